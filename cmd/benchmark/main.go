@@ -0,0 +1,425 @@
+// Drives a GopherStore server with a mix of commands over many connections and reports the throughput
+// and latency percentiles of every command, like redis-benchmark, so performance changes can be
+// compared on the same workload. Every connection sends the same sequence of keys and commands across
+// runs with the same -seed and flags.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Builds the arguments of a command from a key of the keyspace and a value of -size bytes.
+type commandFunc func(key, value []byte, rng *rand.Rand) [][]byte
+
+// Commands that can be part of a mix. Collections get a prefix so they never collide with the strings.
+var commands = map[string]commandFunc{
+	"ping": func(key, value []byte, rng *rand.Rand) [][]byte {
+		return [][]byte{[]byte("PING")}
+	},
+	"get": func(key, value []byte, rng *rand.Rand) [][]byte {
+		return [][]byte{[]byte("GET"), key}
+	},
+	"set": func(key, value []byte, rng *rand.Rand) [][]byte {
+		return [][]byte{[]byte("SET"), key, value}
+	},
+	"lpush": func(key, value []byte, rng *rand.Rand) [][]byte {
+		return [][]byte{[]byte("LPUSH"), prefixed("list:", key), value}
+	},
+	"lrange": func(key, value []byte, rng *rand.Rand) [][]byte {
+		return [][]byte{[]byte("LRANGE"), prefixed("list:", key), []byte("0"), []byte("99")}
+	},
+	"hset": func(key, value []byte, rng *rand.Rand) [][]byte {
+		return [][]byte{[]byte("HSET"), prefixed("hash:", key), field(rng), value}
+	},
+	"hget": func(key, value []byte, rng *rand.Rand) [][]byte {
+		return [][]byte{[]byte("HGET"), prefixed("hash:", key), field(rng)}
+	},
+	"sadd": func(key, value []byte, rng *rand.Rand) [][]byte {
+		return [][]byte{[]byte("SADD"), prefixed("set:", key), field(rng)}
+	},
+	"zadd": func(key, value []byte, rng *rand.Rand) [][]byte {
+		score := strconv.FormatFloat(rng.Float64()*1000, 'f', 2, 64)
+		return [][]byte{[]byte("ZADD"), prefixed("zset:", key), []byte(score), field(rng)}
+	},
+}
+
+func prefixed(prefix string, key []byte) []byte {
+	return append([]byte(prefix), key...)
+}
+
+// Returns one of 100 fields or members, so collections stop growing once every one was written.
+func field(rng *rand.Rand) []byte {
+	return []byte("field:" + strconv.Itoa(rng.IntN(100)))
+}
+
+// Command of a mix with its relative weight.
+type mixEntry struct {
+	name   string
+	weight int
+}
+
+// Parses a mix of comma-separated name:weight pairs, like "get:9,set:1". The weight defaults to 1.
+func parseMix(value string) ([]mixEntry, error) {
+	var mix []mixEntry
+	for part := range strings.SplitSeq(value, ",") {
+		name, weightStr, hasWeight := strings.Cut(strings.TrimSpace(part), ":")
+		name = strings.ToLower(name)
+		if _, ok := commands[name]; !ok {
+			return nil, fmt.Errorf("unknown command %q in mix", name)
+		}
+		if slices.ContainsFunc(mix, func(e mixEntry) bool { return e.name == name }) {
+			return nil, fmt.Errorf("command %q appears twice in mix", name)
+		}
+
+		weight := 1
+		if hasWeight {
+			w, err := strconv.Atoi(weightStr)
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("invalid weight %q for command %q, expected a positive integer", weightStr, name)
+			}
+			weight = w
+		}
+		mix = append(mix, mixEntry{name, weight})
+	}
+
+	return mix, nil
+}
+
+// Picks the index of a command of the mix according to the weights.
+func pickCommand(mix []mixEntry, total int, rng *rand.Rand) int {
+	n := rng.IntN(total)
+	for i, e := range mix {
+		if n < e.weight {
+			return i
+		}
+		n -= e.weight
+	}
+	return len(mix) - 1
+}
+
+// Returns the generator of key numbers in [0, keyspace) for a connection.
+func keyGenerator(distribution string, keyspace uint64, zipfS float64, conn int, rng *rand.Rand) (func() uint64, error) {
+	switch distribution {
+	case "uniform":
+		return func() uint64 { return rng.Uint64N(keyspace) }, nil
+	case "zipf":
+		if zipfS <= 1 {
+			return nil, fmt.Errorf("invalid -zipf %v, expected a value greater than 1", zipfS)
+		}
+		zipf := rand.NewZipf(rng, zipfS, 1, keyspace-1)
+		return zipf.Uint64, nil
+	case "sequential":
+		// Connections start at different offsets so they don't send the same keys in lockstep
+		next := uint64(conn) * 7919 % keyspace
+		return func() uint64 {
+			key := next
+			next = (next + 1) % keyspace
+			return key
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown key distribution %q, expected uniform, zipf or sequential", distribution)
+	}
+}
+
+func keyName(n uint64) []byte {
+	return fmt.Appendf(nil, "key:%012d", n)
+}
+
+type options struct {
+	addr         string
+	password     string
+	clients      int
+	requests     int64
+	duration     time.Duration
+	pipeline     int
+	keyspace     uint64
+	distribution string
+	zipfS        float64
+	size         int
+	seed         uint64
+	mix          []mixEntry
+}
+
+// Latencies and errors of the commands sent by one connection, indexed like the mix.
+type connStats struct {
+	latencies [][]time.Duration
+	errors    []int64
+}
+
+// Opens a connection to the server, authenticating it when a password is set.
+func dial(opts *options) (net.Conn, *bufio.Reader, error) {
+	conn, err := net.Dial("tcp", opts.addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReader(conn)
+
+	if opts.password != "" {
+		if _, err := conn.Write(resp.EncodeBulkStringArray([][]byte{[]byte("AUTH"), []byte(opts.password)})); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		val, err := resp.ReadRESP(r)
+		if err == nil {
+			if respErr, ok := val.(resp.RespErrorValue); ok {
+				err = &resp.RESPError{Msg: respErr.Message}
+			}
+		}
+		if err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+
+	return conn, r, nil
+}
+
+// Sends pipelines of commands of the mix until the requests run out or the deadline passes. The
+// latency of a command is the time from sending its pipeline to reading its reply.
+func runConnection(opts *options, id int, remaining *atomic.Int64, deadline time.Time) (*connStats, error) {
+	conn, r, err := dial(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	rng := rand.New(rand.NewPCG(opts.seed, uint64(id)))
+	nextKey, err := keyGenerator(opts.distribution, opts.keyspace, opts.zipfS, id, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	totalWeight := 0
+	for _, e := range opts.mix {
+		totalWeight += e.weight
+	}
+
+	value := make([]byte, opts.size)
+	for i := range value {
+		value[i] = 'x'
+	}
+
+	stats := &connStats{
+		latencies: make([][]time.Duration, len(opts.mix)),
+		errors:    make([]int64, len(opts.mix)),
+	}
+	batch := make([]int, 0, opts.pipeline)
+	var buf []byte
+	for {
+		n := opts.pipeline
+		if opts.requests > 0 {
+			left := remaining.Add(-int64(n))
+			if left <= -int64(n) {
+				return stats, nil
+			}
+			if left < 0 {
+				n += int(left)
+			}
+		} else if time.Now().After(deadline) {
+			return stats, nil
+		}
+
+		batch, buf = batch[:0], buf[:0]
+		for range n {
+			cmd := pickCommand(opts.mix, totalWeight, rng)
+			batch = append(batch, cmd)
+			buf = resp.AppendBulkStringArray(buf, commands[opts.mix[cmd].name](keyName(nextKey()), value, rng))
+		}
+
+		start := time.Now()
+		if _, err := conn.Write(buf); err != nil {
+			return nil, err
+		}
+		for _, cmd := range batch {
+			val, err := resp.ReadRESP(r)
+			if err != nil {
+				return nil, err
+			}
+			stats.latencies[cmd] = append(stats.latencies[cmd], time.Since(start))
+			if _, ok := val.(resp.RespErrorValue); ok {
+				stats.errors[cmd]++
+			}
+		}
+	}
+}
+
+// Writes a string of -size bytes to every key of the keyspace, so reads hit existing keys.
+func prefill(opts *options) error {
+	conn, r, err := dial(opts)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	value := make([]byte, opts.size)
+	for i := range value {
+		value[i] = 'x'
+	}
+
+	var buf []byte
+	for start := uint64(0); start < opts.keyspace; start += 1000 {
+		end := min(start+1000, opts.keyspace)
+		buf = buf[:0]
+		for n := start; n < end; n++ {
+			buf = resp.AppendBulkStringArray(buf, [][]byte{[]byte("SET"), keyName(n), value})
+		}
+		if _, err := conn.Write(buf); err != nil {
+			return err
+		}
+		for range end - start {
+			val, err := resp.ReadRESP(r)
+			if err != nil {
+				return err
+			}
+			if respErr, ok := val.(resp.RespErrorValue); ok {
+				return &resp.RESPError{Msg: respErr.Message}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the latency below which the fraction p of the sorted latencies fall.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p+0.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+func formatLatency(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+// Prints the throughput and latency percentiles in milliseconds of every command of the mix and of
+// all of them together.
+func report(opts *options, results []*connStats, elapsed time.Duration) {
+	var all []time.Duration
+	var allErrors int64
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "command\trequests\terrors\trps\tp50\tp95\tp99\tp99.9\tmax\t")
+	row := func(name string, latencies []time.Duration, errs int64) {
+		slices.Sort(latencies)
+		rps := float64(len(latencies)) / elapsed.Seconds()
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.0f\t%s\t%s\t%s\t%s\t%s\t\n", name, len(latencies), errs, rps,
+			formatLatency(percentile(latencies, 0.50)), formatLatency(percentile(latencies, 0.95)),
+			formatLatency(percentile(latencies, 0.99)), formatLatency(percentile(latencies, 0.999)),
+			formatLatency(percentile(latencies, 1)))
+	}
+
+	for i, e := range opts.mix {
+		var latencies []time.Duration
+		var errs int64
+		for _, stats := range results {
+			latencies = append(latencies, stats.latencies[i]...)
+			errs += stats.errors[i]
+		}
+		all = append(all, latencies...)
+		allErrors += errs
+		row(e.name, latencies, errs)
+	}
+	if len(opts.mix) > 1 {
+		row("total", all, allErrors)
+	}
+
+	fmt.Printf("%d requests in %s over %d connections, pipeline %d, %s keys over %d\n\n",
+		len(all), elapsed.Round(time.Millisecond), opts.clients, opts.pipeline, opts.distribution, opts.keyspace)
+	w.Flush()
+	fmt.Printf("\nlatencies in milliseconds, throughput %.0f requests per second\n", float64(len(all))/elapsed.Seconds())
+}
+
+// Runs the benchmark over every connection and reports the results.
+func run(opts *options) error {
+	if opts.keyspace == 0 {
+		return errors.New("-keyspace must be at least 1")
+	}
+	if opts.clients <= 0 || opts.pipeline <= 0 || opts.size < 0 {
+		return errors.New("-clients and -pipeline must be at least 1 and -size at least 0")
+	}
+	if opts.requests <= 0 && opts.duration <= 0 {
+		return errors.New("one of -requests or -duration must be positive")
+	}
+	// Checks the distribution before opening connections
+	if _, err := keyGenerator(opts.distribution, opts.keyspace, opts.zipfS, 0, rand.New(rand.NewPCG(0, 0))); err != nil {
+		return err
+	}
+
+	var remaining atomic.Int64
+	remaining.Store(opts.requests)
+	deadline := time.Now().Add(opts.duration)
+
+	results := make([]*connStats, opts.clients)
+	errs := make([]error, opts.clients)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range opts.clients {
+		wg.Go(func() {
+			results[i], errs[i] = runConnection(opts, i, &remaining, deadline)
+		})
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	report(opts, results, elapsed)
+	return nil
+}
+
+func main() {
+	opts := &options{}
+	flag.StringVar(&opts.addr, "addr", "localhost:5001", "Server network address")
+	flag.StringVar(&opts.password, "password", "", "Password sent with AUTH on every connection")
+	flag.IntVar(&opts.clients, "clients", 50, "Number of connections sending commands in parallel")
+	flag.Int64Var(&opts.requests, "requests", 100000, "Total number of commands to send, 0 to run for -duration instead")
+	flag.DurationVar(&opts.duration, "duration", 0, "Send commands for this long, used when -requests is 0")
+	flag.IntVar(&opts.pipeline, "pipeline", 1, "Number of commands sent at once by a connection before reading their replies")
+	flag.Uint64Var(&opts.keyspace, "keyspace", 100000, "Number of distinct keys")
+	flag.StringVar(&opts.distribution, "distribution", "uniform", "Distribution of the keys sent: uniform, zipf or sequential")
+	flag.Float64Var(&opts.zipfS, "zipf", 1.1, "Exponent of the zipf distribution, greater than 1. Higher values make a few keys hotter")
+	flag.IntVar(&opts.size, "size", 64, "Size in bytes of the values written")
+	flag.Uint64Var(&opts.seed, "seed", 1, "Seed of the keys and commands sent, runs with the same seed send the same commands")
+	mix := flag.String("mix", "get:9,set:1", "Comma-separated commands with weights, from ping, get, set, lpush, lrange, hset, hget, sadd and zadd")
+	fill := flag.Bool("prefill", false, "Set every key of the keyspace before the benchmark, so reads hit existing keys")
+	flag.Parse()
+
+	var err error
+	opts.mix, err = parseMix(*mix)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *fill {
+		if err := prefill(opts); err != nil {
+			fmt.Fprintln(os.Stderr, "prefill failed:", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := run(opts); err != nil {
+		fmt.Fprintln(os.Stderr, "benchmark failed:", err)
+		os.Exit(1)
+	}
+}
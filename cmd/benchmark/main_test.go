@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseMix(t *testing.T) {
+	mix, err := parseMix("GET:3, set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []mixEntry{{"get", 3}, {"set", 1}}
+	if !slices.Equal(mix, expected) {
+		t.Errorf("Expected %v, got %v", expected, mix)
+	}
+
+	for _, value := range []string{"get,unknown", "get,set,get", "get:0", "get:-1", "get:x", "get:", ""} {
+		if mix, err := parseMix(value); err == nil {
+			t.Errorf("Expected an error for %q, got %v", value, mix)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	if p := percentile(nil, 0.5); p != 0 {
+		t.Errorf("Expected 0 without latencies, got %v", p)
+	}
+
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i+1) * time.Millisecond
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, time.Millisecond},
+		{0.5, 50 * time.Millisecond},
+		{0.99, 99 * time.Millisecond},
+		{1, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+
+	if p := percentile(sorted[:1], 0.99); p != time.Millisecond {
+		t.Errorf("Expected the only latency, got %v", p)
+	}
+}
+
+func TestKeyGenerator(t *testing.T) {
+	for _, distribution := range []string{"uniform", "zipf", "sequential"} {
+		for _, keyspace := range []uint64{1, 10} {
+			next, err := keyGenerator(distribution, keyspace, 1.1, 3, rand.New(rand.NewPCG(1, 2)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range 1000 {
+				if key := next(); key >= keyspace {
+					t.Fatalf("Expected %s keys below %d, got %d", distribution, keyspace, key)
+				}
+			}
+		}
+	}
+
+	if _, err := keyGenerator("zipf", 10, 1, 0, rand.New(rand.NewPCG(1, 2))); err == nil {
+		t.Error("Expected an error for a zipf exponent of 1")
+	}
+	if _, err := keyGenerator("gaussian", 10, 1.1, 0, rand.New(rand.NewPCG(1, 2))); err == nil {
+		t.Error("Expected an error for an unknown distribution")
+	}
+}
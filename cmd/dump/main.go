@@ -0,0 +1,151 @@
+// Exports the keys of a GopherStore server to a JSON file and imports them back, so datasets can be
+// inspected, diffed and used to seed tests. The file holds one JSON object per key and per line, see
+// the EXPORT command. Binary backups, loadable with -dbfilename, are taken with the SYNC command.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Sends a command to the server and returns its reply.
+func request(addr string, args ...[]byte) (resp.RespValue, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(resp.EncodeBulkStringArray(args)); err != nil {
+		return nil, err
+	}
+
+	val, err := resp.ReadRESP(bufio.NewReader(conn))
+	if err != nil {
+		return nil, err
+	}
+	if respErr, ok := val.(resp.RespErrorValue); ok {
+		return nil, &resp.RESPError{Msg: respErr.Message}
+	}
+
+	return val, nil
+}
+
+// Writes the keys matching pattern to path, or to stdout when path is "-".
+func export(addr, path, pattern string) error {
+	args := [][]byte{[]byte("EXPORT")}
+	if pattern != "" {
+		args = append(args, []byte("MATCH"), []byte(pattern))
+	}
+
+	val, err := request(addr, args...)
+	if err != nil {
+		return err
+	}
+	data, ok := val.(resp.RespBulkString)
+	if !ok {
+		return fmt.Errorf("unexpected reply to EXPORT: %v", val)
+	}
+
+	if path == "-" {
+		_, err = os.Stdout.Write(data.Value)
+		return err
+	}
+	return os.WriteFile(path, data.Value, 0o644)
+}
+
+// Writes a binary snapshot of every key to path, or to stdout when path is "-".
+func backup(addr, path string) (int, error) {
+	val, err := request(addr, []byte("SYNC"))
+	if err != nil {
+		return 0, err
+	}
+	data, ok := val.(resp.RespBulkString)
+	if !ok {
+		return 0, fmt.Errorf("unexpected reply to SYNC: %v", val)
+	}
+
+	if path == "-" {
+		_, err = os.Stdout.Write(data.Value)
+	} else {
+		err = os.WriteFile(path, data.Value, 0o644)
+	}
+	return len(data.Value), err
+}
+
+// Imports the keys of path, or of stdin when path is "-". Returns the number of keys imported.
+func importFile(addr, path string) (int64, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	val, err := request(addr, []byte("IMPORT"), data)
+	if err != nil {
+		return 0, err
+	}
+	imported, ok := val.(resp.RespInteger)
+	if !ok {
+		return 0, fmt.Errorf("unexpected reply to IMPORT: %v", val)
+	}
+
+	return imported.Value, nil
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:5001", "Server network address")
+	out := flag.String("out", "", "Export the keys to this file, - for stdout")
+	match := flag.String("match", "", "Only export keys matching this glob pattern")
+	in := flag.String("import", "", "Import the keys of this file, - for stdin, replacing existing keys with the same names")
+	backupPath := flag.String("backup", "", "Write a binary snapshot of every key to this file, - for stdout")
+	flag.Parse()
+
+	modes := 0
+	for _, path := range []string{*out, *in, *backupPath} {
+		if path != "" {
+			modes++
+		}
+	}
+	if modes != 1 {
+		fmt.Fprintln(os.Stderr, "exactly one of -out, -import or -backup is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *backupPath != "" {
+		size, err := backup(*addr, *backupPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "backup failed:", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "wrote %d bytes\n", size)
+		return
+	}
+
+	if *out != "" {
+		if err := export(*addr, *out, *match); err != nil {
+			fmt.Fprintln(os.Stderr, "export failed:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	imported, err := importFile(*addr, *in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "import failed:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "imported %d keys\n", imported)
+}
@@ -0,0 +1,87 @@
+// Monitors a GopherStore primary and its replicas, promoting a replica when the primary fails. Run
+// several sentinels with -peers pointing at each other so failovers need a quorum and a single one
+// of them performs it. Clients ask a sentinel for the current primary with
+// SENTINEL GET-PRIMARY-ADDR-BY-NAME name.
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/sentinel"
+)
+
+func main() {
+	addr := flag.String("addr", "0.0.0.0:26379", "Sentinel network address")
+	name := flag.String("name", "gopherstore", "Name clients use to ask for the address of the primary")
+	primary := flag.String("primary", "", "Address of the primary to monitor, as host:port")
+	peers := flag.String("peers", "", "Comma-separated addresses of the other sentinels monitoring the primary")
+	quorum := flag.Int("quorum", 1, "Sentinels that must agree the primary is down before failing over")
+	downAfter := flag.Duration("down-after", 5*time.Second, "Time without a reply before the primary is considered down")
+	checkInterval := flag.Duration("check-interval", time.Second, "Time between health checks of the primary")
+	failoverTimeout := flag.Duration("failover-timeout", 30*time.Second, "Wait before another failover is attempted after one started or failed")
+	flag.Parse()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	if _, _, err := net.SplitHostPort(*primary); err != nil {
+		logger.Error("invalid primary address, expected host:port", "primary", *primary, "error", err)
+		os.Exit(1)
+	}
+
+	var peerAddrs []string
+	if *peers != "" {
+		for peer := range strings.SplitSeq(*peers, ",") {
+			peer = strings.TrimSpace(peer)
+			if _, _, err := net.SplitHostPort(peer); err != nil {
+				logger.Error("invalid peer address, expected host:port", "peer", peer, "error", err)
+				os.Exit(1)
+			}
+			peerAddrs = append(peerAddrs, peer)
+		}
+	}
+
+	if *quorum <= 0 || *quorum > len(peerAddrs)+1 {
+		logger.Error("invalid quorum, must be between 1 and the number of sentinels", "quorum", *quorum, "sentinels", len(peerAddrs)+1)
+		os.Exit(1)
+	}
+
+	s := sentinel.New(sentinel.Config{
+		Name:            *name,
+		Primary:         *primary,
+		Peers:           peerAddrs,
+		Quorum:          *quorum,
+		DownAfter:       *downAfter,
+		CheckInterval:   *checkInterval,
+		FailoverTimeout: *failoverTimeout,
+	}, logger)
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		logger.Error("failed to listen", "addr", *addr, "error", err)
+		os.Exit(1)
+	}
+
+	stop := make(chan struct{})
+	go s.Run(stop)
+	go func() {
+		if err := s.Serve(ln); err != nil {
+			logger.Error("failed to accept connections", "error", err)
+		}
+	}()
+	logger.Info("sentinel started", "addr", *addr, "primary", *primary, "quorum", *quorum)
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	<-c
+
+	logger.Info("Shutting down sentinel...")
+	close(stop)
+	ln.Close()
+}
@@ -1,25 +1,523 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log/slog"
+	"net"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/CDavidSV/GopherStore/internal/admin"
+	"github.com/CDavidSV/GopherStore/internal/config"
+	"github.com/CDavidSV/GopherStore/internal/discovery"
+	"github.com/CDavidSV/GopherStore/internal/encryption"
+	"github.com/CDavidSV/GopherStore/internal/metrics"
+	"github.com/CDavidSV/GopherStore/internal/resp"
 	"github.com/CDavidSV/GopherStore/internal/server"
+	"github.com/CDavidSV/GopherStore/internal/storage"
+	"github.com/CDavidSV/GopherStore/internal/systemd"
+	"github.com/CDavidSV/GopherStore/internal/util"
 )
 
+// Returns the address to advertise to service discovery, replacing an unspecified host with the machine hostname.
+func advertiseAddress(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+
+	if host == "" || net.ParseIP(host).IsUnspecified() {
+		host, err = os.Hostname()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+// Creates the service discovery registrar for the given backend.
+func newRegistrar(backend, addr string) (discovery.Registrar, error) {
+	switch backend {
+	case "consul":
+		return discovery.NewConsulRegistrar(addr), nil
+	case "etcd":
+		return discovery.NewEtcdRegistrar(addr, "/gopherstore/services"), nil
+	default:
+		return nil, fmt.Errorf("unknown service discovery backend %q, expected consul or etcd", backend)
+	}
+}
+
+// Creates the logger writing to stdout at the given level, as text or JSON. The level can be
+// changed at runtime through the returned variable.
+func newLogger(level, format string) (*slog.Logger, *slog.LevelVar, error) {
+	lvl := new(slog.LevelVar)
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, nil, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stdout, opts)), lvl, nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stdout, opts)), lvl, nil
+	default:
+		return nil, nil, fmt.Errorf("invalid log format %q, expected text or json", format)
+	}
+}
+
+// Loads the keys encrypting persistence files from a key file or an environment variable.
+func loadEncryptionKeys(path, env string) (*encryption.Keyring, error) {
+	if path != "" && env != "" {
+		return nil, fmt.Errorf("-encryption-key-file and -encryption-key-env cannot be used together")
+	}
+	if path != "" {
+		return encryption.LoadKeyFile(path)
+	}
+	return encryption.LoadKeyEnv(env)
+}
+
 func main() {
+	flag.String(config.FileFlag, "", "Path of a YAML configuration file with the settings of these flags, overridden by GOPHERSTORE_* environment variables and by the flags given")
 	addr := flag.String("addr", "0.0.0.0:5001", "Server network address")
+	ttlPolicy := flag.String("ttl-policy", "", "Default TTLs by key pattern for keys written without an expiration, e.g. \"session:* 30m feed:* 2h\"")
+	defaultTTL := flag.Duration("default-ttl", 0, "TTL of every new key written without an expiration or a matching -ttl-policy rule (default: no expiration)")
+	ttlJitter := flag.Float64("ttl-jitter", 0, "Maximum random jitter added to EX and PX expirations as a fraction of the TTL, e.g. 0.05 for ±5%")
+	expireBudget := flag.Duration("expire-budget", 25*time.Millisecond, "Maximum time spent removing expired keys in each cleanup cycle")
+	expireInterval := flag.Duration("expire-interval", 250*time.Millisecond, "Time between cleanup cycles removing expired keys")
+	lazyFreeThreshold := flag.Int64("lazyfree-threshold", 0, "Release deleted or overwritten values with more elements than this on a background goroutine (default: disabled)")
+	maxMemory := flag.String("maxmemory", "0", "Limit of the approximate memory used by keys, e.g. 100mb (default: no limit)")
+	maxMemoryPolicy := flag.String("maxmemory-policy", "noeviction", "Keys evicted once -maxmemory is exceeded: noeviction, allkeys-lru, volatile-lru, allkeys-lfu or volatile-lfu")
+	keyspaceEvents := flag.String("notify-keyspace-events", "", "Keyspace events published over pub/sub, using Redis flags, e.g. \"KEA\" for all (default: disabled)")
+	appendOnly := flag.Bool("appendonly", false, "Log every write to an append-only file, replayed at startup to restore the data")
+	appendFilename := flag.String("appendfilename", "appendonly.aof", "Path of the append-only file")
+	appendFsync := flag.String("appendfsync", "everysec", "When the append-only file is synced to disk: always, everysec or no")
+	dbFilename := flag.String("dbfilename", "", "Path of the snapshot loaded at startup and written at shutdown (default: disabled)")
+	encryptionKeyFile := flag.String("encryption-key-file", "", "File of id:base64key lines encrypting the append-only file and snapshots with AES-GCM, the last key encrypting new data (default: no encryption)")
+	encryptionKeyEnv := flag.String("encryption-key-env", "", "Environment variable holding comma-separated id:base64key definitions, instead of -encryption-key-file")
+	saveRules := flag.String("save", "3600 1 300 100 60 10000", "Automatic snapshot rules as pairs of seconds and changes, e.g. \"300 100\" to save after 300s if 100 keys changed (\"\" to disable)")
+	storageBackend := flag.String("storage", "", "Disk storage backend holding every key, keeping only the hot keys in memory (bolt, default: memory only)")
+	storagePath := flag.String("storage-path", "gopherstore.db", "Path of the storage backend data")
+	hotKeys := flag.Int("hot-keys", 1000000, "Maximum number of keys kept in memory with a storage backend, the least recently used are evicted")
+	writeBehind := flag.String("write-behind", "", "Store receiving a copy of every string write and delete in the background (bolt, default: disabled)")
+	writeBehindPath := flag.String("write-behind-path", "writebehind.db", "Path of the write-behind store data")
+	writeBehindBatch := flag.Int("write-behind-batch", 500, "Maximum keys written to the write-behind store at once")
+	writeBehindInterval := flag.Duration("write-behind-interval", time.Second, "Time between writes to the write-behind store")
+	writeBehindRetries := flag.Int("write-behind-retries", 5, "Retries of a failed write to the write-behind store before waiting for the next interval")
+	replicaOf := flag.String("replicaof", "", "Replicate the primary at this host:port, replacing the local data with its own (default: run as a primary)")
+	replicaReadOnly := flag.Bool("replica-read-only", true, "Reject writes from clients while replicating a primary")
+	requirePass := flag.String("requirepass", "", "Password clients must send with AUTH or HELLO before running commands (default: no authentication)")
+	primaryAuth := flag.String("masterauth", "", "Password sent with AUTH to the primary when replicating one that requires it")
+	functionPlugins := flag.String("functions", "", "Comma-separated paths of Go plugins registering server-side functions callable with FCALL")
+	discoveryBackend := flag.String("discovery", "", "Service discovery backend to register with (consul or etcd)")
+	discoveryAddr := flag.String("discovery-addr", "localhost:8500", "Service discovery agent or endpoint address")
+	discoveryTTL := flag.Duration("discovery-ttl", 15*time.Second, "Health TTL for the service discovery registration")
+	advertiseAddr := flag.String("advertise-addr", "", "Address advertised to service discovery and cluster nodes (default: -addr with the hostname for unspecified hosts)")
+	clusterEnabled := flag.Bool("cluster-enabled", false, "Enable cluster mode, assembled with the CLUSTER MEET and CLUSTER ADDSLOTS commands")
+	maxBulkLen := flag.Int("proto-max-bulk-len", resp.DefaultLimits.MaxBulkLength, "Maximum length in bytes of a bulk string sent by a client (0 for no limit)")
+	maxArrayLen := flag.Int("proto-max-array-len", resp.DefaultLimits.MaxArrayLength, "Maximum number of elements of an array sent by a client, e.g. the arguments of a command (0 for no limit)")
+	maxDepth := flag.Int("proto-max-depth", resp.DefaultLimits.MaxDepth, "Maximum nesting of arrays sent by a client (0 for no limit)")
+	tcpKeepAlive := flag.Duration("tcp-keepalive", server.DefaultTCPKeepAlive, "Period of the TCP keepalive probes detecting dead clients (0 to disable)")
+	readTimeout := flag.Duration("read-timeout", 0, "Disconnect clients idle for this long, except blocked clients, subscribers and replicas (default: disabled)")
+	writeTimeout := flag.Duration("write-timeout", 0, "Disconnect clients that do not accept a reply for this long (default: disabled)")
+	ioThreads := flag.Int("io-threads", 0, "Threads executing commands, counting the server loop: 1 runs every command on the loop, more run read commands on up to io-threads - 1 workers in parallel (default: one per CPU)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", server.DefaultShutdownTimeout, "Time spent running the commands already received and sending their replies when shutting down, before dropping the remaining clients")
+	latencyThreshold := flag.Int64("latency-monitor-threshold", 0, "Record commands, expiration cycles and append-only file writes taking at least this many milliseconds, inspected with LATENCY (default: disabled)")
+	adminAddr := flag.String("admin-addr", "", "Address of the admin HTTP listener serving pprof profiles and runtime statistics, e.g. 127.0.0.1:6060 (default: disabled)")
+	metricsInterval := flag.Duration("metrics-interval", 10*time.Second, "Time between reports of the metrics to -expvar and -statsd-addr")
+	expvarMetrics := flag.Bool("expvar", false, "Publish the metrics as the gopherstore expvar variable, served under /debug/vars by -admin-addr")
+	statsdAddr := flag.String("statsd-addr", "", "Address of a StatsD server or Datadog agent receiving the metrics over UDP, e.g. 127.0.0.1:8125 (default: disabled)")
+	statsdPrefix := flag.String("statsd-prefix", "gopherstore.", "Prefix of the metric names sent to -statsd-addr")
+	statsdTags := flag.String("statsd-tags", "", "Comma-separated tags sent with the metrics in the DogStatsD format, e.g. \"env:prod,region:eu\" (default: none)")
+	renameCommands := flag.String("rename-command", "", "Comma-separated commands renamed for clients as OLD=NEW pairs, OLD= disabling the command, e.g. \"CONFIG=ADMINCONFIG,DEBUG=\"")
+	commandAllowlist := flag.String("command-allowlist", "", "Comma-separated commands clients may run, by their real names (default: all)")
+	commandDenylist := flag.String("command-denylist", "", "Comma-separated commands clients may not run, by their real names (default: none)")
+	chaos := flag.Bool("chaos", false, "Enable DEBUG commands (fault injection, sleeps, entry internals) for testing")
+	logLevel := flag.String("log-level", "debug", "Minimum level of the messages logged: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "Format of the logs: text or json")
 	flag.Parse()
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	if err := config.Apply(flag.CommandLine, os.Environ()); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:", err)
+		os.Exit(2)
+	}
+
+	logger, logLevelVar, err := newLogger(*logLevel, *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	policy, err := server.ParseExpirationPolicy(*ttlPolicy)
+	if err != nil {
+		logger.Error("invalid expiration policy", "error", err)
+		os.Exit(1)
+	}
+
+	if *defaultTTL < 0 {
+		logger.Error("invalid default TTL, must not be negative", "ttl", *defaultTTL)
+		os.Exit(1)
+	}
+
+	if *ttlJitter < 0 || *ttlJitter >= 1 {
+		logger.Error("invalid TTL jitter, must be at least 0 and less than 1", "jitter", *ttlJitter)
+		os.Exit(1)
+	}
+
+	if *expireBudget <= 0 {
+		logger.Error("invalid expire budget, must be positive", "budget", *expireBudget)
+		os.Exit(1)
+	}
+
+	if *expireInterval < time.Millisecond {
+		logger.Error("invalid expire interval, must be at least 1ms", "interval", *expireInterval)
+		os.Exit(1)
+	}
+
+	if *lazyFreeThreshold < 0 {
+		logger.Error("invalid lazy free threshold, must not be negative", "threshold", *lazyFreeThreshold)
+		os.Exit(1)
+	}
+
+	events, err := server.ParseKeyspaceEvents(*keyspaceEvents)
+	if err != nil {
+		logger.Error("invalid keyspace events", "error", err)
+		os.Exit(1)
+	}
+
+	memoryLimit, ok := util.ParseMemorySize([]byte(*maxMemory))
+	if !ok {
+		logger.Error("invalid maxmemory, expected a number of bytes with an optional unit such as 100mb", "maxmemory", *maxMemory)
+		os.Exit(1)
+	}
+	evictionPolicy, err := server.ParseEvictionPolicy(*maxMemoryPolicy)
+	if err != nil {
+		logger.Error("invalid maxmemory policy", "error", err)
+		os.Exit(1)
+	}
+
+	fsyncPolicy, err := server.ParseFsyncPolicy(*appendFsync)
+	if err != nil {
+		logger.Error("invalid append-only fsync policy", "error", err)
+		os.Exit(1)
+	}
+
+	rules, err := server.ParseSaveRules(*saveRules)
+	if err != nil {
+		logger.Error("invalid save rules", "error", err)
+		os.Exit(1)
+	}
+
+	if *storageBackend != "" && (*appendOnly || *dbFilename != "") {
+		logger.Error("a storage backend already persists every key and cannot be combined with -appendonly or -dbfilename")
+		os.Exit(1)
+	}
+
+	if *hotKeys <= 0 {
+		logger.Error("invalid hot keys limit, must be positive", "keys", *hotKeys)
+		os.Exit(1)
+	}
+
+	if *latencyThreshold < 0 {
+		logger.Error("invalid latency monitor threshold, must not be negative", "threshold", *latencyThreshold)
+		os.Exit(1)
+	}
+
+	if *maxBulkLen < 0 || *maxArrayLen < 0 || *maxDepth < 0 {
+		logger.Error("invalid request limits, must not be negative")
+		os.Exit(1)
+	}
+
+	if *ioThreads < 0 {
+		logger.Error("invalid io threads, must not be negative", "threads", *ioThreads)
+		os.Exit(1)
+	}
+
+	if *tcpKeepAlive < 0 || *readTimeout < 0 || *writeTimeout < 0 || *shutdownTimeout < 0 {
+		logger.Error("invalid connection timeouts, must not be negative")
+		os.Exit(1)
+	}
+
+	var backend storage.Backend
+	if *storageBackend != "" {
+		backend, err = storage.Open(*storageBackend, *storagePath)
+		if err != nil {
+			logger.Error("failed to open storage backend", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if *writeBehindBatch <= 0 || *writeBehindInterval <= 0 || *writeBehindRetries < 0 {
+		logger.Error("invalid write-behind settings, the batch size and interval must be positive and the retries not negative")
+		os.Exit(1)
+	}
+
+	var sink storage.Backend
+	if *writeBehind != "" {
+		sink, err = storage.Open(*writeBehind, *writeBehindPath)
+		if err != nil {
+			logger.Error("failed to open write-behind store", "error", err)
+			os.Exit(1)
+		}
+	}
 
 	storage := server.NewInMemoryKVStore()
+	storage.SetCleanupBudget(*expireBudget)
+	storage.SetCleanupInterval(*expireInterval)
+	storage.SetDefaultTTL(*defaultTTL)
+	storage.SetLazyFreeThreshold(*lazyFreeThreshold)
+	storage.SetEvictionPolicy(evictionPolicy)
+	storage.SetMaxMemory(memoryLimit)
+	if backend != nil {
+		storage.SetBackend(backend, *hotKeys)
+		storage.OnBackendError(func(err error) {
+			logger.Error("storage backend error", "error", err)
+		})
+	}
+	if sink != nil {
+		storage.SetWriteBehind(sink, server.WriteBehindOptions{
+			BatchSize:  *writeBehindBatch,
+			Interval:   *writeBehindInterval,
+			MaxRetries: *writeBehindRetries,
+		})
+		storage.OnWriteBehindError(func(err error) {
+			logger.Error("write-behind error", "error", err)
+		})
+	}
 	server := server.NewServer(logger, *addr, storage)
+	// Under systemd socket activation the socket unit decides the address, -addr is ignored
+	listeners, err := systemd.Listeners()
+	if err != nil {
+		logger.Error("failed to use the sockets passed by systemd", "error", err)
+		os.Exit(1)
+	}
+	if len(listeners) > 0 {
+		for _, ln := range listeners[1:] {
+			logger.Warn("ignoring extra socket passed by systemd", "addr", ln.Addr().String())
+			ln.Close()
+		}
+		server.SetListener(listeners[0])
+	}
+	server.SetExpirationPolicy(policy)
+	server.SetTTLJitter(*ttlJitter)
+	server.SetLatencyMonitorThreshold(time.Duration(*latencyThreshold) * time.Millisecond)
+	server.SetRequestLimits(resp.Limits{
+		MaxBulkLength:  *maxBulkLen,
+		MaxArrayLength: *maxArrayLen,
+		MaxDepth:       *maxDepth,
+	})
+	server.SetTCPKeepAlive(*tcpKeepAlive)
+	server.SetReadTimeout(*readTimeout)
+	server.SetWriteTimeout(*writeTimeout)
+	server.SetShutdownTimeout(*shutdownTimeout)
+	server.SetIOThreads(*ioThreads)
+	server.SetLogLevel(logLevelVar)
+	if path := config.FilePath(flag.CommandLine, os.Environ()); path != "" {
+		server.SetConfigFile(path, config.Overridden(flag.CommandLine, os.Environ()))
+	}
+	if err := server.SetKeyspaceEvents(events); err != nil {
+		logger.Error("failed to enable keyspace events", "error", err)
+		os.Exit(1)
+	}
+	if *encryptionKeyFile != "" || *encryptionKeyEnv != "" {
+		keys, err := loadEncryptionKeys(*encryptionKeyFile, *encryptionKeyEnv)
+		if err != nil {
+			logger.Error("failed to load encryption keys", "error", err)
+			os.Exit(1)
+		}
+		server.SetEncryptionKeys(keys)
+	}
+	if *appendOnly {
+		server.SetAppendOnly(*appendFilename, fsyncPolicy)
+	}
+	if *dbFilename != "" {
+		if err := server.SetSnapshotFile(*dbFilename); err != nil {
+			logger.Error("failed to enable snapshots", "error", err)
+			os.Exit(1)
+		}
+		server.SetSaveRules(rules)
+	}
+
+	if *replicaOf != "" {
+		if _, _, err := net.SplitHostPort(*replicaOf); err != nil {
+			logger.Error("invalid primary address", "error", err)
+			os.Exit(1)
+		}
+		server.SetReplicaOf(*replicaOf)
+	}
+	server.SetReplicaReadOnly(*replicaReadOnly)
+	server.SetRequirePass(*requirePass)
+	server.SetPrimaryAuth(*primaryAuth)
+
+	if *functionPlugins != "" {
+		for path := range strings.SplitSeq(*functionPlugins, ",") {
+			if err := server.Functions().LoadPlugin(strings.TrimSpace(path)); err != nil {
+				logger.Error("failed to load functions", "error", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if *renameCommands != "" {
+		renames := make(map[string]string)
+		for pair := range strings.SplitSeq(*renameCommands, ",") {
+			name, newName, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				logger.Error("invalid command rename, expected OLD=NEW", "rename", pair)
+				os.Exit(1)
+			}
+			renames[name] = newName
+		}
+		if err := server.RenameCommands(renames); err != nil {
+			logger.Error("failed to rename commands", "error", err)
+			os.Exit(1)
+		}
+	}
+	if *commandAllowlist != "" {
+		if err := server.SetCommandAllowlist(strings.Split(*commandAllowlist, ",")); err != nil {
+			logger.Error("invalid command allowlist", "error", err)
+			os.Exit(1)
+		}
+	}
+	if *commandDenylist != "" {
+		if err := server.SetCommandDenylist(strings.Split(*commandDenylist, ",")); err != nil {
+			logger.Error("invalid command denylist", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if *chaos {
+		logger.Warn("fault injection enabled, do not use in production")
+		server.EnableFaultInjection()
+	}
+
+	advertised := *advertiseAddr
+	if advertised == "" && (*discoveryBackend != "" || *clusterEnabled) {
+		advertised, err = advertiseAddress(*addr)
+		if err != nil {
+			logger.Error("failed to determine advertised address", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if *clusterEnabled {
+		server.EnableCluster(advertised)
+	}
+
+	if *discoveryBackend != "" {
+		registrar, err := newRegistrar(*discoveryBackend, *discoveryAddr)
+		if err != nil {
+			logger.Error("invalid service discovery configuration", "error", err)
+			os.Exit(1)
+		}
+
+		instance := discovery.Instance{
+			ID:      "gopherstore-" + advertised,
+			Name:    "gopherstore",
+			Address: advertised,
+		}
+
+		// Register once the server accepts connections and deregister before clients are dropped
+		var registration *discovery.Registration
+		server.OnStart(func() error {
+			reg, err := discovery.Register(registrar, instance, *discoveryTTL, logger)
+			registration = reg
+			return err
+		})
+		server.OnShutdownBegin(func() error {
+			if registration == nil {
+				return nil
+			}
+			return registration.Stop()
+		})
+	}
+
+	if *adminAddr != "" {
+		// Profiles stay available while the server drains, the listener stops once it is done
+		var listener *admin.Listener
+		server.OnStart(func() error {
+			l, err := admin.Listen(*adminAddr, logger)
+			listener = l
+			return err
+		})
+		server.OnShutdownComplete(func() error {
+			if listener == nil {
+				return nil
+			}
+			return listener.Stop()
+		})
+	}
+
+	var sinks []metrics.Sink
+	if *expvarMetrics {
+		sinks = append(sinks, metrics.NewExpvarSink("gopherstore"))
+	}
+	if *statsdAddr != "" {
+		var tags []string
+		if *statsdTags != "" {
+			tags = strings.Split(*statsdTags, ",")
+		}
+		sink, err := metrics.NewStatsDSink(*statsdAddr, *statsdPrefix, tags)
+		if err != nil {
+			logger.Error("failed to create StatsD sink", "error", err)
+			os.Exit(1)
+		}
+		sinks = append(sinks, sink)
+	}
+	if len(sinks) > 0 {
+		if *metricsInterval <= 0 {
+			logger.Error("invalid metrics interval, must be positive", "interval", *metricsInterval)
+			os.Exit(1)
+		}
+		reporter := metrics.NewReporter(server.Metrics, *metricsInterval, logger, sinks...)
+		server.OnStart(func() error {
+			reporter.Start()
+			return nil
+		})
+		server.OnShutdownComplete(func() error {
+			reporter.Stop()
+			return nil
+		})
+	}
 
 	// Start server
-	err := server.Start()
-	if err != nil {
+	if err := server.ListenAndServe(); err != nil {
 		logger.Error("Server failed to start", "error", err)
+	} else {
+		// Tell systemd the server is ready when running as a Type=notify service
+		if _, err := systemd.Notify(systemd.Ready); err != nil {
+			logger.Error("failed to notify systemd", "error", err)
+		}
+
+		// Wait for interrupt signal to stop the server, reloading the configuration file on SIGHUP
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		for sig := <-c; sig == syscall.SIGHUP; sig = <-c {
+			if err := server.ReloadConfig(); err != nil {
+				logger.Error("failed to reload configuration", "error", err)
+			}
+		}
+
+		logger.Info("Shutting down server...")
+		if _, err := systemd.Notify(systemd.Stopping); err != nil {
+			logger.Error("failed to notify systemd", "error", err)
+		}
+		if err := server.Shutdown(context.Background()); err != nil {
+			logger.Error("failed to shut down server", "error", err)
+		}
+		logger.Info("Server stopped")
+	}
+
+	// The store made the remaining writes when the server stopped
+	if sink != nil {
+		if err := sink.Close(); err != nil {
+			logger.Error("failed to close write-behind store", "error", err)
+		}
 	}
 }
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Time allowed for a single request to a cache server or sentinel.
+const requestTimeout = 5 * time.Second
+
+// Cache servers the gateway sends commands to. Writes go to the primary and reads to the replicas
+// when enabled. The primary is looked up again when it cannot be reached or rejects writes as a
+// replica, and every refresh interval, so the gateway follows failovers.
+type backend struct {
+	sentinels        []string // Asked for the primary when set, instead of the servers themselves
+	name             string   // Name of the primary known to the sentinels
+	readFromReplicas bool
+
+	refreshMu sync.Mutex // Serializes lookups of the primary
+	mu        sync.RWMutex
+	primary   string
+	replicas  []string
+	next      int // Replica serving the next read
+}
+
+func (b *backend) primaryAddr() string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.primary
+}
+
+// Returns the replica serving the next read, in turns, or false when reads go to the primary.
+func (b *backend) replicaAddr() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.readFromReplicas || len(b.replicas) == 0 {
+		return "", false
+	}
+	b.next = (b.next + 1) % len(b.replicas)
+	return b.replicas[b.next], true
+}
+
+// Looks up the primary again every interval, to follow failovers before a request fails.
+func (b *backend) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		b.refresh("")
+	}
+}
+
+// Looks up the primary and its replicas. When failed is set, the lookup is skipped if the primary
+// already changed from failed, since a concurrent request found the new one. Returns true if the
+// primary changed.
+func (b *backend) refresh(failed string) bool {
+	b.refreshMu.Lock()
+	defer b.refreshMu.Unlock()
+
+	current := b.primaryAddr()
+	if failed != "" && current != failed {
+		return true
+	}
+
+	var primary string
+	var replicas []string
+	var err error
+	if len(b.sentinels) > 0 {
+		primary, replicas, err = b.askSentinels()
+	} else {
+		b.mu.RLock()
+		candidates := append([]string{current}, b.replicas...)
+		b.mu.RUnlock()
+		primary, replicas, err = findPrimary(candidates)
+	}
+	if err != nil {
+		slog.Warn("failed to look up the primary", "error", err)
+		return false
+	}
+
+	b.mu.Lock()
+	b.primary = primary
+	if replicas != nil {
+		b.replicas = replicas
+	}
+	b.mu.Unlock()
+
+	if primary != current {
+		slog.Info("switched to a new primary", "primary", primary, "previous", current)
+		return true
+	}
+	return false
+}
+
+// Asks the sentinels for the address of the primary, then the primary for its replicas.
+func (b *backend) askSentinels() (string, []string, error) {
+	for _, sentinel := range b.sentinels {
+		val, err := request(sentinel, "SENTINEL", "GET-PRIMARY-ADDR-BY-NAME", b.name)
+		if err != nil {
+			continue
+		}
+		arr, ok := val.(resp.RespArray)
+		if !ok || len(arr.Elements) != 2 {
+			continue
+		}
+		host, _ := arr.Elements[0].(resp.RespBulkString)
+		port, _ := arr.Elements[1].(resp.RespBulkString)
+		primary := net.JoinHostPort(string(host.Value), string(port.Value))
+
+		r, err := queryRole(primary)
+		if err != nil || !r.primary {
+			// The sentinels fail over to a replica soon
+			return primary, nil, nil
+		}
+		return primary, r.replicas, nil
+	}
+	return "", nil, fmt.Errorf("no sentinel knows the primary %q", b.name)
+}
+
+// Asks the servers for their role, in order, and returns the first primary found and its replicas.
+// Replicas point to their primary, so the primary is found even when it is not a candidate.
+func findPrimary(candidates []string) (string, []string, error) {
+	for i := 0; i < len(candidates); i++ {
+		r, err := queryRole(candidates[i])
+		if err != nil {
+			continue
+		}
+		if r.primary {
+			return candidates[i], r.replicas, nil
+		}
+		if r.of != "" && !slices.Contains(candidates, r.of) {
+			candidates = append(candidates, r.of)
+		}
+	}
+	return "", nil, fmt.Errorf("no primary found among %s", strings.Join(candidates, ", "))
+}
+
+// Role of a server as reported by the ROLE command.
+type role struct {
+	primary  bool
+	replicas []string // host:port of the replicas of a primary
+	of       string   // host:port of the primary of a replica
+}
+
+// Asks a server for its role.
+func queryRole(addr string) (role, error) {
+	val, err := request(addr, "ROLE")
+	if err != nil {
+		return role{}, err
+	}
+
+	arr, ok := val.(resp.RespArray)
+	if !ok || len(arr.Elements) < 3 {
+		return role{}, fmt.Errorf("unexpected reply to ROLE: %v", val)
+	}
+	kind, _ := arr.Elements[0].(resp.RespBulkString)
+
+	switch string(kind.Value) {
+	case "master":
+		r := role{primary: true}
+		replicas, _ := arr.Elements[2].(resp.RespArray)
+		for _, elem := range replicas.Elements {
+			fields, ok := elem.(resp.RespArray)
+			if !ok || len(fields.Elements) < 2 {
+				continue
+			}
+			host, _ := fields.Elements[0].(resp.RespBulkString)
+			port, _ := fields.Elements[1].(resp.RespBulkString)
+			r.replicas = append(r.replicas, net.JoinHostPort(string(host.Value), string(port.Value)))
+		}
+		return r, nil
+	case "slave":
+		host, _ := arr.Elements[1].(resp.RespBulkString)
+		port, _ := arr.Elements[2].(resp.RespInteger)
+		return role{of: net.JoinHostPort(string(host.Value), fmt.Sprint(port.Value))}, nil
+	default:
+		return role{}, fmt.Errorf("unexpected reply to ROLE: %v", val)
+	}
+}
+
+// Sends a command to a server or sentinel and returns its reply, error replies included.
+func sendCommand(addr string, respString string) (resp.RespValue, error) {
+	conn, err := net.DialTimeout("tcp", addr, requestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	if _, err := conn.Write([]byte(respString)); err != nil {
+		return nil, err
+	}
+
+	// Wait for the reply before closing the connection
+	return resp.ReadRESP(bufio.NewReader(conn))
+}
+
+// Sends a command made of args, returning error replies as errors.
+func request(addr string, args ...string) (resp.RespValue, error) {
+	encoded := make([][]byte, len(args))
+	for i, arg := range args {
+		encoded[i] = []byte(arg)
+	}
+
+	val, err := sendCommand(addr, string(resp.EncodeBulkStringArray(encoded)))
+	if err != nil {
+		return nil, err
+	}
+	if respErr, ok := val.(resp.RespErrorValue); ok {
+		return nil, &resp.RESPError{Msg: respErr.Message}
+	}
+	return val, nil
+}
+
+// Reports whether a command sent to the primary certainly did not run because the primary could
+// not be reached or is now a replica, so it can be sent again to a new primary.
+func primaryLost(val resp.RespValue, err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	respErr, ok := val.(resp.RespErrorValue)
+	return ok && strings.HasPrefix(respErr.Message, "READONLY")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+	"github.com/CDavidSV/GopherStore/internal/server"
+)
+
+// Starts a cache server on a random port, a replica of primary unless it is empty.
+func startCacheServer(t *testing.T, primary string) *server.Server {
+	store := server.NewInMemoryKVStore()
+	s := server.NewServer(slog.New(slog.NewTextHandler(io.Discard, nil)), "127.0.0.1:0", store)
+	s.SetShutdownTimeout(0)
+	if primary != "" {
+		s.SetReplicaOf(primary)
+	}
+	if err := s.ListenAndServe(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	return s
+}
+
+// Sends a request to a gateway handler and returns the recorded response.
+func serve(handler http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w
+}
+
+// Retries a request to a cache server until it replies with the bulk string expected.
+func waitForValue(t *testing.T, addr, key, expected string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		val, err := request(addr, "GET", key)
+		if bulk, ok := val.(resp.RespBulkString); err == nil && ok && string(bulk.Value) == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %s to hold %q on %s, got %v (%v)", key, expected, addr, val, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBackendFailover(t *testing.T) {
+	primary := startCacheServer(t, "")
+	primaryAddr := primary.Addr().String()
+	replica := startCacheServer(t, primaryAddr)
+	replicaAddr := replica.Addr().String()
+
+	previous := cache
+	t.Cleanup(func() { cache = previous })
+	cache = &backend{primary: primaryAddr}
+	cache.refresh("")
+
+	if w := serve(handleSetCommand, "POST", "/set", `{"key":"greeting","value":"hello"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the write to the primary to succeed, got %d: %s", w.Code, w.Body)
+	}
+	waitForValue(t, replicaAddr, "greeting", "hello")
+
+	// Learn the replica from the primary, like the periodic refresh does
+	cache.refresh("")
+	if cache.replicas == nil || cache.replicas[0] != replicaAddr {
+		t.Fatalf("Expected the replica %s to be learned from the primary, got %v", replicaAddr, cache.replicas)
+	}
+
+	// Without a primary, the replica rejects writes and the gateway reports the error
+	if err := primary.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(handleSetCommand, "POST", "/set", `{"key":"greeting","value":"lost"}`); w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected an error while no primary is available, got %d: %s", w.Code, w.Body)
+	}
+	if cache.primaryAddr() != primaryAddr {
+		t.Errorf("Expected the gateway to keep the last primary until a new one is found, got %s", cache.primaryAddr())
+	}
+
+	// Once the replica is promoted, requests go to it
+	if _, err := request(replicaAddr, "REPLICAOF", "NO", "ONE"); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(handleSetCommand, "POST", "/set", `{"key":"greeting","value":"hi"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the write to go to the promoted replica, got %d: %s", w.Code, w.Body)
+	}
+	if cache.primaryAddr() != replicaAddr {
+		t.Errorf("Expected the promoted replica %s to be the primary, got %s", replicaAddr, cache.primaryAddr())
+	}
+	waitForValue(t, replicaAddr, "greeting", "hi")
+
+	w := serve(handleGetCommand, "GET", "/get?key=greeting", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"hi"`) {
+		t.Errorf("Expected the read to go to the promoted replica, got %d: %s", w.Code, w.Body)
+	}
+}
@@ -1,16 +1,15 @@
 package main
 
 import (
-	"bufio"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"html/template"
 	"log"
 	"log/slog"
-	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/CDavidSV/GopherStore/internal/resp"
@@ -20,8 +19,8 @@ import (
 )
 
 var (
-	cacheServerHost = "localhost:5001"
-	validate        = validator.New()
+	cache    = &backend{primary: "localhost:5001"}
+	validate = validator.New()
 )
 
 type Response struct {
@@ -37,6 +36,11 @@ type SetCommandRequest struct {
 
 type DeleteCommandRequest struct {
 	Keys []string `json:"keys"`
+	Type string   `json:"type,omitempty" validate:"omitempty,oneof=string list hash set zset stream"` // Only delete keys holding this type
+}
+
+type MGetCommandRequest struct {
+	Keys []string `json:"keys" validate:"required,min=1"`
 }
 
 type PushCommandRequest struct {
@@ -55,24 +59,37 @@ type ExpiresCommandRequest struct {
 	ExpireSeconds int    `json:"expiration" validate:"min=1"`
 }
 
-// Makes a request to the cache server and disconnects after receiving a response.
+// Makes a request to the primary cache server and disconnects after receiving a response. If the
+// primary cannot be reached or became a replica, the request is sent again to the new primary.
 func makeRequest(respString string) (resp.RespValue, error) {
-	conn, err := net.Dial("tcp", cacheServerHost)
+	primary := cache.primaryAddr()
+	val, err := sendCommand(primary, respString)
+	if primaryLost(val, err) && cache.refresh(primary) {
+		val, err = sendCommand(cache.primaryAddr(), respString)
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
 
-	_, err = conn.Write([]byte(respString))
-	if err != nil {
-		return nil, err
+	if respErr, ok := val.(resp.RespErrorValue); ok {
+		return nil, &resp.RESPError{Msg: respErr.Message}
 	}
 
-	// Wait for the reply before closing the connection
-	reader := bufio.NewReader(conn)
-	val, err := resp.ReadRESP(reader)
+	return val, nil
+}
+
+// Same as makeRequest for commands that only read, sent to a replica when reads from replicas are
+// enabled. Falls back to the primary if the replica cannot be reached.
+func makeReadRequest(respString string) (resp.RespValue, error) {
+	replica, ok := cache.replicaAddr()
+	if !ok {
+		return makeRequest(respString)
+	}
+
+	val, err := sendCommand(replica, respString)
 	if err != nil {
-		return nil, err
+		slog.Warn("failed to read from replica, reading from the primary", "replica", replica, "error", err)
+		return makeRequest(respString)
 	}
 
 	if respErr, ok := val.(resp.RespErrorValue); ok {
@@ -151,7 +168,7 @@ func handleGetCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cashRes, err := makeRequest(string(resp.EncodeBulkStringArray([][]byte{
+	cashRes, err := makeReadRequest(string(resp.EncodeBulkStringArray([][]byte{
 		[]byte("GET"),
 		[]byte(key),
 	})))
@@ -171,6 +188,88 @@ func handleGetCommand(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(Response{Data: string(stringRes.Value)})
 }
 
+// Fetches multiple keys in a single MGET round trip and replies with a key to value map,
+// where missing keys are explicit nulls.
+func fetchMultipleKeys(w http.ResponseWriter, keys []string) {
+	reqArr := make([][]byte, len(keys)+1)
+	reqArr[0] = []byte("MGET")
+	for i, k := range keys {
+		reqArr[i+1] = []byte(k)
+	}
+
+	cashRes, err := makeReadRequest(string(resp.EncodeBulkStringArray(reqArr)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respArr, ok := cashRes.(resp.RespArray)
+	if !ok || len(respArr.Elements) != len(keys) {
+		http.Error(w, "Invalid response format", http.StatusInternalServerError)
+		return
+	}
+
+	values := make(map[string]*string, len(keys))
+	for i, elem := range respArr.Elements {
+		bulkStr, ok := elem.(resp.RespBulkString)
+		if !ok {
+			http.Error(w, "Invalid response element format", http.StatusInternalServerError)
+			return
+		}
+
+		if bulkStr.Value == nil {
+			values[keys[i]] = nil
+			continue
+		}
+		value := string(bulkStr.Value)
+		values[keys[i]] = &value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Response{Data: values})
+}
+
+func handleMGetCommand(w http.ResponseWriter, r *http.Request) {
+	// Get the comma-separated keys from query params
+	keysParam := r.URL.Query().Get("keys")
+	if keysParam == "" {
+		http.Error(w, "Missing 'keys' query parameter", http.StatusBadRequest)
+		return
+	}
+
+	keys := make([]string, 0)
+	for key := range strings.SplitSeq(keysParam, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		http.Error(w, "Missing 'keys' query parameter", http.StatusBadRequest)
+		return
+	}
+
+	fetchMultipleKeys(w, keys)
+}
+
+// Same as handleMGetCommand but takes the keys from the request body, for batches too large for a URL.
+func handleMGetBatchCommand(w http.ResponseWriter, r *http.Request) {
+	var req MGetCommandRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fetchMultipleKeys(w, req.Keys)
+}
+
 func handleDeleteCommand(w http.ResponseWriter, r *http.Request) {
 	var req DeleteCommandRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
@@ -189,6 +288,10 @@ func handleDeleteCommand(w http.ResponseWriter, r *http.Request) {
 	for i, k := range req.Keys {
 		reqArr[i+1] = []byte(k)
 	}
+
+	if req.Type != "" {
+		reqArr = append(reqArr, []byte("IFTYPE"), []byte(req.Type))
+	}
 	cashRes, err := makeRequest(string(resp.EncodeBulkStringArray(reqArr)))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -295,7 +398,7 @@ func handleLLenCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cashRes, err := makeRequest(string(resp.EncodeBulkStringArray([][]byte{
+	cashRes, err := makeReadRequest(string(resp.EncodeBulkStringArray([][]byte{
 		[]byte("LLEN"),
 		[]byte(key),
 	})))
@@ -329,7 +432,7 @@ func handleLRangeCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cashRes, err := makeRequest(string(resp.EncodeBulkStringArray([][]byte{
+	cashRes, err := makeReadRequest(string(resp.EncodeBulkStringArray([][]byte{
 		[]byte("LRANGE"),
 		[]byte(key),
 		[]byte(startStr),
@@ -483,12 +586,36 @@ func Logger(next http.Handler) http.Handler {
 	})
 }
 
+// Splits a comma-separated list of addresses, ignoring empty ones.
+func splitAddrs(list string) []string {
+	var addrs []string
+	for addr := range strings.SplitSeq(list, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
 func main() {
 	addr := flag.String("addr", "localhost:3000", "HTTP network address")
-	cacheAddr := flag.String("cache-addr", "localhost:5001", "Cache server network address")
+	cacheAddr := flag.String("cache-addr", "localhost:5001", "Primary cache server network address")
+	replicas := flag.String("replicas", "", "Comma-separated addresses of replicas of the cache server, also learned from the primary")
+	readFromReplicas := flag.Bool("read-from-replicas", false, "Send reads to the replicas, which may lag behind the primary")
+	sentinels := flag.String("sentinels", "", "Comma-separated addresses of sentinels to ask for the address of the primary")
+	sentinelName := flag.String("sentinel-name", "gopherstore", "Name of the primary known to the sentinels")
+	refreshInterval := flag.Duration("refresh-interval", time.Second, "Time between lookups of the primary and its replicas")
 	flag.Parse()
 
-	cacheServerHost = *cacheAddr
+	cache = &backend{
+		primary:          *cacheAddr,
+		replicas:         splitAddrs(*replicas),
+		readFromReplicas: *readFromReplicas,
+		sentinels:        splitAddrs(*sentinels),
+		name:             *sentinelName,
+	}
+	cache.refresh("")
+	go cache.watch(*refreshInterval)
 
 	mux := http.NewServeMux()
 
@@ -500,6 +627,8 @@ func main() {
 	mux.HandleFunc("GET /", handleRoot)
 	mux.HandleFunc("POST /set", handleSetCommand)
 	mux.HandleFunc("GET /get", handleGetCommand)
+	mux.HandleFunc("GET /mget", handleMGetCommand)
+	mux.HandleFunc("POST /mget", handleMGetBatchCommand)
 	mux.HandleFunc("POST /delete", handleDeleteCommand)
 	mux.HandleFunc("POST /push", handlePushCommand)
 	mux.HandleFunc("POST /pop", handlePopCommand)
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Points the gateway at a new cache server holding the given string keys.
+func useCacheServer(t *testing.T, values map[string]string) {
+	s := startCacheServer(t, "")
+	addr := s.Addr().String()
+
+	previous := cache
+	t.Cleanup(func() { cache = previous })
+	cache = &backend{primary: addr}
+
+	for key, value := range values {
+		if _, err := request(addr, "SET", key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Decodes the key to value map replied by the MGET handlers, where missing keys are nil.
+func decodeValues(t *testing.T, body []byte) map[string]*string {
+	t.Helper()
+
+	var reply struct {
+		Data map[string]*string `json:"data"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil {
+		t.Fatalf("Expected a JSON reply, got %s: %v", body, err)
+	}
+	return reply.Data
+}
+
+// Converts a map with nil for missing keys to one with "<nil>", so it can be compared and printed.
+func nullable(values map[string]*string) map[string]string {
+	converted := make(map[string]string, len(values))
+	for key, value := range values {
+		converted[key] = "<nil>"
+		if value != nil {
+			converted[key] = *value
+		}
+	}
+	return converted
+}
+
+func TestHandleMGetCommand(t *testing.T) {
+	useCacheServer(t, map[string]string{"a": "1", "b": "2"})
+
+	// Keys are trimmed and empty ones skipped
+	w := serve(handleMGetCommand, "GET", "/mget?keys=a,%20b%20,,missing", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the keys to be fetched, got %d: %s", w.Code, w.Body)
+	}
+	expected := map[string]string{"a": "1", "b": "2", "missing": "<nil>"}
+	if got := nullable(decodeValues(t, w.Body.Bytes())); !maps.Equal(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	for _, target := range []string{"/mget", "/mget?keys=", "/mget?keys=%20,%20,"} {
+		if w := serve(handleMGetCommand, "GET", target, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d: %s", target, w.Code, w.Body)
+		}
+	}
+}
+
+func TestHandleMGetBatchCommand(t *testing.T) {
+	useCacheServer(t, map[string]string{"a": "1"})
+
+	w := serve(handleMGetBatchCommand, "POST", "/mget", `{"keys":["a","missing"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the keys to be fetched, got %d: %s", w.Code, w.Body)
+	}
+
+	// Missing keys are explicit nulls rather than left out
+	if !strings.Contains(w.Body.String(), `"missing":null`) {
+		t.Errorf("Expected an explicit null for the missing key, got %s", w.Body)
+	}
+	expected := map[string]string{"a": "1", "missing": "<nil>"}
+	if got := nullable(decodeValues(t, w.Body.Bytes())); !maps.Equal(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	for _, body := range []string{`{"keys":[]}`, `{}`, `{"keys":"a"}`, `not json`} {
+		if w := serve(handleMGetBatchCommand, "POST", "/mget", body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d: %s", body, w.Code, w.Body)
+		}
+	}
+}
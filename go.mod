@@ -2,7 +2,11 @@ module github.com/CDavidSV/GopherStore
 
 go 1.25.1
 
-require github.com/go-playground/validator/v10 v10.30.1
+require (
+	github.com/go-playground/validator/v10 v10.30.1
+	go.etcd.io/bbolt v1.4.3
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/aymanbagabas/go-osc52/v2 v2.0.1 // indirect
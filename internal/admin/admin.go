@@ -0,0 +1,147 @@
+// Package admin serves the HTTP endpoints used to inspect a running server: the profiles of
+// net/http/pprof under /debug/pprof/, runtime statistics under /debug/runtime and the expvar
+// variables under /debug/vars. The endpoints are not authenticated and must only be reachable by
+// operators.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// Runtime statistics served by /debug/runtime.
+type RuntimeStats struct {
+	GoVersion  string  `json:"go_version"`
+	Uptime     float64 `json:"uptime_seconds"`
+	Goroutines int     `json:"goroutines"`
+	CPUs       int     `json:"cpus"`
+	MaxProcs   int     `json:"gomaxprocs"`
+	CGoCalls   int64   `json:"cgo_calls"`
+
+	HeapAlloc    uint64  `json:"heap_alloc_bytes"`   // Bytes of allocated heap objects
+	HeapInuse    uint64  `json:"heap_inuse_bytes"`   // Bytes in heap spans in use
+	HeapObjects  uint64  `json:"heap_objects"`       // Number of allocated heap objects
+	StackInuse   uint64  `json:"stack_inuse_bytes"`  // Bytes in stack spans in use
+	Sys          uint64  `json:"sys_bytes"`          // Bytes obtained from the operating system
+	TotalAlloc   uint64  `json:"total_alloc_bytes"`  // Cumulative bytes allocated for heap objects
+	Mallocs      uint64  `json:"mallocs"`            // Cumulative count of heap objects allocated
+	Frees        uint64  `json:"frees"`              // Cumulative count of heap objects freed
+	NumGC        uint32  `json:"gc_cycles"`          // Completed GC cycles
+	PauseTotal   uint64  `json:"gc_pause_total_ns"`  // Cumulative time the world was stopped by the GC
+	LastGC       int64   `json:"gc_last_unix_ns"`    // When the last GC cycle finished, 0 if none did
+	NextGC       uint64  `json:"gc_next_heap_bytes"` // Heap size at which the next GC cycle starts
+	GCCPUPercent float64 `json:"gc_cpu_percent"`     // Share of the CPU time used by the GC since the start
+}
+
+// Process start, the origin of the uptime.
+var started = time.Now()
+
+// Collects the runtime statistics. Stops the world briefly, like runtime.ReadMemStats.
+func ReadRuntimeStats() RuntimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	return RuntimeStats{
+		GoVersion:  runtime.Version(),
+		Uptime:     time.Since(started).Seconds(),
+		Goroutines: runtime.NumGoroutine(),
+		CPUs:       runtime.NumCPU(),
+		MaxProcs:   runtime.GOMAXPROCS(0),
+		CGoCalls:   runtime.NumCgoCall(),
+
+		HeapAlloc:    m.HeapAlloc,
+		HeapInuse:    m.HeapInuse,
+		HeapObjects:  m.HeapObjects,
+		StackInuse:   m.StackInuse,
+		Sys:          m.Sys,
+		TotalAlloc:   m.TotalAlloc,
+		Mallocs:      m.Mallocs,
+		Frees:        m.Frees,
+		NumGC:        m.NumGC,
+		PauseTotal:   m.PauseTotalNs,
+		LastGC:       int64(m.LastGC),
+		NextGC:       m.NextGC,
+		GCCPUPercent: m.GCCPUFraction * 100,
+	}
+}
+
+// Returns the handler of the admin endpoints.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	// The index serves the named profiles, e.g. /debug/pprof/heap, the others need their own handler
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	mux.HandleFunc("GET /debug/runtime", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ReadRuntimeStats())
+	})
+	return mux
+}
+
+// Time given to requests in progress when the listener is stopped. CPU profiles and traces last as
+// long as their seconds parameter and are cut short after it.
+const shutdownTimeout = 5 * time.Second
+
+// Listener serving the admin endpoints.
+type Listener struct {
+	ln     net.Listener
+	server *http.Server
+	done   chan struct{}
+}
+
+// Listens on addr and serves the admin endpoints in the background until Stop.
+func Listen(addr string, logger *slog.Logger) (*Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Listener{
+		ln: ln,
+		server: &http.Server{
+			Handler:           Handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+			ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelError),
+		},
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(l.done)
+		if err := l.server.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("admin listener stopped", "error", err)
+		}
+	}()
+
+	logger.Info("admin listener started", "addr", ln.Addr().String())
+	return l, nil
+}
+
+// Returns the address the endpoints are served on.
+func (l *Listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// Stops accepting requests and waits for the requests in progress, up to a few seconds.
+func (l *Listener) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := l.server.Shutdown(ctx)
+	if err != nil {
+		l.server.Close()
+	}
+	<-l.done
+	return err
+}
@@ -0,0 +1,58 @@
+package admin
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestListener(t *testing.T) {
+	l, err := Listen("127.0.0.1:0", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := "http://" + l.Addr().String()
+
+	get := func(path string) (*http.Response, []byte) {
+		res, err := http.Get(base + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, body
+	}
+
+	if res, body := get("/debug/pprof/"); res.StatusCode != http.StatusOK || !strings.Contains(string(body), "goroutine") {
+		t.Errorf("Expected the index of the profiles, got %d", res.StatusCode)
+	}
+	if res, body := get("/debug/pprof/heap"); res.StatusCode != http.StatusOK || len(body) == 0 {
+		t.Errorf("Expected a heap profile, got %d", res.StatusCode)
+	}
+
+	if res, body := get("/debug/vars"); res.StatusCode != http.StatusOK || !strings.Contains(string(body), "memstats") {
+		t.Errorf("Expected the expvar variables, got %d", res.StatusCode)
+	}
+
+	res, body := get("/debug/runtime")
+	var stats RuntimeStats
+	if err := json.Unmarshal(body, &stats); err != nil {
+		t.Fatalf("Expected the runtime statistics as JSON, got %q (%v)", body, err)
+	}
+	if res.Header.Get("Content-Type") != "application/json" || stats.Goroutines == 0 || stats.HeapAlloc == 0 {
+		t.Errorf("Expected runtime statistics, got %+v", stats)
+	}
+
+	if err := l.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.Get(base + "/debug/runtime"); err == nil {
+		t.Error("Expected the listener to be closed")
+	}
+}
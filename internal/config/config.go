@@ -0,0 +1,278 @@
+// Package config applies settings from a YAML configuration file and from environment variables to
+// the command-line flags of a program.
+//
+// Settings are named after the flags. The file is a mapping of flag names to values, which may be
+// grouped into sections of any name, e.g. persistence or security:
+//
+//	addr: 0.0.0.0:5001
+//	persistence:
+//	  appendonly: yes
+//	  save: "300 100"
+//
+// Every setting can also be given as an environment variable named after the flag, uppercased with
+// dashes replaced by underscores and prefixed with GOPHERSTORE_, e.g. GOPHERSTORE_APPENDONLY. Flags
+// given on the command line take precedence over environment variables, which take precedence over
+// the file.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Prefix of the environment variables overriding settings.
+const EnvPrefix = "GOPHERSTORE_"
+
+// Name of the flag holding the path of the configuration file.
+const FileFlag = "config"
+
+// Returns the environment variable overriding the setting of a flag.
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Reads the settings of a YAML configuration file, keyed by flag name. Sections are flattened and
+// null values are returned as empty strings.
+func ParseFile(data []byte) (map[string]string, error) {
+	settings := make(map[string]string)
+
+	var doc yaml.Node
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil {
+		if errors.Is(err, io.EOF) {
+			return settings, nil // Empty file
+		}
+		return nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: expected a mapping of settings", doc.Line)
+	}
+
+	if err := flattenSettings(doc.Content[0], settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// Adds the settings of a mapping to settings, recursing into sections.
+func flattenSettings(mapping *yaml.Node, settings map[string]string) error {
+	for i := 0; i < len(mapping.Content); i += 2 {
+		key, value := mapping.Content[i], mapping.Content[i+1]
+		if value.Kind == yaml.AliasNode {
+			value = value.Alias
+		}
+
+		switch value.Kind {
+		case yaml.MappingNode:
+			if err := flattenSettings(value, settings); err != nil {
+				return err
+			}
+		case yaml.ScalarNode:
+			if _, ok := settings[key.Value]; ok {
+				return fmt.Errorf("line %d: %s is set more than once", key.Line, key.Value)
+			}
+			if value.Tag == "!!null" {
+				settings[key.Value] = ""
+			} else {
+				settings[key.Value] = value.Value
+			}
+		default:
+			return fmt.Errorf("line %d: %s must be a single value, write lists as a string", key.Line, key.Value)
+		}
+	}
+	return nil
+}
+
+// Applies the configuration file named by the config flag, or by its environment variable, and the
+// environment variables to the flags of fs that were not given on the command line. Must be called
+// after fs.Parse. environ holds "key=value" strings, like os.Environ.
+func Apply(fs *flag.FlagSet, environ []string) error {
+	explicit := explicitFlags(fs)
+	env := prefixedEnv(environ)
+
+	settings := make(map[string]string)
+	if path := filePath(fs, explicit, env); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		settings, err = ParseFile(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		for name := range settings {
+			if name == FileFlag || fs.Lookup(name) == nil {
+				return fmt.Errorf("%s: unknown setting %q", path, name)
+			}
+		}
+	}
+
+	// Unknown variables are ignored, the prefix may be shared with other tools
+	fs.VisitAll(func(f *flag.Flag) {
+		if value, ok := env[EnvName(f.Name)]; ok && f.Name != FileFlag {
+			settings[f.Name] = value
+		}
+	})
+
+	for name, value := range settings {
+		if explicit[name] {
+			continue
+		}
+
+		f := fs.Lookup(name)
+		if isBoolFlag(f) {
+			value = normalizeBool(value)
+		}
+		if err := f.Value.Set(value); err != nil {
+			return fmt.Errorf("invalid value %q for setting %s: %w", value, name, err)
+		}
+	}
+	return nil
+}
+
+// Returns the path of the configuration file applied by Apply, empty if there is none.
+func FilePath(fs *flag.FlagSet, environ []string) string {
+	return filePath(fs, explicitFlags(fs), prefixedEnv(environ))
+}
+
+// Returns the names of the flags of fs given on the command line or by environment variables, whose
+// settings in the configuration file are ignored.
+func Overridden(fs *flag.FlagSet, environ []string) []string {
+	explicit := explicitFlags(fs)
+	env := prefixedEnv(environ)
+
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if _, ok := env[EnvName(f.Name)]; f.Name != FileFlag && (explicit[f.Name] || ok) {
+			names = append(names, f.Name)
+		}
+	})
+	return names
+}
+
+// Returns the flags of fs given on the command line.
+func explicitFlags(fs *flag.FlagSet) map[string]bool {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	return explicit
+}
+
+// Returns the environment variables with the prefix of the settings.
+func prefixedEnv(environ []string) map[string]string {
+	env := make(map[string]string)
+	for _, entry := range environ {
+		if key, value, ok := strings.Cut(entry, "="); ok && strings.HasPrefix(key, EnvPrefix) {
+			env[key] = value
+		}
+	}
+	return env
+}
+
+// Returns the path of the configuration file, given by the config flag or by its environment
+// variable. The flag wins when given on the command line.
+func filePath(fs *flag.FlagSet, explicit map[string]bool, env map[string]string) string {
+	path := env[EnvName(FileFlag)]
+	if f := fs.Lookup(FileFlag); f != nil && (explicit[FileFlag] || path == "") {
+		path = f.Value.String()
+	}
+	return path
+}
+
+// Updates the settings of a YAML configuration file, returning the new content. Settings already in
+// the file are changed in place, in their section, and the others are added at the end. Comments and
+// the order of the settings are kept.
+func Rewrite(data []byte, settings map[string]string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: expected a mapping of settings", doc.Line)
+	}
+
+	remaining := make(map[string]string, len(settings))
+	for name, value := range settings {
+		remaining[name] = value
+	}
+	rewriteSettings(root, remaining)
+
+	names := make([]string, 0, len(remaining))
+	for name := range remaining {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		root.Content = append(root.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: name},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: remaining[name]},
+		)
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Changes the values of the settings of a mapping found in remaining, recursing into sections, and
+// removes them from remaining.
+func rewriteSettings(mapping *yaml.Node, remaining map[string]string) {
+	for i := 0; i < len(mapping.Content); i += 2 {
+		key, value := mapping.Content[i], mapping.Content[i+1]
+		if value.Kind == yaml.MappingNode {
+			rewriteSettings(value, remaining)
+			continue
+		}
+
+		newValue, ok := remaining[key.Value]
+		if !ok {
+			continue
+		}
+		delete(remaining, key.Value)
+
+		// The quoting of the value is kept if it has one, the tag is resolved again from the new value
+		if value.Kind == yaml.ScalarNode {
+			value.Value, value.Tag = newValue, ""
+		} else {
+			mapping.Content[i+1] = &yaml.Node{Kind: yaml.ScalarNode, Value: newValue}
+		}
+	}
+}
+
+// Reports whether the flag is a boolean flag, which accepts yes and no in the file.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// Translates the yes and no of Redis configuration files into values accepted by boolean flags.
+func normalizeBool(value string) string {
+	switch strings.ToLower(value) {
+	case "yes", "on":
+		return "true"
+	case "no", "off":
+		return "false"
+	default:
+		return value
+	}
+}
@@ -0,0 +1,220 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFile(t *testing.T) {
+	data := `
+addr: 127.0.0.1:6000
+persistence:
+  appendonly: yes
+  save: "300 100"
+  dbfilename:
+security:
+  requirepass: &pass secret
+  masterauth: *pass
+limits:
+  proto-max-depth: 8
+`
+	settings, err := ParseFile([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"addr":            "127.0.0.1:6000",
+		"appendonly":      "yes",
+		"save":            "300 100",
+		"dbfilename":      "",
+		"requirepass":     "secret",
+		"masterauth":      "secret",
+		"proto-max-depth": "8",
+	}
+	if len(settings) != len(want) {
+		t.Errorf("Expected %d settings, got %v", len(want), settings)
+	}
+	for name, value := range want {
+		if got, ok := settings[name]; !ok || got != value {
+			t.Errorf("settings[%q] = %q, want %q", name, got, value)
+		}
+	}
+
+	if settings, err := ParseFile(nil); err != nil || len(settings) != 0 {
+		t.Errorf("Expected an empty file to have no settings, got %v, %v", settings, err)
+	}
+}
+
+func TestParseFileErrors(t *testing.T) {
+	tests := []struct {
+		data    string
+		wantErr string
+	}{
+		{data: "- addr", wantErr: "expected a mapping"},
+		{data: "functions: [a.so, b.so]", wantErr: "functions must be a single value"},
+		{data: "addr: a\nnetwork:\n  addr: b", wantErr: "line 3: addr is set more than once"},
+		{data: "addr: [", wantErr: "yaml"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseFile([]byte(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("ParseFile(%q) error = %v, want %q", tt.data, err, tt.wantErr)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gopherstore.yaml")
+	data := "addr: 0.0.0.0:6000\nappendonly: yes\nexpire-budget: 10ms\nrequirepass: fromfile\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.String(FileFlag, "", "")
+	addr := fs.String("addr", "0.0.0.0:5001", "")
+	appendOnly := fs.Bool("appendonly", false, "")
+	budget := fs.Duration("expire-budget", 25*time.Millisecond, "")
+	requirePass := fs.String("requirepass", "", "")
+	replicaOf := fs.String("replicaof", "", "")
+
+	if err := fs.Parse([]string{"-config", path, "-addr", "127.0.0.1:7000"}); err != nil {
+		t.Fatal(err)
+	}
+	environ := []string{
+		"GOPHERSTORE_REQUIREPASS=fromenv",
+		"GOPHERSTORE_REPLICAOF=primary:5001",
+		"GOPHERSTORE_ADDR=0.0.0.0:8000",
+		"GOPHERSTORE_UNKNOWN=ignored",
+		"PATH=/bin",
+	}
+	if err := Apply(fs, environ); err != nil {
+		t.Fatal(err)
+	}
+
+	if *addr != "127.0.0.1:7000" {
+		t.Errorf("Expected the command line to take precedence, got addr %q", *addr)
+	}
+	if *requirePass != "fromenv" || *replicaOf != "primary:5001" {
+		t.Errorf("Expected the environment to take precedence over the file, got %q and %q", *requirePass, *replicaOf)
+	}
+	if !*appendOnly || *budget != 10*time.Millisecond {
+		t.Errorf("Expected the file settings to be applied, got %v and %v", *appendOnly, *budget)
+	}
+
+	if got := FilePath(fs, environ); got != path {
+		t.Errorf("Expected the path of the file, got %q", got)
+	}
+	if got := strings.Join(Overridden(fs, environ), ","); got != "addr,replicaof,requirepass" {
+		t.Errorf("Expected the settings given on the command line and by the environment, got %s", got)
+	}
+}
+
+func TestApplyFileFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gopherstore.yaml")
+	if err := os.WriteFile(path, []byte("addr: 0.0.0.0:6000\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.String(FileFlag, "", "")
+	addr := fs.String("addr", "0.0.0.0:5001", "")
+	fs.Parse(nil)
+
+	if err := Apply(fs, []string{"GOPHERSTORE_CONFIG=" + path}); err != nil {
+		t.Fatal(err)
+	}
+	if *addr != "0.0.0.0:6000" {
+		t.Errorf("Expected GOPHERSTORE_CONFIG to name the file, got addr %q", *addr)
+	}
+
+	// An unknown setting in the file is most likely a typo
+	if err := os.WriteFile(path, []byte("adr: 0.0.0.0:6000\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(fs, []string{"GOPHERSTORE_CONFIG=" + path}); err == nil || !strings.Contains(err.Error(), `unknown setting "adr"`) {
+		t.Errorf("Expected an unknown setting to be rejected, got %v", err)
+	}
+
+	if err := Apply(fs, []string{"GOPHERSTORE_CONFIG=" + filepath.Join(dir, "missing.yaml")}); err == nil {
+		t.Error("Expected a missing file to be reported")
+	}
+}
+
+func TestApplyInvalidValue(t *testing.T) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.Int("hot-keys", 1000, "")
+	fs.Parse(nil)
+
+	err := Apply(fs, []string{"GOPHERSTORE_HOT_KEYS=many"})
+	if err == nil || !strings.Contains(err.Error(), `invalid value "many" for setting hot-keys`) {
+		t.Errorf("Expected an invalid value to be reported, got %v", err)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	data := `# Network settings
+addr: 0.0.0.0:5001
+persistence:
+  save: "300 100" # Every 5 minutes
+  appendonly: yes
+security:
+  requirepass: &pass old
+  masterauth: *pass
+`
+	rewritten, err := Rewrite([]byte(data), map[string]string{
+		"save":            "60 1000",
+		"masterauth":      "primary-secret",
+		"maxmemory":       "100mb",
+		"ttl-policy":      "session:* 30m",
+		"proto-max-depth": "8",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# Network settings
+addr: 0.0.0.0:5001
+persistence:
+  save: "60 1000" # Every 5 minutes
+  appendonly: yes
+security:
+  requirepass: &pass old
+  masterauth: primary-secret
+maxmemory: 100mb
+proto-max-depth: 8
+ttl-policy: session:* 30m
+`
+	if string(rewritten) != want {
+		t.Errorf("Expected the settings to be rewritten in place and the others added, got:\n%s", rewritten)
+	}
+
+	settings, err := ParseFile(rewritten)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings["ttl-policy"] != "session:* 30m" || settings["requirepass"] != "old" {
+		t.Errorf("Expected the rewritten file to hold the settings, got %v", settings)
+	}
+
+	rewritten, err = Rewrite(nil, map[string]string{"requirepass": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings, err := ParseFile(rewritten); err != nil || len(settings) != 1 || settings["requirepass"] != "" {
+		t.Errorf("Expected an empty file to be given the settings, got %q", rewritten)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if got := EnvName("proto-max-bulk-len"); got != "GOPHERSTORE_PROTO_MAX_BULK_LEN" {
+		t.Errorf("EnvName(proto-max-bulk-len) = %q", got)
+	}
+}
@@ -0,0 +1,106 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConsulRegistrar registers instances with a Consul agent using TTL health checks.
+type ConsulRegistrar struct {
+	baseURL string
+}
+
+// Creates a registrar for the Consul agent at the given address, e.g. "http://localhost:8500".
+func NewConsulRegistrar(addr string) *ConsulRegistrar {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+
+	return &ConsulRegistrar{
+		baseURL: strings.TrimSuffix(addr, "/"),
+	}
+}
+
+type consulCheck struct {
+	TTL                            string `json:"TTL"`
+	DeregisterCriticalServiceAfter string `json:"DeregisterCriticalServiceAfter"`
+}
+
+type consulService struct {
+	ID      string      `json:"ID"`
+	Name    string      `json:"Name"`
+	Address string      `json:"Address"`
+	Port    int         `json:"Port"`
+	Check   consulCheck `json:"Check"`
+}
+
+// Sends a PUT request to the agent API.
+func (c *ConsulRegistrar) put(ctx context.Context, path string, body any) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("consul returned %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
+
+func (c *ConsulRegistrar) Register(ctx context.Context, inst Instance, ttl time.Duration) error {
+	host, portStr, err := net.SplitHostPort(inst.Address)
+	if err != nil {
+		return fmt.Errorf("invalid instance address: %w", err)
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("invalid instance port: %w", err)
+	}
+
+	return c.put(ctx, "/v1/agent/service/register", consulService{
+		ID:      inst.ID,
+		Name:    inst.Name,
+		Address: host,
+		Port:    port,
+		Check: consulCheck{
+			TTL: ttl.String(),
+			// Let Consul clean up instances that died without deregistering
+			DeregisterCriticalServiceAfter: (ttl * 10).String(),
+		},
+	})
+}
+
+func (c *ConsulRegistrar) Heartbeat(ctx context.Context, inst Instance) error {
+	return c.put(ctx, "/v1/agent/check/pass/service:"+url.PathEscape(inst.ID), nil)
+}
+
+func (c *ConsulRegistrar) Deregister(ctx context.Context, inst Instance) error {
+	return c.put(ctx, "/v1/agent/service/deregister/"+url.PathEscape(inst.ID), nil)
+}
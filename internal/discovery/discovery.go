@@ -0,0 +1,106 @@
+package discovery
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Instance describes a server instance advertised to a service registry.
+type Instance struct {
+	ID      string // Unique ID of the instance
+	Name    string // Service name shared by all instances
+	Address string // Address clients should connect to (host:port)
+}
+
+// Registrar registers instances in a service registry with a health TTL.
+// Implementations must be safe for concurrent use.
+type Registrar interface {
+	Register(ctx context.Context, inst Instance, ttl time.Duration) error // Registers the instance, it is considered unhealthy if not renewed within ttl.
+	Heartbeat(ctx context.Context, inst Instance) error                   // Renews the health TTL of a registered instance.
+	Deregister(ctx context.Context, inst Instance) error                  // Removes the instance from the registry.
+}
+
+const requestTimeout = 5 * time.Second
+
+// Shared HTTP client for registry APIs.
+var httpClient = &http.Client{Timeout: requestTimeout}
+
+// Registration keeps an instance registered by sending heartbeats until stopped.
+type Registration struct {
+	registrar Registrar
+	instance  Instance
+	ttl       time.Duration
+	logger    *slog.Logger
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
+}
+
+// Registers the instance and starts sending heartbeats at a third of the TTL.
+func Register(registrar Registrar, inst Instance, ttl time.Duration, logger *slog.Logger) (*Registration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	if err := registrar.Register(ctx, inst, ttl); err != nil {
+		return nil, err
+	}
+
+	reg := &Registration{
+		registrar: registrar,
+		instance:  inst,
+		ttl:       ttl,
+		logger:    logger,
+		stopCh:    make(chan struct{}),
+	}
+
+	reg.wg.Add(1)
+	go reg.heartbeatLoop()
+
+	logger.Info("registered with service discovery", "id", inst.ID, "name", inst.Name, "address", inst.Address)
+	return reg, nil
+}
+
+func (r *Registration) heartbeatLoop() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+			err := r.registrar.Heartbeat(ctx, r.instance)
+			if err != nil {
+				// The registry may have dropped the instance, try registering again
+				r.logger.Warn("service discovery heartbeat failed, re-registering", "error", err)
+				err = r.registrar.Register(ctx, r.instance, r.ttl)
+			}
+			cancel()
+
+			if err != nil {
+				r.logger.Error("failed to renew service discovery registration", "error", err)
+			}
+		case <-r.stopCh:
+			return
+		}
+	}
+}
+
+// Stops sending heartbeats and deregisters the instance.
+func (r *Registration) Stop() error {
+	close(r.stopCh)
+	r.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	if err := r.registrar.Deregister(ctx, r.instance); err != nil {
+		return err
+	}
+
+	r.logger.Info("deregistered from service discovery", "id", r.instance.ID)
+	return nil
+}
@@ -0,0 +1,231 @@
+package discovery
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Records the requests received by a fake registry.
+type requestLog struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+func (l *requestLog) add(path string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.paths = append(l.paths, path)
+}
+
+func (l *requestLog) count(path string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := 0
+	for _, p := range l.paths {
+		if p == path {
+			n++
+		}
+	}
+	return n
+}
+
+var testInstance = Instance{
+	ID:      "gopherstore-1",
+	Name:    "gopherstore",
+	Address: "10.0.0.5:5001",
+}
+
+func TestConsulRegistrar(t *testing.T) {
+	log := &requestLog{}
+	var registered consulService
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("Expected PUT request, got %s", r.Method)
+		}
+		log.add(r.URL.Path)
+
+		if r.URL.Path == "/v1/agent/service/register" {
+			json.NewDecoder(r.Body).Decode(&registered)
+		}
+	}))
+	defer srv.Close()
+
+	registrar := NewConsulRegistrar(srv.URL)
+	ctx := context.Background()
+
+	if err := registrar.Register(ctx, testInstance, 15*time.Second); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if registered.Address != "10.0.0.5" || registered.Port != 5001 || registered.Check.TTL != "15s" {
+		t.Errorf("Unexpected registration %+v", registered)
+	}
+
+	if err := registrar.Heartbeat(ctx, testInstance); err != nil {
+		t.Fatalf("Heartbeat() error = %v", err)
+	}
+	if log.count("/v1/agent/check/pass/service:gopherstore-1") != 1 {
+		t.Error("Expected a TTL check pass request")
+	}
+
+	if err := registrar.Deregister(ctx, testInstance); err != nil {
+		t.Fatalf("Deregister() error = %v", err)
+	}
+	if log.count("/v1/agent/service/deregister/gopherstore-1") != 1 {
+		t.Error("Expected a deregister request")
+	}
+}
+
+func TestConsulRegistrarError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "ACL not found", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	registrar := NewConsulRegistrar(srv.URL)
+	if err := registrar.Register(context.Background(), testInstance, time.Second); err == nil {
+		t.Error("Expected error when consul rejects the registration")
+	}
+}
+
+func TestEtcdRegistrar(t *testing.T) {
+	log := &requestLog{}
+	var putKey, putValue, putLease string
+	var revokedLease string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.add(r.URL.Path)
+		body, _ := io.ReadAll(r.Body)
+
+		switch r.URL.Path {
+		case "/v3/lease/grant":
+			w.Write([]byte(`{"ID":"7587"}`))
+		case "/v3/kv/put":
+			var req map[string]string
+			json.Unmarshal(body, &req)
+			key, _ := base64.StdEncoding.DecodeString(req["key"])
+			value, _ := base64.StdEncoding.DecodeString(req["value"])
+			putKey, putValue, putLease = string(key), string(value), req["lease"]
+			w.Write([]byte(`{}`))
+		case "/v3/lease/keepalive":
+			w.Write([]byte(`{"result":{"ID":"7587","TTL":"15"}}`))
+		case "/v3/lease/revoke":
+			var req map[string]string
+			json.Unmarshal(body, &req)
+			revokedLease = req["ID"]
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+
+	registrar := NewEtcdRegistrar(srv.URL, "/gopherstore/services/")
+	ctx := context.Background()
+
+	if err := registrar.Register(ctx, testInstance, 15*time.Second); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if putKey != "/gopherstore/services/gopherstore/gopherstore-1" || putValue != "10.0.0.5:5001" || putLease != "7587" {
+		t.Errorf("Unexpected put key=%s value=%s lease=%s", putKey, putValue, putLease)
+	}
+
+	if err := registrar.Heartbeat(ctx, testInstance); err != nil {
+		t.Fatalf("Heartbeat() error = %v", err)
+	}
+
+	if err := registrar.Deregister(ctx, testInstance); err != nil {
+		t.Fatalf("Deregister() error = %v", err)
+	}
+	if revokedLease != "7587" {
+		t.Errorf("Expected lease 7587 to be revoked, got %q", revokedLease)
+	}
+
+	// Heartbeats for unknown instances fail so the registration loop re-registers
+	if err := registrar.Heartbeat(ctx, testInstance); err == nil {
+		t.Error("Expected heartbeat error after deregistering")
+	}
+}
+
+func TestEtcdRegistrarExpiredLease(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/lease/grant":
+			w.Write([]byte(`{"ID":"1"}`))
+		case "/v3/lease/keepalive":
+			w.Write([]byte(`{"result":{"ID":"1"}}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+
+	registrar := NewEtcdRegistrar(srv.URL, "/services")
+	registrar.Register(context.Background(), testInstance, time.Second)
+
+	if err := registrar.Heartbeat(context.Background(), testInstance); err == nil {
+		t.Error("Expected heartbeat error for an expired lease")
+	}
+}
+
+// Registrar that counts calls without talking to a registry.
+type countingRegistrar struct {
+	mu                                sync.Mutex
+	registers, heartbeats, deregister int
+}
+
+func (c *countingRegistrar) Register(ctx context.Context, inst Instance, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.registers++
+	return nil
+}
+
+func (c *countingRegistrar) Heartbeat(ctx context.Context, inst Instance) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.heartbeats++
+	return nil
+}
+
+func (c *countingRegistrar) Deregister(ctx context.Context, inst Instance) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deregister++
+	return nil
+}
+
+func TestRegistration(t *testing.T) {
+	registrar := &countingRegistrar{}
+
+	reg, err := Register(registrar, testInstance, 30*time.Millisecond, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := reg.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+
+	registrar.mu.Lock()
+	defer registrar.mu.Unlock()
+
+	if registrar.registers != 1 {
+		t.Errorf("Expected 1 registration, got %d", registrar.registers)
+	}
+	if registrar.heartbeats < 2 {
+		t.Errorf("Expected at least 2 heartbeats, got %d", registrar.heartbeats)
+	}
+	if registrar.deregister != 1 {
+		t.Errorf("Expected 1 deregistration, got %d", registrar.deregister)
+	}
+}
@@ -0,0 +1,138 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EtcdRegistrar registers instances as keys attached to a lease using the etcd v3 JSON gateway.
+// Instances are stored under <prefix>/<name>/<id> with the instance address as the value.
+type EtcdRegistrar struct {
+	baseURL string
+	prefix  string
+	mu      sync.Mutex
+	leases  map[string]string // Instance ID to lease ID
+}
+
+// Creates a registrar for the etcd endpoint at the given address, e.g. "http://localhost:2379".
+func NewEtcdRegistrar(addr, prefix string) *EtcdRegistrar {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+
+	return &EtcdRegistrar{
+		baseURL: strings.TrimSuffix(addr, "/"),
+		prefix:  strings.TrimSuffix(prefix, "/"),
+		leases:  make(map[string]string),
+	}
+}
+
+// Sends a POST request to the gateway and decodes the JSON reply into out.
+func (e *EtcdRegistrar) post(ctx context.Context, path string, body, out any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("etcd returned %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func (e *EtcdRegistrar) key(inst Instance) string {
+	return e.prefix + "/" + inst.Name + "/" + inst.ID
+}
+
+func (e *EtcdRegistrar) Register(ctx context.Context, inst Instance, ttl time.Duration) error {
+	var grant struct {
+		ID string `json:"ID"`
+	}
+	err := e.post(ctx, "/v3/lease/grant", map[string]any{"TTL": int64(ttl.Seconds())}, &grant)
+	if err != nil {
+		return err
+	}
+
+	if grant.ID == "" {
+		return fmt.Errorf("etcd did not return a lease ID")
+	}
+
+	err = e.post(ctx, "/v3/kv/put", map[string]string{
+		"key":   base64.StdEncoding.EncodeToString([]byte(e.key(inst))),
+		"value": base64.StdEncoding.EncodeToString([]byte(inst.Address)),
+		"lease": grant.ID,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.leases[inst.ID] = grant.ID
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *EtcdRegistrar) Heartbeat(ctx context.Context, inst Instance) error {
+	e.mu.Lock()
+	leaseID, ok := e.leases[inst.ID]
+	e.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("instance %s is not registered", inst.ID)
+	}
+
+	var keepAlive struct {
+		Result struct {
+			TTL string `json:"TTL"`
+		} `json:"result"`
+	}
+	if err := e.post(ctx, "/v3/lease/keepalive", map[string]string{"ID": leaseID}, &keepAlive); err != nil {
+		return err
+	}
+
+	// A missing or zero TTL means the lease already expired
+	if keepAlive.Result.TTL == "" || keepAlive.Result.TTL == "0" {
+		return fmt.Errorf("lease %s expired", leaseID)
+	}
+
+	return nil
+}
+
+func (e *EtcdRegistrar) Deregister(ctx context.Context, inst Instance) error {
+	e.mu.Lock()
+	leaseID, ok := e.leases[inst.ID]
+	delete(e.leases, inst.ID)
+	e.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	// Revoking the lease deletes the attached key
+	return e.post(ctx, "/v3/lease/revoke", map[string]string{"ID": leaseID}, nil)
+}
@@ -0,0 +1,142 @@
+package encryption
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeyProvider supplies the keys used to encrypt persistence files.
+// Implement it to fetch keys from an external KMS.
+type KeyProvider interface {
+	CurrentKey() (id string, key []byte, err error) // Returns the key used to encrypt new files.
+	Key(id string) ([]byte, error)                  // Returns the key with the given ID so files written with older keys can still be read.
+}
+
+// Keyring is a KeyProvider holding a fixed set of keys. The current key is used for writing,
+// while all keys can be used for reading, which allows keys to be rotated: files are re-encrypted
+// with the current key the next time they are rewritten.
+type Keyring struct {
+	keys    map[string][]byte
+	current string
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{
+		keys: make(map[string][]byte),
+	}
+}
+
+// Adds a key to the keyring and makes it the current key.
+func (k *Keyring) Add(id string, key []byte) error {
+	if id == "" {
+		return fmt.Errorf("key ID cannot be empty")
+	}
+
+	if len(id) > 255 {
+		return fmt.Errorf("key ID %q is too long", id)
+	}
+
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		return fmt.Errorf("key %q must be 16, 24 or 32 bytes long, got %d", id, len(key))
+	}
+
+	k.keys[id] = key
+	k.current = id
+	return nil
+}
+
+func (k *Keyring) CurrentKey() (string, []byte, error) {
+	if k.current == "" {
+		return "", nil, fmt.Errorf("keyring is empty")
+	}
+
+	return k.current, k.keys[k.current], nil
+}
+
+func (k *Keyring) Key(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %q", id)
+	}
+
+	return key, nil
+}
+
+// Parses a key definition in the form "id:base64key".
+func parseKeyDefinition(def string) (string, []byte, error) {
+	id, encoded, ok := strings.Cut(strings.TrimSpace(def), ":")
+	if !ok {
+		return "", nil, fmt.Errorf("invalid key definition, expected id:base64key")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid base64 for key %q: %w", id, err)
+	}
+
+	return id, key, nil
+}
+
+// Loads a keyring from a file containing one "id:base64key" definition per line.
+// Empty lines and lines starting with # are ignored. The last key in the file is the current key.
+func LoadKeyFile(path string) (*Keyring, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	keyring := NewKeyring()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		id, key, err := parseKeyDefinition(line)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := keyring.Add(id, key); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if keyring.current == "" {
+		return nil, fmt.Errorf("no keys found in %s", path)
+	}
+
+	return keyring, nil
+}
+
+// Loads a keyring from an environment variable containing comma-separated "id:base64key" definitions.
+// The last key is the current key.
+func LoadKeyEnv(name string) (*Keyring, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || strings.TrimSpace(value) == "" {
+		return nil, fmt.Errorf("environment variable %s is not set", name)
+	}
+
+	keyring := NewKeyring()
+	for def := range strings.SplitSeq(value, ",") {
+		id, key, err := parseKeyDefinition(def)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := keyring.Add(id, key); err != nil {
+			return nil, err
+		}
+	}
+
+	return keyring, nil
+}
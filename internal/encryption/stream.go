@@ -0,0 +1,247 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+)
+
+// Encrypted files are a sequence of segments, each one starting with a header naming the key it was
+// written with, followed by AES-GCM sealed data frames. Appending to an existing file simply starts a
+// new segment, so append-only files can be reopened with a different key after a rotation.
+//
+//	header: magic | key ID length (1 byte) | key ID | nonce prefix (8 bytes)
+//	frame:  'D' | ciphertext length (4 bytes, big endian) | ciphertext
+//
+// Each frame nonce is the segment nonce prefix followed by a frame counter, and the segment header is
+// used as additional data, so frames cannot be reordered or moved between segments.
+const (
+	magic        = "GSENC1"
+	frameTag     = 'D'
+	prefixSize   = 8
+	maxFrameSize = 1 << 20
+)
+
+var ErrCorrupted = errors.New("encrypted file is corrupted or was written with a different key")
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Builds the nonce for the given frame counter.
+func frameNonce(prefix []byte, counter uint32) []byte {
+	nonce := make([]byte, prefixSize+4)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], counter)
+	return nonce
+}
+
+// Reports whether the reader is positioned at the start of an encrypted file without consuming any input.
+func IsEncrypted(r *bufio.Reader) bool {
+	header, err := r.Peek(len(magic))
+	return err == nil && string(header) == magic
+}
+
+// Writer encrypts everything written to it. Each call to Write produces at least one frame,
+// so callers should write through a bufio.Writer when writing many small pieces.
+type Writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+}
+
+// Creates a Writer using the provider's current key and writes the segment header to w.
+func NewWriter(w io.Writer, keys KeyProvider) (*Writer, error) {
+	id, key, err := keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, prefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(magic)+1+len(id)+prefixSize)
+	header = append(header, magic...)
+	header = append(header, byte(len(id)))
+	header = append(header, id...)
+	header = append(header, prefix...)
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		w:      w,
+		aead:   aead,
+		header: header,
+		prefix: prefix,
+	}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), maxFrameSize)]
+
+		if w.counter == math.MaxUint32 {
+			return written, fmt.Errorf("encrypted segment is full")
+		}
+
+		sealed := w.aead.Seal(nil, frameNonce(w.prefix, w.counter), chunk, w.header)
+		w.counter++
+
+		frame := make([]byte, 5, 5+len(sealed))
+		frame[0] = frameTag
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(sealed)))
+		frame = append(frame, sealed...)
+
+		if _, err := w.w.Write(frame); err != nil {
+			return written, err
+		}
+
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+
+	return written, nil
+}
+
+// Reader decrypts a file produced by one or more Writers.
+type Reader struct {
+	r       *bufio.Reader
+	keys    KeyProvider
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	ids     []string
+}
+
+func NewReader(r io.Reader, keys KeyProvider) *Reader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	return &Reader{
+		r:    br,
+		keys: keys,
+	}
+}
+
+// Reads a segment header, the magic has already been peeked.
+func (r *Reader) readHeader() error {
+	fixed := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(r.r, fixed); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+
+	if string(fixed[:len(magic)]) != magic {
+		return ErrCorrupted
+	}
+
+	rest := make([]byte, int(fixed[len(magic)])+prefixSize)
+	if _, err := io.ReadFull(r.r, rest); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+
+	id := string(rest[:len(rest)-prefixSize])
+	key, err := r.keys.Key(id)
+	if err != nil {
+		return err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(r.ids, id) {
+		r.ids = append(r.ids, id)
+	}
+
+	r.aead = aead
+	r.header = append(fixed, rest...)
+	r.prefix = rest[len(rest)-prefixSize:]
+	r.counter = 0
+	return nil
+}
+
+// Reads and decrypts the next frame into the internal buffer.
+func (r *Reader) readFrame() error {
+	tag, err := r.r.Peek(1)
+	if err != nil {
+		return err // io.EOF at a record boundary is a clean end of file
+	}
+
+	if tag[0] == magic[0] {
+		return r.readHeader()
+	}
+
+	if tag[0] != frameTag || r.aead == nil {
+		return ErrCorrupted
+	}
+
+	frameHeader := make([]byte, 5)
+	if _, err := io.ReadFull(r.r, frameHeader); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+
+	size := binary.BigEndian.Uint32(frameHeader[1:])
+	if size > maxFrameSize+uint32(r.aead.Overhead()) {
+		return ErrCorrupted
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(r.r, sealed); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+
+	plain, err := r.aead.Open(sealed[:0], frameNonce(r.prefix, r.counter), sealed, r.header)
+	if err != nil {
+		return ErrCorrupted
+	}
+	r.counter++
+
+	r.buf = plain
+	return nil
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if err := r.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Returns the IDs of the keys used by the segments read so far, in the order they were first seen.
+func (r *Reader) KeyIDs() []string {
+	return r.ids
+}
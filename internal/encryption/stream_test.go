@@ -0,0 +1,215 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func newTestKeyring(t *testing.T, ids ...string) *Keyring {
+	t.Helper()
+
+	keyring := NewKeyring()
+	for i, id := range ids {
+		key := bytes.Repeat([]byte{byte(i + 1)}, 32)
+		if err := keyring.Add(id, key); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	return keyring
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks [][]byte
+	}{
+		{
+			name:   "single write",
+			chunks: [][]byte{[]byte("*1\r\n$4\r\nPING\r\n")},
+		},
+		{
+			name:   "multiple writes",
+			chunks: [][]byte{[]byte("hello "), []byte("encrypted "), []byte("world")},
+		},
+		{
+			name:   "write larger than a frame",
+			chunks: [][]byte{bytes.Repeat([]byte("x"), maxFrameSize*2+10)},
+		},
+		{
+			name:   "no writes",
+			chunks: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring := newTestKeyring(t, "k1")
+
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, keyring)
+			if err != nil {
+				t.Fatalf("NewWriter() error = %v", err)
+			}
+
+			var want []byte
+			for _, chunk := range tt.chunks {
+				if _, err := w.Write(chunk); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+				want = append(want, chunk...)
+			}
+
+			if len(want) > 0 && bytes.Contains(buf.Bytes(), want[:min(len(want), 16)]) {
+				t.Error("Expected ciphertext not to contain the plaintext")
+			}
+
+			got, err := io.ReadAll(NewReader(&buf, keyring))
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("Expected %d bytes of plaintext, got %d", len(want), len(got))
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	keyring := newTestKeyring(t, "old")
+
+	// First segment written with the old key
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, keyring)
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	w.Write([]byte("first "))
+
+	// Rotate and append a second segment with the new key
+	if err := keyring.Add("new", bytes.Repeat([]byte{9}, 32)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	w, err = NewWriter(&buf, keyring)
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	w.Write([]byte("second"))
+
+	r := NewReader(bytes.NewReader(buf.Bytes()), keyring)
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if string(got) != "first second" {
+		t.Errorf("Expected %q, got %q", "first second", got)
+	}
+	if ids := r.KeyIDs(); !slices.Equal(ids, []string{"old", "new"}) {
+		t.Errorf("Expected the segments to use keys [old new], got %v", ids)
+	}
+
+	// A keyring without the old key cannot read the first segment
+	newOnly := NewKeyring()
+	newOnly.Add("new", bytes.Repeat([]byte{9}, 32))
+	if _, err := io.ReadAll(NewReader(bytes.NewReader(buf.Bytes()), newOnly)); err == nil {
+		t.Error("Expected error when the old key is missing")
+	}
+}
+
+func TestTamperedData(t *testing.T) {
+	keyring := newTestKeyring(t, "k1")
+
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, keyring)
+	w.Write([]byte("sensitive data"))
+
+	data := buf.Bytes()
+	data[len(data)-1] ^= 0xff
+
+	_, err := io.ReadAll(NewReader(bytes.NewReader(data), keyring))
+	if !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Expected ErrCorrupted, got %v", err)
+	}
+}
+
+func TestTruncatedData(t *testing.T) {
+	keyring := newTestKeyring(t, "k1")
+
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, keyring)
+	w.Write([]byte("sensitive data"))
+
+	data := buf.Bytes()[:buf.Len()-3]
+	_, err := io.ReadAll(NewReader(bytes.NewReader(data), keyring))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestIsEncrypted(t *testing.T) {
+	keyring := newTestKeyring(t, "k1")
+
+	var buf bytes.Buffer
+	NewWriter(&buf, keyring)
+
+	if !IsEncrypted(bufio.NewReader(bytes.NewReader(buf.Bytes()))) {
+		t.Error("Expected encrypted data to be detected")
+	}
+	if IsEncrypted(bufio.NewReader(bytes.NewReader([]byte("*1\r\n$4\r\nPING\r\n")))) {
+		t.Error("Expected plaintext not to be detected as encrypted")
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	key1 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	key2 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 16))
+
+	path := filepath.Join(t.TempDir(), "keys")
+	content := "# rotated keys\n2024:" + key1 + "\n\n2025:" + key2 + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	keyring, err := LoadKeyFile(path)
+	if err != nil {
+		t.Fatalf("LoadKeyFile() error = %v", err)
+	}
+
+	id, key, err := keyring.CurrentKey()
+	if err != nil {
+		t.Fatalf("CurrentKey() error = %v", err)
+	}
+	if id != "2025" || len(key) != 16 {
+		t.Errorf("Expected current key 2025 with 16 bytes, got %s with %d bytes", id, len(key))
+	}
+
+	if _, err := keyring.Key("2024"); err != nil {
+		t.Errorf("Expected old key to be available, got %v", err)
+	}
+}
+
+func TestLoadKeyEnv(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+
+	t.Setenv("GOPHERSTORE_TEST_KEYS", "a:"+key+",b:"+key)
+	keyring, err := LoadKeyEnv("GOPHERSTORE_TEST_KEYS")
+	if err != nil {
+		t.Fatalf("LoadKeyEnv() error = %v", err)
+	}
+
+	id, _, _ := keyring.CurrentKey()
+	if id != "b" {
+		t.Errorf("Expected current key b, got %s", id)
+	}
+
+	t.Setenv("GOPHERSTORE_TEST_KEYS", "a:"+base64.StdEncoding.EncodeToString([]byte("short")))
+	if _, err := LoadKeyEnv("GOPHERSTORE_TEST_KEYS"); err == nil {
+		t.Error("Expected error for invalid key length")
+	}
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"expvar"
+	"sync"
+)
+
+// ExpvarSink publishes the last metrics reported as an expvar variable, a JSON object mapping the
+// metric names to their values served with the other variables by expvar.Handler, e.g. under
+// /debug/vars.
+type ExpvarSink struct {
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Creates a sink published as the expvar variable name. Panics if the name is already used, like
+// expvar.Publish.
+func NewExpvarSink(name string) *ExpvarSink {
+	sink := &ExpvarSink{values: make(map[string]float64)}
+	expvar.Publish(name, expvar.Func(sink.snapshot))
+	return sink
+}
+
+func (s *ExpvarSink) Report(metrics []Metric) error {
+	values := make(map[string]float64, len(metrics))
+	for _, m := range metrics {
+		values[m.Name] = m.Value
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = values
+	return nil
+}
+
+// Returns the last metrics reported, the value of the expvar variable.
+func (s *ExpvarSink) snapshot() any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.values
+}
@@ -0,0 +1,97 @@
+// Package metrics reports the metrics of a server to monitoring systems. A Reporter collects the
+// metrics at a regular interval and passes them to every Sink, e.g. expvar or StatsD, so each team
+// can consume them with its own stack without running an exporter next to the server.
+package metrics
+
+import (
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Kind tells sinks how to aggregate a metric.
+type Kind uint8
+
+const (
+	Gauge   Kind = iota // A value that goes up and down, e.g. connected clients
+	Counter             // A total that only goes up since the server started, e.g. commands processed
+)
+
+// Metric is a value collected from the server.
+type Metric struct {
+	Name  string // Lowercase words separated by underscores, e.g. connected_clients
+	Kind  Kind
+	Value float64
+}
+
+// Sink receives the metrics collected by a Reporter. Report is called from a single goroutine.
+type Sink interface {
+	Report(metrics []Metric) error
+}
+
+// Reporter passes the metrics returned by collect to the sinks at a regular interval.
+type Reporter struct {
+	collect  func() []Metric
+	interval time.Duration
+	sinks    []Sink
+	logger   *slog.Logger
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Creates a reporter passing the metrics to the sinks every interval once started.
+func NewReporter(collect func() []Metric, interval time.Duration, logger *slog.Logger, sinks ...Sink) *Reporter {
+	return &Reporter{
+		collect:  collect,
+		interval: interval,
+		sinks:    sinks,
+		logger:   logger,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Reports the metrics right away and then every interval in the background, until Stop.
+func (r *Reporter) Start() {
+	r.report()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.report()
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stops reporting, after reporting the metrics one last time so sinks see the final totals. Sinks
+// implementing io.Closer are then closed.
+func (r *Reporter) Stop() {
+	close(r.stopCh)
+	r.wg.Wait()
+	r.report()
+
+	for _, sink := range r.sinks {
+		if closer, ok := sink.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+// Passes the current metrics to every sink. A failing sink does not stop the others.
+func (r *Reporter) report() {
+	metrics := r.collect()
+	for _, sink := range r.sinks {
+		if err := sink.Report(metrics); err != nil {
+			r.logger.Warn("failed to report metrics", "error", err)
+		}
+	}
+}
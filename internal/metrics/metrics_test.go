@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Sink recording the reports it receives.
+type recordingSink struct {
+	reports atomic.Int64
+	last    atomic.Value
+}
+
+func (s *recordingSink) Report(metrics []Metric) error {
+	s.reports.Add(1)
+	s.last.Store(metrics)
+	return nil
+}
+
+func TestReporter(t *testing.T) {
+	var total atomic.Int64
+	collect := func() []Metric {
+		return []Metric{{Name: "commands_processed", Kind: Counter, Value: float64(total.Add(1))}}
+	}
+	sink := &recordingSink{}
+	reporter := NewReporter(collect, 10*time.Millisecond, slog.New(slog.NewTextHandler(io.Discard, nil)), sink)
+
+	reporter.Start()
+	if sink.reports.Load() != 1 {
+		t.Errorf("Expected the metrics to be reported when starting, got %d reports", sink.reports.Load())
+	}
+	time.Sleep(50 * time.Millisecond)
+	reporter.Stop()
+
+	reports := sink.reports.Load()
+	if reports < 3 {
+		t.Errorf("Expected the metrics to be reported every interval, got %d reports", reports)
+	}
+	if last := sink.last.Load().([]Metric); last[0].Value != float64(total.Load()) {
+		t.Errorf("Expected the final totals to be reported when stopping, got %v", last)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if sink.reports.Load() != reports {
+		t.Error("Expected no reports once stopped")
+	}
+}
+
+// Variables published by the expvar tests, which cannot be published twice when tests are repeated.
+var expvarTests atomic.Int64
+
+func TestExpvarSink(t *testing.T) {
+	name := "metrics_test_" + strconv.FormatInt(expvarTests.Add(1), 10)
+	sink := NewExpvarSink(name)
+	sink.Report([]Metric{{Name: "connected_clients", Kind: Gauge, Value: 3}, {Name: "commands_processed", Kind: Counter, Value: 42}})
+
+	var values map[string]float64
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &values); err != nil {
+		t.Fatal(err)
+	}
+	if values["connected_clients"] != 3 || values["commands_processed"] != 42 {
+		t.Errorf("Expected the reported values, got %v", values)
+	}
+}
+
+func TestStatsDSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink, err := NewStatsDSink(conn.LocalAddr().String(), "gopherstore.", []string{"env:test", "role:primary"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	receive := func() string {
+		buf := make([]byte, maxPacketSize)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+
+	report := func(clients, commands float64) {
+		err := sink.Report([]Metric{
+			{Name: "connected_clients", Kind: Gauge, Value: clients},
+			{Name: "commands_processed", Kind: Counter, Value: commands},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report(2, 10)
+	expected := "gopherstore.connected_clients:2|g|#env:test,role:primary\ngopherstore.commands_processed:10|c|#env:test,role:primary"
+	if packet := receive(); packet != expected {
+		t.Errorf("Expected %q, got %q", expected, packet)
+	}
+
+	// Counters are sent as their increase since the previous report
+	report(1, 25)
+	if packet := receive(); !strings.Contains(packet, "gopherstore.commands_processed:15|c") {
+		t.Errorf("Expected the increase of the counter, got %q", packet)
+	}
+}
+
+func TestStatsDSinkSplitsPackets(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink, err := NewStatsDSink(conn.LocalAddr().String(), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	metrics := make([]Metric, 200)
+	for i := range metrics {
+		metrics[i] = Metric{Name: strings.Repeat("x", 20), Kind: Gauge, Value: float64(i)}
+	}
+	if err := sink.Report(metrics); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := 0
+	buf := make([]byte, 65536)
+	for lines < len(metrics) {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Expected %d metrics, got %d (%v)", len(metrics), lines, err)
+		}
+		if n > maxPacketSize {
+			t.Errorf("Expected datagrams of at most %d bytes, got %d", maxPacketSize, n)
+		}
+		lines += strings.Count(string(buf[:n]), "\n") + 1
+	}
+}
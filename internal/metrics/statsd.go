@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Largest payload sent in one datagram, below the usual MTU so packets are not fragmented.
+const maxPacketSize = 1432
+
+// StatsDSink sends the metrics to a StatsD server over UDP. Gauges are sent as gauges and counters
+// as their increase since the previous report. Tags are sent in the DogStatsD format understood by
+// the Datadog agent, which plain StatsD servers do not support.
+type StatsDSink struct {
+	conn   net.Conn
+	prefix string
+	tags   string
+	last   map[string]float64 // Counter totals of the previous report
+}
+
+// Creates a sink sending to the StatsD server at addr. Metric names are prefixed with prefix, e.g.
+// "gopherstore.", and tagged with tags, e.g. "env:prod".
+func NewStatsDSink(addr, prefix string, tags []string) (*StatsDSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &StatsDSink{
+		conn:   conn,
+		prefix: prefix,
+		last:   make(map[string]float64),
+	}
+	if len(tags) > 0 {
+		sink.tags = "|#" + strings.Join(tags, ",")
+	}
+	return sink, nil
+}
+
+// Sends the metrics, several per datagram. Keeps sending after a failed datagram and returns the
+// first error.
+func (s *StatsDSink) Report(metrics []Metric) error {
+	var firstErr error
+	packet := make([]byte, 0, maxPacketSize)
+	send := func() {
+		if len(packet) == 0 {
+			return
+		}
+		if _, err := s.conn.Write(packet); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		packet = packet[:0]
+	}
+
+	for _, m := range metrics {
+		value, kind := m.Value, "g"
+		if m.Kind == Counter {
+			// A total lower than the previous one means the counter was reset
+			if delta := m.Value - s.last[m.Name]; delta >= 0 {
+				value = delta
+			}
+			s.last[m.Name] = m.Value
+			kind = "c"
+		}
+
+		line := s.prefix + m.Name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + kind + s.tags
+		if len(packet) > 0 && len(packet)+1+len(line) > maxPacketSize {
+			send()
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	send()
+
+	return firstErr
+}
+
+// Closes the connection to the StatsD server.
+func (s *StatsDSink) Close() error {
+	return s.conn.Close()
+}
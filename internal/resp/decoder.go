@@ -2,8 +2,12 @@ package resp
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"strconv"
 	"strings"
 )
@@ -15,7 +19,7 @@ const (
 
 // Checks if bytes at the given offset end with \r\n.
 func hasValidTerminator(bytes []byte, offset int) bool {
-	return len(bytes) > offset+1 && bytes[offset] == '\r' && bytes[offset+1] == '\n'
+	return offset >= 0 && len(bytes) > offset+1 && bytes[offset] == '\r' && bytes[offset+1] == '\n'
 }
 
 func readAndParseLength(r *bufio.Reader) (int, error) {
@@ -31,39 +35,134 @@ func readAndParseLength(r *bufio.Reader) (int, error) {
 	if err != nil {
 		return 0, &RESPError{Msg: "invalid length", Err: err}
 	}
+	if count < -1 {
+		return 0, &RESPError{Msg: "invalid length"}
+	}
 	return count, nil
 }
 
+// Limits on the values read from a peer, so a single frame cannot make the reader allocate more than
+// the peer sends or nest arrays without bound. A zero field disables that limit.
+type Limits struct {
+	MaxBulkLength  int // Maximum length of a bulk string in bytes
+	MaxArrayLength int // Maximum number of elements of an array
+	MaxDepth       int // Maximum nesting of arrays, 1 for an array of non-array values
+}
+
+// Limits applied to clients by default. The bulk length matches the default proto-max-bulk-len of Redis.
+var DefaultLimits = Limits{
+	MaxBulkLength:  512 * 1024 * 1024,
+	MaxArrayLength: 1024 * 1024,
+	MaxDepth:       32,
+}
+
+// Bulk strings up to this length are allocated at once, longer ones grow as their data arrives.
+const bulkChunkSize = 64 * 1024
+
 // Reads an array from the RESP protocol.
 func ReadArray(r *bufio.Reader) (RespArray, error) {
-	count, err := readAndParseLength(r)
+	return readArray(r, Limits{}, 1)
+}
+
+func readArray(r *bufio.Reader, limits Limits, depth int) (RespArray, error) {
+	value, count, err := readArrayHeader(r, limits, depth)
 	if err != nil {
 		return RespArray{}, err
 	}
+	if value != nil {
+		return value.(RespArray), nil
+	}
+
+	return readElements(count, depth, func(depth int) (RespValue, int, error) {
+		return readNext(r, limits, depth)
+	})
+}
+
+// Reads the length of an array nested at depth, once its prefix was read. Returns a null array, or
+// the number of elements that follow with a nil value.
+func readArrayHeader(r *bufio.Reader, limits Limits, depth int) (RespValue, int, error) {
+	count, err := readAndParseLength(r)
+	if err != nil {
+		return nil, 0, err
+	}
 
 	// Handle null array case.
 	if count == -1 {
-		return RespArray{Elements: nil}, nil
+		return RespArray{Elements: nil}, 0, nil
 	}
 
-	// Once we have the actual cound, we read each element and recursively call ReadRESP to append to the array.
-	elements := make([]RespValue, 0, count)
-	for range count {
-		// Parse each individual element in the array and handle any errors.
-		elem, err := ReadRESP(r)
-		if err != nil {
-			return RespArray{}, err
-		}
+	if err := checkArray(count, depth, limits); err != nil {
+		return nil, 0, err
+	}
+	return nil, count, nil
+}
 
-		// Append the parsed element to the elements slice.
-		elements = append(elements, elem)
+// An array whose elements are being read.
+type pendingArray struct {
+	elements  []RespValue
+	remaining int
+}
+
+// Reads the count elements of an array nested at depth. next reads the value nested at the given
+// depth, or only the length of an array, with a nil value. Nested arrays are kept on an explicit
+// stack rather than read recursively, so a frame of deeply nested arrays cannot exhaust the
+// goroutine stack, whatever the depth limit.
+func readElements(count, depth int, next func(depth int) (RespValue, int, error)) (RespArray, error) {
+	// Most frames nest a few arrays at most, which fit without allocating the stack
+	var buf [8]pendingArray
+	stack := buf[:0]
+
+	for {
+		// The capacity is capped since the peer may send fewer elements than announced
+		stack = append(stack, pendingArray{elements: make([]RespValue, 0, min(count, 1024)), remaining: count})
+
+		for {
+			top := &stack[len(stack)-1]
+			if top.remaining == 0 {
+				// Add the completed array to its parent
+				arr := RespArray{Elements: top.elements}
+				stack = stack[:len(stack)-1]
+				if len(stack) == 0 {
+					return arr, nil
+				}
+				top = &stack[len(stack)-1]
+				top.elements = append(top.elements, arr)
+				top.remaining--
+				continue
+			}
+
+			value, n, err := next(depth + len(stack))
+			if err != nil {
+				return RespArray{}, err
+			}
+			if value == nil {
+				// Read the elements of the nested array first
+				count = n
+				break
+			}
+			top.elements = append(top.elements, value)
+			top.remaining--
+		}
 	}
+}
 
-	return RespArray{Elements: elements}, nil
+// Checks an array of count elements nested at depth against the limits.
+func checkArray(count, depth int, limits Limits) error {
+	if limits.MaxArrayLength > 0 && count > limits.MaxArrayLength {
+		return &RESPError{Msg: fmt.Sprintf("array length %d exceeds the limit of %d", count, limits.MaxArrayLength)}
+	}
+	if limits.MaxDepth > 0 && depth > limits.MaxDepth {
+		return &RESPError{Msg: fmt.Sprintf("arrays nested deeper than the limit of %d", limits.MaxDepth)}
+	}
+	return nil
 }
 
 // Reads a bulk string from the RESP protocol.
 func ReadBulkString(r *bufio.Reader) (RespBulkString, error) {
+	return readBulkString(r, Limits{})
+}
+
+func readBulkString(r *bufio.Reader, limits Limits) (RespBulkString, error) {
 	count, err := readAndParseLength(r)
 	if err != nil {
 		return RespBulkString{}, err
@@ -73,19 +172,46 @@ func ReadBulkString(r *bufio.Reader) (RespBulkString, error) {
 		return RespBulkString{Value: nil}, nil
 	}
 
-	bytes := make([]byte, count+2) // +2 for \r\n
-	_, err = io.ReadFull(r, bytes)
+	value, err := readBulkData(r, count, limits)
 	if err != nil {
 		return RespBulkString{}, err
 	}
+	return RespBulkString{Value: value}, nil
+}
+
+// Reads the count bytes of a bulk string and its terminator, once the length was read.
+func readBulkData(r *bufio.Reader, count int, limits Limits) ([]byte, error) {
+	if limits.MaxBulkLength > 0 && count > limits.MaxBulkLength {
+		return nil, &RESPError{
+			Msg: fmt.Sprintf("bulk string length %d exceeds the limit of %d", count, limits.MaxBulkLength),
+			Err: bulkTooLongError{count},
+		}
+	}
+
+	var data []byte
+	var err error
+	if count <= bulkChunkSize {
+		data = make([]byte, count+2) // +2 for \r\n
+		_, err = io.ReadFull(r, data)
+	} else {
+		// Grow the value as the data arrives instead of trusting the announced length
+		var buf bytes.Buffer
+		_, err = io.CopyN(&buf, r, int64(count+2))
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		data = buf.Bytes()
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	// Ensure that it ends with \r\n
-	if !hasValidTerminator(bytes, count) {
-		return RespBulkString{}, &RESPError{Msg: "bulk string not terminated properly"}
+	if !hasValidTerminator(data, count) {
+		return nil, &RESPError{Msg: "bulk string not terminated properly"}
 	}
 
-	value := bytes[:count]
-	return RespBulkString{Value: value}, nil
+	return data[:count], nil
 }
 
 func ReadSimpleString(r *bufio.Reader) (RespSimpleString, error) {
@@ -138,25 +264,426 @@ func ReadInteger(r *bufio.Reader) (RespInteger, error) {
 	return RespInteger{Value: value}, nil
 }
 
+// Reads a RESP3 double, including inf, -inf and nan.
+func ReadDouble(r *bufio.Reader) (RespDouble, error) {
+	line, err := r.ReadString(terminator)
+	if err != nil {
+		return RespDouble{}, err
+	}
+
+	if !hasValidTerminator([]byte(line), len(line)-2) {
+		return RespDouble{}, &RESPError{Msg: "double not terminated properly"}
+	}
+
+	return parseDouble([]byte(strings.TrimSuffix(line, "\r\n")))
+}
+
+func parseDouble(b []byte) (RespDouble, error) {
+	value, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return RespDouble{}, &RESPError{Msg: "invalid double", Err: err}
+	}
+	return RespDouble{Value: value}, nil
+}
+
+// Reads a RESP3 verbatim string, made of a three letter format, a colon and the string.
+func ReadVerbatimString(r *bufio.Reader) (RespVerbatimString, error) {
+	return readVerbatimString(r, Limits{})
+}
+
+func readVerbatimString(r *bufio.Reader, limits Limits) (RespVerbatimString, error) {
+	count, err := readAndParseLength(r)
+	if err != nil {
+		return RespVerbatimString{}, err
+	}
+	return readVerbatimData(r, count, limits)
+}
+
+func readVerbatimData(r *bufio.Reader, count int, limits Limits) (RespVerbatimString, error) {
+	if count < 4 {
+		return RespVerbatimString{}, &RESPError{Msg: "invalid verbatim string length"}
+	}
+
+	data, err := readBulkData(r, count, limits)
+	if err != nil {
+		return RespVerbatimString{}, err
+	}
+	if data[3] != ':' {
+		return RespVerbatimString{}, &RESPError{Msg: "invalid verbatim string format"}
+	}
+	return RespVerbatimString{Format: string(data[:3]), Value: data[4:]}, nil
+}
+
+// Reads a RESP3 big number.
+func ReadBigNumber(r *bufio.Reader) (RespBigNumber, error) {
+	line, err := r.ReadString(terminator)
+	if err != nil {
+		return RespBigNumber{}, err
+	}
+
+	if !hasValidTerminator([]byte(line), len(line)-2) {
+		return RespBigNumber{}, &RESPError{Msg: "big number not terminated properly"}
+	}
+
+	return parseBigNumber([]byte(strings.TrimSuffix(line, "\r\n")))
+}
+
+func parseBigNumber(b []byte) (RespBigNumber, error) {
+	value, ok := new(big.Int).SetString(string(b), 10)
+	if !ok {
+		return RespBigNumber{}, &RESPError{Msg: "invalid big number"}
+	}
+	return RespBigNumber{Value: value}, nil
+}
+
 // Reads a RESP value from the reader.
 func ReadRESP(r *bufio.Reader) (RespValue, error) {
+	return readValue(r, Limits{}, 1)
+}
+
+// Reads a RESP value from the reader, failing with a RESPError if it exceeds the limits. Used to
+// read from untrusted peers.
+func ReadRESPWithLimits(r *bufio.Reader, limits Limits) (RespValue, error) {
+	return readValue(r, limits, 1)
+}
+
+func readValue(r *bufio.Reader, limits Limits, depth int) (RespValue, error) {
+	next := func(depth int) (RespValue, int, error) {
+		return readNext(r, limits, depth)
+	}
+
+	value, count, err := next(depth)
+	if err != nil || value != nil {
+		return value, err
+	}
+	return readElements(count, depth, next)
+}
+
+// Reads the value nested at depth, or only the length of an array, with a nil value.
+func readNext(r *bufio.Reader, limits Limits, depth int) (RespValue, int, error) {
 	prefix, err := r.ReadByte()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
+	var value RespValue
 	switch prefix {
 	case '*':
-		return ReadArray(r)
+		return readArrayHeader(r, limits, depth)
 	case '$':
-		return ReadBulkString(r)
+		value, err = readBulkString(r, limits)
 	case '+':
-		return ReadSimpleString(r)
+		value, err = ReadSimpleString(r)
 	case '-':
-		return ReadError(r)
+		value, err = ReadError(r)
 	case ':':
-		return ReadInteger(r)
+		value, err = ReadInteger(r)
+	case ',':
+		value, err = ReadDouble(r)
+	case '=':
+		value, err = readVerbatimString(r, limits)
+	case '(':
+		value, err = ReadBigNumber(r)
+	default:
+		err = &RESPError{Msg: fmt.Sprintf("unknown RESP type prefix: %c", prefix)}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, 0, nil
+}
+
+// Returned by Decoder.DecodeArray when the value read is not an array.
+var ErrNotArray = errors.New("expected an array")
+
+// Wrapped by the RESPError returned by Decoder.DecodeArray when a command exceeds the limit on the
+// length of bulk strings or arrays. The lengths announced say where the command ends, so it was
+// discarded up to there and the next command can be read. After any other RESPError, where the next
+// command starts is unknown and the input cannot be read further.
+var ErrCommandDiscarded = errors.New("command discarded")
+
+// Wrapped by the RESPError of a bulk string over the length limit, returned before its data is read.
+type bulkTooLongError struct {
+	length int
+}
+
+func (e bulkTooLongError) Error() string {
+	return fmt.Sprintf("bulk string length %d exceeds the limit", e.length)
+}
+
+// Decoder reads RESP values from a reader, reusing its buffers between values. Unlike ReadRESP,
+// lengths, integers and the elements of arrays read with DecodeArray are parsed without allocating,
+// which matters for connections sending many small commands. Bulk string values are still allocated
+// one by one since they outlive the decoder, e.g. when stored as keys or values.
+type Decoder struct {
+	r       *bufio.Reader
+	limits  Limits
+	scratch []byte // Holds lines longer than the reader buffer
+}
+
+// Creates a decoder reading from r and rejecting values that exceed the limits.
+func NewDecoder(r *bufio.Reader, limits Limits) *Decoder {
+	return &Decoder{r: r, limits: limits}
+}
+
+// Reads the next value.
+func (d *Decoder) Decode() (RespValue, error) {
+	return d.decode(1)
+}
+
+// Reads the next value into dst, which must be an array. The elements slice of dst is reused and
+// overwritten by the next call, but the elements themselves are not, so they can be kept. Any other
+// value is read and discarded, and ErrNotArray is returned.
+func (d *Decoder) DecodeArray(dst *RespArray) error {
+	prefix, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if prefix != '*' {
+		if err := d.r.UnreadByte(); err != nil {
+			return err
+		}
+		if _, err := d.decode(1); err != nil {
+			return err
+		}
+		return ErrNotArray
+	}
+
+	count, err := d.readLength()
+	if err != nil {
+		return err
+	}
+	if count == -1 {
+		dst.Elements = nil
+		return nil
+	}
+	if err := checkArray(count, 1, d.limits); err != nil {
+		// At the top level, only the length of the array can exceed the limits
+		return d.discardCommand(0, count, err)
+	}
+
+	elements := dst.Elements[:0]
+	for i := range count {
+		elem, skip, err := d.element()
+		if err != nil {
+			if skip > 0 {
+				return d.discardCommand(skip, count-i-1, err)
+			}
+			return err
+		}
+		elements = append(elements, elem)
+	}
+
+	// Clear the elements of the previous array so they can be garbage collected
+	clear(elements[len(elements):cap(elements)])
+	dst.Elements = elements
+	return nil
+}
+
+// Reads an element of a command. For a bulk string over the length limit, whose data was not read,
+// also returns the number of bytes left until the next element.
+func (d *Decoder) element() (RespValue, int, error) {
+	prefix, err := d.r.Peek(1)
+	if err != nil {
+		return nil, 0, err
+	}
+	isBulk := prefix[0] == '$'
+
+	value, err := d.decode(2)
+	if err != nil && isBulk {
+		var tooLong bulkTooLongError
+		if errors.As(err, &tooLong) {
+			return nil, tooLong.length + 2, err // +2 for \r\n
+		}
+	}
+	return value, 0, err
+}
+
+// Discards the rest of a command rejected with err, the skip bytes left of the current element then
+// the n elements after it, without keeping them. Returns err wrapping ErrCommandDiscarded once the
+// input is at the next command, or the error that prevented reaching it.
+func (d *Decoder) discardCommand(skip, n int, err error) error {
+	for {
+		if _, err := d.r.Discard(skip); err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		n--
+
+		var elemErr error
+		if _, skip, elemErr = d.element(); elemErr != nil && skip == 0 {
+			return elemErr
+		}
+	}
+
+	return &RESPError{Msg: err.Error(), Err: ErrCommandDiscarded}
+}
+
+func (d *Decoder) decode(depth int) (RespValue, error) {
+	value, count, err := d.next(depth)
+	if err != nil || value != nil {
+		return value, err
+	}
+	return readElements(count, depth, d.next)
+}
+
+// Reads the value nested at depth, or only the length of an array, with a nil value.
+func (d *Decoder) next(depth int) (RespValue, int, error) {
+	prefix, err := d.r.ReadByte()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if prefix == '*' {
+		count, err := d.readLength()
+		if err != nil {
+			return nil, 0, err
+		}
+		if count == -1 {
+			return RespArray{Elements: nil}, 0, nil
+		}
+		if err := checkArray(count, depth, d.limits); err != nil {
+			return nil, 0, err
+		}
+		return nil, count, nil
+	}
+
+	value, err := d.decodeScalar(prefix)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, 0, nil
+}
+
+// Reads a value other than an array, once its prefix was read.
+func (d *Decoder) decodeScalar(prefix byte) (RespValue, error) {
+	switch prefix {
+	case '$':
+		count, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		if count == -1 {
+			return RespBulkString{Value: nil}, nil
+		}
+		value, err := readBulkData(d.r, count, d.limits)
+		if err != nil {
+			return nil, err
+		}
+		return RespBulkString{Value: value}, nil
+	case '+':
+		line, err := d.readLine("simple string not terminated properly")
+		if err != nil {
+			return nil, err
+		}
+		return RespSimpleString{Value: string(line)}, nil
+	case '-':
+		line, err := d.readLine("error not terminated properly")
+		if err != nil {
+			return nil, err
+		}
+		return RespErrorValue{Message: string(line)}, nil
+	case ':':
+		line, err := d.readLine("integer not terminated properly")
+		if err != nil {
+			return nil, err
+		}
+		value, ok := parseInt(line)
+		if !ok {
+			return nil, &RESPError{Msg: "invalid integer"}
+		}
+		return RespInteger{Value: value}, nil
+	case ',':
+		line, err := d.readLine("double not terminated properly")
+		if err != nil {
+			return nil, err
+		}
+		return parseDouble(line)
+	case '=':
+		count, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		return readVerbatimData(d.r, count, d.limits)
+	case '(':
+		line, err := d.readLine("big number not terminated properly")
+		if err != nil {
+			return nil, err
+		}
+		return parseBigNumber(line)
 	default:
 		return nil, &RESPError{Msg: fmt.Sprintf("unknown RESP type prefix: %c", prefix)}
 	}
 }
+
+// Reads the length of an array or bulk string.
+func (d *Decoder) readLength() (int, error) {
+	line, err := d.readLine("invalid length")
+	if err != nil {
+		return 0, err
+	}
+
+	count, ok := parseInt(line)
+	if !ok || count < -1 || count > math.MaxInt32 {
+		return 0, &RESPError{Msg: "invalid length"}
+	}
+	return int(count), nil
+}
+
+// Returns the next line without its terminator, failing with msg if it does not end with \r\n.
+// The line is only valid until the next read.
+func (d *Decoder) readLine(msg string) ([]byte, error) {
+	line, err := d.r.ReadSlice(terminator)
+	if err == bufio.ErrBufferFull {
+		// Lines longer than the reader buffer are only expected in simple strings and errors
+		d.scratch = append(d.scratch[:0], line...)
+		for err == bufio.ErrBufferFull {
+			if d.limits.MaxBulkLength > 0 && len(d.scratch) > d.limits.MaxBulkLength {
+				return nil, &RESPError{Msg: fmt.Sprintf("line length exceeds the limit of %d", d.limits.MaxBulkLength)}
+			}
+			line, err = d.r.ReadSlice(terminator)
+			d.scratch = append(d.scratch, line...)
+		}
+		line = d.scratch
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasValidTerminator(line, len(line)-2) {
+		return nil, &RESPError{Msg: msg}
+	}
+	return line[:len(line)-2], nil
+}
+
+// Parses a base 10 integer, without the allocations of strconv for byte slices.
+func parseInt(b []byte) (int64, bool) {
+	negative := len(b) > 0 && b[0] == '-'
+	if negative || len(b) > 0 && b[0] == '+' {
+		b = b[1:]
+	}
+	if len(b) == 0 {
+		return 0, false
+	}
+
+	var n uint64
+	for _, c := range b {
+		if c < '0' || c > '9' || n > 1<<63/10 {
+			return 0, false
+		}
+		n = n*10 + uint64(c-'0')
+	}
+
+	if negative {
+		if n > 1<<63 {
+			return 0, false
+		}
+		return -int64(n), true
+	}
+	if n > math.MaxInt64 {
+		return 0, false
+	}
+	return int64(n), true
+}
@@ -3,6 +3,9 @@ package resp
 import (
 	"bufio"
 	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"strings"
 	"testing"
 )
@@ -53,6 +56,13 @@ func TestReadAndParseLength(t *testing.T) {
 			wantErr:     true,
 			errContains: "invalid length",
 		},
+		{
+			name:        "negative length below -1",
+			input:       "-5\r\n",
+			want:        0,
+			wantErr:     true,
+			errContains: "invalid length",
+		},
 	}
 
 	for _, tt := range tests {
@@ -729,3 +739,458 @@ func TestReadRESP(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeArrayDiscardsCommandsOverLimits(t *testing.T) {
+	limits := Limits{MaxBulkLength: 8, MaxArrayLength: 3, MaxDepth: 2}
+	injected := "\r\n*1\r\n$4\r\nPING\r\n"
+	next := "*1\r\n$4\r\nECHO\r\n"
+
+	tests := []struct {
+		name    string
+		input   string
+		discard bool // Whether the command is discarded rather than leaving the input out of sync
+	}{
+		{
+			name:    "bulk string over the limit",
+			input:   "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$" + fmt.Sprint(len(injected)) + "\r\n" + injected + "\r\n",
+			discard: true,
+		},
+		{
+			name:    "elements after a bulk string over the limit",
+			input:   "*3\r\n$" + fmt.Sprint(len(injected)) + "\r\n" + injected + "\r\n$9\r\n123456789\r\n*1\r\n:1\r\n",
+			discard: true,
+		},
+		{
+			name:    "array over the limit",
+			input:   "*4\r\n$1\r\na\r\n$1\r\nb\r\n$" + fmt.Sprint(len(injected)) + "\r\n" + injected + "\r\n$1\r\nd\r\n",
+			discard: true,
+		},
+		{
+			name:  "bulk string over the limit in a nested array",
+			input: "*1\r\n*1\r\n$9\r\n123456789\r\n",
+		},
+		{
+			name:  "invalid length",
+			input: "*1\r\n$abc\r\n",
+		},
+		{
+			name:  "bulk string not terminated",
+			input: "*1\r\n$4\r\nPINGxx\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder(bufio.NewReader(strings.NewReader(tt.input+next)), limits)
+
+			var arr RespArray
+			err := d.DecodeArray(&arr)
+			var respErr *RESPError
+			if !errors.As(err, &respErr) {
+				t.Fatalf("DecodeArray() error = %v, want a RESPError", err)
+			}
+			if discarded := errors.Is(err, ErrCommandDiscarded); discarded != tt.discard {
+				t.Fatalf("DecodeArray() error = %v, discarded = %v, want %v", err, discarded, tt.discard)
+			}
+			if !tt.discard {
+				return
+			}
+
+			// The command sent as a value is never read as one
+			if err := d.DecodeArray(&arr); err != nil {
+				t.Fatal(err)
+			}
+			if len(arr.Elements) != 1 || string(arr.Elements[0].(RespBulkString).Value) != "ECHO" {
+				t.Errorf("DecodeArray() = %v, want the next command", arr)
+			}
+		})
+	}
+
+	// The data announced must still arrive
+	d := NewDecoder(bufio.NewReader(strings.NewReader("*1\r\n$100\r\nshort\r\n")), limits)
+	var arr RespArray
+	if err := d.DecodeArray(&arr); err != io.EOF {
+		t.Errorf("DecodeArray() error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestReadRESPWithLimits(t *testing.T) {
+	limits := Limits{MaxBulkLength: 5, MaxArrayLength: 2, MaxDepth: 2}
+
+	tests := []struct {
+		name        string
+		input       string
+		wantErr     bool
+		errContains string
+	}{
+		{
+			name:  "within the limits",
+			input: "*2\r\n$5\r\nhello\r\n*1\r\n:1\r\n",
+		},
+		{
+			name:        "bulk string too long",
+			input:       "$6\r\nhello!\r\n",
+			wantErr:     true,
+			errContains: "bulk string length 6 exceeds the limit of 5",
+		},
+		{
+			name:        "bulk string too long inside an array",
+			input:       "*1\r\n$1000000000\r\n",
+			wantErr:     true,
+			errContains: "exceeds the limit of 5",
+		},
+		{
+			name:        "array too long",
+			input:       "*3\r\n:1\r\n:2\r\n:3\r\n",
+			wantErr:     true,
+			errContains: "array length 3 exceeds the limit of 2",
+		},
+		{
+			name:        "arrays nested too deep",
+			input:       "*1\r\n*1\r\n*1\r\n:1\r\n",
+			wantErr:     true,
+			errContains: "nested deeper than the limit of 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.input))
+			_, err := ReadRESPWithLimits(r, limits)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadRESPWithLimits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("ReadRESPWithLimits() error = %v, should contain %q", err, tt.errContains)
+			}
+		})
+	}
+}
+
+func TestReadBulkStringLargerThanChunk(t *testing.T) {
+	value := strings.Repeat("x", bulkChunkSize+1)
+	r := bufio.NewReader(strings.NewReader(fmt.Sprintf("%d\r\n%s\r\n", len(value), value)))
+	got, err := ReadBulkString(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.Value) != value {
+		t.Errorf("ReadBulkString() returned %d bytes, want %d", len(got.Value), len(value))
+	}
+
+	// The announced length is not allocated when the data never arrives
+	r = bufio.NewReader(strings.NewReader("1000000000\r\nshort\r\n"))
+	if _, err := ReadBulkString(r); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadBulkString() error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestDecoderMatchesReadRESP(t *testing.T) {
+	inputs := []string{
+		"*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n",
+		"*2\r\n*1\r\n:1\r\n$-1\r\n",
+		"*-1\r\n",
+		"*0\r\n",
+		"$0\r\n\r\n",
+		"+OK\r\n",
+		"-ERR wrong\r\n",
+		":-9223372036854775808\r\n",
+		":+7\r\n",
+		"+" + strings.Repeat("x", 10000) + "\r\n",
+		",-1.5e10\r\n",
+		",nan\r\n",
+		"=8\r\ntxt:a\r\nb\r\n",
+		"(-123456789012345678901234567890\r\n",
+	}
+
+	for _, input := range inputs {
+		want, err := ReadRESP(bufio.NewReader(strings.NewReader(input)))
+		if err != nil {
+			t.Fatalf("ReadRESP(%q) error = %v", input, err)
+		}
+		got, err := NewDecoder(bufio.NewReader(strings.NewReader(input)), Limits{}).Decode()
+		if err != nil {
+			t.Fatalf("Decode(%q) error = %v", input, err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Decode(%q) = %v, want %v", input, got, want)
+		}
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		errContains string
+	}{
+		{name: "invalid length", input: "*x\r\n", errContains: "invalid length"},
+		{name: "negative length", input: "$-2\r\n", errContains: "invalid length"},
+		{name: "length without CR", input: "*1\n", errContains: "invalid length"},
+		{name: "invalid integer", input: ":12a\r\n", errContains: "invalid integer"},
+		{name: "integer overflow", input: ":9223372036854775808\r\n", errContains: "invalid integer"},
+		{name: "empty line", input: "+\n", errContains: "simple string not terminated properly"},
+		{name: "bad bulk terminator", input: "$2\r\nabcd", errContains: "bulk string not terminated properly"},
+		{name: "unknown prefix", input: "?\r\n", errContains: "unknown RESP type prefix"},
+		{name: "invalid double", input: ",1.2.3\r\n", errContains: "invalid double"},
+		{name: "verbatim string without format", input: "=3\r\nabc\r\n", errContains: "invalid verbatim string length"},
+		{name: "verbatim string without colon", input: "=5\r\ntxt-a\r\n", errContains: "invalid verbatim string format"},
+		{name: "invalid big number", input: "(12x\r\n", errContains: "invalid big number"},
+		{name: "over the limits", input: "*1\r\n*1\r\n*1\r\n*1\r\n:1\r\n", errContains: "nested deeper"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder(bufio.NewReader(strings.NewReader(tt.input)), Limits{MaxDepth: 3})
+			_, err := d.Decode()
+			var respErr *RESPError
+			if !errors.As(err, &respErr) {
+				t.Fatalf("Decode() error = %v, want a RESPError", err)
+			}
+			if !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("Decode() error = %v, should contain %q", err, tt.errContains)
+			}
+		})
+	}
+}
+
+func TestDecoderDecodeArray(t *testing.T) {
+	input := "*2\r\n$3\r\nGET\r\n$1\r\na\r\n" + "+OK\r\n" + "*1\r\n$4\r\nPING\r\n"
+	d := NewDecoder(bufio.NewReader(strings.NewReader(input)), Limits{})
+
+	var arr RespArray
+	if err := d.DecodeArray(&arr); err != nil {
+		t.Fatal(err)
+	}
+	first := arr.Elements[1]
+	elements := arr.Elements
+
+	if err := d.DecodeArray(&arr); err != ErrNotArray {
+		t.Fatalf("DecodeArray() error = %v, want %v", err, ErrNotArray)
+	}
+
+	if err := d.DecodeArray(&arr); err != nil {
+		t.Fatal(err)
+	}
+	if len(arr.Elements) != 1 || string(arr.Elements[0].(RespBulkString).Value) != "PING" {
+		t.Errorf("DecodeArray() = %v, want [PING]", arr)
+	}
+	if &arr.Elements[0] != &elements[0] {
+		t.Error("Expected the elements slice to be reused")
+	}
+	if string(first.(RespBulkString).Value) != "a" {
+		t.Errorf("Expected elements of a previous array to be kept, got %v", first)
+	}
+	if elements[1] != nil {
+		t.Errorf("Expected unused elements to be cleared, got %v", elements[1])
+	}
+
+	if err := d.DecodeArray(&arr); err != io.EOF {
+		t.Errorf("DecodeArray() error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestDecoderAllocations(t *testing.T) {
+	command := strings.Repeat("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n", 1000)
+	reader := strings.NewReader(command)
+	d := NewDecoder(bufio.NewReader(reader), Limits{})
+	var arr RespArray
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := d.DecodeArray(&arr); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// One allocation for the value of each bulk string and one to box it into the elements
+	if allocs > 6 {
+		t.Errorf("Expected at most 6 allocations per command, got %v", allocs)
+	}
+}
+
+func TestDecodeDeeplyNestedArrays(t *testing.T) {
+	const depth = 1000000
+	input := strings.Repeat("*1\r\n", depth) + ":1\r\n"
+
+	decoders := map[string]func(r *bufio.Reader, limits Limits) (RespValue, error){
+		"ReadRESP": ReadRESPWithLimits,
+		"Decoder": func(r *bufio.Reader, limits Limits) (RespValue, error) {
+			return NewDecoder(r, limits).Decode()
+		},
+	}
+	for name, decode := range decoders {
+		t.Run(name, func(t *testing.T) {
+			val, err := decode(bufio.NewReader(strings.NewReader(input)), Limits{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range depth {
+				arr, ok := val.(RespArray)
+				if !ok || len(arr.Elements) != 1 {
+					t.Fatalf("Expected an array of one element at depth %d, got %T", i+1, val)
+				}
+				val = arr.Elements[0]
+			}
+			if val != (RespInteger{Value: 1}) {
+				t.Errorf("Expected the innermost value to be 1, got %v", val)
+			}
+
+			_, err = decode(bufio.NewReader(strings.NewReader(input)), DefaultLimits)
+			if err == nil || !strings.Contains(err.Error(), "nested deeper than the limit of 32") {
+				t.Errorf("Expected the default depth limit to be enforced, got %v", err)
+			}
+		})
+	}
+}
+
+// Checks that decoding arbitrary input never panics, that ReadRESP and the Decoder agree, and that
+// the values returned stay within the limits.
+func FuzzDecode(f *testing.F) {
+	seeds := []string{
+		"*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n",
+		"*2\r\n*1\r\n:1\r\n$-1\r\n",
+		"*1\r\n*1\r\n*1\r\n*1\r\n*1\r\n:1\r\n",
+		"*-1\r\n",
+		"*0\r\n",
+		"$0\r\n\r\n",
+		"+OK\r\n",
+		"-ERR wrong\r\n",
+		":-42\r\n",
+		":0000000000000000000007\r\n",
+		",1.5\r\n",
+		",-inf\r\n",
+		"=8\r\ntxt:a\r\nb\r\n",
+		"(12345678901234567890\r\n",
+		"*2\r\n$3\r\nabc\r\n",
+		"$-2\r\n",
+		"*1\n",
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+
+	limits := Limits{MaxArrayLength: 1024, MaxDepth: 4}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		want, wantErr := ReadRESPWithLimits(bufio.NewReader(bytes.NewReader(data)), limits)
+		got, err := NewDecoder(bufio.NewReader(bytes.NewReader(data)), limits).Decode()
+		if (err != nil) != (wantErr != nil) {
+			t.Fatalf("Decode() error = %v, ReadRESP() error = %v", err, wantErr)
+		}
+		if err != nil {
+			return
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("Decode() = %v, ReadRESP() = %v", got, want)
+		}
+		if depth := nestingDepth(got); depth > limits.MaxDepth {
+			t.Fatalf("Decode() returned arrays nested %d deep, over the limit of %d", depth, limits.MaxDepth)
+		}
+	})
+}
+
+// Reads commands the way clients are read, going on after commands discarded for exceeding the
+// limits, and checks that only protocol errors are returned and that each attempt consumes input.
+func FuzzDecoderDecodeArray(f *testing.F) {
+	f.Add([]byte("*1\r\n$4\r\nPING\r\n*2\r\n$3\r\nGET\r\n$1\r\na\r\n"))
+	f.Add([]byte("*1\r\n$abc\r\n*1\r\n$4\r\nPING\r\n"))
+	f.Add([]byte("?garbage\r\n*1\r\n$4\r\nPING\r\n"))
+	f.Add([]byte("*1\r\n*1\r\n*1\r\n:1\r\n"))
+	f.Add([]byte("+OK\r\n$-5\r\n"))
+	f.Add([]byte("*2\r\n$20\r\n*1\r\n$4\r\nPING\r\n123\r\n$1\r\na\r\n*1\r\n$4\r\nPING\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := NewDecoder(bufio.NewReader(bytes.NewReader(data)), Limits{MaxBulkLength: 16, MaxArrayLength: 16, MaxDepth: 2})
+
+		var arr RespArray
+		for range len(data) + 1 {
+			err := d.DecodeArray(&arr)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+			if err == nil {
+				if depth := nestingDepth(arr); depth > 2 {
+					t.Fatalf("DecodeArray() returned arrays nested %d deep", depth)
+				}
+				continue
+			}
+
+			var respErr *RESPError
+			if err != ErrNotArray && !errors.As(err, &respErr) {
+				t.Fatalf("DecodeArray() error = %v", err)
+			}
+			if respErr != nil && !errors.Is(err, ErrCommandDiscarded) {
+				return
+			}
+		}
+		t.Fatalf("Expected the decoder to reach the end of %q", data)
+	})
+}
+
+// Returns the number of arrays nested in val, 0 if it is not an array or a null array, which has no
+// depth limit since it cannot nest anything.
+func nestingDepth(val RespValue) int {
+	arr, ok := val.(RespArray)
+	if !ok || arr.Elements == nil {
+		return 0
+	}
+
+	depth := 0
+	for _, elem := range arr.Elements {
+		depth = max(depth, nestingDepth(elem))
+	}
+	return depth + 1
+}
+
+func TestParseInt(t *testing.T) {
+	tests := []struct {
+		input  string
+		want   int64
+		wantOk bool
+	}{
+		{"0", 0, true},
+		{"123", 123, true},
+		{"-1", -1, true},
+		{"+5", 5, true},
+		{"9223372036854775807", 9223372036854775807, true},
+		{"-9223372036854775808", -9223372036854775808, true},
+		{"9223372036854775808", 0, false},
+		{"99999999999999999999", 0, false},
+		{"000000000000000000000042", 42, true},
+		{"", 0, false},
+		{"-", 0, false},
+		{"1.5", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseInt([]byte(tt.input))
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf("parseInt(%q) = %v, %v, want %v, %v", tt.input, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func BenchmarkReadRESP(b *testing.B) {
+	command := []byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n")
+	reader := bytes.NewReader(command)
+	r := bufio.NewReader(reader)
+
+	for b.Loop() {
+		reader.Reset(command)
+		r.Reset(reader)
+		ReadRESP(r)
+	}
+}
+
+func BenchmarkDecoderDecodeArray(b *testing.B) {
+	command := []byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n")
+	reader := bytes.NewReader(command)
+	r := bufio.NewReader(reader)
+	d := NewDecoder(r, Limits{})
+	var arr RespArray
+
+	for b.Loop() {
+		reader.Reset(command)
+		r.Reset(reader)
+		d.DecodeArray(&arr)
+	}
+}
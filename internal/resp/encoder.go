@@ -1,41 +1,225 @@
 package resp
 
-import "strconv"
+import (
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+)
 
+// Encodes a simple string. Values containing CR or LF, which would end the simple string early and
+// desynchronize the stream, are encoded as a bulk string instead.
 func EncodeSimpleString(value string) []byte {
+	if hasLineBreak(value) {
+		return EncodeBulkString([]byte(value))
+	}
 	return []byte("+" + value + "\r\n")
 }
 
+// Encodes an error. CR and LF in the message are replaced with spaces, since errors have no length
+// prefix to carry them.
 func EncodeError(value string) []byte {
-	return []byte("-" + value + "\r\n")
+	return []byte("-" + sanitizeError(value) + "\r\n")
+}
+
+// Reports whether value contains a CR or LF, which cannot appear in simple strings and errors.
+func hasLineBreak(value string) bool {
+	return strings.ContainsAny(value, "\r\n")
+}
+
+// Replaces CR and LF in an error message with spaces.
+func sanitizeError(value string) string {
+	if !hasLineBreak(value) {
+		return value
+	}
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
 }
 
 func EncodeBulkString(value []byte) []byte {
-	// Handle nil values
+	return AppendBulkString(make([]byte, 0, bulkStringSize(value)), value)
+}
+
+// Appends the encoding of a bulk string to b, nil encoding a null bulk string. Used to encode into a
+// reused buffer.
+func AppendBulkString(b, value []byte) []byte {
 	if value == nil {
-		return []byte("$-1\r\n")
+		return append(b, "$-1\r\n"...)
 	}
 
-	return []byte("$" + strconv.Itoa(len(value)) + "\r\n" + string(value) + "\r\n")
+	b = append(b, '$')
+	b = strconv.AppendInt(b, int64(len(value)), 10)
+	b = append(b, '\r', '\n')
+	b = append(b, value...)
+	return append(b, '\r', '\n')
+}
+
+// Returns the length of the encoding of a bulk string.
+func bulkStringSize(value []byte) int {
+	if value == nil {
+		return len("$-1\r\n")
+	}
+	return len("$\r\n\r\n") + digits(len(value)) + len(value)
+}
+
+// Returns the length of the decimal representation of a non-negative integer.
+func digits(n int) int {
+	count := 1
+	for n >= 10 {
+		n /= 10
+		count++
+	}
+	return count
+}
+
+// Appends the header of an aggregate type, its prefix and length.
+func appendHeader(b []byte, prefix byte, length int) []byte {
+	b = append(b, prefix)
+	b = strconv.AppendInt(b, int64(length), 10)
+	return append(b, '\r', '\n')
+}
+
+// Returns the length of the header of an aggregate type.
+func headerSize(length int) int {
+	return len("*\r\n") + digits(length)
 }
 
 func EncodeInteger(value int64) []byte {
 	return []byte(":" + strconv.FormatInt(value, 10) + "\r\n")
 }
 
+// Encodes a RESP3 double, using the shortest representation and inf, -inf and nan for special values.
+func EncodeDouble(value float64) []byte {
+	return appendDouble([]byte{','}, value)
+}
+
+func appendDouble(b []byte, value float64) []byte {
+	switch {
+	case math.IsInf(value, 1):
+		b = append(b, "inf"...)
+	case math.IsInf(value, -1):
+		b = append(b, "-inf"...)
+	case math.IsNaN(value):
+		b = append(b, "nan"...)
+	default:
+		b = strconv.AppendFloat(b, value, 'g', -1, 64)
+	}
+	return append(b, '\r', '\n')
+}
+
+// Encodes a RESP3 verbatim string. The format must be three characters long, e.g. txt for plain text.
+func EncodeVerbatimString(format string, value []byte) []byte {
+	return []byte("=" + strconv.Itoa(len(value)+4) + "\r\n" + format + ":" + string(value) + "\r\n")
+}
+
+// Encodes a RESP3 big number.
+func EncodeBigNumber(value *big.Int) []byte {
+	return []byte("(" + value.String() + "\r\n")
+}
+
+// Encodes an array of bulk strings in a single buffer sized up front, so large replies like LRANGE
+// are copied once. nil encodes a null array.
 func EncodeBulkStringArray(elements [][]byte) []byte {
 	if elements == nil {
 		return []byte("*-1\r\n")
 	}
+	return encodeBulkStringAggregate('*', len(elements), elements)
+}
 
-	if len(elements) == 0 {
-		return []byte("*0\r\n")
+// Appends the encoding of an array of bulk strings to b, growing it at most once. nil encodes a null
+// array. Used to encode into a reused buffer.
+func AppendBulkStringArray(b []byte, elements [][]byte) []byte {
+	if elements == nil {
+		return append(b, "*-1\r\n"...)
 	}
+	b = slices.Grow(b, bulkStringAggregateSize(len(elements), elements))
+	return appendBulkStringAggregate(b, '*', len(elements), elements)
+}
+
+// Encodes a map from keys and values that are already RESP encoded, given one after the other. RESP2
+// peers get a flat array of the keys and values instead. nil encodes an empty map.
+func EncodeMap(entries [][]byte, protocol Protocol) []byte {
+	return encodeAggregate(mapPrefix(protocol), len(entries)/mapEntrySize(protocol), entries)
+}
 
-	result := "*" + strconv.Itoa(len(elements)) + "\r\n"
+// Encodes a map whose keys and values are bulk strings, given one after the other, like the fields
+// and values of a hash.
+func EncodeBulkStringMap(pairs [][]byte, protocol Protocol) []byte {
+	return encodeBulkStringAggregate(mapPrefix(protocol), len(pairs)/mapEntrySize(protocol), pairs)
+}
+
+// Encodes a set from members that are already RESP encoded. RESP2 peers get an array instead. nil
+// encodes an empty set.
+func EncodeSet(members [][]byte, protocol Protocol) []byte {
+	return encodeAggregate(setPrefix(protocol), len(members), members)
+}
+
+// Encodes a set whose members are bulk strings.
+func EncodeBulkStringSet(members [][]byte, protocol Protocol) []byte {
+	return encodeBulkStringAggregate(setPrefix(protocol), len(members), members)
+}
+
+func mapPrefix(protocol Protocol) byte {
+	if protocol >= RESP3 {
+		return '%'
+	}
+	return '*'
+}
+
+// Returns the number of elements counted as one in the length of a map.
+func mapEntrySize(protocol Protocol) int {
+	if protocol >= RESP3 {
+		return 2
+	}
+	return 1
+}
+
+func setPrefix(protocol Protocol) byte {
+	if protocol >= RESP3 {
+		return '~'
+	}
+	return '*'
+}
+
+func encodeAggregate(prefix byte, length int, elements [][]byte) []byte {
+	size := headerSize(length)
+	for _, elem := range elements {
+		size += len(elem)
+	}
+
+	result := appendHeader(make([]byte, 0, size), prefix, length)
+	for _, elem := range elements {
+		result = append(result, elem...)
+	}
+	return result
+}
+
+func encodeBulkStringAggregate(prefix byte, length int, elements [][]byte) []byte {
+	size := bulkStringAggregateSize(length, elements)
+	return appendBulkStringAggregate(make([]byte, 0, size), prefix, length, elements)
+}
+
+func appendBulkStringAggregate(b []byte, prefix byte, length int, elements [][]byte) []byte {
+	b = appendHeader(b, prefix, length)
 	for _, elem := range elements {
-		result += string(EncodeBulkString(elem))
+		b = AppendBulkString(b, elem)
 	}
+	return b
+}
+
+// Returns the length of the encoding of an aggregate type holding bulk strings.
+func bulkStringAggregateSize(length int, elements [][]byte) int {
+	size := headerSize(length)
+	for _, elem := range elements {
+		size += bulkStringSize(elem)
+	}
+	return size
+}
 
-	return []byte(result)
+// Encodes an array from elements that are already RESP encoded, allowing nested and mixed-type replies.
+func EncodeArray(elements [][]byte) []byte {
+	if elements == nil {
+		return []byte("*-1\r\n")
+	}
+	return encodeAggregate('*', len(elements), elements)
 }
@@ -3,6 +3,9 @@ package resp
 import (
 	"bufio"
 	"bytes"
+	"fmt"
+	"math"
+	"math/big"
 	"testing"
 )
 
@@ -37,6 +40,16 @@ func TestEncodeSimpleString(t *testing.T) {
 			input: "test!@#$%",
 			want:  []byte("+test!@#$%\r\n"),
 		},
+		{
+			name:  "string with CRLF falls back to bulk string",
+			input: "hello\r\n+OK",
+			want:  []byte("$10\r\nhello\r\n+OK\r\n"),
+		},
+		{
+			name:  "string with LF falls back to bulk string",
+			input: "a\nb",
+			want:  []byte("$3\r\na\nb\r\n"),
+		},
 	}
 
 	for _, tt := range tests {
@@ -80,6 +93,16 @@ func TestEncodeError(t *testing.T) {
 			input: "ERR syntax error",
 			want:  []byte("-ERR syntax error\r\n"),
 		},
+		{
+			name:  "error with CRLF",
+			input: "ERR unknown command 'x\r\n+OK'",
+			want:  []byte("-ERR unknown command 'x  +OK'\r\n"),
+		},
+		{
+			name:  "error with lone CR and LF",
+			input: "ERR a\rb\nc",
+			want:  []byte("-ERR a b c\r\n"),
+		},
 	}
 
 	for _, tt := range tests {
@@ -292,7 +315,182 @@ func TestEncodeBulkStringArray(t *testing.T) {
 	}
 }
 
+func TestEncodeArray(t *testing.T) {
+	tests := []struct {
+		name  string
+		input [][]byte
+		want  []byte
+	}{
+		{
+			name:  "null array",
+			input: nil,
+			want:  []byte("*-1\r\n"),
+		},
+		{
+			name:  "empty array",
+			input: [][]byte{},
+			want:  []byte("*0\r\n"),
+		},
+		{
+			name: "mixed types",
+			input: [][]byte{
+				EncodeBulkString([]byte("key")),
+				EncodeInteger(42),
+				EncodeSimpleString("OK"),
+			},
+			want: []byte("*3\r\n$3\r\nkey\r\n:42\r\n+OK\r\n"),
+		},
+		{
+			name: "nested arrays",
+			input: [][]byte{
+				EncodeBulkStringArray([][]byte{[]byte("a")}),
+				EncodeArray([][]byte{EncodeInteger(1)}),
+			},
+			want: []byte("*2\r\n*1\r\n$1\r\na\r\n*1\r\n:1\r\n"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := EncodeArray(tt.input)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("EncodeArray() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 // TestRoundTrip tests encoding and then decoding to ensure data integrity
+func TestEncodeDouble(t *testing.T) {
+	tests := []struct {
+		name  string
+		input float64
+		want  []byte
+	}{
+		{name: "integral", input: 10, want: []byte(",10\r\n")},
+		{name: "fractional", input: -1.5, want: []byte(",-1.5\r\n")},
+		{name: "exponent", input: 1e21, want: []byte(",1e+21\r\n")},
+		{name: "positive infinity", input: math.Inf(1), want: []byte(",inf\r\n")},
+		{name: "negative infinity", input: math.Inf(-1), want: []byte(",-inf\r\n")},
+		{name: "not a number", input: math.NaN(), want: []byte(",nan\r\n")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := EncodeDouble(tt.input)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("EncodeDouble() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeVerbatimString(t *testing.T) {
+	got := EncodeVerbatimString("txt", []byte("Some string"))
+	want := []byte("=15\r\ntxt:Some string\r\n")
+	if !bytes.Equal(got, want) {
+		t.Errorf("EncodeVerbatimString() = %q, want %q", got, want)
+	}
+}
+
+func TestEncodeBigNumber(t *testing.T) {
+	value, _ := new(big.Int).SetString("-3492890328409238509324850943850943825024385", 10)
+	got := EncodeBigNumber(value)
+	want := []byte("(-3492890328409238509324850943850943825024385\r\n")
+	if !bytes.Equal(got, want) {
+		t.Errorf("EncodeBigNumber() = %q, want %q", got, want)
+	}
+}
+
+func TestEncodeMap(t *testing.T) {
+	entries := [][]byte{EncodeBulkString([]byte("first")), EncodeInteger(1), EncodeBulkString([]byte("second")), EncodeDouble(2.5)}
+	pairs := [][]byte{[]byte("a"), []byte("1"), []byte("b"), []byte("2")}
+
+	tests := []struct {
+		name string
+		got  []byte
+		want []byte
+	}{
+		{
+			name: "RESP3 map",
+			got:  EncodeMap(entries, RESP3),
+			want: []byte("%2\r\n$5\r\nfirst\r\n:1\r\n$6\r\nsecond\r\n,2.5\r\n"),
+		},
+		{
+			name: "RESP2 flattened array",
+			got:  EncodeMap(entries, RESP2),
+			want: []byte("*4\r\n$5\r\nfirst\r\n:1\r\n$6\r\nsecond\r\n,2.5\r\n"),
+		},
+		{
+			name: "RESP3 bulk string map",
+			got:  EncodeBulkStringMap(pairs, RESP3),
+			want: []byte("%2\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n"),
+		},
+		{
+			name: "RESP2 bulk string map",
+			got:  EncodeBulkStringMap(pairs, RESP2),
+			want: EncodeBulkStringArray(pairs),
+		},
+		{
+			name: "nil map",
+			got:  EncodeBulkStringMap(nil, RESP3),
+			want: []byte("%0\r\n"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !bytes.Equal(tt.got, tt.want) {
+				t.Errorf("got %q, want %q", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeSet(t *testing.T) {
+	members := [][]byte{[]byte("x"), []byte("y")}
+
+	tests := []struct {
+		name string
+		got  []byte
+		want []byte
+	}{
+		{
+			name: "RESP3 set",
+			got:  EncodeSet([][]byte{EncodeInteger(1), EncodeSimpleString("two")}, RESP3),
+			want: []byte("~2\r\n:1\r\n+two\r\n"),
+		},
+		{
+			name: "RESP2 array",
+			got:  EncodeSet([][]byte{EncodeInteger(1), EncodeSimpleString("two")}, RESP2),
+			want: []byte("*2\r\n:1\r\n+two\r\n"),
+		},
+		{
+			name: "RESP3 bulk string set",
+			got:  EncodeBulkStringSet(members, RESP3),
+			want: []byte("~2\r\n$1\r\nx\r\n$1\r\ny\r\n"),
+		},
+		{
+			name: "RESP2 bulk string set",
+			got:  EncodeBulkStringSet(members, RESP2),
+			want: EncodeBulkStringArray(members),
+		},
+		{
+			name: "nil set",
+			got:  EncodeBulkStringSet(nil, RESP2),
+			want: []byte("*0\r\n"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !bytes.Equal(tt.got, tt.want) {
+				t.Errorf("got %q, want %q", tt.got, tt.want)
+			}
+		})
+	}
+}
+
 func TestRoundTrip(t *testing.T) {
 	t.Run("bulk string round trip", func(t *testing.T) {
 		original := []byte("hello world")
@@ -368,4 +566,89 @@ func TestRoundTrip(t *testing.T) {
 			t.Errorf("Round trip failed: got %q, want %q", decoded.Message, original)
 		}
 	})
+
+	t.Run("RESP3 round trip", func(t *testing.T) {
+		number, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+		values := []RespValue{
+			RespDouble{Value: 3.25},
+			RespDouble{Value: math.Inf(-1)},
+			RespVerbatimString{Format: "mkd", Value: []byte("# title\r\n")},
+			RespBigNumber{Value: number},
+		}
+		encoded := [][]byte{
+			EncodeDouble(3.25),
+			EncodeDouble(math.Inf(-1)),
+			EncodeVerbatimString("mkd", []byte("# title\r\n")),
+			EncodeBigNumber(number),
+		}
+
+		for i, original := range values {
+			decoded, err := ReadRESP(bufio.NewReader(bytes.NewReader(encoded[i])))
+			if err != nil {
+				t.Fatalf("ReadRESP(%q) error = %v", encoded[i], err)
+			}
+			if fmt.Sprint(decoded) != fmt.Sprint(original) {
+				t.Errorf("Round trip failed: got %v, want %v", decoded, original)
+			}
+		}
+	})
+}
+
+func TestEncodedSizes(t *testing.T) {
+	for _, n := range []int{0, 9, 10, 99, 100, 12345} {
+		if got := digits(n); got != len(fmt.Sprint(n)) {
+			t.Errorf("digits(%d) = %d, want %d", n, got, len(fmt.Sprint(n)))
+		}
+	}
+
+	// The buffers are sized exactly, so encoding never grows them
+	elements := [][]byte{[]byte("a"), nil, bytes.Repeat([]byte("x"), 1000), {}}
+	for _, encoded := range [][]byte{
+		EncodeBulkString(elements[2]),
+		EncodeBulkString(nil),
+		EncodeBulkStringArray(elements),
+		EncodeArray([][]byte{EncodeInteger(1), EncodeBulkStringArray(elements)}),
+	} {
+		if len(encoded) != cap(encoded) {
+			t.Errorf("Expected a buffer of %d bytes, got a capacity of %d", len(encoded), cap(encoded))
+		}
+	}
+}
+
+func TestAppendBulkString(t *testing.T) {
+	elements := [][]byte{[]byte("a"), nil, {}}
+	buf := []byte("prefix")
+	if got := AppendBulkString(buf, []byte("value")); string(got) != "prefix"+string(EncodeBulkString([]byte("value"))) {
+		t.Errorf("AppendBulkString() = %q", got)
+	}
+	if got := AppendBulkStringArray(buf, elements); string(got) != "prefix"+string(EncodeBulkStringArray(elements)) {
+		t.Errorf("AppendBulkStringArray() = %q", got)
+	}
+	if got := AppendBulkStringArray(nil, nil); string(got) != "*-1\r\n" {
+		t.Errorf("AppendBulkStringArray(nil) = %q, want a null array", got)
+	}
+}
+
+func BenchmarkEncodeBulkString(b *testing.B) {
+	value := bytes.Repeat([]byte("x"), 64)
+
+	for b.Loop() {
+		EncodeBulkString(value)
+	}
+}
+
+func BenchmarkEncodeBulkStringArray(b *testing.B) {
+	for _, n := range []int{10, 1000, 100000} {
+		elements := make([][]byte, n)
+		for i := range elements {
+			elements[i] = fmt.Appendf(nil, "element:%d", i)
+		}
+
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				EncodeBulkStringArray(elements)
+			}
+		})
+	}
 }
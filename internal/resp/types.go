@@ -1,5 +1,7 @@
 package resp
 
+import "math/big"
+
 type RespType int
 
 const (
@@ -9,6 +11,18 @@ const (
 	Integer
 	BulkString
 	Array
+	Double
+	VerbatimString
+	BigNumber
+)
+
+// Version of the protocol spoken with a peer. RESP3 adds types such as maps, sets and doubles, which
+// are sent as their closest RESP2 type to RESP2 peers.
+type Protocol int
+
+const (
+	RESP2 Protocol = 2
+	RESP3 Protocol = 3
 )
 
 // RESP value interface.
@@ -35,6 +49,21 @@ type RespInteger struct {
 	Value int64
 }
 
+// RESP3 data types.
+type RespDouble struct {
+	Value float64
+}
+
+// A string with a three letter format such as txt or mkd, for text meant to be shown as is.
+type RespVerbatimString struct {
+	Format string
+	Value  []byte
+}
+
+type RespBigNumber struct {
+	Value *big.Int
+}
+
 // RESPError wraps parsing errors with context.
 type RESPError struct {
 	Msg string
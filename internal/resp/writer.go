@@ -0,0 +1,135 @@
+package resp
+
+import (
+	"io"
+	"math/big"
+	"strconv"
+)
+
+// Writer encodes RESP values straight into an io.Writer, usually a bufio.Writer, so large values
+// and arrays are not first copied into an intermediate []byte. The first error is kept and returned
+// by every later call, so a reply can be written in full and checked once.
+type Writer struct {
+	w       io.Writer
+	scratch []byte // Holds the type prefix, length and CRLF of the value being written
+	err     error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, scratch: make([]byte, 0, 32)}
+}
+
+// Returns the first error returned by the underlying writer.
+func (w *Writer) Err() error {
+	return w.err
+}
+
+func (w *Writer) write(p []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	_, w.err = w.w.Write(p)
+	return w.err
+}
+
+// Writes a prefix followed by a number and CRLF, such as a length or an integer.
+func (w *Writer) writeHeader(prefix byte, n int64) error {
+	w.scratch = append(w.scratch[:0], prefix)
+	w.scratch = strconv.AppendInt(w.scratch, n, 10)
+	w.scratch = append(w.scratch, '\r', '\n')
+	return w.write(w.scratch)
+}
+
+// Writes a simple string, or a bulk string if value contains CR or LF.
+func (w *Writer) WriteSimpleString(value string) error {
+	if hasLineBreak(value) {
+		return w.WriteBulkString([]byte(value))
+	}
+
+	w.scratch = append(w.scratch[:0], '+')
+	w.scratch = append(w.scratch, value...)
+	w.scratch = append(w.scratch, '\r', '\n')
+	return w.write(w.scratch)
+}
+
+// Writes an error, replacing CR and LF in the message with spaces.
+func (w *Writer) WriteError(value string) error {
+	w.scratch = append(w.scratch[:0], '-')
+	w.scratch = append(w.scratch, sanitizeError(value)...)
+	w.scratch = append(w.scratch, '\r', '\n')
+	return w.write(w.scratch)
+}
+
+func (w *Writer) WriteInteger(value int64) error {
+	return w.writeHeader(':', value)
+}
+
+// Writes a bulk string, or a null bulk string if value is nil.
+func (w *Writer) WriteBulkString(value []byte) error {
+	if value == nil {
+		return w.write([]byte("$-1\r\n"))
+	}
+
+	w.writeHeader('$', int64(len(value)))
+	w.write(value)
+	return w.write([]byte("\r\n"))
+}
+
+func (w *Writer) WriteDouble(value float64) error {
+	w.scratch = appendDouble(append(w.scratch[:0], ','), value)
+	return w.write(w.scratch)
+}
+
+// Writes a verbatim string. The format must be three characters long, e.g. txt for plain text.
+func (w *Writer) WriteVerbatimString(format string, value []byte) error {
+	w.writeHeader('=', int64(len(value)+4))
+	w.scratch = append(append(w.scratch[:0], format...), ':')
+	w.write(w.scratch)
+	w.write(value)
+	return w.write([]byte("\r\n"))
+}
+
+func (w *Writer) WriteBigNumber(value *big.Int) error {
+	w.scratch = value.Append(append(w.scratch[:0], '('), 10)
+	w.scratch = append(w.scratch, '\r', '\n')
+	return w.write(w.scratch)
+}
+
+// Writes the header of an array of n elements, which must be followed by the n elements. A negative
+// n writes a null array.
+func (w *Writer) WriteArrayHeader(n int) error {
+	if n < 0 {
+		return w.write([]byte("*-1\r\n"))
+	}
+	return w.writeHeader('*', int64(n))
+}
+
+// Writes the header of a map of n keys and values, which must be followed by the 2n keys and values.
+// RESP2 peers get the header of a flat array of the keys and values instead.
+func (w *Writer) WriteMapHeader(n int, protocol Protocol) error {
+	return w.writeHeader(mapPrefix(protocol), int64(n*2/mapEntrySize(protocol)))
+}
+
+// Writes the header of a set of n members, which must be followed by the n members. RESP2 peers get
+// the header of an array instead.
+func (w *Writer) WriteSetHeader(n int, protocol Protocol) error {
+	return w.writeHeader(setPrefix(protocol), int64(n))
+}
+
+// Writes an array of bulk strings, or a null array if elements is nil.
+func (w *Writer) WriteBulkStringArray(elements [][]byte) error {
+	if elements == nil {
+		return w.WriteArrayHeader(-1)
+	}
+
+	w.WriteArrayHeader(len(elements))
+	for _, elem := range elements {
+		w.WriteBulkString(elem)
+	}
+	return w.err
+}
+
+// Writes a value that is already RESP encoded, such as the result of one of the Encode functions.
+func (w *Writer) WriteEncoded(value []byte) error {
+	return w.write(value)
+}
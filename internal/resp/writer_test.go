@@ -0,0 +1,161 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(w *Writer) error
+		want  []byte
+	}{
+		{
+			name:  "simple string",
+			write: func(w *Writer) error { return w.WriteSimpleString("OK") },
+			want:  EncodeSimpleString("OK"),
+		},
+		{
+			name:  "error",
+			write: func(w *Writer) error { return w.WriteError("ERR unknown command") },
+			want:  EncodeError("ERR unknown command"),
+		},
+		{
+			name:  "simple string with CRLF",
+			write: func(w *Writer) error { return w.WriteSimpleString("a\r\n+OK") },
+			want:  EncodeBulkString([]byte("a\r\n+OK")),
+		},
+		{
+			name:  "error with CRLF",
+			write: func(w *Writer) error { return w.WriteError("ERR a\r\n+OK") },
+			want:  []byte("-ERR a  +OK\r\n"),
+		},
+		{
+			name:  "negative integer",
+			write: func(w *Writer) error { return w.WriteInteger(-42) },
+			want:  EncodeInteger(-42),
+		},
+		{
+			name:  "bulk string",
+			write: func(w *Writer) error { return w.WriteBulkString([]byte("hello\r\nworld")) },
+			want:  EncodeBulkString([]byte("hello\r\nworld")),
+		},
+		{
+			name:  "null bulk string",
+			write: func(w *Writer) error { return w.WriteBulkString(nil) },
+			want:  EncodeBulkString(nil),
+		},
+		{
+			name:  "empty bulk string",
+			write: func(w *Writer) error { return w.WriteBulkString([]byte{}) },
+			want:  EncodeBulkString([]byte{}),
+		},
+		{
+			name: "bulk string array",
+			write: func(w *Writer) error {
+				return w.WriteBulkStringArray([][]byte{[]byte("a"), nil, []byte("ccc")})
+			},
+			want: EncodeBulkStringArray([][]byte{[]byte("a"), nil, []byte("ccc")}),
+		},
+		{
+			name:  "null array",
+			write: func(w *Writer) error { return w.WriteBulkStringArray(nil) },
+			want:  EncodeBulkStringArray(nil),
+		},
+		{
+			name:  "empty array",
+			write: func(w *Writer) error { return w.WriteBulkStringArray([][]byte{}) },
+			want:  EncodeBulkStringArray([][]byte{}),
+		},
+		{
+			name:  "double",
+			write: func(w *Writer) error { return w.WriteDouble(-0.125) },
+			want:  EncodeDouble(-0.125),
+		},
+		{
+			name:  "verbatim string",
+			write: func(w *Writer) error { return w.WriteVerbatimString("txt", []byte("a\r\nb")) },
+			want:  EncodeVerbatimString("txt", []byte("a\r\nb")),
+		},
+		{
+			name:  "big number",
+			write: func(w *Writer) error { return w.WriteBigNumber(big.NewInt(-12345)) },
+			want:  EncodeBigNumber(big.NewInt(-12345)),
+		},
+		{
+			name: "RESP3 map",
+			write: func(w *Writer) error {
+				w.WriteMapHeader(1, RESP3)
+				w.WriteBulkString([]byte("key"))
+				return w.WriteInteger(1)
+			},
+			want: EncodeMap([][]byte{EncodeBulkString([]byte("key")), EncodeInteger(1)}, RESP3),
+		},
+		{
+			name: "RESP2 map",
+			write: func(w *Writer) error {
+				w.WriteMapHeader(1, RESP2)
+				w.WriteBulkString([]byte("key"))
+				return w.WriteInteger(1)
+			},
+			want: EncodeMap([][]byte{EncodeBulkString([]byte("key")), EncodeInteger(1)}, RESP2),
+		},
+		{
+			name: "set",
+			write: func(w *Writer) error {
+				w.WriteSetHeader(2, RESP3)
+				w.WriteInteger(1)
+				return w.WriteInteger(2)
+			},
+			want: EncodeSet([][]byte{EncodeInteger(1), EncodeInteger(2)}, RESP3),
+		},
+		{
+			name: "nested array",
+			write: func(w *Writer) error {
+				w.WriteArrayHeader(2)
+				w.WriteInteger(1)
+				return w.WriteEncoded(EncodeArray([][]byte{EncodeSimpleString("x")}))
+			},
+			want: EncodeArray([][]byte{EncodeInteger(1), EncodeArray([][]byte{EncodeSimpleString("x")})}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.write(NewWriter(&buf)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), tt.want) {
+				t.Errorf("wrote %q, want %q", buf.Bytes(), tt.want)
+			}
+		})
+	}
+}
+
+type failingWriter struct {
+	writes int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	f.writes++
+	return 0, errors.New("connection reset")
+}
+
+func TestWriterKeepsFirstError(t *testing.T) {
+	fw := &failingWriter{}
+	w := NewWriter(fw)
+
+	if err := w.WriteBulkStringArray([][]byte{[]byte("a"), []byte("b")}); err == nil {
+		t.Fatal("expected the write error to be returned")
+	}
+	if err := w.WriteInteger(1); err == nil || w.Err() != err {
+		t.Errorf("expected later writes to return the first error, got %v", err)
+	}
+	if fw.writes != 1 {
+		t.Errorf("expected no writes after the first error, got %d writes", fw.writes)
+	}
+}
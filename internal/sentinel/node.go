@@ -0,0 +1,105 @@
+package sentinel
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Role of a server as reported by the ROLE command.
+type role struct {
+	primary  bool
+	offset   int64    // Replication stream sent by a primary or applied by a replica
+	replicas []string // host:port of the replicas of a primary
+	of       string   // host:port of the primary of a replica
+}
+
+// Sends a command to a server or sentinel and returns its reply, failing if it takes longer than timeout.
+func request(addr string, timeout time.Duration, args ...string) (resp.RespValue, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	encoded := make([][]byte, len(args))
+	for i, arg := range args {
+		encoded[i] = []byte(arg)
+	}
+	if _, err := conn.Write(resp.EncodeBulkStringArray(encoded)); err != nil {
+		return nil, err
+	}
+
+	val, err := resp.ReadRESP(bufio.NewReader(conn))
+	if err != nil {
+		return nil, err
+	}
+	if respErr, ok := val.(resp.RespErrorValue); ok {
+		return nil, &resp.RESPError{Msg: respErr.Message}
+	}
+	return val, nil
+}
+
+// Asks a server for its role.
+func queryRole(addr string, timeout time.Duration) (role, error) {
+	val, err := request(addr, timeout, "ROLE")
+	if err != nil {
+		return role{}, err
+	}
+
+	arr, ok := val.(resp.RespArray)
+	if !ok || len(arr.Elements) < 3 {
+		return role{}, fmt.Errorf("unexpected reply to ROLE: %v", val)
+	}
+	kind, _ := arr.Elements[0].(resp.RespBulkString)
+
+	switch string(kind.Value) {
+	case "master":
+		offset, _ := arr.Elements[1].(resp.RespInteger)
+		replicas, _ := arr.Elements[2].(resp.RespArray)
+		r := role{primary: true, offset: offset.Value}
+		for _, elem := range replicas.Elements {
+			fields, ok := elem.(resp.RespArray)
+			if !ok || len(fields.Elements) < 2 {
+				continue
+			}
+			host, _ := fields.Elements[0].(resp.RespBulkString)
+			port, _ := fields.Elements[1].(resp.RespBulkString)
+			r.replicas = append(r.replicas, net.JoinHostPort(string(host.Value), string(port.Value)))
+		}
+		return r, nil
+	case "slave":
+		if len(arr.Elements) < 5 {
+			return role{}, fmt.Errorf("unexpected reply to ROLE: %v", val)
+		}
+		host, _ := arr.Elements[1].(resp.RespBulkString)
+		port, _ := arr.Elements[2].(resp.RespInteger)
+		offset, _ := arr.Elements[4].(resp.RespInteger)
+		return role{
+			offset: offset.Value,
+			of:     net.JoinHostPort(string(host.Value), strconv.FormatInt(port.Value, 10)),
+		}, nil
+	default:
+		return role{}, fmt.Errorf("unexpected reply to ROLE: %v", val)
+	}
+}
+
+// Makes a server replicate the primary at addr, or become a primary when addr is empty.
+func replicaOf(node, addr string, timeout time.Duration) error {
+	args := []string{"REPLICAOF", "NO", "ONE"}
+	if addr != "" {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return err
+		}
+		args = []string{"REPLICAOF", host, port}
+	}
+
+	_, err := request(node, timeout, args...)
+	return err
+}
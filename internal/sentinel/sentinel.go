@@ -0,0 +1,310 @@
+// Package sentinel monitors a GopherStore primary and its replicas. Sentinels agree with their peers
+// that the primary is down, elect one of them to promote the replica with the most data, point the
+// other servers to it, and tell clients which server is the primary.
+package sentinel
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Configures what a sentinel monitors and when it fails over. Zero durations use the defaults.
+type Config struct {
+	Name            string        // Name clients use to ask for the address of the primary
+	Primary         string        // host:port of the primary monitored at startup
+	Peers           []string      // host:port of the other sentinels monitoring the same primary
+	Quorum          int           // Sentinels that must agree the primary is down before failing over
+	DownAfter       time.Duration // Time without a reply before the primary is considered down
+	CheckInterval   time.Duration // Time between health checks
+	FailoverTimeout time.Duration // Wait before another failover is attempted after one started or failed
+}
+
+const (
+	defaultDownAfter       = 5 * time.Second
+	defaultCheckInterval   = time.Second
+	defaultFailoverTimeout = 30 * time.Second
+)
+
+// Monitors a primary, failing over to one of its replicas when it is down.
+type Sentinel struct {
+	cfg    Config
+	id     string
+	logger *slog.Logger
+
+	mu           sync.Mutex
+	primary      string
+	replicas     map[string]struct{} // Known replicas, including former primaries to reconfigure once back
+	lastReply    time.Time           // Last time the primary replied
+	epoch        uint64              // Latest election epoch seen
+	votedEpoch   uint64              // Epoch of the last vote given
+	votedFor     string              // ID of the sentinel voted for in votedEpoch
+	configEpoch  uint64              // Epoch of the failover that elected the current primary
+	nextFailover time.Time           // Failovers are not attempted before then
+}
+
+// Creates a sentinel monitoring cfg.Primary. Call Run to start monitoring and Serve to answer clients.
+func New(cfg Config, logger *slog.Logger) *Sentinel {
+	if cfg.Quorum <= 0 {
+		cfg.Quorum = 1
+	}
+	if cfg.DownAfter <= 0 {
+		cfg.DownAfter = defaultDownAfter
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = defaultCheckInterval
+	}
+	if cfg.FailoverTimeout <= 0 {
+		cfg.FailoverTimeout = defaultFailoverTimeout
+	}
+
+	id := make([]byte, 20)
+	rand.Read(id)
+
+	return &Sentinel{
+		cfg:       cfg,
+		id:        hex.EncodeToString(id),
+		logger:    logger,
+		primary:   cfg.Primary,
+		replicas:  make(map[string]struct{}),
+		lastReply: time.Now(),
+	}
+}
+
+// Returns the address of the current primary.
+func (s *Sentinel) Primary() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.primary
+}
+
+// Returns the addresses of the known replicas of the current primary.
+func (s *Sentinel) Replicas() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	replicas := make([]string, 0, len(s.replicas))
+	for addr := range s.replicas {
+		replicas = append(replicas, addr)
+	}
+	return replicas
+}
+
+// Checks the primary every check interval until stop is closed.
+func (s *Sentinel) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.check()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Requests to servers time out before the next check.
+func (s *Sentinel) timeout() time.Duration {
+	return s.cfg.CheckInterval
+}
+
+// Checks the primary once, failing over if it is down.
+func (s *Sentinel) check() {
+	primary := s.Primary()
+	r, err := queryRole(primary, s.timeout())
+	if err != nil {
+		s.mu.Lock()
+		down := time.Since(s.lastReply) >= s.cfg.DownAfter
+		s.mu.Unlock()
+		if down {
+			s.primaryDown(primary)
+		}
+		return
+	}
+
+	s.mu.Lock()
+	s.lastReply = time.Now()
+	s.mu.Unlock()
+
+	if !r.primary {
+		// Demoted without a sentinel, e.g. by FAILOVER
+		s.logger.Info("primary became a replica, following its primary", "primary", primary, "newPrimary", r.of)
+		s.switchPrimary(primary, r.of)
+		return
+	}
+
+	s.mu.Lock()
+	for _, addr := range r.replicas {
+		s.replicas[addr] = struct{}{}
+	}
+	s.mu.Unlock()
+	s.reconfigureReplicas(primary)
+}
+
+// Points the known replicas that replicate another server, or became primaries, to the primary.
+func (s *Sentinel) reconfigureReplicas(primary string) {
+	for _, addr := range s.Replicas() {
+		r, err := queryRole(addr, s.timeout())
+		if err != nil || (!r.primary && r.of == primary) {
+			continue
+		}
+
+		s.logger.Info("reconfiguring replica", "replica", addr, "primary", primary)
+		if err := replicaOf(addr, primary, s.timeout()); err != nil {
+			s.logger.Warn("failed to reconfigure replica", "replica", addr, "error", err)
+		}
+	}
+}
+
+// Replaces the primary, keeping the former one as a replica to reconfigure once it is back.
+func (s *Sentinel) switchPrimary(from, to string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.primary != from || from == to {
+		return
+	}
+	s.primary = to
+	s.lastReply = time.Now()
+	delete(s.replicas, to)
+	s.replicas[from] = struct{}{}
+}
+
+// Handles a primary that did not reply for longer than DownAfter. The primary is failed over once
+// enough sentinels agree it is down and this sentinel wins the election among them.
+func (s *Sentinel) primaryDown(primary string) {
+	// Another sentinel may have failed over already
+	for _, addr := range s.Replicas() {
+		if r, err := queryRole(addr, s.timeout()); err == nil && r.primary {
+			s.logger.Info("replica was promoted, following it", "primary", primary, "newPrimary", addr)
+			s.switchPrimary(primary, addr)
+			return
+		}
+	}
+
+	host, port, _ := net.SplitHostPort(primary)
+	agreed := 1
+	for _, peer := range s.cfg.Peers {
+		if down, _, _ := s.askPeer(peer, host, port, 0, "*"); down {
+			agreed++
+		}
+	}
+	if agreed < s.cfg.Quorum {
+		s.logger.Warn("primary is down, waiting for the quorum", "primary", primary, "agreed", agreed, "quorum", s.cfg.Quorum)
+		return
+	}
+
+	s.mu.Lock()
+	if time.Now().Before(s.nextFailover) {
+		s.mu.Unlock()
+		return
+	}
+	s.nextFailover = time.Now().Add(s.cfg.FailoverTimeout)
+	s.epoch++
+	epoch := s.epoch
+	s.votedEpoch, s.votedFor = epoch, s.id
+	s.mu.Unlock()
+
+	votes := 1
+	for _, peer := range s.cfg.Peers {
+		if _, leader, leaderEpoch := s.askPeer(peer, host, port, epoch, s.id); leader == s.id && leaderEpoch == epoch {
+			votes++
+		}
+	}
+	if needed := max(s.cfg.Quorum, (len(s.cfg.Peers)+1)/2+1); votes < needed {
+		s.logger.Warn("primary is down, election lost", "primary", primary, "epoch", epoch, "votes", votes, "needed", needed)
+		return
+	}
+
+	s.failover(primary, epoch)
+}
+
+// Asks a peer whether it considers the primary down, and for its vote when runID is not "*".
+// Returns the leader the peer voted for and its epoch.
+func (s *Sentinel) askPeer(peer, host, port string, epoch uint64, runID string) (bool, string, uint64) {
+	val, err := request(peer, s.timeout(), "SENTINEL", "IS-MASTER-DOWN-BY-ADDR", host, port, strconv.FormatUint(epoch, 10), runID)
+	if err != nil {
+		return false, "", 0
+	}
+	return parseDownReply(val)
+}
+
+// Promotes the replica with the most data to replace the primary and tells the peers.
+func (s *Sentinel) failover(primary string, epoch uint64) {
+	var best string
+	var bestOffset int64 = -1
+	for _, addr := range s.Replicas() {
+		r, err := queryRole(addr, s.timeout())
+		if err != nil || r.primary || r.of != primary {
+			continue
+		}
+		if r.offset > bestOffset {
+			best, bestOffset = addr, r.offset
+		}
+	}
+	if best == "" {
+		s.logger.Error("primary is down, no replica can be promoted", "primary", primary)
+		return
+	}
+
+	s.logger.Info("failing over", "primary", primary, "newPrimary", best, "epoch", epoch)
+	if err := replicaOf(best, "", s.timeout()); err != nil {
+		s.logger.Error("failed to promote replica", "replica", best, "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	s.configEpoch = max(s.configEpoch, epoch)
+	s.mu.Unlock()
+	s.switchPrimary(primary, best)
+
+	host, port, _ := net.SplitHostPort(best)
+	for _, peer := range s.cfg.Peers {
+		if _, err := request(peer, s.timeout(), "SENTINEL", "SWITCH-PRIMARY", s.cfg.Name, host, port, strconv.FormatUint(epoch, 10)); err != nil {
+			s.logger.Warn("failed to announce the new primary", "peer", peer, "error", err)
+		}
+	}
+	s.reconfigureReplicas(best)
+}
+
+// Reports whether this sentinel considers the primary at addr down, voting for runID in epoch unless
+// it already voted in that epoch. Returns the sentinel voted for in the latest epoch and that epoch.
+func (s *Sentinel) isDown(addr string, epoch uint64, runID string) (bool, string, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	down := addr == s.primary && time.Since(s.lastReply) >= s.cfg.DownAfter
+	if runID == "*" {
+		return down, "*", 0
+	}
+
+	if epoch > s.votedEpoch {
+		s.votedEpoch, s.votedFor = epoch, runID
+		s.epoch = max(s.epoch, epoch)
+		// The elected sentinel fails over, the others wait
+		s.nextFailover = time.Now().Add(s.cfg.FailoverTimeout)
+	}
+	return down, s.votedFor, s.votedEpoch
+}
+
+// Replaces the primary with the one promoted by the sentinel elected in epoch, unless a later
+// failover was already seen.
+func (s *Sentinel) announced(addr string, epoch uint64) bool {
+	s.mu.Lock()
+	if epoch < s.configEpoch {
+		s.mu.Unlock()
+		return false
+	}
+	s.configEpoch = epoch
+	from := s.primary
+	s.mu.Unlock()
+
+	s.switchPrimary(from, addr)
+	return true
+}
@@ -0,0 +1,238 @@
+package sentinel
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Server answering ROLE, REPLICAOF and PING like a GopherStore server would.
+type fakeNode struct {
+	t    *testing.T
+	addr string
+
+	mu       sync.Mutex
+	ln       net.Listener
+	of       string   // Primary replicated, empty for a primary
+	offset   int64    // Offset reported by ROLE
+	replicas []string // Replicas reported by ROLE as a primary
+}
+
+func startFakeNode(t *testing.T, of string, offset int64) *fakeNode {
+	n := &fakeNode{t: t, of: of, offset: offset}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.addr = ln.Addr().String()
+	n.serve(ln)
+	t.Cleanup(n.stop)
+	return n
+}
+
+func (n *fakeNode) serve(ln net.Listener) {
+	n.mu.Lock()
+	n.ln = ln
+	n.mu.Unlock()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go n.serveConn(conn)
+		}
+	}()
+}
+
+func (n *fakeNode) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		val, err := resp.ReadRESP(reader)
+		if err != nil {
+			return
+		}
+		args, _ := commandArgs(val)
+		conn.Write(n.handle(args))
+	}
+}
+
+func (n *fakeNode) handle(args []string) []byte {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "ROLE":
+		if n.of == "" {
+			replicas := make([][]byte, len(n.replicas))
+			for i, addr := range n.replicas {
+				host, port, _ := net.SplitHostPort(addr)
+				replicas[i] = resp.EncodeBulkStringArray([][]byte{[]byte(host), []byte(port), []byte("0")})
+			}
+			return resp.EncodeArray([][]byte{
+				resp.EncodeBulkString([]byte("master")),
+				resp.EncodeInteger(n.offset),
+				resp.EncodeArray(replicas),
+			})
+		}
+		host, port, _ := net.SplitHostPort(n.of)
+		portNum, _ := strconv.Atoi(port)
+		return resp.EncodeArray([][]byte{
+			resp.EncodeBulkString([]byte("slave")),
+			resp.EncodeBulkString([]byte(host)),
+			resp.EncodeInteger(int64(portNum)),
+			resp.EncodeBulkString([]byte("connected")),
+			resp.EncodeInteger(n.offset),
+		})
+	case "REPLICAOF":
+		n.of = ""
+		if !strings.EqualFold(args[1], "NO") {
+			n.of = net.JoinHostPort(args[1], args[2])
+		}
+		return resp.EncodeSimpleString("OK")
+	default:
+		return resp.EncodeSimpleString("PONG")
+	}
+}
+
+func (n *fakeNode) setReplicas(replicas ...string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.replicas = replicas
+}
+
+func (n *fakeNode) role() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.of
+}
+
+// Stops accepting connections, as if the server went down.
+func (n *fakeNode) stop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.ln.Close()
+}
+
+// Accepts connections again on the same address, as a primary with its data.
+func (n *fakeNode) restart() {
+	ln, err := net.Listen("tcp", n.addr)
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	n.mu.Lock()
+	n.of = ""
+	n.mu.Unlock()
+	n.serve(ln)
+}
+
+func newTestSentinel(t *testing.T, primary string, peers []string, quorum int) (*Sentinel, string) {
+	s := New(Config{
+		Name:            "primary",
+		Primary:         primary,
+		Peers:           peers,
+		Quorum:          quorum,
+		DownAfter:       50 * time.Millisecond,
+		CheckInterval:   10 * time.Millisecond,
+		FailoverTimeout: time.Second,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(func() { ln.Close() })
+
+	return s, ln.Addr().String()
+}
+
+func waitUntil(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	primary := startFakeNode(t, "", 100)
+	behind := startFakeNode(t, primary.addr, 10)
+	ahead := startFakeNode(t, primary.addr, 20)
+	primary.setReplicas(behind.addr, ahead.addr)
+
+	s, addr := newTestSentinel(t, primary.addr, nil, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.Run(stop)
+	waitUntil(t, "Expected the replicas to be discovered", func() bool { return len(s.Replicas()) == 2 })
+
+	primary.stop()
+	waitUntil(t, "Expected the replica with the most data to be promoted", func() bool { return s.Primary() == ahead.addr })
+	if of := ahead.role(); of != "" {
+		t.Errorf("Expected the promoted replica to be a primary, replicating %s", of)
+	}
+	waitUntil(t, "Expected the other replica to replicate the new primary", func() bool { return behind.role() == ahead.addr })
+
+	val, err := request(addr, time.Second, "SENTINEL", "GET-PRIMARY-ADDR-BY-NAME", "primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(ahead.addr)
+	if arr, ok := val.(resp.RespArray); !ok || len(arr.Elements) != 2 ||
+		string(arr.Elements[0].(resp.RespBulkString).Value) != host || string(arr.Elements[1].(resp.RespBulkString).Value) != port {
+		t.Errorf("Expected the address of the new primary, got %v", val)
+	}
+
+	// The former primary comes back as a primary and is made a replica
+	primary.restart()
+	waitUntil(t, "Expected the former primary to replicate the new primary", func() bool { return primary.role() == ahead.addr })
+}
+
+func TestFailoverQuorum(t *testing.T) {
+	primary := startFakeNode(t, "", 100)
+	replica := startFakeNode(t, primary.addr, 100)
+	primary.setReplicas(replica.addr)
+
+	// The peer never considers the primary down since it monitors another one
+	_, peer := newTestSentinel(t, "127.0.0.1:1", nil, 1)
+	s, _ := newTestSentinel(t, primary.addr, []string{peer}, 2)
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.Run(stop)
+	waitUntil(t, "Expected the replica to be discovered", func() bool { return len(s.Replicas()) == 1 })
+
+	primary.stop()
+	time.Sleep(200 * time.Millisecond)
+	if s.Primary() != primary.addr || replica.role() != primary.addr {
+		t.Error("Expected no failover without the quorum")
+	}
+}
+
+func TestVoting(t *testing.T) {
+	s, _ := newTestSentinel(t, "127.0.0.1:1", nil, 1)
+
+	if _, leader, epoch := s.isDown("127.0.0.1:1", 1, "first"); leader != "first" || epoch != 1 {
+		t.Errorf("Expected the vote to go to the first sentinel, got %s in epoch %d", leader, epoch)
+	}
+	if _, leader, _ := s.isDown("127.0.0.1:1", 1, "second"); leader != "first" {
+		t.Errorf("Expected a single vote per epoch, got %s", leader)
+	}
+	if _, leader, epoch := s.isDown("127.0.0.1:1", 2, "second"); leader != "second" || epoch != 2 {
+		t.Errorf("Expected a vote in a later epoch, got %s in epoch %d", leader, epoch)
+	}
+}
@@ -0,0 +1,165 @@
+package sentinel
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Answers clients and peer sentinels on the listener until it is closed.
+//
+// Commands:
+//   - PING
+//   - SENTINEL GET-PRIMARY-ADDR-BY-NAME name (or GET-MASTER-ADDR-BY-NAME): [host, port] of the primary
+//   - SENTINEL REPLICAS name: host:port of the known replicas
+//   - SENTINEL MYID
+//   - SENTINEL IS-MASTER-DOWN-BY-ADDR host port epoch runid: sent by peers, [down, leader, epoch]
+//   - SENTINEL SWITCH-PRIMARY name host port epoch: sent by the peer that failed over
+func (s *Sentinel) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Sentinel) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		val, err := resp.ReadRESP(reader)
+		if err != nil {
+			return
+		}
+
+		reply := resp.EncodeError("expected a command as an array of bulk strings")
+		if args, ok := commandArgs(val); ok {
+			reply = s.handleCommand(args)
+		}
+		if _, err := conn.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+// Returns the name and arguments of a command sent as an array of bulk strings.
+func commandArgs(val resp.RespValue) ([]string, bool) {
+	arr, ok := val.(resp.RespArray)
+	if !ok || len(arr.Elements) == 0 {
+		return nil, false
+	}
+
+	args := make([]string, len(arr.Elements))
+	for i, elem := range arr.Elements {
+		arg, ok := elem.(resp.RespBulkString)
+		if !ok {
+			return nil, false
+		}
+		args[i] = string(arg.Value)
+	}
+	return args, true
+}
+
+func (s *Sentinel) handleCommand(args []string) []byte {
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return resp.EncodeSimpleString("PONG")
+	case "SENTINEL":
+		if len(args) < 2 {
+			return resp.EncodeError("SENTINEL command requires a subcommand")
+		}
+		return s.handleSentinelCommand(strings.ToUpper(args[1]), args[2:])
+	default:
+		return resp.EncodeError("unknown command: " + args[0])
+	}
+}
+
+func (s *Sentinel) handleSentinelCommand(subcommand string, args []string) []byte {
+	switch subcommand {
+	case "GET-PRIMARY-ADDR-BY-NAME", "GET-MASTER-ADDR-BY-NAME":
+		if len(args) != 1 {
+			return resp.EncodeError("SENTINEL " + subcommand + " requires a name")
+		}
+		if args[0] != s.cfg.Name {
+			return resp.EncodeBulkString(nil)
+		}
+		host, port, _ := net.SplitHostPort(s.Primary())
+		return resp.EncodeBulkStringArray([][]byte{[]byte(host), []byte(port)})
+	case "REPLICAS", "SLAVES":
+		if len(args) != 1 {
+			return resp.EncodeError("SENTINEL " + subcommand + " requires a name")
+		}
+		if args[0] != s.cfg.Name {
+			return resp.EncodeError("no such primary with that name")
+		}
+		replicas := s.Replicas()
+		reply := make([][]byte, len(replicas))
+		for i, addr := range replicas {
+			reply[i] = []byte(addr)
+		}
+		return resp.EncodeBulkStringArray(reply)
+	case "MYID":
+		return resp.EncodeBulkString([]byte(s.id))
+	case "IS-MASTER-DOWN-BY-ADDR":
+		if len(args) != 4 {
+			return resp.EncodeError("SENTINEL IS-MASTER-DOWN-BY-ADDR requires a host, port, epoch and run ID")
+		}
+		epoch, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			return resp.EncodeError("invalid epoch")
+		}
+
+		down, leader, leaderEpoch := s.isDown(net.JoinHostPort(args[0], args[1]), epoch, args[3])
+		downFlag := int64(0)
+		if down {
+			downFlag = 1
+		}
+		return resp.EncodeArray([][]byte{
+			resp.EncodeInteger(downFlag),
+			resp.EncodeBulkString([]byte(leader)),
+			resp.EncodeInteger(int64(leaderEpoch)),
+		})
+	case "SWITCH-PRIMARY":
+		if len(args) != 4 {
+			return resp.EncodeError("SENTINEL SWITCH-PRIMARY requires a name, host, port and epoch")
+		}
+		epoch, err := strconv.ParseUint(args[3], 10, 64)
+		if err != nil {
+			return resp.EncodeError("invalid epoch")
+		}
+		if args[0] != s.cfg.Name {
+			return resp.EncodeError("no such primary with that name")
+		}
+
+		addr := net.JoinHostPort(args[1], args[2])
+		if s.announced(addr, epoch) {
+			s.logger.Info("primary switched by a peer", "primary", addr, "epoch", epoch)
+		}
+		return resp.EncodeSimpleString("OK")
+	default:
+		return resp.EncodeError("unknown SENTINEL subcommand: " + subcommand)
+	}
+}
+
+// Parses the reply to SENTINEL IS-MASTER-DOWN-BY-ADDR.
+func parseDownReply(val resp.RespValue) (bool, string, uint64) {
+	arr, ok := val.(resp.RespArray)
+	if !ok || len(arr.Elements) != 3 {
+		return false, "", 0
+	}
+
+	down, _ := arr.Elements[0].(resp.RespInteger)
+	leader, _ := arr.Elements[1].(resp.RespBulkString)
+	epoch, _ := arr.Elements[2].(resp.RespInteger)
+	return down.Value == 1, string(leader.Value), uint64(epoch.Value)
+}
@@ -0,0 +1,375 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/encryption"
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// When the append-only file is synced to disk, using the values of Redis' appendfsync setting.
+type FsyncPolicy uint8
+
+const (
+	FsyncEverySec FsyncPolicy = iota // Sync once per second in the background, losing at most a second of writes
+	FsyncAlways                      // Sync before replying to the commands that wrote, the slowest and safest
+	FsyncNo                          // Leave syncing to the operating system
+)
+
+// Parses an appendfsync value: always, everysec or no.
+func ParseFsyncPolicy(policy string) (FsyncPolicy, error) {
+	switch policy {
+	case "always":
+		return FsyncAlways, nil
+	case "everysec":
+		return FsyncEverySec, nil
+	case "no":
+		return FsyncNo, nil
+	default:
+		return 0, fmt.Errorf("invalid fsync policy %q, expected always, everysec or no", policy)
+	}
+}
+
+func (p FsyncPolicy) String() string {
+	switch p {
+	case FsyncAlways:
+		return "always"
+	case FsyncNo:
+		return "no"
+	default:
+		return "everysec"
+	}
+}
+
+// Log of the commands that changed the store, replayed at startup to restore it. Commands are
+// buffered in memory and written to the file once per server loop iteration, the fsync policy
+// decides when the file is synced to disk.
+type appendOnlyFile struct {
+	file     *os.File
+	writer   *bufio.Writer // Owned by the server loop
+	size     int64         // Length of the commands in the file once buffered ones are written, before any encryption, owned by the server loop
+	policy   FsyncPolicy
+	latency  *latencyMonitor
+	unsynced atomic.Bool // Data was written since the last sync
+	closeCh  chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Opens the append-only file at path for appending, creating it if needed, and starts syncing it
+// every second under the everysec policy. Size is the length of the commands already in the file,
+// which are encrypted with keys unless nil. Slow writes and syncs are recorded by latency.
+func openAppendOnlyFile(path string, size int64, policy FsyncPolicy, keys encryption.KeyProvider, latency *latencyMonitor) (*appendOnlyFile, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	var w io.Writer = file
+	if keys != nil {
+		// Each open starts a new segment, so the file can be reopened after a key rotation
+		w = &encryptingWriter{w: file, keys: keys}
+	}
+
+	aof := &appendOnlyFile{
+		file:    file,
+		writer:  bufio.NewWriter(w),
+		size:    size,
+		policy:  policy,
+		latency: latency,
+		closeCh: make(chan struct{}),
+	}
+	if policy == FsyncEverySec {
+		aof.wg.Add(1)
+		go aof.syncEverySecond()
+	}
+
+	return aof, nil
+}
+
+// Buffers a command to be written with the next flush.
+func (aof *appendOnlyFile) append(args [][]byte) {
+	// Writes to a bufio.Writer only fail once a flush failed, which flush reports
+	n, _ := aof.writer.Write(resp.EncodeBulkStringArray(args))
+	aof.size += int64(n)
+}
+
+// Writes the buffered commands to the file, syncing it under the always policy.
+func (aof *appendOnlyFile) flush() error {
+	if aof.writer.Buffered() == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	if err := aof.writer.Flush(); err != nil {
+		return err
+	}
+	aof.latency.record(latencyAOFWrite, time.Since(start))
+
+	if aof.policy == FsyncAlways {
+		start = time.Now()
+		err := aof.file.Sync()
+		aof.latency.record(latencyAOFFsyncAlways, time.Since(start))
+		return err
+	}
+
+	aof.unsynced.Store(true)
+	return nil
+}
+
+// Writes the buffered commands and syncs the file whatever the policy.
+func (aof *appendOnlyFile) sync() error {
+	if err := aof.writer.Flush(); err != nil {
+		return err
+	}
+	if err := aof.file.Sync(); err != nil {
+		return err
+	}
+
+	aof.unsynced.Store(false)
+	return nil
+}
+
+// Syncs the file once per second while there are writes to sync. Syncing runs beside the server
+// loop, so a slow disk only delays durability and not the commands.
+func (aof *appendOnlyFile) syncEverySecond() {
+	defer aof.wg.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !aof.unsynced.Swap(false) {
+				continue
+			}
+			start := time.Now()
+			if err := aof.file.Sync(); err != nil {
+				// Retried on the next tick
+				aof.unsynced.Store(true)
+			}
+			aof.latency.record(latencyAOFFsync, time.Since(start))
+		case <-aof.closeCh:
+			return
+		}
+	}
+}
+
+// Writes the buffered commands, syncs the file and closes it.
+func (aof *appendOnlyFile) close() error {
+	close(aof.closeCh)
+	aof.wg.Wait()
+
+	flushErr := aof.writer.Flush()
+	syncErr := aof.file.Sync()
+	closeErr := aof.file.Close()
+	return errors.Join(flushErr, syncErr, closeErr)
+}
+
+// Enables the append-only file at path, which is replayed into the store by ListenAndServe before
+// accepting connections and then receives every command that changes the store.
+// Must be called before ListenAndServe.
+func (s *Server) SetAppendOnly(path string, policy FsyncPolicy) {
+	s.aofPath = path
+	s.aofPolicy = policy
+}
+
+// Replays the append-only file from offset into the store and opens it for appending. A command cut
+// short at the end of the file, as left by a crash in the middle of a write, is truncated with a
+// warning. Offsets count the bytes of the commands, before any encryption.
+func (s *Server) loadAppendOnlyFile(offset int64) error {
+	if s.aofPath == "" {
+		return nil
+	}
+
+	size, err := s.replayAppendOnlyFile(offset)
+	if err != nil {
+		return fmt.Errorf("failed to load append-only file %s: %w", s.aofPath, err)
+	}
+
+	aof, err := openAppendOnlyFile(s.aofPath, size, s.aofPolicy, s.persistKeys, s.latency)
+	if err != nil {
+		return fmt.Errorf("failed to open append-only file %s: %w", s.aofPath, err)
+	}
+	s.aof = aof
+
+	return nil
+}
+
+// Replays the commands of the append-only file after from, returning the length of the commands
+// it holds once an incomplete tail is removed.
+func (s *Server) replayAppendOnlyFile(from int64) (int64, error) {
+	file, err := os.Open(s.aofPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	source, encrypted, err := s.persistenceReader(file)
+	if err != nil {
+		return 0, err
+	}
+	if encrypted {
+		// Offsets are positions in the plaintext, so the commands before from are decrypted and skipped
+		if _, err := io.CopyN(io.Discard, source, from); errors.Is(err, io.EOF) {
+			return 0, fmt.Errorf("the file ends before offset %d where the snapshot was taken, the files do not belong together", from)
+		} else if err != nil {
+			return 0, err
+		}
+	} else {
+		if _, err := file.Seek(from, io.SeekStart); err != nil {
+			return 0, err
+		}
+		source = file
+	}
+
+	// Replies of the replayed commands are discarded
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	loader := NewClient(conn, nil, nil, s.logger)
+	loader.muted.Store(true)
+
+	counter := &countingReader{r: source, n: from}
+	reader := bufio.NewReader(counter)
+	offset := from // End of the last complete command
+	var commands int
+	var torn bool
+
+	for {
+		v, err := resp.ReadRESP(reader)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// An encrypted file can also end with part of a frame, which holds no complete command
+			end := counter.n - int64(reader.Buffered())
+			torn = end != offset || errors.Is(err, io.ErrUnexpectedEOF)
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("invalid command at offset %d: %w", offset, err)
+		}
+
+		arr, ok := v.(resp.RespArray)
+		if !ok || len(arr.Elements) == 0 {
+			return 0, fmt.Errorf("invalid command at offset %d: expected a non-empty array", offset)
+		}
+		cmd, err := parseCommand(arr, s.commands)
+		if err != nil {
+			return 0, fmt.Errorf("invalid command at offset %d: %w", offset, err)
+		}
+
+		s.handleMessage(Message{cmd: cmd, client: loader})
+		offset = counter.n - int64(reader.Buffered())
+		commands++
+	}
+
+	s.readyKeys = nil
+	s.logger.Info("loaded append-only file", "path", s.aofPath, "from", from, "commands", commands)
+	file.Close()
+
+	if torn {
+		s.logger.Warn("truncating incomplete command at the end of the append-only file", "path", s.aofPath, "offset", offset)
+	}
+	if rewrite, reason := s.appendOnlyRewriteReason(source, encrypted, offset, torn); rewrite {
+		s.logger.Info("rewriting append-only file", "path", s.aofPath, "reason", reason)
+		return offset, s.rewriteAppendOnlyFile(offset)
+	}
+	if torn {
+		return offset, os.Truncate(s.aofPath, offset)
+	}
+	return offset, nil
+}
+
+// Reports whether the append-only file must be rewritten with the current encryption key after
+// being read from source, and why: it is in plaintext while encryption is enabled, it uses an older
+// key, or it ends with an incomplete command that cannot be truncated in place because it is
+// encrypted.
+func (s *Server) appendOnlyRewriteReason(source io.Reader, encrypted bool, size int64, torn bool) (bool, string) {
+	if s.persistKeys == nil {
+		return false, ""
+	}
+	if !encrypted {
+		return size > 0 || torn, "encryption enabled"
+	}
+
+	current, _, err := s.persistKeys.CurrentKey()
+	if err != nil {
+		return false, ""
+	}
+	if slices.ContainsFunc(source.(*encryption.Reader).KeyIDs(), func(id string) bool { return id != current }) {
+		return true, "key rotated"
+	}
+	return torn, "incomplete command"
+}
+
+// Replaces the append-only file by the first size bytes of its commands, encrypted with the current
+// key.
+func (s *Server) rewriteAppendOnlyFile(size int64) error {
+	file, err := os.Open(s.aofPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	source, _, err := s.persistenceReader(file)
+	if err != nil {
+		return err
+	}
+	return replaceFile(s.aofPath, func(tmp *os.File) error {
+		_, err := io.CopyN(&encryptingWriter{w: tmp, keys: s.persistKeys}, source, size)
+		return err
+	})
+}
+
+// Counts the bytes read from a reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Writes the buffered commands to the append-only file, then sends the replies held until the
+// writes they acknowledge were synced.
+// Must be called from the server loop.
+func (s *Server) flushAppendOnly() {
+	if s.aof == nil {
+		return
+	}
+
+	if err := s.aof.flush(); err != nil {
+		s.logger.Error("failed to write append-only file", "path", s.aofPath, "error", err)
+	}
+
+	for _, client := range s.heldClients {
+		client.releaseReplies()
+	}
+	s.heldClients = nil
+}
+
+// Holds the replies to a client until the next flush of the append-only file under the always
+// fsync policy, so no write is acknowledged before it is on disk.
+// Must be called from the server loop.
+func (s *Server) holdReplies(client *Client) {
+	if s.aof == nil || s.aof.policy != FsyncAlways || client.holdingReplies() {
+		return
+	}
+
+	client.holdReplies()
+	s.heldClients = append(s.heldClients, client)
+}
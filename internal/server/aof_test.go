@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+func TestParseFsyncPolicy(t *testing.T) {
+	for _, name := range []string{"always", "everysec", "no"} {
+		policy, err := ParseFsyncPolicy(name)
+		if err != nil || policy.String() != name {
+			t.Errorf("Expected %s to parse, got %v (%v)", name, policy, err)
+		}
+	}
+
+	if _, err := ParseFsyncPolicy("sometimes"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+// Returns a server replaying and appending to the append-only file at path.
+func newAppendOnlyServer(t *testing.T, path string) *Server {
+	s := newTestServer(t)
+	s.SetAppendOnly(path, FsyncAlways)
+	if err := s.loadAppendOnlyFile(0); err != nil {
+		t.Fatalf("Failed to load append-only file: %v", err)
+	}
+	return s
+}
+
+func TestAppendOnlyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	s := newAppendOnlyServer(t, path)
+	client := newTestClient()
+
+	run := func(args ...string) {
+		cmd, err := parseCommand(commandArray(args...), nil)
+		if err != nil {
+			t.Fatalf("Failed to parse %v: %v", args, err)
+		}
+		s.processMessages([]Message{{cmd: cmd, args: commandArgs(commandArray(args...)), client: client}})
+		drainReplies(client)
+	}
+
+	run("SET", "greeting", "hello", "EX", "100")
+	run("RPUSH", "queue", "a", "b")
+	run("BLPOP", "queue", "0")
+	run("XADD", "events", "*", "type", "login")
+	run("XGROUP", "CREATE", "events", "workers", "0")
+	run("XREADGROUP", "GROUP", "workers", "alice", "STREAMS", "events", ">")
+	run("XADD", "events", "*", "type", "logout")
+	run("XREADGROUP", "GROUP", "workers", "alice", "STREAMS", "events", ">")
+	run("XREADGROUP", "GROUP", "workers", "alice", "STREAMS", "events", ">")
+	run("GET", "greeting")
+	run("DEL", "missing")
+	if err := s.aof.close(); err != nil {
+		t.Fatalf("Failed to close append-only file: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// Reads are not logged, and relative expirations, generated IDs and blocking pops are rewritten
+	var logged []string
+	reader := bufio.NewReader(file)
+	for {
+		v, err := resp.ReadRESP(reader)
+		if err != nil {
+			break
+		}
+		logged = append(logged, string(v.(resp.RespArray).Elements[0].(resp.RespBulkString).Value))
+	}
+	expected := []string{"SET", "PEXPIREAT", "RPUSH", "LPOP", "XADD", "XGROUP", "XREADGROUP", "XADD", "XREADGROUP"}
+	if !slices.Equal(logged, expected) {
+		t.Errorf("Expected %v to be logged, got %v", expected, logged)
+	}
+
+	restored := newAppendOnlyServer(t, path)
+	defer restored.aof.close()
+
+	if value, _ := restored.store.GetValue([]byte("greeting")); string(value) != "hello" {
+		t.Errorf("Expected greeting to be restored, got %q", value)
+	}
+	if expected, got := s.store.ExpiresAt([]byte("greeting"))/1e6, restored.store.ExpiresAt([]byte("greeting"))/1e6; got != expected {
+		t.Errorf("Expected the expiration to be kept at %d ms, got %d", expected, got)
+	}
+	if list, _ := restored.store.GetList([]byte("queue")); len(list) != 1 || string(list[0]) != "b" {
+		t.Errorf("Expected the popped element to stay removed, got %q", list)
+	}
+
+	expectedID, _ := s.store.StreamLastID([]byte("events"))
+	if id, _ := restored.store.StreamLastID([]byte("events")); id != expectedID {
+		t.Errorf("Expected the stream entry to keep ID %s, got %s", expectedID, id)
+	}
+	if summary, _ := restored.store.StreamPendingSummary([]byte("events"), []byte("workers")); summary.Count != 2 {
+		t.Errorf("Expected the group reads to restore 2 pending entries, got %d", summary.Count)
+	}
+}
+
+func TestAppendOnlyFileTruncatedTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	complete := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"
+	if err := os.WriteFile(path, []byte(complete+"*3\r\n$3\r\nSET\r\n$5\r\nother"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newAppendOnlyServer(t, path)
+	defer s.aof.close()
+
+	if value, _ := s.store.GetValue([]byte("key")); string(value) != "value" {
+		t.Errorf("Expected the complete command to be replayed, got %q", value)
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(len(complete)) {
+		t.Errorf("Expected the incomplete command to be truncated to %d bytes, got %d", len(complete), info.Size())
+	}
+
+	if err := os.WriteFile(path, []byte("+not a command\r\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := newTestServer(t)
+	corrupt.SetAppendOnly(path, FsyncNo)
+	if err := corrupt.loadAppendOnlyFile(0); err == nil {
+		t.Error("Expected a corrupt append-only file to fail loading")
+	}
+}
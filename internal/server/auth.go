@@ -0,0 +1,114 @@
+package server
+
+import (
+	"crypto/subtle"
+	"runtime/debug"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// The only user, authenticated with the password set by SetRequirePass.
+const defaultUser = "default"
+
+// Sets the password clients must send with AUTH or HELLO before running other commands. Empty
+// disables authentication. Must be called before ListenAndServe or from the server loop, changes only apply
+// to new connections.
+func (s *Server) SetRequirePass(password string) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	s.requirePass = password
+}
+
+// Sets the password sent with AUTH to the primary before replicating it, when it requires one.
+// Changes made after ListenAndServe apply to the next connection to the primary.
+func (s *Server) SetPrimaryAuth(password string) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	s.primaryAuth = password
+}
+
+// Reports whether a command can run before the client authenticates.
+func allowedUnauthenticated(cmd Command) bool {
+	switch cmd.(type) {
+	case AuthCommand, HelloCommand:
+		return true
+	default:
+		return false
+	}
+}
+
+// Reports whether the credentials are the ones of the default user.
+func (s *Server) checkCredentials(auth AuthCommand) bool {
+	return auth.Username == defaultUser &&
+		subtle.ConstantTimeCompare([]byte(auth.Password), []byte(s.requirePass)) == 1
+}
+
+// Handles an AUTH command from a client.
+func (s *Server) handleAuthCommand(cmd AuthCommand, client *Client) {
+	if s.requirePass == "" && cmd.Username == defaultUser {
+		client.SendMessage(resp.EncodeError("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?"))
+		return
+	}
+
+	if !s.checkCredentials(cmd) {
+		s.logger.Warn("failed authentication", "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError("WRONGPASS invalid username-password pair or user is disabled."))
+		return
+	}
+
+	client.authenticated = true
+	client.SendMessage(resp.EncodeSimpleString("OK"))
+}
+
+// Handles a HELLO command from a client. Switches to the requested protocol, after authenticating
+// and naming the client if asked to, and replies with a map describing the server.
+func (s *Server) handleHelloCommand(cmd HelloCommand, client *Client) {
+	if cmd.Auth != nil {
+		if s.requirePass != "" && !s.checkCredentials(*cmd.Auth) {
+			s.logger.Warn("failed authentication", "remoteAddr", client.conn.RemoteAddr().String())
+			client.SendMessage(resp.EncodeError("WRONGPASS invalid username-password pair or user is disabled."))
+			return
+		}
+		client.authenticated = true
+	}
+	if !client.authenticated {
+		client.SendMessage(resp.EncodeError("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time"))
+		return
+	}
+
+	if cmd.Protocol != 0 {
+		client.protocol = cmd.Protocol
+	}
+	if cmd.Name != nil {
+		client.name = *cmd.Name
+	}
+
+	role := "master"
+	if s.link != nil {
+		role = "replica"
+	}
+	mode := "standalone"
+	if s.cluster != nil {
+		mode = "cluster"
+	}
+
+	client.SendMessage(resp.EncodeMap([][]byte{
+		resp.EncodeBulkString([]byte("server")), resp.EncodeBulkString([]byte("gopherstore")),
+		resp.EncodeBulkString([]byte("version")), resp.EncodeBulkString([]byte(serverVersion())),
+		resp.EncodeBulkString([]byte("proto")), resp.EncodeInteger(int64(client.protocol)),
+		resp.EncodeBulkString([]byte("id")), resp.EncodeInteger(client.id),
+		resp.EncodeBulkString([]byte("mode")), resp.EncodeBulkString([]byte(mode)),
+		resp.EncodeBulkString([]byte("role")), resp.EncodeBulkString([]byte(role)),
+		resp.EncodeBulkString([]byte("modules")), resp.EncodeArray([][]byte{}),
+	}, client.protocol))
+}
+
+// Returns the version of the server module, as stamped by the Go toolchain at build time.
+func serverVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
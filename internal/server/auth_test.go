@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+func TestRequirePass(t *testing.T) {
+	_, addr := startTestServer(t, func(s *Server, _ string) {
+		s.SetRequirePass("secret")
+	})
+	client := dialTestServer(t, addr)
+	ok := resp.RespSimpleString{Value: "OK"}
+
+	isError := func(val resp.RespValue, prefix string) bool {
+		errVal, ok := val.(resp.RespErrorValue)
+		return ok && strings.HasPrefix(errVal.Message, prefix)
+	}
+
+	if val := client.do("SET", "key", "value"); !isError(val, "NOAUTH") {
+		t.Errorf("Expected commands to require authentication, got %v", val)
+	}
+	if val := client.do("HELLO", "2"); !isError(val, "NOAUTH") {
+		t.Errorf("Expected HELLO without AUTH to require authentication, got %v", val)
+	}
+	if val := client.do("AUTH", "wrong"); !isError(val, "WRONGPASS") {
+		t.Errorf("Expected a wrong password to be rejected, got %v", val)
+	}
+	if val := client.do("AUTH", "someone", "secret"); !isError(val, "WRONGPASS") {
+		t.Errorf("Expected an unknown user to be rejected, got %v", val)
+	}
+	if val := client.do("AUTH", "secret"); val != ok {
+		t.Fatalf("Expected AUTH to succeed, got %v", val)
+	}
+	if val := client.do("SET", "key", "value"); val != ok {
+		t.Errorf("Expected commands to run once authenticated, got %v", val)
+	}
+
+	other := dialTestServer(t, addr)
+	if val, isArray := other.do("HELLO", "2", "AUTH", "default", "secret", "SETNAME", "worker").(resp.RespArray); !isArray || len(val.Elements) != 14 {
+		t.Fatalf("Expected HELLO to authenticate and reply with the server properties, got %v", val)
+	}
+	if val, _ := other.do("CLIENT", "GETNAME").(resp.RespBulkString); string(val.Value) != "worker" {
+		t.Errorf("Expected HELLO SETNAME to name the client, got %q", val.Value)
+	}
+}
+
+func TestRequirePassReplication(t *testing.T) {
+	_, primaryAddr := startTestServer(t, func(s *Server, _ string) {
+		s.SetRequirePass("secret")
+	})
+	_, replicaAddr := startTestServer(t, func(s *Server, _ string) {
+		s.SetPrimaryAuth("secret")
+	})
+	primary := dialTestServer(t, primaryAddr)
+	replica := dialTestServer(t, replicaAddr)
+
+	host, port, _ := net.SplitHostPort(primaryAddr)
+	primary.do("AUTH", "secret")
+	primary.do("SET", "key", "value")
+	replica.do("REPLICAOF", host, port)
+	replica.waitFor("value", "GET", "key")
+}
+
+func TestHello(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient()
+
+	run := func(cmd Command) string {
+		s.processMessages([]Message{{cmd: cmd, client: client}})
+		return strings.Join(drainReplies(client), "")
+	}
+
+	if reply := run(HelloCommand{}); !strings.HasPrefix(reply, "*14\r\n$6\r\nserver\r\n") || !strings.Contains(reply, "$5\r\nproto\r\n:2\r\n") {
+		t.Errorf("Expected HELLO to reply with a flat array in RESP2, got %q", reply)
+	}
+	if reply := run(HelloCommand{Protocol: resp.RESP3}); !strings.HasPrefix(reply, "%7\r\n") || !strings.Contains(reply, "$5\r\nproto\r\n:3\r\n") {
+		t.Errorf("Expected HELLO 3 to reply with a map, got %q", reply)
+	}
+
+	// Replies follow the protocol of the client
+	run(HSetCommand{Key: []byte("hash"), Pairs: [][]byte{[]byte("field"), []byte("value")}})
+	if reply := run(HGetAllCommand{Key: []byte("hash")}); reply != "%1\r\n$5\r\nfield\r\n$5\r\nvalue\r\n" {
+		t.Errorf("Expected HGETALL to reply with a map, got %q", reply)
+	}
+	run(ZAddCommand{Key: []byte("zset"), Members: []ScoredMember{{Score: 1.5, Member: []byte("a")}}})
+	if reply := run(ZScoreCommand{Key: []byte("zset"), Member: []byte("a")}); reply != ",1.5\r\n" {
+		t.Errorf("Expected ZSCORE to reply with a double, got %q", reply)
+	}
+
+	run(HelloCommand{Protocol: resp.RESP2})
+	if reply := run(ZScoreCommand{Key: []byte("zset"), Member: []byte("a")}); reply != "$3\r\n1.5\r\n" {
+		t.Errorf("Expected ZSCORE to reply with a bulk string after switching back to RESP2, got %q", reply)
+	}
+}
+
+func TestParseHelloCommand(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{args: []string{"HELLO", "4"}, wantErr: "NOPROTO"},
+		{args: []string{"HELLO", "3", "AUTH", "default"}, wantErr: "requires a username and a password"},
+		{args: []string{"HELLO", "3", "SETNAME", "has space"}, wantErr: "Client names cannot contain spaces"},
+		{args: []string{"HELLO", "3", "OTHER"}, wantErr: "syntax error"},
+		{args: []string{"HELLO", "3", "AUTH", "default", "pass", "SETNAME", "name"}},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseCommand(commandArray(tt.args...))
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("ParseCommand(%v) error = %v", tt.args, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("ParseCommand(%v) error = %v, want %q", tt.args, err, tt.wantErr)
+		}
+	}
+}
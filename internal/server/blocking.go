@@ -0,0 +1,346 @@
+package server
+
+import (
+	"bytes"
+	"slices"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// A client parked by a blocking command until one of its keys can serve it or the timeout expires.
+// Blocked clients are only accessed from the server loop.
+type blockedClient struct {
+	client *Client
+	keys   [][]byte
+	timer  *time.Timer // nil when blocking forever
+
+	// Tries to complete the blocked command using the given key.
+	// Returns true if the client was served and a reply was sent.
+	tryServe func(key []byte) bool
+	// Sends the reply for an expired timeout.
+	onTimeout func()
+}
+
+// Parks a client on the given keys until it is served or the timeout expires. A zero timeout blocks forever.
+func (s *Server) blockClient(bc *blockedClient, timeout time.Duration) {
+	for _, key := range bc.keys {
+		s.blockedByKey[string(key)] = append(s.blockedByKey[string(key)], bc)
+	}
+	bc.client.blocked = bc
+
+	if timeout > 0 {
+		bc.timer = time.AfterFunc(timeout, func() {
+			select {
+			case s.timeoutCh <- bc:
+			case <-s.quitCh:
+			}
+		})
+	}
+}
+
+// Removes a blocked client from every key it is waiting on.
+func (s *Server) unblockClient(bc *blockedClient) {
+	if bc.timer != nil {
+		bc.timer.Stop()
+	}
+
+	for _, key := range bc.keys {
+		waiters := s.blockedByKey[string(key)]
+		for i, waiter := range waiters {
+			if waiter == bc {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+
+		if len(waiters) == 0 {
+			delete(s.blockedByKey, string(key))
+		} else {
+			s.blockedByKey[string(key)] = waiters
+		}
+	}
+
+	if bc.client.blocked == bc {
+		bc.client.blocked = nil
+		s.updateIdleExempt(bc.client)
+	}
+}
+
+// Marks a key as possibly able to serve blocked clients, called after writes that add elements to a list.
+func (s *Server) signalKeyReady(key []byte) {
+	if _, ok := s.blockedByKey[string(key)]; ok {
+		s.readyKeys = append(s.readyKeys, string(key))
+	}
+}
+
+// Serves clients blocked on keys marked as ready, in the order they blocked.
+// Returns the clients that were unblocked.
+func (s *Server) serveReadyKeys() []*Client {
+	var unblocked []*Client
+
+	for len(s.readyKeys) > 0 {
+		key := s.readyKeys[0]
+		s.readyKeys = s.readyKeys[1:]
+
+		// Clients that cannot be served stay blocked, but later clients may still be served,
+		// e.g. stream readers waiting for entries after different IDs
+		for i := 0; i < len(s.blockedByKey[key]); {
+			bc := s.blockedByKey[key][i]
+			s.holdReplies(bc.client)
+			if !bc.tryServe([]byte(key)) {
+				i++
+				continue
+			}
+
+			s.unblockClient(bc)
+			unblocked = append(unblocked, bc.client)
+		}
+	}
+
+	return unblocked
+}
+
+// Replies to a blocked client whose timeout expired.
+func (s *Server) handleBlockTimeout(bc *blockedClient) {
+	if bc.client.blocked != bc {
+		// Already served or disconnected
+		return
+	}
+
+	s.unblockClient(bc)
+	bc.onTimeout()
+	s.processMessages(bc.client.takePending())
+}
+
+// Handles BLPOP and BRPOP commands from a client.
+func (s *Server) handleBlockingPopCommand(cmd BlockingPopCommand, client *Client) {
+	// Serve immediately from the first non-empty list
+	for _, key := range cmd.Keys {
+		value, err := s.store.Pop(key, cmd.popAtFront)
+		if err != nil {
+			client.SendMessage(resp.EncodeError(err.Error()))
+			return
+		}
+
+		if value != nil {
+			s.propagate(popArgs(key, cmd.popAtFront))
+			client.SendMessage(resp.EncodeBulkStringArray([][]byte{key, value}))
+			return
+		}
+	}
+
+	s.blockClient(&blockedClient{
+		client: client,
+		keys:   cmd.Keys,
+		tryServe: func(key []byte) bool {
+			value, err := s.store.Pop(key, cmd.popAtFront)
+			if err != nil || value == nil {
+				return false
+			}
+
+			s.propagate(popArgs(key, cmd.popAtFront))
+			client.SendMessage(resp.EncodeBulkStringArray([][]byte{key, value}))
+			return true
+		},
+		onTimeout: func() {
+			client.SendMessage(resp.EncodeBulkStringArray(nil))
+		},
+	}, cmd.Timeout)
+}
+
+// Handles LMOVE and BLMOVE commands from a client.
+func (s *Server) handleMoveCommand(cmd MoveCommand, client *Client) {
+	value, err := s.store.Move(cmd.Source, cmd.Destination, cmd.popAtFront, cmd.pushAtFront)
+	if err != nil {
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	if value != nil || !cmd.blocking {
+		if value != nil {
+			s.signalKeyReady(cmd.Destination)
+		}
+		client.SendMessage(resp.EncodeBulkString(value))
+		return
+	}
+
+	s.blockClient(&blockedClient{
+		client: client,
+		keys:   [][]byte{cmd.Source},
+		tryServe: func(key []byte) bool {
+			value, err := s.store.Move(cmd.Source, cmd.Destination, cmd.popAtFront, cmd.pushAtFront)
+			if err != nil {
+				// The destination changed type while blocked, reply with the error
+				client.SendMessage(resp.EncodeError(err.Error()))
+				return true
+			}
+
+			if value == nil {
+				return false
+			}
+
+			s.propagate(moveArgs(cmd))
+			s.signalKeyReady(cmd.Destination)
+			client.SendMessage(resp.EncodeBulkString(value))
+			return true
+		},
+		onTimeout: func() {
+			client.SendMessage(resp.EncodeBulkString(nil))
+		},
+	}, cmd.Timeout)
+}
+
+// Returns the entries of the stream stored at key added after the given ID.
+func (s *Server) readStreamAfter(key []byte, id StreamID, count int) ([]StreamEntry, error) {
+	start, ok := id.next()
+	if !ok {
+		return nil, nil
+	}
+	return s.store.StreamRange(key, start, maxStreamID, count, false)
+}
+
+// Handles an XREAD command from a client.
+func (s *Server) handleXReadCommand(cmd XReadCommand, client *Client) {
+	// Resolve $ to the last ID when the command runs, so only entries added afterwards are returned
+	ids := make([]StreamID, len(cmd.IDs))
+	for i, key := range cmd.Keys {
+		ids[i] = cmd.IDs[i]
+		if !cmd.lastID[i] {
+			continue
+		}
+
+		lastID, err := s.store.StreamLastID(key)
+		if err != nil {
+			client.SendMessage(resp.EncodeError(err.Error()))
+			return
+		}
+		ids[i] = lastID
+	}
+
+	// Reply with every stream that has new entries
+	var reply [][]byte
+	for i, key := range cmd.Keys {
+		entries, err := s.readStreamAfter(key, ids[i], cmd.Count)
+		if err != nil {
+			client.SendMessage(resp.EncodeError(err.Error()))
+			return
+		}
+
+		if len(entries) > 0 {
+			reply = append(reply, resp.EncodeArray([][]byte{resp.EncodeBulkString(key), encodeStreamEntries(entries)}))
+		}
+	}
+
+	if len(reply) > 0 || !cmd.blocking {
+		if reply == nil {
+			client.SendMessage(resp.EncodeBulkStringArray(nil))
+		} else {
+			client.SendMessage(resp.EncodeArray(reply))
+		}
+		return
+	}
+
+	s.blockClient(&blockedClient{
+		client: client,
+		keys:   cmd.Keys,
+		tryServe: func(key []byte) bool {
+			i := slices.IndexFunc(cmd.Keys, func(k []byte) bool {
+				return bytes.Equal(k, key)
+			})
+
+			entries, err := s.readStreamAfter(key, ids[i], cmd.Count)
+			if err != nil {
+				// The key changed type while blocked, reply with the error
+				client.SendMessage(resp.EncodeError(err.Error()))
+				return true
+			}
+
+			if len(entries) == 0 {
+				return false
+			}
+
+			client.SendMessage(resp.EncodeArray([][]byte{
+				resp.EncodeArray([][]byte{resp.EncodeBulkString(key), encodeStreamEntries(entries)}),
+			}))
+			return true
+		},
+		onTimeout: func() {
+			client.SendMessage(resp.EncodeBulkStringArray(nil))
+		},
+	}, cmd.Timeout)
+}
+
+// Handles an XREADGROUP command from a client. Only reads of new entries block, reading the history
+// of the consumer always replies immediately.
+func (s *Server) handleXReadGroupCommand(cmd XReadGroupCommand, client *Client) {
+	readGroup := func(i int) ([]StreamEntry, error) {
+		return s.store.StreamReadGroup(cmd.Keys[i], cmd.Group, XReadGroupOptions{
+			Consumer: cmd.Consumer,
+			ID:       cmd.IDs[i],
+			New:      cmd.New[i],
+			Count:    cmd.Count,
+			NoAck:    cmd.NoAck,
+		})
+	}
+
+	var reply [][]byte
+	for i, key := range cmd.Keys {
+		entries, err := readGroup(i)
+		if err != nil {
+			client.SendMessage(resp.EncodeError(err.Error()))
+			return
+		}
+
+		if len(entries) > 0 || !cmd.New[i] {
+			reply = append(reply, resp.EncodeArray([][]byte{resp.EncodeBulkString(key), encodeStreamEntries(entries)}))
+		}
+	}
+
+	if len(reply) > 0 || !cmd.blocking {
+		if reply == nil {
+			client.SendMessage(resp.EncodeBulkStringArray(nil))
+		} else {
+			client.SendMessage(resp.EncodeArray(reply))
+		}
+		return
+	}
+
+	s.blockClient(&blockedClient{
+		client: client,
+		keys:   cmd.Keys,
+		tryServe: func(key []byte) bool {
+			i := slices.IndexFunc(cmd.Keys, func(k []byte) bool {
+				return bytes.Equal(k, key)
+			})
+
+			entries, err := readGroup(i)
+			if err != nil {
+				// The key or the group was deleted while blocked, reply with the error
+				client.SendMessage(resp.EncodeError(err.Error()))
+				return true
+			}
+
+			if len(entries) == 0 {
+				return false
+			}
+
+			s.propagate(xreadGroupArgs(cmd, [][]byte{key}, cmd.IDs[i:i+1], cmd.New[i:i+1]))
+			client.SendMessage(resp.EncodeArray([][]byte{
+				resp.EncodeArray([][]byte{resp.EncodeBulkString(key), encodeStreamEntries(entries)}),
+			}))
+			return true
+		},
+		onTimeout: func() {
+			client.SendMessage(resp.EncodeBulkStringArray(nil))
+		},
+	}, cmd.Timeout)
+}
+
+// Returns the LPOP or RPOP equivalent to serving a BLPOP or BRPOP from key.
+func popArgs(key []byte, popAtFront bool) [][]byte {
+	if popAtFront {
+		return [][]byte{[]byte(CmdLPop), key}
+	}
+	return [][]byte{[]byte(CmdRPop), key}
+}
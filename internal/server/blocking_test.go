@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Time given to the server loop to park a blocked client or notice a disconnect.
+const blockDelay = 50 * time.Millisecond
+
+// Checks that a reply is a BLPOP or BRPOP reply with the given key and value.
+func expectPopReply(t *testing.T, val resp.RespValue, key, value string) {
+	t.Helper()
+
+	arr, _ := val.(resp.RespArray)
+	if len(arr.Elements) != 2 {
+		t.Fatalf("Expected a key and a value, got %v", val)
+	}
+	gotKey, _ := arr.Elements[0].(resp.RespBulkString)
+	gotValue, _ := arr.Elements[1].(resp.RespBulkString)
+	if string(gotKey.Value) != key || string(gotValue.Value) != value {
+		t.Errorf("Expected %s from %s, got %s from %s", value, key, gotValue.Value, gotKey.Value)
+	}
+}
+
+func TestBlockingPopServedByPush(t *testing.T) {
+	_, addr := startTestServer(t)
+	blocked := dialTestServer(t, addr)
+	pusher := dialTestServer(t, addr)
+
+	// Lists with elements are served right away, from the first non-empty key
+	pusher.do("RPUSH", "second", "b")
+	expectPopReply(t, blocked.do("BRPOP", "first", "second", "0"), "second", "b")
+
+	blocked.pipeline([]string{"BLPOP", "first", "second", "0"})
+	time.Sleep(blockDelay)
+	pusher.do("RPUSH", "first", "x", "y")
+	expectPopReply(t, blocked.read(), "first", "x")
+
+	if val := pusher.do("LLEN", "first"); val != (resp.RespInteger{Value: 1}) {
+		t.Errorf("Expected the blocked client to pop a single element, got %v", val)
+	}
+}
+
+func TestBlockingPopTimeout(t *testing.T) {
+	_, addr := startTestServer(t)
+	client := dialTestServer(t, addr)
+
+	start := time.Now()
+	val, _ := client.do("BLPOP", "list", "0.1").(resp.RespArray)
+	if val.Elements != nil {
+		t.Errorf("Expected a null array once the timeout expired, got %v", val)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected BLPOP to block for 100ms, took %v", elapsed)
+	}
+
+	// The client is no longer blocked, a later push stays in the list
+	client.do("RPUSH", "list", "a")
+	if val := client.do("LLEN", "list"); val != (resp.RespInteger{Value: 1}) {
+		t.Errorf("Expected the pushed element to stay in the list, got %v", val)
+	}
+}
+
+func TestBlockingPopWithoutTimeout(t *testing.T) {
+	_, addr := startTestServer(t)
+	blocked := dialTestServer(t, addr)
+	pusher := dialTestServer(t, addr)
+
+	blocked.pipeline([]string{"BLPOP", "list", "0"})
+	blocked.conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	_, err := resp.ReadRESP(blocked.reader)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Expected a timeout of 0 to block, got %v", err)
+	}
+
+	pusher.do("LPUSH", "list", "a")
+	expectPopReply(t, blocked.read(), "list", "a")
+}
+
+func TestBlockingPopDisconnect(t *testing.T) {
+	_, addr := startTestServer(t)
+	gone := dialTestServer(t, addr)
+	waiting := dialTestServer(t, addr)
+	pusher := dialTestServer(t, addr)
+
+	gone.pipeline([]string{"BLPOP", "list", "0"})
+	time.Sleep(blockDelay)
+	waiting.pipeline([]string{"BLPOP", "list", "0"})
+	time.Sleep(blockDelay)
+	gone.conn.Close()
+	time.Sleep(blockDelay)
+
+	// The element goes to the client still connected instead of being lost
+	pusher.do("RPUSH", "list", "a")
+	expectPopReply(t, waiting.read(), "list", "a")
+
+	pusher.do("RPUSH", "list", "b")
+	if val := pusher.do("LLEN", "list"); val != (resp.RespInteger{Value: 1}) {
+		t.Errorf("Expected no client left blocked, got a list length of %v", val)
+	}
+}
+
+func TestBlockingPopOrder(t *testing.T) {
+	_, addr := startTestServer(t)
+	pusher := dialTestServer(t, addr)
+
+	waiters := make([]*testConn, 3)
+	for i := range waiters {
+		waiters[i] = dialTestServer(t, addr)
+		waiters[i].pipeline([]string{"BLPOP", "list", "0"})
+		time.Sleep(blockDelay)
+	}
+
+	// A single push serves every waiter in the order they blocked
+	pusher.do("RPUSH", "list", "a", "b", "c")
+	for i, value := range []string{"a", "b", "c"} {
+		expectPopReply(t, waiters[i].read(), "list", value)
+	}
+}
@@ -2,62 +2,363 @@ package server
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/CDavidSV/GopherStore/internal/resp"
 )
 
+// Source of client IDs, unique for the lifetime of the process.
+var clientIDs atomic.Int64
+
 type Client struct {
+	id      int64 // Returned by CLIENT ID
 	conn    net.Conn
 	deregCh chan *Client
 	msgCh   chan Message
-	sendCh  chan []byte
-	doneCh  chan struct{}
+	sendCh  chan reply
+	doneCh  chan struct{} // Closed once the reader stops
+	closeCh chan struct{} // Closed by the server loop once the client is deregistered
 	writer  *bufio.Writer
 	logger  *slog.Logger
+	faults  *faultInjector // nil unless fault injection is enabled
+
+	commands map[CommandName]customCommand // Custom commands of the server, read-only once started
+	policy   *commandPolicy                // Renamed and restricted commands of the server, nil if none
+	limits   resp.Limits                   // Limits on the commands read from the client
+
+	// Connection timeouts, see Server.SetReadTimeout and Server.SetWriteTimeout. 0 disables them
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	deadlineMu   sync.Mutex
+	idleExempt   bool // The client may idle without a read deadline, set from the server loop
+	stopped      bool // No more commands are read, see stopReading
+
+	// Blocking state, owned by the server loop
+	blocked *blockedClient // Set while the client waits on a blocking command
+	pending []Message      // Commands received while blocked, executed once unblocked
+
+	// Read commands run by the reader instead of the server loop, see Server.runConcurrently
+	runner      func(msg Message) bool // Runs a command if it can run outside the loop, nil runs every command on the loop
+	loopPending atomic.Int64           // Commands sent to the server loop that it did not handle yet
+	loopIdle    chan struct{}          // Signaled once loopPending drops to 0
+	concurrent  atomic.Bool            // The state of the client lets read commands run outside the loop, set from the server loop
+
+	// Reply mode set with CLIENT REPLY, owned by the server loop
+	replyOff  bool        // Replies are suppressed until CLIENT REPLY ON
+	replySkip bool        // The reply of the next command is suppressed
+	muted     atomic.Bool // Replies of the current command are dropped by SendMessage
+
+	// Pub/sub subscriptions, owned by the server loop
+	channels      map[string]struct{}
+	patterns      map[string]struct{}
+	shardChannels map[string]struct{}
+
+	tracking *clientTracking // Set by CLIENT TRACKING ON, owned by the server loop
+
+	// Connection state set with HELLO, AUTH and CLIENT SETNAME, owned by the server loop
+	protocol      resp.Protocol // Protocol version of the replies
+	name          string        // Returned by CLIENT GETNAME
+	authenticated bool          // Commands other than AUTH and HELLO are rejected until set
+
+	primary       bool   // Runs the replication stream received from the primary of this server
+	listeningPort string // Sent by a replica with REPLCONF LISTENING-PORT, owned by the server loop
+
+	// Replies held until the append-only file is synced, see Server.holdReplies
+	holdMu  sync.Mutex
+	holding bool
+	held    []reply
+}
+
+// A reply queued for a client, either already encoded or streamed by the writer goroutine.
+type reply struct {
+	data   []byte
+	stream func(w *resp.Writer) error
+	buffer *[]byte // Pooled buffer holding data, returned to replyBufferPool once written
+}
+
+func (r reply) writeTo(w *resp.Writer) error {
+	if r.stream != nil {
+		return r.stream(w)
+	}
+	err := w.WriteEncoded(r.data)
+	if r.buffer != nil {
+		releaseReplyBuffer(r.buffer)
+	}
+	return err
 }
 
 func NewClient(conn net.Conn, deregCh chan *Client, msgCh chan Message, logger *slog.Logger) *Client {
+	writer := writerPool.get()
+	writer.Reset(conn)
+
 	return &Client{
-		conn:    conn,
-		deregCh: deregCh,
-		msgCh:   msgCh,
-		sendCh:  make(chan []byte, 1024),
-		doneCh:  make(chan struct{}),
-		writer:  bufio.NewWriter(conn),
-		logger:  logger,
+		id:       clientIDs.Add(1),
+		conn:     conn,
+		deregCh:  deregCh,
+		msgCh:    msgCh,
+		sendCh:   make(chan reply, 1024),
+		doneCh:   make(chan struct{}),
+		loopIdle: make(chan struct{}, 1),
+		closeCh:  make(chan struct{}),
+		writer:   writer,
+		logger:   logger,
+
+		protocol: resp.RESP2,
+		// Clients created by the server itself, like the replication link, are trusted.
+		// handleNewClient resets this for connections when the server requires a password
+		authenticated: true,
+
+		channels:      make(map[string]struct{}),
+		patterns:      make(map[string]struct{}),
+		shardChannels: make(map[string]struct{}),
 	}
 }
 
 func (c *Client) SendMessage(msg []byte) error {
+	return c.queue(reply{data: msg})
+}
+
+// Sends a bulk string, encoded in a pooled buffer rather than a new one for every reply.
+func (c *Client) SendBulkString(value []byte) error {
+	buf := replyBufferPool.get()
+	*buf = resp.AppendBulkString(*buf, value)
+	return c.queue(reply{data: *buf, buffer: buf})
+}
+
+// Sends an array of bulk strings, encoded in a pooled buffer rather than a new one for every reply.
+func (c *Client) SendBulkStringArray(values [][]byte) error {
+	buf := replyBufferPool.get()
+	*buf = resp.AppendBulkStringArray(*buf, values)
+	return c.queue(reply{data: *buf, buffer: buf})
+}
+
+// Sends a reply written by stream straight into the connection buffer, without encoding it in memory
+// first. stream runs on the writer goroutine after the command returns, so it must only read data
+// that is not modified afterwards, such as a copy of the elements of a list.
+func (c *Client) SendReply(stream func(w *resp.Writer) error) error {
+	return c.queue(reply{stream: stream})
+}
+
+func (c *Client) queue(r reply) error {
+	if c.muted.Load() {
+		return nil
+	}
+
+	c.holdMu.Lock()
+	if c.holding {
+		c.held = append(c.held, r)
+		c.holdMu.Unlock()
+		return nil
+	}
+	c.holdMu.Unlock()
+
+	return c.push(r)
+}
+
+// Sends an encoded message, bypassing the reply mode and held replies.
+func (c *Client) send(msg []byte) error {
+	return c.push(reply{data: msg})
+}
+
+func (c *Client) push(r reply) error {
 	select {
-	case c.sendCh <- msg:
+	case c.sendCh <- r:
 		return nil
 	default:
 		return fmt.Errorf("send channel full")
 	}
 }
 
+// Queues the replies sent to the client instead of sending them, until releaseReplies.
+func (c *Client) holdReplies() {
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+
+	c.holding = true
+}
+
+func (c *Client) holdingReplies() bool {
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+
+	return c.holding
+}
+
+// Sends the replies held since holdReplies and stops holding them.
+func (c *Client) releaseReplies() {
+	c.holdMu.Lock()
+	held := c.held
+	c.holding = false
+	c.held = nil
+	c.holdMu.Unlock()
+
+	for _, r := range held {
+		if err := c.push(r); err != nil {
+			c.logger.Warn("dropped held reply for slow client", "error", err)
+		}
+	}
+}
+
+// Sets the read deadline before waiting for the next command, unless the client is exempt.
+func (c *Client) armReadDeadline() {
+	if c.readTimeout == 0 {
+		return
+	}
+
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	if !c.idleExempt && !c.stopped {
+		c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+}
+
+// Exempts the client from the read timeout while it waits without sending commands. The deadline of
+// the read in progress is updated right away, so it does not expire while the client is blocked.
+func (c *Client) setIdleExempt(exempt bool) {
+	if c.readTimeout == 0 {
+		return
+	}
+
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	if c.idleExempt == exempt || c.stopped {
+		return
+	}
+	c.idleExempt = exempt
+	if exempt {
+		c.conn.SetReadDeadline(time.Time{})
+	} else {
+		c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+}
+
+// Stops reading commands from the client, the commands already read are still run. Safe to call
+// from any goroutine.
+func (c *Client) stopReading() {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+
+	c.stopped = true
+	c.conn.SetReadDeadline(time.Now())
+}
+
+// Reports whether stopReading was called.
+func (c *Client) readingStopped() bool {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+
+	return c.stopped
+}
+
+// Returns and clears the commands queued while the client was blocked.
+func (c *Client) takePending() []Message {
+	pending := c.pending
+	c.pending = nil
+	return pending
+}
+
+// Reports whether the read commands of the client can run outside the server loop: it is not
+// blocked, subscribed or tracking keys, and its replies are not turned off, all of which the loop
+// handles.
+// Must be called from the server loop, or before the client is registered.
+func (c *Client) canRunConcurrently() bool {
+	return c.authenticated && c.blocked == nil && c.subscriptionCount() == 0 && c.tracking == nil &&
+		!c.replyOff && !c.replySkip
+}
+
+// Sends a command to the server loop.
+func (c *Client) sendToLoop(msg Message) {
+	c.loopPending.Add(1)
+	c.msgCh <- msg
+}
+
+// An error found by the reader of a client, such as a protocol or parse error, sent to the server
+// loop like a command so it is replied after the commands read before it.
+type readerError struct {
+	message string
+}
+
+// Sends an error found by the reader to the client through the server loop, see readerError.
+func (c *Client) sendError(message string) {
+	c.sendToLoop(Message{cmd: readerError{message}, client: c})
+}
+
+// Records that the server loop handled a command sent by sendToLoop, queued it until the client is
+// unblocked, or rejected it.
+// Must be called from the server loop.
+func (c *Client) loopHandled() {
+	c.concurrent.Store(c.canRunConcurrently())
+	if c.loopPending.Add(-1) == 0 {
+		select {
+		case c.loopIdle <- struct{}{}:
+		default:
+			// Already signaled, the count is checked again by waitForLoop
+		}
+	}
+}
+
+// Waits until the server loop handled every command sent by the client. Returns false if the client
+// was deregistered meanwhile.
+func (c *Client) waitForLoop() bool {
+	for c.loopPending.Load() > 0 {
+		select {
+		case <-c.loopIdle:
+		case <-c.closeCh:
+			return false
+		}
+	}
+	return true
+}
+
 func (c *Client) read() error {
+	reader := readerPool.get()
+	reader.Reset(c.conn)
 	defer func() {
 		// Close the send channel to signal write() to stop
 		close(c.doneCh)
+		reader.Reset(nil)
+		readerPool.put(reader)
 	}()
 
-	reader := bufio.NewReader(c.conn)
+	decoder := resp.NewDecoder(reader, c.limits)
 
+	// Reused for every command, the parsed commands only keep its elements
+	var cmd resp.RespArray
 	for {
-		v, err := resp.ReadRESP(reader)
+		c.armReadDeadline()
+		err := decoder.DecodeArray(&cmd)
+		if errors.Is(err, resp.ErrNotArray) {
+			c.logger.Debug("received non-array from client")
+			c.sendError("expected array of commands")
+			continue
+		}
 		if err != nil {
 			// error could be EOF or a RESP parsing error
 			if err == io.EOF {
 				return nil
+			} else if errors.Is(err, os.ErrDeadlineExceeded) {
+				if !c.readingStopped() {
+					c.logger.Debug("closing idle client", "remoteAddr", c.conn.RemoteAddr().String())
+				}
+				return nil
 			} else if respErr, ok := err.(*resp.RESPError); ok {
 				c.logger.Debug("RESP error while reading from client", "error", respErr.Msg)
-				c.SendMessage(resp.EncodeError(respErr.Error()))
+				c.sendError("ERR Protocol error: " + respErr.Error())
+				if errors.Is(err, resp.ErrCommandDiscarded) {
+					// The command over the limits was discarded up to its end, the next one can be read
+					continue
+				}
+				// Where the next command starts is unknown, and looking for it could run data sent as a
+				// value, so the connection is closed like Redis does, once the error is queued
+				c.waitForLoop()
 				return nil
 			}
 
@@ -66,55 +367,128 @@ func (c *Client) read() error {
 			return err
 		}
 
-		// Depending on the type, we handle commands accordingly.
-		cmd, ok := v.(resp.RespArray)
-		if !ok {
-			c.logger.Debug("received non-array from client")
-			c.SendMessage(resp.EncodeError("expected array of commands"))
-			return nil
-		}
-
 		if len(cmd.Elements) == 0 {
 			c.logger.Debug("received empty command array from client")
-			c.SendMessage(resp.EncodeError("empty command array"))
-			return nil
+			c.sendError("empty command array")
+			continue
+		}
+
+		if c.policy != nil {
+			if err := c.policy.apply(cmd); err != nil {
+				c.sendError(err.Error())
+				continue
+			}
 		}
 
 		// Process the command
-		parsedCmd, err := ParseCommand(cmd)
+		parsedCmd, err := parseCommand(cmd, c.commands)
 		if err != nil {
 			c.logger.Debug("failed to parse command from client", "error", err)
-			c.SendMessage(resp.EncodeError(err.Error()))
+			c.sendError(err.Error())
 			continue
 		}
 
-		c.msgCh <- Message{
+		// Inject faults, DEBUG commands are exempt so faults can always be reset
+		if _, isDebug := parsedCmd.(DebugCommand); c.faults != nil && !isDebug {
+			if delay := c.faults.delay(); delay > 0 {
+				time.Sleep(delay)
+			}
+
+			if c.faults.shouldDisconnect() {
+				c.logger.Debug("injected disconnect", "remoteAddr", c.conn.RemoteAddr().String())
+				return nil
+			}
+
+			if c.faults.shouldError() {
+				c.sendError("injected fault")
+				continue
+			}
+		}
+
+		msg := Message{
 			cmd:    parsedCmd,
+			args:   commandArgs(cmd),
 			client: c,
 		}
+		if c.runner != nil && c.runner(msg) {
+			continue
+		}
+		c.sendToLoop(msg)
 	}
 }
 
+// Writes the queued replies until the reader stops or a write fails. The client is then handed to the
+// server loop, which deregisters it once every command it sent was run, and the replies queued until
+// then are sent before the connection is closed.
 func (c *Client) write() {
-	defer func() {
-		c.writer.Flush()
-		c.deregCh <- c
-	}()
+	w := resp.NewWriter(c.writer)
+	if err := c.writeReplies(w); err != nil {
+		c.logger.Error("failed to write to client", "error", err)
+	}
 
+	select {
+	case c.deregCh <- c:
+		<-c.closeCh
+	case <-c.closeCh:
+	}
+
+	// The write deadline set by the server loop bounds the time spent here
+	for range len(c.sendCh) {
+		if err := (<-c.sendCh).writeTo(w); err != nil {
+			break
+		}
+	}
+	c.writer.Flush()
+	c.conn.Close()
+	c.writer.Reset(nil)
+	writerPool.put(c.writer)
+}
+
+// Writes the queued replies until the reader stops, then the replies it queued before stopping.
+func (c *Client) writeReplies(w *resp.Writer) error {
 	for {
 		select {
-		case msg := <-c.sendCh:
-			if _, err := c.writer.Write(msg); err != nil {
-				c.logger.Error("failed to write to client", "error", err)
-				return
+		case r := <-c.sendCh:
+			// The deadline covers the whole batch, a client that stops reading is dropped once it expires
+			if c.writeTimeout > 0 {
+				c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+			}
+			if err := r.writeTo(w); err != nil {
+				return err
+			}
+
+			// Write the replies already queued before flushing, so pipelined commands are answered
+			// with as few writes to the connection as possible
+			for range len(c.sendCh) {
+				if err := (<-c.sendCh).writeTo(w); err != nil {
+					return err
+				}
 			}
 
 			if err := c.writer.Flush(); err != nil {
-				c.logger.Error("failed to flush writer to client", "error", err)
-				return
+				return err
 			}
 		case <-c.doneCh:
-			return
+			// Send the replies queued before the reader stopped, such as the error that made it stop.
+			// The connection is closed next, so a failed write is not reported
+			for range len(c.sendCh) {
+				if err := (<-c.sendCh).writeTo(w); err != nil {
+					return nil
+				}
+			}
+			c.writer.Flush()
+			return nil
+		}
+	}
+}
+
+// Returns the name and arguments of a parsed command array.
+func commandArgs(arr resp.RespArray) [][]byte {
+	args := make([][]byte, 0, len(arr.Elements))
+	for _, elem := range arr.Elements {
+		if arg, ok := elem.(resp.RespBulkString); ok {
+			args = append(args, arg.Value)
 		}
 	}
+	return args
 }
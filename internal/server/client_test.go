@@ -0,0 +1,314 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+func TestClientReplyModes(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient()
+
+	get := GetCommand{Key: []byte("key")}
+	send := func(cmds ...Command) int {
+		for _, cmd := range cmds {
+			s.processMessages([]Message{{cmd: cmd, client: client}})
+		}
+
+		return len(drainReplies(client))
+	}
+
+	if n := send(ClientCommand{Subcommand: "REPLY", ReplyMode: "SKIP"}, get, get); n != 1 {
+		t.Errorf("Expected only the command after the skipped one to reply, got %d replies", n)
+	}
+	if n := send(ClientCommand{Subcommand: "REPLY", ReplyMode: "OFF"}, get, get); n != 0 {
+		t.Errorf("Expected no replies while replies are off, got %d", n)
+	}
+	if n := send(ClientCommand{Subcommand: "REPLY", ReplyMode: "ON"}, get); n != 2 {
+		t.Errorf("Expected CLIENT REPLY ON and the next command to reply, got %d replies", n)
+	}
+}
+
+func TestClientTracking(t *testing.T) {
+	s := newTestServer(t)
+	reader := newTestClient()
+	receiver := newTestClient()
+	s.clients[receiver] = struct{}{}
+
+	run := func(client *Client, cmd Command) []string {
+		s.processMessages([]Message{{cmd: cmd, client: client}})
+		return drainReplies(client)
+	}
+	// Passes the next change made to the store to the server loop handler
+	write := func(key string) {
+		s.store.Set([]byte(key), []byte("value"), -1)
+		s.handleKeyspaceEvent(<-s.eventCh)
+	}
+
+	run(receiver, SubscribeCommand{Channels: [][]byte{[]byte(invalidateChannel)}})
+	if replies := run(reader, ClientCommand{Subcommand: "TRACKING", Tracking: true, Redirect: receiver.id}); replies[0] != "+OK\r\n" {
+		t.Fatalf("Expected OK, got %q", replies)
+	}
+
+	run(reader, GetCommand{Key: []byte("cached")})
+	write("other")
+	if replies := drainReplies(receiver); len(replies) != 0 {
+		t.Errorf("Expected no invalidation for a key that was not read, got %q", replies)
+	}
+
+	write("cached")
+	expected := "*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*1\r\n$6\r\ncached\r\n"
+	if replies := drainReplies(receiver); len(replies) != 1 || replies[0] != expected {
+		t.Errorf("Expected %q, got %q", expected, replies)
+	}
+
+	// Keys are only invalidated once until they are read again
+	write("cached")
+	if replies := drainReplies(receiver); len(replies) != 0 {
+		t.Errorf("Expected no second invalidation, got %q", replies)
+	}
+
+	// Broadcast mode invalidates every key with a matching prefix
+	run(reader, ClientCommand{Subcommand: "TRACKING", Tracking: true, Redirect: receiver.id, BCast: true, Prefixes: [][]byte{[]byte("user:")}})
+	write("user:1")
+	write("post:1")
+	if replies := drainReplies(receiver); len(replies) != 1 {
+		t.Errorf("Expected one invalidation for the matching prefix, got %q", replies)
+	}
+
+	run(reader, ClientCommand{Subcommand: "TRACKING", Tracking: false})
+	if reader.tracking != nil || len(s.trackingBcast) != 0 {
+		t.Error("Expected tracking to be disabled")
+	}
+}
+
+func TestClientProtocolErrors(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	malformed := []string{
+		"*1\r\n$abc\r\nPING\r\n",
+		"*-5\r\n",
+		"?garbage\r\n",
+		"*1\r\n$4\r\nPINGxx\r\n",
+	}
+	for _, input := range malformed {
+		client := dialTestServer(t, addr)
+		if _, err := client.conn.Write([]byte(input + "*1\r\n$4\r\nPING\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		if errVal, ok := client.read().(resp.RespErrorValue); !ok || !strings.HasPrefix(errVal.Message, "ERR Protocol error") {
+			t.Errorf("Expected a protocol error for %q, got %v", input, errVal)
+		}
+
+		// The next command cannot be found, so nothing after the malformed input is run
+		if val, err := resp.ReadRESP(client.reader); err != io.EOF {
+			t.Errorf("Expected the connection to be closed after %q, got %v (%v)", input, val, err)
+		}
+	}
+}
+
+func TestClientOversizedValueNotRun(t *testing.T) {
+	s, addr := startTestServer(t, func(s *Server, _ string) {
+		s.SetRequestLimits(resp.Limits{MaxBulkLength: 64})
+	})
+	client := dialTestServer(t, addr)
+
+	// A value over the limit holding a command, as a proxy forwarding user input would send it
+	value := "\r\n*3\r\n$3\r\nSET\r\n$5\r\npwned\r\n$3\r\nyes\r\n" + strings.Repeat("x", 60)
+	client.pipeline([]string{"SET", "key", value}, []string{"GET", "pwned"})
+	if errVal, ok := client.read().(resp.RespErrorValue); !ok || !strings.Contains(errVal.Message, "exceeds the limit") {
+		t.Fatalf("Expected the value over the limit to be rejected, got %v", errVal)
+	}
+	if bulk, ok := client.read().(resp.RespBulkString); !ok || bulk.Value != nil {
+		t.Errorf("Expected the command after the rejected one to run next, got %v", bulk)
+	}
+	if value, _ := s.store.GetValue([]byte("pwned")); value != nil {
+		t.Errorf("Expected the command in the value to never run, got pwned=%s", value)
+	}
+}
+
+func TestClientRepliesWithLineBreaks(t *testing.T) {
+	_, addr := startTestServer(t)
+	client := dialTestServer(t, addr)
+
+	if val, _ := client.do("PING", "hi\r\n+OK").(resp.RespBulkString); string(val.Value) != "hi\r\n+OK" {
+		t.Errorf("Expected a PING message with CRLF to be sent back as a bulk string, got %q", val.Value)
+	}
+	if val, ok := client.do("HELLO", "3", "x\r\n+OK").(resp.RespErrorValue); !ok || strings.ContainsAny(val.Message, "\r\n") {
+		t.Errorf("Expected an error without line breaks, got %v", val)
+	}
+	if val := client.do("PING"); val != (resp.RespSimpleString{Value: "PONG"}) {
+		t.Errorf("Expected the stream to stay in sync, got %v", val)
+	}
+}
+
+func TestClientRequestLimits(t *testing.T) {
+	_, addr := startTestServer(t, func(s *Server, _ string) {
+		s.SetRequestLimits(resp.Limits{MaxBulkLength: 8, MaxArrayLength: 4, MaxDepth: 1})
+	})
+	client := dialTestServer(t, addr)
+
+	if val := client.do("SET", "key", "12345678"); val != (resp.RespSimpleString{Value: "OK"}) {
+		t.Fatalf("Expected a command within the limits to run, got %v", val)
+	}
+
+	tests := map[string][]string{
+		"bulk string": {"SET", "key", "123456789"},
+		"array":       {"MSET", "a", "1", "b", "2"},
+	}
+	for name, args := range tests {
+		val := client.do(args...)
+		if errVal, ok := val.(resp.RespErrorValue); !ok || !strings.Contains(errVal.Message, "exceeds the limit") {
+			t.Errorf("Expected a %s over the limit to be rejected, got %v", name, val)
+		}
+		if val := client.do("PING"); val != (resp.RespSimpleString{Value: "PONG"}) {
+			t.Errorf("Expected the connection to keep serving commands, got %v", val)
+		}
+	}
+}
+
+func TestClientStreamedReplies(t *testing.T) {
+	_, addr := startTestServer(t)
+	client := dialTestServer(t, addr)
+
+	client.do("RPUSH", "list", "a", "b", "c")
+	val, ok := client.do("LRANGE", "list", "0", "-1").(resp.RespArray)
+	if !ok || len(val.Elements) != 3 {
+		t.Fatalf("Expected LRANGE to reply with 3 elements, got %v", val)
+	}
+	if last, _ := val.Elements[2].(resp.RespBulkString); string(last.Value) != "c" {
+		t.Errorf("Expected the last element to be %q, got %q", "c", last.Value)
+	}
+
+	if val, ok := client.do("LRANGE", "list", "5", "10").(resp.RespArray); !ok || val.Elements == nil || len(val.Elements) != 0 {
+		t.Errorf("Expected an empty array for a range past the end, got %v", val)
+	}
+	if val := client.do("PING"); val != (resp.RespSimpleString{Value: "PONG"}) {
+		t.Errorf("Expected replies after a streamed one to stay in order, got %v", val)
+	}
+}
+
+// Connection counting the writes made to it.
+type countingConn struct {
+	net.Conn
+	mu     sync.Mutex
+	writes int
+	data   bytes.Buffer
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes++
+	return c.data.Write(p)
+}
+
+func TestClientCoalescesQueuedReplies(t *testing.T) {
+	conn := &countingConn{}
+	deregCh := make(chan *Client, 1)
+	client := NewClient(conn, deregCh, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for i := range 100 {
+		client.SendMessage(resp.EncodeInteger(int64(i)))
+	}
+	client.SendReply(func(w *resp.Writer) error {
+		return w.WriteSimpleString("done")
+	})
+
+	go client.write()
+	deadline := time.Now().Add(time.Second)
+	for {
+		conn.mu.Lock()
+		done := strings.HasSuffix(conn.data.String(), "+done\r\n")
+		conn.mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the replies to be written")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(client.doneCh)
+	<-deregCh
+
+	if conn.writes != 1 {
+		t.Errorf("Expected the queued replies to be written at once, got %d writes", conn.writes)
+	}
+	if !strings.HasPrefix(conn.data.String(), ":0\r\n:1\r\n:2\r\n") {
+		t.Errorf("Expected the replies in order, got %q", conn.data.String()[:20])
+	}
+}
+
+func TestClientReadTimeout(t *testing.T) {
+	_, addr := startTestServer(t, func(s *Server, _ string) {
+		s.SetReadTimeout(100 * time.Millisecond)
+	})
+	idle := dialTestServer(t, addr)
+	blocked := dialTestServer(t, addr)
+	subscriber := dialTestServer(t, addr)
+
+	subscriber.do("SUBSCRIBE", "channel")
+	if _, err := blocked.conn.Write(resp.EncodeBulkStringArray([][]byte{[]byte("BLPOP"), []byte("list"), []byte("0")})); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	idle.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := idle.reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the idle client to be disconnected, got %v", err)
+	}
+
+	// Blocked clients and subscribers wait without sending commands
+	pusher := dialTestServer(t, addr)
+	pusher.do("RPUSH", "list", "value")
+	if val, err := resp.ReadRESP(blocked.reader); err != nil {
+		t.Errorf("Expected the blocked client to be served, got %v", err)
+	} else if arr, _ := val.(resp.RespArray); len(arr.Elements) != 2 {
+		t.Errorf("Expected BLPOP to reply with the key and value, got %v", val)
+	}
+	if val, _ := subscriber.do("PING").(resp.RespArray); len(val.Elements) != 2 {
+		t.Errorf("Expected the subscriber to stay connected, got %v", val)
+	}
+
+	// Once served, the client is idle again
+	time.Sleep(300 * time.Millisecond)
+	blocked.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := blocked.reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the unblocked client to be disconnected once idle, got %v", err)
+	}
+}
+
+func TestClientWriteTimeout(t *testing.T) {
+	_, addr := startTestServer(t, func(s *Server, _ string) {
+		s.SetWriteTimeout(100 * time.Millisecond)
+	})
+	client := dialTestServer(t, addr)
+	client.do("SET", "key", strings.Repeat("x", 1<<20))
+
+	// Stop reading while the replies are far larger than the socket buffers
+	const requests = 32
+	for range requests {
+		if _, err := client.conn.Write(resp.EncodeBulkStringArray([][]byte{[]byte("GET"), []byte("key")})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	client.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := io.Copy(io.Discard, client.reader)
+	if err != nil {
+		t.Fatalf("Expected the wedged client to be disconnected, got %v", err)
+	}
+	if n >= requests<<20 {
+		t.Errorf("Expected the pending replies to be dropped, got %d bytes", n)
+	}
+}
@@ -0,0 +1,554 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+	"github.com/CDavidSV/GopherStore/internal/util"
+)
+
+const (
+	clusterSlots          = 16384
+	clusterGossipInterval = time.Second      // Time between HELLO messages sent to every known node
+	clusterNodeTimeout    = 15 * time.Second // Nodes that did not reply for longer are considered failing
+)
+
+// Range of hash slots, inclusive.
+type slotRange struct {
+	start, end int
+}
+
+// What a node shares about itself or another node in CLUSTER HELLO.
+type clusterNodeInfo struct {
+	ID    string
+	Addr  string // host:port clients connect to
+	Slots []slotRange
+}
+
+type clusterNode struct {
+	clusterNodeInfo
+	lastSeen time.Time // Last reply to HELLO, zero for nodes only heard of from other nodes
+}
+
+// Nodes of the cluster known to this server. Nodes share what they know by sending CLUSTER HELLO to
+// every node they know, so meeting one node of a cluster is enough to learn about the others. Each
+// node is the authority on its own slots.
+type cluster struct {
+	mu     sync.Mutex
+	myself *clusterNode
+	nodes  map[string]*clusterNode // By ID, including myself
+	meet   map[string]struct{}     // Addresses given to CLUSTER MEET that did not reply yet
+	wake   chan struct{}           // Makes the gossip goroutine send HELLO right away
+}
+
+// CRC16-CCITT (XMODEM) lookup table used to map keys to hash slots.
+var crc16Table = func() [256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		crc := uint16(i) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^b]
+	}
+	return crc
+}
+
+// Returns the hash slot of a key. When the key contains a non-empty hash tag between the first { and
+// the next }, only the tag is hashed, so keys sharing a tag are stored in the same slot.
+func keySlot(key []byte) int {
+	if start := bytes.IndexByte(key, '{'); start >= 0 {
+		if end := bytes.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % clusterSlots
+}
+
+func parseSlot(s []byte) (int, bool) {
+	slot, ok := util.ParseInt(s)
+	return slot, ok && slot >= 0 && slot < clusterSlots
+}
+
+// Formats slot ranges as in "0-5460,10923", empty without slots.
+func formatSlotRanges(ranges []slotRange) string {
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		if r.start == r.end {
+			parts[i] = strconv.Itoa(r.start)
+		} else {
+			parts[i] = fmt.Sprintf("%d-%d", r.start, r.end)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseSlotRanges(s string) ([]slotRange, error) {
+	var ranges []slotRange
+	if s == "" {
+		return ranges, nil
+	}
+
+	for part := range strings.SplitSeq(s, ",") {
+		startStr, endStr, isRange := strings.Cut(part, "-")
+		if !isRange {
+			endStr = startStr
+		}
+
+		start, ok := parseSlot([]byte(startStr))
+		end, endOk := parseSlot([]byte(endStr))
+		if !ok || !endOk || start > end {
+			return nil, fmt.Errorf("invalid slot range %q", part)
+		}
+		ranges = append(ranges, slotRange{start, end})
+	}
+	return ranges, nil
+}
+
+// Parses the nodes of CLUSTER HELLO given as triples of ID, address and slots.
+func parseClusterNodes(args [][]byte) ([]clusterNodeInfo, error) {
+	nodes := make([]clusterNodeInfo, 0, len(args)/3)
+	for i := 0; i+2 < len(args); i += 3 {
+		if _, _, err := net.SplitHostPort(string(args[i+1])); err != nil || len(args[i]) == 0 {
+			return nil, fmt.Errorf("invalid cluster node %q at %q", args[i], args[i+1])
+		}
+		slots, err := parseSlotRanges(string(args[i+2]))
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, clusterNodeInfo{ID: string(args[i]), Addr: string(args[i+1]), Slots: slots})
+	}
+	return nodes, nil
+}
+
+// Merges ranges into the fewest sorted ranges covering the same slots.
+func normalizeSlotRanges(ranges []slotRange) []slotRange {
+	slices.SortFunc(ranges, func(a, b slotRange) int { return a.start - b.start })
+
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r.start <= merged[n-1].end+1 {
+			merged[n-1].end = max(merged[n-1].end, r.end)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// Enables cluster mode, with this server reachable by the other nodes and clients at addr.
+// Must be called before ListenAndServe.
+func (s *Server) EnableCluster(addr string) {
+	id := make([]byte, 20)
+	rand.Read(id)
+
+	myself := &clusterNode{clusterNodeInfo: clusterNodeInfo{ID: hex.EncodeToString(id), Addr: addr}}
+	s.cluster = &cluster{
+		myself: myself,
+		nodes:  map[string]*clusterNode{myself.ID: myself},
+		meet:   make(map[string]struct{}),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Returns the node owning each slot, nil for unassigned slots.
+// Must be called with the lock already held.
+func (c *cluster) owners() []*clusterNode {
+	owners := make([]*clusterNode, clusterSlots)
+	for _, node := range c.nodes {
+		for _, r := range node.Slots {
+			for slot := r.start; slot <= r.end; slot++ {
+				owners[slot] = node
+			}
+		}
+	}
+	return owners
+}
+
+// Returns the node owning a slot, nil if it is unassigned.
+// Must be called with the lock already held.
+func (c *cluster) slotOwner(slot int) *clusterNode {
+	for _, node := range c.nodes {
+		for _, r := range node.Slots {
+			if slot >= r.start && slot <= r.end {
+				return node
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the error replied by Redis Cluster when keys, or shard channels, are not served by this
+// node: CROSSSLOT when they map to different slots, MOVED with the node owning their slot, or
+// CLUSTERDOWN when the slot is unassigned. Returns an empty string when this node serves them.
+func (c *cluster) redirect(keys [][]byte) string {
+	if len(keys) == 0 {
+		return ""
+	}
+	slot := keySlot(keys[0])
+	for _, key := range keys[1:] {
+		if keySlot(key) != slot {
+			return "CROSSSLOT Keys in request don't hash to the same slot"
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch owner := c.slotOwner(slot); owner {
+	case c.myself:
+		return ""
+	case nil:
+		return "CLUSTERDOWN Hash slot not served"
+	default:
+		return fmt.Sprintf("MOVED %d %s", slot, owner.Addr)
+	}
+}
+
+// Reports whether the node replied recently.
+// Must be called with the lock already held.
+func (c *cluster) healthy(node *clusterNode) bool {
+	return node == c.myself || time.Since(node.lastSeen) < clusterNodeTimeout
+}
+
+// Returns the arguments of CLUSTER HELLO, or of its reply, describing this node and then the others.
+// Must be called with the lock already held.
+func (c *cluster) helloArgs() [][]byte {
+	args := make([][]byte, 0, len(c.nodes)*3)
+	for _, node := range c.sortedNodes() {
+		args = append(args, []byte(node.ID), []byte(node.Addr), []byte(formatSlotRanges(node.Slots)))
+	}
+	return args
+}
+
+// Returns the nodes, this node first and then by address.
+// Must be called with the lock already held.
+func (c *cluster) sortedNodes() []*clusterNode {
+	nodes := make([]*clusterNode, 0, len(c.nodes))
+	for _, node := range c.nodes {
+		if node != c.myself {
+			nodes = append(nodes, node)
+		}
+	}
+	slices.SortFunc(nodes, func(a, b *clusterNode) int { return strings.Compare(a.Addr, b.Addr) })
+	return append([]*clusterNode{c.myself}, nodes...)
+}
+
+// Records what a node shared about itself, the first of nodes, and the nodes it knows. Other nodes are
+// only added when unknown, since each node is the authority on its own slots.
+func (c *cluster) merge(nodes []clusterNodeInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, info := range nodes {
+		if info.ID == c.myself.ID {
+			continue
+		}
+
+		node, known := c.nodes[info.ID]
+		if i > 0 && (known || c.knownAddr(info.Addr)) {
+			continue
+		}
+		if !known {
+			// A node restarted with a new ID replaces its former self
+			for id, other := range c.nodes {
+				if other.Addr == info.Addr && other != c.myself {
+					delete(c.nodes, id)
+				}
+			}
+			node = &clusterNode{}
+			c.nodes[info.ID] = node
+		}
+
+		node.clusterNodeInfo = info
+		if i == 0 {
+			node.lastSeen = time.Now()
+		}
+	}
+}
+
+// Must be called with the lock already held.
+func (c *cluster) knownAddr(addr string) bool {
+	for _, node := range c.nodes {
+		if node.Addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Sends CLUSTER HELLO to every known node and to the addresses given to CLUSTER MEET, every
+// clusterGossipInterval and whenever woken up, until the server stops.
+func (s *Server) runClusterGossip() {
+	ticker := time.NewTicker(clusterGossipInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.cluster.wake:
+		case <-s.quitCh:
+			return
+		}
+		s.gossip()
+	}
+}
+
+func (s *Server) gossip() {
+	c := s.cluster
+
+	c.mu.Lock()
+	args := append([][]byte{[]byte(CmdCluster), []byte("HELLO")}, c.helloArgs()...)
+	var targets []string
+	for _, node := range c.nodes {
+		if node != c.myself {
+			targets = append(targets, node.Addr)
+		}
+	}
+	for addr := range c.meet {
+		targets = append(targets, addr)
+	}
+	c.mu.Unlock()
+
+	for _, addr := range targets {
+		nodes, err := sendClusterHello(addr, args)
+		if err != nil {
+			s.logger.Debug("failed to reach cluster node", "addr", addr, "error", err)
+			continue
+		}
+		c.merge(nodes)
+
+		c.mu.Lock()
+		if _, meeting := c.meet[addr]; meeting {
+			delete(c.meet, addr)
+			s.logger.Info("met cluster node", "addr", addr, "id", nodes[0].ID)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// Sends CLUSTER HELLO to a node, returning the nodes it knows, itself first.
+func sendClusterHello(addr string, args [][]byte) ([]clusterNodeInfo, error) {
+	conn, err := net.DialTimeout("tcp", addr, clusterGossipInterval)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(clusterGossipInterval))
+
+	if _, err := conn.Write(resp.EncodeBulkStringArray(args)); err != nil {
+		return nil, err
+	}
+	val, err := resp.ReadRESP(bufio.NewReader(conn))
+	if err != nil {
+		return nil, err
+	}
+	if respErr, ok := val.(resp.RespErrorValue); ok {
+		return nil, &resp.RESPError{Msg: respErr.Message}
+	}
+
+	arr, ok := val.(resp.RespArray)
+	if !ok || len(arr.Elements) == 0 || len(arr.Elements)%3 != 0 {
+		return nil, fmt.Errorf("unexpected reply to CLUSTER HELLO: %v", val)
+	}
+	reply := make([][]byte, len(arr.Elements))
+	for i, elem := range arr.Elements {
+		bulk, ok := elem.(resp.RespBulkString)
+		if !ok {
+			return nil, fmt.Errorf("unexpected reply to CLUSTER HELLO: %v", val)
+		}
+		reply[i] = bulk.Value
+	}
+	return parseClusterNodes(reply)
+}
+
+// Handles CLUSTER subcommands from a client.
+func (s *Server) handleClusterCommand(cmd ClusterCommand, client *Client) {
+	c := s.cluster
+	if c == nil {
+		client.SendMessage(resp.EncodeError("this instance has cluster support disabled"))
+		return
+	}
+
+	switch cmd.Subcommand {
+	case "KEYSLOT":
+		client.SendMessage(resp.EncodeInteger(int64(keySlot(cmd.Key))))
+	case "MYID":
+		client.SendMessage(resp.EncodeBulkString([]byte(c.myself.ID)))
+	case "MEET":
+		c.mu.Lock()
+		if cmd.Addr != c.myself.Addr {
+			c.meet[cmd.Addr] = struct{}{}
+		}
+		c.mu.Unlock()
+
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+		client.SendMessage(resp.EncodeSimpleString("OK"))
+	case "ADDSLOTS", "ADDSLOTSRANGE":
+		if err := c.addSlots(cmd.Slots); err != nil {
+			client.SendMessage(resp.EncodeError(err.Error()))
+			return
+		}
+		client.SendMessage(resp.EncodeSimpleString("OK"))
+	case "HELLO":
+		c.merge(cmd.Nodes)
+		c.mu.Lock()
+		reply := c.helloArgs()
+		c.mu.Unlock()
+		client.SendMessage(resp.EncodeBulkStringArray(reply))
+	case "INFO":
+		client.SendMessage(resp.EncodeBulkString([]byte(c.info())))
+	case "SLOTS":
+		client.SendMessage(c.encodeSlots())
+	case "SHARDS":
+		client.SendMessage(c.encodeShards())
+	}
+}
+
+// Assigns slots to this node, failing without assigning any if one is already assigned.
+func (c *cluster) addSlots(ranges []slotRange) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	owners := c.owners()
+	requested := make([]bool, clusterSlots)
+	for _, r := range ranges {
+		for slot := r.start; slot <= r.end; slot++ {
+			if owners[slot] != nil || requested[slot] {
+				return fmt.Errorf("slot %d is already busy", slot)
+			}
+			requested[slot] = true
+		}
+	}
+
+	c.myself.Slots = normalizeSlotRanges(append(slices.Clone(c.myself.Slots), ranges...))
+	return nil
+}
+
+// Returns the CLUSTER INFO report.
+func (c *cluster) info() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	assigned, ok := 0, 0
+	for _, owner := range c.owners() {
+		if owner == nil {
+			continue
+		}
+		assigned++
+		if c.healthy(owner) {
+			ok++
+		}
+	}
+
+	size := 0
+	for _, node := range c.nodes {
+		if len(node.Slots) > 0 {
+			size++
+		}
+	}
+
+	state := "ok"
+	if ok < clusterSlots {
+		state = "fail"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "cluster_state:%s\r\n", state)
+	fmt.Fprintf(&b, "cluster_slots_assigned:%d\r\n", assigned)
+	fmt.Fprintf(&b, "cluster_slots_ok:%d\r\n", ok)
+	fmt.Fprintf(&b, "cluster_slots_fail:%d\r\n", assigned-ok)
+	fmt.Fprintf(&b, "cluster_known_nodes:%d\r\n", len(c.nodes))
+	fmt.Fprintf(&b, "cluster_size:%d\r\n", size)
+	return b.String()
+}
+
+// Encodes the CLUSTER SLOTS reply: [start, end, [host, port, id]] for each range of slots.
+func (c *cluster) encodeSlots() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var reply [][]byte
+	for _, node := range c.sortedNodes() {
+		host, port, _ := net.SplitHostPort(node.Addr)
+		portNum, _ := strconv.Atoi(port)
+		for _, r := range node.Slots {
+			reply = append(reply, resp.EncodeArray([][]byte{
+				resp.EncodeInteger(int64(r.start)),
+				resp.EncodeInteger(int64(r.end)),
+				resp.EncodeArray([][]byte{
+					resp.EncodeBulkString([]byte(host)),
+					resp.EncodeInteger(int64(portNum)),
+					resp.EncodeBulkString([]byte(node.ID)),
+				}),
+			}))
+		}
+	}
+	return resp.EncodeArray(reply)
+}
+
+// Encodes the CLUSTER SHARDS reply: one shard per node, as ["slots", [start, end, ...], "nodes", [node]]
+// where the node is ["id", id, "endpoint", host, "port", port, "role", "master", "health", health].
+func (c *cluster) encodeShards() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var reply [][]byte
+	for _, node := range c.sortedNodes() {
+		slots := make([][]byte, 0, len(node.Slots)*2)
+		for _, r := range node.Slots {
+			slots = append(slots, resp.EncodeInteger(int64(r.start)), resp.EncodeInteger(int64(r.end)))
+		}
+
+		host, port, _ := net.SplitHostPort(node.Addr)
+		portNum, _ := strconv.Atoi(port)
+		health := "online"
+		if !c.healthy(node) {
+			health = "fail"
+		}
+
+		reply = append(reply, resp.EncodeArray([][]byte{
+			resp.EncodeBulkString([]byte("slots")),
+			resp.EncodeArray(slots),
+			resp.EncodeBulkString([]byte("nodes")),
+			resp.EncodeArray([][]byte{resp.EncodeArray([][]byte{
+				resp.EncodeBulkString([]byte("id")),
+				resp.EncodeBulkString([]byte(node.ID)),
+				resp.EncodeBulkString([]byte("endpoint")),
+				resp.EncodeBulkString([]byte(host)),
+				resp.EncodeBulkString([]byte("port")),
+				resp.EncodeInteger(int64(portNum)),
+				resp.EncodeBulkString([]byte("role")),
+				resp.EncodeBulkString([]byte("master")),
+				resp.EncodeBulkString([]byte("health")),
+				resp.EncodeBulkString([]byte(health)),
+			})}),
+		}))
+	}
+	return resp.EncodeArray(reply)
+}
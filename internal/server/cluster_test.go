@@ -0,0 +1,167 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+func TestKeySlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		slot int
+	}{
+		{"123456789", 12739},
+		{"foo", 12182},
+		{"{user1000}.following", keySlot([]byte("user1000"))},
+		{"foo{}{bar}", keySlot([]byte("foo{}{bar}"))}, // Empty tags are ignored
+		{"foo{{bar}}", keySlot([]byte("{bar"))},
+	}
+
+	for _, tt := range tests {
+		if slot := keySlot([]byte(tt.key)); slot != tt.slot {
+			t.Errorf("Expected %q to map to slot %d, got %d", tt.key, tt.slot, slot)
+		}
+	}
+}
+
+func startClusterNode(t *testing.T) (*Server, *testConn) {
+	s, addr := startTestServer(t, func(s *Server, addr string) {
+		s.EnableCluster(addr)
+		go s.runClusterGossip()
+	})
+	return s, dialTestServer(t, addr)
+}
+
+func TestCluster(t *testing.T) {
+	_, first := startClusterNode(t)
+	secondServer, second := startClusterNode(t)
+	thirdServer, thirdConn := startClusterNode(t)
+
+	if val := first.do("CLUSTER", "ADDSLOTSRANGE", "0", "8191"); val != (resp.RespSimpleString{Value: "OK"}) {
+		t.Fatalf("Expected ADDSLOTSRANGE to reply OK, got %v", val)
+	}
+	if val := second.do("CLUSTER", "ADDSLOTSRANGE", "8192", "16382"); val != (resp.RespSimpleString{Value: "OK"}) {
+		t.Fatalf("Expected ADDSLOTSRANGE to reply OK, got %v", val)
+	}
+	thirdConn.do("CLUSTER", "ADDSLOTS", "16383")
+
+	// Meeting one node of a cluster is enough to learn about the others
+	host, port, _ := net.SplitHostPort(secondServer.cluster.myself.Addr)
+	first.do("CLUSTER", "MEET", host, port)
+	host, port, _ = net.SplitHostPort(thirdServer.cluster.myself.Addr)
+	second.do("CLUSTER", "MEET", host, port)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		info, _ := first.do("CLUSTER", "INFO").(resp.RespBulkString)
+		if strings.Contains(string(info.Value), "cluster_state:ok") && strings.Contains(string(info.Value), "cluster_known_nodes:3") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected every slot to be covered by 3 nodes, got %q", info.Value)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if val, ok := first.do("CLUSTER", "ADDSLOTS", "100").(resp.RespErrorValue); !ok || val.Message != "slot 100 is already busy" {
+		t.Errorf("Expected assigned slots to be rejected, got %v", val)
+	}
+	if val, ok := thirdConn.do("CLUSTER", "ADDSLOTS", "10").(resp.RespErrorValue); !ok {
+		t.Errorf("Expected slots of other nodes to be rejected, got %v", val)
+	}
+
+	slots, ok := thirdConn.do("CLUSTER", "SLOTS").(resp.RespArray)
+	if !ok || len(slots.Elements) != 3 {
+		t.Fatalf("Expected 3 slot ranges, got %v", slots)
+	}
+	// The node answering comes first
+	own := slots.Elements[0].(resp.RespArray)
+	if own.Elements[0] != (resp.RespInteger{Value: 16383}) || own.Elements[1] != (resp.RespInteger{Value: 16383}) {
+		t.Errorf("Expected the first range to be the slots of the node, got %v", own)
+	}
+
+	if shards, ok := first.do("CLUSTER", "SHARDS").(resp.RespArray); !ok || len(shards.Elements) != 3 {
+		t.Errorf("Expected 3 shards, got %v", shards)
+	}
+	if val := first.do("CLUSTER", "KEYSLOT", "foo"); val != (resp.RespInteger{Value: 12182}) {
+		t.Errorf("Expected KEYSLOT to reply 12182, got %v", val)
+	}
+}
+
+// Returns a name mapping to a slot in the range, made of prefix and a number.
+func nameInSlots(prefix string, start, end int) string {
+	for i := 0; ; i++ {
+		name := prefix + strconv.Itoa(i)
+		if slot := keySlot([]byte(name)); slot >= start && slot <= end {
+			return name
+		}
+	}
+}
+
+func TestClusterShardChannels(t *testing.T) {
+	_, first := startClusterNode(t)
+	secondServer, second := startClusterNode(t)
+	first.do("CLUSTER", "ADDSLOTSRANGE", "0", "8191")
+	second.do("CLUSTER", "ADDSLOTSRANGE", "8192", "16000")
+
+	local := nameInSlots("local:", 0, 8191)
+	remote := nameInSlots("remote:", 8192, 16000)
+	unassigned := nameInSlots("unassigned:", 16001, 16383)
+
+	// Slots of other nodes are unassigned until they are known
+	if val, ok := first.do("SPUBLISH", remote, "hi").(resp.RespErrorValue); !ok || !strings.HasPrefix(val.Message, "CLUSTERDOWN") {
+		t.Errorf("Expected CLUSTERDOWN for a slot not known to be served, got %v", val)
+	}
+
+	host, port, _ := net.SplitHostPort(secondServer.cluster.myself.Addr)
+	first.do("CLUSTER", "MEET", host, port)
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		info, _ := first.do("CLUSTER", "INFO").(resp.RespBulkString)
+		if strings.Contains(string(info.Value), "cluster_known_nodes:2") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the nodes to meet, got %q", info.Value)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	moved := fmt.Sprintf("MOVED %d %s", keySlot([]byte(remote)), secondServer.cluster.myself.Addr)
+	if val := first.do("SSUBSCRIBE", remote); val != (resp.RespErrorValue{Message: moved}) {
+		t.Errorf("Expected SSUBSCRIBE to be redirected with %q, got %v", moved, val)
+	}
+	if val := first.do("SPUBLISH", remote, "hi"); val != (resp.RespErrorValue{Message: moved}) {
+		t.Errorf("Expected SPUBLISH to be redirected with %q, got %v", moved, val)
+	}
+	if val, ok := first.do("SSUBSCRIBE", local, remote).(resp.RespErrorValue); !ok || !strings.HasPrefix(val.Message, "CROSSSLOT") {
+		t.Errorf("Expected CROSSSLOT for channels in different slots, got %v", val)
+	}
+	if val, ok := first.do("SPUBLISH", unassigned, "hi").(resp.RespErrorValue); !ok || !strings.HasPrefix(val.Message, "CLUSTERDOWN") {
+		t.Errorf("Expected CLUSTERDOWN for an unassigned slot, got %v", val)
+	}
+
+	// Channels in the slots of the node are served as without cluster mode
+	if arr, _ := first.do("SSUBSCRIBE", local).(resp.RespArray); len(arr.Elements) != 3 {
+		t.Fatalf("Expected a subscription to a channel of the node, got %v", arr)
+	}
+	if val := second.do("SPUBLISH", remote, "hi"); val != (resp.RespInteger{Value: 0}) {
+		t.Errorf("Expected SPUBLISH to run on the node owning the channel, got %v", val)
+	}
+}
+
+func TestClusterDisabled(t *testing.T) {
+	server := newTestServer(t)
+	client := newTestClient()
+
+	server.processMessages([]Message{{cmd: ClusterCommand{Subcommand: "INFO"}, client: client}})
+	if replies := drainReplies(client); len(replies) != 1 || !strings.HasPrefix(replies[0], "-") {
+		t.Errorf("Expected CLUSTER to be rejected without cluster mode, got %q", replies)
+	}
+}
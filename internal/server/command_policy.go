@@ -0,0 +1,134 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// The commands clients may run and the names they run them with, so each deployment can hide or
+// disable dangerous commands like DEBUG or CONFIG. Commands are renamed like with Redis'
+// rename-command, and restricted by an allowlist or a denylist of their real names. Only applies to
+// commands sent by clients, the append-only file and the replication stream use the real names.
+// Read-only once the server started.
+type commandPolicy struct {
+	renamed map[string]CommandName // Real names of the renamed commands, by new name
+	hidden  map[CommandName]bool   // Commands renamed or disabled, whose real name is not available
+	allowed map[CommandName]bool   // nil allows every command that is not denied
+	denied  map[CommandName]bool
+}
+
+// Renames commands, mapping their name to the name clients use instead, like Redis' rename-command.
+// An empty name disables the command. The old name is no longer available, unless another command
+// is renamed to it. Must be called before ListenAndServe.
+func (s *Server) RenameCommands(renames map[string]string) error {
+	policy := s.commandPolicy()
+	for name, newName := range renames {
+		name = strings.ToUpper(name)
+		if !s.commandExists(CommandName(name)) {
+			return fmt.Errorf("cannot rename unknown command %s", name)
+		}
+		if newName == "" {
+			policy.hidden[CommandName(name)] = true
+			continue
+		}
+		if _, taken := policy.renamed[newName]; taken {
+			return fmt.Errorf("cannot rename %s to %s, which is already used by another command", name, newName)
+		}
+
+		policy.hidden[CommandName(name)] = true
+		policy.renamed[newName] = CommandName(name)
+	}
+
+	// Names given to other commands must not be left to the command of the same name
+	for newName := range policy.renamed {
+		if s.commandExists(CommandName(newName)) && !policy.hidden[CommandName(newName)] {
+			return fmt.Errorf("cannot rename a command to %s, which is an existing command", newName)
+		}
+	}
+	return nil
+}
+
+// Only lets clients run the given commands, by their real names. Must be called before
+// ListenAndServe.
+func (s *Server) SetCommandAllowlist(names []string) error {
+	allowed, err := s.commandSet(names)
+	if err != nil {
+		return err
+	}
+	s.commandPolicy().allowed = allowed
+	return nil
+}
+
+// Forbids clients from running the given commands, by their real names. Must be called before
+// ListenAndServe.
+func (s *Server) SetCommandDenylist(names []string) error {
+	denied, err := s.commandSet(names)
+	if err != nil {
+		return err
+	}
+	s.commandPolicy().denied = denied
+	return nil
+}
+
+// Returns the command policy, creating it the first time it is configured.
+func (s *Server) commandPolicy() *commandPolicy {
+	if s.policy == nil {
+		s.policy = &commandPolicy{
+			renamed: make(map[string]CommandName),
+			hidden:  make(map[CommandName]bool),
+			denied:  make(map[CommandName]bool),
+		}
+	}
+	return s.policy
+}
+
+// Returns the set of the given commands, failing if one of them does not exist.
+func (s *Server) commandSet(names []string) (map[CommandName]bool, error) {
+	set := make(map[CommandName]bool, len(names))
+	for _, name := range names {
+		name = strings.ToUpper(name)
+		if !s.commandExists(CommandName(name)) {
+			return nil, fmt.Errorf("unknown command %s", name)
+		}
+		set[CommandName(name)] = true
+	}
+	return set, nil
+}
+
+// Reports whether a built-in or custom command has the given name.
+func (s *Server) commandExists(name CommandName) bool {
+	if _, custom := s.commands[name]; custom {
+		return true
+	}
+	probe := resp.RespArray{Elements: []resp.RespValue{resp.RespBulkString{Value: []byte(name)}}}
+	_, err := ParseCommand(probe)
+	return !errors.Is(err, errUnknownCommand)
+}
+
+// Replaces the name of a command sent by a client with the real name of the command. Fails with an
+// unknown command error, like Redis, if the command is renamed, disabled or not allowed.
+func (p *commandPolicy) apply(arr resp.RespArray) error {
+	name, ok := arr.Elements[0].(resp.RespBulkString)
+	if !ok {
+		return nil
+	}
+
+	target, renamed := p.renamed[string(name.Value)]
+	if !renamed {
+		target = CommandName(name.Value)
+		if p.hidden[target] {
+			return fmt.Errorf("%w: %s", errUnknownCommand, name.Value)
+		}
+	}
+	if p.denied[target] || (p.allowed != nil && !p.allowed[target]) {
+		return fmt.Errorf("%w: %s", errUnknownCommand, name.Value)
+	}
+
+	if renamed {
+		arr.Elements[0] = resp.RespBulkString{Value: []byte(target)}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Reports whether the reply is the error sent for an unknown command.
+func isUnknownCommand(val resp.RespValue) bool {
+	errVal, ok := val.(resp.RespErrorValue)
+	return ok && strings.Contains(errVal.Message, "unknown command")
+}
+
+func TestRenameCommands(t *testing.T) {
+	_, addr := startTestServer(t, func(s *Server, _ string) {
+		if err := s.RenameCommands(map[string]string{"config": "ADMINCONFIG", "DEBUG": ""}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	client := dialTestServer(t, addr)
+
+	if val := client.do("CONFIG", "GET", "maxmemory"); !isUnknownCommand(val) {
+		t.Errorf("Expected the old name to be unknown, got %v", val)
+	}
+	val := client.do("ADMINCONFIG", "GET", "maxmemory")
+	if _, ok := val.(resp.RespArray); !ok {
+		t.Errorf("Expected the new name to run CONFIG, got %v", val)
+	}
+	if val := client.do("DEBUG", "SLEEP", "0"); !isUnknownCommand(val) {
+		t.Errorf("Expected a disabled command to be unknown, got %v", val)
+	}
+	if val := client.do("PING"); val != (resp.RespSimpleString{Value: "PONG"}) {
+		t.Errorf("Expected other commands to run, got %v", val)
+	}
+}
+
+func TestRenameCommandsInvalid(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.RenameCommands(map[string]string{"NOPE": "X"}); err == nil {
+		t.Error("Expected renaming an unknown command to fail")
+	}
+
+	s = newTestServer(t)
+	if err := s.RenameCommands(map[string]string{"CONFIG": "GET"}); err == nil {
+		t.Error("Expected renaming a command to an existing command to fail")
+	}
+
+	s = newTestServer(t)
+	if err := s.RenameCommands(map[string]string{"GET": "SET", "SET": "GET"}); err != nil {
+		t.Errorf("Expected swapping two commands to succeed, got %v", err)
+	}
+}
+
+func TestCommandLists(t *testing.T) {
+	_, addr := startTestServer(t, func(s *Server, _ string) {
+		if err := s.RenameCommands(map[string]string{"GET": "FETCH"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetCommandAllowlist([]string{"ping", "GET", "SET", "DEL"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetCommandDenylist([]string{"DEL"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	client := dialTestServer(t, addr)
+
+	if val := client.do("SET", "k", "v"); val != (resp.RespSimpleString{Value: "OK"}) {
+		t.Errorf("Expected an allowed command to run, got %v", val)
+	}
+	if val, ok := client.do("FETCH", "k").(resp.RespBulkString); !ok || string(val.Value) != "v" {
+		t.Errorf("Expected the allowlist to use the real name of a renamed command, got %v", val)
+	}
+	if val := client.do("DEL", "k"); !isUnknownCommand(val) {
+		t.Errorf("Expected a denied command to be unknown, got %v", val)
+	}
+	if val := client.do("EXISTS", "k"); !isUnknownCommand(val) {
+		t.Errorf("Expected a command missing from the allowlist to be unknown, got %v", val)
+	}
+
+	s := newTestServer(t)
+	if err := s.SetCommandDenylist([]string{"NOPE"}); err == nil {
+		t.Error("Expected denying an unknown command to fail")
+	}
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Describes a command for COMMAND replies, following the Redis conventions so cluster-aware clients
+// can route keys and tell reads from writes.
+type commandInfo struct {
+	name     CommandName
+	arity    int      // Number of arguments including the name, negative for a minimum
+	flags    []string // Redis command flags, e.g. write, readonly or fast
+	firstKey int      // Position of the first key, 0 if the command takes no keys
+	lastKey  int      // Position of the last key, negative to count from the end
+	step     int      // Distance between keys
+	group    string
+	summary  string
+}
+
+// Every built-in command, in alphabetical order. Commands with movablekeys take keys at positions
+// given by their arguments, e.g. after numkeys or STREAMS, which firstKey and lastKey don't cover.
+var commandTable = []commandInfo{
+	{CmdAuth, -2, []string{"no_auth", "fast"}, 0, 0, 0, "connection", "Authenticates the connection."},
+	{CmdBGSave, 1, []string{"admin"}, 0, 0, 0, "server", "Asynchronously saves the database to disk."},
+	{CmdBigKeys, -1, []string{"readonly"}, 0, 0, 0, "generic", "Returns the largest keys by memory usage or elements, or the totals of every type."},
+	{CmdBitField, -2, []string{"write", "denyoom"}, 1, 1, 1, "bitmap", "Performs arbitrary bitfield integer operations on strings."},
+	{CmdBitOp, -4, []string{"write", "denyoom"}, 2, -1, 1, "bitmap", "Performs bitwise operations on multiple strings, and stores the result."},
+	{CmdBLMove, 6, []string{"write", "denyoom", "blocking"}, 1, 2, 1, "list", "Pops an element from a list, pushes it to another list and returns it. Blocks until an element is available otherwise."},
+	{CmdBLPop, -3, []string{"write", "blocking"}, 1, -2, 1, "list", "Removes and returns the first element in a list. Blocks until an element is available otherwise."},
+	{CmdBRPop, -3, []string{"write", "blocking"}, 1, -2, 1, "list", "Removes and returns the last element in a list. Blocks until an element is available otherwise."},
+	{CmdClient, -2, nil, 0, 0, 0, "connection", "Manages the connection and replies of the client."},
+	{CmdCluster, -2, nil, 0, 0, 0, "cluster", "Manages the cluster and inspects its slots."},
+	{CmdCommand, -1, nil, 0, 0, 0, "server", "Returns detailed information about the commands."},
+	{CmdConfig, -2, []string{"admin"}, 0, 0, 0, "server", "Gets or sets the runtime settings of the server."},
+	{CmdDebug, -2, []string{"admin"}, 0, 0, 0, "server", "Injects faults and inspects the server for testing."},
+	{CmdDelete, -2, []string{"write"}, 1, -1, 1, "generic", "Deletes one or more keys."},
+	{CmdDelIfEq, 3, []string{"write", "fast"}, 1, 1, 1, "generic", "Deletes a key if its value equals the given value."},
+	{CmdExists, -2, []string{"readonly", "fast"}, 1, -1, 1, "generic", "Determines whether one or more keys exist."},
+	{CmdExpire, 3, []string{"write", "fast"}, 1, 1, 1, "generic", "Sets the expiration time of a key in seconds."},
+	{CmdExpireAt, 3, []string{"write", "fast"}, 1, 1, 1, "generic", "Sets the expiration time of a key to a Unix timestamp."},
+	{CmdExport, -1, []string{"readonly", "admin"}, 0, 0, 0, "server", "Exports the keys matching a pattern as JSON."},
+	{CmdFailover, -1, []string{"admin"}, 0, 0, 0, "server", "Starts a coordinated failover to a replica."},
+	{CmdFCall, -3, []string{"write", "denyoom", "movablekeys"}, 0, 0, 0, "scripting", "Invokes a function."},
+	{CmdFCallRO, -3, []string{"readonly", "movablekeys"}, 0, 0, 0, "scripting", "Invokes a read-only function."},
+	{CmdFunction, 2, nil, 0, 0, 0, "scripting", "Lists the loaded functions."},
+	{CmdGeoAdd, -5, []string{"write", "denyoom"}, 1, 1, 1, "geo", "Adds one or more members to a geospatial index."},
+	{CmdGeoDist, -4, []string{"readonly"}, 1, 1, 1, "geo", "Returns the distance between two members of a geospatial index."},
+	{CmdGeoSearch, -7, []string{"readonly"}, 1, 1, 1, "geo", "Queries a geospatial index for members inside an area of a box or a circle."},
+	{CmdGet, 2, []string{"readonly", "fast"}, 1, 1, 1, "string", "Returns the string value of a key."},
+	{CmdGetOrSet, -3, []string{"write", "denyoom"}, 1, 1, 1, "string", "Returns the string value of a key, setting it first if the key doesn't exist."},
+	{CmdHDel, -3, []string{"write", "fast"}, 1, 1, 1, "hash", "Deletes one or more fields and their values from a hash."},
+	{CmdHello, -1, []string{"no_auth", "fast"}, 0, 0, 0, "connection", "Handshakes with the server."},
+	{CmdHExists, 3, []string{"readonly", "fast"}, 1, 1, 1, "hash", "Determines whether a field exists in a hash."},
+	{CmdHExpire, -6, []string{"write", "fast"}, 1, 1, 1, "hash", "Sets the expiration time of hash fields in seconds."},
+	{CmdHExpireAt, -6, []string{"write", "fast"}, 1, 1, 1, "hash", "Sets the expiration time of hash fields to a Unix timestamp."},
+	{CmdHGet, 3, []string{"readonly", "fast"}, 1, 1, 1, "hash", "Returns the value of a field in a hash."},
+	{CmdHGetAll, 2, []string{"readonly"}, 1, 1, 1, "hash", "Returns all fields and values in a hash."},
+	{CmdHIncrBy, 4, []string{"write", "denyoom", "fast"}, 1, 1, 1, "hash", "Increments the integer value of a field in a hash by a number."},
+	{CmdHIncrByFloat, 4, []string{"write", "denyoom", "fast"}, 1, 1, 1, "hash", "Increments the floating point value of a field in a hash by a number."},
+	{CmdHKeys, 2, []string{"readonly"}, 1, 1, 1, "hash", "Returns all fields in a hash."},
+	{CmdHLen, 2, []string{"readonly", "fast"}, 1, 1, 1, "hash", "Returns the number of fields in a hash."},
+	{CmdHMGet, -3, []string{"readonly", "fast"}, 1, 1, 1, "hash", "Returns the values of all fields in a hash."},
+	{CmdHPExpire, -6, []string{"write", "fast"}, 1, 1, 1, "hash", "Sets the expiration time of hash fields in milliseconds."},
+	{CmdHPExpireAt, -6, []string{"write", "fast"}, 1, 1, 1, "hash", "Sets the expiration time of hash fields to a Unix milliseconds timestamp."},
+	{CmdHPTTL, -5, []string{"readonly", "fast"}, 1, 1, 1, "hash", "Returns the TTL in milliseconds of hash fields."},
+	{CmdHSet, -4, []string{"write", "denyoom", "fast"}, 1, 1, 1, "hash", "Creates or modifies the value of fields in a hash."},
+	{CmdHSetNX, 4, []string{"write", "denyoom", "fast"}, 1, 1, 1, "hash", "Sets the value of a field in a hash only when the field doesn't exist."},
+	{CmdHTTL, -5, []string{"readonly", "fast"}, 1, 1, 1, "hash", "Returns the TTL in seconds of hash fields."},
+	{CmdHVals, 2, []string{"readonly"}, 1, 1, 1, "hash", "Returns all values in a hash."},
+	{CmdImport, -2, []string{"write", "denyoom", "admin"}, 0, 0, 0, "server", "Imports keys exported as JSON."},
+	{CmdLastSave, 1, []string{"fast"}, 0, 0, 0, "server", "Returns the Unix timestamp of the last successful save to disk."},
+	{CmdLatency, -2, []string{"admin"}, 0, 0, 0, "server", "Inspects the latency spikes recorded by the latency monitor."},
+	{CmdLLen, 2, []string{"readonly", "fast"}, 1, 1, 1, "list", "Returns the length of a list."},
+	{CmdLMove, 5, []string{"write", "denyoom"}, 1, 2, 1, "list", "Returns an element after popping it from one list and pushing it to another."},
+	{CmdLPop, 2, []string{"write", "fast"}, 1, 1, 1, "list", "Returns the first element of a list after removing it."},
+	{CmdLPush, -3, []string{"write", "denyoom", "fast"}, 1, 1, 1, "list", "Prepends one or more elements to a list. Creates the key if it doesn't exist."},
+	{CmdLRange, 4, []string{"readonly"}, 1, 1, 1, "list", "Returns a range of elements from a list."},
+	{CmdMemory, -2, []string{"readonly"}, 0, 0, 0, "server", "Reports the memory used by a key and by the server."},
+	{CmdMGet, -2, []string{"readonly", "fast"}, 1, -1, 1, "string", "Atomically returns the string values of one or more keys."},
+	{CmdMigrate, -5, []string{"write", "movablekeys"}, 3, 3, 1, "generic", "Atomically transfers keys to another server."},
+	{CmdObject, -2, []string{"readonly"}, 2, 2, 1, "generic", "Inspects the internals of keys."},
+	{CmdPExpire, 3, []string{"write", "fast"}, 1, 1, 1, "generic", "Sets the expiration time of a key in milliseconds."},
+	{CmdPExpireAt, 3, []string{"write", "fast"}, 1, 1, 1, "generic", "Sets the expiration time of a key to a Unix milliseconds timestamp."},
+	{CmdPFAdd, -2, []string{"write", "denyoom", "fast"}, 1, 1, 1, "hyperloglog", "Adds elements to a HyperLogLog key. Creates the key if it doesn't exist."},
+	{CmdPFCount, -2, []string{"readonly"}, 1, -1, 1, "hyperloglog", "Returns the approximated cardinality of the sets observed by the HyperLogLog keys."},
+	{CmdPFMerge, -2, []string{"write", "denyoom"}, 1, -1, 1, "hyperloglog", "Merges one or more HyperLogLog values into a single key."},
+	{CmdPing, -1, []string{"fast"}, 0, 0, 0, "connection", "Returns the server's liveliness response."},
+	{CmdPSubscribe, -2, []string{"pubsub"}, 0, 0, 0, "pubsub", "Listens for messages published to channels that match one or more patterns."},
+	{CmdPSync, 3, []string{"admin"}, 0, 0, 0, "server", "An internal command used in replication."},
+	{CmdPublish, 3, []string{"pubsub", "fast"}, 0, 0, 0, "pubsub", "Posts a message to a channel."},
+	{CmdPubSub, -2, []string{"pubsub"}, 0, 0, 0, "pubsub", "Inspects the state of the pub/sub subsystem."},
+	{CmdPUnsubscribe, -1, []string{"pubsub"}, 0, 0, 0, "pubsub", "Stops listening to messages published to channels that match one or more patterns."},
+	{CmdReplConf, -3, []string{"admin"}, 0, 0, 0, "server", "An internal command for configuring the replication stream."},
+	{CmdReplicaOf, 3, []string{"admin"}, 0, 0, 0, "server", "Configures a server as replica of another, or promotes it to a primary."},
+	{CmdRole, 1, []string{"fast"}, 0, 0, 0, "server", "Returns the replication role."},
+	{CmdRPop, 2, []string{"write", "fast"}, 1, 1, 1, "list", "Returns and removes the last element of a list."},
+	{CmdRPush, -3, []string{"write", "denyoom", "fast"}, 1, 1, 1, "list", "Appends one or more elements to a list. Creates the key if it doesn't exist."},
+	{CmdSAdd, -3, []string{"write", "denyoom", "fast"}, 1, 1, 1, "set", "Adds one or more members to a set. Creates the key if it doesn't exist."},
+	{CmdSave, 1, []string{"admin"}, 0, 0, 0, "server", "Synchronously saves the database to disk."},
+	{CmdSCard, 2, []string{"readonly", "fast"}, 1, 1, 1, "set", "Returns the number of members in a set."},
+	{CmdSet, -3, []string{"write", "denyoom"}, 1, 1, 1, "string", "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist."},
+	{CmdSIsMember, 3, []string{"readonly", "fast"}, 1, 1, 1, "set", "Determines whether a member belongs to a set."},
+	{CmdSlaveOf, 3, []string{"admin"}, 0, 0, 0, "server", "Sets a server as a replica of another, or promotes it to being a primary."},
+	{CmdSMembers, 2, []string{"readonly"}, 1, 1, 1, "set", "Returns all members of a set."},
+	{CmdSMIsMember, -3, []string{"readonly", "fast"}, 1, 1, 1, "set", "Determines whether multiple members belong to a set."},
+	{CmdSPublish, 3, []string{"pubsub", "fast"}, 1, 1, 1, "pubsub", "Posts a message to a shard channel."},
+	{CmdSRem, -3, []string{"write", "fast"}, 1, 1, 1, "set", "Removes one or more members from a set. Deletes the set if the last member was removed."},
+	{CmdSScan, -3, []string{"readonly"}, 1, 1, 1, "set", "Iterates over members of a set."},
+	{CmdSSubscribe, -2, []string{"pubsub"}, 1, -1, 1, "pubsub", "Listens for messages published to shard channels."},
+	{CmdSubscribe, -2, []string{"pubsub"}, 0, 0, 0, "pubsub", "Listens for messages published to channels."},
+	{CmdSUnsubscribe, -1, []string{"pubsub"}, 1, -1, 1, "pubsub", "Stops listening to messages posted to shard channels."},
+	{CmdSync, 1, []string{"admin"}, 0, 0, 0, "server", "An internal command used in replication."},
+	{CmdUnsubscribe, -1, []string{"pubsub"}, 0, 0, 0, "pubsub", "Stops listening to messages posted to channels."},
+	{CmdWait, 3, nil, 0, 0, 0, "generic", "Blocks until the writes sent by the connection are acknowledged by replicas."},
+	{CmdXAck, -4, []string{"write", "fast"}, 1, 1, 1, "stream", "Returns the number of messages that were successfully acknowledged by the consumer group member of a stream."},
+	{CmdXAdd, -5, []string{"write", "denyoom", "fast"}, 1, 1, 1, "stream", "Appends a new message to a stream. Creates the key if it doesn't exist."},
+	{CmdXAutoClaim, -6, []string{"write", "fast"}, 1, 1, 1, "stream", "Changes, or acquires, ownership of messages in a consumer group, as if the messages were delivered to a consumer group member."},
+	{CmdXGroup, -2, []string{"write", "denyoom"}, 2, 2, 1, "stream", "Manages the consumer groups of a stream."},
+	{CmdXLen, 2, []string{"readonly", "fast"}, 1, 1, 1, "stream", "Returns the number of messages in a stream."},
+	{CmdXPending, -3, []string{"readonly"}, 1, 1, 1, "stream", "Returns the information and entries from a stream consumer group's pending entries list."},
+	{CmdXRange, -4, []string{"readonly"}, 1, 1, 1, "stream", "Returns the messages from a stream within a range of IDs."},
+	{CmdXRead, -4, []string{"readonly", "blocking", "movablekeys"}, 0, 0, 0, "stream", "Returns messages from multiple streams with IDs greater than the ones requested. Blocks until a message is available otherwise."},
+	{CmdXReadGroup, -7, []string{"write", "blocking", "movablekeys"}, 0, 0, 0, "stream", "Returns new or historical messages from a stream for a consumer in a group. Blocks until a message is available otherwise."},
+	{CmdXRevRange, -4, []string{"readonly"}, 1, 1, 1, "stream", "Returns the messages from a stream within a range of IDs in reverse order."},
+	{CmdZAdd, -4, []string{"write", "denyoom", "fast"}, 1, 1, 1, "sorted-set", "Adds one or more members to a sorted set, or updates their scores. Creates the key if it doesn't exist."},
+	{CmdZCard, 2, []string{"readonly", "fast"}, 1, 1, 1, "sorted-set", "Returns the number of members in a sorted set."},
+	{CmdZDiffStore, -4, []string{"write", "denyoom", "movablekeys"}, 1, 1, 1, "sorted-set", "Stores the difference of multiple sorted sets in a key."},
+	{CmdZInterStore, -4, []string{"write", "denyoom", "movablekeys"}, 1, 1, 1, "sorted-set", "Stores the intersect of multiple sorted sets in a key."},
+	{CmdZRange, -4, []string{"readonly"}, 1, 1, 1, "sorted-set", "Returns members in a sorted set within a range of indexes."},
+	{CmdZRangeByLex, -4, []string{"readonly"}, 1, 1, 1, "sorted-set", "Returns members in a sorted set within a lexicographical range."},
+	{CmdZRangeByScore, -4, []string{"readonly"}, 1, 1, 1, "sorted-set", "Returns members in a sorted set within a range of scores."},
+	{CmdZRem, -3, []string{"write", "fast"}, 1, 1, 1, "sorted-set", "Removes one or more members from a sorted set. Deletes the sorted set if all members were removed."},
+	{CmdZRemRangeByRank, 4, []string{"write"}, 1, 1, 1, "sorted-set", "Removes members in a sorted set within a range of indexes. Deletes the sorted set if all members were removed."},
+	{CmdZRemRangeByScore, 4, []string{"write"}, 1, 1, 1, "sorted-set", "Removes members in a sorted set within a range of scores. Deletes the sorted set if all members were removed."},
+	{CmdZRevRange, -4, []string{"readonly"}, 1, 1, 1, "sorted-set", "Returns members in a sorted set within a range of indexes in reverse order."},
+	{CmdZRevRangeByLex, -4, []string{"readonly"}, 1, 1, 1, "sorted-set", "Returns members in a sorted set within a lexicographical range in reverse order."},
+	{CmdZRevRangeByScore, -4, []string{"readonly"}, 1, 1, 1, "sorted-set", "Returns members in a sorted set within a range of scores in reverse order."},
+	{CmdZScore, 3, []string{"readonly", "fast"}, 1, 1, 1, "sorted-set", "Returns the score of a member in a sorted set."},
+	{CmdZUnionStore, -4, []string{"write", "denyoom", "movablekeys"}, 1, 1, 1, "sorted-set", "Stores the union of multiple sorted sets in a key."},
+}
+
+// Returns the description of a built-in command, looked up case-insensitively like Redis does.
+func findCommandInfo(name string) (commandInfo, bool) {
+	name = strings.ToUpper(name)
+	i := sort.Search(len(commandTable), func(i int) bool { return string(commandTable[i].name) >= name })
+	if i < len(commandTable) && string(commandTable[i].name) == name {
+		return commandTable[i], true
+	}
+	return commandInfo{}, false
+}
+
+// Returns the descriptions of the built-in and custom commands. Custom commands take any number of
+// arguments and their keys are unknown.
+func (s *Server) commandInfos() []commandInfo {
+	infos := make([]commandInfo, len(commandTable), len(commandTable)+len(s.commands))
+	copy(infos, commandTable)
+	for name := range s.commands {
+		infos = append(infos, customCommandInfo(name))
+	}
+	return infos
+}
+
+func customCommandInfo(name CommandName) commandInfo {
+	return commandInfo{name: name, arity: -1, group: "module"}
+}
+
+// Returns the description of a built-in or custom command. Custom command names are case-sensitive.
+func (s *Server) lookupCommandInfo(name string) (commandInfo, bool) {
+	if _, exists := s.commands[CommandName(name)]; exists {
+		return customCommandInfo(CommandName(name)), true
+	}
+	return findCommandInfo(name)
+}
+
+// Encodes a command the way Redis replies to COMMAND INFO: name, arity, flags, first key, last key,
+// step, ACL categories, tips, key specifications and subcommands. GopherStore has no tips, key
+// specifications or subcommand details, which are empty.
+func (info commandInfo) encode(protocol resp.Protocol) []byte {
+	flags := make([][]byte, len(info.flags))
+	for i, flag := range info.flags {
+		flags[i] = resp.EncodeSimpleString(flag)
+	}
+
+	return resp.EncodeArray([][]byte{
+		resp.EncodeBulkString([]byte(strings.ToLower(string(info.name)))),
+		resp.EncodeInteger(int64(info.arity)),
+		resp.EncodeSet(flags, protocol),
+		resp.EncodeInteger(int64(info.firstKey)),
+		resp.EncodeInteger(int64(info.lastKey)),
+		resp.EncodeInteger(int64(info.step)),
+		resp.EncodeSet(info.categories(), protocol),
+		resp.EncodeSet([][]byte{}, protocol),
+		resp.EncodeArray([][]byte{}),
+		resp.EncodeArray([][]byte{}),
+	})
+}
+
+// Returns the ACL categories of a command derived from its flags, like Redis does.
+func (info commandInfo) categories() [][]byte {
+	var categories [][]byte
+	fast := false
+	for _, flag := range info.flags {
+		switch flag {
+		case "write":
+			categories = append(categories, resp.EncodeSimpleString("@write"))
+		case "readonly":
+			categories = append(categories, resp.EncodeSimpleString("@read"))
+		case "admin":
+			categories = append(categories, resp.EncodeSimpleString("@admin"), resp.EncodeSimpleString("@dangerous"))
+		case "pubsub":
+			categories = append(categories, resp.EncodeSimpleString("@pubsub"))
+		case "blocking":
+			categories = append(categories, resp.EncodeSimpleString("@blocking"))
+		case "fast":
+			fast = true
+		}
+	}
+
+	if fast {
+		categories = append(categories, resp.EncodeSimpleString("@fast"))
+	} else {
+		categories = append(categories, resp.EncodeSimpleString("@slow"))
+	}
+	return categories
+}
+
+// Handles a COMMAND command from a client.
+func (s *Server) handleCommandCommand(cmd CommandCommand, client *Client) {
+	switch cmd.Subcommand {
+	case "":
+		infos := s.commandInfos()
+		replies := make([][]byte, len(infos))
+		for i, info := range infos {
+			replies[i] = info.encode(client.protocol)
+		}
+		client.SendMessage(resp.EncodeArray(replies))
+	case "COUNT":
+		client.SendMessage(resp.EncodeInteger(int64(len(commandTable) + len(s.commands))))
+	case "LIST":
+		infos := s.commandInfos()
+		names := make([][]byte, len(infos))
+		for i, info := range infos {
+			names[i] = []byte(strings.ToLower(string(info.name)))
+		}
+		client.SendMessage(resp.EncodeBulkStringArray(names))
+	case "INFO":
+		if len(cmd.Names) == 0 {
+			s.handleCommandCommand(CommandCommand{}, client)
+			return
+		}
+
+		replies := make([][]byte, len(cmd.Names))
+		for i, name := range cmd.Names {
+			if info, ok := s.lookupCommandInfo(name); ok {
+				replies[i] = info.encode(client.protocol)
+			} else {
+				replies[i] = resp.EncodeBulkStringArray(nil)
+			}
+		}
+		client.SendMessage(resp.EncodeArray(replies))
+	case "DOCS":
+		var infos []commandInfo
+		if len(cmd.Names) == 0 {
+			infos = s.commandInfos()
+		}
+		for _, name := range cmd.Names {
+			// Unknown commands are left out of the reply
+			if info, ok := s.lookupCommandInfo(name); ok {
+				infos = append(infos, info)
+			}
+		}
+
+		entries := make([][]byte, 0, len(infos)*2)
+		for _, info := range infos {
+			doc := [][]byte{
+				resp.EncodeBulkString([]byte("summary")), resp.EncodeBulkString([]byte(info.summary)),
+				resp.EncodeBulkString([]byte("group")), resp.EncodeBulkString([]byte(info.group)),
+			}
+			entries = append(entries,
+				resp.EncodeBulkString([]byte(strings.ToLower(string(info.name)))),
+				resp.EncodeMap(doc, client.protocol),
+			)
+		}
+		client.SendMessage(resp.EncodeMap(entries, client.protocol))
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+func TestCommandTable(t *testing.T) {
+	for i, info := range commandTable {
+		if i > 0 && commandTable[i-1].name >= info.name {
+			t.Errorf("Expected the command table to be sorted, %s comes after %s", info.name, commandTable[i-1].name)
+		}
+
+		if _, err := ParseCommand(commandArray(string(info.name))); errors.Is(err, errUnknownCommand) {
+			t.Errorf("%s is not a built-in command", info.name)
+			continue
+		}
+
+		// Commands with fewer arguments than the arity, or more for a fixed arity, must be rejected
+		withArgs := func(n int) resp.RespArray {
+			return commandArray(append([]string{string(info.name)}, strings.Split(strings.Repeat("1", n), "")...)...)
+		}
+		if arity := max(info.arity, -info.arity); arity > 1 {
+			if _, err := ParseCommand(withArgs(arity - 2)); err == nil {
+				t.Errorf("Expected %s with %d arguments to be rejected, the arity is %d", info.name, arity-2, info.arity)
+			}
+		}
+		if info.arity > 0 {
+			if _, err := ParseCommand(withArgs(info.arity)); err == nil {
+				t.Errorf("Expected %s with %d arguments to be rejected, the arity is %d", info.name, info.arity, info.arity)
+			}
+		}
+	}
+}
+
+func TestCommandCommand(t *testing.T) {
+	_, addr := startTestServer(t, func(s *Server, _ string) {
+		parser := func(args [][]byte) (Command, error) { return echoCommand{Args: args}, nil }
+		handler := func(store KVStore, cmd Command) ([]byte, error) { return resp.EncodeSimpleString("OK"), nil }
+		if err := s.RegisterCommand("ECHOALL", parser, handler); err != nil {
+			t.Fatal(err)
+		}
+	})
+	client := dialTestServer(t, addr)
+
+	if val, _ := client.do("COMMAND", "COUNT").(resp.RespInteger); val.Value != int64(len(commandTable)+1) {
+		t.Errorf("Expected COMMAND COUNT to count the built-in and custom commands, got %v", val)
+	}
+	if val, _ := client.do("COMMAND").(resp.RespArray); len(val.Elements) != len(commandTable)+1 {
+		t.Errorf("Expected COMMAND to describe every command, got %d commands", len(val.Elements))
+	}
+
+	val, _ := client.do("COMMAND", "INFO", "get", "MGET", "ECHOALL", "unknown").(resp.RespArray)
+	if len(val.Elements) != 4 {
+		t.Fatalf("Expected COMMAND INFO to reply for every name, got %v", val)
+	}
+	get, _ := val.Elements[0].(resp.RespArray)
+	if len(get.Elements) != 10 || string(get.Elements[0].(resp.RespBulkString).Value) != "get" {
+		t.Fatalf("Expected the description of GET, got %v", get)
+	}
+	if arity := get.Elements[1].(resp.RespInteger).Value; arity != 2 {
+		t.Errorf("Expected GET to take 2 arguments, got %d", arity)
+	}
+	if flags, _ := get.Elements[2].(resp.RespArray); len(flags.Elements) != 2 || flags.Elements[0] != (resp.RespSimpleString{Value: "readonly"}) {
+		t.Errorf("Expected GET to be a fast read, got %v", flags)
+	}
+	mget, _ := val.Elements[1].(resp.RespArray)
+	first, last, step := mget.Elements[3].(resp.RespInteger), mget.Elements[4].(resp.RespInteger), mget.Elements[5].(resp.RespInteger)
+	if first.Value != 1 || last.Value != -1 || step.Value != 1 {
+		t.Errorf("Expected MGET keys from the first argument to the last, got %d %d %d", first.Value, last.Value, step.Value)
+	}
+	if custom, _ := val.Elements[2].(resp.RespArray); len(custom.Elements) != 10 || custom.Elements[1] != (resp.RespInteger{Value: -1}) {
+		t.Errorf("Expected custom commands to take any number of arguments, got %v", custom)
+	}
+	if unknown, _ := val.Elements[3].(resp.RespArray); unknown.Elements != nil {
+		t.Errorf("Expected a null array for an unknown command, got %v", unknown)
+	}
+
+	docs, _ := client.do("COMMAND", "DOCS", "set", "unknown").(resp.RespArray)
+	if len(docs.Elements) != 2 || string(docs.Elements[0].(resp.RespBulkString).Value) != "set" {
+		t.Fatalf("Expected COMMAND DOCS to describe SET, got %v", docs)
+	}
+	doc, _ := docs.Elements[1].(resp.RespArray)
+	if len(doc.Elements) != 4 || string(doc.Elements[3].(resp.RespBulkString).Value) != "string" {
+		t.Errorf("Expected the summary and group of SET, got %v", doc)
+	}
+
+	if val, _ := client.do("COMMAND", "HELP").(resp.RespErrorValue); !strings.Contains(val.Message, "unknown subcommand") {
+		t.Errorf("Expected an unknown subcommand to be rejected, got %v", val)
+	}
+}
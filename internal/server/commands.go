@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Parses the arguments of a custom command, without the command name, into the command passed to
+// its handler.
+type CommandParser func(args [][]byte) (Command, error)
+
+// Executes a custom command returned by its parser and returns the RESP encoded reply. Runs on the
+// server loop like the built-in commands, so it must not block.
+type CommandHandler func(store KVStore, cmd Command) ([]byte, error)
+
+type customCommand struct {
+	parse  CommandParser
+	handle CommandHandler
+}
+
+// A custom command parsed by a client, queued to the server loop with its handler.
+type customCommandCall struct {
+	name   CommandName
+	cmd    Command
+	handle CommandHandler
+}
+
+// Registers a command handled by the application embedding the server. Names are case-sensitive
+// like the built-in commands, which cannot be replaced. Must be called before ListenAndServe.
+func (s *Server) RegisterCommand(name string, parser CommandParser, handler CommandHandler) error {
+	if name == "" {
+		return fmt.Errorf("command name cannot be empty")
+	}
+	if parser == nil || handler == nil {
+		return fmt.Errorf("command %s requires a parser and a handler", name)
+	}
+	if _, exists := s.commands[CommandName(name)]; exists {
+		return fmt.Errorf("command %s is already registered", name)
+	}
+
+	probe := resp.RespArray{Elements: []resp.RespValue{resp.RespBulkString{Value: []byte(name)}}}
+	if _, err := ParseCommand(probe); !errors.Is(err, errUnknownCommand) {
+		return fmt.Errorf("command %s is a built-in command", name)
+	}
+
+	s.commands[CommandName(name)] = customCommand{parse: parser, handle: handler}
+	return nil
+}
+
+// Parses a command, looking up the custom commands before the built-in ones.
+func parseCommand(arr resp.RespArray, custom map[CommandName]customCommand) (Command, error) {
+	name, ok := arr.Elements[0].(resp.RespBulkString)
+	if !ok {
+		return ParseCommand(arr)
+	}
+
+	command, exists := custom[CommandName(name.Value)]
+	if !exists {
+		return ParseCommand(arr)
+	}
+
+	args, err := parseArgs(arr, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd, err := command.parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return customCommandCall{name: CommandName(name.Value), cmd: cmd, handle: command.handle}, nil
+}
+
+func (s *Server) handleCustomCommand(call customCommandCall, client *Client) {
+	reply, err := call.handle(s.store, call.cmd)
+	if err != nil {
+		s.logger.Error("failed to handle custom command", "command", call.name, "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(reply)
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+type echoCommand struct {
+	Args [][]byte
+}
+
+func newTestServer(t *testing.T) *Server {
+	store := NewInMemoryKVStore()
+	t.Cleanup(store.Close)
+
+	return NewServer(slog.New(slog.NewTextHandler(io.Discard, nil)), "127.0.0.1:0", store)
+}
+
+func commandArray(args ...string) resp.RespArray {
+	elements := make([]resp.RespValue, len(args))
+	for i, arg := range args {
+		elements[i] = resp.RespBulkString{Value: []byte(arg)}
+	}
+	return resp.RespArray{Elements: elements}
+}
+
+func TestRegisterCommand(t *testing.T) {
+	s := newTestServer(t)
+	parser := func(args [][]byte) (Command, error) { return echoCommand{Args: args}, nil }
+	handler := func(store KVStore, cmd Command) ([]byte, error) {
+		return resp.EncodeBulkStringArray(cmd.(echoCommand).Args), nil
+	}
+
+	if err := s.RegisterCommand("ECHOALL", parser, handler); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := s.RegisterCommand("ECHOALL", parser, handler); err == nil {
+		t.Error("Expected an error registering a command twice")
+	}
+	if err := s.RegisterCommand("GET", parser, handler); err == nil {
+		t.Error("Expected an error replacing a built-in command")
+	}
+	if err := s.RegisterCommand("PING", parser, handler); err == nil {
+		t.Error("Expected an error replacing a built-in command without required arguments")
+	}
+	if err := s.RegisterCommand("NOHANDLER", parser, nil); err == nil {
+		t.Error("Expected an error registering a command without a handler")
+	}
+
+	cmd, err := parseCommand(commandArray("ECHOALL", "a", "b"), s.commands)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	call, ok := cmd.(customCommandCall)
+	if !ok {
+		t.Fatalf("Expected a custom command, got %T", cmd)
+	}
+
+	reply, err := call.handle(s.store, call.cmd)
+	if err != nil || string(reply) != "*2\r\n$1\r\na\r\n$1\r\nb\r\n" {
+		t.Errorf("Unexpected reply %q (%v)", reply, err)
+	}
+
+	// Built-in commands are still parsed as usual
+	if cmd, err := parseCommand(commandArray("GET", "key"), s.commands); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	} else if _, ok := cmd.(GetCommand); !ok {
+		t.Errorf("Expected a GET command, got %T", cmd)
+	}
+}
+
+func TestParseDeleteIfType(t *testing.T) {
+	s := newTestServer(t)
+
+	cmd, err := parseCommand(commandArray("DEL", "a", "b", "iftype", "LIST"), s.commands)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	del := cmd.(DeleteCommand)
+	if len(del.Keys) != 2 || del.IfType != "list" {
+		t.Errorf("Expected keys a and b with IFTYPE list, got %q with IFTYPE %q", del.Keys, del.IfType)
+	}
+
+	// IFTYPE is only an option second to last
+	cmd, err = parseCommand(commandArray("DEL", "IFTYPE", "list"), s.commands)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if del := cmd.(DeleteCommand); len(del.Keys) != 2 || del.IfType != "" {
+		t.Errorf("Expected the keys IFTYPE and list, got %q with IFTYPE %q", del.Keys, del.IfType)
+	}
+
+	if _, err := parseCommand(commandArray("DEL", "a", "IfType", "bitmap"), s.commands); err == nil {
+		t.Error("Expected an unknown type to be rejected")
+	}
+}
+
+func TestParseMGet(t *testing.T) {
+	s := newTestServer(t)
+
+	cmd, err := parseCommand(commandArray("MGET", "a", "b", "a"), s.commands)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if mget := cmd.(MGetCommand); len(mget.Keys) != 3 || string(mget.Keys[0]) != "a" || string(mget.Keys[2]) != "a" {
+		t.Errorf("Expected the keys a, b and a in order, got %q", mget.Keys)
+	}
+
+	if _, err := parseCommand(commandArray("MGET"), s.commands); err == nil {
+		t.Error("Expected MGET without keys to be rejected")
+	}
+	arr := commandArray("MGET", "a")
+	arr.Elements = append(arr.Elements, resp.RespInteger{Value: 1})
+	if _, err := parseCommand(arr, s.commands); err == nil {
+		t.Error("Expected a key that is not a bulk string to be rejected")
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import "runtime"
+
+// Read commands run on the goroutine reading the client that sent them rather than on the server
+// loop, so a slow read of one client, like a large ZRANGE, does not delay the commands of the
+// others. The store locks the keys they read. Writes, and every command using state owned by the
+// loop, still run on the loop, which propagates writes to the append-only file and replicas in order.
+//
+// The commands of a client never reorder: its reader runs a read only once the loop handled every
+// command sent before, and reads the next command only once the read replied. Errors found by the
+// reader, like protocol and parse errors, are replied by the loop too, see readerError. At most
+// io-threads - 1 reads run at once, see SetIOThreads.
+
+// Sets the number of threads executing commands, counting the server loop, like Redis' io-threads.
+// With 1, every command runs on the loop. With more, read commands run on up to threads - 1 workers
+// in parallel with the loop. 0 uses one thread per CPU, which is the default. Must be called before
+// ListenAndServe.
+func (s *Server) SetIOThreads(threads int) {
+	if threads <= 0 {
+		threads = runtime.GOMAXPROCS(0)
+	}
+	s.ioThreads = threads
+	s.workers = nil
+	if threads > 1 {
+		s.workers = make(chan struct{}, threads-1)
+	}
+}
+
+// Reports whether a command only reads keys and replies, without using state owned by the server
+// loop.
+func concurrentCommand(cmd Command) bool {
+	switch cmd.(type) {
+	case PingCommand, GetCommand, MGetCommand, ExistsCommand, LLenCommand, LRangeCommand,
+		HGetCommand, HMGetCommand, HLenCommand, HKeysCommand, HGetAllCommand, HExistsCommand, HTTLCommand,
+		SIsMemberCommand, SMembersCommand, SCardCommand, SScanCommand,
+		ZScoreCommand, ZCardCommand, ZRangeCommand, ZRangeByCommand,
+		XLenCommand, XRangeCommand, GeoDistCommand, GeoSearchCommand, BigKeysCommand:
+		return true
+	default:
+		return false
+	}
+}
+
+// Runs a read command on the calling goroutine instead of the server loop, once the loop handled
+// every command sent before by the client, so the replies of a client are never reordered and it
+// reads its own writes. Returns false if the command must be sent to the loop instead: it is not a
+// read, the client is in a state handled by the loop, like being blocked or subscribed, or there are
+// no workers. With appendfsync always, every command runs on the loop so reads never see writes not
+// synced yet. Waits for a free worker when all of them are busy.
+func (s *Server) runConcurrently(msg Message) bool {
+	if s.workers == nil || !concurrentCommand(msg.cmd) || (s.aofPath != "" && s.aofPolicy == FsyncAlways) {
+		return false
+	}
+
+	client := msg.client
+	if !client.waitForLoop() {
+		// Deregistered, the command is dropped like those left on the loop
+		return true
+	}
+	if !client.concurrent.Load() {
+		return false
+	}
+
+	s.workers <- struct{}{}
+	s.latency.measure(latencyCommand, func() { s.handleMessage(msg) })
+	<-s.workers
+	s.stats.commandsProcessed.Add(1)
+	s.stats.concurrentCommands.Add(1)
+	return true
+}
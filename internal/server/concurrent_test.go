@@ -0,0 +1,218 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Sends commands without waiting for their replies.
+func (c *testConn) pipeline(cmds ...[]string) {
+	var pipeline []byte
+	for _, cmd := range cmds {
+		args := make([][]byte, len(cmd))
+		for i, arg := range cmd {
+			args[i] = []byte(arg)
+		}
+		pipeline = append(pipeline, resp.EncodeBulkStringArray(args)...)
+	}
+	if _, err := c.conn.Write(pipeline); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// Reads the next reply.
+func (c *testConn) read() resp.RespValue {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	val, err := resp.ReadRESP(c.reader)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return val
+}
+
+func TestConcurrentCommand(t *testing.T) {
+	if !concurrentCommand(GetCommand{}) || !concurrentCommand(ZRangeCommand{}) {
+		t.Error("Expected reads to run outside the server loop")
+	}
+	if concurrentCommand(SetCommand{}) || concurrentCommand(BlockingPopCommand{}) {
+		t.Error("Expected writes and blocking commands to run on the server loop")
+	}
+}
+
+func TestConcurrentReadsNotDelayedByLoop(t *testing.T) {
+	_, addr := startTestServer(t, func(s *Server, _ string) {
+		s.EnableFaultInjection()
+		s.SetIOThreads(4)
+	})
+	sleeper := dialTestServer(t, addr)
+	reader := dialTestServer(t, addr)
+
+	reader.do("SET", "key", "value")
+	sleeper.pipeline([]string{"DEBUG", "SLEEP", "1"})
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if bulk, _ := reader.do("GET", "key").(resp.RespBulkString); string(bulk.Value) != "value" {
+		t.Errorf("Expected value, got %v", bulk)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected GET to run while the server loop sleeps, took %v", elapsed)
+	}
+	sleeper.read()
+}
+
+func TestConcurrentReadsKeepOrder(t *testing.T) {
+	_, addr := startTestServer(t, func(s *Server, _ string) {
+		s.EnableFaultInjection()
+		s.SetIOThreads(4)
+	})
+	client := dialTestServer(t, addr)
+
+	// Reads sent after a write wait for it, even while the server loop is busy
+	client.pipeline(
+		[]string{"DEBUG", "SLEEP", "0.1"},
+		[]string{"SET", "key", "value"},
+		[]string{"GET", "key"},
+	)
+	for range 2 {
+		if val := client.read(); val != (resp.RespSimpleString{Value: "OK"}) {
+			t.Fatalf("Expected OK, got %v", val)
+		}
+	}
+	if bulk, _ := client.read().(resp.RespBulkString); string(bulk.Value) != "value" {
+		t.Errorf("Expected the value just written, got %v", bulk)
+	}
+
+	// Reads sent while blocked run once the client is unblocked
+	client.pipeline([]string{"BLPOP", "list", "0"}, []string{"GET", "key"})
+	time.Sleep(50 * time.Millisecond)
+	dialTestServer(t, addr).do("RPUSH", "list", "item")
+
+	if arr, _ := client.read().(resp.RespArray); len(arr.Elements) != 2 {
+		t.Fatalf("Expected the reply of BLPOP first, got %v", arr)
+	}
+	if bulk, _ := client.read().(resp.RespBulkString); string(bulk.Value) != "value" {
+		t.Errorf("Expected the reply of GET after BLPOP, got %v", bulk)
+	}
+}
+
+func TestReaderErrorsKeepOrder(t *testing.T) {
+	_, addr := startTestServer(t, func(s *Server, _ string) {
+		s.EnableFaultInjection()
+	})
+	client := dialTestServer(t, addr)
+
+	// Errors found while reading commands follow the replies of the commands sent before
+	client.pipeline([]string{"DEBUG", "SLEEP", "0.2"}, []string{"NOSUCHCMD"}, []string{"PING"})
+	if val := client.read(); val != (resp.RespSimpleString{Value: "OK"}) {
+		t.Fatalf("Expected the reply of DEBUG SLEEP first, got %v", val)
+	}
+	if _, ok := client.read().(resp.RespErrorValue); !ok {
+		t.Fatal("Expected the unknown command error after DEBUG SLEEP")
+	}
+	if val := client.read(); val != (resp.RespSimpleString{Value: "PONG"}) {
+		t.Fatalf("Expected PONG last, got %v", val)
+	}
+
+	// Including while the client is blocked
+	client.pipeline([]string{"BLPOP", "list", "0"}, []string{"NOSUCHCMD"})
+	time.Sleep(50 * time.Millisecond)
+	dialTestServer(t, addr).do("RPUSH", "list", "item")
+	if arr, _ := client.read().(resp.RespArray); len(arr.Elements) != 2 {
+		t.Fatalf("Expected the reply of BLPOP first, got %v", arr)
+	}
+	if _, ok := client.read().(resp.RespErrorValue); !ok {
+		t.Error("Expected the unknown command error after BLPOP")
+	}
+
+	// And before the connection is closed on a malformed command
+	client.conn.Write([]byte("*1\r\n$4\r\nPING\r\n" + "*1\r\n$abc\r\n"))
+	if val := client.read(); val != (resp.RespSimpleString{Value: "PONG"}) {
+		t.Fatalf("Expected PONG before the protocol error, got %v", val)
+	}
+	if _, ok := client.read().(resp.RespErrorValue); !ok {
+		t.Error("Expected the protocol error last")
+	}
+}
+
+func TestSetIOThreads(t *testing.T) {
+	s := newTestServer(t)
+	s.SetIOThreads(1)
+	if s.workers != nil || s.runConcurrently(Message{cmd: GetCommand{}}) {
+		t.Error("Expected every command to run on the server loop with 1 thread")
+	}
+	s.SetIOThreads(4)
+	if cap(s.workers) != 3 {
+		t.Errorf("Expected 3 workers besides the server loop, got %d", cap(s.workers))
+	}
+	s.SetIOThreads(0)
+	if s.ioThreads < 1 {
+		t.Errorf("Expected one thread per CPU by default, got %d", s.ioThreads)
+	}
+}
+
+func TestIOThreadsLimitConcurrentReads(t *testing.T) {
+	s, addr := startTestServer(t, func(s *Server, _ string) { s.SetIOThreads(2) })
+	dialTestServer(t, addr).do("SET", "key", "value")
+
+	// With a single worker busy, the reads of other clients wait for it
+	s.workers <- struct{}{}
+	replied := make(chan resp.RespValue)
+	go func() {
+		conn := dialTestServer(t, addr)
+		conn.pipeline([]string{"GET", "key"})
+		replied <- conn.read()
+	}()
+	select {
+	case val := <-replied:
+		t.Fatalf("Expected GET to wait for a free worker, got %v", val)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	<-s.workers
+	if bulk, _ := (<-replied).(resp.RespBulkString); string(bulk.Value) != "value" {
+		t.Errorf("Expected value once the worker is free, got %v", bulk)
+	}
+}
+
+func TestIOThreadsKeepClientOrder(t *testing.T) {
+	for _, threads := range []int{1, 4} {
+		t.Run(strconv.Itoa(threads), func(t *testing.T) {
+			_, addr := startTestServer(t, func(s *Server, _ string) { s.SetIOThreads(threads) })
+
+			// Every GET pipelined after a SET replies with the value just set, whatever runs the GET
+			const clients = 4
+			const rounds = 200
+			var wg sync.WaitGroup
+			for c := range clients {
+				conn := dialTestServer(t, addr)
+				key := "counter:" + strconv.Itoa(c)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					var cmds [][]string
+					for i := range rounds {
+						cmds = append(cmds, []string{"SET", key, strconv.Itoa(i)}, []string{"GET", key})
+					}
+					conn.pipeline(cmds...)
+
+					for i := range rounds {
+						if val := conn.read(); val != (resp.RespSimpleString{Value: "OK"}) {
+							t.Errorf("Expected SET to reply OK, got %v", val)
+							return
+						}
+						if bulk, _ := conn.read().(resp.RespBulkString); string(bulk.Value) != strconv.Itoa(i) {
+							t.Errorf("Expected GET to reply %d, got %v", i, bulk)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
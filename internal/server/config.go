@@ -0,0 +1,574 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/config"
+	"github.com/CDavidSV/GopherStore/internal/resp"
+	"github.com/CDavidSV/GopherStore/internal/util"
+)
+
+// A server setting inspected with CONFIG GET and changed at runtime with CONFIG SET. Settings are
+// named after the flags of cmd/server. Getters and setters run on the server loop.
+type configParam struct {
+	name string
+	get  func(s *Server) (string, bool)      // Returns false if the setting does not apply, e.g. to this store
+	set  func(s *Server, value string) error // nil for settings that can only be set at startup
+}
+
+// Stores whose settings can be changed at runtime.
+type tunableStore interface {
+	SetCleanupBudget(budget time.Duration)
+	CleanupBudget() time.Duration
+	SetCleanupInterval(interval time.Duration)
+	CleanupInterval() time.Duration
+	SetDefaultTTL(ttl time.Duration)
+	DefaultTTL() time.Duration
+	SetLazyFreeThreshold(elements int64)
+	LazyFreeThreshold() int64
+	SetActiveExpire(enabled bool)
+	SetMaxMemory(maxMemory int64)
+	MaxMemory() int64
+	SetEvictionPolicy(policy EvictionPolicy)
+	EvictionPolicy() EvictionPolicy
+}
+
+var (
+	errImmutableConfig = errors.New("can't set immutable config")
+	errNoConfigFile    = errors.New("the server is running without a config file")
+	errServerStopped   = errors.New("the server is stopped")
+)
+
+// Settings in alphabetical order, the order of CONFIG GET replies.
+var configParams = []configParam{
+	{
+		name: "appendfilename",
+		get:  func(s *Server) (string, bool) { return s.aofPath, true },
+	},
+	{
+		name: "appendfsync",
+		get:  func(s *Server) (string, bool) { return s.aofPolicy.String(), true },
+	},
+	{
+		name: "appendonly",
+		get:  func(s *Server) (string, bool) { return formatConfigBool(s.aofPath != ""), true },
+	},
+	{
+		name: "dbfilename",
+		get:  func(s *Server) (string, bool) { return s.snapshotPath, true },
+	},
+	storeDurationParam("default-ttl", tunableStore.DefaultTTL, tunableStore.SetDefaultTTL, 0),
+	storeDurationParam("expire-budget", tunableStore.CleanupBudget, tunableStore.SetCleanupBudget, time.Nanosecond),
+	storeDurationParam("expire-interval", tunableStore.CleanupInterval, tunableStore.SetCleanupInterval, time.Millisecond),
+	{
+		name: "io-threads",
+		get:  func(s *Server) (string, bool) { return strconv.Itoa(s.ioThreads), true },
+	},
+	{
+		name: "lazyfree-threshold",
+		get: func(s *Server) (string, bool) {
+			store, ok := s.store.(tunableStore)
+			if !ok {
+				return "", false
+			}
+			return strconv.FormatInt(store.LazyFreeThreshold(), 10), true
+		},
+		set: func(s *Server, value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return fmt.Errorf("argument must be a non-negative integer")
+			}
+			s.store.(tunableStore).SetLazyFreeThreshold(n)
+			return nil
+		},
+	},
+	{
+		name: "latency-monitor-threshold",
+		get:  func(s *Server) (string, bool) { return strconv.FormatInt(s.latency.threshold.Load(), 10), true },
+		set: func(s *Server, value string) error {
+			ms, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || ms < 0 {
+				return fmt.Errorf("argument must be a non-negative number of milliseconds")
+			}
+			s.latency.threshold.Store(ms)
+			return nil
+		},
+	},
+	{
+		name: "log-level",
+		get: func(s *Server) (string, bool) {
+			if s.logLevel == nil {
+				return "", false
+			}
+			return strings.ToLower(s.logLevel.Level().String()), true
+		},
+		set: func(s *Server, value string) error {
+			var level slog.Level
+			if err := level.UnmarshalText([]byte(value)); err != nil {
+				return fmt.Errorf("argument must be debug, info, warn or error")
+			}
+			s.logLevel.Set(level)
+			return nil
+		},
+	},
+	{
+		name: "masterauth",
+		get: func(s *Server) (string, bool) {
+			s.configMu.RLock()
+			defer s.configMu.RUnlock()
+			return s.primaryAuth, true
+		},
+		set: func(s *Server, value string) error {
+			s.SetPrimaryAuth(value)
+			return nil
+		},
+	},
+	{
+		name: "maxmemory",
+		get: func(s *Server) (string, bool) {
+			store, ok := s.store.(tunableStore)
+			if !ok {
+				return "", false
+			}
+			return strconv.FormatInt(store.MaxMemory(), 10), true
+		},
+		set: func(s *Server, value string) error {
+			n, ok := util.ParseMemorySize([]byte(value))
+			if !ok {
+				return fmt.Errorf("argument must be a memory size, e.g. 100mb")
+			}
+			s.store.(tunableStore).SetMaxMemory(n)
+			return nil
+		},
+	},
+	{
+		name: "maxmemory-policy",
+		get: func(s *Server) (string, bool) {
+			store, ok := s.store.(tunableStore)
+			if !ok {
+				return "", false
+			}
+			return store.EvictionPolicy().String(), true
+		},
+		set: func(s *Server, value string) error {
+			policy, err := ParseEvictionPolicy(value)
+			if err != nil {
+				return err
+			}
+			s.store.(tunableStore).SetEvictionPolicy(policy)
+			return nil
+		},
+	},
+	{
+		name: "notify-keyspace-events",
+		get:  func(s *Server) (string, bool) { return s.keyspaceEvents.String(), true },
+		set: func(s *Server, value string) error {
+			flags, err := ParseKeyspaceEvents(value)
+			if err != nil {
+				return err
+			}
+			return s.SetKeyspaceEvents(flags)
+		},
+	},
+	limitParam("proto-max-array-len", func(l *resp.Limits) *int { return &l.MaxArrayLength }),
+	limitParam("proto-max-bulk-len", func(l *resp.Limits) *int { return &l.MaxBulkLength }),
+	limitParam("proto-max-depth", func(l *resp.Limits) *int { return &l.MaxDepth }),
+	connDurationParam("read-timeout", func(s *Server) *time.Duration { return &s.readTimeout }),
+	{
+		name: "replica-read-only",
+		get:  func(s *Server) (string, bool) { return formatConfigBool(!s.writable), true },
+		set: func(s *Server, value string) error {
+			readOnly, err := parseConfigBool(value)
+			if err != nil {
+				return err
+			}
+			s.SetReplicaReadOnly(readOnly)
+			return nil
+		},
+	},
+	{
+		name: "requirepass",
+		get: func(s *Server) (string, bool) {
+			s.configMu.RLock()
+			defer s.configMu.RUnlock()
+			return s.requirePass, true
+		},
+		set: func(s *Server, value string) error {
+			// Clients already connected stay authenticated, like in Redis
+			s.SetRequirePass(value)
+			return nil
+		},
+	},
+	{
+		name: "save",
+		get: func(s *Server) (string, bool) {
+			parts := make([]string, 0, len(s.saveRules)*2)
+			for _, rule := range s.saveRules {
+				parts = append(parts, strconv.Itoa(int(rule.After/time.Second)), strconv.FormatUint(rule.Changes, 10))
+			}
+			return strings.Join(parts, " "), true
+		},
+		set: func(s *Server, value string) error {
+			rules, err := ParseSaveRules(value)
+			if err != nil {
+				return err
+			}
+			s.SetSaveRules(rules)
+			return nil
+		},
+	},
+	connDurationParam("tcp-keepalive", func(s *Server) *time.Duration { return &s.tcpKeepAlive }),
+	{
+		name: "ttl-jitter",
+		get:  func(s *Server) (string, bool) { return strconv.FormatFloat(s.ttlJitter, 'g', -1, 64), true },
+		set: func(s *Server, value string) error {
+			fraction, err := strconv.ParseFloat(value, 64)
+			if err != nil || fraction < 0 || fraction >= 1 {
+				return fmt.Errorf("argument must be at least 0 and less than 1")
+			}
+			s.SetTTLJitter(fraction)
+			return nil
+		},
+	},
+	{
+		name: "ttl-policy",
+		get:  func(s *Server) (string, bool) { return s.ttlPolicy.String(), true },
+		set: func(s *Server, value string) error {
+			policy, err := ParseExpirationPolicy(value)
+			if err != nil {
+				return err
+			}
+			s.SetExpirationPolicy(policy)
+			return nil
+		},
+	},
+	connDurationParam("write-timeout", func(s *Server) *time.Duration { return &s.writeTimeout }),
+}
+
+// A duration setting of stores implementing tunableStore, which must be at least min.
+func storeDurationParam(name string, get func(tunableStore) time.Duration, set func(tunableStore, time.Duration), min time.Duration) configParam {
+	return configParam{
+		name: name,
+		get: func(s *Server) (string, bool) {
+			store, ok := s.store.(tunableStore)
+			if !ok {
+				return "", false
+			}
+			return get(store).String(), true
+		},
+		set: func(s *Server, value string) error {
+			d, err := time.ParseDuration(value)
+			if err != nil || d < min {
+				return fmt.Errorf("argument must be a duration of at least %s, e.g. 30s", min)
+			}
+			set(s.store.(tunableStore), d)
+			return nil
+		},
+	}
+}
+
+// A limit on the commands read from clients, applied to new connections.
+func limitParam(name string, field func(l *resp.Limits) *int) configParam {
+	return configParam{
+		name: name,
+		get: func(s *Server) (string, bool) {
+			s.configMu.RLock()
+			defer s.configMu.RUnlock()
+			return strconv.Itoa(*field(&s.limits)), true
+		},
+		set: func(s *Server, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return fmt.Errorf("argument must be a non-negative integer")
+			}
+
+			s.configMu.Lock()
+			defer s.configMu.Unlock()
+			*field(&s.limits) = n
+			return nil
+		},
+	}
+}
+
+// A connection setting of the server, applied to new connections. 0 disables it.
+func connDurationParam(name string, field func(s *Server) *time.Duration) configParam {
+	return configParam{
+		name: name,
+		get: func(s *Server) (string, bool) {
+			s.configMu.RLock()
+			defer s.configMu.RUnlock()
+			return field(s).String(), true
+		},
+		set: func(s *Server, value string) error {
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return fmt.Errorf("argument must be a non-negative duration, e.g. 30s")
+			}
+
+			s.configMu.Lock()
+			defer s.configMu.Unlock()
+			*field(s) = d
+			return nil
+		},
+	}
+}
+
+// Formats a boolean setting the way Redis does.
+func formatConfigBool(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// Parses a boolean setting, given as yes or no like in Redis, or as true or false like the flags.
+func parseConfigBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes", "true":
+		return true, nil
+	case "no", "false":
+		return false, nil
+	default:
+		return false, fmt.Errorf("argument must be 'yes' or 'no'")
+	}
+}
+
+// Returns the setting with the given name.
+func findConfigParam(name string) (configParam, bool) {
+	for _, param := range configParams {
+		if param.name == name {
+			return param, true
+		}
+	}
+	return configParam{}, false
+}
+
+// Handles a CONFIG command from a client.
+func (s *Server) handleConfigCommand(cmd ConfigCommand, client *Client) {
+	switch cmd.Subcommand {
+	case "GET":
+		var entries [][]byte
+		for _, param := range configParams {
+			matched := false
+			for _, pattern := range cmd.Patterns {
+				if util.MatchPattern([]byte(pattern), []byte(param.name)) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				continue
+			}
+
+			if value, ok := param.get(s); ok {
+				entries = append(entries, resp.EncodeBulkString([]byte(param.name)), resp.EncodeBulkString([]byte(value)))
+			}
+		}
+		client.SendMessage(resp.EncodeMap(entries, client.protocol))
+	case "SET":
+		if err := s.setConfig(cmd.Pairs); err != nil {
+			client.SendMessage(resp.EncodeError(err.Error()))
+			return
+		}
+		client.SendMessage(resp.EncodeSimpleString("OK"))
+	case "REWRITE":
+		if err := s.rewriteConfig(); err != nil {
+			client.SendMessage(resp.EncodeError("ERR Rewriting config file: " + err.Error()))
+			return
+		}
+		client.SendMessage(resp.EncodeSimpleString("OK"))
+	}
+}
+
+// Applies alternating settings and values. Either every setting is changed or none is.
+func (s *Server) setConfig(pairs []string) error {
+	params := make([]configParam, 0, len(pairs)/2)
+	values := make([]string, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		param, ok := findConfigParam(pairs[i])
+		if !ok {
+			return fmt.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", pairs[i])
+		}
+		if _, applies := param.get(s); !applies {
+			return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - not supported by the store", param.name)
+		}
+		if param.set == nil {
+			return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - %w", param.name, errImmutableConfig)
+		}
+		params = append(params, param)
+		values = append(values, pairs[i+1])
+	}
+
+	if name, err := s.applyConfig(params, values); err != nil {
+		return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - %w", name, err)
+	}
+
+	names := make([]string, len(params))
+	for i, param := range params {
+		names[i] = param.name
+		if s.configChanged == nil {
+			s.configChanged = make(map[string]bool)
+		}
+		s.configChanged[param.name] = true
+	}
+	s.logger.Info("configuration changed with CONFIG SET", "settings", strings.Join(names, ","))
+	return nil
+}
+
+// Changes the settings to the given values. Settings already changed are restored when a later one
+// fails, whose name is returned with the error.
+func (s *Server) applyConfig(params []configParam, values []string) (string, error) {
+	previous := make([]string, 0, len(params))
+	for i, param := range params {
+		old, _ := param.get(s)
+		if err := param.set(s, values[i]); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				params[j].set(s, previous[j])
+			}
+			return param.name, err
+		}
+		previous = append(previous, old)
+	}
+	return "", nil
+}
+
+// Sets the YAML configuration file the server was started with, reloaded by ReloadConfig and
+// written by CONFIG REWRITE. The overridden settings, given on the command line or by environment
+// variables, take precedence over the file and are not reloaded from it. Must be called before
+// ListenAndServe.
+func (s *Server) SetConfigFile(path string, overridden []string) {
+	s.configFile = path
+	s.configOverridden = make(map[string]bool, len(overridden))
+	for _, name := range overridden {
+		s.configOverridden[name] = true
+	}
+}
+
+// Lets CONFIG SET and reloads change the level of the logger, which must be the level of the
+// handler of the logger given to NewServer. Must be called before ListenAndServe.
+func (s *Server) SetLogLevel(level *slog.LevelVar) {
+	s.logLevel = level
+}
+
+// Reloads the configuration file, applying the settings that can be changed at runtime, like with
+// CONFIG SET, without dropping the connected clients. Either every setting is changed or none is.
+// Other settings take effect at the next restart. Safe to call from any goroutine once started.
+func (s *Server) ReloadConfig() error {
+	done := make(chan error, 1)
+	select {
+	case s.reloadCh <- done:
+		return <-done
+	case <-s.stoppedCh:
+		return errServerStopped
+	}
+}
+
+// Applies the settings of the configuration file that differ from the current ones.
+// Must be called from the server loop.
+func (s *Server) reloadConfig() error {
+	if s.configFile == "" {
+		return errNoConfigFile
+	}
+	data, err := os.ReadFile(s.configFile)
+	if err != nil {
+		return err
+	}
+	settings, err := config.ParseFile(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.configFile, err)
+	}
+
+	var params []configParam
+	var values []string
+	for _, param := range configParams {
+		value, ok := settings[param.name]
+		if !ok || param.set == nil || s.configOverridden[param.name] {
+			continue
+		}
+		if current, applies := param.get(s); !applies || current == value {
+			continue
+		}
+		params = append(params, param)
+		values = append(values, value)
+	}
+
+	if name, err := s.applyConfig(params, values); err != nil {
+		return fmt.Errorf("%s: invalid value for setting %s: %w", s.configFile, name, err)
+	}
+
+	names := make([]string, len(params))
+	for i, param := range params {
+		names[i] = param.name
+		delete(s.configChanged, param.name)
+	}
+	s.logger.Info("configuration reloaded", "path", s.configFile, "settings", strings.Join(names, ","))
+	return nil
+}
+
+// Writes the settings changed with CONFIG SET to the configuration file, keeping its other settings
+// and comments. The file is replaced atomically, like snapshots. Must be called from the server loop.
+func (s *Server) rewriteConfig() error {
+	if s.configFile == "" {
+		return errNoConfigFile
+	}
+
+	settings := make(map[string]string, len(s.configChanged))
+	for name := range s.configChanged {
+		param, _ := findConfigParam(name)
+		settings[name], _ = param.get(s)
+	}
+
+	// The permissions of the file are kept since it may hold passwords
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(s.configFile); err == nil {
+		mode = info.Mode().Perm()
+	}
+	data, err := os.ReadFile(s.configFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	data, err = config.Rewrite(data, settings)
+	if err != nil {
+		return err
+	}
+
+	dir, name := filepath.Split(s.configFile)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, name+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.configFile); err != nil {
+		return err
+	}
+
+	s.logger.Info("configuration file rewritten", "path", s.configFile)
+	return nil
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+func TestConfigGetSet(t *testing.T) {
+	s, addr := startTestServer(t)
+	client := dialTestServer(t, addr)
+	ok := resp.RespSimpleString{Value: "OK"}
+
+	// Replies with the names and values of the matching settings
+	config := func(patterns ...string) map[string]string {
+		val, isArray := client.do(append([]string{"CONFIG", "GET"}, patterns...)...).(resp.RespArray)
+		if !isArray || len(val.Elements)%2 != 0 {
+			t.Fatalf("Expected CONFIG GET to reply with names and values, got %v", val)
+		}
+		settings := make(map[string]string)
+		for i := 0; i < len(val.Elements); i += 2 {
+			name := val.Elements[i].(resp.RespBulkString)
+			value := val.Elements[i+1].(resp.RespBulkString)
+			settings[string(name.Value)] = string(value.Value)
+		}
+		return settings
+	}
+	isError := func(val resp.RespValue, contains string) bool {
+		errVal, ok := val.(resp.RespErrorValue)
+		return ok && strings.Contains(errVal.Message, contains)
+	}
+
+	if got := config("proto-max-*"); len(got) != 3 || got["proto-max-depth"] != "32" {
+		t.Errorf("Expected the 3 request limits, got %v", got)
+	}
+	if got := config("EXPIRE-BUDGET", "appendonly"); got["expire-budget"] != "25ms" || got["appendonly"] != "no" {
+		t.Errorf("Expected case-insensitive patterns to match, got %v", got)
+	}
+
+	if val := client.do("CONFIG", "SET", "expire-interval", "100ms", "ttl-jitter", "0.1"); val != ok {
+		t.Fatalf("Expected CONFIG SET to succeed, got %v", val)
+	}
+	if got := config("expire-interval", "ttl-jitter"); got["expire-interval"] != "100ms" || got["ttl-jitter"] != "0.1" {
+		t.Errorf("Expected the new values, got %v", got)
+	}
+	if interval := s.store.(*InMemoryKVStore).CleanupInterval(); interval != 100*time.Millisecond {
+		t.Errorf("Expected the store to use the new interval, got %v", interval)
+	}
+
+	tests := []struct {
+		args     []string
+		contains string
+	}{
+		{args: []string{"unknown", "1"}, contains: "Unknown option"},
+		{args: []string{"appendonly", "yes"}, contains: "can't set immutable config"},
+		{args: []string{"expire-budget", "soon"}, contains: "argument must be a duration"},
+		{args: []string{"ttl-jitter", "0.5", "save", "10"}, contains: "possibly related to argument 'save'"},
+	}
+	for _, tt := range tests {
+		if val := client.do(append([]string{"CONFIG", "SET"}, tt.args...)...); !isError(val, tt.contains) {
+			t.Errorf("Expected CONFIG SET %v to fail with %q, got %v", tt.args, tt.contains, val)
+		}
+	}
+	if got := config("ttl-jitter"); got["ttl-jitter"] != "0.1" {
+		t.Errorf("Expected settings to be restored when another one fails, got %v", got)
+	}
+
+	if val := client.do("CONFIG", "REWRITE"); !isError(val, "without a config file") {
+		t.Errorf("Expected CONFIG REWRITE to require a config file, got %v", val)
+	}
+}
+
+func TestConfigSetAppliesToNewConnections(t *testing.T) {
+	_, addr := startTestServer(t)
+	admin := dialTestServer(t, addr)
+	ok := resp.RespSimpleString{Value: "OK"}
+
+	if val := admin.do("CONFIG", "SET", "proto-max-bulk-len", "4", "requirepass", "secret"); val != ok {
+		t.Fatalf("Expected CONFIG SET to succeed, got %v", val)
+	}
+	if val := admin.do("SET", "key", "long value"); val != ok {
+		t.Errorf("Expected connections to keep their limits and authentication, got %v", val)
+	}
+
+	client := dialTestServer(t, addr)
+	if val, _ := client.do("GET", "key").(resp.RespErrorValue); !strings.HasPrefix(val.Message, "NOAUTH") {
+		t.Errorf("Expected new connections to require authentication, got %v", val)
+	}
+	client.do("AUTH", "secret")
+	if val, _ := client.do("SET", "key", "long value").(resp.RespErrorValue); !strings.Contains(val.Message, "exceeds the limit of 4") {
+		t.Errorf("Expected new connections to use the new limits, got %v", val)
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gopherstore.yaml")
+	writeFile := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("addr: 0.0.0.0:5001\nlog-level: debug\n")
+
+	level := new(slog.LevelVar)
+	s, addr := startTestServer(t, func(s *Server, _ string) {
+		s.SetConfigFile(path, []string{"ttl-jitter"})
+		s.SetLogLevel(level)
+	})
+	client := dialTestServer(t, addr)
+
+	writeFile(`
+addr: 0.0.0.0:6000
+log-level: warn
+limits:
+  proto-max-depth: 4
+  ttl-jitter: 0.5
+`)
+	if err := s.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if level.Level() != slog.LevelWarn {
+		t.Errorf("Expected the log level to be reloaded, got %v", level.Level())
+	}
+	if got := s.limits.MaxDepth; got != 4 {
+		t.Errorf("Expected the settings of the file to be applied, got proto-max-depth %d", got)
+	}
+	if s.ttlJitter != 0 {
+		t.Errorf("Expected overridden settings to be ignored, got ttl-jitter %v", s.ttlJitter)
+	}
+	if val := client.do("PING"); val != (resp.RespSimpleString{Value: "PONG"}) {
+		t.Errorf("Expected clients to stay connected, got %v", val)
+	}
+
+	// An invalid value leaves every setting unchanged
+	writeFile("log-level: error\nproto-max-depth: deep\n")
+	if err := s.ReloadConfig(); err == nil || !strings.Contains(err.Error(), "proto-max-depth") {
+		t.Errorf("Expected the invalid setting to be reported, got %v", err)
+	}
+	if level.Level() != slog.LevelWarn {
+		t.Errorf("Expected the log level to be restored, got %v", level.Level())
+	}
+}
+
+func TestConfigRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gopherstore.yaml")
+	data := "# Limits\nlimits:\n  proto-max-depth: 8 # Nesting\nappendonly: yes\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, addr := startTestServer(t, func(s *Server, _ string) {
+		s.SetConfigFile(path, nil)
+	})
+	client := dialTestServer(t, addr)
+	ok := resp.RespSimpleString{Value: "OK"}
+
+	if val := client.do("CONFIG", "SET", "proto-max-depth", "4", "save", "60 100"); val != ok {
+		t.Fatalf("Expected CONFIG SET to succeed, got %v", val)
+	}
+	if val := client.do("CONFIG", "REWRITE"); val != ok {
+		t.Fatalf("Expected CONFIG REWRITE to succeed, got %v", val)
+	}
+
+	rewritten, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Limits\nlimits:\n  proto-max-depth: 4 # Nesting\nappendonly: yes\nsave: 60 100\n"
+	if string(rewritten) != want {
+		t.Errorf("Expected the changed settings to be written, got:\n%s", rewritten)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the permissions of the file to be kept, got %v", info.Mode())
+	}
+}
+
+func TestKeyspaceEventFlagsString(t *testing.T) {
+	for _, flags := range []string{"", "KEA", "Kg$x", "Elshzt"} {
+		parsed, err := ParseKeyspaceEvents(flags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := parsed.String(); got != flags {
+			t.Errorf("ParseKeyspaceEvents(%q).String() = %q", flags, got)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/CDavidSV/GopherStore/internal/encryption"
+)
+
+// Encrypts the append-only file and snapshots with the keys of the provider, which must be safe for
+// concurrent use since background saves call it. New data is written with its current key and files
+// written with its other keys can still be read. When loaded, a plaintext append-only file or one
+// using an older key is rewritten with the current key, while snapshots are on their next save.
+// Must be called before ListenAndServe.
+func (s *Server) SetEncryptionKeys(keys encryption.KeyProvider) {
+	s.persistKeys = keys
+}
+
+// Returns a reader of the plaintext of a persistence file, decrypting it if it is encrypted, and
+// whether it was encrypted. Fails for an encrypted file when no keys are set.
+func (s *Server) persistenceReader(r io.Reader) (io.Reader, bool, error) {
+	br := bufio.NewReader(r)
+	if !encryption.IsEncrypted(br) {
+		return br, false, nil
+	}
+	if s.persistKeys == nil {
+		return nil, true, fmt.Errorf("the file is encrypted but no encryption keys are set")
+	}
+	return encryption.NewReader(br, s.persistKeys), true, nil
+}
+
+// Encrypts everything written to w in a new segment, started on the first write so nothing is
+// written when nothing is encrypted. A failed write leaves the segment unusable, which is fine for
+// the bufio.Writers in front of it since they stop writing after an error.
+type encryptingWriter struct {
+	w    io.Writer
+	keys encryption.KeyProvider
+	enc  *encryption.Writer
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	if e.enc == nil {
+		enc, err := encryption.NewWriter(e.w, e.keys)
+		if err != nil {
+			return 0, err
+		}
+		e.enc = enc
+	}
+	return e.enc.Write(p)
+}
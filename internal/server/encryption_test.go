@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/CDavidSV/GopherStore/internal/encryption"
+)
+
+// Returns a keyring whose keys are added in order, the last one being the current key.
+func newTestKeyring(t *testing.T, ids ...string) *encryption.Keyring {
+	keyring := encryption.NewKeyring()
+	for i, id := range ids {
+		if err := keyring.Add(id, bytes.Repeat([]byte{byte(i + 1)}, 32)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return keyring
+}
+
+// Returns the IDs of the keys the file at path is encrypted with, failing if it is not encrypted.
+func fileKeyIDs(t *testing.T, path string, keys encryption.KeyProvider) []string {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	if !encryption.IsEncrypted(reader) {
+		t.Fatalf("Expected %s to be encrypted", path)
+	}
+	decrypted := encryption.NewReader(reader, keys)
+	if _, err := io.Copy(io.Discard, decrypted); err != nil {
+		t.Fatalf("Failed to decrypt %s: %v", path, err)
+	}
+	return decrypted.KeyIDs()
+}
+
+func TestEncryptedRestore(t *testing.T) {
+	dir := t.TempDir()
+	snapshotPath, aofPath := filepath.Join(dir, "dump.gsdb"), filepath.Join(dir, "appendonly.aof")
+	keys := newTestKeyring(t, "primary")
+
+	newEncryptedServer := func(keys encryption.KeyProvider) *Server {
+		s := newTestServer(t)
+		s.SetSnapshotFile(snapshotPath)
+		s.SetAppendOnly(aofPath, FsyncAlways)
+		if keys != nil {
+			s.SetEncryptionKeys(keys)
+		}
+		return s
+	}
+
+	s := newEncryptedServer(keys)
+	if err := s.restore(); err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient()
+	run := func(args ...string) {
+		cmd, err := parseCommand(commandArray(args...), nil)
+		if err != nil {
+			t.Fatalf("Failed to parse %v: %v", args, err)
+		}
+		s.processMessages([]Message{{cmd: cmd, args: commandArgs(commandArray(args...)), client: client}})
+		drainReplies(client)
+	}
+
+	run("SET", "before", "secret-before")
+	if err := s.saveSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	run("SET", "after", "secret-after")
+	if err := s.aof.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{snapshotPath, aofPath} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("secret")) {
+			t.Errorf("Expected %s to be encrypted, found the values in plaintext", path)
+		}
+	}
+
+	// The snapshot is loaded and the commands written after it are replayed from the encrypted file
+	restored := newEncryptedServer(keys)
+	if err := restored.restore(); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	for key, expected := range map[string]string{"before": "secret-before", "after": "secret-after"} {
+		if value, _ := restored.store.GetValue([]byte(key)); string(value) != expected {
+			t.Errorf("Expected %s to be %q, got %q", key, expected, value)
+		}
+	}
+	if restored.aof.size != s.aof.size {
+		t.Errorf("Expected the append-only file to hold %d bytes of commands, got %d", s.aof.size, restored.aof.size)
+	}
+	if err := restored.aof.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := newEncryptedServer(nil).restore(); err == nil {
+		t.Error("Expected encrypted files to fail loading without keys")
+	}
+	if err := newEncryptedServer(newTestKeyring(t, "other")).restore(); err == nil {
+		t.Error("Expected encrypted files to fail loading without their key")
+	}
+}
+
+func TestEncryptedAppendOnlyFileRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	complete := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"
+	if err := os.WriteFile(path, []byte(complete+"*3\r\n$3\r\nSET\r\n$5\r\nother"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	load := func(keys encryption.KeyProvider) *Server {
+		t.Helper()
+		s := newTestServer(t)
+		s.SetAppendOnly(path, FsyncAlways)
+		s.SetEncryptionKeys(keys)
+		if err := s.loadAppendOnlyFile(0); err != nil {
+			t.Fatalf("Failed to load append-only file: %v", err)
+		}
+		if err := s.aof.close(); err != nil {
+			t.Fatal(err)
+		}
+		if value, _ := s.store.GetValue([]byte("key")); string(value) != "value" {
+			t.Errorf("Expected the complete command to be replayed, got %q", value)
+		}
+		if s.aof.size != int64(len(complete)) {
+			t.Errorf("Expected %d bytes of commands, got %d", len(complete), s.aof.size)
+		}
+		return s
+	}
+
+	// A plaintext file is encrypted once encryption is enabled, without its incomplete command
+	old := newTestKeyring(t, "old")
+	load(old)
+	if ids := fileKeyIDs(t, path, old); !slices.Equal(ids, []string{"old"}) {
+		t.Errorf("Expected the file to be encrypted with the old key, got %v", ids)
+	}
+
+	// After a rotation, the file is rewritten with the new key so the old one can be dropped
+	rotated := newTestKeyring(t, "old", "new")
+	load(rotated)
+	if ids := fileKeyIDs(t, path, newTestKeyring(t, "old", "new")); !slices.Equal(ids, []string{"new"}) {
+		t.Errorf("Expected the file to be rewritten with the new key, got %v", ids)
+	}
+
+	// Part of a frame left by a crash is dropped
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("D\x00\x00"))
+	file.Close()
+	load(rotated)
+	if ids := fileKeyIDs(t, path, rotated); !slices.Equal(ids, []string{"new"}) {
+		t.Errorf("Expected the incomplete frame to be dropped, got %v", ids)
+	}
+}
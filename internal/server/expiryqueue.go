@@ -0,0 +1,79 @@
+package server
+
+import "container/heap"
+
+type expiryItem struct {
+	key       string
+	expiresAt int64
+	index     int // Position in the heap, maintained by the heap methods
+}
+
+// Min-heap of keys ordered by expiration time, so the cleanup loop only visits keys that are due.
+// Not safe for concurrent use.
+type expiryQueue struct {
+	items []*expiryItem
+	byKey map[string]*expiryItem
+}
+
+func newExpiryQueue() *expiryQueue {
+	return &expiryQueue{byKey: make(map[string]*expiryItem)}
+}
+
+// Implements heap.Interface, use the methods below instead.
+func (q *expiryQueue) Len() int           { return len(q.items) }
+func (q *expiryQueue) Less(i, j int) bool { return q.items[i].expiresAt < q.items[j].expiresAt }
+
+func (q *expiryQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+
+func (q *expiryQueue) Push(x any) {
+	item := x.(*expiryItem)
+	item.index = len(q.items)
+	q.items = append(q.items, item)
+}
+
+func (q *expiryQueue) Pop() any {
+	last := len(q.items) - 1
+	item := q.items[last]
+	q.items[last] = nil
+	q.items = q.items[:last]
+	return item
+}
+
+// Schedules the expiration of a key, replacing any previous expiration time.
+func (q *expiryQueue) set(key string, expiresAt int64) {
+	if item, exists := q.byKey[key]; exists {
+		item.expiresAt = expiresAt
+		heap.Fix(q, item.index)
+		return
+	}
+
+	item := &expiryItem{key: key, expiresAt: expiresAt}
+	heap.Push(q, item)
+	q.byKey[key] = item
+}
+
+// Removes the expiration of a key.
+func (q *expiryQueue) remove(key string) {
+	item, exists := q.byKey[key]
+	if !exists {
+		return
+	}
+
+	heap.Remove(q, item.index)
+	delete(q.byKey, key)
+}
+
+// Removes and returns the key with the earliest expiration time if it expired before now.
+func (q *expiryQueue) popExpired(now int64) (string, bool) {
+	if len(q.items) == 0 || q.items[0].expiresAt >= now {
+		return "", false
+	}
+
+	item := heap.Pop(q).(*expiryItem)
+	delete(q.byKey, item.key)
+	return item.key, true
+}
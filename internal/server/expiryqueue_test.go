@@ -0,0 +1,41 @@
+package server
+
+import "testing"
+
+func TestExpiryQueueOrder(t *testing.T) {
+	q := newExpiryQueue()
+	q.set("c", 30)
+	q.set("a", 10)
+	q.set("b", 20)
+	q.set("d", 5)
+
+	// Updating and removing keys keeps the queue ordered
+	q.set("d", 40)
+	q.remove("b")
+
+	if _, ok := q.popExpired(10); ok {
+		t.Error("Expected no key to expire before 10")
+	}
+
+	var popped []string
+	for {
+		key, ok := q.popExpired(35)
+		if !ok {
+			break
+		}
+		popped = append(popped, key)
+	}
+
+	if len(popped) != 2 || popped[0] != "a" || popped[1] != "c" {
+		t.Errorf("Expected [a c], got %v", popped)
+	}
+	if q.Len() != 1 {
+		t.Errorf("Expected 1 remaining key, got %d", q.Len())
+	}
+
+	q.remove("d")
+	q.remove("missing")
+	if q.Len() != 0 || len(q.byKey) != 0 {
+		t.Errorf("Expected empty queue, got %d items", q.Len())
+	}
+}
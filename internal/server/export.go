@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Stores able to export their keys as JSON and import them back, see InMemoryKVStore.ExportJSON.
+type jsonExporter interface {
+	ExportJSON(w io.Writer, pattern []byte) (int, error)
+	ExportJSONKeys(w io.Writer, keys [][]byte) (int, error)
+	ImportJSON(r io.Reader, replace bool) (int, error)
+}
+
+// Handles the EXPORT command from a client, replying with the matching keys in the JSON export
+// format.
+func (s *Server) handleExportCommand(cmd ExportCommand, client *Client) {
+	exporter, ok := s.store.(jsonExporter)
+	if !ok {
+		client.SendMessage(resp.EncodeError("the store does not support exports"))
+		return
+	}
+
+	var buf bytes.Buffer
+	if _, err := exporter.ExportJSON(&buf, cmd.Pattern); err != nil {
+		s.logger.Error("failed to handle EXPORT command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeBulkString(buf.Bytes()))
+}
+
+// Handles the IMPORT command from a client, replying with the number of keys imported. With NX,
+// nothing is imported if one of the keys exists.
+func (s *Server) handleImportCommand(cmd ImportCommand, client *Client) {
+	exporter, ok := s.store.(jsonExporter)
+	if !ok {
+		client.SendMessage(resp.EncodeError("the store does not support imports"))
+		return
+	}
+
+	imported, err := exporter.ImportJSON(bytes.NewReader(cmd.Data), !cmd.NX)
+	if errors.Is(err, errBusyKey) {
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to handle IMPORT command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(fmt.Sprintf("invalid import: %v", err)))
+		return
+	}
+
+	client.SendMessage(resp.EncodeInteger(int64(imported)))
+}
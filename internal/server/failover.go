@@ -0,0 +1,161 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Failover started by FAILOVER, waiting for a replica to catch up with the paused writes.
+// Must only be accessed from the server loop.
+type failover struct {
+	target string // host:port of the replica to promote, empty for the first one to catch up
+	offset int64  // Replication stream the replica must acknowledge before it is promoted
+	force  bool
+	timer  *time.Timer      // nil without a timeout
+	paused []*blockedClient // Clients whose writes wait for the failover to end
+}
+
+// Handles the FAILOVER command from a client. Writes are paused and the replicas are asked to
+// acknowledge the stream; the first one to catch up, or the one given with TO, is told to become a
+// primary and this server becomes its replica. The paused writes then run against a replica and are
+// rejected with READONLY, so clients switch to the new primary. Replies OK once the failover started.
+func (s *Server) handleFailoverCommand(cmd FailoverCommand, client *Client) {
+	if cmd.Abort {
+		if s.failover == nil {
+			client.SendMessage(resp.EncodeError("no failover in progress"))
+			return
+		}
+		s.endFailover()
+		s.logger.Info("failover aborted")
+		client.SendMessage(resp.EncodeSimpleString("OK"))
+		return
+	}
+
+	if err := s.startFailover(cmd); err != nil {
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+	client.SendMessage(resp.EncodeSimpleString("OK"))
+}
+
+// Must be called from the server loop.
+func (s *Server) startFailover(cmd FailoverCommand) error {
+	switch {
+	case s.link != nil:
+		return fmt.Errorf("FAILOVER is not valid when server is a replica")
+	case s.failover != nil:
+		return fmt.Errorf("FAILOVER already in progress")
+	}
+
+	found := false
+	for _, r := range s.replicas {
+		if r.online && r.addr != "" && (cmd.To == "" || r.addr == cmd.To) {
+			found = true
+			break
+		}
+	}
+	if !found && cmd.To != "" {
+		return fmt.Errorf("FAILOVER target %s is not a replica", cmd.To)
+	}
+	if !found {
+		return fmt.Errorf("FAILOVER requires connected replicas")
+	}
+
+	f := &failover{target: cmd.To, offset: s.replOffset, force: cmd.Force}
+	if cmd.Timeout > 0 {
+		f.timer = time.AfterFunc(cmd.Timeout, func() {
+			select {
+			case s.failoverCh <- f:
+			case <-s.quitCh:
+			}
+		})
+	}
+	s.failover = f
+	s.logger.Info("failover started, pausing writes", "target", cmd.To)
+
+	s.replicate([][]byte{[]byte(CmdReplConf), []byte("GETACK"), []byte("*")})
+	s.checkFailover()
+	return nil
+}
+
+// Blocks the client of a write until the failover ends, when the write runs again.
+// Must be called from the server loop.
+func (s *Server) pauseForFailover(msg Message) {
+	bc := &blockedClient{client: msg.client}
+	s.blockClient(bc, 0)
+	msg.client.pending = append(msg.client.pending, msg)
+	s.failover.paused = append(s.failover.paused, bc)
+}
+
+// Promotes a replica once it acknowledged the paused writes.
+// Must be called from the server loop.
+func (s *Server) checkFailover() {
+	f := s.failover
+	if f == nil {
+		return
+	}
+
+	for _, r := range s.replicas {
+		if r.online && r.addr != "" && r.acked >= f.offset && (f.target == "" || r.addr == f.target) {
+			s.completeFailover(r)
+			return
+		}
+	}
+}
+
+// Aborts the failover when its timeout expired, or promotes its target anyway with FORCE.
+// Must be called from the server loop.
+func (s *Server) handleFailoverTimeout(f *failover) {
+	if s.failover != f {
+		return
+	}
+
+	if f.force {
+		for _, r := range s.replicas {
+			if r.online && r.addr == f.target {
+				s.logger.Warn("failover timed out, forcing the promotion", "target", f.target)
+				s.completeFailover(r)
+				return
+			}
+		}
+	}
+
+	s.logger.Warn("failover timed out, aborting", "target", f.target)
+	s.endFailover()
+}
+
+// Makes the replica a primary and this server its replica, then runs the paused writes.
+// Must be called from the server loop.
+func (s *Server) completeFailover(r *replica) {
+	// Sent to the replica alone, which leaves its primary before this server connects to it
+	if err := r.client.send(resp.EncodeBulkStringArray([][]byte{[]byte(CmdReplicaOf), []byte("NO"), []byte("ONE")})); err != nil {
+		s.logger.Error("failed to promote replica, aborting failover", "replica", r.addr, "error", err)
+		s.endFailover()
+		return
+	}
+
+	s.logger.Info("failover completed, replicating the new primary", "primary", r.addr)
+	s.startReplication(r.addr)
+	s.endFailover()
+}
+
+// Forgets the failover and runs the writes it paused.
+// Must be called from the server loop.
+func (s *Server) endFailover() {
+	f := s.failover
+	s.failover = nil
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+
+	for _, bc := range f.paused {
+		if bc.client.blocked != bc {
+			// Disconnected
+			continue
+		}
+		s.unblockClient(bc)
+		s.processMessages(bc.client.takePending())
+	}
+}
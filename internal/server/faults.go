@@ -0,0 +1,101 @@
+package server
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Injects artificial latency, disconnects and error replies so client retry logic can be tested.
+// Rates are probabilities between 0 and 1 applied independently to every command.
+type faultInjector struct {
+	mu             sync.Mutex
+	latency        time.Duration
+	latencyRate    float64
+	disconnectRate float64
+	errorRate      float64
+}
+
+func newFaultInjector() *faultInjector {
+	return &faultInjector{}
+}
+
+// Returns true with the given probability.
+func roll(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+func (f *faultInjector) setLatency(latency time.Duration, rate float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.latency = latency
+	f.latencyRate = rate
+}
+
+func (f *faultInjector) setDisconnectRate(rate float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.disconnectRate = rate
+}
+
+func (f *faultInjector) setErrorRate(rate float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.errorRate = rate
+}
+
+func (f *faultInjector) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.latency = 0
+	f.latencyRate = 0
+	f.disconnectRate = 0
+	f.errorRate = 0
+}
+
+// Returns the latency to inject before executing the next command, or 0 for none.
+func (f *faultInjector) delay() time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.latency > 0 && roll(f.latencyRate) {
+		return f.latency
+	}
+	return 0
+}
+
+func (f *faultInjector) shouldDisconnect() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return roll(f.disconnectRate)
+}
+
+func (f *faultInjector) shouldError() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return roll(f.errorRate)
+}
+
+// Returns the current settings as alternating name/value pairs.
+func (f *faultInjector) settings() [][]byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	formatRate := func(rate float64) []byte {
+		return []byte(strconv.FormatFloat(rate, 'f', -1, 64))
+	}
+
+	return [][]byte{
+		[]byte("latency-ms"), []byte(strconv.FormatInt(f.latency.Milliseconds(), 10)),
+		[]byte("latency-rate"), formatRate(f.latencyRate),
+		[]byte("disconnect-rate"), formatRate(f.disconnectRate),
+		[]byte("error-rate"), formatRate(f.errorRate),
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+func TestDebugCommand(t *testing.T) {
+	_, addr := startTestServer(t)
+	client := dialTestServer(t, addr)
+	if val, _ := client.do("DEBUG", "SLEEP", "0").(resp.RespErrorValue); !strings.Contains(val.Message, "-chaos") {
+		t.Errorf("Expected DEBUG to be disabled by default, got %v", val)
+	}
+
+	_, addr = startTestServer(t, func(s *Server, _ string) { s.EnableFaultInjection() })
+	client = dialTestServer(t, addr)
+	ok := resp.RespSimpleString{Value: "OK"}
+
+	start := time.Now()
+	if val := client.do("DEBUG", "SLEEP", "0.05"); val != ok {
+		t.Errorf("Expected DEBUG SLEEP to reply OK, got %v", val)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected DEBUG SLEEP to block for 50ms, took %v", elapsed)
+	}
+
+	client.do("RPUSH", "list", "a", "bb")
+	client.do("SET", "counter", "42")
+	val, _ := client.do("DEBUG", "OBJECT", "list").(resp.RespSimpleString)
+	if !strings.HasPrefix(val.Value, "type:list encoding:array ") || !strings.Contains(val.Value, " elements:2 ttl:-1") {
+		t.Errorf("Expected the internals of the list, got %q", val.Value)
+	}
+	if val, _ := client.do("DEBUG", "OBJECT", "counter").(resp.RespSimpleString); !strings.Contains(val.Value, "encoding:int") {
+		t.Errorf("Expected an integer string, got %q", val.Value)
+	}
+	if val, _ := client.do("DEBUG", "OBJECT", "missing").(resp.RespErrorValue); val.Message == "" {
+		t.Errorf("Expected an error for a missing key, got %v", val)
+	}
+
+	if val := client.do("DEBUG", "SET-ACTIVE-EXPIRE", "0"); val != ok {
+		t.Errorf("Expected DEBUG SET-ACTIVE-EXPIRE to reply OK, got %v", val)
+	}
+	if val := client.do("DEBUG", "QUICKLIST-PACKED-THRESHOLD", "1kb"); val != ok {
+		t.Errorf("Expected DEBUG QUICKLIST-PACKED-THRESHOLD to reply OK, got %v", val)
+	}
+
+	for _, args := range [][]string{
+		{"DEBUG", "SLEEP", "-1"},
+		{"DEBUG", "SET-ACTIVE-EXPIRE", "yes"},
+		{"DEBUG", "QUICKLIST-PACKED-THRESHOLD", "0"},
+		{"DEBUG", "OBJECT"},
+	} {
+		if _, err := ParseCommand(commandArray(args...)); err == nil {
+			t.Errorf("Expected %v to be rejected", args)
+		}
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	_, addr := startTestServer(t, func(s *Server, _ string) { s.EnableFaultInjection() })
+	client := dialTestServer(t, addr)
+	ok := resp.RespSimpleString{Value: "OK"}
+	pong := resp.RespSimpleString{Value: "PONG"}
+
+	// Latency is injected before every command but DEBUG
+	if val := client.do("DEBUG", "LATENCY", "50", "1"); val != ok {
+		t.Fatalf("Expected DEBUG LATENCY to reply OK, got %v", val)
+	}
+	start := time.Now()
+	if val := client.do("PING"); val != pong {
+		t.Errorf("Expected PING to reply PONG, got %v", val)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected PING to be delayed by 50ms, took %v", elapsed)
+	}
+
+	settings, _ := client.do("DEBUG", "FAULTS").(resp.RespArray)
+	if len(settings.Elements) != 8 || string(settings.Elements[1].(resp.RespBulkString).Value) != "50" {
+		t.Errorf("Expected the latency in the settings, got %v", settings)
+	}
+
+	client.do("DEBUG", "RESET-FAULTS")
+	start = time.Now()
+	client.do("PING")
+	if elapsed := time.Since(start); elapsed >= 50*time.Millisecond {
+		t.Errorf("Expected no latency after RESET-FAULTS, took %v", elapsed)
+	}
+
+	// Errors replace the reply without running the command
+	client.do("DEBUG", "ERROR", "1")
+	if val, _ := client.do("SET", "key", "value").(resp.RespErrorValue); val.Message != "injected fault" {
+		t.Errorf("Expected an injected error, got %v", val)
+	}
+	client.do("DEBUG", "ERROR", "0")
+	if val, _ := client.do("GET", "key").(resp.RespBulkString); val.Value != nil {
+		t.Errorf("Expected the SET failed by the fault to not run, got %q", val.Value)
+	}
+
+	// Disconnects close the connection instead of replying
+	client.do("DEBUG", "DISCONNECT", "1")
+	if _, err := client.conn.Write(resp.EncodeBulkStringArray([][]byte{[]byte("PING")})); err != nil {
+		t.Fatal(err)
+	}
+	client.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if val, err := resp.ReadRESP(client.reader); err == nil {
+		t.Errorf("Expected the connection to be closed, got %v", val)
+	}
+
+	// Faults are shared by every client, DEBUG still runs to clear them
+	other := dialTestServer(t, addr)
+	if val := other.do("DEBUG", "RESET-FAULTS"); val != ok {
+		t.Fatalf("Expected DEBUG RESET-FAULTS to reply OK, got %v", val)
+	}
+	for range 20 {
+		if val := other.do("PING"); val != pong {
+			t.Fatalf("Expected PING to reply PONG after clearing the faults, got %v", val)
+		}
+	}
+}
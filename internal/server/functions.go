@@ -0,0 +1,282 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"plugin"
+	"slices"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Handler of a server-side function called with FCALL. Keys are the key names declared by the
+// caller, the only ones the function can access through ctx. The returned value is sent to the
+// client: nil, []byte, string, int, int64, bool, [][]byte or []any of those.
+type FunctionHandler func(ctx *FunctionContext, keys, args [][]byte) (any, error)
+
+// A server-side function registered by a plugin.
+type Function struct {
+	Name     string
+	ReadOnly bool // The function never writes, so it can be called with FCALL_RO
+	Handler  FunctionHandler
+}
+
+// Calls the function, turning a panic into an error so a faulty plugin cannot crash the server.
+func (fn *Function) call(ctx *FunctionContext, keys, args [][]byte) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("function %s panicked: %v", fn.Name, r)
+		}
+	}()
+
+	return fn.Handler(ctx, keys, args)
+}
+
+// Functions loaded at startup, looked up by name. Not safe for concurrent use, functions are only
+// registered before the server starts.
+type FunctionLibrary struct {
+	functions map[string]*Function
+}
+
+func NewFunctionLibrary() *FunctionLibrary {
+	return &FunctionLibrary{functions: make(map[string]*Function)}
+}
+
+// Registers a function, failing if the name is empty or already taken.
+func (l *FunctionLibrary) Register(name string, readOnly bool, handler FunctionHandler) error {
+	if name == "" {
+		return fmt.Errorf("function name cannot be empty")
+	}
+	if handler == nil {
+		return fmt.Errorf("function %s has no handler", name)
+	}
+	if _, exists := l.functions[name]; exists {
+		return fmt.Errorf("function %s already exists", name)
+	}
+
+	l.functions[name] = &Function{Name: name, ReadOnly: readOnly, Handler: handler}
+	return nil
+}
+
+// Returns the function registered with name.
+func (l *FunctionLibrary) Get(name string) (*Function, bool) {
+	fn, exists := l.functions[name]
+	return fn, exists
+}
+
+// Returns the registered functions sorted by name.
+func (l *FunctionLibrary) List() []*Function {
+	functions := make([]*Function, 0, len(l.functions))
+	for _, fn := range l.functions {
+		functions = append(functions, fn)
+	}
+	slices.SortFunc(functions, func(a, b *Function) int { return cmp.Compare(a.Name, b.Name) })
+
+	return functions
+}
+
+// Opens a Go plugin built with -buildmode=plugin and calls its exported Register function, which must
+// have the signature func(*server.FunctionLibrary) error, to add its functions to the library.
+func (l *FunctionLibrary) LoadPlugin(path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open function plugin %s: %w", path, err)
+	}
+
+	symbol, err := p.Lookup("Register")
+	if err != nil {
+		return fmt.Errorf("function plugin %s does not export Register: %w", path, err)
+	}
+
+	register, ok := symbol.(func(*FunctionLibrary) error)
+	if !ok {
+		return fmt.Errorf("function plugin %s: Register must be a func(*server.FunctionLibrary) error, got %T", path, symbol)
+	}
+
+	if err := register(l); err != nil {
+		return fmt.Errorf("function plugin %s failed to register: %w", path, err)
+	}
+	return nil
+}
+
+// Access to the store given to a function for a single call. Only the keys declared by the caller
+// can be accessed, and read-only functions cannot write.
+type FunctionContext struct {
+	store    KVStore
+	keys     map[string]struct{}
+	readOnly bool
+	written  bool
+}
+
+func newFunctionContext(store KVStore, keys [][]byte, readOnly bool) *FunctionContext {
+	declared := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		declared[string(key)] = struct{}{}
+	}
+
+	return &FunctionContext{store: store, keys: declared, readOnly: readOnly}
+}
+
+// Checks that the function can access key, and write to it if write is set.
+func (ctx *FunctionContext) check(key []byte, write bool) error {
+	if _, declared := ctx.keys[string(key)]; !declared {
+		return fmt.Errorf("key %s was not declared in the keys of the function call", key)
+	}
+	if write {
+		if ctx.readOnly {
+			return fmt.Errorf("write to key %s from a read-only function", key)
+		}
+		ctx.written = true
+	}
+
+	return nil
+}
+
+func (ctx *FunctionContext) Get(key []byte) ([]byte, error) {
+	if err := ctx.check(key, false); err != nil {
+		return nil, err
+	}
+	return ctx.store.GetValue(key)
+}
+
+// Sets a string value, with no expiration if ttl is 0.
+func (ctx *FunctionContext) Set(key, value []byte, ttl time.Duration) error {
+	if err := ctx.check(key, true); err != nil {
+		return err
+	}
+
+	var expiresAt int64 = -1
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl).UnixNano()
+	}
+	ctx.store.Set(key, value, expiresAt)
+	return nil
+}
+
+func (ctx *FunctionContext) Delete(key []byte) (bool, error) {
+	if err := ctx.check(key, true); err != nil {
+		return false, err
+	}
+	return ctx.store.Delete([][]byte{key}) == 1, nil
+}
+
+func (ctx *FunctionContext) Exists(key []byte) (bool, error) {
+	if err := ctx.check(key, false); err != nil {
+		return false, err
+	}
+	return ctx.store.Exists([][]byte{key}) == 1, nil
+}
+
+// Sets the expiration of a key, returning false if it does not exist.
+func (ctx *FunctionContext) Expire(key []byte, ttl time.Duration) (bool, error) {
+	if err := ctx.check(key, true); err != nil {
+		return false, err
+	}
+	return ctx.store.Expire(key, time.Now().Add(ttl).UnixNano()), nil
+}
+
+func (ctx *FunctionContext) Push(key []byte, values [][]byte, pushAtFront bool) (int, error) {
+	if err := ctx.check(key, true); err != nil {
+		return 0, err
+	}
+	return ctx.store.Push(key, values, pushAtFront)
+}
+
+func (ctx *FunctionContext) Pop(key []byte, popAtFront bool) ([]byte, error) {
+	if err := ctx.check(key, true); err != nil {
+		return nil, err
+	}
+	return ctx.store.Pop(key, popAtFront)
+}
+
+func (ctx *FunctionContext) List(key []byte) ([][]byte, error) {
+	if err := ctx.check(key, false); err != nil {
+		return nil, err
+	}
+	return ctx.store.GetList(key)
+}
+
+func (ctx *FunctionContext) HashGet(key, field []byte) ([]byte, error) {
+	if err := ctx.check(key, false); err != nil {
+		return nil, err
+	}
+	return ctx.store.HashGet(key, field)
+}
+
+func (ctx *FunctionContext) HashSet(key []byte, pairs [][]byte) (int64, error) {
+	if err := ctx.check(key, true); err != nil {
+		return 0, err
+	}
+	return ctx.store.HashSet(key, pairs)
+}
+
+func (ctx *FunctionContext) HashGetAll(key []byte) ([][]byte, error) {
+	if err := ctx.check(key, false); err != nil {
+		return nil, err
+	}
+	return ctx.store.HashGetAll(key)
+}
+
+func (ctx *FunctionContext) SetAdd(key []byte, members [][]byte) (int64, error) {
+	if err := ctx.check(key, true); err != nil {
+		return 0, err
+	}
+	return ctx.store.SetAdd(key, members)
+}
+
+func (ctx *FunctionContext) SetMembers(key []byte) ([][]byte, error) {
+	if err := ctx.check(key, false); err != nil {
+		return nil, err
+	}
+	return ctx.store.SetMembers(key)
+}
+
+func (ctx *FunctionContext) SortedSetAdd(key []byte, items []ScoredMember) (int64, error) {
+	if err := ctx.check(key, true); err != nil {
+		return 0, err
+	}
+	return ctx.store.SortedSetAdd(key, items, ZAddOptions{})
+}
+
+func (ctx *FunctionContext) SortedSetRange(key []byte, start, stop int, rev bool) ([]ScoredMember, error) {
+	if err := ctx.check(key, false); err != nil {
+		return nil, err
+	}
+	return ctx.store.SortedSetRange(key, start, stop, rev)
+}
+
+// Encodes the value returned by a function as a RESP reply.
+func encodeFunctionReply(value any) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return resp.EncodeBulkString(nil), nil
+	case []byte:
+		return resp.EncodeBulkString(v), nil
+	case string:
+		return resp.EncodeBulkString([]byte(v)), nil
+	case int:
+		return resp.EncodeInteger(int64(v)), nil
+	case int64:
+		return resp.EncodeInteger(v), nil
+	case bool:
+		if v {
+			return resp.EncodeInteger(1), nil
+		}
+		return resp.EncodeInteger(0), nil
+	case [][]byte:
+		return resp.EncodeBulkStringArray(v), nil
+	case []any:
+		elements := make([][]byte, len(v))
+		for i, elem := range v {
+			encoded, err := encodeFunctionReply(elem)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = encoded
+		}
+		return resp.EncodeArray(elements), nil
+	default:
+		return nil, fmt.Errorf("function returned an unsupported reply type %T", value)
+	}
+}
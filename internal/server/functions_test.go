@@ -0,0 +1,100 @@
+package server
+
+import "testing"
+
+func TestFunctionLibraryRegister(t *testing.T) {
+	lib := NewFunctionLibrary()
+	handler := func(ctx *FunctionContext, keys, args [][]byte) (any, error) { return nil, nil }
+
+	if err := lib.Register("b", false, handler); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := lib.Register("a", true, handler); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := lib.Register("a", false, handler); err == nil {
+		t.Error("Expected an error registering a duplicate name")
+	}
+	if err := lib.Register("", false, handler); err == nil {
+		t.Error("Expected an error registering an empty name")
+	}
+	if err := lib.Register("c", false, nil); err == nil {
+		t.Error("Expected an error registering a nil handler")
+	}
+
+	functions := lib.List()
+	if len(functions) != 2 || functions[0].Name != "a" || functions[1].Name != "b" {
+		t.Errorf("Expected functions a and b sorted by name, got %v", functions)
+	}
+	if !functions[0].ReadOnly || functions[1].ReadOnly {
+		t.Error("Expected only a to be read-only")
+	}
+}
+
+func TestFunctionContextAccess(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.Set([]byte("declared"), []byte("value"), -1)
+	store.Set([]byte("other"), []byte("value"), -1)
+
+	ctx := newFunctionContext(store, [][]byte{[]byte("declared")}, false)
+	if value, err := ctx.Get([]byte("declared")); err != nil || string(value) != "value" {
+		t.Errorf("Expected value, got %q (%v)", value, err)
+	}
+	if _, err := ctx.Get([]byte("other")); err == nil {
+		t.Error("Expected an error reading an undeclared key")
+	}
+	if err := ctx.Set([]byte("declared"), []byte("new"), 0); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !ctx.written {
+		t.Error("Expected the write to be recorded")
+	}
+
+	readOnly := newFunctionContext(store, [][]byte{[]byte("declared")}, true)
+	if _, err := readOnly.Push([]byte("declared"), [][]byte{[]byte("x")}, false); err == nil {
+		t.Error("Expected an error writing from a read-only function")
+	}
+	if value, _ := readOnly.Get([]byte("declared")); string(value) != "new" {
+		t.Errorf("Expected new, got %q", value)
+	}
+}
+
+func TestFunctionCallRecoversPanic(t *testing.T) {
+	fn := &Function{Name: "boom", Handler: func(ctx *FunctionContext, keys, args [][]byte) (any, error) {
+		return keys[0], nil
+	}}
+
+	if _, err := fn.call(newFunctionContext(nil, nil, false), nil, nil); err == nil {
+		t.Error("Expected the panic to be returned as an error")
+	}
+}
+
+func TestEncodeFunctionReply(t *testing.T) {
+	tests := []struct {
+		value    any
+		expected string
+	}{
+		{nil, "$-1\r\n"},
+		{[]byte("a"), "$1\r\na\r\n"},
+		{"ok", "$2\r\nok\r\n"},
+		{3, ":3\r\n"},
+		{int64(-1), ":-1\r\n"},
+		{true, ":1\r\n"},
+		{[][]byte{[]byte("a")}, "*1\r\n$1\r\na\r\n"},
+		{[]any{1, []any{"x", nil}}, "*2\r\n:1\r\n*2\r\n$1\r\nx\r\n$-1\r\n"},
+	}
+
+	for _, tt := range tests {
+		reply, err := encodeFunctionReply(tt.value)
+		if err != nil || string(reply) != tt.expected {
+			t.Errorf("encodeFunctionReply(%v) = %q (%v), expected %q", tt.value, reply, err, tt.expected)
+		}
+	}
+
+	if _, err := encodeFunctionReply(1.5); err == nil {
+		t.Error("Expected an error for an unsupported type")
+	}
+}
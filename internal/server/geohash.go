@@ -0,0 +1,191 @@
+package server
+
+import "math"
+
+// Locations are stored as sorted set scores holding a 52-bit geohash, interleaving 26 bits of latitude
+// with 26 bits of longitude like Redis. Members close to each other have close scores, so the cells of
+// a geohash grid map to score ranges.
+const (
+	geoStepMax = 26 // Bits of each coordinate in a stored geohash
+
+	geoLatMin = -85.05112878 // Limits of the Web Mercator projection
+	geoLatMax = 85.05112878
+	geoLonMin = -180.0
+	geoLonMax = 180.0
+
+	earthRadius = 6372797.560856 // Meters, the value used by Redis
+	mercatorMax = 20037726.37    // Half the circumference of the earth at the equator in meters
+)
+
+// A location as a longitude and latitude in degrees.
+type GeoPoint struct {
+	Lon, Lat float64
+}
+
+// Reports whether the point can be encoded as a geohash.
+func (p GeoPoint) valid() bool {
+	return p.Lon >= geoLonMin && p.Lon <= geoLonMax && p.Lat >= geoLatMin && p.Lat <= geoLatMax
+}
+
+// Returns the sorted set score storing the point.
+func (p GeoPoint) score() float64 {
+	lat, lon := geohashCell(p, geoStepMax)
+	return float64(interleave(lat, lon))
+}
+
+// Returns the indexes of the latitude and longitude intervals containing the point in a grid
+// with 2^step intervals per coordinate.
+func geohashCell(p GeoPoint, step uint) (uint32, uint32) {
+	cells := float64(uint64(1) << step)
+	lat := (p.Lat - geoLatMin) / (geoLatMax - geoLatMin) * cells
+	lon := (p.Lon - geoLonMin) / (geoLonMax - geoLonMin) * cells
+
+	// Points on the upper limits belong to the last interval
+	maxIndex := uint32(cells - 1)
+	return min(uint32(lat), maxIndex), min(uint32(lon), maxIndex)
+}
+
+// Decodes a score into the center of its geohash cell.
+func geohashDecode(score float64) GeoPoint {
+	bits := uint64(score)
+	lat, lon := squash(bits), squash(bits>>1)
+
+	cells := float64(uint64(1) << geoStepMax)
+	latStep := (geoLatMax - geoLatMin) / cells
+	lonStep := (geoLonMax - geoLonMin) / cells
+
+	return GeoPoint{
+		Lon: min(max(geoLonMin+(float64(lon)+0.5)*lonStep, geoLonMin), geoLonMax),
+		Lat: min(max(geoLatMin+(float64(lat)+0.5)*latStep, geoLatMin), geoLatMax),
+	}
+}
+
+// Interleaves the bits of the latitude and longitude indexes, latitude bits in the even positions.
+func interleave(lat, lon uint32) uint64 {
+	return spread(lat) | spread(lon)<<1
+}
+
+// Spreads the bits of v into the even positions of the result.
+func spread(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000ffff0000ffff
+	x = (x | x<<8) & 0x00ff00ff00ff00ff
+	x = (x | x<<4) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+// Collects the bits in the even positions of x, the inverse of spread.
+func squash(x uint64) uint32 {
+	x &= 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x>>4) & 0x00ff00ff00ff00ff
+	x = (x | x>>8) & 0x0000ffff0000ffff
+	x = (x | x>>16) & 0x00000000ffffffff
+	return uint32(x)
+}
+
+func degToRad(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func radToDeg(r float64) float64 {
+	return r * 180 / math.Pi
+}
+
+// Returns the great circle distance between two points in meters using the haversine formula.
+func geoDistance(a, b GeoPoint) float64 {
+	lat1, lat2 := degToRad(a.Lat), degToRad(b.Lat)
+	u := math.Sin((lat2 - lat1) / 2)
+	v := math.Sin(degToRad(b.Lon-a.Lon) / 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1)*math.Cos(lat2)*v*v))
+}
+
+// Returns the geohash precision whose cells are about as large as the radius, smaller near the poles
+// where cells are narrower.
+func geoEstimateStep(radius, lat float64) uint {
+	if radius == 0 {
+		return geoStepMax
+	}
+
+	step := 1
+	for radius < mercatorMax && step < geoStepMax+2 {
+		radius *= 2
+		step++
+	}
+	step -= 2
+
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+
+	return uint(min(max(step, 1), geoStepMax))
+}
+
+// Returns the score ranges of the geohash cells covering the area within halfWidth and halfHeight meters
+// of the center: the cell containing the center and its eight neighbors, at a precision where they
+// cover the whole area.
+func geoSearchRanges(center GeoPoint, halfWidth, halfHeight float64) []ScoreRange {
+	latDelta := radToDeg(halfHeight / earthRadius)
+	minLat, maxLat := center.Lat-latDelta, center.Lat+latDelta
+
+	// Degrees of longitude are shortest at the latitude farthest from the equator
+	widest := min(max(math.Abs(minLat), math.Abs(maxLat)), 89.999)
+	lonDelta := radToDeg(halfWidth / earthRadius / math.Cos(degToRad(widest)))
+
+	step := geoEstimateStep(math.Hypot(halfWidth, halfHeight), center.Lat)
+	var lat, lon uint32
+	for ; ; step-- {
+		lat, lon = geohashCell(center, step)
+		if step == 1 {
+			// Two cells per coordinate, the neighbors cover the whole world
+			break
+		}
+
+		cells := float64(uint64(1) << step)
+		latSize := (geoLatMax - geoLatMin) / cells
+		lonSize := (geoLonMax - geoLonMin) / cells
+		cellLat := geoLatMin + float64(lat)*latSize
+		cellLon := geoLonMin + float64(lon)*lonSize
+
+		if minLat >= cellLat-latSize && maxLat <= cellLat+2*latSize &&
+			center.Lon-lonDelta >= cellLon-lonSize && center.Lon+lonDelta <= cellLon+2*lonSize {
+			break
+		}
+	}
+
+	cells := int64(1) << step
+	shift := 2 * (geoStepMax - step)
+	seen := make(map[uint64]bool, 9)
+	ranges := make([]ScoreRange, 0, 9)
+	for dLat := int64(-1); dLat <= 1; dLat++ {
+		cellLat := int64(lat) + dLat
+		if cellLat < 0 || cellLat >= cells {
+			continue
+		}
+
+		for dLon := int64(-1); dLon <= 1; dLon++ {
+			// Longitude wraps around the antimeridian
+			cellLon := (int64(lon) + dLon + cells) % cells
+
+			hash := interleave(uint32(cellLat), uint32(cellLon))
+			if seen[hash] {
+				continue
+			}
+			seen[hash] = true
+
+			ranges = append(ranges, ScoreRange{
+				Min:          float64(hash << shift),
+				Max:          float64((hash + 1) << shift),
+				MaxExclusive: true,
+			})
+		}
+	}
+
+	return ranges
+}
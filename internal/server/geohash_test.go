@@ -0,0 +1,85 @@
+package server
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGeohashScore(t *testing.T) {
+	// Scores computed by Redis for the same locations
+	tests := []struct {
+		name  string
+		point GeoPoint
+		want  float64
+	}{
+		{"Palermo", GeoPoint{13.361389, 38.115556}, 3479099956230698},
+		{"Catania", GeoPoint{15.087269, 37.502669}, 3479447370796909},
+	}
+
+	for _, tt := range tests {
+		if got := tt.point.score(); got != tt.want {
+			t.Errorf("%s: expected score %.0f, got %.0f", tt.name, tt.want, got)
+		}
+
+		// Decoding returns the center of a cell smaller than a meter
+		if d := geoDistance(tt.point, geohashDecode(tt.want)); d > 1 {
+			t.Errorf("%s: decoded point is %f meters away", tt.name, d)
+		}
+	}
+}
+
+func TestGeoDistance(t *testing.T) {
+	palermo := GeoPoint{13.361389, 38.115556}
+	catania := GeoPoint{15.087269, 37.502669}
+
+	if d := geoDistance(palermo, catania); math.Abs(d-166274.15) > 1 {
+		t.Errorf("Expected about 166274 meters, got %f", d)
+	}
+	if d := geoDistance(palermo, palermo); d != 0 {
+		t.Errorf("Expected 0 meters, got %f", d)
+	}
+}
+
+func TestGeoSearchRangesCoverArea(t *testing.T) {
+	centers := []GeoPoint{{0, 0}, {179.9, 10}, {-179.9, -10}, {13.4, 38.1}, {20, 84}}
+	radii := []float64{1, 500, 50_000, 2_000_000}
+
+	for _, center := range centers {
+		for _, radius := range radii {
+			ranges := geoSearchRanges(center, radius, radius)
+
+			// Points at the edge of the area in every direction must fall in one of the ranges
+			for _, bearing := range []float64{0, 45, 90, 135, 180, 225, 270, 315} {
+				p := geoDestination(center, bearing, radius*0.999)
+				if !p.valid() {
+					continue
+				}
+
+				score := p.score()
+				covered := false
+				for _, r := range ranges {
+					if score >= r.Min && score < r.Max {
+						covered = true
+						break
+					}
+				}
+				if !covered {
+					t.Errorf("Point %v at %.0f meters from %v is not covered", p, radius, center)
+				}
+			}
+		}
+	}
+}
+
+// Returns the point reached by traveling dist meters from p along the given bearing in degrees.
+func geoDestination(p GeoPoint, bearing, dist float64) GeoPoint {
+	lat1, lon1 := degToRad(p.Lat), degToRad(p.Lon)
+	b := degToRad(bearing)
+	d := dist / earthRadius
+
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(d) + math.Cos(lat1)*math.Sin(d)*math.Cos(b))
+	lon2 := lon1 + math.Atan2(math.Sin(b)*math.Sin(d)*math.Cos(lat1), math.Cos(d)-math.Sin(lat1)*math.Sin(lat2))
+
+	lon := math.Mod(radToDeg(lon2)+540, 360) - 180
+	return GeoPoint{Lon: lon, Lat: radToDeg(lat2)}
+}
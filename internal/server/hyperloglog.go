@@ -0,0 +1,276 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLogs are stored as string values using the Redis dense layout: a 16 byte header
+// ("HYLL", the encoding, 3 unused bytes and the cached cardinality) followed by 16384 6-bit registers.
+const (
+	hllP          = 14 // Bits of the hash used to select the register
+	hllQ          = 64 - hllP
+	hllRegisters  = 1 << hllP
+	hllBits       = 6
+	hllMaxValue   = 1<<hllBits - 1
+	hllHeaderSize = 16
+	hllDenseSize  = hllHeaderSize + (hllRegisters*hllBits+7)/8
+
+	hllDense  = 0
+	hllSparse = 1
+
+	// Set in the last byte of the cached cardinality when it must be recomputed
+	hllCacheInvalid = 1 << 7
+
+	hllAlphaInf = 0.721347520444481703680 // 1 / (2 * ln(2))
+	hllHashSeed = 0xadc83b19
+)
+
+var errInvalidHyperLogLog = fmt.Errorf("WRONGTYPE Key is not a valid HyperLogLog string value")
+
+// Dense HyperLogLog backed by the string value stored in the keyspace.
+type hyperLogLog []byte
+
+// Registers of a HyperLogLog unpacked to one byte each, used to merge and count.
+type hllRegisterSet [hllRegisters]uint8
+
+func newHyperLogLog() hyperLogLog {
+	h := make(hyperLogLog, hllDenseSize)
+	copy(h, "HYLL")
+	h[4] = hllDense
+	return h
+}
+
+// Validates a string value holding a HyperLogLog. Sparse values are converted to a new dense HyperLogLog.
+func parseHyperLogLog(value []byte) (hyperLogLog, error) {
+	if len(value) < hllHeaderSize || string(value[:4]) != "HYLL" {
+		return nil, errInvalidHyperLogLog
+	}
+
+	switch value[4] {
+	case hllDense:
+		if len(value) != hllDenseSize {
+			return nil, errInvalidHyperLogLog
+		}
+		return hyperLogLog(value), nil
+	case hllSparse:
+		return parseSparseHyperLogLog(value)
+	default:
+		return nil, errInvalidHyperLogLog
+	}
+}
+
+// Decodes the run length encoded registers of a sparse HyperLogLog into a dense one.
+func parseSparseHyperLogLog(value []byte) (hyperLogLog, error) {
+	h := newHyperLogLog()
+	copy(h[8:hllHeaderSize], value[8:hllHeaderSize])
+
+	index := 0
+	for p := hllHeaderSize; p < len(value); {
+		op := value[p]
+		switch {
+		case op&0xc0 == 0x00: // ZERO: 00xxxxxx, run of 1 to 64 empty registers
+			index += int(op&0x3f) + 1
+			p++
+		case op&0xc0 == 0x40: // XZERO: 01xxxxxx yyyyyyyy, run of 1 to 16384 empty registers
+			if p+1 >= len(value) {
+				return nil, errInvalidHyperLogLog
+			}
+			index += (int(op&0x3f)<<8 | int(value[p+1])) + 1
+			p += 2
+		default: // VAL: 1vvvvvxx, run of 1 to 4 registers set to 1 to 32
+			val := (op>>2)&0x1f + 1
+			run := int(op&0x03) + 1
+			if index+run > hllRegisters {
+				return nil, errInvalidHyperLogLog
+			}
+			for range run {
+				h.setRegister(index, val)
+				index++
+			}
+			p++
+		}
+	}
+
+	if index != hllRegisters {
+		return nil, errInvalidHyperLogLog
+	}
+	return h, nil
+}
+
+func (h hyperLogLog) register(i int) uint8 {
+	data := h[hllHeaderSize:]
+	pos := i * hllBits
+	b, shift := pos/8, pos%8
+
+	v := data[b] >> shift
+	if b+1 < len(data) {
+		v |= data[b+1] << (8 - shift)
+	}
+	return v & hllMaxValue
+}
+
+func (h hyperLogLog) setRegister(i int, v uint8) {
+	data := h[hllHeaderSize:]
+	pos := i * hllBits
+	b, shift := pos/8, pos%8
+
+	data[b] &^= hllMaxValue << shift
+	data[b] |= v << shift
+	if b+1 < len(data) {
+		data[b+1] &^= hllMaxValue >> (8 - shift)
+		data[b+1] |= v >> (8 - shift)
+	}
+}
+
+func (h hyperLogLog) invalidateCache() {
+	h[15] |= hllCacheInvalid
+}
+
+// Adds an element, returning true if a register changed.
+func (h hyperLogLog) add(element []byte) bool {
+	index, count := hllPatternLen(element)
+	if count <= h.register(index) {
+		return false
+	}
+
+	h.setRegister(index, count)
+	h.invalidateCache()
+	return true
+}
+
+// Raises the registers of regs to the registers of the HyperLogLog.
+func (h hyperLogLog) mergeInto(regs *hllRegisterSet) {
+	for i := range regs {
+		regs[i] = max(regs[i], h.register(i))
+	}
+}
+
+// Overwrites the registers of the HyperLogLog.
+func (h hyperLogLog) setRegisters(regs *hllRegisterSet) {
+	for i, v := range regs {
+		h.setRegister(i, v)
+	}
+	h.invalidateCache()
+}
+
+// Returns the cardinality cached in the header, or false if it must be recomputed.
+func (h hyperLogLog) cachedCount() (uint64, bool) {
+	if h[15]&hllCacheInvalid != 0 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(h[8:hllHeaderSize]), true
+}
+
+// Returns the estimated cardinality, using and updating the cached value in the header.
+func (h hyperLogLog) count() uint64 {
+	if card, ok := h.cachedCount(); ok {
+		return card
+	}
+
+	var regs hllRegisterSet
+	h.mergeInto(&regs)
+	card := regs.estimate()
+	binary.LittleEndian.PutUint64(h[8:hllHeaderSize], card)
+	return card
+}
+
+// Estimates the cardinality from the registers using the improved estimator by Otmar Ertl,
+// which needs no bias correction for small or large cardinalities.
+func (regs *hllRegisterSet) estimate() uint64 {
+	var histogram [64]int
+	for _, v := range regs {
+		histogram[v]++
+	}
+
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if prev == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if prev == z {
+			return z / 3
+		}
+	}
+}
+
+// Returns the register selected by the element and the length of the run of zeros after
+// the register bits plus one, which is the value the register is raised to.
+func hllPatternLen(element []byte) (int, uint8) {
+	hash := murmurHash64A(element, hllHashSeed)
+	index := int(hash & (hllRegisters - 1))
+
+	// The bit at hllQ bounds the count when the remaining bits are all zero
+	hash >>= hllP
+	hash |= 1 << hllQ
+	return index, uint8(bits.TrailingZeros64(hash) + 1)
+}
+
+// MurmurHash64A by Austin Appleby, reading blocks as little endian.
+func murmurHash64A(data []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+
+	h := seed ^ uint64(len(data))*m
+
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+
+		h ^= k
+		h *= m
+		data = data[8:]
+	}
+
+	if len(data) > 0 {
+		for i := len(data) - 1; i >= 0; i-- {
+			h ^= uint64(data[i]) << (8 * i)
+		}
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestHyperLogLogRegisters(t *testing.T) {
+	h := newHyperLogLog()
+
+	// Registers are packed across byte boundaries, so neighbours must not be affected
+	for i := range hllRegisters {
+		h.setRegister(i, uint8(i%(hllMaxValue+1)))
+	}
+	for i := range hllRegisters {
+		if got := h.register(i); got != uint8(i%(hllMaxValue+1)) {
+			t.Fatalf("register(%d) = %d, want %d", i, got, i%(hllMaxValue+1))
+		}
+	}
+
+	h.setRegister(hllRegisters-1, 0)
+	if h.register(hllRegisters-2) != uint8((hllRegisters-2)%(hllMaxValue+1)) || h.register(hllRegisters-1) != 0 {
+		t.Error("Expected the last register to be set independently")
+	}
+}
+
+func TestHyperLogLogAccuracy(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 1000, 100000} {
+		h := newHyperLogLog()
+		h.invalidateCache()
+		for i := range n {
+			h.add(fmt.Appendf(nil, "element:%d", i))
+		}
+
+		// The standard error with 16384 registers is 0.81%
+		got := h.count()
+		if diff := math.Abs(float64(got) - float64(n)); diff > math.Max(1, float64(n)*0.03) {
+			t.Errorf("count() = %d for %d unique elements", got, n)
+		}
+	}
+}
+
+func TestHyperLogLogCountCache(t *testing.T) {
+	h := newHyperLogLog()
+	h.add([]byte("a"))
+	if h[15]&hllCacheInvalid == 0 {
+		t.Fatal("Expected adding a new element to invalidate the cache")
+	}
+
+	count := h.count()
+	if h[15]&hllCacheInvalid != 0 {
+		t.Error("Expected count to store the cardinality in the header")
+	}
+
+	// Adding an existing element keeps the cached value
+	if h.add([]byte("a")) || h.count() != count {
+		t.Error("Expected duplicate element not to change the estimate")
+	}
+}
+
+func TestParseSparseHyperLogLog(t *testing.T) {
+	sparse := []byte("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80")
+	sparse = append(sparse,
+		0x40|0x00, 0x09, // XZERO: 10 empty registers
+		0x80|2<<2|1, // VAL: 2 registers set to 3
+		0x05,        // ZERO: 6 empty registers
+		0x7f, 0xed,  // XZERO: the remaining 16366 registers
+	)
+
+	h, err := parseHyperLogLog(sparse)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(h) != hllDenseSize || h[4] != hllDense {
+		t.Fatal("Expected sparse value to be converted to dense")
+	}
+
+	for i := range hllRegisters {
+		want := uint8(0)
+		if i == 10 || i == 11 {
+			want = 3
+		}
+		if got := h.register(i); got != want {
+			t.Fatalf("register(%d) = %d, want %d", i, got, want)
+		}
+	}
+
+	// Runs must cover exactly every register
+	if _, err := parseHyperLogLog(sparse[:len(sparse)-2]); err == nil {
+		t.Error("Expected error for sparse value not covering every register")
+	}
+}
+
+func TestParseHyperLogLogInvalid(t *testing.T) {
+	for _, value := range []string{"", "HYLL", "hello world, not an hll", "HYLL\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"} {
+		if _, err := parseHyperLogLog([]byte(value)); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
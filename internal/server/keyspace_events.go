@@ -0,0 +1,338 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/CDavidSV/GopherStore/internal/util"
+)
+
+// Classes of keyspace events and the kinds of notification to publish for them, using the flags of
+// Redis' notify-keyspace-events setting.
+type KeyspaceEventFlags uint16
+
+const (
+	NotifyKeyspace KeyspaceEventFlags = 1 << iota // K: publish to __keyspace@0__:<key> with the event as message
+	NotifyKeyevent                                // E: publish to __keyevent@0__:<event> with the key as message
+	EventGeneric                                  // g: del, expire and other commands not specific to a type
+	EventString                                   // $: string commands
+	EventList                                     // l: list commands
+	EventSet                                      // s: set commands
+	EventHash                                     // h: hash commands
+	EventSortedSet                                // z: sorted set commands
+	EventExpired                                  // x: keys removed because they expired
+	EventStream                                   // t: stream commands
+	EventEvicted                                  // e: keys evicted to stay under the maxmemory limit
+
+	// A: every event class
+	EventAll = EventGeneric | EventString | EventList | EventSet | EventHash | EventSortedSet | EventExpired | EventStream | EventEvicted
+)
+
+// Parses a notify-keyspace-events string such as "KEA" or "Ex". Nothing is published unless the
+// flags include K or E and at least one event class.
+func ParseKeyspaceEvents(flags string) (KeyspaceEventFlags, error) {
+	var parsed KeyspaceEventFlags
+	for _, flag := range flags {
+		switch flag {
+		case 'K':
+			parsed |= NotifyKeyspace
+		case 'E':
+			parsed |= NotifyKeyevent
+		case 'g':
+			parsed |= EventGeneric
+		case '$':
+			parsed |= EventString
+		case 'l':
+			parsed |= EventList
+		case 's':
+			parsed |= EventSet
+		case 'h':
+			parsed |= EventHash
+		case 'z':
+			parsed |= EventSortedSet
+		case 'x':
+			parsed |= EventExpired
+		case 't':
+			parsed |= EventStream
+		case 'e':
+			parsed |= EventEvicted
+		case 'A':
+			parsed |= EventAll
+		default:
+			return 0, fmt.Errorf("invalid keyspace event flag %q, expected one of KEg$lshzxetA", flag)
+		}
+	}
+
+	return parsed, nil
+}
+
+// Formats the flags using the letters accepted by ParseKeyspaceEvents, with A for every event class.
+func (f KeyspaceEventFlags) String() string {
+	var b strings.Builder
+	for _, flag := range []struct {
+		flag   KeyspaceEventFlags
+		letter byte
+	}{{NotifyKeyspace, 'K'}, {NotifyKeyevent, 'E'}} {
+		if f&flag.flag != 0 {
+			b.WriteByte(flag.letter)
+		}
+	}
+
+	if f&EventAll == EventAll {
+		b.WriteByte('A')
+		return b.String()
+	}
+	for _, class := range []struct {
+		flag   KeyspaceEventFlags
+		letter byte
+	}{{EventGeneric, 'g'}, {EventString, '$'}, {EventList, 'l'}, {EventSet, 's'}, {EventHash, 'h'}, {EventSortedSet, 'z'}, {EventExpired, 'x'}, {EventEvicted, 'e'}, {EventStream, 't'}} {
+		if f&class.flag != 0 {
+			b.WriteByte(class.letter)
+		}
+	}
+	return b.String()
+}
+
+// Reports whether any notification would be published with these flags.
+func (f KeyspaceEventFlags) enabled() bool {
+	return f&(NotifyKeyspace|NotifyKeyevent) != 0 && f&EventAll != 0
+}
+
+// A change to a key, named after the Redis command that makes it, e.g. "set", "lpush" or "expired".
+type KeyspaceEvent struct {
+	Class KeyspaceEventFlags // The single event class of the event
+	Name  string
+	Key   []byte
+}
+
+// Counts a change made to a key and queues an event for the keyspace event hooks. Expired events
+// are also passed to the expiration hooks.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) notify(class KeyspaceEventFlags, name string, key []byte) {
+	if class != EventExpired && class != EventEvicted && name != "hexpired" {
+		kv.dirty.Add(1)
+	}
+	kv.markChanged(key)
+	kv.queueWriteBehind(class, name, key)
+
+	if len(kv.eventHooks) == 0 && len(kv.subscriptions) == 0 && (class != EventExpired || len(kv.expiredHooks) == 0) {
+		return
+	}
+
+	kv.queueMu.Lock()
+	kv.eventQueue = append(kv.eventQueue, KeyspaceEvent{Class: class, Name: name, Key: bytes.Clone(key)})
+	kv.queueMu.Unlock()
+	select {
+	case kv.eventSignal <- struct{}{}:
+	default:
+		// The hooks goroutine is already signaled and will pick up the event
+	}
+}
+
+// Counts a change made to a key that sends no keyspace event, like a consumer group reading
+// or acknowledging entries, so the command is still propagated.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) changed(key []byte) {
+	kv.dirty.Add(1)
+	kv.markChanged(key)
+}
+
+// Registers a hook called with every change made to a key, including expirations. Hooks run in order
+// on a background goroutine, in the order the changes were made, and can safely call the store.
+func (kv *InMemoryKVStore) OnKeyspaceEvent(hook func(event KeyspaceEvent)) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.eventHooks = append(kv.eventHooks, hook)
+}
+
+// Passes queued events to the keyspace event and expiration hooks and to the subscriptions until the
+// store is closed, then closes the subscriptions.
+func (kv *InMemoryKVStore) runEventHooks() {
+	for {
+		select {
+		case <-kv.eventSignal:
+			kv.mu.RLock()
+			eventHooks := kv.eventHooks
+			expiredHooks := kv.expiredHooks
+			subscriptions := slices.Clone(kv.subscriptions)
+			kv.queueMu.Lock()
+			events := kv.eventQueue
+			kv.eventQueue = nil
+			kv.queueMu.Unlock()
+			kv.mu.RUnlock()
+
+			// The hooks are called without the lock so they can use the store
+			for _, event := range events {
+				if event.Class == EventExpired {
+					for _, hook := range expiredHooks {
+						hook(event.Key)
+					}
+				}
+				for _, hook := range eventHooks {
+					hook(event)
+				}
+				for _, sub := range subscriptions {
+					if util.MatchPattern(sub.pattern, event.Key) {
+						sub.send(event)
+					}
+				}
+			}
+		case <-kv.closeCh:
+			kv.mu.Lock()
+			subscriptions := kv.subscriptions
+			kv.subscriptions = nil
+			kv.mu.Unlock()
+
+			for _, sub := range subscriptions {
+				sub.close()
+			}
+			return
+		}
+	}
+}
+
+// Number of events buffered for a subscription before new events are dropped.
+const subscriptionBufferSize = 1024
+
+// Subscription to the changes of the keys matching a pattern, created with Subscribe.
+type keyspaceSubscription struct {
+	pattern []byte
+	events  chan KeyspaceEvent
+	mu      sync.Mutex // Prevents sending on the channel once it is closed
+	closed  bool
+}
+
+// Sends an event without blocking, dropping it if the subscriber is not keeping up.
+func (sub *keyspaceSubscription) send(event KeyspaceEvent) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.closed {
+		return
+	}
+	select {
+	case sub.events <- event:
+	default:
+	}
+}
+
+func (sub *keyspaceSubscription) close() {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if !sub.closed {
+		sub.closed = true
+		close(sub.events)
+	}
+}
+
+// Subscribes to the changes made to the keys matching a glob-style pattern, like PSUBSCRIBE on the
+// __keyspace@0__ channels but without a connection, for programs embedding the store. Every event
+// class is delivered. Up to 1024 events are buffered, later ones are dropped until the subscriber
+// catches up. The channel is closed by cancel or when the store is closed.
+func (kv *InMemoryKVStore) Subscribe(pattern string) (events <-chan KeyspaceEvent, cancel func()) {
+	sub := &keyspaceSubscription{
+		pattern: []byte(pattern),
+		events:  make(chan KeyspaceEvent, subscriptionBufferSize),
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if kv.closed {
+		sub.close()
+		return sub.events, func() {}
+	}
+	kv.subscriptions = append(kv.subscriptions, sub)
+
+	cancel = func() {
+		kv.mu.Lock()
+		kv.subscriptions = slices.DeleteFunc(kv.subscriptions, func(s *keyspaceSubscription) bool { return s == sub })
+		kv.mu.Unlock()
+		sub.close()
+	}
+	return sub.events, cancel
+}
+
+// Returns the name of the event for a push to the front or back of a list.
+func pushEvent(pushAtFront bool) string {
+	if pushAtFront {
+		return "lpush"
+	}
+	return "rpush"
+}
+
+// Returns the name of the event for a pop from the front or back of a list.
+func popEvent(popAtFront bool) string {
+	if popAtFront {
+		return "lpop"
+	}
+	return "rpop"
+}
+
+// Stores able to report changes made to their keys.
+type keyspaceNotifier interface {
+	OnKeyspaceEvent(hook func(event KeyspaceEvent))
+}
+
+// Sets the keyspace events published to the __keyspace@0__ and __keyevent@0__ channels. Fails if
+// the store cannot report changes to its keys. Must be called before ListenAndServe or from the
+// server loop.
+func (s *Server) SetKeyspaceEvents(flags KeyspaceEventFlags) error {
+	if flags.enabled() {
+		if err := s.watchKeyspace(); err != nil {
+			return err
+		}
+	}
+
+	s.keyspaceEvents = flags
+	return nil
+}
+
+// Starts passing the changes made to keys in the store to the server loop. The hook is only
+// registered once, the server loop decides what to do with each event.
+// Must be called before ListenAndServe or from the server loop.
+func (s *Server) watchKeyspace() error {
+	if s.eventCh != nil {
+		return nil
+	}
+
+	notifier, ok := s.store.(keyspaceNotifier)
+	if !ok {
+		return fmt.Errorf("the store does not support keyspace notifications")
+	}
+
+	s.eventCh = make(chan KeyspaceEvent)
+	notifier.OnKeyspaceEvent(func(event KeyspaceEvent) {
+		select {
+		case s.eventCh <- event:
+		case <-s.quitCh:
+		}
+	})
+	return nil
+}
+
+// Handles a change made to a key in the store, publishing keyspace notifications and invalidating
+// the key for clients tracking it.
+func (s *Server) handleKeyspaceEvent(event KeyspaceEvent) {
+	s.publishKeyspaceEvent(event)
+	s.invalidateKey(event.Key)
+}
+
+// Publishes a keyspace event to the channels enabled by the keyspace event flags.
+func (s *Server) publishKeyspaceEvent(event KeyspaceEvent) {
+	if s.keyspaceEvents&event.Class == 0 {
+		return
+	}
+
+	if s.keyspaceEvents&NotifyKeyspace != 0 {
+		s.publish(append([]byte("__keyspace@0__:"), event.Key...), []byte(event.Name))
+	}
+	if s.keyspaceEvents&NotifyKeyevent != 0 {
+		s.publish([]byte("__keyevent@0__:"+event.Name), event.Key)
+	}
+}
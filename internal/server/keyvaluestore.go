@@ -1,158 +1,557 @@
 package server
 
 import (
+	"bytes"
 	"fmt"
+	"runtime/debug"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/CDavidSV/GopherStore/internal/storage"
 	"github.com/CDavidSV/GopherStore/internal/util"
 )
 
 // KVStore interface defines a key-value storage system.
 type KVStore interface {
-	Set(key, value []byte, expiresAt int64)                          // Sets a key-value pair with optional expiration time (-1 means no expiration).
-	Push(key []byte, values [][]byte, pushAtFront bool) (int, error) // Pushes values to a list stored at key. If pushAtFront is true, values are added to the front.
-	Pop(key []byte, popAtFront bool) ([]byte, error)                 // Pops a value from a list stored at key. Returns nil if the list is empty or key does not exist.
-	GetValue(key []byte) ([]byte, error)                             // Retrieves the value for a given key.
-	GetList(key []byte) ([][]byte, error)                            // Retrieves the list for a given key.
-	Delete(keys [][]byte) int64                                      // Deletes a key-value pair. Returning the number of keys deleted.
-	Exists(keys [][]byte) int64                                      // Returns the number of keys currently stored.
-	Expire(key []byte, expiresAt int64) bool                         // Sets expiration for a key. Returns true if the key exists and expiration is set.
-	Close()                                                          // Closes the store and releases resources.
+	Set(key, value []byte, expiresAt int64)                                               // Sets a key-value pair with optional expiration time (-1 means no expiration).
+	SetWithOptions(key, value []byte, expiresAt int64, cond SetCondition) (bool, error)   // Sets a key-value pair if the condition holds: NX only sets missing keys, XX only existing ones. Returns false if the key was left unchanged.
+	Push(key []byte, values [][]byte, pushAtFront bool) (int, error)                      // Pushes values to a list stored at key. If pushAtFront is true, values are added to the front.
+	Pop(key []byte, popAtFront bool) ([]byte, error)                                      // Pops a value from a list stored at key, deleting the key once the list is empty. Returns nil if the key does not exist.
+	Move(source, destination []byte, popAtFront, pushAtFront bool) ([]byte, error)        // Atomically pops a value from the source list and pushes it to the destination list. Returns nil if the source is empty or does not exist.
+	GetValue(key []byte) ([]byte, error)                                                  // Retrieves the value for a given key.
+	GetOrSet(key, value []byte, expiresAt int64) ([]byte, error)                          // Returns the existing value for key, or sets it to value and returns value if the key does not exist.
+	GetValues(keys [][]byte) [][]byte                                                     // Retrieves the values for multiple keys. Missing keys and keys holding other types are returned as nil.
+	GetList(key []byte) ([][]byte, error)                                                 // Returns a copy of the list stored at key.
+	ListLen(key []byte) (int64, error)                                                    // Returns the number of elements of the list stored at key.
+	GetRange(key []byte, start, stop int) ([][]byte, error)                               // Returns a copy of the elements of the list stored at key between start and stop inclusive, negative indexes counting from the end. Returns nil if the key does not exist.
+	Delete(keys [][]byte) int64                                                           // Deletes a key-value pair. Returning the number of keys deleted.
+	DeleteIfType(keys [][]byte, keyType string) int64                                     // Deletes only the keys holding a value of the given type. Returning the number of keys deleted.
+	DeleteIfEquals(key, value []byte) (bool, error)                                       // Deletes a key only if its value equals the given value. Returns true if the key was deleted.
+	Exists(keys [][]byte) int64                                                           // Returns the number of keys currently stored.
+	Expire(key []byte, expiresAt int64) bool                                              // Sets expiration for a key. Returns true if the key exists and expiration is set.
+	Version(key []byte) uint64                                                            // Returns the version of a key, which changes on every write to it. Returns 0 if the key does not exist.
+	ExpiresAt(key []byte) int64                                                           // Returns the expiration time of a key in unix nanoseconds, -1 if it has none or -2 if the key does not exist.
+	Dirty() uint64                                                                        // Returns the number of changes made to keys, not counting expirations. Comparing values tells whether a command wrote anything.
+	HashSet(key []byte, pairs [][]byte) (int64, error)                                    // Sets field/value pairs in the hash stored at key. Returns the number of new fields.
+	HashSetNX(key, field, value []byte) (bool, error)                                     // Sets a field in the hash stored at key only if it does not exist. Returns true if the field was set.
+	HashGet(key, field []byte) ([]byte, error)                                            // Retrieves a field from the hash stored at key. Returns nil if the field or key does not exist.
+	HashGetMany(key []byte, fields [][]byte) ([][]byte, error)                            // Retrieves multiple fields from the hash stored at key. Missing fields are returned as nil.
+	HashLen(key []byte) (int64, error)                                                    // Returns the number of fields in the hash stored at key.
+	HashDelete(key []byte, fields [][]byte) (int64, error)                                // Deletes fields from the hash stored at key, deleting the key once the hash is empty. Returns the number of fields deleted.
+	HashGetAll(key []byte) ([][]byte, error)                                              // Retrieves all fields and values of the hash stored at key as a flat list of pairs.
+	HashExists(key, field []byte) (bool, error)                                           // Returns true if the field exists in the hash stored at key.
+	HashIncrBy(key, field []byte, delta int64) (int64, error)                             // Atomically increments an integer field in the hash stored at key, missing fields start at 0. Returns the new value.
+	HashIncrByFloat(key, field []byte, delta float64) ([]byte, error)                     // Atomically increments a float field in the hash stored at key, missing fields start at 0. Returns the new value formatted as a string.
+	HashExpire(key []byte, fields [][]byte, expiresAt int64) ([]int64, error)             // Sets the expiration of fields in the hash stored at key. Returns -2 per missing field, 1 if set, or 2 if the field was deleted because expiresAt is in the past.
+	HashTTL(key []byte, fields [][]byte) ([]int64, error)                                 // Returns the remaining TTL in milliseconds of fields in the hash stored at key, -1 for fields without expiration or -2 for missing fields.
+	SetAdd(key []byte, members [][]byte) (int64, error)                                   // Adds members to the set stored at key. Returns the number of members added.
+	SetRemove(key []byte, members [][]byte) (int64, error)                                // Removes members from the set stored at key, deleting the key once the set is empty. Returns the number of members removed.
+	SetContains(key []byte, members [][]byte) ([]bool, error)                             // Reports whether each member belongs to the set stored at key.
+	SetMembers(key []byte) ([][]byte, error)                                              // Retrieves all members of the set stored at key.
+	SetLen(key []byte) (int64, error)                                                     // Returns the number of members in the set stored at key.
+	SetScan(key []byte, cursor uint64, count int) ([][]byte, uint64, error)               // Returns about count members of the set stored at key starting at cursor, and the cursor for the next call (0 when complete).
+	SortedSetAdd(key []byte, items []ScoredMember, opt ZAddOptions) (int64, error)        // Adds or updates members of the sorted set stored at key. Returns the number of members added, plus updated ones with CH.
+	SortedSetRemove(key []byte, members [][]byte) (int64, error)                          // Removes members from the sorted set stored at key, deleting the key once it is empty. Returns the number of members removed.
+	SortedSetScore(key, member []byte) (float64, bool, error)                             // Returns the score of a member of the sorted set stored at key. Returns false if the member or key does not exist.
+	SortedSetLen(key []byte) (int64, error)                                               // Returns the number of members in the sorted set stored at key.
+	SortedSetRange(key []byte, start, stop int, rev bool) ([]ScoredMember, error)         // Returns the members between the ranks start and stop inclusive, negative ranks count from the end. Ranks count from the highest score if rev is true.
+	SortedSetRangeBy(key []byte, r ZRangeSpec, opt ZRangeOptions) ([]ScoredMember, error) // Returns the members within a score or lexicographic range, optionally reversed and limited to a page of the range.
+	SortedSetRemoveRange(key []byte, start, stop int) (int64, error)                      // Removes the members between the ranks start and stop inclusive, negative ranks count from the end. Returns the number of members removed.
+	SortedSetRemoveRangeBy(key []byte, r ZRangeSpec) (int64, error)                       // Removes the members within a score or lexicographic range. Returns the number of members removed.
+	SortedSetStore(dest []byte, keys [][]byte, opt ZStoreOptions) (int64, error)          // Stores the union, intersection or difference of the sorted sets or sets stored at keys in dest, replacing it. Returns the size of the result.
+	BitOp(op BitOperation, dest []byte, keys [][]byte) (int64, error)                     // Stores the bitwise operation of the strings stored at keys in dest, replacing it. Returns the length of the result.
+	BitField(key []byte, ops []BitFieldOp) ([]BitFieldResult, error)                      // Reads and writes integers at arbitrary bit offsets of the string stored at key. Returns one result per operation.
+	HyperLogLogAdd(key []byte, elements [][]byte) (bool, error)                           // Adds elements to the HyperLogLog stored at key, creating it if needed. Returns true if the estimate may have changed.
+	HyperLogLogCount(keys [][]byte) (int64, error)                                        // Returns the estimated number of unique elements in the union of the HyperLogLogs stored at keys.
+	HyperLogLogMerge(dest []byte, keys [][]byte) error                                    // Merges the HyperLogLogs stored at keys into the one stored at dest, creating it if needed.
+	StreamAdd(key []byte, fields [][]byte, opt XAddOptions) (StreamID, bool, error)       // Appends an entry to the stream stored at key. Returns the ID of the entry, or false if the stream does not exist and NoMkStream is set.
+	StreamLen(key []byte) (int64, error)                                                  // Returns the number of entries in the stream stored at key.
+	StreamRange(key []byte, start, end StreamID, n int, rev bool) ([]StreamEntry, error)  // Returns up to n entries with IDs between start and end inclusive, newest first if rev is true. A negative n means no limit.
+	StreamLastID(key []byte) (StreamID, error)                                            // Returns the ID of the last entry added to the stream stored at key, or 0-0 if it does not exist.
+	StreamGroupCreate(key, group []byte, id StreamID, mkStream bool) error                // Creates a consumer group that delivers entries after id. Creates an empty stream if mkStream is true.
+	StreamGroupSetID(key, group []byte, id StreamID) error                                // Sets the last delivered ID of a consumer group.
+	StreamGroupDestroy(key, group []byte) (bool, error)                                   // Deletes a consumer group and its pending entries.
+	StreamConsumerCreate(key, group, consumer []byte) (bool, error)                       // Creates a consumer in a group. Returns false if it already exists.
+	StreamConsumerDelete(key, group, consumer []byte) (int64, error)                      // Deletes a consumer from a group. Returns the number of entries that were pending for it.
+	StreamReadGroup(key, group []byte, opt XReadGroupOptions) ([]StreamEntry, error)      // Reads new or pending entries of a stream on behalf of a consumer of a group.
+	StreamAck(key, group []byte, ids []StreamID) (int64, error)                           // Removes entries from the pending entries list of a group. Returns the number acknowledged.
+	StreamPendingSummary(key, group []byte) (PendingSummary, error)                       // Returns the number of pending entries of a group, their ID range and the count per consumer.
+	StreamPending(key, group []byte, opt XPendingOptions) ([]PendingEntry, error)         // Returns the pending entries of a group with IDs in a range.
+	StreamAutoClaim(key, group []byte, opt XAutoClaimOptions) (AutoClaimResult, error)    // Transfers pending entries idle for at least MinIdle to a consumer.
+	GeoAdd(key []byte, members []GeoMember, opt ZAddOptions) (int64, error)               // Adds members with their locations to the sorted set stored at key.
+	GeoDist(key, member1, member2 []byte) (float64, bool, error)                          // Returns the distance in meters between two members, or false if either does not exist.
+	GeoSearch(key []byte, q GeoQuery) ([]GeoResult, error)                                // Returns the members located within a circle or rectangle.
+	MemoryUsage(key []byte) (int64, bool)                                                 // Returns the approximate memory used by a key in bytes. Returns false if the key does not exist.
+	DebugObject(key []byte) (ObjectInfo, bool)                                            // Returns the internals of the entry stored under key. Returns false if the key does not exist.
+	AccessFrequency(key []byte) (int, bool)                                               // Returns the access counter of a key used by LFU eviction. Returns false if the key does not exist.
+	DatasetSize() (keys, bytes int64)                                                     // Scans the keyspace and returns the number of keys and their approximate memory usage in bytes.
+	BiggestKeys(count int, by KeyMeasure) []KeyStats                                      // Scans the keyspace and returns up to count keys ordered by approximate memory usage or element count, biggest first.
+	KeyspaceSummary() []TypeSummary                                                       // Scans the keyspace and returns the totals and the biggest key of every type.
+	Close()                                                                               // Closes the store and releases resources.
 }
 
+// Type of the value held by an entry.
+type entryKind uint8
+
+const (
+	kindString entryKind = iota
+	kindList
+	kindHash
+	kindSet
+	kindSortedSet
+	kindStream
+)
+
 type Entry struct {
-	value     []byte
-	list      [][]byte
-	isList    bool
-	expiresAt int64
+	value          []byte // Never changed in place once stored, reads return it and encode it after unlocking
+	list           [][]byte
+	hash           map[string][]byte
+	fieldExpiresAt map[string]int64 // Per-field expiration for hashes, nil until a field TTL is set
+	set            map[string]struct{}
+	zset           *sortedSet
+	stream         *stream
+	kind           entryKind
+	expiresAt      int64
+	version        uint64 // Changes on every write, see InMemoryKVStore.Version
+	accessed       int64  // Unix nanoseconds of the last access while accesses are tracked for eviction, updated atomically
+	counter        uint32 // Logarithmic access counter of the LFU policies, see frequency, updated atomically
+	size           int64  // Memory usage at sizeVersion, see memoryUsage, updated atomically
+	sizeVersion    uint64 // Version at which size was computed, updated atomically
+	accounted      int64  // Size counted in the memory used by the store, see InMemoryKVStore.SetMaxMemory
 }
 
+// Returns a string entry, reusing one removed from a store when possible, see InMemoryKVStore.discard.
 func NewValueEntry(value []byte, expiresAt int64) *Entry {
+	entry := entryPool.get()
+	entry.value = value
+	entry.kind = kindString
+	entry.expiresAt = expiresAt
+	return entry
+}
+
+func NewListEntry(list [][]byte, expiresAt int64) *Entry {
 	return &Entry{
-		value:     value,
-		isList:    false,
+		list:      list,
+		kind:      kindList,
 		expiresAt: expiresAt,
 	}
 }
 
-func NewListEntry(list [][]byte, expiresAt int64) *Entry {
+func NewHashEntry(expiresAt int64) *Entry {
 	return &Entry{
-		list:      list,
-		isList:    true,
+		hash:      make(map[string][]byte),
+		kind:      kindHash,
+		expiresAt: expiresAt,
+	}
+}
+
+func NewSetEntry(expiresAt int64) *Entry {
+	return &Entry{
+		set:       make(map[string]struct{}),
+		kind:      kindSet,
+		expiresAt: expiresAt,
+	}
+}
+
+func NewSortedSetEntry(expiresAt int64) *Entry {
+	return &Entry{
+		zset:      newSortedSet(),
+		kind:      kindSortedSet,
+		expiresAt: expiresAt,
+	}
+}
+
+func NewStreamEntry(expiresAt int64) *Entry {
+	return &Entry{
+		stream:    newStream(),
+		kind:      kindStream,
 		expiresAt: expiresAt,
 	}
 }
 
+// Returns the name of the type held by the entry.
+func (e *Entry) typeName() string {
+	switch e.kind {
+	case kindList:
+		return "list"
+	case kindHash:
+		return "hash"
+	case kindSet:
+		return "set"
+	case kindSortedSet:
+		return "zset"
+	case kindStream:
+		return "stream"
+	default:
+		return "string"
+	}
+}
+
 // Checks if the current entry is expired.
 func (e *Entry) isExpired() bool {
 	return e.expiresAt > 0 && time.Now().UnixNano() > e.expiresAt
 }
 
-// Implement the KVStore interface with a map.
+// Implement the KVStore interface with a map, split into shards so operations on keys of different
+// shards run in parallel, see lockKeys.
 type InMemoryKVStore struct {
-	store     map[string]*Entry
-	expirable map[string]struct{}
-	mu        sync.RWMutex
-	closeCh   chan struct{}
-	closed    bool
+	shards           [storeShards]storeShard
+	cleanupBudget    time.Duration           // Maximum time spent by each active expiration cycle
+	cleanupInterval  time.Duration           // Time between active expiration cycles
+	defaultTTL       time.Duration           // Expiration of new keys written without one, 0 means none
+	expiredHooks     []func(key []byte)      // Called with each key removed because it expired
+	passiveExpiry    bool                    // Expired keys and fields are hidden but not removed, see SetPassiveExpiry
+	noActiveExpire   bool                    // Active expiration cycles are skipped, see SetActiveExpire
+	maxMemory        atomic.Int64            // Limit of the memory used by keys in bytes, 0 means none, see SetMaxMemory
+	evictionPolicy   EvictionPolicy          // Keys evicted once the memory used exceeds maxMemory
+	lfu              atomic.Bool             // The eviction policy uses access frequencies, tracked even without maxMemory
+	usedMemory       atomic.Int64            // Approximate memory used by keys, only tracked with maxMemory
+	evicted          int64                   // Number of keys evicted to stay under maxMemory
+	removals         []Removal               // Keys and hash fields removed by the store itself, see TrackRemovals. Guarded by queueMu
+	removalSignal    chan struct{}           // Signaled when removals are waiting, nil until TrackRemovals
+	eventHooks       []func(KeyspaceEvent)   // Called with every change made to a key
+	eventQueue       []KeyspaceEvent         // Events waiting to be passed to the hooks. Guarded by queueMu
+	eventSignal      chan struct{}           // Wakes up the goroutine running the hooks
+	subscriptions    []*keyspaceSubscription // Created by Subscribe
+	lazyFreeMin      int64                   // Removed values with more elements are released in the background, 0 disables it
+	lazyFreeQueue    []*Entry                // Removed values waiting to be released. Guarded by queueMu
+	lazyFreeSignal   chan struct{}           // Wakes up the goroutine releasing the values
+	lazyFreed        int64                   // Number of values released in the background. Guarded by queueMu
+	version          atomic.Uint64           // Last version given to a written entry
+	dirty            atomic.Uint64           // Number of changes made to keys, see Dirty
+	frozen           map[string]*Entry       // Entries being written by a snapshot, copied before they are changed
+	backend          storage.Backend         // Holds every key when set, the map only keeps the hot ones
+	maxHotKeys       int                     // Keys kept in memory with a backend, colder keys are evicted
+	flushMu          sync.Mutex              // Serializes writes to the backend
+	backendErrorHook func(err error)         // Called with the errors of the backend
+	latencyHook      latencyFunc             // Called with the time taken by each active expiration cycle
+	writeBehind      *writeBehind            // Mirrors string writes and deletes to a sink when set
+	mu               sync.RWMutex            // Read locked by operations on keys, write locked by operations on the whole store
+	queueMu          sync.Mutex              // Guards the queues filled by operations on different shards
+	closeCh          chan struct{}
+	closed           bool
 }
 
 const (
-	cleanupInterval   = time.Millisecond * 250
-	cleanupCountBound = 25
+	defaultCleanupInterval = time.Millisecond * 250
+	cleanupBatchSize       = 1000 // Expired keys removed per lock acquisition
+	cleanupSampleSize      = 20   // Hash keys with field TTLs checked per sample
+	cleanupExpiredRatio    = 0.25 // Keep sampling while more than this fraction of a sample had expired fields
+	defaultCleanupBudget   = time.Millisecond * 25
 )
 
-// Removes a key from both the store and expirable maps.
-// Must be called with the lock already held.
+// Removes a key from the store and the expiration indexes.
+// Must be called with the lock of the key held, see lockKeys.
 func (kv *InMemoryKVStore) deleteKey(key string) {
-	delete(kv.store, key)
-	delete(kv.expirable, key)
+	sh := kv.shard(key)
+	if entry, exists := sh.entries[key]; exists {
+		kv.releaseMemory(entry)
+		kv.discard(key, entry)
+	}
+	delete(sh.entries, key)
+	sh.expiries.remove(key)
+	delete(sh.fieldExpirable, key)
+}
+
+// Adds a key to the store, replacing any existing entry. Entries without an expiration time get
+// the default TTL when one is set.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) addKey(key string, entry *Entry) {
+	if entry.expiresAt <= 0 && kv.defaultTTL > 0 {
+		entry.expiresAt = time.Now().Add(kv.defaultTTL).UnixNano()
+	}
+
+	sh := kv.shard(key)
+	if entry.expiresAt > 0 {
+		sh.expiries.set(key, entry.expiresAt)
+	} else {
+		sh.expiries.remove(key)
+	}
+	if old, exists := sh.entries[key]; exists && old != entry {
+		kv.releaseMemory(old)
+		kv.discard(key, old)
+	}
+	kv.touch(entry)
+	sh.entries[key] = entry
+	kv.markResized(key)
+}
+
+// Releases an entry removed from the store. Values with many elements are queued to be freed in the
+// background, and string entries go back to entryPool unless a snapshot still writes them. Entries of
+// other kinds are left to the garbage collector, since their collections may still be referenced.
+// Reads must copy what they need from an entry before releasing its lock, see view, since the entry
+// may be reused for another key right after.
+// Must be called with the lock of the key held, as the entry is removed.
+func (kv *InMemoryKVStore) discard(key string, entry *Entry) {
+	if kv.lazyFree(entry) || entry.kind != kindString || kv.frozen[key] == entry {
+		return
+	}
+	*entry = Entry{}
+	entryPool.put(entry)
+}
+
+// Gives an entry a new version after a write. Versions come from a single counter for the whole
+// store, so a key deleted and created again never gets a version it had before.
+// Must be called with the lock of the entry's key held.
+func (kv *InMemoryKVStore) touch(entry *Entry) {
+	entry.version = kv.version.Add(1)
+	kv.markAccessed(entry)
+}
+
+// Queues a value removed from the store to be released on a background goroutine if it has more
+// elements than the lazy free threshold, so the write lock is not held while its memory is reclaimed.
+// Returns true if it was queued.
+// Must be called with the lock of the entry's key held.
+func (kv *InMemoryKVStore) lazyFree(entry *Entry) bool {
+	if kv.lazyFreeMin <= 0 || entry.elementCount() <= kv.lazyFreeMin {
+		return false
+	}
+
+	kv.queueMu.Lock()
+	kv.lazyFreeQueue = append(kv.lazyFreeQueue, entry)
+	kv.queueMu.Unlock()
+	select {
+	case kv.lazyFreeSignal <- struct{}{}:
+	default:
+		// The lazy free goroutine is already signaled and will pick up the value
+	}
+	return true
+}
+
+// Removes a key whose expiration time has passed and queues an expired event for the hooks. With
+// passive expiry, the key is left for the primary to delete.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) expireKey(key string) {
+	if _, exists := kv.shard(key).entries[key]; !exists || kv.passiveExpiry {
+		return
+	}
+	kv.deleteKey(key)
+	kv.notify(EventExpired, "expired", []byte(key))
+	kv.recordRemoval(Removal{Key: []byte(key)})
 }
 
 func NewInMemoryKVStore() *InMemoryKVStore {
 	store := &InMemoryKVStore{
-		store:     make(map[string]*Entry),
-		expirable: make(map[string]struct{}),
-		closeCh:   make(chan struct{}),
-		closed:    false,
+		cleanupBudget:   defaultCleanupBudget,
+		cleanupInterval: defaultCleanupInterval,
+		eventSignal:     make(chan struct{}, 1),
+		lazyFreeSignal:  make(chan struct{}, 1),
+		closeCh:         make(chan struct{}),
+		closed:          false,
+	}
+	for i := range store.shards {
+		store.shards[i].init()
 	}
 
 	go store.cleanupExpiredKeys()
+	go store.runEventHooks()
+	go store.runLazyFree()
 
 	return store
 }
 
 func (kv *InMemoryKVStore) Set(key, value []byte, expiresAt int64) {
-	kv.mu.Lock()
-	defer kv.mu.Unlock()
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
 
 	if kv.closed {
 		return
 	}
 
-	entry := NewValueEntry(value, expiresAt)
+	kv.addKey(string(key), NewValueEntry(value, expiresAt))
+	kv.notifySet(key, expiresAt)
+}
 
+// Notifies that a string was set, and that an expiration was set with it, like SET with EX in Redis.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) notifySet(key []byte, expiresAt int64) {
+	kv.notify(EventString, "set", key)
 	if expiresAt > 0 {
-		kv.expirable[string(key)] = struct{}{}
+		kv.notify(EventGeneric, "expire", key)
 	}
-	kv.store[string(key)] = entry
 }
 
-func (kv *InMemoryKVStore) get(key []byte) (*Entry, bool) {
-	kv.mu.RLock()
+// Checks the condition and sets the key with a single lookup under the lock of the key, so the check
+// and the write cannot be split by another write. Keys holding another type are not replaced.
+func (kv *InMemoryKVStore) SetWithOptions(key, value []byte, expiresAt int64, cond SetCondition) (bool, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
 	if kv.closed {
-		kv.mu.RUnlock()
-		return nil, false
+		return false, fmt.Errorf("store is closed")
 	}
 
-	entry, exists := kv.store[string(key)]
-	kv.mu.RUnlock()
-	if !exists {
-		return nil, false
+	k := string(key)
+	entry, exists := kv.entry(k)
+	if exists && entry.isExpired() {
+		kv.expireKey(k)
+		exists = false
 	}
 
-	// Check expiration
-	if entry.isExpired() {
-		// Key has expired
-		kv.mu.Lock()
-		kv.deleteKey(string(key))
-		kv.mu.Unlock()
-		return nil, false
+	if exists && entry.kind != kindString {
+		return false, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	if (cond == ConditionNX && exists) || (cond == ConditionXX && !exists) {
+		return false, nil
 	}
 
-	return entry, true
+	kv.addKey(k, NewValueEntry(value, expiresAt))
+	kv.notifySet(key, expiresAt)
+	return true, nil
+}
+
+// Calls fn with the entry of key under the read lock of its shard, so the entry is never read while a
+// write changes it. fn must copy what it needs, the entry must not be used once it returns. Expired
+// keys are removed without calling fn. Returns false if the key does not exist.
+func (kv *InMemoryKVStore) view(key []byte, fn func(entry *Entry)) bool {
+	kv.loadKeys([][]byte{key})
+
+	locked := kv.rlockKeys(key)
+	if kv.closed {
+		kv.runlockKeys(locked)
+		return false
+	}
+
+	entry, exists := kv.shard(string(key)).entries[string(key)]
+	if exists && !entry.isExpired() {
+		kv.markAccessed(entry)
+		fn(entry)
+		kv.runlockKeys(locked)
+		return true
+	}
+	kv.runlockKeys(locked)
+	if !exists {
+		return false
+	}
+
+	// Key has expired, unless it was replaced after releasing the read lock. The entry is checked again
+	// rather than compared, since a replaced string entry may be reused for the same key, see discard
+	locked = kv.lockKeys(key)
+	if entry, exists := kv.shard(string(key)).entries[string(key)]; exists && entry.isExpired() {
+		kv.expireKey(string(key))
+	}
+	kv.unlockKeys(locked)
+	return false
 }
 
 func (kv *InMemoryKVStore) GetValue(key []byte) ([]byte, error) {
-	entry, exists := kv.get(key)
+	var kind entryKind
+	var value []byte
+	exists := kv.view(key, func(entry *Entry) {
+		kind, value = entry.kind, entry.value
+	})
 	if !exists {
 		return nil, nil
 	}
 
-	if entry.isList {
+	if kind != kindString {
 		return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 
-	return entry.value, nil
+	return value, nil
 }
 
-func (kv *InMemoryKVStore) GetList(key []byte) ([][]byte, error) {
-	entry, exists := kv.get(key)
-	if !exists {
-		return nil, nil
+func (kv *InMemoryKVStore) GetValues(keys [][]byte) [][]byte {
+	kv.loadKeys(keys)
+
+	locked := kv.rlockKeys(keys...)
+	defer kv.runlockKeys(locked)
+
+	values := make([][]byte, len(keys))
+	if kv.closed {
+		return values
 	}
 
-	if !entry.isList {
-		return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	for i, key := range keys {
+		entry, exists := kv.shard(string(key)).entries[string(key)]
+		if !exists || entry.isExpired() || entry.kind != kindString {
+			// Expired keys are left for lazy or active cleanup
+			continue
+		}
+		kv.markAccessed(entry)
+		values[i] = entry.value
 	}
 
-	return entry.list, nil
+	return values
+}
+
+func (kv *InMemoryKVStore) GetOrSet(key, value []byte, expiresAt int64) ([]byte, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	k := string(key)
+	entry, exists := kv.entry(k)
+	if exists && entry.isExpired() {
+		// Key has expired, treat it as missing
+		kv.expireKey(k)
+		exists = false
+	}
+
+	if exists {
+		if entry.kind != kindString {
+			return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+		return entry.value, nil
+	}
+
+	kv.addKey(k, NewValueEntry(value, expiresAt))
+	kv.notifySet(key, expiresAt)
+
+	return value, nil
+}
+
+// Calls fn with the list stored at key under the read lock of its shard, see view. fn is not called if
+// the key does not exist. The list is only read, so it is not copied for a snapshot in progress.
+func (kv *InMemoryKVStore) viewList(key []byte, fn func(list [][]byte)) error {
+	var kind entryKind
+	exists := kv.view(key, func(entry *Entry) {
+		if kind = entry.kind; kind == kindList {
+			fn(entry.list)
+		}
+	})
+	if exists && kind != kindList {
+		return fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return nil
+}
+
+// Returns a copy of the list made under the lock of the key. The list itself is not returned, since a
+// pop followed by a push writes the new element where the popped one was.
+func (kv *InMemoryKVStore) GetList(key []byte) ([][]byte, error) {
+	var list [][]byte
+	err := kv.viewList(key, func(l [][]byte) {
+		list = slices.Clone(l)
+	})
+	return list, err
+}
+
+// Copies only the elements in the range, like LRANGE, so a small range of a large list costs as much
+// as the range. Out of range indexes are clamped to the list, and a start after stop returns no elements.
+func (kv *InMemoryKVStore) GetRange(key []byte, start, stop int) ([][]byte, error) {
+	var elements [][]byte
+	err := kv.viewList(key, func(list [][]byte) {
+		elements = slices.Clone(util.SliceList(list, start, stop))
+	})
+	return elements, err
+}
+
+func (kv *InMemoryKVStore) ListLen(key []byte) (int64, error) {
+	var length int64
+	err := kv.viewList(key, func(list [][]byte) {
+		length = int64(len(list))
+	})
+	return length, err
 }
 
 func (kv *InMemoryKVStore) Delete(keys [][]byte) int64 {
-	kv.mu.Lock()
-	defer kv.mu.Unlock()
+	locked := kv.lockKeys(keys...)
+	defer kv.unlockKeys(locked)
 
 	if kv.closed {
 		return 0
@@ -160,9 +559,10 @@ func (kv *InMemoryKVStore) Delete(keys [][]byte) int64 {
 
 	var deletedKeys int64 = 0
 	for _, key := range keys {
-		_, exists := kv.store[string(key)]
+		_, exists := kv.entry(string(key))
 		if exists {
 			kv.deleteKey(string(key))
+			kv.notify(EventGeneric, "del", key)
 			deletedKeys++
 		}
 
@@ -173,9 +573,73 @@ func (kv *InMemoryKVStore) Delete(keys [][]byte) int64 {
 	return deletedKeys
 }
 
+func (kv *InMemoryKVStore) DeleteIfType(keys [][]byte, keyType string) int64 {
+	locked := kv.lockKeys(keys...)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0
+	}
+
+	var deletedKeys int64 = 0
+	for _, key := range keys {
+		entry, exists := kv.entry(string(key))
+		if !exists {
+			continue
+		}
+
+		// Expired keys are removed but not counted as deleted
+		if entry.isExpired() {
+			kv.expireKey(string(key))
+			continue
+		}
+
+		if entry.typeName() == keyType {
+			kv.deleteKey(string(key))
+			kv.notify(EventGeneric, "del", key)
+			deletedKeys++
+		}
+	}
+
+	return deletedKeys
+}
+
+func (kv *InMemoryKVStore) DeleteIfEquals(key, value []byte) (bool, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return false, nil
+	}
+
+	entry, exists := kv.entry(string(key))
+	if !exists {
+		return false, nil
+	}
+
+	if entry.isExpired() {
+		kv.expireKey(string(key))
+		return false, nil
+	}
+
+	if entry.kind != kindString {
+		return false, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	if !bytes.Equal(entry.value, value) {
+		return false, nil
+	}
+
+	kv.deleteKey(string(key))
+	kv.notify(EventGeneric, "del", key)
+	return true, nil
+}
+
 func (kv *InMemoryKVStore) Exists(keys [][]byte) int64 {
-	kv.mu.RLock()
-	defer kv.mu.RUnlock()
+	kv.loadKeys(keys)
+
+	locked := kv.rlockKeys(keys...)
+	defer kv.runlockKeys(locked)
 
 	if kv.closed {
 		return 0
@@ -183,7 +647,7 @@ func (kv *InMemoryKVStore) Exists(keys [][]byte) int64 {
 
 	var existingKeys int64 = 0
 	for _, key := range keys {
-		entry, exists := kv.store[string(key)]
+		entry, exists := kv.shard(string(key)).entries[string(key)]
 		if exists {
 			// Check expiration
 			if entry.isExpired() {
@@ -197,15 +661,91 @@ func (kv *InMemoryKVStore) Exists(keys [][]byte) int64 {
 	return existingKeys
 }
 
+// Returns the version of the value stored at key. Every write to the key gives it a new version, greater
+// than any version given before, so comparing versions tells whether a key changed between two reads,
+// including being deleted or expiring and created again. Changes to the consumer groups of a stream
+// do not change its version. Returns 0 if the key does not exist.
+func (kv *InMemoryKVStore) Version(key []byte) uint64 {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0
+	}
+
+	entry, exists := kv.entry(string(key))
+	if exists && entry.isExpired() {
+		kv.expireKey(string(key))
+		return 0
+	}
+
+	// Expired hash fields are removed first since removing them is a write
+	if exists && len(entry.fieldExpiresAt) > 0 {
+		kv.expireHashFields(string(key))
+		entry, exists = kv.shard(string(key)).entries[string(key)]
+	}
+
+	if !exists {
+		return 0
+	}
+	return entry.version
+}
+
+// Returns the expiration time of a key in unix nanoseconds, -1 if it has no expiration or -2 if the
+// key does not exist.
+func (kv *InMemoryKVStore) ExpiresAt(key []byte) int64 {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return -2
+	}
+
+	entry, exists := kv.entry(string(key))
+	if !exists {
+		return -2
+	}
+	if entry.isExpired() {
+		kv.expireKey(string(key))
+		return -2
+	}
+
+	if entry.expiresAt <= 0 {
+		return -1
+	}
+	return entry.expiresAt
+}
+
+// Returns the number of changes made to keys since the store was created. Keys and hash fields
+// removed because they expired are not counted, so reads never change it.
+func (kv *InMemoryKVStore) Dirty() uint64 {
+	return kv.dirty.Load()
+}
+
+// Returns the number of keys held in memory, including expired keys not removed yet.
+func (kv *InMemoryKVStore) KeyCount() int64 {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	var count int64
+	for i := range kv.shards {
+		sh := &kv.shards[i]
+		sh.mu.RLock()
+		count += int64(len(sh.entries))
+		sh.mu.RUnlock()
+	}
+	return count
+}
+
 func (kv *InMemoryKVStore) Expire(key []byte, expiresAt int64) bool {
-	kv.mu.Lock()
-	defer kv.mu.Unlock()
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
 
 	if kv.closed {
 		return false
 	}
 
-	entry, exists := kv.store[string(key)]
+	entry, exists := kv.entry(string(key))
 	if !exists {
 		return false
 	}
@@ -213,34 +753,39 @@ func (kv *InMemoryKVStore) Expire(key []byte, expiresAt int64) bool {
 	// Check if expired already
 	if entry.isExpired() {
 		// Key has expired
-		kv.deleteKey(string(key))
+		kv.expireKey(string(key))
 		return false
 	}
 
 	// Update expiration time
+	entry = kv.mutable(string(key), entry)
 	entry.expiresAt = expiresAt
-	kv.store[string(key)] = entry
+	kv.touch(entry)
+	sh := kv.shard(string(key))
+	sh.entries[string(key)] = entry
+	sh.expiries.set(string(key), expiresAt)
+	kv.notify(EventGeneric, "expire", key)
 
 	return true
 }
 
 func (kv *InMemoryKVStore) Push(key []byte, values [][]byte, pushAtFront bool) (int, error) {
-	kv.mu.Lock()
-	defer kv.mu.Unlock()
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
 
 	if kv.closed {
 		return 0, fmt.Errorf("store is closed")
 	}
 
-	entry, exists := kv.store[string(key)]
-	if exists && !entry.isList {
+	entry, exists := kv.entry(string(key))
+	if exists && entry.kind != kindList {
 		return 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 
 	// Check if expired already
 	if exists && entry.isExpired() {
 		// Key has expired
-		kv.deleteKey(string(key))
+		kv.expireKey(string(key))
 		exists = false
 	}
 
@@ -250,41 +795,44 @@ func (kv *InMemoryKVStore) Push(key []byte, values [][]byte, pushAtFront bool) (
 
 	// Depending on pushAtFront, we add elements to the front or back
 	if exists {
+		entry = kv.mutable(string(key), entry)
 		if pushAtFront {
 			util.ReverseSlice(elements)
 			entry.list = append(elements, entry.list...)
 		} else {
 			entry.list = append(entry.list, elements...)
 		}
+		kv.touch(entry)
 	} else {
 		if pushAtFront {
 			util.ReverseSlice(elements)
 		}
 
 		entry = NewListEntry(elements, -1)
-		kv.store[string(key)] = entry
+		kv.addKey(string(key), entry)
 	}
+	kv.notify(EventList, pushEvent(pushAtFront), key)
 
 	return len(entry.list), nil
 }
 
 func (kv *InMemoryKVStore) Pop(key []byte, popAtFront bool) ([]byte, error) {
-	kv.mu.Lock()
-	defer kv.mu.Unlock()
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
 
 	if kv.closed {
 		return nil, fmt.Errorf("store is closed")
 	}
 
-	entry, exists := kv.store[string(key)]
-	if exists && !entry.isList {
+	entry, exists := kv.entry(string(key))
+	if exists && entry.kind != kindList {
 		return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 
 	// Check if expired already
 	if exists && entry.isExpired() {
 		// Key has expired
-		kv.deleteKey(string(key))
+		kv.expireKey(string(key))
 		return nil, nil
 	}
 
@@ -293,6 +841,7 @@ func (kv *InMemoryKVStore) Pop(key []byte, popAtFront bool) ([]byte, error) {
 	}
 
 	var value []byte
+	entry = kv.mutable(string(key), entry)
 
 	if popAtFront {
 		value = entry.list[0]
@@ -301,53 +850,368 @@ func (kv *InMemoryKVStore) Pop(key []byte, popAtFront bool) ([]byte, error) {
 		value = entry.list[len(entry.list)-1]
 		entry.list = entry.list[:len(entry.list)-1]
 	}
-	// We do not delete the key even if empty
+	kv.touch(entry)
+
+	kv.notify(EventList, popEvent(popAtFront), key)
+
+	// Lists are removed once their last element is popped
+	if len(entry.list) == 0 {
+		kv.deleteKey(string(key))
+		kv.notify(EventGeneric, "del", key)
+	}
 
 	return value, nil
 }
 
-func (kv *InMemoryKVStore) Close() {
+func (kv *InMemoryKVStore) Move(source, destination []byte, popAtFront, pushAtFront bool) ([]byte, error) {
+	locked := kv.lockKeys(source, destination)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	src, exists := kv.entry(string(source))
+	if exists && src.isExpired() {
+		kv.expireKey(string(source))
+		exists = false
+	}
+
+	if exists && src.kind != kindList {
+		return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	if !exists || len(src.list) == 0 {
+		return nil, nil
+	}
+
+	dst, dstExists := kv.entry(string(destination))
+	if dstExists && dst.isExpired() {
+		kv.expireKey(string(destination))
+		dstExists = false
+	}
+
+	// Check the destination type before modifying the source
+	if dstExists && dst.kind != kindList {
+		return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	// Looked up again after copying the source, which may also be the destination
+	src = kv.mutable(string(source), src)
+	if dstExists {
+		dst = kv.mutable(string(destination), kv.shard(string(destination)).entries[string(destination)])
+	}
+
+	var value []byte
+	if popAtFront {
+		value = src.list[0]
+		src.list = src.list[1:]
+	} else {
+		value = src.list[len(src.list)-1]
+		src.list = src.list[:len(src.list)-1]
+	}
+	kv.touch(src)
+
+	if !dstExists {
+		dst = NewListEntry(nil, -1)
+		kv.addKey(string(destination), dst)
+	}
+
+	if pushAtFront {
+		dst.list = append([][]byte{value}, dst.list...)
+	} else {
+		dst.list = append(dst.list, value)
+	}
+	kv.touch(dst)
+	kv.notify(EventList, popEvent(popAtFront), source)
+	kv.notify(EventList, pushEvent(pushAtFront), destination)
+
+	if len(src.list) == 0 {
+		kv.deleteKey(string(source))
+		kv.notify(EventGeneric, "del", source)
+	}
+
+	return value, nil
+}
+
+// Deletes every key without sending keyspace events, used before loading the data of a primary.
+func (kv *InMemoryKVStore) Flush() error {
 	kv.mu.Lock()
 	defer kv.mu.Unlock()
 
 	if kv.closed {
+		return fmt.Errorf("store is closed")
+	}
+	if kv.backend != nil {
+		return fmt.Errorf("flushing is not supported with a storage backend")
+	}
+
+	for i := range kv.shards {
+		for key := range kv.shards[i].entries {
+			kv.deleteKey(key)
+		}
+	}
+	return nil
+}
+
+func (kv *InMemoryKVStore) Close() {
+	kv.mu.Lock()
+	if kv.closed {
+		kv.mu.Unlock()
 		return
 	}
 
 	kv.closed = true
 	close(kv.closeCh)
+	wb := kv.writeBehind
+	kv.mu.Unlock()
+
+	if wb != nil {
+		// The remaining writes may need keys that are only in the backend
+		<-wb.done
+	}
+	if kv.backend != nil {
+		kv.closeBackend()
+	}
 }
 
-func (kv *InMemoryKVStore) cleanupExpiredKeys() {
-	ticker := time.NewTicker(cleanupInterval)
-	defer ticker.Stop()
+// Registers a hook called with each key removed because its expiration time passed, whether it was
+// found expired when accessed or by the cleanup loop. Keys deleted by commands or whose hash fields
+// expired are not reported. Hooks run in order on a background goroutine, in the order keys expired,
+// and can safely call the store.
+func (kv *InMemoryKVStore) OnExpired(hook func(key []byte)) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
 
+	kv.expiredHooks = append(kv.expiredHooks, hook)
+}
+
+// Sets the number of elements above which deleted or overwritten values are released on a background
+// goroutine instead of while holding the write lock. Strings count bytes as elements, 0 disables it.
+func (kv *InMemoryKVStore) SetLazyFreeThreshold(elements int64) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.lazyFreeMin = elements
+}
+
+// Returns the threshold set by SetLazyFreeThreshold.
+func (kv *InMemoryKVStore) LazyFreeThreshold() int64 {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	return kv.lazyFreeMin
+}
+
+// Releases the values queued by lazyFree until the store is closed.
+func (kv *InMemoryKVStore) runLazyFree() {
 	for {
 		select {
-		case <-ticker.C:
-			checked := 0
-			kv.mu.Lock()
-
-			// Iterate over expirable keys and remove expired ones
-			for key := range kv.expirable {
-				// If the key exists, check expiration and delete if expired
-				if entry, exists := kv.store[key]; exists {
-					if entry.isExpired() {
-						kv.deleteKey(key)
-					}
-				} else {
-					// Key no longer exists, remove from expirable map
-					delete(kv.expirable, key)
+		case <-kv.lazyFreeSignal:
+			kv.queueMu.Lock()
+			entries := kv.lazyFreeQueue
+			kv.lazyFreeQueue = nil
+			kv.lazyFreed += int64(len(entries))
+			kv.queueMu.Unlock()
+
+			// Drop the last references to the values and return their memory to the OS,
+			// without blocking the store while the garbage collector reclaims them
+			clear(entries)
+			debug.FreeOSMemory()
+		case <-kv.closeCh:
+			return
+		}
+	}
+}
+
+// Sets the TTL of new keys written without an explicit expiration, so no key lives forever.
+// Applies to keys of every type when they are created, 0 disables it.
+func (kv *InMemoryKVStore) SetDefaultTTL(ttl time.Duration) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.defaultTTL = ttl
+}
+
+// Returns the TTL set by SetDefaultTTL.
+func (kv *InMemoryKVStore) DefaultTTL() time.Duration {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	return kv.defaultTTL
+}
+
+// Sets the maximum time spent by each active expiration cycle, which runs every 250ms by default.
+// Keys left over when the budget runs out are removed in the next cycles or when accessed.
+func (kv *InMemoryKVStore) SetCleanupBudget(budget time.Duration) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.cleanupBudget = budget
+}
+
+// Returns the budget set by SetCleanupBudget.
+func (kv *InMemoryKVStore) CleanupBudget() time.Duration {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	return kv.cleanupBudget
+}
+
+// Sets the time between active expiration cycles, which also write changed keys to the storage
+// backend. Shorter intervals remove expired keys sooner at the cost of more frequent locking. A new
+// interval takes effect after the next cycle. Must be positive.
+func (kv *InMemoryKVStore) SetCleanupInterval(interval time.Duration) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.cleanupInterval = interval
+}
+
+// Returns the interval set by SetCleanupInterval.
+func (kv *InMemoryKVStore) CleanupInterval() time.Duration {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	return kv.cleanupInterval
+}
+
+// Sets whether expired keys and hash fields are removed in the background, enabled by default. When
+// disabled, like with DEBUG SET-ACTIVE-EXPIRE 0 in Redis, they are only removed when accessed.
+func (kv *InMemoryKVStore) SetActiveExpire(enabled bool) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.noActiveExpire = !enabled
+}
+
+// Removes expired keys and hash fields until there are none left or the cleanup budget runs out.
+func (kv *InMemoryKVStore) activeExpireCycle() {
+	kv.mu.RLock()
+	deadline := time.Now().Add(kv.cleanupBudget)
+	skip := kv.passiveExpiry || kv.noActiveExpire
+	kv.mu.RUnlock()
+	if skip {
+		return
+	}
+
+	kv.removeExpiredKeys(deadline)
+	kv.sampleExpiredFields(deadline)
+}
+
+// Removes the keys whose expiration time has passed, earliest first in each shard, in batches of
+// cleanupBatchSize keys so a large number of keys expiring at once does not block other operations.
+// Only one shard is locked at a time. Shards are visited from a random one, so a budget running out
+// does not always leave the same shards for later.
+func (kv *InMemoryKVStore) removeExpiredKeys(deadline time.Time) {
+	batch := 0 // Keys removed since the budget was last checked
+	for sh := range kv.shardsFromRandom() {
+		for {
+			kv.mu.RLock()
+			sh.mu.Lock()
+			now := time.Now().UnixNano()
+
+			removed := 0
+			for ; batch+removed < cleanupBatchSize; removed++ {
+				key, ok := sh.expiries.popExpired(now)
+				if !ok {
+					break
 				}
 
-				checked++
-				// Only check a limited number of keys per interval
-				if checked >= cleanupCountBound {
-					kv.mu.Unlock()
+				// The queue is kept in sync with the entries, check in case the key was replaced
+				entry, exists := sh.entries[key]
+				switch {
+				case exists && entry.isExpired():
+					kv.expireKey(key)
+				case exists && entry.expiresAt > 0:
+					sh.expiries.set(key, entry.expiresAt)
+				}
+			}
+			sh.mu.Unlock()
+			kv.mu.RUnlock()
+
+			batch += removed
+			if batch < cleanupBatchSize {
+				// No expired keys left in the shard
+				break
+			}
+			if time.Now().After(deadline) {
+				return
+			}
+			batch = 0
+		}
+	}
+}
+
+// Checks random samples of the hashes with field TTLs of each shard, like the Redis active expiration
+// cycle does for each database. Sampling a shard continues while a large fraction of each sample had
+// expired fields, as it is likely that many more are waiting to be removed.
+func (kv *InMemoryKVStore) sampleExpiredFields(deadline time.Time) {
+	for sh := range kv.shardsFromRandom() {
+		for {
+			sampled, expired := 0, 0
+			kv.mu.RLock()
+			sh.mu.Lock()
+			// Map iteration starts at a random key
+			for key := range sh.fieldExpirable {
+				if kv.expireHashFields(key) {
+					expired++
+				}
+
+				sampled++
+				if sampled >= cleanupSampleSize {
 					break
 				}
 			}
-			kv.mu.Unlock()
+			sh.mu.Unlock()
+			kv.mu.RUnlock()
+
+			if time.Now().After(deadline) {
+				return
+			}
+			if sampled < cleanupSampleSize || float64(expired) <= cleanupExpiredRatio*float64(sampled) {
+				break
+			}
+		}
+	}
+}
+
+// Registers a hook called with the time taken by the background work of the store, named like the
+// Redis latency events. Only active expiration cycles ("expire-cycle") are reported for now.
+func (kv *InMemoryKVStore) OnLatency(hook func(event string, latency time.Duration)) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.latencyHook = hook
+}
+
+func (kv *InMemoryKVStore) reportLatency(event string, latency time.Duration) {
+	kv.mu.RLock()
+	hook := kv.latencyHook
+	kv.mu.RUnlock()
+
+	if hook != nil {
+		hook(event, latency)
+	}
+}
+
+func (kv *InMemoryKVStore) cleanupExpiredKeys() {
+	interval := kv.CleanupInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			start := time.Now()
+			kv.activeExpireCycle()
+			kv.reportLatency(latencyExpireCycle, time.Since(start))
+			kv.syncBackend()
+
+			if next := kv.CleanupInterval(); next != interval {
+				interval = next
+				ticker.Reset(interval)
+			}
 		case <-kv.closeCh:
 			// Store closed, exit the goroutine
 			return
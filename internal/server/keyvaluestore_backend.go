@@ -0,0 +1,352 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/storage"
+)
+
+// With a storage backend, the backend holds every key and the map only the hot ones. Keys missing
+// from the map are loaded from the backend when accessed, changed keys are written back in batches by
+// the cleanup goroutine, and once the map holds more than maxHotKeys keys the least recently used keys
+// already written back are evicted from memory. Values are stored in the backend with the record
+// format of snapshots.
+const (
+	evictionSampleSize = 16          // Keys compared to pick each key to evict, like Redis' maxmemory-samples
+	evictionMaxScan    = 256         // Keys looked at to find the sample, most may not be evictable
+	evictionMinIdle    = time.Second // Keys accessed more recently are never evicted
+	evictionBatchSize  = 1000        // Keys evicted per lock acquisition
+)
+
+// Backs the store with a storage backend holding every key, keeping at most maxHotKeys keys in memory.
+// Keys already in the backend are loaded when first accessed, so the store starts without reading
+// the whole dataset. Changes reach the backend within a cleanup interval and when the store is
+// closed. Snapshots are not supported with a backend since it already persists every key.
+// Must be called before the store is used.
+func (kv *InMemoryKVStore) SetBackend(backend storage.Backend, maxHotKeys int) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.backend = backend
+	kv.maxHotKeys = maxHotKeys
+	for i := range kv.shards {
+		sh := &kv.shards[i]
+		sh.unflushed = make(map[string]struct{}, len(sh.entries))
+		for key := range sh.entries {
+			sh.unflushed[key] = struct{}{}
+		}
+	}
+}
+
+// Registers a hook called with the errors of the storage backend. Failed reads look like missing
+// keys to the commands and failed writes are retried with the next batch, so the hook is the only
+// place they are reported. The hook is called with locks held, from any goroutine, and must not
+// call the store.
+func (kv *InMemoryKVStore) OnBackendError(hook func(err error)) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.backendErrorHook = hook
+}
+
+// Must be called with the lock of a key or the store held.
+func (kv *InMemoryKVStore) backendError(err error) {
+	if kv.backendErrorHook != nil {
+		kv.backendErrorHook(err)
+	}
+}
+
+// Returns the entry stored at key, loading it from the backend if it is not in memory.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) entry(key string) (*Entry, bool) {
+	sh := kv.shard(key)
+	entry, exists := sh.entries[key]
+	if exists {
+		kv.markAccessed(entry)
+		return entry, true
+	}
+	if kv.backend == nil {
+		return nil, false
+	}
+
+	// Keys changed since they were last written to the backend are only current in memory
+	if _, changed := sh.unflushed[key]; changed {
+		return nil, false
+	}
+	if _, changed := sh.flushing[key]; changed {
+		return nil, false
+	}
+
+	data, err := kv.backend.Get([]byte(key))
+	if err != nil {
+		kv.backendError(fmt.Errorf("failed to read key %q: %w", key, err))
+		return nil, false
+	}
+	if data == nil {
+		return nil, false
+	}
+	entry, err = decodeBackendEntry(data)
+	if err != nil {
+		kv.backendError(fmt.Errorf("failed to decode key %q: %w", key, err))
+		return nil, false
+	}
+
+	sh.entries[key] = entry
+	kv.touch(entry)
+	kv.markResized(key)
+	if entry.expiresAt > 0 {
+		sh.expiries.set(key, entry.expiresAt)
+	}
+	if len(entry.fieldExpiresAt) > 0 {
+		sh.fieldExpirable[key] = struct{}{}
+	}
+	if entry.isExpired() {
+		kv.expireKey(key)
+		return nil, false
+	}
+
+	return entry, true
+}
+
+// Loads the keys missing from memory from the backend, for reads made with the read lock.
+func (kv *InMemoryKVStore) loadKeys(keys [][]byte) {
+	if kv.backend == nil {
+		return
+	}
+
+	locked := kv.rlockKeys(keys...)
+	missing := false
+	for _, key := range keys {
+		if _, exists := kv.shard(string(key)).entries[string(key)]; !exists {
+			missing = true
+			break
+		}
+	}
+	kv.runlockKeys(locked)
+	if !missing {
+		return
+	}
+
+	locked = kv.lockKeys(keys...)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return
+	}
+	for _, key := range keys {
+		kv.entry(string(key))
+	}
+}
+
+// Records that the key changed for the next flush to the backend and the next memory estimate. The key
+// is only converted to a string, which allocates, when either of them is enabled.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) markChanged(key []byte) {
+	if kv.backend == nil && kv.maxMemory.Load() <= 0 {
+		return
+	}
+	k := string(key)
+	kv.markUnflushed(k)
+	kv.markResized(k)
+}
+
+// Records that the key changed and must be written to the backend by the next flush.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) markUnflushed(key string) {
+	if kv.backend != nil {
+		kv.shard(key).unflushed[key] = struct{}{}
+	}
+}
+
+// Records an access to an entry, used to pick the keys to evict. Safe to call with the read lock.
+func (kv *InMemoryKVStore) markAccessed(entry *Entry) {
+	if kv.backend != nil || kv.maxMemory.Load() > 0 || kv.lfu.Load() {
+		now := time.Now().UnixNano()
+		entry.countAccess(now)
+		atomic.StoreInt64(&entry.accessed, now)
+	}
+}
+
+// Writes the changed keys to the backend, then evicts cold keys from memory.
+func (kv *InMemoryKVStore) syncBackend() {
+	kv.mu.RLock()
+	backend := kv.backend
+	kv.mu.RUnlock()
+	if backend == nil {
+		return
+	}
+
+	if err := kv.flushBackend(); err != nil {
+		// Evicting now would lose the keys that were not written
+		return
+	}
+	kv.evictColdKeys()
+}
+
+// Writes the keys changed since the last flush to the backend in one batch. The store is only write
+// locked while the changed entries are encoded. Keys that failed to be written are retried by the
+// next flush.
+func (kv *InMemoryKVStore) flushBackend() error {
+	kv.flushMu.Lock()
+	defer kv.flushMu.Unlock()
+
+	kv.mu.Lock()
+	var batch []storage.Write
+	for i := range kv.shards {
+		sh := &kv.shards[i]
+		for key := range sh.unflushed {
+			write := storage.Write{Key: []byte(key)}
+			if entry, exists := sh.entries[key]; exists && !entry.isExpired() {
+				write.Value = encodeBackendEntry(entry)
+			}
+			batch = append(batch, write)
+		}
+		sh.flushing = sh.unflushed
+		sh.unflushed = make(map[string]struct{})
+	}
+	kv.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := kv.backend.Write(batch)
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	for i := range kv.shards {
+		sh := &kv.shards[i]
+		if err != nil {
+			for key := range sh.flushing {
+				sh.unflushed[key] = struct{}{}
+			}
+		}
+		sh.flushing = nil
+	}
+	if err != nil {
+		kv.backendError(fmt.Errorf("failed to write %d keys: %w", len(batch), err))
+	}
+	return err
+}
+
+// Evicts the least recently used keys from memory until at most maxHotKeys are left. Only keys
+// already written to the backend and idle for evictionMinIdle are evicted. The store is write locked
+// by batches.
+func (kv *InMemoryKVStore) evictColdKeys() {
+	for {
+		kv.mu.Lock()
+		now := time.Now().UnixNano()
+		hot := kv.hotKeys()
+
+		evicted := 0
+		found := true
+		for evicted < evictionBatchSize && hot > kv.maxHotKeys {
+			var key string
+			key, found = kv.coldestKey(now)
+			if !found {
+				break
+			}
+
+			sh := kv.shard(key)
+			if sh.entries[key].isExpired() {
+				// Deleted from the backend by the next flush instead of being left there
+				kv.expireKey(key)
+			} else {
+				kv.releaseMemory(sh.entries[key])
+				delete(sh.entries, key)
+				sh.expiries.remove(key)
+				delete(sh.fieldExpirable, key)
+			}
+			hot--
+			evicted++
+		}
+		done := !found || hot <= kv.maxHotKeys || kv.closed
+		kv.mu.Unlock()
+
+		if done {
+			return
+		}
+	}
+}
+
+// Returns the number of keys held in memory.
+// Must be called with the store write locked.
+func (kv *InMemoryKVStore) hotKeys() int {
+	hot := 0
+	for i := range kv.shards {
+		hot += len(kv.shards[i].entries)
+	}
+	return hot
+}
+
+// Returns the least recently used of a sample of the keys that can be evicted, or false if none of
+// the keys looked at can be. Shards and the keys in each shard are looked at from random ones, so
+// every call samples other keys.
+// Must be called with the store write locked.
+func (kv *InMemoryKVStore) coldestKey(now int64) (string, bool) {
+	var coldest string
+	var coldestAccess int64
+	sampled, scanned := 0, 0
+	for sh := range kv.shardsFromRandom() {
+		for key, entry := range sh.entries {
+			if scanned++; scanned > evictionMaxScan || sampled == evictionSampleSize {
+				return coldest, sampled > 0
+			}
+
+			accessed := atomic.LoadInt64(&entry.accessed)
+			if now-accessed < int64(evictionMinIdle) {
+				continue
+			}
+			if _, changed := sh.unflushed[key]; changed {
+				continue
+			}
+			if _, changed := sh.flushing[key]; changed {
+				continue
+			}
+
+			if sampled == 0 || accessed < coldestAccess {
+				coldest, coldestAccess = key, accessed
+			}
+			sampled++
+		}
+	}
+
+	return coldest, sampled > 0
+}
+
+// Writes the remaining changes to the backend and closes it.
+func (kv *InMemoryKVStore) closeBackend() {
+	flushErr := kv.flushBackend()
+	closeErr := kv.backend.Close()
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if flushErr == nil && closeErr != nil {
+		kv.backendError(fmt.Errorf("failed to close backend: %w", closeErr))
+	}
+}
+
+// Encodes an entry as a snapshot record without the key.
+func encodeBackendEntry(entry *Entry) []byte {
+	buf := []byte{byte(entry.kind)}
+	buf = binary.AppendVarint(buf, max(entry.expiresAt, 0))
+	return appendSnapshotValue(buf, entry)
+}
+
+func decodeBackendEntry(data []byte) (*Entry, error) {
+	d := &snapshotDecoder{data: data}
+	entry := &Entry{kind: entryKind(d.byte())}
+	entry.expiresAt = d.varint()
+	d.value(entry)
+	if d.err == nil && len(d.data) > 0 {
+		d.fail()
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return entry, nil
+}
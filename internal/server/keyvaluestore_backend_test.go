@@ -0,0 +1,140 @@
+package server
+
+import (
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/storage"
+)
+
+// Returns a store backed by the BoltDB file at path, keeping at most maxHotKeys keys in memory.
+func newBackedStore(t *testing.T, path string, maxHotKeys int) *InMemoryKVStore {
+	backend, err := storage.OpenBolt(path)
+	if err != nil {
+		t.Fatalf("Failed to open backend: %v", err)
+	}
+
+	store := NewInMemoryKVStore()
+	store.SetBackend(backend, maxHotKeys)
+	store.OnBackendError(func(err error) {
+		t.Errorf("Unexpected backend error: %v", err)
+	})
+	return store
+}
+
+// Writes the changed keys to the backend and evicts every key that can be, as if they had been idle.
+func evictAll(t *testing.T, store *InMemoryKVStore) {
+	if err := store.flushBackend(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	store.mu.Lock()
+	for i := range store.shards {
+		for _, entry := range store.shards[i].entries {
+			atomic.StoreInt64(&entry.accessed, 0)
+		}
+	}
+	store.mu.Unlock()
+	store.evictColdKeys()
+}
+
+func TestBackendEviction(t *testing.T) {
+	store := newBackedStore(t, filepath.Join(t.TempDir(), "data.db"), 1)
+	defer store.Close()
+
+	expiresAt := time.Now().Add(time.Hour).UnixNano()
+	store.Set([]byte("string"), []byte("value"), expiresAt)
+	store.Push([]byte("list"), [][]byte{[]byte("a"), []byte("b")}, false)
+	store.HashSet([]byte("hash"), [][]byte{[]byte("field"), []byte("value")})
+	store.Set([]byte("deleted"), []byte("value"), -1)
+	evictAll(t, store)
+
+	if hot := storedKeys(store); hot != 1 {
+		t.Fatalf("Expected 1 hot key to be left, got %d", hot)
+	}
+
+	// Deleting a cold key deletes it from the backend too
+	if deleted := store.Delete([][]byte{[]byte("deleted")}); deleted != 1 {
+		t.Errorf("Expected the cold key to be deleted, got %d", deleted)
+	}
+	evictAll(t, store)
+
+	if value, _ := store.GetValue([]byte("string")); string(value) != "value" {
+		t.Errorf("Expected the string to be loaded, got %q", value)
+	}
+	if got := store.ExpiresAt([]byte("string")); got != expiresAt {
+		t.Errorf("Expected the expiration to be kept at %d, got %d", expiresAt, got)
+	}
+	if list, _ := store.GetList([]byte("list")); len(list) != 2 || string(list[1]) != "b" {
+		t.Errorf("Expected the list to be loaded, got %q", list)
+	}
+	if value, _ := store.HashGet([]byte("hash"), []byte("field")); string(value) != "value" {
+		t.Errorf("Expected the hash to be loaded, got %q", value)
+	}
+	if exists := store.Exists([][]byte{[]byte("deleted")}); exists != 0 {
+		t.Error("Expected the deleted key not to be loaded again")
+	}
+	if _, err := store.GetValue([]byte("list")); err == nil {
+		t.Error("Expected a wrong type error for a loaded list")
+	}
+}
+
+func TestBackendChangesWhileCold(t *testing.T) {
+	store := newBackedStore(t, filepath.Join(t.TempDir(), "data.db"), 1)
+	defer store.Close()
+
+	store.Set([]byte("counter"), []byte("1"), -1)
+	store.Set([]byte("other"), []byte("value"), -1)
+	store.Set([]byte("expiring"), []byte("value"), time.Now().Add(50*time.Millisecond).UnixNano())
+	evictAll(t, store)
+
+	// A cold key is loaded before being changed, and the change is written back
+	if _, err := store.HashSet([]byte("counter"), [][]byte{[]byte("field"), []byte("value")}); err == nil {
+		t.Error("Expected a wrong type error for a cold string")
+	}
+	store.Set([]byte("counter"), []byte("2"), -1)
+	evictAll(t, store)
+	if value, _ := store.GetValue([]byte("counter")); string(value) != "2" {
+		t.Errorf("Expected the changed value to be loaded, got %q", value)
+	}
+
+	// Keys that expired are deleted from the backend once accessed, even when cold
+	time.Sleep(60 * time.Millisecond)
+	if value, _ := store.GetValue([]byte("expiring")); value != nil {
+		t.Errorf("Expected the expired key not to exist, got %q", value)
+	}
+	if err := store.flushBackend(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	value, err := store.backend.Get([]byte("expiring"))
+	if err != nil || value != nil {
+		t.Errorf("Expected the expired key to be deleted from the backend, got %q (%v)", value, err)
+	}
+}
+
+func TestBackendReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	store := newBackedStore(t, path, 100)
+	store.Set([]byte("greeting"), []byte("hello"), -1)
+	store.SetAdd([]byte("set"), [][]byte{[]byte("x"), []byte("y")})
+	store.StreamAdd([]byte("stream"), [][]byte{[]byte("type"), []byte("login")}, XAddOptions{ID: StreamID{1, 0}, MaxLen: -1})
+	store.StreamGroupCreate([]byte("stream"), []byte("workers"), StreamID{}, false)
+	store.StreamReadGroup([]byte("stream"), []byte("workers"), XReadGroupOptions{Consumer: []byte("alice"), New: true, Count: -1})
+	store.Close()
+
+	// Closing writes the remaining changes, and the new store loads keys as they are accessed
+	reopened := newBackedStore(t, path, 100)
+	defer reopened.Close()
+
+	if value, _ := reopened.GetValue([]byte("greeting")); string(value) != "hello" {
+		t.Errorf("Expected greeting to persist, got %q", value)
+	}
+	if members, _ := reopened.SetLen([]byte("set")); members != 2 {
+		t.Errorf("Expected the set to persist with 2 members, got %d", members)
+	}
+	if summary, _ := reopened.StreamPendingSummary([]byte("stream"), []byte("workers")); summary.Count != 1 {
+		t.Errorf("Expected the pending entry read by the group to persist, got %d", summary.Count)
+	}
+}
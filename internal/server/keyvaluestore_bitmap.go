@@ -0,0 +1,294 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Bitwise operation applied by BitOp.
+type BitOperation uint8
+
+const (
+	BitAnd BitOperation = iota
+	BitOr
+	BitXor
+	BitNot // Takes a single source key
+)
+
+// Integer type of a BITFIELD operation, i1 to i64 or u1 to u63.
+type BitFieldEncoding struct {
+	Signed bool
+	Bits   int
+}
+
+// Behavior of BITFIELD writes when the value does not fit the encoding.
+type BitFieldOverflow uint8
+
+const (
+	OverflowWrap BitFieldOverflow = iota // Wrap around using modular arithmetic
+	OverflowSat                          // Saturate to the minimum or maximum value
+	OverflowFail                         // Skip the write and return nil
+)
+
+type BitFieldOpKind uint8
+
+const (
+	BitFieldGet BitFieldOpKind = iota
+	BitFieldSet
+	BitFieldIncrBy
+)
+
+// A single BITFIELD operation.
+type BitFieldOp struct {
+	Kind     BitFieldOpKind
+	Encoding BitFieldEncoding
+	Offset   uint64 // Offset in bits from the start of the string
+	Value    int64  // The value to set or the increment
+	Overflow BitFieldOverflow
+}
+
+// Result of a BITFIELD operation: the old value for GET and SET, the new value for INCRBY.
+type BitFieldResult struct {
+	Value  int64
+	Failed bool // The write was skipped because it would overflow with OVERFLOW FAIL
+}
+
+// Maximum offset addressable by the bit commands, keeping strings under 512MB.
+const maxBitOffset = 1<<32 - 1
+
+// Returns the smallest and largest values representable by the encoding.
+func (e BitFieldEncoding) limits() (int64, int64) {
+	if !e.Signed {
+		return 0, 1<<e.Bits - 1
+	}
+	if e.Bits == 64 {
+		return math.MinInt64, math.MaxInt64
+	}
+	return -(1 << (e.Bits - 1)), 1<<(e.Bits-1) - 1
+}
+
+// Truncates v to the encoding size, sign extending it for signed encodings.
+func (e BitFieldEncoding) wrap(v uint64) int64 {
+	if e.Bits == 64 {
+		return int64(v)
+	}
+
+	v &= 1<<e.Bits - 1
+	if e.Signed && v&(1<<(e.Bits-1)) != 0 {
+		v |= ^uint64(0) << e.Bits
+	}
+	return int64(v)
+}
+
+// Returns value plus incr handled according to the overflow behavior.
+// Returns false if the result overflows and overflow is OverflowFail.
+func (e BitFieldEncoding) add(value, incr int64, overflow BitFieldOverflow) (int64, bool) {
+	minValue, maxValue := e.limits()
+
+	// Values passed to SET can be out of range even without an increment
+	up := value > maxValue || (incr > 0 && value > maxValue-incr)
+	down := value < minValue
+	if incr < 0 {
+		if e.Signed {
+			down = down || value < minValue-incr
+		} else {
+			down = down || value+incr < 0
+		}
+	}
+
+	if !up && !down {
+		return value + incr, true
+	}
+
+	switch overflow {
+	case OverflowSat:
+		if up {
+			return maxValue, true
+		}
+		return minValue, true
+	case OverflowFail:
+		return 0, false
+	default:
+		return e.wrap(uint64(value) + uint64(incr)), true
+	}
+}
+
+// Reads bits starting at offset, the most significant bit of each byte first. Bits past the end read as 0.
+func getBits(buf []byte, offset uint64, bits int) uint64 {
+	var v uint64 = 0
+	for i := range uint64(bits) {
+		pos := offset + i
+		v <<= 1
+		if pos/8 < uint64(len(buf)) {
+			v |= uint64(buf[pos/8]>>(7-pos%8)) & 1
+		}
+	}
+	return v
+}
+
+// Writes the lowest bits of v starting at offset. The buffer must be large enough.
+func setBits(buf []byte, offset uint64, bits int, v uint64) {
+	for i := range uint64(bits) {
+		pos := offset + i
+		mask := byte(1) << (7 - pos%8)
+		if (v>>(uint64(bits)-1-i))&1 == 1 {
+			buf[pos/8] |= mask
+		} else {
+			buf[pos/8] &^= mask
+		}
+	}
+}
+
+// Returns the string stored at key, or nil if it does not exist.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) stringEntry(key []byte) (*Entry, error) {
+	entry, exists := kv.entry(string(key))
+	if exists && entry.isExpired() {
+		kv.expireKey(string(key))
+		return nil, nil
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	if entry.kind != kindString {
+		return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return kv.mutable(string(key), entry), nil
+}
+
+// Applies a bitwise operation to the strings stored at keys and stores the result at dest, replacing any existing value.
+// Missing keys are treated as strings of zero bytes. Returns the length of the result.
+func (kv *InMemoryKVStore) BitOp(op BitOperation, dest []byte, keys [][]byte) (int64, error) {
+	locked := kv.lockKeys(append([][]byte{dest}, keys...)...)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+
+	sources := make([][]byte, len(keys))
+	length := 0
+	for i, key := range keys {
+		entry, err := kv.stringEntry(key)
+		if err != nil {
+			return 0, err
+		}
+		if entry != nil {
+			sources[i] = entry.value
+			length = max(length, len(entry.value))
+		}
+	}
+
+	result := make([]byte, length)
+	for i := range result {
+		// Bytes past the end of a shorter source are treated as 0
+		var b byte = 0
+		if i < len(sources[0]) {
+			b = sources[0][i]
+		}
+
+		if op == BitNot {
+			result[i] = ^b
+			continue
+		}
+
+		for _, src := range sources[1:] {
+			var other byte = 0
+			if i < len(src) {
+				other = src[i]
+			}
+
+			switch op {
+			case BitAnd:
+				b &= other
+			case BitOr:
+				b |= other
+			case BitXor:
+				b ^= other
+			}
+		}
+		result[i] = b
+	}
+
+	_, destExists := kv.entry(string(dest))
+	kv.deleteKey(string(dest))
+	if length > 0 {
+		kv.addKey(string(dest), NewValueEntry(result, -1))
+		kv.notify(EventString, "set", dest)
+	} else if destExists {
+		kv.notify(EventGeneric, "del", dest)
+	}
+
+	return int64(length), nil
+}
+
+// Reads and writes integers at arbitrary bit offsets of the string stored at key.
+// The string is created or zero padded as needed by writes, reads past the end return 0.
+func (kv *InMemoryKVStore) BitField(key []byte, ops []BitFieldOp) ([]BitFieldResult, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.stringEntry(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var value []byte
+	if entry != nil {
+		value = entry.value
+	}
+
+	written := false
+	results := make([]BitFieldResult, len(ops))
+	for i, op := range ops {
+		bits := op.Encoding.Bits
+		old := op.Encoding.wrap(getBits(value, op.Offset, bits))
+
+		var updated int64
+		var ok bool
+		switch op.Kind {
+		case BitFieldGet:
+			results[i].Value = old
+			continue
+		case BitFieldSet:
+			updated, ok = op.Encoding.add(op.Value, 0, op.Overflow)
+			results[i].Value = old
+		case BitFieldIncrBy:
+			updated, ok = op.Encoding.add(old, op.Value, op.Overflow)
+			results[i].Value = updated
+		}
+
+		if !ok {
+			results[i] = BitFieldResult{Failed: true}
+			continue
+		}
+
+		if !written {
+			// Written to a copy, see Entry.value
+			value = slices.Clone(value)
+			written = true
+		}
+		if needed := (op.Offset + uint64(bits) + 7) / 8; needed > uint64(len(value)) {
+			value = append(value, make([]byte, needed-uint64(len(value)))...)
+		}
+		setBits(value, op.Offset, bits, uint64(updated))
+	}
+
+	if written {
+		if entry == nil {
+			kv.addKey(string(key), NewValueEntry(value, -1))
+		} else {
+			entry.value = value
+			kv.touch(entry)
+		}
+		kv.notify(EventString, "setbit", key)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBitOp(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.Set([]byte("a"), []byte{0b1100, 0xff}, -1)
+	store.Set([]byte("b"), []byte{0b1010}, -1)
+
+	tests := []struct {
+		name string
+		op   BitOperation
+		keys []string
+		want []byte
+	}{
+		{"and", BitAnd, []string{"a", "b"}, []byte{0b1000, 0}},
+		{"or", BitOr, []string{"a", "b"}, []byte{0b1110, 0xff}},
+		{"xor", BitXor, []string{"a", "b"}, []byte{0b0110, 0xff}},
+		{"not", BitNot, []string{"b"}, []byte{0xf5}},
+		{"missing key", BitAnd, []string{"a", "missing"}, []byte{0, 0}},
+	}
+
+	for _, tt := range tests {
+		keys := make([][]byte, len(tt.keys))
+		for i, key := range tt.keys {
+			keys[i] = []byte(key)
+		}
+
+		length, err := store.BitOp(tt.op, []byte("dest"), keys)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if length != int64(len(tt.want)) {
+			t.Errorf("%s: expected length %d, got %d", tt.name, len(tt.want), length)
+		}
+
+		value, _ := store.GetValue([]byte("dest"))
+		if string(value) != string(tt.want) {
+			t.Errorf("%s: expected %08b, got %08b", tt.name, tt.want, value)
+		}
+	}
+
+	// An empty result deletes the destination
+	store.BitOp(BitOr, []byte("dest"), [][]byte{[]byte("missing")})
+	if store.Exists([][]byte{[]byte("dest")}) != 0 {
+		t.Error("Expected empty result to delete the destination")
+	}
+
+	store.HashSet([]byte("hash"), [][]byte{[]byte("f"), []byte("v")})
+	if _, err := store.BitOp(BitAnd, []byte("dest"), [][]byte{[]byte("a"), []byte("hash")}); err == nil {
+		t.Error("Expected error when using a hash as source")
+	}
+}
+
+func TestBitField(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("counters")
+	i8 := BitFieldEncoding{Signed: true, Bits: 8}
+	u4 := BitFieldEncoding{Bits: 4}
+
+	results, err := store.BitField(key, []BitFieldOp{
+		{Kind: BitFieldSet, Encoding: i8, Offset: 0, Value: 100},
+		{Kind: BitFieldGet, Encoding: u4, Offset: 0},
+		{Kind: BitFieldIncrBy, Encoding: i8, Offset: 0, Value: 100},
+		{Kind: BitFieldIncrBy, Encoding: u4, Offset: 12, Value: 20, Overflow: OverflowSat},
+		{Kind: BitFieldIncrBy, Encoding: u4, Offset: 12, Value: 1, Overflow: OverflowFail},
+		{Kind: BitFieldSet, Encoding: u4, Offset: 8, Value: -1, Overflow: OverflowSat},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := []BitFieldResult{{Value: 0}, {Value: 6}, {Value: -56}, {Value: 15}, {Failed: true}, {Value: 0}}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("Result %d: expected %+v, got %+v", i, want[i], results[i])
+		}
+	}
+
+	value, _ := store.GetValue(key)
+	if string(value) != string([]byte{0xc8, 0x0f}) {
+		t.Errorf("Unexpected value %x", value)
+	}
+
+	// Reads past the end return 0 without creating the key
+	results, _ = store.BitField([]byte("missing"), []BitFieldOp{{Kind: BitFieldGet, Encoding: i8, Offset: 64}})
+	if results[0].Value != 0 || store.Exists([][]byte{[]byte("missing")}) != 0 {
+		t.Error("Expected reads not to create the key")
+	}
+}
+
+func TestBitFieldEncodingAdd(t *testing.T) {
+	i8 := BitFieldEncoding{Signed: true, Bits: 8}
+	u8 := BitFieldEncoding{Bits: 8}
+	i64 := BitFieldEncoding{Signed: true, Bits: 64}
+	u63 := BitFieldEncoding{Bits: 63}
+
+	tests := []struct {
+		name     string
+		enc      BitFieldEncoding
+		value    int64
+		incr     int64
+		overflow BitFieldOverflow
+		want     int64
+		ok       bool
+	}{
+		{"i8 in range", i8, 100, 27, OverflowWrap, 127, true},
+		{"i8 wrap up", i8, 127, 1, OverflowWrap, -128, true},
+		{"i8 wrap down", i8, -128, -1, OverflowWrap, 127, true},
+		{"i8 sat up", i8, 100, 100, OverflowSat, 127, true},
+		{"i8 sat down", i8, -100, -100, OverflowSat, -128, true},
+		{"i8 fail", i8, 127, 1, OverflowFail, 0, false},
+		{"u8 wrap", u8, 255, 2, OverflowWrap, 1, true},
+		{"u8 sat below zero", u8, 1, -2, OverflowSat, 0, true},
+		{"u8 set out of range", u8, 300, 0, OverflowWrap, 44, true},
+		{"i64 wrap", i64, math.MaxInt64, 1, OverflowWrap, math.MinInt64, true},
+		{"i64 sat down", i64, math.MinInt64, -1, OverflowSat, math.MinInt64, true},
+		{"u63 min increment", u63, 5, math.MinInt64, OverflowSat, 0, true},
+		{"u63 sat up", u63, math.MaxInt64, 1, OverflowSat, math.MaxInt64, true},
+	}
+
+	for _, tt := range tests {
+		got, ok := tt.enc.add(tt.value, tt.incr, tt.overflow)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: expected (%d, %v), got (%d, %v)", tt.name, tt.want, tt.ok, got, ok)
+		}
+	}
+}
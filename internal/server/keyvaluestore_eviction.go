@@ -0,0 +1,334 @@
+package server
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// Keys evicted once the memory used by the keys exceeds the maxmemory limit, using the values of
+// Redis' maxmemory-policy setting.
+type EvictionPolicy uint8
+
+const (
+	NoEviction  EvictionPolicy = iota // Nothing is evicted, commands that add data are rejected instead
+	AllKeysLRU                        // The least recently used keys are evicted
+	VolatileLRU                       // The least recently used keys with an expiration time are evicted
+	AllKeysLFU                        // The least frequently used keys are evicted
+	VolatileLFU                       // The least frequently used keys with an expiration time are evicted
+)
+
+// Parses a maxmemory-policy value: noeviction, allkeys-lru, volatile-lru, allkeys-lfu or volatile-lfu.
+func ParseEvictionPolicy(policy string) (EvictionPolicy, error) {
+	switch policy {
+	case "noeviction":
+		return NoEviction, nil
+	case "allkeys-lru":
+		return AllKeysLRU, nil
+	case "volatile-lru":
+		return VolatileLRU, nil
+	case "allkeys-lfu":
+		return AllKeysLFU, nil
+	case "volatile-lfu":
+		return VolatileLFU, nil
+	default:
+		return 0, fmt.Errorf("invalid eviction policy %q, expected noeviction, allkeys-lru, volatile-lru, allkeys-lfu or volatile-lfu", policy)
+	}
+}
+
+func (p EvictionPolicy) String() string {
+	switch p {
+	case AllKeysLRU:
+		return "allkeys-lru"
+	case VolatileLRU:
+		return "volatile-lru"
+	case AllKeysLFU:
+		return "allkeys-lfu"
+	case VolatileLFU:
+		return "volatile-lfu"
+	default:
+		return "noeviction"
+	}
+}
+
+// Reports whether the policy evicts the keys accessed the least often rather than the least recently.
+func (p EvictionPolicy) lfu() bool {
+	return p == AllKeysLFU || p == VolatileLFU
+}
+
+// The LFU policies count the accesses to each key with a logarithmic counter, like Redis: the
+// counter goes up to 255 and is incremented with a probability that falls as it grows, so keys
+// accessed a few times can be told apart from keys accessed millions of times. The counter is
+// decremented for every minute without access, so keys that are no longer hot are evicted too.
+const (
+	lfuInitValue = 5           // Counter of new keys, so they are not evicted before being accessed again
+	lfuLogFactor = 10          // Higher factors need more accesses to increment the counter
+	lfuDecayTime = time.Minute // Time without access that decrements the counter by one
+	lfuMaxValue  = 255
+)
+
+// Returns the access counter of the entry, decremented for the time since the last access.
+func (e *Entry) frequency(now int64) int {
+	accessed := atomic.LoadInt64(&e.accessed)
+	if accessed == 0 {
+		return lfuInitValue
+	}
+
+	counter := int64(atomic.LoadUint32(&e.counter))
+	return int(max(counter-(now-accessed)/int64(lfuDecayTime), 0))
+}
+
+// Counts an access in the counter returned by frequency. Must be called before the access time is
+// updated. Concurrent accesses may be counted once, which the counter is too coarse to notice.
+func (e *Entry) countAccess(now int64) {
+	counter := e.frequency(now)
+	if counter < lfuMaxValue {
+		base := max(counter-lfuInitValue, 0)
+		if rand.Float64() < 1/float64(base*lfuLogFactor+1) {
+			counter++
+		}
+	}
+	atomic.StoreUint32(&e.counter, uint32(counter))
+}
+
+// Elements walked to estimate the size of a collection when the memory used is tracked, the size of
+// the other elements is extrapolated from them.
+const memorySamples = 16
+
+// Limits the approximate memory used by the keys, as reported by MEMORY USAGE, to maxMemory bytes.
+// Once the limit is exceeded, FreeMemory evicts keys following the policy. 0 removes the limit.
+// Setting a limit counts the memory used by every key, which holds the lock for a while on large
+// datasets; changing it afterwards is cheap.
+func (kv *InMemoryKVStore) SetMaxMemory(maxMemory int64) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if kv.maxMemory.Load() == 0 && maxMemory > 0 {
+		kv.countMemory()
+	}
+	kv.maxMemory.Store(maxMemory)
+}
+
+// Returns the limit set by SetMaxMemory.
+func (kv *InMemoryKVStore) MaxMemory() int64 {
+	return kv.maxMemory.Load()
+}
+
+// Sets the keys evicted once the maxmemory limit is exceeded, noeviction by default. The access
+// frequencies of the LFU policies are tracked even without a limit, see AccessFrequency.
+func (kv *InMemoryKVStore) SetEvictionPolicy(policy EvictionPolicy) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.evictionPolicy = policy
+	kv.lfu.Store(policy.lfu())
+}
+
+// Returns the access counter of a key used by the LFU policies, without counting an access. Returns
+// false if the key does not exist.
+func (kv *InMemoryKVStore) AccessFrequency(key []byte) (int, bool) {
+	kv.loadKeys([][]byte{key})
+
+	locked := kv.rlockKeys(key)
+	defer kv.runlockKeys(locked)
+
+	entry, exists := kv.shard(string(key)).entries[string(key)]
+	if !exists || entry.isExpired() {
+		return 0, false
+	}
+	return entry.frequency(time.Now().UnixNano()), true
+}
+
+// Returns the policy set by SetEvictionPolicy.
+func (kv *InMemoryKVStore) EvictionPolicy() EvictionPolicy {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	return kv.evictionPolicy
+}
+
+// Returns the approximate memory used by the keys while a maxmemory limit is set, and the number of
+// keys evicted to stay under it.
+func (kv *InMemoryKVStore) MemoryStats() (used, evicted int64) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if kv.maxMemory.Load() == 0 {
+		return 0, kv.evicted
+	}
+	kv.updateMemory()
+	return kv.usedMemory.Load(), kv.evicted
+}
+
+// Counts the memory used by every key from scratch. Keys whose accesses were not tracked yet are
+// considered accessed now.
+// Must be called with the store write locked.
+func (kv *InMemoryKVStore) countMemory() {
+	now := time.Now().UnixNano()
+	var used int64
+	for i := range kv.shards {
+		sh := &kv.shards[i]
+		for key, entry := range sh.entries {
+			entry.accounted = entry.estimateMemoryUsage(key)
+			used += entry.accounted
+			if atomic.LoadInt64(&entry.accessed) == 0 {
+				entry.countAccess(now)
+				atomic.StoreInt64(&entry.accessed, now)
+			}
+		}
+		sh.resized = make(map[string]struct{})
+	}
+	kv.usedMemory.Store(used)
+}
+
+// Records that a key was written, so its size is estimated again by the next updateMemory.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) markResized(key string) {
+	if kv.maxMemory.Load() > 0 {
+		kv.shard(key).resized[key] = struct{}{}
+	}
+}
+
+// Stops counting the memory of an entry removed from the store.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) releaseMemory(entry *Entry) {
+	kv.usedMemory.Add(-entry.accounted)
+	entry.accounted = 0
+}
+
+// Estimates again the size of the keys written since the last call.
+// Must be called with the store write locked.
+func (kv *InMemoryKVStore) updateMemory() {
+	for i := range kv.shards {
+		sh := &kv.shards[i]
+		for key := range sh.resized {
+			if entry, exists := sh.entries[key]; exists {
+				size := entry.estimateMemoryUsage(key)
+				kv.usedMemory.Add(size - entry.accounted)
+				entry.accounted = size
+			}
+		}
+		clear(sh.resized)
+	}
+}
+
+// Evicts keys following the eviction policy until the memory used by the keys is under the
+// maxmemory limit, like Redis does before running a command. Returns false if the limit is still
+// exceeded, because the policy is noeviction or no key can be evicted. The store is write locked by
+// batches.
+func (kv *InMemoryKVStore) FreeMemory() bool {
+	limit := kv.maxMemory.Load()
+	if limit == 0 {
+		return true
+	}
+
+	for {
+		kv.mu.Lock()
+		if kv.closed {
+			kv.mu.Unlock()
+			return true
+		}
+		kv.updateMemory()
+
+		evicted := 0
+		for evicted < evictionBatchSize && kv.usedMemory.Load() > limit {
+			key, found := kv.evictionCandidate()
+			if !found {
+				kv.mu.Unlock()
+				return false
+			}
+
+			if kv.shard(key).entries[key].isExpired() && !kv.passiveExpiry {
+				kv.expireKey(key)
+			} else {
+				kv.evictKey(key)
+			}
+			kv.updateMemory()
+			evicted++
+		}
+		done := kv.usedMemory.Load() <= limit
+		kv.mu.Unlock()
+
+		if done {
+			return true
+		}
+	}
+}
+
+// Returns the key to evict next under the eviction policy, the least recently or frequently used of
+// a sample of the keys, or false if there is none. Shards and the keys in each shard are looked at
+// from random ones, so every call samples other keys.
+// Must be called with the store write locked.
+func (kv *InMemoryKVStore) evictionCandidate() (string, bool) {
+	now := time.Now().UnixNano()
+	var candidate string
+	var candidateScore int64
+	sampled := 0
+	consider := func(key string, entry *Entry) bool {
+		score := atomic.LoadInt64(&entry.accessed)
+		if kv.evictionPolicy.lfu() {
+			score = int64(entry.frequency(now))
+		}
+		if sampled == 0 || score < candidateScore {
+			candidate, candidateScore = key, score
+		}
+		sampled++
+		return sampled < evictionSampleSize
+	}
+
+	for sh := range kv.shardsFromRandom() {
+		switch kv.evictionPolicy {
+		case AllKeysLRU, AllKeysLFU:
+			for key, entry := range sh.entries {
+				if !consider(key, entry) {
+					return candidate, true
+				}
+			}
+		case VolatileLRU, VolatileLFU:
+			for key := range sh.expiries.byKey {
+				if !consider(key, sh.entries[key]) {
+					return candidate, true
+				}
+			}
+		default:
+			return candidate, false
+		}
+	}
+
+	return candidate, sampled > 0
+}
+
+// Removes a key to free memory and queues an evicted event for the hooks. Evictions are recorded as
+// removals, so replicas and the append-only file delete the key too.
+// Must be called with the store write locked.
+func (kv *InMemoryKVStore) evictKey(key string) {
+	kv.deleteKey(key)
+	kv.evicted++
+	kv.notify(EventEvicted, "evicted", []byte(key))
+	kv.recordRemoval(Removal{Key: []byte(key)})
+}
+
+// Stores that limit the memory used by their keys, see InMemoryKVStore.FreeMemory.
+type memoryLimiter interface {
+	FreeMemory() bool
+	MemoryStats() (used, evicted int64)
+}
+
+// Reports whether a command must be rejected because the maxmemory limit is exceeded and no key can
+// be evicted. Only commands that may add data are rejected, like Redis' denyoom commands. Replicas
+// leave evictions to their primary and commands of the primary are always applied.
+// Must be called from the server loop.
+func (s *Server) rejectsForMemory(msg Message) bool {
+	limiter, ok := s.store.(memoryLimiter)
+	if !ok || s.link != nil || msg.client.primary || len(msg.args) == 0 {
+		return false
+	}
+	if limiter.FreeMemory() {
+		return false
+	}
+
+	info, known := findCommandInfo(string(msg.args[0]))
+	return known && slices.Contains(info.flags, "denyoom")
+}
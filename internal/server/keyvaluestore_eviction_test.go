@@ -0,0 +1,214 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+func TestParseEvictionPolicy(t *testing.T) {
+	for _, name := range []string{"noeviction", "allkeys-lru", "volatile-lru", "allkeys-lfu", "volatile-lfu"} {
+		policy, err := ParseEvictionPolicy(name)
+		if err != nil || policy.String() != name {
+			t.Errorf("Expected %s to round trip, got %s (%v)", name, policy, err)
+		}
+	}
+	if _, err := ParseEvictionPolicy("random"); err == nil {
+		t.Error("Expected an unknown policy to be rejected")
+	}
+}
+
+func TestMemoryAccounting(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.Set([]byte("before"), make([]byte, 100), -1)
+	store.SetMaxMemory(1 << 30)
+	expected, _ := store.MemoryUsage([]byte("before"))
+	if used, _ := store.MemoryStats(); used != expected {
+		t.Errorf("Expected the keys written before the limit to be counted, got %d instead of %d", used, expected)
+	}
+
+	store.Set([]byte("string"), make([]byte, 1000), -1)
+	store.Set([]byte("string"), make([]byte, 10), -1)
+	for i := range 1000 {
+		store.Push([]byte("list"), [][]byte{make([]byte, 100)}, false)
+		if i == 0 {
+			store.Delete([][]byte{[]byte("before")})
+		}
+	}
+
+	stringSize, _ := store.MemoryUsage([]byte("string"))
+	listSize, _ := store.MemoryUsage([]byte("list"))
+	if used, _ := store.MemoryStats(); used != stringSize+listSize {
+		t.Errorf("Expected the memory used to follow overwrites, deletes and pushes, got %d instead of %d", used, stringSize+listSize)
+	}
+}
+
+func TestFreeMemory(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+	store.SetMaxMemory(1 << 30)
+
+	for i := range 100 {
+		store.Set([]byte(fmt.Sprintf("key%d", i)), make([]byte, 1000), -1)
+	}
+	time.Sleep(time.Millisecond)
+	store.GetValue([]byte("key0"))
+	used, _ := store.MemoryStats()
+
+	store.SetMaxMemory(used / 2)
+	if store.FreeMemory() {
+		t.Error("Expected nothing to be evicted with noeviction")
+	}
+
+	store.SetEvictionPolicy(VolatileLRU)
+	if store.FreeMemory() {
+		t.Error("Expected nothing to be evicted without keys with an expiration time")
+	}
+
+	store.SetEvictionPolicy(AllKeysLRU)
+	if !store.FreeMemory() {
+		t.Fatal("Expected keys to be evicted")
+	}
+	used, evicted := store.MemoryStats()
+	if used > store.MaxMemory() || evicted < 50 {
+		t.Errorf("Expected at least 50 keys to be evicted to get under the limit, got %d evicted and %d bytes used", evicted, used)
+	}
+	if value, _ := store.GetValue([]byte("key0")); value == nil {
+		t.Error("Expected the most recently used key to be kept")
+	}
+
+	// Only keys with an expiration time are evicted with volatile-lru
+	store.SetEvictionPolicy(VolatileLRU)
+	store.Set([]byte("volatile"), make([]byte, 1000), time.Now().Add(time.Hour).UnixNano())
+	store.Set([]byte("persistent"), make([]byte, 1000), -1)
+	store.FreeMemory()
+	if value, _ := store.GetValue([]byte("volatile")); value != nil {
+		t.Error("Expected the key with an expiration time to be evicted")
+	}
+	if value, _ := store.GetValue([]byte("persistent")); value == nil {
+		t.Error("Expected the key without an expiration time to be kept")
+	}
+}
+
+func TestAccessFrequency(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+	store.SetEvictionPolicy(AllKeysLFU)
+
+	store.Set([]byte("cold"), []byte("value"), -1)
+	store.Set([]byte("hot"), []byte("value"), -1)
+	cold, _ := store.AccessFrequency([]byte("cold"))
+	if cold != lfuInitValue+1 {
+		t.Errorf("Expected a new key to start at %d, got %d", lfuInitValue+1, cold)
+	}
+
+	for range 10000 {
+		store.GetValue([]byte("hot"))
+	}
+	hot, _ := store.AccessFrequency([]byte("hot"))
+	if hot <= cold+5 || hot >= lfuMaxValue {
+		t.Errorf("Expected the counter to grow logarithmically with 10000 accesses, got %d", hot)
+	}
+	if again, _ := store.AccessFrequency([]byte("hot")); again != hot {
+		t.Errorf("Expected AccessFrequency not to count as an access, got %d then %d", hot, again)
+	}
+
+	// The counter is decremented for every minute without access
+	entry, _ := storedEntry(store, "hot")
+	atomic.AddInt64(&entry.accessed, -int64(3*lfuDecayTime))
+	if decayed, _ := store.AccessFrequency([]byte("hot")); decayed != hot-3 {
+		t.Errorf("Expected the counter to decay to %d, got %d", hot-3, decayed)
+	}
+
+	if _, ok := store.AccessFrequency([]byte("missing")); ok {
+		t.Error("Expected a missing key to have no frequency")
+	}
+}
+
+func TestFreeMemoryLFU(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+	store.SetEvictionPolicy(AllKeysLFU)
+	store.SetMaxMemory(1 << 30)
+
+	for i := range 100 {
+		store.Set([]byte(fmt.Sprintf("key%d", i)), make([]byte, 1000), -1)
+	}
+	// The oldest key is the most frequently used
+	for range 1000 {
+		store.GetValue([]byte("key0"))
+	}
+	store.Set([]byte("latest"), make([]byte, 1000), -1)
+
+	used, _ := store.MemoryStats()
+	store.SetMaxMemory(used / 2)
+	if !store.FreeMemory() {
+		t.Fatal("Expected keys to be evicted")
+	}
+	if value, _ := store.GetValue([]byte("key0")); value == nil {
+		t.Error("Expected the most frequently used key to be kept")
+	}
+}
+
+func TestMaxMemoryCommands(t *testing.T) {
+	_, addr := startTestServer(t)
+	client := dialTestServer(t, addr)
+	ok := resp.RespSimpleString{Value: "OK"}
+
+	client.do("SET", "key", strings.Repeat("x", 1000))
+	if val := client.do("CONFIG", "SET", "maxmemory", "1kb"); val != ok {
+		t.Fatalf("Expected CONFIG SET maxmemory to succeed, got %v", val)
+	}
+	if val, _ := client.do("SET", "other", "value").(resp.RespErrorValue); !strings.HasPrefix(val.Message, "OOM") {
+		t.Errorf("Expected writes to be rejected with noeviction, got %v", val)
+	}
+	if val, _ := client.do("GET", "key").(resp.RespBulkString); len(val.Value) != 1000 {
+		t.Errorf("Expected reads to still be served, got %v", val)
+	}
+	if val, _ := client.do("DEL", "key").(resp.RespInteger); val.Value != 1 {
+		t.Errorf("Expected deletes to still be served, got %v", val)
+	}
+
+	client.do("SET", "key", strings.Repeat("x", 1000))
+	if val := client.do("CONFIG", "SET", "maxmemory-policy", "allkeys-lru"); val != ok {
+		t.Fatalf("Expected CONFIG SET maxmemory-policy to succeed, got %v", val)
+	}
+	if val := client.do("SET", "other", "value"); val != ok {
+		t.Errorf("Expected the write to evict a key, got %v", val)
+	}
+	if val, _ := client.do("EXISTS", "key").(resp.RespInteger); val.Value != 0 {
+		t.Error("Expected the big key to be evicted")
+	}
+
+	if val, _ := client.do("CONFIG", "SET", "maxmemory-policy", "random").(resp.RespErrorValue); val.Message == "" {
+		t.Error("Expected an unknown policy to be rejected")
+	}
+}
+
+func TestObjectCommand(t *testing.T) {
+	_, addr := startTestServer(t)
+	client := dialTestServer(t, addr)
+
+	client.do("SET", "key", "value")
+	if val, _ := client.do("OBJECT", "FREQ", "key").(resp.RespErrorValue); !strings.Contains(val.Message, "LFU") {
+		t.Errorf("Expected OBJECT FREQ to require an LFU policy, got %v", val)
+	}
+
+	client.do("CONFIG", "SET", "maxmemory-policy", "volatile-lfu")
+	client.do("SET", "key", "value")
+	if val, _ := client.do("OBJECT", "FREQ", "key").(resp.RespInteger); val.Value != lfuInitValue+1 {
+		t.Errorf("Expected the counter of a new key, got %v", val)
+	}
+	if val, _ := client.do("OBJECT", "FREQ", "missing").(resp.RespBulkString); val.Value != nil {
+		t.Errorf("Expected a null reply for a missing key, got %v", val)
+	}
+	if val, _ := client.do("OBJECT", "HELP").(resp.RespErrorValue); !strings.Contains(val.Message, "unknown subcommand") {
+		t.Errorf("Expected an unknown subcommand to be rejected, got %v", val)
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// A sorted set member with its location.
+type GeoMember struct {
+	Member []byte
+	Point  GeoPoint
+}
+
+// Order of GeoSearch results by distance from the center.
+type GeoSort uint8
+
+const (
+	GeoSortNone GeoSort = iota
+	GeoSortAsc
+	GeoSortDesc
+)
+
+// Area and options of GeoSearch. Distances are in meters.
+type GeoQuery struct {
+	Member []byte   // Search around the location of this member if not nil
+	Center GeoPoint // Search around this location if Member is nil
+
+	ByBox  bool    // Search a rectangle of Width by Height instead of a circle of Radius
+	Radius float64 // Radius of the circle
+	Width  float64 // Width of the rectangle
+	Height float64 // Height of the rectangle
+
+	Sort  GeoSort
+	Count int  // Maximum number of results, 0 means no limit
+	Any   bool // Return the first Count matches found instead of the nearest ones
+}
+
+// A member found by GeoSearch.
+type GeoResult struct {
+	Member []byte
+	Dist   float64 // Distance from the center in meters
+	Hash   uint64  // The geohash stored as the score of the member
+	Point  GeoPoint
+}
+
+// Adds members with their locations to the sorted set stored at key, using geohashes as scores.
+func (kv *InMemoryKVStore) GeoAdd(key []byte, members []GeoMember, opt ZAddOptions) (int64, error) {
+	items := make([]ScoredMember, len(members))
+	for i, m := range members {
+		if !m.Point.valid() {
+			return 0, fmt.Errorf("invalid longitude,latitude pair %f,%f", m.Point.Lon, m.Point.Lat)
+		}
+		items[i] = ScoredMember{Member: m.Member, Score: m.Point.score()}
+	}
+
+	return kv.SortedSetAdd(key, items, opt)
+}
+
+// Returns the distance in meters between two members of the sorted set stored at key.
+// Returns false if either member does not exist.
+func (kv *InMemoryKVStore) GeoDist(key, member1, member2 []byte) (float64, bool, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, false, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.sortedSetEntry(key, false)
+	if err != nil || entry == nil {
+		return 0, false, err
+	}
+
+	score1, ok1 := entry.zset.score(string(member1))
+	score2, ok2 := entry.zset.score(string(member2))
+	if !ok1 || !ok2 {
+		return 0, false, nil
+	}
+
+	return geoDistance(geohashDecode(score1), geohashDecode(score2)), true, nil
+}
+
+// Returns the members of the sorted set stored at key located within the area of the query.
+// Only the geohash cells around the center are scanned.
+func (kv *InMemoryKVStore) GeoSearch(key []byte, q GeoQuery) ([]GeoResult, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.sortedSetEntry(key, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		if q.Member != nil {
+			return nil, fmt.Errorf("could not decode requested zset member")
+		}
+		return nil, nil
+	}
+
+	center := q.Center
+	if q.Member != nil {
+		score, exists := entry.zset.score(string(q.Member))
+		if !exists {
+			return nil, fmt.Errorf("could not decode requested zset member")
+		}
+		center = geohashDecode(score)
+	}
+
+	halfWidth, halfHeight := q.Radius, q.Radius
+	if q.ByBox {
+		halfWidth, halfHeight = q.Width/2, q.Height/2
+	}
+
+	var results []GeoResult
+search:
+	for _, r := range geoSearchRanges(center, halfWidth, halfHeight) {
+		for _, m := range entry.zset.rangeBy(r, false, 0, -1) {
+			point := geohashDecode(m.Score)
+
+			dist, ok := geoWithin(center, point, q, halfWidth, halfHeight)
+			if !ok {
+				continue
+			}
+
+			results = append(results, GeoResult{Member: m.Member, Dist: dist, Hash: uint64(m.Score), Point: point})
+			if q.Any && q.Count > 0 && len(results) == q.Count {
+				break search
+			}
+		}
+	}
+
+	// A limit without ANY returns the nearest members
+	sortOrder := q.Sort
+	if sortOrder == GeoSortNone && q.Count > 0 && !q.Any {
+		sortOrder = GeoSortAsc
+	}
+
+	switch sortOrder {
+	case GeoSortAsc:
+		slices.SortFunc(results, func(a, b GeoResult) int { return cmp.Compare(a.Dist, b.Dist) })
+	case GeoSortDesc:
+		slices.SortFunc(results, func(a, b GeoResult) int { return cmp.Compare(b.Dist, a.Dist) })
+	}
+
+	if q.Count > 0 && len(results) > q.Count {
+		results = results[:q.Count]
+	}
+
+	return results, nil
+}
+
+// Returns the distance of the point from the center and whether it is within the area of the query.
+func geoWithin(center, point GeoPoint, q GeoQuery, halfWidth, halfHeight float64) (float64, bool) {
+	if !q.ByBox {
+		dist := geoDistance(center, point)
+		return dist, dist <= q.Radius
+	}
+
+	// The horizontal distance is measured along the latitude of the point
+	if geoDistance(GeoPoint{Lon: center.Lon, Lat: point.Lat}, point) > halfWidth {
+		return 0, false
+	}
+	if geoDistance(center, GeoPoint{Lon: center.Lon, Lat: point.Lat}) > halfHeight {
+		return 0, false
+	}
+
+	return geoDistance(center, point), true
+}
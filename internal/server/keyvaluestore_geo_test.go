@@ -0,0 +1,89 @@
+package server
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGeoAddAndDist(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("sicily")
+	added, err := store.GeoAdd(key, []GeoMember{
+		{Member: []byte("Palermo"), Point: GeoPoint{13.361389, 38.115556}},
+		{Member: []byte("Catania"), Point: GeoPoint{15.087269, 37.502669}},
+	}, ZAddOptions{})
+	if err != nil || added != 2 {
+		t.Fatalf("Expected 2 members added, got %d (err=%v)", added, err)
+	}
+
+	dist, ok, _ := store.GeoDist(key, []byte("Palermo"), []byte("Catania"))
+	if !ok || math.Abs(dist-166274.1516) > 0.001 {
+		t.Errorf("Expected 166274.1516 meters, got %f", dist)
+	}
+
+	if _, ok, _ := store.GeoDist(key, []byte("Palermo"), []byte("missing")); ok {
+		t.Error("Expected no distance for a missing member")
+	}
+
+	if _, err := store.GeoAdd(key, []GeoMember{{Member: []byte("pole"), Point: GeoPoint{0, 89}}}, ZAddOptions{}); err == nil {
+		t.Error("Expected error for a latitude outside the projection")
+	}
+}
+
+func TestGeoSearch(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("sicily")
+	store.GeoAdd(key, []GeoMember{
+		{Member: []byte("Palermo"), Point: GeoPoint{13.361389, 38.115556}},
+		{Member: []byte("Catania"), Point: GeoPoint{15.087269, 37.502669}},
+		{Member: []byte("Agrigento"), Point: GeoPoint{13.583333, 37.316667}},
+		{Member: []byte("Rome"), Point: GeoPoint{12.496366, 41.902782}},
+	}, ZAddOptions{})
+
+	center := GeoPoint{15, 37}
+	tests := []struct {
+		name string
+		q    GeoQuery
+		want []string
+	}{
+		{"radius ascending", GeoQuery{Center: center, Radius: 200_000, Sort: GeoSortAsc}, []string{"Catania", "Agrigento", "Palermo"}},
+		{"radius descending", GeoQuery{Center: center, Radius: 200_000, Sort: GeoSortDesc}, []string{"Palermo", "Agrigento", "Catania"}},
+		{"count sorts by distance", GeoQuery{Center: center, Radius: 200_000, Count: 2}, []string{"Catania", "Agrigento"}},
+		{"small radius", GeoQuery{Center: center, Radius: 100_000}, []string{"Catania"}},
+		{"from member", GeoQuery{Member: []byte("Palermo"), Radius: 1000}, []string{"Palermo"}},
+		{"box", GeoQuery{Center: center, ByBox: true, Width: 400_000, Height: 300_000, Sort: GeoSortAsc}, []string{"Catania", "Agrigento", "Palermo"}},
+		{"narrow box", GeoQuery{Center: center, ByBox: true, Width: 400_000, Height: 100_000, Sort: GeoSortAsc}, []string{"Agrigento"}},
+		{"far away", GeoQuery{Center: GeoPoint{-70, -30}, Radius: 500_000}, nil},
+	}
+
+	for _, tt := range tests {
+		results, err := store.GeoSearch(key, tt.q)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+
+		if len(results) != len(tt.want) {
+			t.Errorf("%s: expected %v, got %d results", tt.name, tt.want, len(results))
+			continue
+		}
+		for i, r := range results {
+			if string(r.Member) != tt.want[i] {
+				t.Errorf("%s: expected %s at %d, got %s", tt.name, tt.want[i], i, r.Member)
+			}
+		}
+	}
+
+	// ANY stops at the first matches found
+	results, _ := store.GeoSearch(key, GeoQuery{Center: center, Radius: 1_000_000, Count: 2, Any: true})
+	if len(results) != 2 {
+		t.Errorf("Expected 2 results with ANY, got %d", len(results))
+	}
+
+	if _, err := store.GeoSearch(key, GeoQuery{Member: []byte("missing"), Radius: 1}); err == nil {
+		t.Error("Expected error when searching from a missing member")
+	}
+}
@@ -0,0 +1,437 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Removes the expired fields of the hash stored at key, deleting the key once the hash is empty.
+// Nothing is removed with passive expiry. Returns true if any field was removed.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) expireHashFields(key string) bool {
+	sh := kv.shard(key)
+	entry, exists := sh.entries[key]
+	if !exists || entry.kind != kindHash || len(entry.fieldExpiresAt) == 0 {
+		// The key was deleted or replaced, or has no field TTLs left
+		delete(sh.fieldExpirable, key)
+		return false
+	}
+	if kv.passiveExpiry {
+		return false
+	}
+	entry = kv.mutable(key, entry)
+
+	now := time.Now().UnixNano()
+	var removed [][]byte
+	for field, expiresAt := range entry.fieldExpiresAt {
+		if now > expiresAt {
+			delete(entry.hash, field)
+			delete(entry.fieldExpiresAt, field)
+			removed = append(removed, []byte(field))
+		}
+	}
+
+	if len(removed) > 0 {
+		kv.touch(entry)
+		kv.notify(EventHash, "hexpired", []byte(key))
+		kv.recordRemoval(Removal{Key: []byte(key), Fields: removed})
+	}
+
+	if len(entry.hash) == 0 {
+		kv.deleteKey(key)
+		kv.notify(EventGeneric, "del", []byte(key))
+	} else if len(entry.fieldExpiresAt) == 0 {
+		delete(sh.fieldExpirable, key)
+	}
+
+	return len(removed) > 0
+}
+
+// Returns the hash stored at key, creating an empty one if create is true.
+// Returns nil if the key does not exist and create is false.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) hashEntry(key []byte, create bool) (*Entry, error) {
+	entry, exists := kv.entry(string(key))
+	if exists && entry.isExpired() {
+		kv.expireKey(string(key))
+		exists = false
+	}
+
+	if exists && len(entry.fieldExpiresAt) > 0 {
+		kv.expireHashFields(string(key))
+		entry, exists = kv.shard(string(key)).entries[string(key)]
+	}
+
+	if exists {
+		if entry.kind != kindHash {
+			return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+		return kv.mutable(string(key), entry), nil
+	}
+
+	if !create {
+		return nil, nil
+	}
+
+	entry = NewHashEntry(-1)
+	kv.addKey(string(key), entry)
+	return entry, nil
+}
+
+func (kv *InMemoryKVStore) HashSet(key []byte, pairs [][]byte) (int64, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.hashEntry(key, true)
+	if err != nil {
+		return 0, err
+	}
+
+	var created int64 = 0
+	for i := 0; i+1 < len(pairs); i += 2 {
+		field := string(pairs[i])
+		if _, exists := entry.hash[field]; !exists {
+			created++
+		}
+
+		entry.hash[field] = pairs[i+1]
+		// Setting a field clears its TTL
+		delete(entry.fieldExpiresAt, field)
+	}
+	kv.touch(entry)
+	kv.notify(EventHash, "hset", key)
+
+	return created, nil
+}
+
+func (kv *InMemoryKVStore) HashSetNX(key, field, value []byte) (bool, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return false, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.hashEntry(key, true)
+	if err != nil {
+		return false, err
+	}
+
+	if _, exists := entry.hash[string(field)]; exists {
+		return false, nil
+	}
+
+	entry.hash[string(field)] = value
+	kv.touch(entry)
+	kv.notify(EventHash, "hset", key)
+	return true, nil
+}
+
+func (kv *InMemoryKVStore) HashGet(key, field []byte) ([]byte, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.hashEntry(key, false)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	return entry.hash[string(field)], nil
+}
+
+func (kv *InMemoryKVStore) HashGetMany(key []byte, fields [][]byte) ([][]byte, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.hashEntry(key, false)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([][]byte, len(fields))
+	if entry == nil {
+		return values, nil
+	}
+
+	for i, field := range fields {
+		values[i] = entry.hash[string(field)]
+	}
+
+	return values, nil
+}
+
+func (kv *InMemoryKVStore) HashLen(key []byte) (int64, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.hashEntry(key, false)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+
+	return int64(len(entry.hash)), nil
+}
+
+func (kv *InMemoryKVStore) HashDelete(key []byte, fields [][]byte) (int64, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.hashEntry(key, false)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+
+	var deleted int64 = 0
+	for _, field := range fields {
+		if _, exists := entry.hash[string(field)]; exists {
+			delete(entry.hash, string(field))
+			delete(entry.fieldExpiresAt, string(field))
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		kv.touch(entry)
+		kv.notify(EventHash, "hdel", key)
+	}
+
+	// Hashes are removed once their last field is deleted
+	if len(entry.hash) == 0 {
+		kv.deleteKey(string(key))
+		kv.notify(EventGeneric, "del", key)
+	}
+
+	return deleted, nil
+}
+
+func (kv *InMemoryKVStore) HashGetAll(key []byte) ([][]byte, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.hashEntry(key, false)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	// Copy the fields so the caller does not share the map
+	pairs := make([][]byte, 0, len(entry.hash)*2)
+	for field, value := range entry.hash {
+		pairs = append(pairs, []byte(field), value)
+	}
+
+	return pairs, nil
+}
+
+func (kv *InMemoryKVStore) HashExists(key, field []byte) (bool, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return false, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.hashEntry(key, false)
+	if err != nil || entry == nil {
+		return false, err
+	}
+
+	_, exists := entry.hash[string(field)]
+	return exists, nil
+}
+
+func (kv *InMemoryKVStore) HashIncrBy(key, field []byte, delta int64) (int64, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.hashEntry(key, true)
+	if err != nil {
+		return 0, err
+	}
+
+	var current int64 = 0
+	if value, exists := entry.hash[string(field)]; exists {
+		current, err = strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("hash value is not an integer")
+		}
+	}
+
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, fmt.Errorf("increment or decrement would overflow")
+	}
+
+	current += delta
+	entry.hash[string(field)] = []byte(strconv.FormatInt(current, 10))
+	kv.touch(entry)
+	kv.notify(EventHash, "hincrby", key)
+
+	return current, nil
+}
+
+func (kv *InMemoryKVStore) HashIncrByFloat(key, field []byte, delta float64) ([]byte, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.hashEntry(key, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var current float64 = 0
+	if value, exists := entry.hash[string(field)]; exists {
+		current, err = strconv.ParseFloat(string(value), 64)
+		if err != nil || math.IsNaN(current) || math.IsInf(current, 0) {
+			return nil, fmt.Errorf("hash value is not a float")
+		}
+	}
+
+	current += delta
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		return nil, fmt.Errorf("increment would produce NaN or Infinity")
+	}
+
+	value := []byte(strconv.FormatFloat(current, 'f', -1, 64))
+	entry.hash[string(field)] = value
+	kv.touch(entry)
+	kv.notify(EventHash, "hincrbyfloat", key)
+
+	return value, nil
+}
+
+// Result codes returned per field by HashExpire and HashTTL.
+const (
+	fieldNotFound  = -2 // The field or key does not exist
+	fieldNoTTL     = -1 // The field exists but has no expiration
+	fieldExpireSet = 1  // The expiration was set
+	fieldDeleted   = 2  // The expiration time is in the past so the field was deleted
+)
+
+func (kv *InMemoryKVStore) HashExpire(key []byte, fields [][]byte, expiresAt int64) ([]int64, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.hashEntry(key, false)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]int64, len(fields))
+	expired, deleted := false, false
+	for i, field := range fields {
+		if entry == nil {
+			results[i] = fieldNotFound
+			continue
+		}
+
+		if _, exists := entry.hash[string(field)]; !exists {
+			results[i] = fieldNotFound
+			continue
+		}
+
+		if expiresAt <= time.Now().UnixNano() {
+			delete(entry.hash, string(field))
+			delete(entry.fieldExpiresAt, string(field))
+			results[i] = fieldDeleted
+			deleted = true
+			continue
+		}
+
+		if entry.fieldExpiresAt == nil {
+			entry.fieldExpiresAt = make(map[string]int64)
+		}
+		entry.fieldExpiresAt[string(field)] = expiresAt
+		kv.shard(string(key)).fieldExpirable[string(key)] = struct{}{}
+		results[i] = fieldExpireSet
+		expired = true
+	}
+
+	if entry != nil && len(fields) > 0 {
+		kv.touch(entry)
+	}
+	if expired {
+		kv.notify(EventHash, "hexpire", key)
+	}
+	if deleted {
+		kv.notify(EventHash, "hdel", key)
+	}
+	if entry != nil && len(entry.hash) == 0 {
+		kv.deleteKey(string(key))
+		kv.notify(EventGeneric, "del", key)
+	}
+
+	return results, nil
+}
+
+func (kv *InMemoryKVStore) HashTTL(key []byte, fields [][]byte) ([]int64, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.hashEntry(key, false)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixNano()
+	ttls := make([]int64, len(fields))
+	for i, field := range fields {
+		if entry == nil {
+			ttls[i] = fieldNotFound
+			continue
+		}
+
+		if _, exists := entry.hash[string(field)]; !exists {
+			ttls[i] = fieldNotFound
+			continue
+		}
+
+		expiresAt, ok := entry.fieldExpiresAt[string(field)]
+		if !ok {
+			ttls[i] = fieldNoTTL
+			continue
+		}
+		ttls[i] = (expiresAt - now) / int64(time.Millisecond)
+	}
+
+	return ttls, nil
+}
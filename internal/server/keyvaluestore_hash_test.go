@@ -0,0 +1,405 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHashSetAndGet(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("session")
+
+	created, err := store.HashSet(key, [][]byte{[]byte("user"), []byte("alice")})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if created != 1 {
+		t.Errorf("Expected 1 new field, got %d", created)
+	}
+
+	// Updating an existing field and adding a new one
+	created, err = store.HashSet(key, [][]byte{[]byte("user"), []byte("bob"), []byte("role"), []byte("admin")})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if created != 1 {
+		t.Errorf("Expected 1 new field, got %d", created)
+	}
+
+	value, err := store.HashGet(key, []byte("user"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(value) != "bob" {
+		t.Errorf("Expected bob, got %s", string(value))
+	}
+
+	value, err = store.HashGet(key, []byte("missing"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if value != nil {
+		t.Errorf("Expected nil for missing field, got %s", string(value))
+	}
+
+	value, err = store.HashGet([]byte("missing"), []byte("user"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if value != nil {
+		t.Errorf("Expected nil for missing key, got %s", string(value))
+	}
+}
+
+func TestHashDelete(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("session")
+	store.HashSet(key, [][]byte{[]byte("field1"), []byte("value1")})
+	store.HashSet(key, [][]byte{[]byte("field2"), []byte("value2")})
+
+	deleted, err := store.HashDelete(key, [][]byte{[]byte("field1"), []byte("missing")})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("Expected 1 field deleted, got %d", deleted)
+	}
+
+	deleted, _ = store.HashDelete(key, [][]byte{[]byte("field2")})
+	if deleted != 1 {
+		t.Errorf("Expected 1 field deleted, got %d", deleted)
+	}
+
+	// The key is removed once the hash is empty
+	if store.Exists([][]byte{key}) != 0 {
+		t.Error("Expected hash to be deleted after removing the last field")
+	}
+}
+
+func TestHashGetAllAndExists(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("session")
+	store.HashSet(key, [][]byte{[]byte("field1"), []byte("value1")})
+	store.HashSet(key, [][]byte{[]byte("field2"), []byte("value2")})
+
+	pairs, err := store.HashGetAll(key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(pairs) != 4 {
+		t.Fatalf("Expected 4 elements, got %d", len(pairs))
+	}
+
+	fields := make(map[string]string)
+	for i := 0; i < len(pairs); i += 2 {
+		fields[string(pairs[i])] = string(pairs[i+1])
+	}
+	if fields["field1"] != "value1" || fields["field2"] != "value2" {
+		t.Errorf("Unexpected fields %v", fields)
+	}
+
+	exists, err := store.HashExists(key, []byte("field1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !exists {
+		t.Error("Expected field1 to exist")
+	}
+
+	exists, _ = store.HashExists(key, []byte("missing"))
+	if exists {
+		t.Error("Expected missing field not to exist")
+	}
+
+	pairs, _ = store.HashGetAll([]byte("missing"))
+	if pairs != nil {
+		t.Errorf("Expected nil for missing key, got %v", pairs)
+	}
+}
+
+func TestHashWrongType(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.Set([]byte("string_key"), []byte("value"), -1)
+	store.HashSet([]byte("hash_key"), [][]byte{[]byte("field"), []byte("value")})
+
+	if _, err := store.HashSet([]byte("string_key"), [][]byte{[]byte("field"), []byte("value")}); err == nil {
+		t.Error("Expected error when setting a field on a string")
+	}
+
+	if _, err := store.HashGet([]byte("string_key"), []byte("field")); err == nil {
+		t.Error("Expected error when reading a field from a string")
+	}
+
+	if _, err := store.GetValue([]byte("hash_key")); err == nil {
+		t.Error("Expected error when reading a hash as a string")
+	}
+
+	if _, err := store.Push([]byte("hash_key"), [][]byte{[]byte("value")}, false); err == nil {
+		t.Error("Expected error when pushing to a hash")
+	}
+}
+
+func TestHashExpiration(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("session")
+	store.HashSet(key, [][]byte{[]byte("field"), []byte("value")})
+	store.Expire(key, time.Now().Add(50*time.Millisecond).UnixNano())
+
+	time.Sleep(100 * time.Millisecond)
+
+	value, err := store.HashGet(key, []byte("field"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if value != nil {
+		t.Errorf("Expected nil for expired hash, got %s", string(value))
+	}
+
+	// Setting a field on an expired hash starts a new one
+	created, _ := store.HashSet(key, [][]byte{[]byte("field"), []byte("new")})
+	if created != 1 {
+		t.Error("Expected field to be created on a new hash")
+	}
+}
+
+func TestHashIncrBy(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("counters")
+
+	value, err := store.HashIncrBy(key, []byte("views"), 5)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if value != 5 {
+		t.Errorf("Expected 5, got %d", value)
+	}
+
+	value, _ = store.HashIncrBy(key, []byte("views"), -7)
+	if value != -2 {
+		t.Errorf("Expected -2, got %d", value)
+	}
+
+	stored, _ := store.HashGet(key, []byte("views"))
+	if string(stored) != "-2" {
+		t.Errorf("Expected stored value -2, got %s", string(stored))
+	}
+
+	store.HashSet(key, [][]byte{[]byte("name"), []byte("alice")})
+	if _, err := store.HashIncrBy(key, []byte("name"), 1); err == nil {
+		t.Error("Expected error when incrementing a non-integer field")
+	}
+
+	store.HashSet(key, [][]byte{[]byte("max"), []byte("9223372036854775807")})
+	if _, err := store.HashIncrBy(key, []byte("max"), 1); err == nil {
+		t.Error("Expected overflow error")
+	}
+}
+
+func TestHashIncrByFloat(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("counters")
+
+	value, err := store.HashIncrByFloat(key, []byte("balance"), 10.5)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(value) != "10.5" {
+		t.Errorf("Expected 10.5, got %s", string(value))
+	}
+
+	value, _ = store.HashIncrByFloat(key, []byte("balance"), -0.5)
+	if string(value) != "10" {
+		t.Errorf("Expected 10, got %s", string(value))
+	}
+
+	// Integer fields can be incremented by floats
+	store.HashIncrBy(key, []byte("views"), 3)
+	value, _ = store.HashIncrByFloat(key, []byte("views"), 0.25)
+	if string(value) != "3.25" {
+		t.Errorf("Expected 3.25, got %s", string(value))
+	}
+
+	store.HashSet(key, [][]byte{[]byte("name"), []byte("alice")})
+	if _, err := store.HashIncrByFloat(key, []byte("name"), 1); err == nil {
+		t.Error("Expected error when incrementing a non-float field")
+	}
+}
+
+func TestHashFieldExpiration(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("heartbeats")
+	store.HashSet(key, [][]byte{[]byte("device1"), []byte("ok")})
+	store.HashSet(key, [][]byte{[]byte("device2"), []byte("ok")})
+
+	results, err := store.HashExpire(key, [][]byte{[]byte("device1"), []byte("missing")}, time.Now().Add(50*time.Millisecond).UnixNano())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results) != 2 || results[0] != 1 || results[1] != -2 {
+		t.Errorf("Expected [1 -2], got %v", results)
+	}
+
+	ttls, err := store.HashTTL(key, [][]byte{[]byte("device1"), []byte("device2"), []byte("missing")})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ttls[0] <= 0 || ttls[0] > 50 || ttls[1] != -1 || ttls[2] != -2 {
+		t.Errorf("Unexpected TTLs %v", ttls)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	value, _ := store.HashGet(key, []byte("device1"))
+	if value != nil {
+		t.Errorf("Expected expired field to be gone, got %s", string(value))
+	}
+
+	value, _ = store.HashGet(key, []byte("device2"))
+	if string(value) != "ok" {
+		t.Errorf("Expected device2 to remain, got %s", string(value))
+	}
+}
+
+func TestHashFieldExpirationCleanup(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("heartbeats")
+	store.HashSet(key, [][]byte{[]byte("device1"), []byte("ok")})
+	store.HashExpire(key, [][]byte{[]byte("device1")}, time.Now().Add(50*time.Millisecond).UnixNano())
+
+	// Wait for the cleanup loop to remove the field without accessing the key
+	time.Sleep(defaultCleanupInterval + 100*time.Millisecond)
+
+	_, exists := storedEntry(store, string(key))
+
+	if exists {
+		t.Error("Expected hash to be deleted once its only field expired")
+	}
+}
+
+func TestHashFieldExpirationSampling(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	// Many more hashes with expired fields than a single sample checks
+	for i := range cleanupSampleSize * 20 {
+		key := []byte("hash:" + strconv.Itoa(i))
+		store.HashSet(key, [][]byte{[]byte("f"), []byte("v")})
+		store.HashExpire(key, [][]byte{[]byte("f")}, time.Now().Add(time.Millisecond).UnixNano())
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	// Sampling continues while most sampled hashes had expired fields
+	store.activeExpireCycle()
+
+	_, remaining := storedExpirable(store)
+
+	if remaining != 0 {
+		t.Errorf("Expected every hash to be checked in a single cycle, %d left", remaining)
+	}
+}
+
+func TestHashFieldExpirationReset(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("heartbeats")
+	store.HashSet(key, [][]byte{[]byte("device1"), []byte("ok")})
+	store.HashSet(key, [][]byte{[]byte("device2"), []byte("ok")})
+	store.HashExpire(key, [][]byte{[]byte("device1")}, time.Now().Add(time.Minute).UnixNano())
+
+	// Setting the field again clears its TTL
+	store.HashSet(key, [][]byte{[]byte("device1"), []byte("ok")})
+	ttls, _ := store.HashTTL(key, [][]byte{[]byte("device1")})
+	if ttls[0] != -1 {
+		t.Errorf("Expected TTL to be cleared, got %d", ttls[0])
+	}
+
+	// An expiration in the past deletes the field
+	results, _ := store.HashExpire(key, [][]byte{[]byte("device2")}, time.Now().Add(-time.Second).UnixNano())
+	if results[0] != 2 {
+		t.Errorf("Expected field to be deleted, got %d", results[0])
+	}
+
+	exists, _ := store.HashExists(key, []byte("device2"))
+	if exists {
+		t.Error("Expected device2 to be deleted")
+	}
+}
+
+func TestHashSetNX(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("session")
+
+	set, err := store.HashSetNX(key, []byte("user"), []byte("alice"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !set {
+		t.Error("Expected field to be set")
+	}
+
+	set, _ = store.HashSetNX(key, []byte("user"), []byte("bob"))
+	if set {
+		t.Error("Expected existing field not to be overwritten")
+	}
+
+	value, _ := store.HashGet(key, []byte("user"))
+	if string(value) != "alice" {
+		t.Errorf("Expected alice, got %s", string(value))
+	}
+}
+
+func TestHashGetManyAndLen(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("session")
+	store.HashSet(key, [][]byte{[]byte("user"), []byte("alice"), []byte("role"), []byte("admin")})
+
+	values, err := store.HashGetMany(key, [][]byte{[]byte("role"), []byte("missing"), []byte("user")})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(values) != 3 || string(values[0]) != "admin" || values[1] != nil || string(values[2]) != "alice" {
+		t.Errorf("Unexpected values %q", values)
+	}
+
+	values, _ = store.HashGetMany([]byte("missing"), [][]byte{[]byte("user")})
+	if len(values) != 1 || values[0] != nil {
+		t.Errorf("Expected [nil] for missing key, got %q", values)
+	}
+
+	length, err := store.HashLen(key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if length != 2 {
+		t.Errorf("Expected length 2, got %d", length)
+	}
+
+	length, _ = store.HashLen([]byte("missing"))
+	if length != 0 {
+		t.Errorf("Expected length 0 for missing key, got %d", length)
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Returns the string entry stored at key and the HyperLogLog it holds, or nil if the key does not exist.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) hyperLogLogEntry(key []byte) (*Entry, hyperLogLog, error) {
+	entry, err := kv.stringEntry(key)
+	if err != nil || entry == nil {
+		return nil, nil, err
+	}
+
+	h, err := parseHyperLogLog(entry.value)
+	if err != nil {
+		return nil, nil, err
+	}
+	return entry, h, nil
+}
+
+func (kv *InMemoryKVStore) HyperLogLogAdd(key []byte, elements [][]byte) (bool, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return false, fmt.Errorf("store is closed")
+	}
+
+	entry, h, err := kv.hyperLogLogEntry(key)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	if entry == nil {
+		h = newHyperLogLog()
+		kv.addKey(string(key), NewValueEntry(h, -1))
+		changed = true
+	} else {
+		// Changed in a copy, see Entry.value. Sparse values are already parsed into a new dense value
+		if entry.value[4] == hllDense {
+			h = slices.Clone(h)
+		}
+		entry.value = h
+	}
+
+	for _, element := range elements {
+		if h.add(element) {
+			changed = true
+		}
+	}
+	if changed && entry != nil {
+		kv.touch(entry)
+	}
+	if changed {
+		kv.notify(EventString, "pfadd", key)
+	}
+
+	return changed, nil
+}
+
+func (kv *InMemoryKVStore) HyperLogLogCount(keys [][]byte) (int64, error) {
+	locked := kv.lockKeys(keys...)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+
+	if len(keys) == 1 {
+		entry, h, err := kv.hyperLogLogEntry(keys[0])
+		if err != nil || entry == nil {
+			return 0, err
+		}
+		if card, ok := h.cachedCount(); ok {
+			return int64(card), nil
+		}
+
+		// The cardinality is cached in a copy of dense values, sparse ones are not cached
+		if entry.value[4] == hllDense {
+			h = slices.Clone(h)
+			entry.value = h
+		}
+		return int64(h.count()), nil
+	}
+
+	// The union of several keys is counted without modifying them
+	var regs hllRegisterSet
+	for _, key := range keys {
+		entry, h, err := kv.hyperLogLogEntry(key)
+		if err != nil {
+			return 0, err
+		}
+		if entry != nil {
+			h.mergeInto(&regs)
+		}
+	}
+
+	return int64(regs.estimate()), nil
+}
+
+func (kv *InMemoryKVStore) HyperLogLogMerge(dest []byte, keys [][]byte) error {
+	locked := kv.lockKeys(append([][]byte{dest}, keys...)...)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return fmt.Errorf("store is closed")
+	}
+
+	// The existing destination is part of the union
+	destEntry, destHLL, err := kv.hyperLogLogEntry(dest)
+	if err != nil {
+		return err
+	}
+
+	var regs hllRegisterSet
+	if destEntry != nil {
+		destHLL.mergeInto(&regs)
+	}
+
+	for _, key := range keys {
+		entry, h, err := kv.hyperLogLogEntry(key)
+		if err != nil {
+			return err
+		}
+		if entry != nil {
+			h.mergeInto(&regs)
+		}
+	}
+
+	// Every register is overwritten, so the union is written to a new value
+	destHLL = newHyperLogLog()
+	destHLL.setRegisters(&regs)
+	if destEntry == nil {
+		kv.addKey(string(dest), NewValueEntry(destHLL, -1))
+	} else {
+		destEntry.value = destHLL
+		kv.touch(destEntry)
+	}
+	kv.notify(EventString, "pfadd", dest)
+
+	return nil
+}
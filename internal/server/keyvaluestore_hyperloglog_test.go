@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHyperLogLogAddAndCount(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("visitors")
+
+	// Creating the key counts as a change even without elements
+	changed, err := store.HyperLogLogAdd(key, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !changed {
+		t.Error("Expected creating the HyperLogLog to report a change")
+	}
+
+	changed, _ = store.HyperLogLogAdd(key, [][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	if !changed {
+		t.Error("Expected new elements to report a change")
+	}
+
+	changed, _ = store.HyperLogLogAdd(key, [][]byte{[]byte("a")})
+	if changed {
+		t.Error("Expected duplicate element not to report a change")
+	}
+
+	count, err := store.HyperLogLogCount([][]byte{key})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected count 3, got %d", count)
+	}
+
+	count, _ = store.HyperLogLogCount([][]byte{[]byte("missing")})
+	if count != 0 {
+		t.Errorf("Expected count 0 for missing key, got %d", count)
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	for i := range 100 {
+		store.HyperLogLogAdd([]byte("monday"), [][]byte{fmt.Appendf(nil, "user:%d", i)})
+		store.HyperLogLogAdd([]byte("tuesday"), [][]byte{fmt.Appendf(nil, "user:%d", i+50)})
+	}
+
+	count, _ := store.HyperLogLogCount([][]byte{[]byte("monday"), []byte("tuesday")})
+	if count < 145 || count > 155 {
+		t.Errorf("Expected union count close to 150, got %d", count)
+	}
+
+	if err := store.HyperLogLogMerge([]byte("week"), [][]byte{[]byte("monday"), []byte("tuesday")}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	merged, _ := store.HyperLogLogCount([][]byte{[]byte("week")})
+	if merged != count {
+		t.Errorf("Expected merged count %d, got %d", count, merged)
+	}
+
+	// Counting the union does not modify the sources
+	if monday, _ := store.HyperLogLogCount([][]byte{[]byte("monday")}); monday < 95 || monday > 105 {
+		t.Errorf("Expected monday count close to 100, got %d", monday)
+	}
+}
+
+func TestHyperLogLogWrongType(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.Set([]byte("string_key"), []byte("value"), -1)
+	store.SetAdd([]byte("set_key"), [][]byte{[]byte("a")})
+
+	for _, key := range []string{"string_key", "set_key"} {
+		if _, err := store.HyperLogLogAdd([]byte(key), [][]byte{[]byte("a")}); err == nil {
+			t.Errorf("Expected error when adding to %s", key)
+		}
+		if _, err := store.HyperLogLogCount([][]byte{[]byte(key)}); err == nil {
+			t.Errorf("Expected error when counting %s", key)
+		}
+		if err := store.HyperLogLogMerge([]byte("dest"), [][]byte{[]byte(key)}); err == nil {
+			t.Errorf("Expected error when merging %s", key)
+		}
+	}
+
+	if store.Exists([][]byte{[]byte("dest")}) != 0 {
+		t.Error("Expected failed merge not to create the destination")
+	}
+}
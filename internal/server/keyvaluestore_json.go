@@ -0,0 +1,494 @@
+package server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/CDavidSV/GopherStore/internal/util"
+)
+
+// JSON export format, one JSON object per key and per line (NDJSON), sorted by key so exports of the
+// same data can be diffed:
+//
+//	{"key":"user:1","type":"hash","expires_at":1767225600000,"value":{"name":"Ada"}}
+//
+// expires_at is in unix milliseconds and omitted for keys without expiration. The value depends on
+// the type:
+//
+//	string: string
+//	list:   array of elements
+//	hash:   object of fields, with field TTLs in "field_expires_at" as unix milliseconds
+//	set:    array of members in lexicographic order
+//	zset:   array of {"member", "score"} in score order, infinite scores are "inf" and "-inf"
+//	stream: {"last_id", "entries": [{"id", "fields"}], "groups": [{"name", "last_delivered_id",
+//	        "consumers", "pending": [{"id", "consumer", "delivered_at", "deliveries"}]}]}
+//
+// Records holding bytes that are not valid UTF-8 have every key, field and value base64 encoded
+// instead, marked with "encoding":"base64".
+type jsonRecord struct {
+	Key            string           `json:"key"`
+	Type           string           `json:"type"`
+	Encoding       string           `json:"encoding,omitempty"`
+	ExpiresAt      int64            `json:"expires_at,omitempty"`
+	Value          json.RawMessage  `json:"value"`
+	FieldExpiresAt map[string]int64 `json:"field_expires_at,omitempty"`
+}
+
+type jsonScoredMember struct {
+	Member string    `json:"member"`
+	Score  jsonScore `json:"score"`
+}
+
+// Sorted set score, encoded as a string when infinite since JSON numbers cannot be.
+type jsonScore float64
+
+func (s jsonScore) MarshalJSON() ([]byte, error) {
+	if math.IsInf(float64(s), 0) {
+		return json.Marshal(string(util.FormatFloat(float64(s))))
+	}
+	return json.Marshal(float64(s))
+}
+
+func (s *jsonScore) UnmarshalJSON(data []byte) error {
+	var text string
+	if json.Unmarshal(data, &text) == nil {
+		score, ok := util.ParseFloat([]byte(text))
+		if !ok {
+			return fmt.Errorf("invalid score %q", text)
+		}
+		*s = jsonScore(score)
+		return nil
+	}
+
+	return json.Unmarshal(data, (*float64)(s))
+}
+
+type jsonStream struct {
+	LastID  string            `json:"last_id"`
+	Entries []jsonStreamEntry `json:"entries"`
+	Groups  []jsonStreamGroup `json:"groups,omitempty"`
+}
+
+type jsonStreamEntry struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+}
+
+type jsonStreamGroup struct {
+	Name            string             `json:"name"`
+	LastDeliveredID string             `json:"last_delivered_id"`
+	Consumers       []string           `json:"consumers"`
+	Pending         []jsonPendingEntry `json:"pending,omitempty"`
+}
+
+type jsonPendingEntry struct {
+	ID          string `json:"id"`
+	Consumer    string `json:"consumer"`
+	DeliveredAt int64  `json:"delivered_at"`
+	Deliveries  int64  `json:"deliveries"`
+}
+
+// Writes the keys matching pattern to w in the JSON export format, as they were when it was called.
+// An empty pattern exports every key. Like snapshots, the store is only locked while the keys are
+// listed. Returns the number of keys exported.
+func (kv *InMemoryKVStore) ExportJSON(w io.Writer, pattern []byte) (int, error) {
+	snapshot, err := kv.BeginSnapshot()
+	if err != nil {
+		return 0, err
+	}
+	defer snapshot.Release()
+
+	keys := make([]string, 0, len(snapshot.entries))
+	for key := range snapshot.entries {
+		if len(pattern) == 0 || util.MatchPattern(pattern, []byte(key)) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	out := bufio.NewWriter(w)
+	exported := 0
+	for _, key := range keys {
+		entry := snapshot.entries[key]
+		if entry.isExpired() {
+			continue
+		}
+
+		if err := writeJSONRecord(out, key, entry); err != nil {
+			return exported, err
+		}
+		exported++
+	}
+
+	return exported, out.Flush()
+}
+
+// Writes the given keys to w in the JSON export format, in the given order and skipping missing
+// ones. Unlike ExportJSON, the store is read locked while the keys are encoded, so it is meant for a
+// few keys. Returns the number of keys exported.
+func (kv *InMemoryKVStore) ExportJSONKeys(w io.Writer, keys [][]byte) (int, error) {
+	kv.loadKeys(keys)
+
+	locked := kv.rlockKeys(keys...)
+	defer kv.runlockKeys(locked)
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+
+	out := bufio.NewWriter(w)
+	exported := 0
+	for _, key := range keys {
+		entry, exists := kv.shard(string(key)).entries[string(key)]
+		if !exists || entry.isExpired() {
+			continue
+		}
+
+		if err := writeJSONRecord(out, string(key), entry); err != nil {
+			return exported, err
+		}
+		exported++
+	}
+
+	return exported, out.Flush()
+}
+
+// Writes an entry as a line in the JSON export format, base64 encoded if it is not valid UTF-8.
+func writeJSONRecord(out *bufio.Writer, key string, entry *Entry) error {
+	line, err := encodeJSONRecord(key, entry, false)
+	if errors.Is(err, errNotText) {
+		line, err = encodeJSONRecord(key, entry, true)
+	}
+	if err != nil {
+		return err
+	}
+
+	out.Write(line)
+	return out.WriteByte('\n')
+}
+
+var errNotText = errors.New("not valid UTF-8")
+
+// Encodes the bytes of a record as JSON strings, either as text or base64.
+type jsonTextEncoder struct {
+	base64 bool
+	err    error
+}
+
+func (e *jsonTextEncoder) text(b []byte) string {
+	if e.base64 {
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	if !utf8.Valid(b) {
+		e.err = errNotText
+	}
+	return string(b)
+}
+
+func (e *jsonTextEncoder) texts(list [][]byte) []string {
+	texts := make([]string, len(list))
+	for i, b := range list {
+		texts[i] = e.text(b)
+	}
+	return texts
+}
+
+// Encodes an entry as a JSON export record. Fails with errNotText if the record holds bytes that are
+// not valid UTF-8 and base64 is false.
+func encodeJSONRecord(key string, entry *Entry, base64 bool) ([]byte, error) {
+	e := &jsonTextEncoder{base64: base64}
+	record := jsonRecord{
+		Key:  e.text([]byte(key)),
+		Type: entry.typeName(),
+	}
+	if base64 {
+		record.Encoding = "base64"
+	}
+	if entry.expiresAt > 0 {
+		record.ExpiresAt = entry.expiresAt / int64(time.Millisecond)
+	}
+
+	var value any
+	switch entry.kind {
+	case kindString:
+		value = e.text(entry.value)
+	case kindList:
+		value = e.texts(entry.list)
+	case kindHash:
+		hash := make(map[string]string, len(entry.hash))
+		for field, v := range entry.hash {
+			hash[e.text([]byte(field))] = e.text(v)
+		}
+		for field, expiresAt := range entry.fieldExpiresAt {
+			if record.FieldExpiresAt == nil {
+				record.FieldExpiresAt = make(map[string]int64, len(entry.fieldExpiresAt))
+			}
+			record.FieldExpiresAt[e.text([]byte(field))] = expiresAt / int64(time.Millisecond)
+		}
+		value = hash
+	case kindSet:
+		members := make([]string, 0, len(entry.set))
+		for member := range entry.set {
+			members = append(members, e.text([]byte(member)))
+		}
+		slices.Sort(members)
+		value = members
+	case kindSortedSet:
+		members := make([]jsonScoredMember, 0, entry.zset.len())
+		for x := entry.zset.header.level[0].forward; x != nil; x = x.level[0].forward {
+			members = append(members, jsonScoredMember{Member: e.text([]byte(x.member)), Score: jsonScore(x.score)})
+		}
+		value = members
+	case kindStream:
+		value = encodeJSONStream(e, entry.stream)
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	record.Value = raw
+	return json.Marshal(record)
+}
+
+func encodeJSONStream(e *jsonTextEncoder, s *stream) jsonStream {
+	js := jsonStream{
+		LastID:  s.lastID.String(),
+		Entries: make([]jsonStreamEntry, len(s.entries)),
+	}
+	for i, entry := range s.entries {
+		js.Entries[i] = jsonStreamEntry{ID: entry.ID.String(), Fields: e.texts(entry.Fields)}
+	}
+
+	for name, g := range s.groups {
+		group := jsonStreamGroup{
+			Name:            e.text([]byte(name)),
+			LastDeliveredID: g.lastID.String(),
+			Consumers:       make([]string, 0, len(g.consumers)),
+		}
+		for consumer := range g.consumers {
+			group.Consumers = append(group.Consumers, e.text([]byte(consumer)))
+		}
+		slices.Sort(group.Consumers)
+		for _, pe := range g.pel {
+			group.Pending = append(group.Pending, jsonPendingEntry{
+				ID:          pe.id.String(),
+				Consumer:    e.text([]byte(pe.consumer.name)),
+				DeliveredAt: pe.deliveredAt,
+				Deliveries:  pe.deliveries,
+			})
+		}
+		js.Groups = append(js.Groups, group)
+	}
+	slices.SortFunc(js.Groups, func(a, b jsonStreamGroup) int { return strings.Compare(a.Name, b.Name) })
+	return js
+}
+
+// Reads records in the JSON export format into the store, replacing existing keys with the same
+// names, or failing with errBusyKey without importing anything if one exists and replace is false.
+// Every record is decoded before the store is changed, so an invalid line leaves it untouched. Keys
+// that already expired are skipped and a restore keyspace event is sent for every key imported.
+// Returns the number of keys imported.
+func (kv *InMemoryKVStore) ImportJSON(r io.Reader, replace bool) (int, error) {
+	keys := make(map[string]*Entry)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, math.MaxInt32) // Lines hold whole keys, which can be large
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		key, entry, err := decodeJSONRecord(scanner.Bytes())
+		if err != nil {
+			return 0, fmt.Errorf("invalid record on line %d: %w", line, err)
+		}
+		keys[string(key)] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	if !replace {
+		names := make([][]byte, 0, len(keys))
+		for key := range keys {
+			names = append(names, []byte(key))
+		}
+		kv.loadKeys(names)
+	}
+
+	return kv.restoreEntries(keys, true, replace)
+}
+
+// Decodes the strings of a record, either as text or base64.
+type jsonTextDecoder struct {
+	base64 bool
+	err    error
+}
+
+func (d *jsonTextDecoder) bytes(s string) []byte {
+	if !d.base64 {
+		return []byte(s)
+	}
+
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil && d.err == nil {
+		d.err = err
+	}
+	return b
+}
+
+func (d *jsonTextDecoder) id(s string) StreamID {
+	id, ok := parseStreamID([]byte(s), 0)
+	if !ok && d.err == nil {
+		d.err = fmt.Errorf("invalid stream ID %q", s)
+	}
+	return id
+}
+
+// Decodes a JSON export record into its key and entry.
+func decodeJSONRecord(line []byte) ([]byte, *Entry, error) {
+	var record jsonRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return nil, nil, err
+	}
+	if record.Value == nil {
+		return nil, nil, fmt.Errorf("missing value")
+	}
+
+	d := &jsonTextDecoder{}
+	switch record.Encoding {
+	case "":
+	case "base64":
+		d.base64 = true
+	default:
+		return nil, nil, fmt.Errorf("unknown encoding %q", record.Encoding)
+	}
+
+	key := d.bytes(record.Key)
+	entry := &Entry{}
+	if record.ExpiresAt > 0 {
+		entry.expiresAt = record.ExpiresAt * int64(time.Millisecond)
+	}
+
+	var err error
+	switch record.Type {
+	case "string":
+		entry.kind = kindString
+		var value string
+		if err = json.Unmarshal(record.Value, &value); err == nil {
+			entry.value = d.bytes(value)
+		}
+	case "list":
+		entry.kind = kindList
+		var list []string
+		if err = json.Unmarshal(record.Value, &list); err == nil {
+			entry.list = make([][]byte, len(list))
+			for i, element := range list {
+				entry.list[i] = d.bytes(element)
+			}
+		}
+	case "hash":
+		entry.kind = kindHash
+		var hash map[string]string
+		if err = json.Unmarshal(record.Value, &hash); err == nil {
+			entry.hash = make(map[string][]byte, len(hash))
+			for field, value := range hash {
+				entry.hash[string(d.bytes(field))] = d.bytes(value)
+			}
+			for field, expiresAt := range record.FieldExpiresAt {
+				name := string(d.bytes(field))
+				if _, exists := entry.hash[name]; !exists || expiresAt <= 0 {
+					continue
+				}
+				if entry.fieldExpiresAt == nil {
+					entry.fieldExpiresAt = make(map[string]int64)
+				}
+				entry.fieldExpiresAt[name] = expiresAt * int64(time.Millisecond)
+			}
+		}
+	case "set":
+		entry.kind = kindSet
+		var members []string
+		if err = json.Unmarshal(record.Value, &members); err == nil {
+			entry.set = make(map[string]struct{}, len(members))
+			for _, member := range members {
+				entry.set[string(d.bytes(member))] = struct{}{}
+			}
+		}
+	case "zset":
+		entry.kind = kindSortedSet
+		var members []jsonScoredMember
+		if err = json.Unmarshal(record.Value, &members); err == nil {
+			entry.zset = newSortedSet()
+			for _, m := range members {
+				entry.zset.add(string(d.bytes(m.Member)), float64(m.Score))
+			}
+		}
+	case "stream":
+		entry.kind = kindStream
+		var js jsonStream
+		if err = json.Unmarshal(record.Value, &js); err == nil {
+			entry.stream = decodeJSONStream(d, js)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown type %q", record.Type)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.err != nil {
+		return nil, nil, d.err
+	}
+
+	return key, entry, nil
+}
+
+func decodeJSONStream(d *jsonTextDecoder, js jsonStream) *stream {
+	s := newStream()
+	s.lastID = d.id(js.LastID)
+	s.entries = make([]StreamEntry, len(js.Entries))
+	for i, e := range js.Entries {
+		s.entries[i].ID = d.id(e.ID)
+		s.entries[i].Fields = make([][]byte, len(e.Fields))
+		for j, field := range e.Fields {
+			s.entries[i].Fields[j] = d.bytes(field)
+		}
+		if i > 0 && s.entries[i].ID.compare(s.entries[i-1].ID) <= 0 && d.err == nil {
+			d.err = fmt.Errorf("stream entry IDs must be increasing, got %s after %s", e.ID, js.Entries[i-1].ID)
+		}
+	}
+	if n := len(s.entries); n > 0 && s.entries[n-1].ID.compare(s.lastID) > 0 {
+		s.lastID = s.entries[n-1].ID
+	}
+
+	if len(js.Groups) > 0 {
+		s.groups = make(map[string]*consumerGroup, len(js.Groups))
+	}
+	for _, jg := range js.Groups {
+		g := newConsumerGroup(d.id(jg.LastDeliveredID))
+		for _, consumer := range jg.Consumers {
+			g.consumer(string(d.bytes(consumer)), true)
+		}
+		for _, jp := range jg.Pending {
+			pe := &pendingEntry{id: d.id(jp.ID), deliveredAt: jp.DeliveredAt, deliveries: jp.Deliveries}
+			pe.consumer = g.consumer(string(d.bytes(jp.Consumer)), true)
+			pe.consumer.pending[pe.id] = pe
+			g.pel = append(g.pel, pe)
+		}
+		slices.SortFunc(g.pel, func(a, b *pendingEntry) int { return a.id.compare(b.id) })
+		s.groups[string(d.bytes(jg.Name))] = g
+	}
+	return s
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONExportRoundTrip(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Millisecond).UnixNano()
+	store.Set([]byte("string"), []byte("value"), expiresAt)
+	store.Set([]byte("binary"), []byte{0xff, 0x00}, -1)
+	store.Push([]byte("list"), [][]byte{[]byte("a"), []byte("b")}, false)
+	store.HashSet([]byte("hash"), [][]byte{[]byte("f1"), []byte("v1"), []byte("f2"), []byte("v2")})
+	store.HashExpire([]byte("hash"), [][]byte{[]byte("f1")}, expiresAt)
+	store.SetAdd([]byte("set"), [][]byte{[]byte("y"), []byte("x")})
+	store.SortedSetAdd([]byte("zset"), []ScoredMember{{[]byte("low"), math.Inf(-1)}, {[]byte("high"), 2.5}}, ZAddOptions{})
+	store.StreamAdd([]byte("stream"), [][]byte{[]byte("type"), []byte("login")}, XAddOptions{ID: StreamID{1, 0}, MaxLen: -1})
+	store.StreamAdd([]byte("stream"), [][]byte{[]byte("type"), []byte("logout")}, XAddOptions{ID: StreamID{2, 0}, MaxLen: -1})
+	store.StreamGroupCreate([]byte("stream"), []byte("workers"), StreamID{}, false)
+	store.StreamReadGroup([]byte("stream"), []byte("workers"), XReadGroupOptions{Consumer: []byte("alice"), New: true, Count: 1})
+
+	var buf bytes.Buffer
+	exported, err := store.ExportJSON(&buf, nil)
+	if err != nil || exported != 7 {
+		t.Fatalf("Expected 7 keys to be exported, got %d (%v)", exported, err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 7 || lines[0] != `{"key":"YmluYXJ5","type":"string","encoding":"base64","value":"/wA="}` {
+		t.Errorf("Expected one line per key sorted by key with binary records base64 encoded, got %q", lines)
+	}
+	if expected := `{"key":"set","type":"set","value":["x","y"]}`; lines[3] != expected {
+		t.Errorf("Expected %s, got %s", expected, lines[3])
+	}
+
+	// Exports of the same data are identical
+	var again bytes.Buffer
+	store.ExportJSON(&again, nil)
+	if again.String() != buf.String() {
+		t.Error("Expected exports of the same data to be identical")
+	}
+
+	loaded := NewInMemoryKVStore()
+	defer loaded.Close()
+	imported, err := loaded.ImportJSON(bytes.NewReader(buf.Bytes()), true)
+	if err != nil || imported != 7 {
+		t.Fatalf("Expected 7 keys to be imported, got %d (%v)", imported, err)
+	}
+
+	if value, _ := loaded.GetValue([]byte("binary")); !bytes.Equal(value, []byte{0xff, 0x00}) {
+		t.Errorf("Expected binary value to survive, got %q", value)
+	}
+	if got := loaded.ExpiresAt([]byte("string")); got != expiresAt {
+		t.Errorf("Expected expiration %d, got %d", expiresAt, got)
+	}
+	if ttls, _ := loaded.HashTTL([]byte("hash"), [][]byte{[]byte("f1"), []byte("f2")}); ttls[0] <= 0 || ttls[1] != -1 {
+		t.Errorf("Expected only f1 to have a TTL, got %v", ttls)
+	}
+	if members, _ := loaded.SortedSetRange([]byte("zset"), 0, -1, false); len(members) != 2 || !math.IsInf(members[0].Score, -1) {
+		t.Errorf("Expected the infinite score to survive, got %v", members)
+	}
+	summary, err := loaded.StreamPendingSummary([]byte("stream"), []byte("workers"))
+	if err != nil || summary.Count != 1 {
+		t.Errorf("Expected 1 pending entry, got %+v (%v)", summary, err)
+	}
+
+	var filtered bytes.Buffer
+	if n, _ := store.ExportJSON(&filtered, []byte("s*")); n != 3 {
+		t.Errorf("Expected 3 keys matching s*, got %d", n)
+	}
+}
+
+func TestImportInvalidJSON(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	for _, data := range []string{
+		`{"key":"a","type":"string","value":"1"}` + "\n" + `not json`,
+		`{"key":"a","type":"unknown","value":"1"}`,
+		`{"key":"a","type":"list","value":"not a list"}`,
+		`{"key":"a","type":"string"}`,
+		`{"key":"a","type":"stream","value":{"last_id":"2-0","entries":[{"id":"2-0","fields":[]},{"id":"1-0","fields":[]}]}}`,
+	} {
+		if _, err := store.ImportJSON(strings.NewReader(data), true); err == nil {
+			t.Errorf("Expected an error importing %s", data)
+		}
+	}
+	if store.Exists([][]byte{[]byte("a")}) != 0 {
+		t.Error("Expected a failed import to leave the store untouched")
+	}
+}
+
+func TestImportJSONWithoutReplace(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+	store.Set([]byte("a"), []byte("1"), -1)
+	store.Set([]byte("b"), []byte("2"), -1)
+
+	var buf bytes.Buffer
+	if n, err := store.ExportJSONKeys(&buf, [][]byte{[]byte("b"), []byte("missing"), []byte("a")}); err != nil || n != 2 {
+		t.Fatalf("Expected 2 keys to be exported, got %d (%v)", n, err)
+	}
+	if lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"); len(lines) != 2 || !strings.Contains(lines[0], `"key":"b"`) {
+		t.Errorf("Expected the keys in the given order, got %q", lines)
+	}
+
+	target := NewInMemoryKVStore()
+	defer target.Close()
+	target.Set([]byte("a"), []byte("old"), -1)
+	if _, err := target.ImportJSON(bytes.NewReader(buf.Bytes()), false); err != errBusyKey {
+		t.Errorf("Expected errBusyKey, got %v", err)
+	}
+	if target.Exists([][]byte{[]byte("b")}) != 0 {
+		t.Error("Expected a failed import to leave the store untouched")
+	}
+}
@@ -0,0 +1,341 @@
+package server
+
+import (
+	"container/heap"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync/atomic"
+)
+
+const (
+	// Approximate bytes used by a map slot, the Entry struct and its slice headers.
+	entryOverhead = 96
+	// Approximate bytes used by the slice header of each list element.
+	listElementOverhead = 24
+	// Approximate bytes used by the map slot, string and slice headers of each hash field.
+	hashFieldOverhead = 48
+	// Approximate bytes used by the map slot and string header of each set member.
+	setMemberOverhead = 24
+	// Approximate bytes used by the skip list node and map slot of each sorted set member.
+	sortedSetMemberOverhead = 96
+	// Approximate bytes used by the ID and slice headers of each stream entry.
+	streamEntryOverhead = 48
+	// Number of keys inspected per lock acquisition when scanning the keyspace.
+	scanChunkSize = 1000
+)
+
+// Size and element count statistics for a single key.
+type KeyStats struct {
+	Key      []byte
+	Type     string
+	Size     int64 // Approximate memory usage in bytes
+	Elements int64 // Number of elements for collections, length in bytes for strings
+}
+
+// Measure of the keys ordered by BiggestKeys.
+type KeyMeasure uint8
+
+const (
+	MeasureMemory   KeyMeasure = iota // Approximate memory usage
+	MeasureElements                   // Number of elements, or length in bytes for strings
+)
+
+func (k KeyStats) measure(by KeyMeasure) int64 {
+	if by == MeasureElements {
+		return k.Elements
+	}
+	return k.Size
+}
+
+// Totals of the keys of one type, like the summary of redis-cli --bigkeys.
+type TypeSummary struct {
+	Type     string
+	Keys     int64
+	Elements int64    // Elements of every key, bytes for strings
+	Size     int64    // Approximate memory usage of every key
+	Biggest  KeyStats // Key with the most elements or the longest string, with a nil Key without keys
+}
+
+// Types in the order of KeyspaceSummary.
+var summaryTypes = []entryKind{kindString, kindList, kindHash, kindSet, kindSortedSet, kindStream}
+
+// Internals of an entry reported by DEBUG OBJECT.
+type ObjectInfo struct {
+	Type      string
+	Encoding  string // How the value is held, see Entry.encoding
+	Version   uint64 // Changes on every write
+	Size      int64  // Approximate memory usage in bytes
+	Elements  int64  // Number of elements for collections, length in bytes for strings
+	ExpiresAt int64  // Unix nanoseconds, 0 if the key does not expire
+}
+
+// Returns the representation of the value, named like the Redis encodings where they match.
+func (e *Entry) encoding() string {
+	switch e.kind {
+	case kindList:
+		return "array"
+	case kindHash, kindSet:
+		return "hashtable"
+	case kindSortedSet:
+		return "skiplist"
+	case kindStream:
+		return "stream"
+	default:
+		if _, err := strconv.ParseInt(string(e.value), 10, 64); err == nil {
+			return "int"
+		}
+		return "raw"
+	}
+}
+
+// Returns the approximate number of bytes used by the entry stored under key. The size is computed
+// again only once the entry was written, so keys that did not change are not walked on every call.
+// Must be called with the lock of the key held, a read lock is enough: versions only change with it
+// write locked, and readers computing the size at the same time store the same value.
+func (e *Entry) memoryUsage(key string) int64 {
+	if atomic.LoadUint64(&e.sizeVersion) == e.version {
+		// Loaded after the version, which is stored after the size
+		if size := atomic.LoadInt64(&e.size); size > 0 {
+			return size
+		}
+	}
+
+	size := e.computeMemoryUsage(key, 0)
+	atomic.StoreInt64(&e.size, size)
+	atomic.StoreUint64(&e.sizeVersion, e.version)
+	return size
+}
+
+// Returns the size of the entry computed by memoryUsage if it is still current, or an estimate
+// from memorySamples elements otherwise, so tracking the memory used stays cheap for large
+// collections. Must be called with the lock of the key held.
+func (e *Entry) estimateMemoryUsage(key string) int64 {
+	if atomic.LoadUint64(&e.sizeVersion) == e.version {
+		if size := atomic.LoadInt64(&e.size); size > 0 {
+			return size
+		}
+	}
+	return e.computeMemoryUsage(key, memorySamples)
+}
+
+// Walks the entry to count the bytes used by its elements. With samples > 0, only that many elements
+// are walked and the size of the others is extrapolated from them, like the SAMPLES option of MEMORY
+// USAGE in Redis.
+func (e *Entry) computeMemoryUsage(key string, samples int) int64 {
+	var elements, walked int64
+	// Adds the size of an element, returns false once enough elements were walked
+	walk := func(size int) bool {
+		elements += int64(size)
+		walked++
+		return samples <= 0 || walked < int64(samples)
+	}
+
+	switch e.kind {
+	case kindList:
+		for _, elem := range e.list {
+			if !walk(listElementOverhead + len(elem)) {
+				break
+			}
+		}
+	case kindHash:
+		for field, value := range e.hash {
+			if !walk(hashFieldOverhead + len(field) + len(value)) {
+				break
+			}
+		}
+	case kindSet:
+		for member := range e.set {
+			if !walk(setMemberOverhead + len(member)) {
+				break
+			}
+		}
+	case kindSortedSet:
+		for member := range e.zset.scores {
+			if !walk(sortedSetMemberOverhead + len(member)) {
+				break
+			}
+		}
+	case kindStream:
+		for _, entry := range e.stream.entries {
+			size := streamEntryOverhead
+			for _, field := range entry.Fields {
+				size += listElementOverhead + len(field)
+			}
+			if !walk(size) {
+				break
+			}
+		}
+	default:
+		return int64(entryOverhead + len(key) + len(e.value))
+	}
+
+	if count := e.elementCount(); walked > 0 && walked < count {
+		elements = elements * count / walked
+	}
+	return int64(entryOverhead+len(key)) + elements
+}
+
+// Returns the number of elements for collections or the length in bytes for strings.
+func (e *Entry) elementCount() int64 {
+	switch e.kind {
+	case kindList:
+		return int64(len(e.list))
+	case kindHash:
+		return int64(len(e.hash))
+	case kindSet:
+		return int64(len(e.set))
+	case kindSortedSet:
+		return int64(e.zset.len())
+	case kindStream:
+		return int64(e.stream.len())
+	default:
+		return int64(len(e.value))
+	}
+}
+
+func (kv *InMemoryKVStore) MemoryUsage(key []byte) (int64, bool) {
+	var size int64
+	exists := kv.view(key, func(entry *Entry) {
+		size = entry.memoryUsage(string(key))
+	})
+
+	return size, exists
+}
+
+func (kv *InMemoryKVStore) DebugObject(key []byte) (ObjectInfo, bool) {
+	var info ObjectInfo
+	exists := kv.view(key, func(entry *Entry) {
+		info = ObjectInfo{
+			Type:      entry.typeName(),
+			Encoding:  entry.encoding(),
+			Version:   entry.version,
+			Size:      entry.memoryUsage(string(key)),
+			Elements:  entry.elementCount(),
+			ExpiresAt: entry.expiresAt,
+		}
+	})
+
+	return info, exists
+}
+
+// Min-heap of key stats ordered by a measure, used to keep the N biggest keys.
+type keyStatsHeap struct {
+	stats []KeyStats
+	by    KeyMeasure
+}
+
+func (h keyStatsHeap) Len() int           { return len(h.stats) }
+func (h keyStatsHeap) Less(i, j int) bool { return h.stats[i].measure(h.by) < h.stats[j].measure(h.by) }
+func (h keyStatsHeap) Swap(i, j int)      { h.stats[i], h.stats[j] = h.stats[j], h.stats[i] }
+func (h *keyStatsHeap) Push(x any)        { h.stats = append(h.stats, x.(KeyStats)) }
+func (h *keyStatsHeap) Pop() any {
+	old := h.stats
+	last := old[len(old)-1]
+	h.stats = old[:len(old)-1]
+	return last
+}
+
+// Calls fn with every key that has not expired. Entries are inspected shard by shard in small
+// chunks so writers are never blocked for longer than one chunk, fn runs with the shard read locked.
+func (kv *InMemoryKVStore) scanEntries(fn func(key string, entry *Entry)) {
+	for i := range kv.shards {
+		sh := &kv.shards[i]
+
+		// Take a snapshot of the key names, entries are inspected later
+		kv.mu.RLock()
+		if kv.closed {
+			kv.mu.RUnlock()
+			return
+		}
+		sh.mu.RLock()
+		keys := make([]string, 0, len(sh.entries))
+		for key := range sh.entries {
+			keys = append(keys, key)
+		}
+		sh.mu.RUnlock()
+		kv.mu.RUnlock()
+
+		for start := 0; start < len(keys); start += scanChunkSize {
+			end := min(start+scanChunkSize, len(keys))
+
+			kv.mu.RLock()
+			sh.mu.RLock()
+			for _, key := range keys[start:end] {
+				entry, exists := sh.entries[key]
+				if !exists || entry.isExpired() {
+					continue
+				}
+				fn(key, entry)
+			}
+			sh.mu.RUnlock()
+			kv.mu.RUnlock()
+
+			// Give writers waiting on the lock a chance to run between chunks
+			runtime.Gosched()
+		}
+	}
+}
+
+func (kv *InMemoryKVStore) DatasetSize() (keys, bytes int64) {
+	kv.scanEntries(func(key string, entry *Entry) {
+		keys++
+		bytes += entry.memoryUsage(key)
+	})
+	return keys, bytes
+}
+
+func (kv *InMemoryKVStore) BiggestKeys(count int, by KeyMeasure) []KeyStats {
+	if count <= 0 {
+		return []KeyStats{}
+	}
+
+	biggest := &keyStatsHeap{by: by}
+	kv.scanEntries(func(key string, entry *Entry) {
+		stats := KeyStats{
+			Type:     entry.typeName(),
+			Size:     entry.memoryUsage(key),
+			Elements: entry.elementCount(),
+		}
+		if biggest.Len() == count && stats.measure(by) <= biggest.stats[0].measure(by) {
+			return
+		}
+
+		stats.Key = []byte(key)
+		heap.Push(biggest, stats)
+		if biggest.Len() > count {
+			heap.Pop(biggest)
+		}
+	})
+
+	// Pop from the min-heap to build the result from biggest to smallest
+	result := make([]KeyStats, biggest.Len())
+	for i := len(result) - 1; i >= 0; i-- {
+		result[i] = heap.Pop(biggest).(KeyStats)
+	}
+
+	return result
+}
+
+// Returns the totals of every type, including types without keys, in the order of summaryTypes.
+func (kv *InMemoryKVStore) KeyspaceSummary() []TypeSummary {
+	summary := make([]TypeSummary, len(summaryTypes))
+	for i, kind := range summaryTypes {
+		summary[i].Type = (&Entry{kind: kind}).typeName()
+	}
+
+	kv.scanEntries(func(key string, entry *Entry) {
+		totals := &summary[slices.Index(summaryTypes, entry.kind)]
+		elements := entry.elementCount()
+		size := entry.memoryUsage(key)
+
+		totals.Keys++
+		totals.Elements += elements
+		totals.Size += size
+		if totals.Biggest.Key == nil || elements > totals.Biggest.Elements {
+			totals.Biggest = KeyStats{Key: []byte(key), Type: totals.Type, Size: size, Elements: elements}
+		}
+	})
+
+	return summary
+}
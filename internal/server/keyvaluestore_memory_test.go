@@ -0,0 +1,236 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+func TestMemoryUsage(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.Set([]byte("small"), []byte("v"), -1)
+	store.Set([]byte("large"), make([]byte, 1024), -1)
+
+	small, ok := store.MemoryUsage([]byte("small"))
+	if !ok {
+		t.Fatal("Expected small key to exist")
+	}
+
+	large, ok := store.MemoryUsage([]byte("large"))
+	if !ok {
+		t.Fatal("Expected large key to exist")
+	}
+
+	if large-small != 1023 {
+		t.Errorf("Expected size difference of 1023 bytes, got %d", large-small)
+	}
+
+	if _, ok := store.MemoryUsage([]byte("missing")); ok {
+		t.Error("Expected missing key to not report memory usage")
+	}
+}
+
+func TestBiggestKeys(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	// Keys with increasing value sizes
+	for i := range 50 {
+		store.Set([]byte(fmt.Sprintf("key%d", i)), make([]byte, i*10), -1)
+	}
+	store.Push([]byte("biglist"), [][]byte{make([]byte, 1000), make([]byte, 1000)}, false)
+
+	// Expired keys must be ignored even if they are big
+	store.Set([]byte("expired"), make([]byte, 10000), time.Now().Add(-time.Second).UnixNano())
+
+	biggest := store.BiggestKeys(3, MeasureMemory)
+	if len(biggest) != 3 {
+		t.Fatalf("Expected 3 keys, got %d", len(biggest))
+	}
+
+	expected := []string{"biglist", "key49", "key48"}
+	for i, stats := range biggest {
+		if string(stats.Key) != expected[i] {
+			t.Errorf("Expected key %s at position %d, got %s", expected[i], i, stats.Key)
+		}
+	}
+
+	if biggest[0].Type != "list" || biggest[0].Elements != 2 {
+		t.Errorf("Expected list with 2 elements, got %s with %d elements", biggest[0].Type, biggest[0].Elements)
+	}
+
+	if biggest[1].Type != "string" || biggest[1].Elements != 490 {
+		t.Errorf("Expected string of 490 bytes, got %s with %d elements", biggest[1].Type, biggest[1].Elements)
+	}
+
+	// Asking for more keys than exist returns all of them
+	if all := store.BiggestKeys(1000, MeasureMemory); len(all) != 51 {
+		t.Errorf("Expected 51 keys, got %d", len(all))
+	}
+}
+
+func TestBiggestKeysByElements(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	// The list uses the most memory but the hash has the most elements
+	store.Push([]byte("list"), [][]byte{make([]byte, 1000), make([]byte, 1000)}, false)
+	store.HashSet([]byte("hash"), [][]byte{[]byte("a"), []byte("1"), []byte("b"), []byte("2"), []byte("c"), []byte("3")})
+	store.Set([]byte("string"), []byte("v"), -1)
+
+	biggest := store.BiggestKeys(2, MeasureElements)
+	if len(biggest) != 2 || string(biggest[0].Key) != "hash" || string(biggest[1].Key) != "list" {
+		t.Fatalf("Expected hash and list ordered by elements, got %v", biggest)
+	}
+	if biggest[0].Elements != 3 {
+		t.Errorf("Expected 3 fields in the hash, got %d", biggest[0].Elements)
+	}
+
+	if byMemory := store.BiggestKeys(1, MeasureMemory); string(byMemory[0].Key) != "list" {
+		t.Errorf("Expected the list to use the most memory, got %s", byMemory[0].Key)
+	}
+}
+
+func TestKeyspaceSummary(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.Set([]byte("short"), []byte("ab"), -1)
+	store.Set([]byte("long"), []byte("abcdef"), -1)
+	store.Push([]byte("list"), [][]byte{[]byte("a"), []byte("b"), []byte("c")}, false)
+	store.Set([]byte("expired"), make([]byte, 100), time.Now().Add(-time.Second).UnixNano())
+
+	summary := store.KeyspaceSummary()
+	if len(summary) != len(summaryTypes) {
+		t.Fatalf("Expected a summary for %d types, got %d", len(summaryTypes), len(summary))
+	}
+
+	strs := summary[0]
+	if strs.Type != "string" || strs.Keys != 2 || strs.Elements != 8 || string(strs.Biggest.Key) != "long" {
+		t.Errorf("Expected 2 strings of 8 bytes with long the biggest, got %+v", strs)
+	}
+	if strs.Size <= strs.Elements {
+		t.Errorf("Expected the size to include the overhead of the keys, got %d", strs.Size)
+	}
+
+	lists := summary[1]
+	if lists.Type != "list" || lists.Keys != 1 || lists.Elements != 3 || string(lists.Biggest.Key) != "list" {
+		t.Errorf("Expected 1 list of 3 elements, got %+v", lists)
+	}
+
+	if hashes := summary[2]; hashes.Type != "hash" || hashes.Keys != 0 || hashes.Biggest.Key != nil {
+		t.Errorf("Expected no hashes, got %+v", hashes)
+	}
+}
+
+func TestBigKeysCommand(t *testing.T) {
+	_, addr := startTestServer(t)
+	client := dialTestServer(t, addr)
+
+	client.do("RPUSH", "list", "a", "b", "c")
+	client.do("SET", "string", string(make([]byte, 1000)))
+
+	byElements, _ := client.do("BIGKEYS", "count", "1", "by", "elements").(resp.RespArray)
+	if len(byElements.Elements) != 1 {
+		t.Fatalf("Expected 1 key, got %v", byElements)
+	}
+	if key := byElements.Elements[0].(resp.RespArray).Elements[0].(resp.RespBulkString); string(key.Value) != "string" {
+		t.Errorf("Expected the 1000 byte string to have the most elements, got %s", key.Value)
+	}
+
+	summary, _ := client.do("BIGKEYS", "SUMMARY").(resp.RespArray)
+	if len(summary.Elements) != len(summaryTypes) {
+		t.Fatalf("Expected a summary for %d types, got %v", len(summaryTypes), summary)
+	}
+	lists, _ := summary.Elements[1].(resp.RespArray)
+	values := make(map[string]resp.RespValue)
+	for i := 0; i+1 < len(lists.Elements); i += 2 {
+		values[string(lists.Elements[i].(resp.RespBulkString).Value)] = lists.Elements[i+1]
+	}
+	if values["keys"] != (resp.RespInteger{Value: 1}) || values["elements"] != (resp.RespInteger{Value: 3}) {
+		t.Errorf("Expected 1 list of 3 elements, got %v", values)
+	}
+	if key, _ := values["biggest-key"].(resp.RespBulkString); string(key.Value) != "list" {
+		t.Errorf("Expected list to be the biggest list, got %v", values["biggest-key"])
+	}
+
+	for _, args := range [][]string{{"BIGKEYS", "BY", "SIZE"}, {"BIGKEYS", "COUNT"}, {"BIGKEYS", "SUMMARY", "COUNT", "1"}} {
+		if _, ok := client.do(args...).(resp.RespErrorValue); !ok {
+			t.Errorf("Expected %v to be rejected", args)
+		}
+	}
+}
+
+func TestMemoryUsageCache(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.Push([]byte("list"), [][]byte{make([]byte, 100)}, false)
+	before, _ := store.MemoryUsage([]byte("list"))
+	if again, _ := store.MemoryUsage([]byte("list")); again != before {
+		t.Errorf("Expected the cached size %d, got %d", before, again)
+	}
+
+	// Writes invalidate the cached size
+	store.Push([]byte("list"), [][]byte{make([]byte, 100)}, false)
+	if after, _ := store.MemoryUsage([]byte("list")); after <= before+100 {
+		t.Errorf("Expected the size to grow by more than 100 bytes after a push, got %d then %d", before, after)
+	}
+}
+
+func TestDatasetSize(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	if keys, bytes := store.DatasetSize(); keys != 0 || bytes != 0 {
+		t.Errorf("Expected an empty dataset, got %d keys and %d bytes", keys, bytes)
+	}
+
+	store.Set([]byte("a"), make([]byte, 10), -1)
+	store.Set([]byte("b"), make([]byte, 20), -1)
+	store.Set([]byte("expired"), make([]byte, 1000), time.Now().Add(-time.Second).UnixNano())
+
+	a, _ := store.MemoryUsage([]byte("a"))
+	b, _ := store.MemoryUsage([]byte("b"))
+	if keys, bytes := store.DatasetSize(); keys != 2 || bytes != a+b {
+		t.Errorf("Expected 2 keys and %d bytes, got %d keys and %d bytes", a+b, keys, bytes)
+	}
+}
+
+func TestMemoryCommand(t *testing.T) {
+	_, addr := startTestServer(t)
+	client := dialTestServer(t, addr)
+
+	client.do("SET", "key", "value")
+	usage, ok := client.do("MEMORY", "USAGE", "key", "SAMPLES", "5").(resp.RespInteger)
+	if !ok || usage.Value <= int64(len("key")+len("value")) {
+		t.Errorf("Expected the size of the key and its value with the overhead, got %v", usage)
+	}
+	if val, _ := client.do("MEMORY", "USAGE", "missing").(resp.RespBulkString); val.Value != nil {
+		t.Errorf("Expected a null reply for a missing key, got %v", val)
+	}
+
+	stats, _ := client.do("MEMORY", "STATS").(resp.RespArray)
+	values := make(map[string]resp.RespValue)
+	for i := 0; i+1 < len(stats.Elements); i += 2 {
+		values[string(stats.Elements[i].(resp.RespBulkString).Value)] = stats.Elements[i+1]
+	}
+	if values["keys.count"] != (resp.RespInteger{Value: 1}) {
+		t.Errorf("Expected 1 key, got %v", values["keys.count"])
+	}
+	if values["dataset.bytes"] != (resp.RespInteger{Value: usage.Value}) {
+		t.Errorf("Expected the dataset to be the size of the key, got %v", values["dataset.bytes"])
+	}
+	if allocated, _ := values["total.allocated"].(resp.RespInteger); allocated.Value <= 0 {
+		t.Errorf("Expected the allocated memory, got %v", values["total.allocated"])
+	}
+
+	if val, _ := client.do("MEMORY", "DOCTOR").(resp.RespErrorValue); !strings.Contains(val.Message, "unknown subcommand") {
+		t.Errorf("Expected an unknown subcommand to be rejected, got %v", val)
+	}
+}
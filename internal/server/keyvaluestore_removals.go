@@ -0,0 +1,57 @@
+package server
+
+// A key or hash fields removed by the store itself rather than by a command, because they expired.
+type Removal struct {
+	Key    []byte
+	Fields [][]byte // Expired hash fields, nil when the whole key was removed
+}
+
+// Starts recording the keys and hash fields the store removes by itself, for TakeRemovals. The
+// channel receives a value when removals are waiting. Used by primaries to tell their replicas, so
+// keys expire at the same time on every node instead of when each node's clock says so.
+func (kv *InMemoryKVStore) TrackRemovals() <-chan struct{} {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if kv.removalSignal == nil {
+		kv.removalSignal = make(chan struct{}, 1)
+	}
+	return kv.removalSignal
+}
+
+// Returns the removals recorded since the last call, in the order they were made.
+func (kv *InMemoryKVStore) TakeRemovals() []Removal {
+	kv.queueMu.Lock()
+	defer kv.queueMu.Unlock()
+
+	removals := kv.removals
+	kv.removals = nil
+	return removals
+}
+
+// Records a removal once TrackRemovals was called.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) recordRemoval(removal Removal) {
+	if kv.removalSignal == nil {
+		return
+	}
+
+	kv.queueMu.Lock()
+	kv.removals = append(kv.removals, removal)
+	kv.queueMu.Unlock()
+	select {
+	case kv.removalSignal <- struct{}{}:
+	default:
+		// Already signaled, the removals are taken together
+	}
+}
+
+// Sets whether expired keys and hash fields are left in the store instead of being removed, like
+// on replicas, which wait for their primary to delete them. Expired keys are still hidden from reads,
+// expired hash fields are only hidden once removed.
+func (kv *InMemoryKVStore) SetPassiveExpiry(passive bool) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.passiveExpiry = passive
+}
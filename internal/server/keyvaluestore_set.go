@@ -0,0 +1,217 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"slices"
+)
+
+// Returns the set stored at key, creating an empty one if create is true.
+// Returns nil if the key does not exist and create is false.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) setEntry(key []byte, create bool) (*Entry, error) {
+	entry, exists := kv.entry(string(key))
+	if exists && entry.isExpired() {
+		kv.expireKey(string(key))
+		exists = false
+	}
+
+	if exists {
+		if entry.kind != kindSet {
+			return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+		return kv.mutable(string(key), entry), nil
+	}
+
+	if !create {
+		return nil, nil
+	}
+
+	entry = NewSetEntry(-1)
+	kv.addKey(string(key), entry)
+	return entry, nil
+}
+
+func (kv *InMemoryKVStore) SetAdd(key []byte, members [][]byte) (int64, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.setEntry(key, true)
+	if err != nil {
+		return 0, err
+	}
+
+	var added int64 = 0
+	for _, member := range members {
+		if _, exists := entry.set[string(member)]; !exists {
+			entry.set[string(member)] = struct{}{}
+			added++
+		}
+	}
+	if added > 0 {
+		kv.touch(entry)
+		kv.notify(EventSet, "sadd", key)
+	}
+
+	return added, nil
+}
+
+func (kv *InMemoryKVStore) SetRemove(key []byte, members [][]byte) (int64, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.setEntry(key, false)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+
+	var removed int64 = 0
+	for _, member := range members {
+		if _, exists := entry.set[string(member)]; exists {
+			delete(entry.set, string(member))
+			removed++
+		}
+	}
+	if removed > 0 {
+		kv.touch(entry)
+		kv.notify(EventSet, "srem", key)
+	}
+
+	// Sets are removed once their last member is removed
+	if len(entry.set) == 0 {
+		kv.deleteKey(string(key))
+		kv.notify(EventGeneric, "del", key)
+	}
+
+	return removed, nil
+}
+
+func (kv *InMemoryKVStore) SetContains(key []byte, members [][]byte) ([]bool, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.setEntry(key, false)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]bool, len(members))
+	if entry == nil {
+		return found, nil
+	}
+
+	for i, member := range members {
+		_, found[i] = entry.set[string(member)]
+	}
+
+	return found, nil
+}
+
+func (kv *InMemoryKVStore) SetMembers(key []byte) ([][]byte, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.setEntry(key, false)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	members := make([][]byte, 0, len(entry.set))
+	for member := range entry.set {
+		members = append(members, []byte(member))
+	}
+
+	return members, nil
+}
+
+func (kv *InMemoryKVStore) SetLen(key []byte) (int64, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.setEntry(key, false)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+
+	return int64(len(entry.set)), nil
+}
+
+// Returns the position of a member in the scan order.
+func scanHash(member string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(member))
+	return h.Sum64()
+}
+
+type scanItem struct {
+	hash   uint64
+	member string
+}
+
+// Iterates the set in the order of the members' hashes. The cursor is the hash to resume from,
+// so members present for the whole iteration are returned even if the set changes between calls.
+func (kv *InMemoryKVStore) SetScan(key []byte, cursor uint64, count int) ([][]byte, uint64, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return nil, 0, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.setEntry(key, false)
+	if err != nil || entry == nil {
+		return nil, 0, err
+	}
+
+	var items []scanItem
+	for member := range entry.set {
+		if hash := scanHash(member); hash >= cursor {
+			items = append(items, scanItem{hash, member})
+		}
+	}
+
+	slices.SortFunc(items, func(a, b scanItem) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+
+	// Members sharing a hash are always returned in the same call
+	end := min(count, len(items))
+	for end > 0 && end < len(items) && items[end].hash == items[end-1].hash {
+		end++
+	}
+
+	members := make([][]byte, end)
+	for i, item := range items[:end] {
+		members[i] = []byte(item.member)
+	}
+
+	// A zero cursor signals the iteration is complete
+	var next uint64 = 0
+	if end < len(items) && items[end-1].hash < math.MaxUint64 {
+		next = items[end-1].hash + 1
+	}
+
+	return members, next, nil
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSetAddAndRemove(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("tags")
+
+	added, err := store.SetAdd(key, [][]byte{[]byte("a"), []byte("b"), []byte("a")})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if added != 2 {
+		t.Errorf("Expected 2 members added, got %d", added)
+	}
+
+	length, _ := store.SetLen(key)
+	if length != 2 {
+		t.Errorf("Expected length 2, got %d", length)
+	}
+
+	removed, err := store.SetRemove(key, [][]byte{[]byte("a"), []byte("missing")})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 member removed, got %d", removed)
+	}
+
+	store.SetRemove(key, [][]byte{[]byte("b")})
+	if store.Exists([][]byte{key}) != 0 {
+		t.Error("Expected set to be deleted after removing the last member")
+	}
+}
+
+func TestSetContains(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("tags")
+	store.SetAdd(key, [][]byte{[]byte("a"), []byte("b")})
+
+	found, err := store.SetContains(key, [][]byte{[]byte("a"), []byte("c"), []byte("b")})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(found) != 3 || !found[0] || found[1] || !found[2] {
+		t.Errorf("Expected [true false true], got %v", found)
+	}
+
+	found, _ = store.SetContains([]byte("missing"), [][]byte{[]byte("a")})
+	if len(found) != 1 || found[0] {
+		t.Errorf("Expected [false] for missing key, got %v", found)
+	}
+
+	members, _ := store.SetMembers(key)
+	if len(members) != 2 {
+		t.Errorf("Expected 2 members, got %d", len(members))
+	}
+}
+
+func TestSetWrongType(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.Set([]byte("string_key"), []byte("value"), -1)
+
+	if _, err := store.SetAdd([]byte("string_key"), [][]byte{[]byte("a")}); err == nil {
+		t.Error("Expected error when adding to a string")
+	}
+
+	if _, _, err := store.SetScan([]byte("string_key"), 0, 10); err == nil {
+		t.Error("Expected error when scanning a string")
+	}
+}
+
+func TestSetScan(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("members")
+	for i := range 100 {
+		store.SetAdd(key, [][]byte{[]byte(fmt.Sprintf("member%d", i))})
+	}
+
+	seen := make(map[string]bool)
+	var cursor uint64 = 0
+	calls := 0
+
+	for {
+		members, next, err := store.SetScan(key, cursor, 10)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		for _, member := range members {
+			seen[string(member)] = true
+		}
+
+		// Add and remove members between calls
+		if calls == 2 {
+			store.SetAdd(key, [][]byte{[]byte("added")})
+			store.SetRemove(key, [][]byte{[]byte("member99")})
+		}
+
+		calls++
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+
+	if calls < 10 {
+		t.Errorf("Expected the scan to take at least 10 calls, took %d", calls)
+	}
+
+	// Every member present for the whole iteration must be returned
+	for i := range 99 {
+		if !seen[fmt.Sprintf("member%d", i)] {
+			t.Errorf("Expected member%d to be returned", i)
+		}
+	}
+
+	members, next, _ := store.SetScan([]byte("missing"), 0, 10)
+	if members != nil || next != 0 {
+		t.Errorf("Expected empty scan for missing key, got %v %d", members, next)
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"iter"
+	"math/bits"
+	"math/rand/v2"
+	"sync"
+)
+
+// The keyspace is split into shards by the hash of the key names, each with its own lock, so
+// operations on keys of different shards do not wait for each other. The store lock is read locked
+// by every operation on keys, and write locked by the operations on the whole store, like snapshots
+// or evictions, which can then use every shard without locking them.
+//
+// Lock order: the store lock, then the shards in ascending order, then queueMu.
+const storeShards = 64 // A power of two, at most 64 so a shardSet holds every shard
+
+// A part of the keyspace. Guarded by its lock, or by the store lock when it is write locked.
+type storeShard struct {
+	mu             sync.RWMutex
+	entries        map[string]*Entry
+	expiries       *expiryQueue        // Keys with an expiration time, earliest first
+	fieldExpirable map[string]struct{} // Hash keys with at least one field TTL
+	resized        map[string]struct{} // Keys written since usedMemory was updated
+	unflushed      map[string]struct{} // Keys changed since they were last written to the backend
+	flushing       map[string]struct{} // Keys being written to the backend
+}
+
+func (sh *storeShard) init() {
+	sh.entries = make(map[string]*Entry)
+	sh.expiries = newExpiryQueue()
+	sh.fieldExpirable = make(map[string]struct{})
+	sh.resized = make(map[string]struct{})
+}
+
+// Set of shards, one bit per shard index.
+type shardSet uint64
+
+// Returns the index of the shard holding key, from its FNV-1a hash.
+func shardIndex(key string) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return int(hash & (storeShards - 1))
+}
+
+// Returns the shard holding key.
+func (kv *InMemoryKVStore) shard(key string) *storeShard {
+	return &kv.shards[shardIndex(key)]
+}
+
+// Returns the shards holding the keys.
+func shardsOf(keys [][]byte) shardSet {
+	var set shardSet
+	for _, key := range keys {
+		set |= 1 << shardIndex(string(key))
+	}
+	return set
+}
+
+// Locks the store for an operation writing the given keys: the store lock is read locked and the
+// shards of the keys are write locked in ascending order, so operations on several keys never
+// deadlock. Returns the shards to pass to unlockKeys.
+func (kv *InMemoryKVStore) lockKeys(keys ...[]byte) shardSet {
+	set := shardsOf(keys)
+	kv.mu.RLock()
+	for rest := set; rest != 0; rest &= rest - 1 {
+		kv.shards[bits.TrailingZeros64(uint64(rest))].mu.Lock()
+	}
+	return set
+}
+
+// Unlocks the shards locked by lockKeys and the store.
+func (kv *InMemoryKVStore) unlockKeys(set shardSet) {
+	for rest := set; rest != 0; rest &= rest - 1 {
+		kv.shards[bits.TrailingZeros64(uint64(rest))].mu.Unlock()
+	}
+	kv.mu.RUnlock()
+}
+
+// Like lockKeys for operations only reading the keys, which run at the same time as other reads of
+// the same shards.
+func (kv *InMemoryKVStore) rlockKeys(keys ...[]byte) shardSet {
+	set := shardsOf(keys)
+	kv.mu.RLock()
+	for rest := set; rest != 0; rest &= rest - 1 {
+		kv.shards[bits.TrailingZeros64(uint64(rest))].mu.RLock()
+	}
+	return set
+}
+
+// Unlocks the shards locked by rlockKeys and the store.
+func (kv *InMemoryKVStore) runlockKeys(set shardSet) {
+	for rest := set; rest != 0; rest &= rest - 1 {
+		kv.shards[bits.TrailingZeros64(uint64(rest))].mu.RUnlock()
+	}
+	kv.mu.RUnlock()
+}
+
+// Returns every shard, starting from a random one so samples of the keys are taken from every shard.
+// Map iteration already starts at a random key within each shard.
+func (kv *InMemoryKVStore) shardsFromRandom() iter.Seq[*storeShard] {
+	start := rand.IntN(storeShards)
+	return func(yield func(*storeShard) bool) {
+		for i := range storeShards {
+			if !yield(&kv.shards[(start+i)%storeShards]) {
+				return
+			}
+		}
+	}
+}
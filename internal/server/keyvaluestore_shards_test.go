@@ -0,0 +1,191 @@
+package server
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Returns the entry stored at key, including expired ones, without loading it from a backend.
+func storedEntry(store *InMemoryKVStore, key string) (*Entry, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	entry, exists := store.shard(key).entries[key]
+	return entry, exists
+}
+
+// Returns the number of keys held in memory, including expired ones.
+func storedKeys(store *InMemoryKVStore) int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.hotKeys()
+}
+
+// Returns the number of keys in the expiry queues and of hashes with field TTLs.
+func storedExpirable(store *InMemoryKVStore) (keys, hashes int) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for i := range store.shards {
+		keys += store.shards[i].expiries.Len()
+		hashes += len(store.shards[i].fieldExpirable)
+	}
+	return keys, hashes
+}
+
+func TestShardsOf(t *testing.T) {
+	keys := make([][]byte, 1000)
+	for i := range keys {
+		keys[i] = []byte("key:" + strconv.Itoa(i))
+	}
+
+	set := shardsOf(keys)
+	if count := bits.OnesCount64(uint64(set)); count != storeShards {
+		t.Errorf("Expected 1000 keys to spread over every shard, got %d", count)
+	}
+	for _, key := range keys[:10] {
+		if set&(1<<shardIndex(string(key))) == 0 {
+			t.Errorf("Expected the shard of %s to be in the set", key)
+		}
+	}
+	if shardsOf(nil) != 0 {
+		t.Error("Expected no shards without keys")
+	}
+}
+
+func TestShardedConcurrentAccess(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	const workers = 8
+	const rounds = 500
+
+	// Operations on several keys lock their shards in the same order, so workers using the same keys
+	// in different orders never deadlock
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range rounds {
+				a := []byte(fmt.Sprintf("list:%d", (w+i)%16))
+				b := []byte(fmt.Sprintf("list:%d", (w+i+1)%16))
+				if w%2 == 1 {
+					a, b = b, a
+				}
+
+				store.Push(a, [][]byte{[]byte("x")}, false)
+				store.Move(a, b, true, false)
+				store.Set([]byte(fmt.Sprintf("string:%d:%d", w, i)), []byte("v"), -1)
+				store.GetValues([][]byte{a, b, []byte("string:0:0")})
+				store.SortedSetAdd([]byte(fmt.Sprintf("zset:%d", w)), []ScoredMember{{Member: []byte(strconv.Itoa(i)), Score: 1}}, ZAddOptions{})
+				store.SortedSetStore([]byte(fmt.Sprintf("zset:%d", (w+1)%workers)), [][]byte{[]byte(fmt.Sprintf("zset:%d", w))}, ZStoreOptions{})
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("Expected concurrent operations on several keys to finish")
+	}
+
+	for w := range workers {
+		for i := range rounds {
+			if value, _ := store.GetValue([]byte(fmt.Sprintf("string:%d:%d", w, i))); string(value) != "v" {
+				t.Fatalf("Expected string:%d:%d to be set, got %q", w, i, value)
+			}
+		}
+		if count, _ := store.SortedSetLen([]byte(fmt.Sprintf("zset:%d", w))); count == 0 {
+			t.Errorf("Expected zset:%d to hold members", w)
+		}
+	}
+}
+
+// GET runs outside the server loop while BITFIELD and PFADD write the same keys, run with -race
+// to catch values read without the lock.
+func TestGetValueDuringStringWrites(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	bit := BitFieldEncoding{Bits: 1}
+	store.BitField([]byte("bitmap"), []BitFieldOp{{Kind: BitFieldSet, Encoding: bit, Value: 1}})
+	store.HyperLogLogAdd([]byte("hll"), [][]byte{[]byte("first")})
+
+	const writes = 500
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for i := range writes {
+			store.BitField([]byte("bitmap"), []BitFieldOp{{Kind: BitFieldSet, Encoding: bit, Offset: uint64(i), Value: 1}})
+		}
+	})
+	wg.Go(func() {
+		for i := range writes {
+			store.HyperLogLogAdd([]byte("hll"), [][]byte{[]byte(strconv.Itoa(i))})
+			store.HyperLogLogCount([][]byte{[]byte("hll")})
+			store.HyperLogLogMerge([]byte("merged"), [][]byte{[]byte("hll")})
+		}
+	})
+
+	for range 2 {
+		wg.Go(func() {
+			for range writes {
+				if bitmap, err := store.GetValue([]byte("bitmap")); err != nil || len(bitmap) == 0 || bitmap[0]&0x80 == 0 {
+					t.Errorf("Expected the bitmap with its first bit set, got %v (%v)", bitmap, err)
+					return
+				}
+				for _, key := range []string{"hll", "merged"} {
+					if h, _ := store.GetValue([]byte(key)); h != nil && string(h[:4]) != "HYLL" {
+						t.Errorf("Expected a HyperLogLog at %s, got %q", key, h)
+						return
+					}
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func TestListReadsShareTheShard(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("list")
+	store.Push(key, [][]byte{[]byte("a"), []byte("b")}, false)
+
+	// Reads only need the read lock, so they run while another reader holds the shard
+	locked := store.rlockKeys(key)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		store.GetList(key)
+		store.GetRange(key, 0, -1)
+		store.ListLen(key)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Expected list reads to run while the shard is read locked")
+	}
+	store.runlockKeys(locked)
+	<-done
+
+	// An expired list is removed by the read that finds it
+	store.Expire(key, time.Now().Add(-time.Second).UnixNano())
+	if length, err := store.ListLen(key); err != nil || length != 0 {
+		t.Errorf("Expected an expired list to be empty, got %d (%v)", length, err)
+	}
+	if _, exists := storedEntry(store, "list"); exists {
+		t.Error("Expected the expired list to be removed")
+	}
+}
@@ -0,0 +1,604 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"time"
+)
+
+// Binary snapshot format. A snapshot starts with the magic string, the format version and the
+// metadata as a count of key/value pairs, followed by one record per key and the end marker with a CRC-32 (IEEE) checksum of everything before it.
+// Lengths, counts and stream IDs are unsigned varints and times are signed varints in unix
+// nanoseconds, 0 meaning no expiration. A record is the kind of the value, the key, its expiration
+// and the value:
+//
+//	string:     bytes
+//	list:       count, elements
+//	hash:       count, (field, value, field expiration) per field
+//	set:        count, members
+//	sorted set: count, (member, score as float64 bits) per member in score order
+//	stream:     last ID, count, (ID, field/value count, fields) per entry, group count,
+//	            (name, last delivered ID, consumer count, consumer names, pending count,
+//	            (ID, consumer, delivery time in unix milliseconds, deliveries) per pending entry) per group
+const (
+	snapshotMagic     = "GOPHERSTORE"
+	snapshotVersion   = 2 // Version 1 had no metadata
+	snapshotEndMarker = 0xFF
+)
+
+var errSnapshotInProgress = errors.New("a snapshot is already in progress")
+
+// Keys of the store as they were when BeginSnapshot was called, waiting to be written.
+type StoreSnapshot struct {
+	kv      *InMemoryKVStore
+	entries map[string]*Entry
+}
+
+// Starts a snapshot of every key of the store as they are now. The store is only locked while the
+// keys are listed: entries changed before the snapshot is released are copied first, so writers
+// never wait for the snapshot to be written. Only one snapshot can be in progress at a time.
+func (kv *InMemoryKVStore) BeginSnapshot() (*StoreSnapshot, error) {
+	frozen, err := kv.freeze()
+	if err != nil {
+		return nil, err
+	}
+	return &StoreSnapshot{kv: kv, entries: frozen}, nil
+}
+
+// Writes the keys to w in the binary snapshot format along with metadata, read back with
+// ReadSnapshotMetadata. Keys that already expired are skipped.
+func (s *StoreSnapshot) Encode(w io.Writer, metadata map[string]string) error {
+	return writeSnapshotEntries(w, s.entries, metadata)
+}
+
+// Ends the snapshot, letting entries be changed in place again.
+func (s *StoreSnapshot) Release() {
+	s.kv.thaw()
+}
+
+// Writes every key of the store to w in the binary snapshot format, as they were when it was called.
+func (kv *InMemoryKVStore) WriteSnapshot(w io.Writer) error {
+	snapshot, err := kv.BeginSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snapshot.Release()
+
+	return snapshot.Encode(w, nil)
+}
+
+// Starts a snapshot, returning the entries it will write. The entries are not changed until thaw.
+func (kv *InMemoryKVStore) freeze() (map[string]*Entry, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if kv.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+	if kv.frozen != nil {
+		return nil, errSnapshotInProgress
+	}
+	if kv.backend != nil {
+		return nil, fmt.Errorf("snapshots are not supported with a storage backend, which already persists every key")
+	}
+
+	frozen := make(map[string]*Entry)
+	for i := range kv.shards {
+		maps.Copy(frozen, kv.shards[i].entries)
+	}
+	kv.frozen = frozen
+	return frozen, nil
+}
+
+// Ends a snapshot, letting entries be changed in place again.
+func (kv *InMemoryKVStore) thaw() {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.frozen = nil
+}
+
+// Returns the entry stored at key ready to be changed in place. Entries still being written by a
+// snapshot are replaced by a copy first, so the snapshot sees them as they were when it started.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) mutable(key string, entry *Entry) *Entry {
+	if kv.frozen == nil || kv.frozen[key] != entry {
+		return entry
+	}
+
+	clone := entry.clone()
+	kv.shard(key).entries[key] = clone
+	return clone
+}
+
+// Returns a deep copy of an entry. Elements of lists and streams are shared since they are never
+// changed in place.
+func (e *Entry) clone() *Entry {
+	c := *e
+	switch e.kind {
+	case kindString:
+		c.value = bytes.Clone(e.value)
+	case kindList:
+		c.list = slices.Clone(e.list)
+	case kindHash:
+		c.hash = maps.Clone(e.hash)
+		c.fieldExpiresAt = maps.Clone(e.fieldExpiresAt)
+	case kindSet:
+		c.set = maps.Clone(e.set)
+	case kindSortedSet:
+		c.zset = newSortedSet()
+		for x := e.zset.header.level[0].forward; x != nil; x = x.level[0].forward {
+			c.zset.add(x.member, x.score)
+		}
+	case kindStream:
+		c.stream = e.stream.clone()
+	}
+	return &c
+}
+
+func (s *stream) clone() *stream {
+	c := &stream{entries: slices.Clone(s.entries), lastID: s.lastID}
+	if s.groups == nil {
+		return c
+	}
+
+	c.groups = make(map[string]*consumerGroup, len(s.groups))
+	for name, g := range s.groups {
+		cg := newConsumerGroup(g.lastID)
+		for consumer := range g.consumers {
+			cg.consumer(consumer, true)
+		}
+		cg.pel = make([]*pendingEntry, len(g.pel))
+		for i, pe := range g.pel {
+			cpe := *pe
+			cpe.consumer = cg.consumers[pe.consumer.name]
+			cpe.consumer.pending[cpe.id] = &cpe
+			cg.pel[i] = &cpe
+		}
+		c.groups[name] = cg
+	}
+	return c
+}
+
+// Writes entries in the binary snapshot format.
+func writeSnapshotEntries(w io.Writer, entries map[string]*Entry, metadata map[string]string) error {
+	checksum := crc32.NewIEEE()
+	out := bufio.NewWriter(io.MultiWriter(w, checksum))
+
+	var buf []byte
+	buf = append(buf, snapshotMagic...)
+	buf = append(buf, snapshotVersion)
+	buf = binary.AppendUvarint(buf, uint64(len(metadata)))
+	for key, value := range metadata {
+		buf = appendSnapshotBytes(buf, []byte(key))
+		buf = appendSnapshotBytes(buf, []byte(value))
+	}
+
+	now := time.Now().UnixNano()
+	for key, entry := range entries {
+		if entry.expiresAt > 0 && entry.expiresAt < now {
+			continue
+		}
+
+		buf = append(buf, byte(entry.kind))
+		buf = appendSnapshotBytes(buf, []byte(key))
+		buf = binary.AppendVarint(buf, max(entry.expiresAt, 0))
+		buf = appendSnapshotValue(buf, entry)
+
+		// Flush large buffers so huge keyspaces are not held in memory twice
+		if len(buf) >= 64*1024 {
+			if _, err := out.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+
+	buf = append(buf, snapshotEndMarker)
+	if _, err := out.Write(buf); err != nil {
+		return err
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+
+	_, err := w.Write(binary.LittleEndian.AppendUint32(nil, checksum.Sum32()))
+	return err
+}
+
+func appendSnapshotBytes(buf, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func appendSnapshotID(buf []byte, id StreamID) []byte {
+	buf = binary.AppendUvarint(buf, id.Ms)
+	return binary.AppendUvarint(buf, id.Seq)
+}
+
+// Appends the value of an entry in the snapshot format.
+func appendSnapshotValue(buf []byte, entry *Entry) []byte {
+	switch entry.kind {
+	case kindString:
+		buf = appendSnapshotBytes(buf, entry.value)
+	case kindList:
+		buf = binary.AppendUvarint(buf, uint64(len(entry.list)))
+		for _, element := range entry.list {
+			buf = appendSnapshotBytes(buf, element)
+		}
+	case kindHash:
+		buf = binary.AppendUvarint(buf, uint64(len(entry.hash)))
+		for field, value := range entry.hash {
+			buf = appendSnapshotBytes(buf, []byte(field))
+			buf = appendSnapshotBytes(buf, value)
+			buf = binary.AppendVarint(buf, entry.fieldExpiresAt[field])
+		}
+	case kindSet:
+		buf = binary.AppendUvarint(buf, uint64(len(entry.set)))
+		for member := range entry.set {
+			buf = appendSnapshotBytes(buf, []byte(member))
+		}
+	case kindSortedSet:
+		buf = binary.AppendUvarint(buf, uint64(entry.zset.len()))
+		for x := entry.zset.header.level[0].forward; x != nil; x = x.level[0].forward {
+			buf = appendSnapshotBytes(buf, []byte(x.member))
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(x.score))
+		}
+	case kindStream:
+		buf = appendSnapshotStream(buf, entry.stream)
+	}
+	return buf
+}
+
+func appendSnapshotStream(buf []byte, s *stream) []byte {
+	buf = appendSnapshotID(buf, s.lastID)
+	buf = binary.AppendUvarint(buf, uint64(len(s.entries)))
+	for _, e := range s.entries {
+		buf = appendSnapshotID(buf, e.ID)
+		buf = binary.AppendUvarint(buf, uint64(len(e.Fields)))
+		for _, field := range e.Fields {
+			buf = appendSnapshotBytes(buf, field)
+		}
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(len(s.groups)))
+	for name, g := range s.groups {
+		buf = appendSnapshotBytes(buf, []byte(name))
+		buf = appendSnapshotID(buf, g.lastID)
+		buf = binary.AppendUvarint(buf, uint64(len(g.consumers)))
+		for consumer := range g.consumers {
+			buf = appendSnapshotBytes(buf, []byte(consumer))
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(g.pel)))
+		for _, pe := range g.pel {
+			buf = appendSnapshotID(buf, pe.id)
+			buf = appendSnapshotBytes(buf, []byte(pe.consumer.name))
+			buf = binary.AppendVarint(buf, pe.deliveredAt)
+			buf = binary.AppendUvarint(buf, uint64(pe.deliveries))
+		}
+	}
+	return buf
+}
+
+var errCorruptSnapshot = errors.New("snapshot is corrupt")
+
+// Reads the keys of a snapshot written by WriteSnapshot into the store, replacing existing keys with
+// the same names. The whole snapshot is verified before the store is changed, so a corrupt snapshot
+// leaves it untouched. Keys that expired since the snapshot was written are skipped, the default TTL
+// is not applied to loaded keys and no keyspace events are sent. Returns the number of keys loaded.
+func (kv *InMemoryKVStore) LoadSnapshot(r io.Reader) (int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+
+	header := len(snapshotMagic) + 1
+	if len(data) < header+5 {
+		return 0, fmt.Errorf("not a snapshot")
+	}
+	version, err := readSnapshotHeader(data[:header])
+	if err != nil {
+		return 0, err
+	}
+
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum || body[len(body)-1] != snapshotEndMarker {
+		return 0, errCorruptSnapshot
+	}
+
+	// Decode every record before taking the lock
+	d := &snapshotDecoder{data: body[header : len(body)-1]}
+	if version > 1 {
+		d.metadata()
+	}
+	keys := make(map[string]*Entry)
+	for len(d.data) > 0 && d.err == nil {
+		kind := entryKind(d.byte())
+		key := string(d.bytes())
+		entry := &Entry{kind: kind, expiresAt: d.varint()}
+		d.value(entry)
+		keys[key] = entry
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	return kv.restoreEntries(keys, false, true)
+}
+
+var errBusyKey = errors.New("BUSYKEY target key name already exists")
+
+// Stores decoded entries, replacing existing keys with the same names and skipping expired ones.
+// Without replace, nothing is stored and errBusyKey is returned if one of the keys exists. With
+// notify, a restore event is sent for each key. Returns the number of keys stored.
+func (kv *InMemoryKVStore) restoreEntries(keys map[string]*Entry, notify, replace bool) (int, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+	if !replace {
+		for key := range keys {
+			if old, exists := kv.shard(key).entries[key]; exists && !old.isExpired() {
+				return 0, errBusyKey
+			}
+		}
+	}
+
+	restored := 0
+	for key, entry := range keys {
+		if entry.isExpired() {
+			continue
+		}
+
+		sh := kv.shard(key)
+		if old, exists := sh.entries[key]; exists {
+			kv.releaseMemory(old)
+			kv.lazyFree(old)
+		}
+		sh.entries[key] = entry
+		kv.touch(entry)
+		kv.markResized(key)
+		if entry.expiresAt > 0 {
+			sh.expiries.set(key, entry.expiresAt)
+		} else {
+			sh.expiries.remove(key)
+		}
+		if len(entry.fieldExpiresAt) > 0 {
+			sh.fieldExpirable[key] = struct{}{}
+		} else {
+			delete(sh.fieldExpirable, key)
+		}
+		if notify {
+			kv.notify(EventGeneric, "restore", []byte(key))
+		} else {
+			kv.markUnflushed(key)
+		}
+		restored++
+	}
+
+	return restored, nil
+}
+
+// Reads the metadata written with a snapshot without reading its keys, nil for snapshots written
+// without metadata. The checksum is not verified, LoadSnapshot verifies it.
+func ReadSnapshotMetadata(r io.Reader) (map[string]string, error) {
+	reader := bufio.NewReader(r)
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("not a snapshot")
+	}
+	version, err := readSnapshotHeader(header)
+	if err != nil || version == 1 {
+		return nil, err
+	}
+
+	// Metadata is small, decode it from a prefix of the snapshot
+	prefix, err := reader.Peek(reader.Size())
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	d := &snapshotDecoder{data: prefix}
+	metadata := d.metadata()
+	if d.err != nil {
+		return nil, d.err
+	}
+	return metadata, nil
+}
+
+// Checks the magic string and returns the format version of a snapshot header.
+func readSnapshotHeader(header []byte) (byte, error) {
+	if !bytes.Equal(header[:len(snapshotMagic)], []byte(snapshotMagic)) {
+		return 0, fmt.Errorf("not a snapshot")
+	}
+	version := header[len(snapshotMagic)]
+	if version < 1 || version > snapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	return version, nil
+}
+
+// Decodes the records of a snapshot, remembering the first error. Values decoded after an error are
+// zero.
+type snapshotDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *snapshotDecoder) fail() {
+	if d.err == nil {
+		d.err = errCorruptSnapshot
+	}
+	d.data = nil
+}
+
+func (d *snapshotDecoder) byte() byte {
+	if len(d.data) < 1 {
+		d.fail()
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *snapshotDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *snapshotDecoder) varint() int64 {
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// Returns a count, failing if the remaining data cannot hold that many elements of at least one byte.
+func (d *snapshotDecoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (d *snapshotDecoder) bytes() []byte {
+	n := d.count()
+	if d.err != nil {
+		return nil
+	}
+	b := bytes.Clone(d.data[:n])
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *snapshotDecoder) float() float64 {
+	if len(d.data) < 8 {
+		d.fail()
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.data))
+	d.data = d.data[8:]
+	return v
+}
+
+func (d *snapshotDecoder) id() StreamID {
+	return StreamID{Ms: d.uvarint(), Seq: d.uvarint()}
+}
+
+// Decodes the value of an entry of the given kind.
+func (d *snapshotDecoder) metadata() map[string]string {
+	n := d.count()
+	if n == 0 {
+		return nil
+	}
+
+	metadata := make(map[string]string, n)
+	for range n {
+		key := string(d.bytes())
+		metadata[key] = string(d.bytes())
+	}
+	return metadata
+}
+
+func (d *snapshotDecoder) value(entry *Entry) {
+	switch entry.kind {
+	case kindString:
+		entry.value = d.bytes()
+	case kindList:
+		n := d.count()
+		entry.list = make([][]byte, n)
+		for i := range n {
+			entry.list[i] = d.bytes()
+		}
+	case kindHash:
+		n := d.count()
+		entry.hash = make(map[string][]byte, n)
+		for range n {
+			field := string(d.bytes())
+			entry.hash[field] = d.bytes()
+			if expiresAt := d.varint(); expiresAt > 0 {
+				if entry.fieldExpiresAt == nil {
+					entry.fieldExpiresAt = make(map[string]int64)
+				}
+				entry.fieldExpiresAt[field] = expiresAt
+			}
+		}
+	case kindSet:
+		n := d.count()
+		entry.set = make(map[string]struct{}, n)
+		for range n {
+			entry.set[string(d.bytes())] = struct{}{}
+		}
+	case kindSortedSet:
+		entry.zset = newSortedSet()
+		for range d.count() {
+			member := string(d.bytes())
+			entry.zset.add(member, d.float())
+		}
+	case kindStream:
+		entry.stream = d.stream()
+	default:
+		d.fail()
+	}
+}
+
+func (d *snapshotDecoder) stream() *stream {
+	s := newStream()
+	s.lastID = d.id()
+
+	n := d.count()
+	s.entries = make([]StreamEntry, n)
+	for i := range n {
+		s.entries[i].ID = d.id()
+		fields := d.count()
+		s.entries[i].Fields = make([][]byte, fields)
+		for j := range fields {
+			s.entries[i].Fields[j] = d.bytes()
+		}
+	}
+
+	groups := d.count()
+	if groups > 0 {
+		s.groups = make(map[string]*consumerGroup, groups)
+	}
+	for range groups {
+		name := string(d.bytes())
+		g := newConsumerGroup(d.id())
+		for range d.count() {
+			g.consumer(string(d.bytes()), true)
+		}
+
+		pending := d.count()
+		g.pel = make([]*pendingEntry, 0, pending)
+		for range pending {
+			pe := &pendingEntry{id: d.id()}
+			pe.consumer = g.consumer(string(d.bytes()), true)
+			pe.deliveredAt = d.varint()
+			pe.deliveries = int64(d.uvarint())
+			pe.consumer.pending[pe.id] = pe
+			g.pel = append(g.pel, pe)
+		}
+		s.groups[name] = g
+	}
+	return s
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	expiresAt := time.Now().Add(time.Hour).UnixNano()
+	store.Set([]byte("string"), []byte("value"), expiresAt)
+	store.Set([]byte("expired"), []byte("value"), time.Now().Add(-time.Second).UnixNano())
+	store.Push([]byte("list"), [][]byte{[]byte("a"), []byte("b")}, false)
+	store.HashSet([]byte("hash"), [][]byte{[]byte("f1"), []byte("v1"), []byte("f2"), []byte("v2")})
+	store.HashExpire([]byte("hash"), [][]byte{[]byte("f1")}, expiresAt)
+	store.SetAdd([]byte("set"), [][]byte{[]byte("x"), []byte("y")})
+	store.SortedSetAdd([]byte("zset"), []ScoredMember{{[]byte("low"), 1}, {[]byte("high"), 2.5}}, ZAddOptions{})
+	store.StreamAdd([]byte("stream"), [][]byte{[]byte("type"), []byte("login")}, XAddOptions{ID: StreamID{1, 0}, MaxLen: -1})
+	store.StreamAdd([]byte("stream"), [][]byte{[]byte("type"), []byte("logout")}, XAddOptions{ID: StreamID{2, 0}, MaxLen: -1})
+	store.StreamGroupCreate([]byte("stream"), []byte("workers"), StreamID{}, false)
+	store.StreamConsumerCreate([]byte("stream"), []byte("workers"), []byte("idle"))
+	store.StreamReadGroup([]byte("stream"), []byte("workers"), XReadGroupOptions{Consumer: []byte("alice"), New: true, Count: 1})
+
+	var buf bytes.Buffer
+	if err := store.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+
+	loaded := NewInMemoryKVStore()
+	defer loaded.Close()
+	keys, err := loaded.LoadSnapshot(bytes.NewReader(buf.Bytes()))
+	if err != nil || keys != 6 {
+		t.Fatalf("Expected 6 keys to be loaded without the expired one, got %d (%v)", keys, err)
+	}
+
+	if value, _ := loaded.GetValue([]byte("string")); string(value) != "value" {
+		t.Errorf("Expected string value, got %q", value)
+	}
+	if got := loaded.ExpiresAt([]byte("string")); got != expiresAt {
+		t.Errorf("Expected expiration %d, got %d", expiresAt, got)
+	}
+	if list, _ := loaded.GetList([]byte("list")); len(list) != 2 || string(list[1]) != "b" {
+		t.Errorf("Expected list [a b], got %q", list)
+	}
+	if ttls, _ := loaded.HashTTL([]byte("hash"), [][]byte{[]byte("f1"), []byte("f2")}); ttls[0] <= 0 || ttls[1] != -1 {
+		t.Errorf("Expected only f1 to have a TTL, got %v", ttls)
+	}
+	if members, _ := loaded.SetMembers([]byte("set")); len(members) != 2 {
+		t.Errorf("Expected 2 set members, got %q", members)
+	}
+	if members, _ := loaded.SortedSetRange([]byte("zset"), 0, -1, false); len(members) != 2 || string(members[1].Member) != "high" || members[1].Score != 2.5 {
+		t.Errorf("Expected sorted set members in score order, got %v", members)
+	}
+
+	entries, _ := loaded.StreamRange([]byte("stream"), minStreamID, maxStreamID, -1, false)
+	if len(entries) != 2 || string(entries[1].Fields[1]) != "logout" {
+		t.Errorf("Expected 2 stream entries, got %v", entries)
+	}
+	summary, err := loaded.StreamPendingSummary([]byte("stream"), []byte("workers"))
+	if err != nil || summary.Count != 1 {
+		t.Errorf("Expected 1 pending entry, got %+v (%v)", summary, err)
+	}
+	if created, _ := loaded.StreamConsumerCreate([]byte("stream"), []byte("workers"), []byte("idle")); created {
+		t.Error("Expected the consumer without pending entries to be restored")
+	}
+	next, _ := loaded.StreamReadGroup([]byte("stream"), []byte("workers"), XReadGroupOptions{Consumer: []byte("bob"), New: true, Count: -1})
+	if len(next) != 1 || next[0].ID != (StreamID{2, 0}) {
+		t.Errorf("Expected the group to resume after the delivered entry, got %v", next)
+	}
+}
+
+func TestLoadCorruptSnapshot(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+	store.Set([]byte("key"), []byte("value"), -1)
+
+	var buf bytes.Buffer
+	if err := store.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewInMemoryKVStore()
+	defer loaded.Close()
+
+	corrupt := slices.Clone(buf.Bytes())
+	corrupt[len(corrupt)/2] ^= 0xFF
+	if _, err := loaded.LoadSnapshot(bytes.NewReader(corrupt)); err == nil {
+		t.Error("Expected an error for a corrupt snapshot")
+	}
+	if _, err := loaded.LoadSnapshot(bytes.NewReader(buf.Bytes()[:buf.Len()-1])); err == nil {
+		t.Error("Expected an error for a truncated snapshot")
+	}
+	if loaded.Exists([][]byte{[]byte("key")}) != 0 {
+		t.Error("Expected a corrupt snapshot to leave the store untouched")
+	}
+}
+
+func TestSnapshotCopyOnWrite(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.Push([]byte("list"), [][]byte{[]byte("a")}, false)
+	store.HashSet([]byte("hash"), [][]byte{[]byte("field"), []byte("old")})
+	store.SortedSetAdd([]byte("zset"), []ScoredMember{{[]byte("member"), 1}}, ZAddOptions{})
+	store.StreamAdd([]byte("stream"), [][]byte{[]byte("n"), []byte("1")}, XAddOptions{ID: StreamID{1, 0}, MaxLen: -1})
+
+	frozen, err := store.freeze()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteSnapshot(io.Discard); err != errSnapshotInProgress {
+		t.Errorf("Expected a second snapshot to fail while one is in progress, got %v", err)
+	}
+
+	// Writes made while the snapshot is in progress must not change what it writes
+	store.Push([]byte("list"), [][]byte{[]byte("b")}, false)
+	store.HashSet([]byte("hash"), [][]byte{[]byte("field"), []byte("new")})
+	store.SortedSetAdd([]byte("zset"), []ScoredMember{{[]byte("member"), 5}}, ZAddOptions{})
+	store.StreamAdd([]byte("stream"), [][]byte{[]byte("n"), []byte("2")}, XAddOptions{ID: StreamID{2, 0}, MaxLen: 1})
+	store.Set([]byte("added"), []byte("value"), -1)
+
+	var buf bytes.Buffer
+	if err := writeSnapshotEntries(&buf, frozen, nil); err != nil {
+		t.Fatal(err)
+	}
+	store.thaw()
+
+	loaded := NewInMemoryKVStore()
+	defer loaded.Close()
+	if keys, err := loaded.LoadSnapshot(&buf); err != nil || keys != 4 {
+		t.Fatalf("Expected the 4 keys present when the snapshot started, got %d (%v)", keys, err)
+	}
+
+	if list, _ := loaded.GetList([]byte("list")); len(list) != 1 {
+		t.Errorf("Expected the snapshot list to have 1 element, got %q", list)
+	}
+	if value, _ := loaded.HashGet([]byte("hash"), []byte("field")); string(value) != "old" {
+		t.Errorf("Expected the snapshot hash field to be old, got %q", value)
+	}
+	if score, _, _ := loaded.SortedSetScore([]byte("zset"), []byte("member")); score != 1 {
+		t.Errorf("Expected the snapshot score to be 1, got %v", score)
+	}
+	if n, _ := loaded.StreamLen([]byte("stream")); n != 1 {
+		t.Errorf("Expected the snapshot stream to have 1 entry, got %d", n)
+	}
+
+	// The store itself has every write
+	if value, _ := store.HashGet([]byte("hash"), []byte("field")); string(value) != "new" {
+		t.Errorf("Expected the store hash field to be new, got %q", value)
+	}
+	if list, _ := store.GetList([]byte("list")); len(list) != 2 {
+		t.Errorf("Expected the store list to have 2 elements, got %q", list)
+	}
+}
@@ -0,0 +1,572 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Options of StreamAdd.
+type XAddOptions struct {
+	ID         StreamID
+	AutoID     bool // Generate the whole ID from the current time, ID is ignored
+	AutoSeq    bool // Generate the sequence number for the milliseconds of ID
+	NoMkStream bool // Do not create the stream if it does not exist
+	MaxLen     int  // Trim the stream to at most MaxLen entries after adding, negative means no trimming
+}
+
+// Returns the stream stored at key, creating an empty one if create is true.
+// Returns nil if the key does not exist and create is false.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) streamEntry(key []byte, create bool) (*Entry, error) {
+	entry, exists := kv.entry(string(key))
+	if exists && entry.isExpired() {
+		kv.expireKey(string(key))
+		exists = false
+	}
+
+	if exists {
+		if entry.kind != kindStream {
+			return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+		return kv.mutable(string(key), entry), nil
+	}
+
+	if !create {
+		return nil, nil
+	}
+
+	entry = NewStreamEntry(-1)
+	kv.addKey(string(key), entry)
+	return entry, nil
+}
+
+// Returns the ID of a new entry, which must be greater than the last ID of the stream.
+func nextStreamID(last StreamID, opt XAddOptions) (StreamID, error) {
+	if opt.AutoID {
+		ms := uint64(time.Now().UnixMilli())
+		if ms > last.Ms {
+			return StreamID{Ms: ms}, nil
+		}
+
+		// The clock went backwards or several entries were added in the same millisecond
+		id, ok := last.next()
+		if !ok {
+			return StreamID{}, fmt.Errorf("the stream has exhausted the last possible ID, unable to add more items")
+		}
+		return id, nil
+	}
+
+	id := opt.ID
+	if opt.AutoSeq {
+		switch {
+		case id.Ms > last.Ms:
+			return StreamID{Ms: id.Ms}, nil
+		case id.Ms == last.Ms && last.Seq < maxStreamID.Seq:
+			return StreamID{Ms: id.Ms, Seq: last.Seq + 1}, nil
+		}
+	}
+
+	if id.compare(minStreamID) == 0 {
+		return StreamID{}, fmt.Errorf("the ID specified in XADD must be greater than 0-0")
+	}
+	if id.compare(last) <= 0 {
+		return StreamID{}, fmt.Errorf("the ID specified in XADD is equal or smaller than the target stream top item")
+	}
+	return id, nil
+}
+
+func (kv *InMemoryKVStore) StreamAdd(key []byte, fields [][]byte, opt XAddOptions) (StreamID, bool, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return StreamID{}, false, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.streamEntry(key, false)
+	if err != nil {
+		return StreamID{}, false, err
+	}
+	if entry == nil && opt.NoMkStream {
+		return StreamID{}, false, nil
+	}
+
+	var last StreamID
+	if entry != nil {
+		last = entry.stream.lastID
+	}
+
+	// Validate the ID before creating the stream so a rejected entry does not leave an empty key
+	id, err := nextStreamID(last, opt)
+	if err != nil {
+		return StreamID{}, false, err
+	}
+
+	if entry == nil {
+		entry, _ = kv.streamEntry(key, true)
+	}
+
+	entry.stream.append(id, fields)
+	kv.notify(EventStream, "xadd", key)
+	if opt.MaxLen >= 0 && entry.stream.trim(opt.MaxLen) > 0 {
+		kv.notify(EventStream, "xtrim", key)
+	}
+	kv.touch(entry)
+
+	return id, true, nil
+}
+
+func (kv *InMemoryKVStore) StreamLen(key []byte) (int64, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.streamEntry(key, false)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+
+	return int64(entry.stream.len()), nil
+}
+
+func (kv *InMemoryKVStore) StreamRange(key []byte, start, end StreamID, count int, rev bool) ([]StreamEntry, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.streamEntry(key, false)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	return entry.stream.rangeByID(start, end, count, rev), nil
+}
+
+func (kv *InMemoryKVStore) StreamLastID(key []byte) (StreamID, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return StreamID{}, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.streamEntry(key, false)
+	if err != nil || entry == nil {
+		return StreamID{}, err
+	}
+
+	return entry.stream.lastID, nil
+}
+
+// Options of StreamReadGroup.
+type XReadGroupOptions struct {
+	Consumer []byte
+	ID       StreamID // Return the entries pending for the consumer after ID, ignored if New is set
+	New      bool     // Deliver entries never delivered to the group
+	Count    int      // Maximum number of entries to return, negative means no limit
+	NoAck    bool     // Do not add delivered entries to the pending entries list
+}
+
+// Options of StreamPending.
+type XPendingOptions struct {
+	Start, End StreamID
+	Count      int
+	Consumer   []byte // Only return the entries pending for this consumer if not nil
+	MinIdle    time.Duration
+}
+
+// An entry delivered to a consumer and not yet acknowledged.
+type PendingEntry struct {
+	ID         StreamID
+	Consumer   []byte
+	Idle       time.Duration // Time since the last delivery
+	Deliveries int64
+}
+
+// Number of entries pending for a consumer.
+type ConsumerPending struct {
+	Name  []byte
+	Count int64
+}
+
+// Summary of the pending entries of a consumer group.
+type PendingSummary struct {
+	Count     int64
+	Smallest  StreamID
+	Largest   StreamID
+	Consumers []ConsumerPending // Ordered by name, consumers without pending entries are omitted
+}
+
+// Options of StreamAutoClaim.
+type XAutoClaimOptions struct {
+	Consumer []byte
+	MinIdle  time.Duration // Only claim entries idle for at least MinIdle
+	Start    StreamID
+	Count    int  // Maximum number of pending entries to scan
+	JustID   bool // Do not increment the delivery count and only return IDs
+}
+
+// Result of StreamAutoClaim.
+type AutoClaimResult struct {
+	Next    StreamID      // ID to continue scanning from, 0-0 when the scan is complete
+	Claimed []StreamEntry // Fields are nil with JustID
+	Deleted []StreamID    // Entries no longer in the stream, removed from the pending entries list
+}
+
+// Returns the consumer group of the stream stored at key.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) streamGroup(key, group []byte) (*stream, *consumerGroup, error) {
+	entry, err := kv.streamEntry(key, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if entry != nil {
+		if g, exists := entry.stream.groups[string(group)]; exists {
+			return entry.stream, g, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'", key, group)
+}
+
+func (kv *InMemoryKVStore) StreamGroupCreate(key, group []byte, id StreamID, mkStream bool) error {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.streamEntry(key, mkStream)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return fmt.Errorf("XGROUP CREATE requires the key to exist, use MKSTREAM to create an empty stream")
+	}
+
+	s := entry.stream
+	if _, exists := s.groups[string(group)]; exists {
+		return fmt.Errorf("BUSYGROUP Consumer Group name already exists")
+	}
+	if s.groups == nil {
+		s.groups = make(map[string]*consumerGroup)
+	}
+	s.groups[string(group)] = newConsumerGroup(id)
+	kv.notify(EventStream, "xgroup-create", key)
+
+	return nil
+}
+
+func (kv *InMemoryKVStore) StreamGroupSetID(key, group []byte, id StreamID) error {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return fmt.Errorf("store is closed")
+	}
+
+	_, g, err := kv.streamGroup(key, group)
+	if err != nil {
+		return err
+	}
+
+	g.lastID = id
+	kv.notify(EventStream, "xgroup-setid", key)
+	return nil
+}
+
+func (kv *InMemoryKVStore) StreamGroupDestroy(key, group []byte) (bool, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return false, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.streamEntry(key, false)
+	if err != nil {
+		return false, err
+	}
+	if entry == nil {
+		return false, fmt.Errorf("XGROUP DESTROY requires the key to exist")
+	}
+
+	if _, exists := entry.stream.groups[string(group)]; !exists {
+		return false, nil
+	}
+	delete(entry.stream.groups, string(group))
+	kv.notify(EventStream, "xgroup-destroy", key)
+
+	return true, nil
+}
+
+func (kv *InMemoryKVStore) StreamConsumerCreate(key, group, consumer []byte) (bool, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return false, fmt.Errorf("store is closed")
+	}
+
+	_, g, err := kv.streamGroup(key, group)
+	if err != nil {
+		return false, err
+	}
+
+	if g.consumer(string(consumer), false) != nil {
+		return false, nil
+	}
+	g.consumer(string(consumer), true)
+	kv.notify(EventStream, "xgroup-createconsumer", key)
+
+	return true, nil
+}
+
+func (kv *InMemoryKVStore) StreamConsumerDelete(key, group, consumer []byte) (int64, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+
+	_, g, err := kv.streamGroup(key, group)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := g.deleteConsumer(string(consumer))
+	kv.notify(EventStream, "xgroup-delconsumer", key)
+
+	return int64(pending), nil
+}
+
+// Reads entries of the stream stored at key on behalf of a consumer of the group.
+// New entries are added to the pending entries list of the consumer unless NoAck is set. Reading the history
+// of the consumer returns entries deleted from the stream with nil fields.
+func (kv *InMemoryKVStore) StreamReadGroup(key, group []byte, opt XReadGroupOptions) ([]StreamEntry, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	s, g, err := kv.streamGroup(key, group)
+	if err != nil {
+		return nil, err
+	}
+
+	c := g.consumer(string(opt.Consumer), true)
+	now := time.Now().UnixMilli()
+
+	if opt.New {
+		start, ok := g.lastID.next()
+		if !ok {
+			return nil, nil
+		}
+
+		entries := s.rangeByID(start, maxStreamID, opt.Count, false)
+		for _, e := range entries {
+			if !opt.NoAck {
+				g.deliver(e.ID, c, now)
+			}
+		}
+		if len(entries) > 0 {
+			g.lastID = entries[len(entries)-1].ID
+			kv.changed(key)
+		}
+		return entries, nil
+	}
+
+	entries := []StreamEntry{}
+	for _, pe := range g.pel[g.searchPending(opt.ID):] {
+		if opt.Count >= 0 && len(entries) >= opt.Count {
+			break
+		}
+		if pe.consumer != c || pe.id == opt.ID {
+			continue
+		}
+
+		e, _ := s.entry(pe.id)
+		entries = append(entries, StreamEntry{ID: pe.id, Fields: e.Fields})
+		pe.deliveredAt = now
+		pe.deliveries++
+	}
+	if len(entries) > 0 {
+		kv.changed(key)
+	}
+
+	return entries, nil
+}
+
+// Acknowledges entries of the group, removing them from the pending entries list.
+// Returns the number of entries that were pending.
+func (kv *InMemoryKVStore) StreamAck(key, group []byte, ids []StreamID) (int64, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.streamEntry(key, false)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+
+	g, exists := entry.stream.groups[string(group)]
+	if !exists {
+		return 0, nil
+	}
+
+	var acked int64 = 0
+	for _, id := range ids {
+		if g.ack(id) {
+			acked++
+		}
+	}
+	if acked > 0 {
+		kv.changed(key)
+	}
+
+	return acked, nil
+}
+
+func (kv *InMemoryKVStore) StreamPendingSummary(key, group []byte) (PendingSummary, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return PendingSummary{}, fmt.Errorf("store is closed")
+	}
+
+	_, g, err := kv.streamGroup(key, group)
+	if err != nil || len(g.pel) == 0 {
+		return PendingSummary{}, err
+	}
+
+	summary := PendingSummary{
+		Count:    int64(len(g.pel)),
+		Smallest: g.pel[0].id,
+		Largest:  g.pel[len(g.pel)-1].id,
+	}
+	for name, c := range g.consumers {
+		if len(c.pending) > 0 {
+			summary.Consumers = append(summary.Consumers, ConsumerPending{Name: []byte(name), Count: int64(len(c.pending))})
+		}
+	}
+	slices.SortFunc(summary.Consumers, func(a, b ConsumerPending) int {
+		return bytes.Compare(a.Name, b.Name)
+	})
+
+	return summary, nil
+}
+
+// Returns up to Count pending entries of the group with IDs between Start and End inclusive.
+func (kv *InMemoryKVStore) StreamPending(key, group []byte, opt XPendingOptions) ([]PendingEntry, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	_, g, err := kv.streamGroup(key, group)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	entries := []PendingEntry{}
+	for _, pe := range g.pel[g.searchPending(opt.Start):] {
+		if len(entries) >= opt.Count || pe.id.compare(opt.End) > 0 {
+			break
+		}
+		if opt.Consumer != nil && pe.consumer.name != string(opt.Consumer) {
+			continue
+		}
+
+		idle := time.Duration(max(now-pe.deliveredAt, 0)) * time.Millisecond
+		if idle < opt.MinIdle {
+			continue
+		}
+
+		entries = append(entries, PendingEntry{
+			ID:         pe.id,
+			Consumer:   []byte(pe.consumer.name),
+			Idle:       idle,
+			Deliveries: pe.deliveries,
+		})
+	}
+
+	return entries, nil
+}
+
+// Transfers pending entries of the group idle for at least MinIdle to a consumer, scanning up to Count
+// entries of the pending entries list from Start. Entries deleted from the stream are removed from the list.
+func (kv *InMemoryKVStore) StreamAutoClaim(key, group []byte, opt XAutoClaimOptions) (AutoClaimResult, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return AutoClaimResult{}, fmt.Errorf("store is closed")
+	}
+
+	s, g, err := kv.streamGroup(key, group)
+	if err != nil {
+		return AutoClaimResult{}, err
+	}
+
+	c := g.consumer(string(opt.Consumer), true)
+	now := time.Now().UnixMilli()
+	result := AutoClaimResult{Claimed: []StreamEntry{}, Deleted: []StreamID{}}
+
+	i := g.searchPending(opt.Start)
+	for scanned := 0; i < len(g.pel) && scanned < opt.Count; scanned++ {
+		pe := g.pel[i]
+
+		e, exists := s.entry(pe.id)
+		if !exists {
+			result.Deleted = append(result.Deleted, pe.id)
+			g.ack(pe.id)
+			continue
+		}
+
+		if time.Duration(now-pe.deliveredAt)*time.Millisecond < opt.MinIdle {
+			i++
+			continue
+		}
+
+		delete(pe.consumer.pending, pe.id)
+		pe.consumer = c
+		c.pending[pe.id] = pe
+		pe.deliveredAt = now
+		if opt.JustID {
+			e.Fields = nil
+		} else {
+			pe.deliveries++
+		}
+
+		result.Claimed = append(result.Claimed, e)
+		i++
+	}
+
+	if i < len(g.pel) {
+		result.Next = g.pel[i].id
+	}
+	if len(result.Claimed) > 0 || len(result.Deleted) > 0 {
+		kv.changed(key)
+	}
+
+	return result, nil
+}
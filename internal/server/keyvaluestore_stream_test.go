@@ -0,0 +1,235 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreamAdd(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("events")
+	fields := [][]byte{[]byte("type"), []byte("click")}
+
+	tests := []struct {
+		name    string
+		opt     XAddOptions
+		want    StreamID
+		wantErr bool
+	}{
+		{"explicit", XAddOptions{ID: StreamID{5, 1}, MaxLen: -1}, StreamID{5, 1}, false},
+		{"auto sequence same ms", XAddOptions{ID: StreamID{5, 0}, AutoSeq: true, MaxLen: -1}, StreamID{5, 2}, false},
+		{"auto sequence new ms", XAddOptions{ID: StreamID{7, 0}, AutoSeq: true, MaxLen: -1}, StreamID{7, 0}, false},
+		{"equal to last", XAddOptions{ID: StreamID{7, 0}, MaxLen: -1}, StreamID{}, true},
+		{"auto sequence older ms", XAddOptions{ID: StreamID{6, 0}, AutoSeq: true, MaxLen: -1}, StreamID{}, true},
+		{"zero", XAddOptions{ID: StreamID{0, 0}, MaxLen: -1}, StreamID{}, true},
+	}
+
+	for _, tt := range tests {
+		id, added, err := store.StreamAdd(key, fields, tt.opt)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.name)
+			}
+			continue
+		}
+
+		if err != nil || !added {
+			t.Fatalf("%s: unexpected result (added=%v, err=%v)", tt.name, added, err)
+		}
+		if id != tt.want {
+			t.Errorf("%s: expected ID %s, got %s", tt.name, tt.want, id)
+		}
+	}
+
+	// Auto IDs use the current time and always increase
+	before := uint64(time.Now().UnixMilli())
+	first, _, _ := store.StreamAdd(key, fields, XAddOptions{AutoID: true, MaxLen: -1})
+	second, _, _ := store.StreamAdd(key, fields, XAddOptions{AutoID: true, MaxLen: -1})
+	if first.Ms < before || second.compare(first) <= 0 {
+		t.Errorf("Expected increasing auto IDs, got %s and %s", first, second)
+	}
+
+	length, _ := store.StreamLen(key)
+	if length != 5 {
+		t.Errorf("Expected 5 entries, got %d", length)
+	}
+
+	lastID, _ := store.StreamLastID(key)
+	if lastID != second {
+		t.Errorf("Expected last ID %s, got %s", second, lastID)
+	}
+}
+
+func TestStreamAddOptions(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	fields := [][]byte{[]byte("f"), []byte("v")}
+
+	_, added, err := store.StreamAdd([]byte("missing"), fields, XAddOptions{AutoID: true, NoMkStream: true, MaxLen: -1})
+	if err != nil || added {
+		t.Errorf("Expected NOMKSTREAM not to add to a missing stream (added=%v, err=%v)", added, err)
+	}
+	if store.Exists([][]byte{[]byte("missing")}) != 0 {
+		t.Error("Expected NOMKSTREAM not to create the key")
+	}
+
+	// A rejected ID does not leave an empty stream behind
+	store.StreamAdd([]byte("rejected"), fields, XAddOptions{MaxLen: -1})
+	if store.Exists([][]byte{[]byte("rejected")}) != 0 {
+		t.Error("Expected rejected entry not to create the key")
+	}
+
+	key := []byte("capped")
+	for i := range 10 {
+		store.StreamAdd(key, fields, XAddOptions{ID: StreamID{uint64(i + 1), 0}, MaxLen: 3})
+	}
+
+	entries, _ := store.StreamRange(key, minStreamID, maxStreamID, -1, false)
+	if len(entries) != 3 || entries[0].ID != (StreamID{8, 0}) {
+		t.Errorf("Expected the 3 newest entries, got %v", entries)
+	}
+}
+
+func TestStreamWrongType(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.Set([]byte("string_key"), []byte("value"), -1)
+	key := []byte("string_key")
+
+	if _, _, err := store.StreamAdd(key, [][]byte{[]byte("f"), []byte("v")}, XAddOptions{AutoID: true, MaxLen: -1}); err == nil {
+		t.Error("Expected error when adding to a string")
+	}
+	if _, err := store.StreamLen(key); err == nil {
+		t.Error("Expected error when reading the length of a string")
+	}
+	if _, err := store.StreamRange(key, minStreamID, maxStreamID, -1, false); err == nil {
+		t.Error("Expected error when reading a string as a stream")
+	}
+}
+
+func TestStreamConsumerGroups(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key, group := []byte("jobs"), []byte("workers")
+	if err := store.StreamGroupCreate(key, group, minStreamID, false); err == nil {
+		t.Error("Expected error when creating a group without MKSTREAM on a missing key")
+	}
+	if err := store.StreamGroupCreate(key, group, minStreamID, true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := store.StreamGroupCreate(key, group, minStreamID, false); err == nil {
+		t.Error("Expected error when creating an existing group")
+	}
+
+	for ms := uint64(1); ms <= 3; ms++ {
+		store.StreamAdd(key, [][]byte{[]byte("n"), []byte("v")}, XAddOptions{ID: StreamID{ms, 0}, MaxLen: -1})
+	}
+
+	// New entries are split between consumers
+	alice, _ := store.StreamReadGroup(key, group, XReadGroupOptions{Consumer: []byte("alice"), New: true, Count: 2})
+	bob, _ := store.StreamReadGroup(key, group, XReadGroupOptions{Consumer: []byte("bob"), New: true, Count: -1})
+	if len(alice) != 2 || len(bob) != 1 || bob[0].ID != (StreamID{3, 0}) {
+		t.Fatalf("Unexpected deliveries: alice=%v bob=%v", alice, bob)
+	}
+
+	more, _ := store.StreamReadGroup(key, group, XReadGroupOptions{Consumer: []byte("bob"), New: true, Count: -1})
+	if len(more) != 0 {
+		t.Errorf("Expected no new entries, got %d", len(more))
+	}
+
+	// The history of a consumer only contains its own pending entries
+	history, _ := store.StreamReadGroup(key, group, XReadGroupOptions{Consumer: []byte("alice"), Count: -1})
+	if len(history) != 2 || history[0].ID != (StreamID{1, 0}) {
+		t.Errorf("Unexpected history: %v", history)
+	}
+
+	summary, _ := store.StreamPendingSummary(key, group)
+	if summary.Count != 3 || summary.Smallest != (StreamID{1, 0}) || summary.Largest != (StreamID{3, 0}) {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+	if len(summary.Consumers) != 2 || string(summary.Consumers[0].Name) != "alice" || summary.Consumers[0].Count != 2 {
+		t.Errorf("Unexpected consumers: %+v", summary.Consumers)
+	}
+
+	acked, _ := store.StreamAck(key, group, []StreamID{{1, 0}, {1, 0}, {9, 0}})
+	if acked != 1 {
+		t.Errorf("Expected 1 acknowledged entry, got %d", acked)
+	}
+
+	pending, _ := store.StreamPending(key, group, XPendingOptions{Start: minStreamID, End: maxStreamID, Count: 10})
+	if len(pending) != 2 || string(pending[0].Consumer) != "alice" || pending[0].Deliveries != 2 {
+		t.Errorf("Unexpected pending entries: %+v", pending)
+	}
+
+	removed, _ := store.StreamConsumerDelete(key, group, []byte("bob"))
+	if removed != 1 {
+		t.Errorf("Expected 1 pending entry removed with the consumer, got %d", removed)
+	}
+
+	if _, err := store.StreamReadGroup(key, []byte("missing"), XReadGroupOptions{New: true}); err == nil {
+		t.Error("Expected error when reading a missing group")
+	}
+}
+
+func TestStreamReadGroupNoAck(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key, group := []byte("jobs"), []byte("workers")
+	store.StreamAdd(key, [][]byte{[]byte("n"), []byte("v")}, XAddOptions{ID: StreamID{1, 0}, MaxLen: -1})
+	store.StreamGroupCreate(key, group, minStreamID, false)
+
+	entries, _ := store.StreamReadGroup(key, group, XReadGroupOptions{Consumer: []byte("c"), New: true, Count: -1, NoAck: true})
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+
+	summary, _ := store.StreamPendingSummary(key, group)
+	if summary.Count != 0 {
+		t.Errorf("Expected no pending entries with NoAck, got %d", summary.Count)
+	}
+}
+
+func TestStreamAutoClaim(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key, group := []byte("jobs"), []byte("workers")
+	store.StreamGroupCreate(key, group, minStreamID, true)
+	for ms := uint64(1); ms <= 3; ms++ {
+		store.StreamAdd(key, [][]byte{[]byte("n"), []byte("v")}, XAddOptions{ID: StreamID{ms, 0}, MaxLen: -1})
+	}
+	store.StreamReadGroup(key, group, XReadGroupOptions{Consumer: []byte("a"), New: true, Count: -1})
+
+	// Entries delivered too recently are not claimed
+	result, _ := store.StreamAutoClaim(key, group, XAutoClaimOptions{Consumer: []byte("b"), MinIdle: time.Hour, Count: 100})
+	if len(result.Claimed) != 0 || result.Next != minStreamID {
+		t.Errorf("Expected nothing claimed, got %+v", result)
+	}
+
+	// Trimmed entries are removed from the pending entries list
+	store.StreamAdd(key, [][]byte{[]byte("n"), []byte("v")}, XAddOptions{ID: StreamID{4, 0}, MaxLen: 3})
+
+	result, _ = store.StreamAutoClaim(key, group, XAutoClaimOptions{Consumer: []byte("b"), Count: 2})
+	if len(result.Deleted) != 1 || result.Deleted[0] != (StreamID{1, 0}) {
+		t.Errorf("Expected 1-0 to be deleted, got %v", result.Deleted)
+	}
+	if len(result.Claimed) != 1 || result.Claimed[0].ID != (StreamID{2, 0}) || result.Next != (StreamID{3, 0}) {
+		t.Errorf("Unexpected claim result: %+v", result)
+	}
+
+	result, _ = store.StreamAutoClaim(key, group, XAutoClaimOptions{Consumer: []byte("b"), Start: result.Next, Count: 2, JustID: true})
+	if len(result.Claimed) != 1 || result.Claimed[0].Fields != nil || result.Next != minStreamID {
+		t.Errorf("Unexpected claim result: %+v", result)
+	}
+
+	pending, _ := store.StreamPending(key, group, XPendingOptions{Start: minStreamID, End: maxStreamID, Count: 10})
+	if len(pending) != 2 || string(pending[0].Consumer) != "b" || pending[0].Deliveries != 2 || pending[1].Deliveries != 1 {
+		t.Errorf("Unexpected pending entries: %+v", pending)
+	}
+}
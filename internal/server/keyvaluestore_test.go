@@ -1,6 +1,9 @@
 package server
 
 import (
+	"bytes"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -136,17 +139,317 @@ func TestExpirationCleanup(t *testing.T) {
 	time.Sleep(400 * time.Millisecond)
 
 	// Verify keys are cleaned up
-	store.mu.RLock()
-	storeLen := len(store.store)
-	expirableLen := len(store.expirable)
-	store.mu.RUnlock()
+	storeLen := storedKeys(store)
+	expirableLen, _ := storedExpirable(store)
 
 	if storeLen != 0 {
 		t.Errorf("Expected store to be empty, but has %d entries", storeLen)
 	}
 
 	if expirableLen != 0 {
-		t.Errorf("Expected expirable map to be empty, but has %d entries", expirableLen)
+		t.Errorf("Expected expiry queue to be empty, but has %d entries", expirableLen)
+	}
+}
+
+func TestExpirationCleanupManyKeys(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	// More expired keys than the cleanup loop removes per batch
+	for i := range cleanupBatchSize * 2 {
+		store.Set([]byte(strconv.Itoa(i)), []byte("value"), time.Now().Add(10*time.Millisecond).UnixNano())
+	}
+
+	time.Sleep(defaultCleanupInterval*2 + 100*time.Millisecond)
+
+	remaining := storedKeys(store)
+
+	if remaining != 0 {
+		t.Errorf("Expected the cleanup loop to remove every expired key, %d remaining", remaining)
+	}
+}
+
+func TestExpirationCleanupBudget(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.SetCleanupBudget(time.Nanosecond)
+	for i := range cleanupBatchSize * 3 {
+		store.Set([]byte(strconv.Itoa(i)), []byte("value"), time.Now().Add(time.Millisecond).UnixNano())
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	// An exhausted budget still removes one batch per cycle
+	store.activeExpireCycle()
+
+	remaining := storedKeys(store)
+
+	if remaining != cleanupBatchSize*2 {
+		t.Errorf("Expected %d remaining keys, got %d", cleanupBatchSize*2, remaining)
+	}
+}
+
+func TestExpirationCleanupAfterExpire(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	// Keys given a TTL after being written are also removed by the cleanup loop
+	store.Set([]byte("key"), []byte("value"), -1)
+	store.Expire([]byte("key"), time.Now().Add(10*time.Millisecond).UnixNano())
+
+	time.Sleep(defaultCleanupInterval + 100*time.Millisecond)
+
+	_, exists := storedEntry(store, "key")
+
+	if exists {
+		t.Error("Expected the cleanup loop to remove the key")
+	}
+}
+
+func TestOnExpired(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	expired := make(chan string, 10)
+	store.OnExpired(func(key []byte) {
+		// Hooks can use the store
+		store.Exists([][]byte{key})
+		expired <- string(key)
+	})
+
+	store.Set([]byte("lazy"), []byte("value"), time.Now().Add(10*time.Millisecond).UnixNano())
+	store.Set([]byte("active"), []byte("value"), time.Now().Add(10*time.Millisecond).UnixNano())
+	store.Set([]byte("deleted"), []byte("value"), time.Now().Add(time.Hour).UnixNano())
+	store.Delete([][]byte{[]byte("deleted")})
+	time.Sleep(20 * time.Millisecond)
+
+	// Found expired when accessed
+	store.GetValue([]byte("lazy"))
+	select {
+	case key := <-expired:
+		if key != "lazy" {
+			t.Errorf("Expected lazy to expire first, got %s", key)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected hook to be called for a lazily expired key")
+	}
+
+	// Removed by the cleanup loop
+	select {
+	case key := <-expired:
+		if key != "active" {
+			t.Errorf("Expected active to expire, got %s", key)
+		}
+	case <-time.After(defaultCleanupInterval * 2):
+		t.Fatal("Expected hook to be called for an actively expired key")
+	}
+
+	select {
+	case key := <-expired:
+		t.Errorf("Unexpected hook call for %s", key)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSetActiveExpire(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.SetActiveExpire(false)
+	store.Set([]byte("key"), []byte("value"), time.Now().Add(-time.Millisecond).UnixNano())
+	store.activeExpireCycle()
+
+	_, kept := storedEntry(store, "key")
+	if !kept {
+		t.Fatal("Expected the expired key to be left while active expiration is disabled")
+	}
+
+	store.SetActiveExpire(true)
+	store.activeExpireCycle()
+
+	_, kept = storedEntry(store, "key")
+	if kept {
+		t.Error("Expected the expired key to be removed once active expiration is enabled")
+	}
+}
+
+func TestPassiveExpiry(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+	removed := store.TrackRemovals()
+	store.SetPassiveExpiry(true)
+
+	expiresAt := time.Now().Add(10 * time.Millisecond).UnixNano()
+	store.Set([]byte("key"), []byte("value"), expiresAt)
+	store.HashSet([]byte("hash"), [][]byte{[]byte("f1"), []byte("v1"), []byte("f2"), []byte("v2")})
+	store.HashExpire([]byte("hash"), [][]byte{[]byte("f1")}, expiresAt)
+	time.Sleep(20 * time.Millisecond)
+	store.activeExpireCycle()
+
+	if value, _ := store.GetValue([]byte("key")); value != nil {
+		t.Errorf("Expected the expired key to be hidden, got %q", value)
+	}
+	_, kept := storedEntry(store, "key")
+	if !kept {
+		t.Error("Expected the expired key to be left in the store")
+	}
+	if removals := store.TakeRemovals(); len(removals) != 0 {
+		t.Errorf("Expected no removals, got %v", removals)
+	}
+
+	// Deleting the key, as the primary does, removes it
+	if deleted := store.Delete([][]byte{[]byte("key")}); deleted != 1 {
+		t.Errorf("Expected the expired key to be deleted, got %d", deleted)
+	}
+
+	store.SetPassiveExpiry(false)
+	store.activeExpireCycle()
+	store.Set([]byte("lazy"), []byte("value"), time.Now().Add(-time.Millisecond).UnixNano())
+	store.GetValue([]byte("lazy"))
+	select {
+	case <-removed:
+	default:
+		t.Fatal("Expected the removals to be signaled")
+	}
+	removals := store.TakeRemovals()
+	if len(removals) != 2 || string(removals[0].Key) != "hash" || len(removals[0].Fields) != 1 || string(removals[0].Fields[0]) != "f1" ||
+		string(removals[1].Key) != "lazy" || removals[1].Fields != nil {
+		t.Errorf("Expected the expired field then the expired key, got %v", removals)
+	}
+}
+
+func TestDefaultTTL(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.SetDefaultTTL(time.Minute)
+	before := time.Now()
+
+	store.Set([]byte("default"), []byte("value"), -1)
+	store.Set([]byte("explicit"), []byte("value"), before.Add(time.Hour).UnixNano())
+	store.HashSet([]byte("hash"), [][]byte{[]byte("f"), []byte("v")})
+	store.SortedSetAdd([]byte("zset"), []ScoredMember{{Member: []byte("m"), Score: 1}}, ZAddOptions{})
+
+	for _, key := range []string{"default", "hash", "zset"} {
+		entry, _ := storedEntry(store, key)
+		expiresAt := time.Unix(0, entry.expiresAt)
+		if expiresAt.Before(before.Add(time.Minute)) || expiresAt.After(time.Now().Add(time.Minute)) {
+			t.Errorf("Expected %s to expire in a minute, got %v", key, expiresAt.Sub(before))
+		}
+	}
+
+	// An explicit expiration overrides the default
+	if entry, _ := storedEntry(store, "explicit"); entry.expiresAt != before.Add(time.Hour).UnixNano() {
+		t.Error("Expected the explicit expiration to be kept")
+	}
+
+	if expirable, _ := storedExpirable(store); expirable != 4 {
+		t.Errorf("Expected 4 keys in the expiry queue, got %d", expirable)
+	}
+}
+
+func TestLazyFree(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.SetLazyFreeThreshold(100)
+
+	values := make([][]byte, 1000)
+	for i := range values {
+		values[i] = []byte(strconv.Itoa(i))
+	}
+
+	store.Push([]byte("big"), values, false)
+	store.Push([]byte("small"), values[:10], false)
+	store.Set([]byte("overwritten"), make([]byte, 1000), -1)
+
+	store.Delete([][]byte{[]byte("big"), []byte("small")})
+	store.Set([]byte("overwritten"), []byte("value"), -1)
+
+	// Only the big list and the overwritten string go through the lazy free path
+	deadline := time.Now().Add(time.Second)
+	for {
+		store.queueMu.Lock()
+		freed, pending := store.lazyFreed, len(store.lazyFreeQueue)
+		store.queueMu.Unlock()
+
+		if freed == 2 && pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 2 values released in the background, got %d with %d pending", freed, pending)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if store.Exists([][]byte{[]byte("big"), []byte("small")}) != 0 {
+		t.Error("Expected deleted keys to be gone")
+	}
+	if value, _ := store.GetValue([]byte("overwritten")); string(value) != "value" {
+		t.Errorf("Expected the new value, got %q", value)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("key")
+	if v := store.Version(key); v != 0 {
+		t.Errorf("Expected version 0 for a missing key, got %d", v)
+	}
+
+	store.Set(key, []byte("value"), -1)
+	v1 := store.Version(key)
+	if v1 == 0 {
+		t.Fatal("Expected a version for an existing key")
+	}
+
+	store.GetValue(key)
+	if v := store.Version(key); v != v1 {
+		t.Errorf("Expected reads to keep version %d, got %d", v1, v)
+	}
+
+	store.Expire(key, time.Now().Add(time.Hour).UnixNano())
+	v2 := store.Version(key)
+	if v2 <= v1 {
+		t.Errorf("Expected EXPIRE to increase the version, got %d after %d", v2, v1)
+	}
+
+	// A deleted and recreated key never gets an old version back
+	store.Delete([][]byte{key})
+	if v := store.Version(key); v != 0 {
+		t.Errorf("Expected version 0 after delete, got %d", v)
+	}
+	store.Set(key, []byte("value"), -1)
+	if v := store.Version(key); v <= v2 {
+		t.Errorf("Expected a new version after recreating the key, got %d after %d", v, v2)
+	}
+
+	// In place writes to collections change the version, no-op writes do not
+	store.SetAdd([]byte("set"), [][]byte{[]byte("a")})
+	v3 := store.Version([]byte("set"))
+	store.SetAdd([]byte("set"), [][]byte{[]byte("a")})
+	if v := store.Version([]byte("set")); v != v3 {
+		t.Errorf("Expected adding an existing member to keep version %d, got %d", v3, v)
+	}
+	store.SetAdd([]byte("set"), [][]byte{[]byte("b")})
+	if v := store.Version([]byte("set")); v <= v3 {
+		t.Errorf("Expected adding a member to increase the version, got %d after %d", v, v3)
+	}
+
+	store.Push([]byte("list"), [][]byte{[]byte("a"), []byte("b")}, false)
+	v4 := store.Version([]byte("list"))
+	store.Pop([]byte("list"), true)
+	if v := store.Version([]byte("list")); v <= v4 {
+		t.Errorf("Expected a pop to increase the version, got %d after %d", v, v4)
+	}
+
+	store.HashSet([]byte("hash"), [][]byte{[]byte("f"), []byte("v")})
+	v5 := store.Version([]byte("hash"))
+	store.HashIncrBy([]byte("hash"), []byte("n"), 1)
+	if v := store.Version([]byte("hash")); v <= v5 {
+		t.Errorf("Expected HINCRBY to increase the version, got %d after %d", v, v5)
 	}
 }
 
@@ -604,6 +907,18 @@ func BenchmarkSet(b *testing.B) {
 	}
 }
 
+func BenchmarkSetWithOptions(b *testing.B) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("benchmark_key")
+	value := []byte("benchmark_value")
+
+	for b.Loop() {
+		store.SetWithOptions(key, value, -1, ConditionXX)
+	}
+}
+
 func BenchmarkGet(b *testing.B) {
 	store := NewInMemoryKVStore()
 	defer store.Close()
@@ -618,6 +933,22 @@ func BenchmarkGet(b *testing.B) {
 	}
 }
 
+func BenchmarkGetRange(b *testing.B) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("benchmark_list")
+	elements := make([][]byte, 100000)
+	for i := range elements {
+		elements[i] = []byte(strconv.Itoa(i))
+	}
+	store.Push(key, elements, false)
+
+	for b.Loop() {
+		store.GetRange(key, 0, 9)
+	}
+}
+
 func BenchmarkDelete(b *testing.B) {
 	store := NewInMemoryKVStore()
 	defer store.Close()
@@ -819,6 +1150,139 @@ func TestPopEmptyList(t *testing.T) {
 	}
 }
 
+func TestPopDeletesEmptyList(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("list_key")
+	store.Push(key, [][]byte{[]byte("value1"), []byte("value2")}, false)
+
+	store.Pop(key, true)
+	if store.Exists([][]byte{key}) != 1 {
+		t.Error("Expected list to exist while it still has elements")
+	}
+
+	store.Pop(key, true)
+	if store.Exists([][]byte{key}) != 0 {
+		t.Error("Expected list to be deleted after popping the last element")
+	}
+
+	// Moving the last element also deletes the source
+	store.Push(key, [][]byte{[]byte("value")}, false)
+	store.Move(key, []byte("destination"), true, false)
+	if store.Exists([][]byte{key}) != 0 {
+		t.Error("Expected source list to be deleted after moving the last element")
+	}
+}
+
+func TestGetListReturnsCopy(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("list_key")
+	store.Push(key, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, false)
+
+	// A pop followed by a push reuses the slot of the popped element, which the copy must not see
+	list, err := store.GetList(key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store.Pop(key, false)
+	store.Push(key, [][]byte{[]byte("d")}, false)
+	if string(list[2]) != "c" {
+		t.Errorf("Expected the copy to keep c, got %s", list[2])
+	}
+
+	list[0] = []byte("changed")
+	if current, _ := store.GetList(key); string(current[0]) != "a" || string(current[2]) != "d" {
+		t.Errorf("Expected [a b d], got %q", current)
+	}
+
+	length, err := store.ListLen(key)
+	if err != nil || length != 3 {
+		t.Errorf("Expected a length of 3, got %d (%v)", length, err)
+	}
+	if length, _ := store.ListLen([]byte("missing")); length != 0 {
+		t.Errorf("Expected a length of 0 for a missing key, got %d", length)
+	}
+	store.Set([]byte("string"), []byte("v"), -1)
+	if _, err := store.ListLen([]byte("string")); err == nil {
+		t.Error("Expected WRONGTYPE for a string")
+	}
+}
+
+func TestGetRange(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("list_key")
+	store.Push(key, [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}, false)
+
+	tests := []struct {
+		start, stop int
+		want        string
+	}{
+		{0, -1, "abcd"},
+		{1, 2, "bc"},
+		{-2, -1, "cd"},
+		{-100, 1, "ab"},
+		{2, 100, "cd"},
+		{3, 1, ""},
+		{5, 10, ""},
+	}
+	for _, tt := range tests {
+		elements, err := store.GetRange(key, tt.start, tt.stop)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if elements == nil {
+			t.Errorf("GetRange(%d, %d) = nil, want an empty range for an existing list", tt.start, tt.stop)
+		}
+		if got := string(bytes.Join(elements, nil)); got != tt.want {
+			t.Errorf("GetRange(%d, %d) = %q, want %q", tt.start, tt.stop, got, tt.want)
+		}
+	}
+
+	// The range is a copy
+	elements, _ := store.GetRange(key, 0, 0)
+	elements[0] = []byte("changed")
+	if list, _ := store.GetList(key); string(list[0]) != "a" {
+		t.Errorf("Expected the list to be left unchanged, got %q", list[0])
+	}
+
+	if elements, err := store.GetRange([]byte("missing"), 0, -1); elements != nil || err != nil {
+		t.Errorf("Expected nil for a missing key, got %q (%v)", elements, err)
+	}
+}
+
+func TestGetListConcurrentWrites(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("list_key")
+	store.Push(key, [][]byte{[]byte("a"), []byte("b")}, false)
+
+	// Run with -race: copies are read while the list changes
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 1000 {
+			store.Pop(key, false)
+			store.Push(key, [][]byte{[]byte("b")}, false)
+		}
+	}()
+	for range 1000 {
+		list, _ := store.GetList(key)
+		for _, element := range list {
+			if string(element) != "a" && string(element) != "b" {
+				t.Fatalf("Unexpected element %q", element)
+			}
+		}
+	}
+	wg.Wait()
+}
+
 func TestPushToExistingList(t *testing.T) {
 	store := NewInMemoryKVStore()
 	defer store.Close()
@@ -1075,3 +1539,364 @@ func TestPushMultipleValues(t *testing.T) {
 		t.Errorf("Expected nil for empty list, got %v", val)
 	}
 }
+
+func TestGetOrSet(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("key")
+
+	// Key does not exist, value should be set and returned
+	result, err := store.GetOrSet(key, []byte("first"), -1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(result) != "first" {
+		t.Errorf("Expected first, got %s", result)
+	}
+
+	// Key exists, existing value should be returned and left untouched
+	result, err = store.GetOrSet(key, []byte("second"), -1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(result) != "first" {
+		t.Errorf("Expected first, got %s", result)
+	}
+
+	result, err = store.GetValue(key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(result) != "first" {
+		t.Errorf("Expected first, got %s", result)
+	}
+}
+
+func TestSetWithOptions(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("key")
+
+	tests := []struct {
+		value string
+		cond  SetCondition
+		set   bool
+		want  string
+	}{
+		{"xx", ConditionXX, false, ""},      // Missing key, XX leaves it missing
+		{"nx", ConditionNX, true, "nx"},     // Missing key, NX sets it
+		{"nx2", ConditionNX, false, "nx"},   // Existing key, NX leaves it
+		{"xx2", ConditionXX, true, "xx2"},   // Existing key, XX replaces it
+		{"any", ConditionNone, true, "any"}, // No condition always sets
+	}
+	for _, tt := range tests {
+		set, err := store.SetWithOptions(key, []byte(tt.value), -1, tt.cond)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if set != tt.set {
+			t.Errorf("SetWithOptions(%s, %d) = %v, want %v", tt.value, tt.cond, set, tt.set)
+		}
+		if value, _ := store.GetValue(key); string(value) != tt.want {
+			t.Errorf("Expected %q after setting %s, got %q", tt.want, tt.value, value)
+		}
+	}
+
+	// Expired keys are missing
+	store.Set(key, []byte("old"), time.Now().Add(-time.Second).UnixNano())
+	if set, _ := store.SetWithOptions(key, []byte("new"), -1, ConditionNX); !set {
+		t.Error("Expected NX to set an expired key")
+	}
+
+	store.Push([]byte("list"), [][]byte{[]byte("a")}, false)
+	if _, err := store.SetWithOptions([]byte("list"), []byte("v"), -1, ConditionNone); err == nil || !strings.Contains(err.Error(), "WRONGTYPE") {
+		t.Errorf("Expected WRONGTYPE for a list, got %v", err)
+	}
+}
+
+func TestGetOrSetExpired(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("key")
+	store.Set(key, []byte("old"), time.Now().Add(50*time.Millisecond).UnixNano())
+
+	time.Sleep(100 * time.Millisecond)
+
+	// Expired key should be treated as missing
+	result, err := store.GetOrSet(key, []byte("new"), time.Now().Add(time.Hour).UnixNano())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(result) != "new" {
+		t.Errorf("Expected new, got %s", result)
+	}
+}
+
+func TestGetOrSetWrongType(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("list_key")
+	store.Push(key, [][]byte{[]byte("value")}, false)
+
+	result, err := store.GetOrSet(key, []byte("value"), -1)
+	if err == nil {
+		t.Error("Expected error when calling GetOrSet on a list key")
+	}
+	if result != nil {
+		t.Errorf("Expected nil result, got %v", result)
+	}
+}
+
+func TestDeleteIfType(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.Set([]byte("string_key"), []byte("value"), -1)
+	store.Push([]byte("list_key"), [][]byte{[]byte("value")}, false)
+
+	// Only the list key should be deleted
+	deleted := store.DeleteIfType([][]byte{[]byte("string_key"), []byte("list_key"), []byte("missing")}, "list")
+	if deleted != 1 {
+		t.Errorf("Expected 1 key deleted, got %d", deleted)
+	}
+
+	if exists := store.Exists([][]byte{[]byte("string_key")}); exists != 1 {
+		t.Error("Expected string_key to still exist")
+	}
+	if exists := store.Exists([][]byte{[]byte("list_key")}); exists != 0 {
+		t.Error("Expected list_key to be deleted")
+	}
+
+	deleted = store.DeleteIfType([][]byte{[]byte("string_key")}, "string")
+	if deleted != 1 {
+		t.Errorf("Expected 1 key deleted, got %d", deleted)
+	}
+}
+
+func TestDeleteIfEquals(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("lock")
+	store.Set(key, []byte("token1"), -1)
+
+	// Mismatched token should not delete the key
+	deleted, err := store.DeleteIfEquals(key, []byte("token2"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if deleted {
+		t.Error("Expected key not to be deleted with a mismatched token")
+	}
+
+	// Matching token should delete the key
+	deleted, err = store.DeleteIfEquals(key, []byte("token1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !deleted {
+		t.Error("Expected key to be deleted with a matching token")
+	}
+
+	// Key no longer exists
+	deleted, err = store.DeleteIfEquals(key, []byte("token1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if deleted {
+		t.Error("Expected missing key not to be deleted")
+	}
+
+	store.Push([]byte("list_key"), [][]byte{[]byte("value")}, false)
+	if _, err := store.DeleteIfEquals([]byte("list_key"), []byte("value")); err == nil {
+		t.Error("Expected error when calling DeleteIfEquals on a list key")
+	}
+}
+
+func TestGetValues(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.Set([]byte("key1"), []byte("value1"), -1)
+	store.Set([]byte("key2"), []byte("value2"), -1)
+	store.Set([]byte("expired"), []byte("value"), time.Now().Add(-time.Second).UnixNano())
+	store.Push([]byte("list_key"), [][]byte{[]byte("value")}, false)
+
+	values := store.GetValues([][]byte{
+		[]byte("key1"),
+		[]byte("missing"),
+		[]byte("key2"),
+		[]byte("expired"),
+		[]byte("list_key"),
+	})
+
+	expected := []string{"value1", "", "value2", "", ""}
+	if len(values) != len(expected) {
+		t.Fatalf("Expected %d values, got %d", len(expected), len(values))
+	}
+
+	for i, value := range values {
+		if expected[i] == "" {
+			if value != nil {
+				t.Errorf("Expected nil at index %d, got %s", i, value)
+			}
+			continue
+		}
+		if string(value) != expected[i] {
+			t.Errorf("Expected %s at index %d, got %s", expected[i], i, value)
+		}
+	}
+}
+
+func TestMove(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	source := []byte("jobs")
+	destination := []byte("processing")
+	store.Push(source, [][]byte{[]byte("job1"), []byte("job2")}, false)
+
+	val, err := store.Move(source, destination, true, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(val) != "job1" {
+		t.Errorf("Expected job1, got %s", string(val))
+	}
+
+	list, _ := store.GetList(destination)
+	if len(list) != 1 || string(list[0]) != "job1" {
+		t.Errorf("Expected destination to contain job1, got %q", list)
+	}
+
+	// Rotating a list onto itself
+	store.Push(source, [][]byte{[]byte("job3")}, false)
+	val, err = store.Move(source, source, true, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(val) != "job2" {
+		t.Errorf("Expected job2, got %s", string(val))
+	}
+
+	list, _ = store.GetList(source)
+	if len(list) != 2 || string(list[0]) != "job3" || string(list[1]) != "job2" {
+		t.Errorf("Expected [job3 job2], got %q", list)
+	}
+
+	// Missing source
+	val, err = store.Move([]byte("missing"), destination, true, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if val != nil {
+		t.Errorf("Expected nil for missing source, got %s", string(val))
+	}
+}
+
+func TestMoveWrongType(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.Set([]byte("string_key"), []byte("value"), -1)
+	store.Push([]byte("list_key"), [][]byte{[]byte("value")}, false)
+
+	if _, err := store.Move([]byte("string_key"), []byte("list_key"), true, true); err == nil {
+		t.Error("Expected error when source holds a string")
+	}
+
+	if _, err := store.Move([]byte("list_key"), []byte("string_key"), true, true); err == nil {
+		t.Error("Expected error when destination holds a string")
+	}
+
+	// The source must be left untouched when the destination has the wrong type
+	list, _ := store.GetList([]byte("list_key"))
+	if len(list) != 1 {
+		t.Errorf("Expected source list to keep its element, got %d elements", len(list))
+	}
+}
+
+func TestKeyspaceEvents(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	events := make(chan string, 20)
+	store.OnKeyspaceEvent(func(event KeyspaceEvent) {
+		events <- event.Name + " " + string(event.Key)
+	})
+
+	store.Set([]byte("str"), []byte("value"), -1)
+	store.Expire([]byte("str"), time.Now().Add(time.Hour).UnixNano())
+	store.Push([]byte("list"), [][]byte{[]byte("a")}, true)
+	store.Move([]byte("list"), []byte("other"), false, false)
+	store.HashSet([]byte("hash"), [][]byte{[]byte("field"), []byte("value")})
+	store.Delete([][]byte{[]byte("str"), []byte("missing")})
+	store.Set([]byte("short"), []byte("value"), time.Now().Add(-time.Millisecond).UnixNano())
+	store.GetValue([]byte("short"))
+
+	// Setting a string with an expiration also sends expire, like SET with EX
+	hour := time.Now().Add(time.Hour).UnixNano()
+	store.SetWithOptions([]byte("ex"), []byte("value"), hour, ConditionNone)
+	store.GetOrSet([]byte("getorset"), []byte("value"), hour)
+	store.GetOrSet([]byte("getorset"), []byte("other"), hour)
+
+	expected := []string{
+		"set str", "expire str", "lpush list", "rpop list", "rpush other", "del list",
+		"hset hash", "del str", "set short", "expire short", "expired short",
+		"set ex", "expire ex", "set getorset", "expire getorset",
+	}
+	for _, want := range expected {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Errorf("Expected event %q, got %q", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected event %q", want)
+		}
+	}
+
+	select {
+	case got := <-events:
+		t.Errorf("Unexpected event %q", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	store := NewInMemoryKVStore()
+
+	events, cancel := store.Subscribe("user:*")
+	store.Set([]byte("post:1"), []byte("value"), -1)
+	store.HashSet([]byte("user:1"), [][]byte{[]byte("name"), []byte("gopher")})
+
+	select {
+	case event := <-events:
+		if event.Name != "hset" || string(event.Key) != "user:1" || event.Class != EventHash {
+			t.Errorf("Unexpected event %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected an event for the matching key")
+	}
+
+	cancel()
+	if _, open := <-events; open {
+		t.Error("Expected the channel to be closed by cancel")
+	}
+
+	// Subscriptions end when the store is closed
+	events, _ = store.Subscribe("*")
+	store.Close()
+	select {
+	case _, open := <-events:
+		if open {
+			t.Error("Expected no event after closing the store")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the channel to be closed with the store")
+	}
+}
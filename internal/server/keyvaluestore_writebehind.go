@@ -0,0 +1,194 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/storage"
+)
+
+const (
+	defaultWriteBehindBatchSize  = 500
+	defaultWriteBehindInterval   = time.Second
+	defaultWriteBehindRetryDelay = 100 * time.Millisecond
+)
+
+// Configures how changes are mirrored to a write-behind sink. Zero values use the defaults.
+type WriteBehindOptions struct {
+	BatchSize  int           // Maximum writes per call to the sink, a full batch is written without waiting for the interval
+	Interval   time.Duration // Time between writes to the sink
+	MaxRetries int           // Attempts made after a failed write before the batch is left for the next interval
+	RetryDelay time.Duration // Wait before the first retry, doubled after each one
+}
+
+// Mirrors the string writes and deletes made to the store to a sink.
+type writeBehind struct {
+	sink      storage.Sink
+	opt       WriteBehindOptions
+	pending   map[string]bool // Keys waiting to be written to the sink, true to delete them. Guarded by queueMu, or the store write lock
+	signal    chan struct{}   // Wakes up the goroutine writing to the sink once a batch is full
+	done      chan struct{}   // Closed once the remaining writes were made after the store is closed
+	errorHook func(err error) // Called with the writes that failed after every retry
+}
+
+// Mirrors the changes made to string keys to a sink, like a database the store is used as a cache for.
+// Keys are written with their latest value and deleted when a command deletes them, in batches made
+// by a background goroutine, so commands never wait for the sink. Keys changed again before being
+// written are only written once. Expired keys are left in the sink since they were only dropped
+// from the cache. Failed writes are retried, and kept for the next interval once the retries run out.
+// The remaining writes are made when the store is closed.
+// Must be called before the store is used.
+func (kv *InMemoryKVStore) SetWriteBehind(sink storage.Sink, opt WriteBehindOptions) {
+	if opt.BatchSize <= 0 {
+		opt.BatchSize = defaultWriteBehindBatchSize
+	}
+	if opt.Interval <= 0 {
+		opt.Interval = defaultWriteBehindInterval
+	}
+	if opt.RetryDelay <= 0 {
+		opt.RetryDelay = defaultWriteBehindRetryDelay
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.writeBehind = &writeBehind{
+		sink:    sink,
+		opt:     opt,
+		pending: make(map[string]bool),
+		signal:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go kv.runWriteBehind(kv.writeBehind)
+}
+
+// Registers a hook called with the writes to the write-behind sink that failed after every retry.
+// The writes are attempted again at the next interval.
+func (kv *InMemoryKVStore) OnWriteBehindError(hook func(err error)) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if kv.writeBehind != nil {
+		kv.writeBehind.errorHook = hook
+	}
+}
+
+// Queues the change made by a keyspace event to be written to the sink.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) queueWriteBehind(class KeyspaceEventFlags, name string, key []byte) {
+	wb := kv.writeBehind
+	if wb == nil {
+		return
+	}
+
+	var deleted bool
+	switch {
+	case class == EventString || name == "restore":
+		deleted = false
+	case class == EventGeneric && name == "del":
+		deleted = true
+	default:
+		return
+	}
+
+	kv.queueMu.Lock()
+	wb.pending[string(key)] = deleted
+	full := len(wb.pending) >= wb.opt.BatchSize
+	kv.queueMu.Unlock()
+
+	if full {
+		select {
+		case wb.signal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (kv *InMemoryKVStore) runWriteBehind(wb *writeBehind) {
+	ticker := time.NewTicker(wb.opt.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-wb.signal:
+		case <-kv.closeCh:
+			kv.flushWriteBehind(wb)
+			close(wb.done)
+			return
+		}
+		kv.flushWriteBehind(wb)
+	}
+}
+
+// Writes the pending changes to the sink in batches. Stops at the first batch that failed after every
+// retry, putting its keys back unless they changed again in the meantime.
+func (kv *InMemoryKVStore) flushWriteBehind(wb *writeBehind) {
+	for {
+		batch := kv.takeWriteBehindBatch(wb)
+		if len(batch) == 0 {
+			return
+		}
+
+		err := writeWithRetries(wb, batch)
+		if err == nil {
+			continue
+		}
+
+		kv.mu.Lock()
+		for _, write := range batch {
+			if _, changed := wb.pending[string(write.Key)]; !changed {
+				wb.pending[string(write.Key)] = write.Value == nil
+			}
+		}
+		hook := wb.errorHook
+		kv.mu.Unlock()
+
+		if hook != nil {
+			hook(fmt.Errorf("failed to write %d keys to the write-behind sink: %w", len(batch), err))
+		}
+		return
+	}
+}
+
+// Removes up to a batch of pending keys, returning the writes made of their current values. Keys that
+// no longer hold a string, or expired since they were written, are skipped.
+func (kv *InMemoryKVStore) takeWriteBehindBatch(wb *writeBehind) []storage.Write {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	batch := make([]storage.Write, 0, min(len(wb.pending), wb.opt.BatchSize))
+	for key, deleted := range wb.pending {
+		if len(batch) == wb.opt.BatchSize {
+			break
+		}
+		delete(wb.pending, key)
+
+		if deleted {
+			batch = append(batch, storage.Write{Key: []byte(key)})
+			continue
+		}
+		entry, exists := kv.entry(key)
+		if !exists || entry.isExpired() || entry.kind != kindString {
+			continue
+		}
+		// Never nil, which would delete the key
+		value := append([]byte{}, entry.value...)
+		batch = append(batch, storage.Write{Key: []byte(key), Value: value})
+	}
+
+	return batch
+}
+
+func writeWithRetries(wb *writeBehind, batch []storage.Write) error {
+	delay := wb.opt.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := wb.sink.Write(batch)
+		if err == nil || attempt == wb.opt.MaxRetries {
+			return err
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
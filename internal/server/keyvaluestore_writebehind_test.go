@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/storage"
+)
+
+// Records the writes it receives, failing the first failures calls.
+type recordingSink struct {
+	mu       sync.Mutex
+	data     map[string]string
+	batches  int
+	failures int
+}
+
+func (s *recordingSink) Write(batch []storage.Write) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("sink unavailable")
+	}
+
+	s.batches++
+	for _, write := range batch {
+		if write.Value == nil {
+			delete(s.data, string(write.Key))
+		} else {
+			s.data[string(write.Key)] = string(write.Value)
+		}
+	}
+	return nil
+}
+
+func (s *recordingSink) get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, exists := s.data[key]
+	return value, exists
+}
+
+func TestWriteBehind(t *testing.T) {
+	sink := &recordingSink{data: map[string]string{"stale": "old", "cached": "value"}}
+	store := NewInMemoryKVStore()
+	store.SetWriteBehind(sink, WriteBehindOptions{Interval: time.Hour})
+
+	store.Set([]byte("greeting"), []byte("hello"), -1)
+	store.Set([]byte("greeting"), []byte("hi"), -1)
+	store.Set([]byte("empty"), []byte{}, -1)
+	store.Set([]byte("stale"), []byte("new"), -1)
+	store.Delete([][]byte{[]byte("stale")})
+	store.Push([]byte("list"), [][]byte{[]byte("a")}, false)
+	store.Set([]byte("cached"), []byte("value"), time.Now().Add(10*time.Millisecond).UnixNano())
+	time.Sleep(20 * time.Millisecond)
+	store.GetValue([]byte("cached"))
+
+	// Closing the store makes the remaining writes
+	store.Close()
+
+	if value, _ := sink.get("greeting"); value != "hi" {
+		t.Errorf("Expected the latest value to be written, got %q", value)
+	}
+	if _, exists := sink.get("empty"); !exists {
+		t.Error("Expected an empty string to be written instead of deleting the key")
+	}
+	if _, exists := sink.get("stale"); exists {
+		t.Error("Expected the deleted key to be deleted from the sink")
+	}
+	if _, exists := sink.get("list"); exists {
+		t.Error("Expected lists not to be written")
+	}
+	if value, _ := sink.get("cached"); value != "value" {
+		t.Errorf("Expected the expired key to be left in the sink, got %q", value)
+	}
+	if sink.batches != 1 {
+		t.Errorf("Expected the changes to be written in 1 batch, got %d", sink.batches)
+	}
+}
+
+func TestWriteBehindRetries(t *testing.T) {
+	sink := &recordingSink{data: map[string]string{}, failures: 3}
+	store := NewInMemoryKVStore()
+	defer store.Close()
+	store.SetWriteBehind(sink, WriteBehindOptions{BatchSize: 2, Interval: 10 * time.Millisecond, MaxRetries: 1, RetryDelay: time.Millisecond})
+
+	var mu sync.Mutex
+	var failed []error
+	store.OnWriteBehindError(func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = append(failed, err)
+	})
+
+	store.Set([]byte("key"), []byte("value"), -1)
+
+	// The first attempt and its retry fail, and the next interval succeeds after one more failure
+	deadline := time.Now().Add(time.Second)
+	for {
+		if value, _ := sink.get("key"); value == "value" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the write to succeed after being retried")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failed) != 1 {
+		t.Errorf("Expected 1 error once the retries ran out, got %v", failed)
+	}
+}
@@ -0,0 +1,447 @@
+package server
+
+import (
+	"fmt"
+	"math"
+)
+
+// Options controlling how SortedSetAdd updates existing members.
+type ZAddOptions struct {
+	Condition SetCondition // ConditionNX only adds new members, ConditionXX only updates existing ones
+	GT        bool         // Only update existing members if the new score is greater
+	LT        bool         // Only update existing members if the new score is less
+	CH        bool         // Count updated members in addition to added ones
+}
+
+// Options of the score and lexicographic range queries.
+type ZRangeOptions struct {
+	Rev    bool // Return members from the highest to the lowest
+	Offset int  // Number of members in the range to skip
+	Count  int  // Maximum number of members to return, negative means no limit
+}
+
+// Operation combining the input sorted sets of SortedSetStore.
+type ZStoreOp uint8
+
+const (
+	ZStoreUnion ZStoreOp = iota // Members of any input
+	ZStoreInter                 // Members of every input
+	ZStoreDiff                  // Members of the first input missing from the others
+)
+
+// Returns the name of the keyspace event for the operation.
+func (op ZStoreOp) eventName() string {
+	switch op {
+	case ZStoreInter:
+		return "zinterstore"
+	case ZStoreDiff:
+		return "zdiffstore"
+	default:
+		return "zunionstore"
+	}
+}
+
+// Function merging the scores of a member found in several inputs.
+type ZAggregate uint8
+
+const (
+	AggregateSum ZAggregate = iota
+	AggregateMin
+	AggregateMax
+)
+
+// Options controlling how SortedSetStore combines its inputs.
+type ZStoreOptions struct {
+	Op        ZStoreOp
+	Weights   []float64 // Multiplier of the scores of each input, nil means 1 for every input
+	Aggregate ZAggregate
+}
+
+// Returns the weight of the input at index i.
+func (opt ZStoreOptions) weight(i int) float64 {
+	if opt.Weights == nil {
+		return 1
+	}
+	return opt.Weights[i]
+}
+
+// Merges two scores of the same member. Sums of opposite infinities are treated as 0.
+func (a ZAggregate) merge(x, y float64) float64 {
+	switch a {
+	case AggregateMin:
+		return min(x, y)
+	case AggregateMax:
+		return max(x, y)
+	default:
+		return zeroIfNaN(x + y)
+	}
+}
+
+func zeroIfNaN(f float64) float64 {
+	if math.IsNaN(f) {
+		return 0
+	}
+	return f
+}
+
+// Returns the sorted set stored at key, creating an empty one if create is true.
+// Returns nil if the key does not exist and create is false.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) sortedSetEntry(key []byte, create bool) (*Entry, error) {
+	entry, exists := kv.entry(string(key))
+	if exists && entry.isExpired() {
+		kv.expireKey(string(key))
+		exists = false
+	}
+
+	if exists {
+		if entry.kind != kindSortedSet {
+			return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+		return kv.mutable(string(key), entry), nil
+	}
+
+	if !create {
+		return nil, nil
+	}
+
+	entry = NewSortedSetEntry(-1)
+	kv.addKey(string(key), entry)
+	return entry, nil
+}
+
+func (kv *InMemoryKVStore) SortedSetAdd(key []byte, items []ScoredMember, opt ZAddOptions) (int64, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+
+	// Avoid creating an empty sorted set when no member can be added
+	entry, err := kv.sortedSetEntry(key, opt.Condition != ConditionXX)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+
+	var changed int64 = 0
+	updated := false
+	for _, m := range items {
+		old, exists := entry.zset.score(string(m.Member))
+
+		if exists {
+			if opt.Condition == ConditionNX ||
+				(opt.GT && m.Score <= old) ||
+				(opt.LT && m.Score >= old) {
+				continue
+			}
+
+			if m.Score != old {
+				entry.zset.add(string(m.Member), m.Score)
+				kv.touch(entry)
+				updated = true
+				if opt.CH {
+					changed++
+				}
+			}
+			continue
+		}
+
+		if opt.Condition == ConditionXX {
+			continue
+		}
+
+		entry.zset.add(string(m.Member), m.Score)
+		kv.touch(entry)
+		updated = true
+		changed++
+	}
+	if updated {
+		kv.notify(EventSortedSet, "zadd", key)
+	}
+
+	if entry.zset.len() == 0 {
+		kv.deleteKey(string(key))
+	}
+
+	return changed, nil
+}
+
+func (kv *InMemoryKVStore) SortedSetRemove(key []byte, members [][]byte) (int64, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.sortedSetEntry(key, false)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+
+	var removed int64 = 0
+	for _, member := range members {
+		if entry.zset.remove(string(member)) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		kv.touch(entry)
+		kv.notify(EventSortedSet, "zrem", key)
+	}
+
+	// Sorted sets are removed once their last member is removed
+	if entry.zset.len() == 0 {
+		kv.deleteKey(string(key))
+		kv.notify(EventGeneric, "del", key)
+	}
+
+	return removed, nil
+}
+
+func (kv *InMemoryKVStore) SortedSetScore(key, member []byte) (float64, bool, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, false, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.sortedSetEntry(key, false)
+	if err != nil || entry == nil {
+		return 0, false, err
+	}
+
+	score, exists := entry.zset.score(string(member))
+	return score, exists, nil
+}
+
+func (kv *InMemoryKVStore) SortedSetLen(key []byte) (int64, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.sortedSetEntry(key, false)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+
+	return int64(entry.zset.len()), nil
+}
+
+func (kv *InMemoryKVStore) SortedSetRange(key []byte, start, stop int, rev bool) ([]ScoredMember, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.sortedSetEntry(key, false)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	// Negative indexes count from the end
+	length := entry.zset.len()
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	start = max(start, 0)
+	stop = min(stop, length-1)
+
+	return entry.zset.rangeByRank(start, stop, rev), nil
+}
+
+func (kv *InMemoryKVStore) SortedSetRangeBy(key []byte, spec ZRangeSpec, opt ZRangeOptions) ([]ScoredMember, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return nil, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.sortedSetEntry(key, false)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	return entry.zset.rangeBy(spec, opt.Rev, opt.Offset, opt.Count), nil
+}
+
+func (kv *InMemoryKVStore) SortedSetRemoveRange(key []byte, start, stop int) (int64, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.sortedSetEntry(key, false)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+
+	// Negative indexes count from the end
+	length := entry.zset.len()
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	start = max(start, 0)
+	stop = min(stop, length-1)
+	if start > stop || start >= length {
+		return 0, nil
+	}
+
+	removed := entry.zset.deleteRangeByRank(start, stop)
+	kv.touch(entry)
+	kv.notify(EventSortedSet, "zremrangebyrank", key)
+	if entry.zset.len() == 0 {
+		kv.deleteKey(string(key))
+		kv.notify(EventGeneric, "del", key)
+	}
+
+	return int64(removed), nil
+}
+
+func (kv *InMemoryKVStore) SortedSetRemoveRangeBy(key []byte, spec ZRangeSpec) (int64, error) {
+	locked := kv.lockKeys(key)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+
+	entry, err := kv.sortedSetEntry(key, false)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+
+	removed := entry.zset.deleteRangeBy(spec)
+	if removed > 0 {
+		kv.touch(entry)
+		event := "zremrangebyscore"
+		if _, lex := spec.(LexRange); lex {
+			event = "zremrangebylex"
+		}
+		kv.notify(EventSortedSet, event, key)
+	}
+	if entry.zset.len() == 0 {
+		kv.deleteKey(string(key))
+		kv.notify(EventGeneric, "del", key)
+	}
+
+	return int64(removed), nil
+}
+
+// Returns the member scores of a sorted set or set used as input of SortedSetStore.
+// Set members have a score of 1. Returns nil if the key does not exist.
+// Must be called with the lock of the key held.
+func (kv *InMemoryKVStore) storeInputScores(key []byte) (map[string]float64, error) {
+	entry, exists := kv.entry(string(key))
+	if exists && entry.isExpired() {
+		kv.expireKey(string(key))
+		return nil, nil
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	switch entry.kind {
+	case kindSortedSet:
+		return entry.zset.scores, nil
+	case kindSet:
+		scores := make(map[string]float64, len(entry.set))
+		for member := range entry.set {
+			scores[member] = 1
+		}
+		return scores, nil
+	default:
+		return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+}
+
+// Combines the sorted sets or sets stored at keys and stores the result at dest, replacing any existing value.
+func (kv *InMemoryKVStore) SortedSetStore(dest []byte, keys [][]byte, opt ZStoreOptions) (int64, error) {
+	locked := kv.lockKeys(append([][]byte{dest}, keys...)...)
+	defer kv.unlockKeys(locked)
+
+	if kv.closed {
+		return 0, fmt.Errorf("store is closed")
+	}
+
+	inputs := make([]map[string]float64, len(keys))
+	for i, key := range keys {
+		scores, err := kv.storeInputScores(key)
+		if err != nil {
+			return 0, err
+		}
+		inputs[i] = scores
+	}
+
+	result := make(map[string]float64)
+	switch opt.Op {
+	case ZStoreUnion:
+		for i, input := range inputs {
+			for member, score := range input {
+				score = zeroIfNaN(score * opt.weight(i))
+				if current, exists := result[member]; exists {
+					score = opt.Aggregate.merge(current, score)
+				}
+				result[member] = score
+			}
+		}
+	case ZStoreInter:
+	members:
+		for member, score := range inputs[0] {
+			score = zeroIfNaN(score * opt.weight(0))
+			for i, input := range inputs[1:] {
+				other, exists := input[member]
+				if !exists {
+					continue members
+				}
+				score = opt.Aggregate.merge(score, zeroIfNaN(other*opt.weight(i+1)))
+			}
+			result[member] = score
+		}
+	case ZStoreDiff:
+	diff:
+		for member, score := range inputs[0] {
+			for _, input := range inputs[1:] {
+				if _, exists := input[member]; exists {
+					continue diff
+				}
+			}
+			result[member] = score
+		}
+	}
+
+	// The inputs are read before dest is replaced, so dest can also be one of the inputs
+	_, destExists := kv.entry(string(dest))
+	kv.deleteKey(string(dest))
+	if len(result) == 0 {
+		if destExists {
+			kv.notify(EventGeneric, "del", dest)
+		}
+		return 0, nil
+	}
+
+	entry := NewSortedSetEntry(-1)
+	for member, score := range result {
+		entry.zset.add(member, score)
+	}
+	kv.addKey(string(dest), entry)
+	kv.notify(EventSortedSet, opt.Op.eventName(), dest)
+
+	return int64(len(result)), nil
+}
@@ -0,0 +1,313 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+func TestSortedSetAddAndScore(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("leaderboard")
+
+	added, err := store.SortedSetAdd(key, []ScoredMember{
+		{Member: []byte("alice"), Score: 10},
+		{Member: []byte("bob"), Score: 20},
+	}, ZAddOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if added != 2 {
+		t.Errorf("Expected 2 members added, got %d", added)
+	}
+
+	// Updating a score is not counted without CH
+	added, _ = store.SortedSetAdd(key, []ScoredMember{{Member: []byte("alice"), Score: 30}}, ZAddOptions{})
+	if added != 0 {
+		t.Errorf("Expected 0 members added, got %d", added)
+	}
+
+	score, exists, err := store.SortedSetScore(key, []byte("alice"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !exists || score != 30 {
+		t.Errorf("Expected score 30, got %v (exists=%v)", score, exists)
+	}
+
+	_, exists, _ = store.SortedSetScore(key, []byte("missing"))
+	if exists {
+		t.Error("Expected missing member not to exist")
+	}
+
+	length, _ := store.SortedSetLen(key)
+	if length != 2 {
+		t.Errorf("Expected length 2, got %d", length)
+	}
+}
+
+func TestSortedSetAddOptions(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("leaderboard")
+	store.SortedSetAdd(key, []ScoredMember{{Member: []byte("alice"), Score: 10}}, ZAddOptions{})
+
+	tests := []struct {
+		name      string
+		member    string
+		score     float64
+		opts      ZAddOptions
+		want      int64
+		wantScore float64
+	}{
+		{"NX skips existing", "alice", 5, ZAddOptions{Condition: ConditionNX}, 0, 10},
+		{"XX skips new", "bob", 5, ZAddOptions{Condition: ConditionXX}, 0, 0},
+		{"GT skips lower", "alice", 5, ZAddOptions{GT: true}, 0, 10},
+		{"GT updates higher", "alice", 15, ZAddOptions{GT: true, CH: true}, 1, 15},
+		{"LT skips higher", "alice", 20, ZAddOptions{LT: true, CH: true}, 0, 15},
+		{"CH counts updates", "alice", 1, ZAddOptions{CH: true}, 1, 1},
+	}
+
+	for _, tt := range tests {
+		got, err := store.SortedSetAdd(key, []ScoredMember{{Member: []byte(tt.member), Score: tt.score}}, tt.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, got)
+		}
+
+		score, _, _ := store.SortedSetScore(key, []byte(tt.member))
+		if score != tt.wantScore {
+			t.Errorf("%s: expected score %v, got %v", tt.name, tt.wantScore, score)
+		}
+	}
+
+	// XX on a missing key must not create it
+	store.SortedSetAdd([]byte("missing"), []ScoredMember{{Member: []byte("a"), Score: 1}}, ZAddOptions{Condition: ConditionXX})
+	if store.Exists([][]byte{[]byte("missing")}) != 0 {
+		t.Error("Expected XX not to create the key")
+	}
+}
+
+func TestSortedSetRemoveAndRange(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("leaderboard")
+	store.SortedSetAdd(key, []ScoredMember{
+		{Member: []byte("c"), Score: 3},
+		{Member: []byte("a"), Score: 1},
+		{Member: []byte("b"), Score: 2},
+	}, ZAddOptions{})
+
+	members, err := store.SortedSetRange(key, 0, -1, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(members) != 3 || string(members[0].Member) != "a" || string(members[2].Member) != "c" {
+		t.Errorf("Unexpected range %v", members)
+	}
+
+	members, _ = store.SortedSetRange(key, -2, -1, false)
+	if len(members) != 2 || string(members[0].Member) != "b" {
+		t.Errorf("Unexpected range for negative indexes %v", members)
+	}
+
+	members, _ = store.SortedSetRange(key, 0, 0, true)
+	if len(members) != 1 || string(members[0].Member) != "c" {
+		t.Errorf("Unexpected reverse range %v", members)
+	}
+
+	removed, _ := store.SortedSetRemove(key, [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("x")})
+	if removed != 3 {
+		t.Errorf("Expected 3 members removed, got %d", removed)
+	}
+
+	if store.Exists([][]byte{key}) != 0 {
+		t.Error("Expected sorted set to be deleted after removing the last member")
+	}
+}
+
+func TestSortedSetWrongType(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.Set([]byte("string_key"), []byte("value"), -1)
+
+	if _, err := store.SortedSetAdd([]byte("string_key"), []ScoredMember{{Member: []byte("a"), Score: 1}}, ZAddOptions{}); err == nil {
+		t.Error("Expected error when adding to a string")
+	}
+
+	if _, err := store.SortedSetRange([]byte("string_key"), 0, -1, false); err == nil {
+		t.Error("Expected error when reading a string as a sorted set")
+	}
+}
+
+func TestSortedSetRangeBy(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("events")
+	store.SortedSetAdd(key, []ScoredMember{
+		{Member: []byte("e1"), Score: 100},
+		{Member: []byte("e2"), Score: 200},
+		{Member: []byte("e3"), Score: 300},
+	}, ZAddOptions{})
+
+	members, err := store.SortedSetRangeBy(key, ScoreRange{Min: 150, Max: 300}, ZRangeOptions{Count: -1})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(members) != 2 || string(members[0].Member) != "e2" || members[1].Score != 300 {
+		t.Errorf("Unexpected range %v", members)
+	}
+
+	members, _ = store.SortedSetRangeBy([]byte("missing"), ScoreRange{Min: 0, Max: 1}, ZRangeOptions{Count: -1})
+	if members != nil {
+		t.Errorf("Expected nil for missing key, got %v", members)
+	}
+
+	store.Set([]byte("string_key"), []byte("value"), -1)
+	if _, err := store.SortedSetRangeBy([]byte("string_key"), ScoreRange{Min: 0, Max: 1}, ZRangeOptions{Count: -1}); err == nil {
+		t.Error("Expected error when reading a string as a sorted set")
+	}
+}
+
+func TestSortedSetStore(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	store.SortedSetAdd([]byte("a"), []ScoredMember{
+		{Member: []byte("x"), Score: 1},
+		{Member: []byte("y"), Score: 2},
+	}, ZAddOptions{})
+	store.SortedSetAdd([]byte("b"), []ScoredMember{
+		{Member: []byte("y"), Score: 10},
+		{Member: []byte("z"), Score: 20},
+	}, ZAddOptions{})
+	store.SetAdd([]byte("s"), [][]byte{[]byte("y")})
+
+	tests := []struct {
+		name string
+		keys []string
+		opt  ZStoreOptions
+		want map[string]float64
+	}{
+		{"union sum", []string{"a", "b"}, ZStoreOptions{}, map[string]float64{"x": 1, "y": 12, "z": 20}},
+		{"union weights", []string{"a", "b"}, ZStoreOptions{Weights: []float64{2, 0.5}}, map[string]float64{"x": 2, "y": 9, "z": 10}},
+		{"union max", []string{"a", "b"}, ZStoreOptions{Aggregate: AggregateMax}, map[string]float64{"x": 1, "y": 10, "z": 20}},
+		{"inter min", []string{"a", "b"}, ZStoreOptions{Op: ZStoreInter, Aggregate: AggregateMin}, map[string]float64{"y": 2}},
+		{"inter with set", []string{"b", "s"}, ZStoreOptions{Op: ZStoreInter}, map[string]float64{"y": 11}},
+		{"diff", []string{"a", "b"}, ZStoreOptions{Op: ZStoreDiff}, map[string]float64{"x": 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := make([][]byte, len(tt.keys))
+			for i, key := range tt.keys {
+				keys[i] = []byte(key)
+			}
+
+			length, err := store.SortedSetStore([]byte("dest"), keys, tt.opt)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if length != int64(len(tt.want)) {
+				t.Errorf("Expected %d members, got %d", len(tt.want), length)
+			}
+
+			for member, want := range tt.want {
+				score, exists, _ := store.SortedSetScore([]byte("dest"), []byte(member))
+				if !exists || score != want {
+					t.Errorf("Expected %s to have score %v, got %v (exists=%v)", member, want, score, exists)
+				}
+			}
+		})
+	}
+
+	// An empty result deletes the destination
+	store.SortedSetStore([]byte("dest"), [][]byte{[]byte("a"), []byte("missing")}, ZStoreOptions{Op: ZStoreInter})
+	if exists := store.Exists([][]byte{[]byte("dest")}); exists != 0 {
+		t.Error("Expected empty result to delete the destination")
+	}
+
+	// The destination can also be an input
+	store.SortedSetStore([]byte("a"), [][]byte{[]byte("a"), []byte("b")}, ZStoreOptions{})
+	if length, _ := store.SortedSetLen([]byte("a")); length != 3 {
+		t.Errorf("Expected 3 members in a, got %d", length)
+	}
+
+	store.Set([]byte("string_key"), []byte("value"), -1)
+	if _, err := store.SortedSetStore([]byte("dest"), [][]byte{[]byte("string_key")}, ZStoreOptions{}); err == nil {
+		t.Error("Expected error when using a string as input")
+	}
+}
+
+func TestSortedSetRemoveRange(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	key := []byte("requests")
+	for i := range 10 {
+		store.SortedSetAdd(key, []ScoredMember{{Member: fmt.Appendf(nil, "r%d", i), Score: float64(i * 100)}}, ZAddOptions{})
+	}
+
+	// Prune the entries older than a sliding window
+	removed, err := store.SortedSetRemoveRangeBy(key, ScoreRange{Min: math.Inf(-1), Max: 300, MaxExclusive: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if removed != 3 {
+		t.Errorf("Expected 3 members removed, got %d", removed)
+	}
+
+	// Keep only the 5 most recent entries
+	removed, _ = store.SortedSetRemoveRange(key, 0, -6)
+	if removed != 2 {
+		t.Errorf("Expected 2 members removed, got %d", removed)
+	}
+
+	members, _ := store.SortedSetRange(key, 0, -1, false)
+	if len(members) != 5 || string(members[0].Member) != "r5" {
+		t.Errorf("Unexpected members after removal %v", members)
+	}
+
+	removed, _ = store.SortedSetRemoveRange(key, 10, 20)
+	if removed != 0 {
+		t.Errorf("Expected no members removed out of range, got %d", removed)
+	}
+
+	// Removing every member deletes the key
+	store.SortedSetRemoveRange(key, 0, -1)
+	if exists := store.Exists([][]byte{key}); exists != 0 {
+		t.Error("Expected key to be deleted once empty")
+	}
+}
+
+func TestSortedSetTimestampScores(t *testing.T) {
+	_, addr := startTestServer(t)
+	client := dialTestServer(t, addr)
+
+	// Millisecond timestamps, as used by sliding windows, read back without an exponent
+	client.do("ZADD", "window", "1700000000000", "a", "1.5", "b")
+	if bulk, _ := client.do("ZSCORE", "window", "a").(resp.RespBulkString); string(bulk.Value) != "1700000000000" {
+		t.Errorf("Expected ZSCORE to reply 1700000000000, got %q", bulk.Value)
+	}
+
+	arr, _ := client.do("ZRANGE", "window", "0", "-1", "WITHSCORES").(resp.RespArray)
+	var got []string
+	for _, elem := range arr.Elements {
+		bulk, _ := elem.(resp.RespBulkString)
+		got = append(got, string(bulk.Value))
+	}
+	if expected := []string{"b", "1.5", "a", "1700000000000"}; !slices.Equal(got, expected) {
+		t.Errorf("Expected ZRANGE WITHSCORES to reply %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Latency events recorded by the latency monitor, named like in Redis.
+const (
+	latencyCommand        = "command"          // A command ran on the server loop
+	latencyExpireCycle    = "expire-cycle"     // An active expiration cycle of the store
+	latencyAOFWrite       = "aof-write"        // Buffered commands were written to the append-only file
+	latencyAOFFsyncAlways = "aof-fsync-always" // The append-only file was synced under the always policy
+	latencyAOFFsync       = "aof-fsync"        // The append-only file was synced in the background
+)
+
+// Called with an event and the time it took.
+type latencyFunc func(event string, latency time.Duration)
+
+// Stores that report the time taken by their background work, see InMemoryKVStore.OnLatency.
+type latencyReporter interface {
+	OnLatency(hook func(event string, latency time.Duration))
+}
+
+// Samples kept per event, the last 160 seconds with a spike at most.
+const latencyHistoryLen = 160
+
+type latencySample struct {
+	time    int64 // Unix time in seconds
+	latency int64 // Milliseconds
+}
+
+// The spikes of an event, with the samples in a ring buffer.
+type latencyEvent struct {
+	samples [latencyHistoryLen]latencySample
+	next    int   // Index of the slot for the next sample
+	count   int   // Number of samples in the buffer
+	max     int64 // Highest latency since the last reset, in milliseconds
+}
+
+// Returns the most recent sample.
+func (e *latencyEvent) latest() latencySample {
+	return e.samples[(e.next+latencyHistoryLen-1)%latencyHistoryLen]
+}
+
+// Returns the samples from the oldest to the latest.
+func (e *latencyEvent) history() []latencySample {
+	samples := make([]latencySample, e.count)
+	start := (e.next - e.count + latencyHistoryLen) % latencyHistoryLen
+	for i := range samples {
+		samples[i] = e.samples[(start+i)%latencyHistoryLen]
+	}
+	return samples
+}
+
+// Records the events that took at least a threshold, like the Redis latency monitor, so spikes can be
+// inspected with LATENCY. Events are recorded from the server loop and from background goroutines.
+type latencyMonitor struct {
+	threshold atomic.Int64 // Milliseconds, 0 disables the monitor
+	mu        sync.Mutex
+	events    map[string]*latencyEvent
+}
+
+func newLatencyMonitor() *latencyMonitor {
+	return &latencyMonitor{events: make(map[string]*latencyEvent)}
+}
+
+// Records an event that took latency if it reached the threshold. Spikes within the same second are
+// merged into one sample with the highest latency.
+func (m *latencyMonitor) record(event string, latency time.Duration) {
+	threshold := m.threshold.Load()
+	ms := latency.Milliseconds()
+	if threshold == 0 || ms < threshold {
+		return
+	}
+	m.add(event, time.Now().Unix(), ms)
+}
+
+func (m *latencyMonitor) add(event string, now, ms int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.events[event]
+	if !ok {
+		e = &latencyEvent{}
+		m.events[event] = e
+	}
+	e.max = max(e.max, ms)
+
+	if e.count > 0 {
+		if last := &e.samples[(e.next+latencyHistoryLen-1)%latencyHistoryLen]; last.time == now {
+			last.latency = max(last.latency, ms)
+			return
+		}
+	}
+
+	e.samples[e.next] = latencySample{time: now, latency: ms}
+	e.next = (e.next + 1) % latencyHistoryLen
+	e.count = min(e.count+1, latencyHistoryLen)
+}
+
+// Removes the samples of the given events, or of every event if none is given, and returns the
+// number of events removed.
+func (m *latencyMonitor) reset(events []string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(events) == 0 {
+		n := len(m.events)
+		clear(m.events)
+		return n
+	}
+
+	n := 0
+	for _, event := range events {
+		if _, ok := m.events[event]; ok {
+			delete(m.events, event)
+			n++
+		}
+	}
+	return n
+}
+
+// Records the time taken by f as event.
+func (m *latencyMonitor) measure(event string, f func()) {
+	start := time.Now()
+	f()
+	m.record(event, time.Since(start))
+}
+
+// Sets the minimum latency of the events recorded by the latency monitor, as in Redis'
+// latency-monitor-threshold. 0 disables the monitor, which is the default.
+func (s *Server) SetLatencyMonitorThreshold(threshold time.Duration) {
+	s.latency.threshold.Store(threshold.Milliseconds())
+}
+
+// Handles a LATENCY command from a client.
+func (s *Server) handleLatencyCommand(cmd LatencyCommand, client *Client) {
+	switch cmd.Subcommand {
+	case "LATEST":
+		s.latency.mu.Lock()
+		names := make([]string, 0, len(s.latency.events))
+		for name := range s.latency.events {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		replies := make([][]byte, len(names))
+		for i, name := range names {
+			e := s.latency.events[name]
+			latest := e.latest()
+			replies[i] = resp.EncodeArray([][]byte{
+				resp.EncodeBulkString([]byte(name)),
+				resp.EncodeInteger(latest.time),
+				resp.EncodeInteger(latest.latency),
+				resp.EncodeInteger(e.max),
+			})
+		}
+		s.latency.mu.Unlock()
+
+		client.SendMessage(resp.EncodeArray(replies))
+	case "HISTORY":
+		var samples []latencySample
+		s.latency.mu.Lock()
+		if e, ok := s.latency.events[cmd.Events[0]]; ok {
+			samples = e.history()
+		}
+		s.latency.mu.Unlock()
+
+		replies := make([][]byte, len(samples))
+		for i, sample := range samples {
+			replies[i] = resp.EncodeArray([][]byte{resp.EncodeInteger(sample.time), resp.EncodeInteger(sample.latency)})
+		}
+		client.SendMessage(resp.EncodeArray(replies))
+	case "RESET":
+		client.SendMessage(resp.EncodeInteger(int64(s.latency.reset(cmd.Events))))
+	}
+}
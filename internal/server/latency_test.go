@@ -0,0 +1,104 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+func TestLatencyMonitor(t *testing.T) {
+	m := newLatencyMonitor()
+
+	m.measure(latencyCommand, func() { time.Sleep(2 * time.Millisecond) })
+	if len(m.events) != 0 {
+		t.Errorf("Expected nothing to be recorded while the monitor is disabled, got %v", m.events)
+	}
+
+	m.threshold.Store(1)
+	m.record(latencyCommand, 500*time.Microsecond)
+	if len(m.events) != 0 {
+		t.Errorf("Expected latencies under the threshold to be ignored, got %v", m.events)
+	}
+	m.measure(latencyCommand, func() { time.Sleep(2 * time.Millisecond) })
+	if e := m.events[latencyCommand]; e == nil || e.count != 1 || e.latest().latency < 2 {
+		t.Fatalf("Expected the slow command to be recorded, got %+v", e)
+	}
+
+	// Spikes in the same second are merged, keeping the highest latency
+	m.reset(nil)
+	m.add(latencyExpireCycle, 100, 5)
+	m.add(latencyExpireCycle, 100, 9)
+	m.add(latencyExpireCycle, 100, 3)
+	m.add(latencyExpireCycle, 101, 4)
+	e := m.events[latencyExpireCycle]
+	if got := e.history(); len(got) != 2 || got[0] != (latencySample{100, 9}) || got[1] != (latencySample{101, 4}) {
+		t.Errorf("Expected 2 samples, got %v", got)
+	}
+	if e.max != 9 {
+		t.Errorf("Expected a max of 9ms, got %d", e.max)
+	}
+
+	// Only the latest samples are kept
+	for i := range int64(latencyHistoryLen + 10) {
+		m.add(latencyAOFWrite, 1000+i, i)
+	}
+	history := m.events[latencyAOFWrite].history()
+	if len(history) != latencyHistoryLen || history[0].time != 1010 || history[len(history)-1].time != 1000+latencyHistoryLen+9 {
+		t.Errorf("Expected the last %d samples in order, got %v ... %v", latencyHistoryLen, history[0], history[len(history)-1])
+	}
+
+	if n := m.reset([]string{latencyAOFWrite, "unknown"}); n != 1 || len(m.events) != 1 {
+		t.Errorf("Expected RESET of one event to remove it, got %d and %v", n, m.events)
+	}
+}
+
+func TestLatencyCommand(t *testing.T) {
+	s, addr := startTestServer(t)
+	client := dialTestServer(t, addr)
+	ok := resp.RespSimpleString{Value: "OK"}
+
+	// High enough for the commands of the test not to be recorded, samples are added directly
+	if val := client.do("CONFIG", "SET", "latency-monitor-threshold", "1000"); val != ok {
+		t.Fatalf("Expected CONFIG SET to succeed, got %v", val)
+	}
+	s.latency.add(latencyExpireCycle, 100, 7)
+	s.latency.add(latencyExpireCycle, 105, 3)
+	s.latency.add(latencyAOFWrite, 102, 12)
+
+	latest, _ := client.do("LATENCY", "LATEST").(resp.RespArray)
+	want := []resp.RespValue{
+		resp.RespBulkString{Value: []byte(latencyAOFWrite)},
+		resp.RespInteger{Value: 102},
+		resp.RespInteger{Value: 12},
+		resp.RespInteger{Value: 12},
+	}
+	if len(latest.Elements) != 2 {
+		t.Fatalf("Expected the 2 events, got %v", latest)
+	}
+	if got := latest.Elements[0].(resp.RespArray); !reflect.DeepEqual(got.Elements, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got := latest.Elements[1].(resp.RespArray); got.Elements[2] != (resp.RespInteger{Value: 3}) || got.Elements[3] != (resp.RespInteger{Value: 7}) {
+		t.Errorf("Expected the latest and highest latencies of the expire cycle, got %v", got)
+	}
+
+	history, _ := client.do("LATENCY", "HISTORY", latencyExpireCycle).(resp.RespArray)
+	if len(history.Elements) != 2 {
+		t.Fatalf("Expected 2 samples, got %v", history)
+	}
+	if got := history.Elements[0].(resp.RespArray); got.Elements[0] != (resp.RespInteger{Value: 100}) || got.Elements[1] != (resp.RespInteger{Value: 7}) {
+		t.Errorf("Expected the oldest sample first, got %v", got)
+	}
+	if val, _ := client.do("LATENCY", "HISTORY", "unknown").(resp.RespArray); len(val.Elements) != 0 {
+		t.Errorf("Expected no samples for an unknown event, got %v", val)
+	}
+
+	if val := client.do("LATENCY", "RESET"); val != (resp.RespInteger{Value: 2}) {
+		t.Errorf("Expected RESET to remove the 2 events, got %v", val)
+	}
+	if val, _ := client.do("LATENCY", "LATEST").(resp.RespArray); len(val.Elements) != 0 {
+		t.Errorf("Expected no events after RESET, got %v", val)
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"runtime"
+	"sync/atomic"
+
+	"github.com/CDavidSV/GopherStore/internal/metrics"
+)
+
+// Statistics of the server loop read by Metrics from other goroutines.
+type serverStats struct {
+	connectedClients    atomic.Int64
+	connectionsReceived atomic.Int64
+	commandsProcessed   atomic.Int64
+	concurrentCommands  atomic.Int64 // Commands run outside the server loop, see SetIOThreads
+}
+
+// Stores that count their keys cheaply, see InMemoryKVStore.KeyCount.
+type keyCounter interface {
+	KeyCount() int64
+}
+
+// Returns the metrics of the server, passed to the sinks of a metrics.Reporter. Safe to call from
+// any goroutine. The memory used by the keys is only reported with a maxmemory limit, since it is
+// not tracked otherwise.
+func (s *Server) Metrics() []metrics.Metric {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	m := []metrics.Metric{
+		{Name: "connected_clients", Kind: metrics.Gauge, Value: float64(s.stats.connectedClients.Load())},
+		{Name: "connections_received", Kind: metrics.Counter, Value: float64(s.stats.connectionsReceived.Load())},
+		{Name: "commands_processed", Kind: metrics.Counter, Value: float64(s.stats.commandsProcessed.Load())},
+		{Name: "commands_processed_concurrently", Kind: metrics.Counter, Value: float64(s.stats.concurrentCommands.Load())},
+		{Name: "goroutines", Kind: metrics.Gauge, Value: float64(runtime.NumGoroutine())},
+		{Name: "heap_alloc_bytes", Kind: metrics.Gauge, Value: float64(mem.HeapAlloc)},
+		{Name: "gc_cycles", Kind: metrics.Counter, Value: float64(mem.NumGC)},
+	}
+	m = append(m, poolMetrics()...)
+	if counter, ok := s.store.(keyCounter); ok {
+		m = append(m, metrics.Metric{Name: "keys", Kind: metrics.Gauge, Value: float64(counter.KeyCount())})
+	}
+	if limiter, ok := s.store.(memoryLimiter); ok {
+		used, evicted := limiter.MemoryStats()
+		if store, ok := s.store.(tunableStore); ok && store.MaxMemory() > 0 {
+			m = append(m, metrics.Metric{Name: "used_memory_bytes", Kind: metrics.Gauge, Value: float64(used)})
+		}
+		m = append(m, metrics.Metric{Name: "evicted_keys", Kind: metrics.Counter, Value: float64(evicted)})
+	}
+	return m
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/CDavidSV/GopherStore/internal/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	s, addr := startTestServer(t)
+	client := dialTestServer(t, addr)
+
+	client.do("SET", "a", "1")
+	client.do("SET", "b", "2")
+	client.do("CONFIG", "SET", "maxmemory", "1gb")
+	client.do("PING")
+
+	values := make(map[string]metrics.Metric)
+	for _, m := range s.Metrics() {
+		values[m.Name] = m
+	}
+	if m := values["connected_clients"]; m.Value != 1 || m.Kind != metrics.Gauge {
+		t.Errorf("Expected 1 connected client, got %v", m)
+	}
+	if m := values["commands_processed"]; m.Value < 3 || m.Kind != metrics.Counter {
+		t.Errorf("Expected at least 3 commands processed, got %v", m)
+	}
+	if m := values["keys"]; m.Value != 2 {
+		t.Errorf("Expected 2 keys, got %v", m)
+	}
+	if m := values["used_memory_bytes"]; m.Value <= 0 {
+		t.Errorf("Expected the memory used with a maxmemory limit, got %v", m)
+	}
+}
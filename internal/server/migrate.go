@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Handles the MIGRATE command from a client. The keys are exported in the JSON export format and
+// sent to the target instance with IMPORT, then deleted unless COPY was given. Like in Redis, the
+// server loop waits for the target instance, for at most the timeout, so no other command sees the
+// keys on both instances or on neither. Replies OK, or NOKEY if none of the keys exist.
+func (s *Server) handleMigrateCommand(cmd MigrateCommand, client *Client) {
+	exporter, ok := s.store.(jsonExporter)
+	if !ok {
+		client.SendMessage(resp.EncodeError("the store does not support migrations"))
+		return
+	}
+
+	var buf bytes.Buffer
+	exported, err := exporter.ExportJSONKeys(&buf, cmd.Keys)
+	if err != nil {
+		s.logger.Error("failed to handle MIGRATE command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+	if exported == 0 {
+		client.SendMessage(resp.EncodeSimpleString("NOKEY"))
+		return
+	}
+
+	if err := migrateKeys(cmd.Addr, buf.Bytes(), cmd.Replace, cmd.Timeout); err != nil {
+		s.logger.Warn("failed to migrate keys", "error", err, "target", cmd.Addr, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	if !cmd.Copy {
+		s.store.Delete(cmd.Keys)
+	}
+	client.SendMessage(resp.EncodeSimpleString("OK"))
+}
+
+// Imports keys in the JSON export format on the instance at addr, failing if one of them exists on
+// it unless replace is true.
+func migrateKeys(addr string, data []byte, replace bool, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return fmt.Errorf("IOERR error or timeout connecting to the target instance: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	args := [][]byte{[]byte(CmdImport), data}
+	if !replace {
+		args = append(args, []byte("NX"))
+	}
+	if _, err := conn.Write(resp.EncodeBulkStringArray(args)); err != nil {
+		return fmt.Errorf("IOERR error or timeout writing to the target instance: %v", err)
+	}
+
+	val, err := resp.ReadRESP(bufio.NewReader(conn))
+	if err != nil {
+		return fmt.Errorf("IOERR error or timeout reading from the target instance: %v", err)
+	}
+	switch val := val.(type) {
+	case resp.RespInteger:
+		return nil
+	case resp.RespErrorValue:
+		return fmt.Errorf("target instance replied with error: %s", val.Message)
+	default:
+		return fmt.Errorf("unexpected reply from the target instance: %v", val)
+	}
+}
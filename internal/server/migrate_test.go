@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+func TestMigrate(t *testing.T) {
+	_, sourceAddr := startTestServer(t)
+	_, targetAddr := startTestServer(t)
+	source := dialTestServer(t, sourceAddr)
+	target := dialTestServer(t, targetAddr)
+	host, port, _ := net.SplitHostPort(targetAddr)
+	ok := resp.RespSimpleString{Value: "OK"}
+
+	source.do("SET", "a", "1")
+	source.do("RPUSH", "list", "x", "y")
+	source.do("SET", "b", "2")
+	target.do("SET", "b", "old")
+
+	if val := source.do("MIGRATE", host, port, "", "1000", "KEYS", "a", "list"); val != ok {
+		t.Fatalf("Expected MIGRATE to reply OK, got %v", val)
+	}
+	if val := source.do("EXISTS", "a", "list"); val != (resp.RespInteger{Value: 0}) {
+		t.Errorf("Expected the keys to be removed from the source, got %v", val)
+	}
+	if val, _ := target.do("GET", "a").(resp.RespBulkString); string(val.Value) != "1" {
+		t.Errorf("Expected the key to be moved to the target, got %q", val.Value)
+	}
+	if val := target.do("LLEN", "list"); val != (resp.RespInteger{Value: 2}) {
+		t.Errorf("Expected the list to be moved to the target, got %v", val)
+	}
+
+	// Existing keys are only replaced with REPLACE
+	if _, isErr := source.do("MIGRATE", host, port, "b", "1000").(resp.RespErrorValue); !isErr {
+		t.Error("Expected MIGRATE to fail when the key exists on the target")
+	}
+	if val := source.do("EXISTS", "b"); val != (resp.RespInteger{Value: 1}) {
+		t.Error("Expected a failed MIGRATE to keep the key")
+	}
+	if val := source.do("MIGRATE", host, port, "b", "1000", "COPY", "REPLACE"); val != ok {
+		t.Fatalf("Expected MIGRATE to reply OK, got %v", val)
+	}
+	if val, _ := target.do("GET", "b").(resp.RespBulkString); string(val.Value) != "2" {
+		t.Errorf("Expected the key to be replaced on the target, got %q", val.Value)
+	}
+	if val := source.do("EXISTS", "b"); val != (resp.RespInteger{Value: 1}) {
+		t.Error("Expected COPY to keep the key")
+	}
+
+	if val := source.do("MIGRATE", host, port, "missing", "1000"); val != (resp.RespSimpleString{Value: "NOKEY"}) {
+		t.Errorf("Expected NOKEY, got %v", val)
+	}
+	if _, isErr := source.do("MIGRATE", "127.0.0.1", "1", "b", "100").(resp.RespErrorValue); !isErr {
+		t.Error("Expected MIGRATE to fail when the target is unreachable")
+	}
+}
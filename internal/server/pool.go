@@ -0,0 +1,80 @@
+package server
+
+import (
+	"bufio"
+	"sync"
+	"sync/atomic"
+
+	"github.com/CDavidSV/GopherStore/internal/metrics"
+)
+
+// Pools of the objects allocated for every command or connection, reused to cut the allocations and
+// garbage collection under sustained load. Each pool counts the objects it had to allocate, so the
+// share of gets served by a reused object is reported by Server.Metrics. Entries are reused as soon as
+// their key is written again, so they are only read under the lock of their key, see discard.
+var (
+	entryPool       = newCountedPool("entry", func() *Entry { return &Entry{} })
+	replyBufferPool = newCountedPool("reply_buffer", func() *[]byte { b := make([]byte, 0, 512); return &b })
+	readerPool      = newCountedPool("reader", func() *bufio.Reader { return bufio.NewReader(nil) })
+	writerPool      = newCountedPool("writer", func() *bufio.Writer { return bufio.NewWriter(nil) })
+)
+
+// Reply buffers that grew past this size are left to the garbage collector, so a single large reply
+// does not keep its memory allocated in the pool.
+const maxPooledReplyBuffer = 64 << 10
+
+// A sync.Pool counting its gets and the objects allocated to serve them.
+type countedPool[T any] struct {
+	name   string
+	pool   sync.Pool
+	gets   atomic.Int64
+	allocs atomic.Int64
+}
+
+func newCountedPool[T any](name string, alloc func() *T) *countedPool[T] {
+	p := &countedPool[T]{name: name}
+	p.pool.New = func() any {
+		p.allocs.Add(1)
+		return alloc()
+	}
+	return p
+}
+
+func (p *countedPool[T]) get() *T {
+	p.gets.Add(1)
+	return p.pool.Get().(*T)
+}
+
+// Returns an object to the pool. It must not be used afterwards.
+func (p *countedPool[T]) put(x *T) {
+	p.pool.Put(x)
+}
+
+// Returns the hit and miss counters of the pool, the gets served by a reused object and by a new one.
+func (p *countedPool[T]) metrics() []metrics.Metric {
+	misses := p.allocs.Load()
+	hits := p.gets.Load() - misses
+	return []metrics.Metric{
+		{Name: p.name + "_pool_hits", Kind: metrics.Counter, Value: float64(hits)},
+		{Name: p.name + "_pool_misses", Kind: metrics.Counter, Value: float64(misses)},
+	}
+}
+
+// Returns a reply buffer to replyBufferPool, unless it grew too large.
+func releaseReplyBuffer(buf *[]byte) {
+	if cap(*buf) > maxPooledReplyBuffer {
+		return
+	}
+	*buf = (*buf)[:0]
+	replyBufferPool.put(buf)
+}
+
+// Returns the hit and miss counters of every pool.
+func poolMetrics() []metrics.Metric {
+	var m []metrics.Metric
+	m = append(m, entryPool.metrics()...)
+	m = append(m, replyBufferPool.metrics()...)
+	m = append(m, readerPool.metrics()...)
+	m = append(m, writerPool.metrics()...)
+	return m
+}
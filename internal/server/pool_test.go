@@ -0,0 +1,119 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/CDavidSV/GopherStore/internal/metrics"
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+func TestCountedPool(t *testing.T) {
+	p := newCountedPool("test", func() *int { return new(int) })
+	for range 10 {
+		p.put(p.get())
+	}
+
+	values := make(map[string]metrics.Metric)
+	for _, m := range p.metrics() {
+		values[m.Name] = m
+	}
+	hits, misses := values["test_pool_hits"], values["test_pool_misses"]
+	if misses.Value < 1 || hits.Value+misses.Value != 10 || hits.Kind != metrics.Counter {
+		t.Errorf("Expected 10 gets counted as hits and at least 1 miss, got %v and %v", hits, misses)
+	}
+}
+
+func TestDiscardRecyclesStringEntries(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	// Overwritten and deleted strings are reset before going back to the pool
+	store.Set([]byte("string"), []byte("value"), -1)
+	old, _ := storedEntry(store, "string")
+	store.Set([]byte("string"), []byte("new"), -1)
+	if old.value != nil {
+		t.Errorf("Expected the overwritten entry to be reset, got %q", old.value)
+	}
+	if value, _ := store.GetValue([]byte("string")); string(value) != "new" {
+		t.Errorf("Expected new, got %q", value)
+	}
+
+	// Entries of other kinds may still be referenced and are left alone
+	store.Push([]byte("list"), [][]byte{[]byte("a")}, false)
+	list, _ := storedEntry(store, "list")
+	store.Delete([][]byte{[]byte("list")})
+	if len(list.list) != 1 {
+		t.Errorf("Expected the deleted list entry to be left alone, got %v", list.list)
+	}
+
+	// Entries written by a snapshot are kept until it is done
+	snapshot, err := store.BeginSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snapshot.Release()
+	frozen, _ := storedEntry(store, "string")
+	store.Delete([][]byte{[]byte("string")})
+	if string(frozen.value) != "new" {
+		t.Errorf("Expected the entry held by the snapshot to be kept, got %q", frozen.value)
+	}
+}
+
+func TestGetValueWithRecycledEntries(t *testing.T) {
+	store := NewInMemoryKVStore()
+	defer store.Close()
+
+	// Entries replaced by one key are reused by the others, a read must never see another key's value
+	const writes = 20000
+	keys := []string{"a", "b", "c"}
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Go(func() {
+			for i := range writes {
+				store.Set([]byte(key), []byte(key+":"+strconv.Itoa(i)), -1)
+			}
+		})
+	}
+	for _, key := range keys {
+		wg.Go(func() {
+			for range writes {
+				value, err := store.GetValue([]byte(key))
+				if err != nil || (value != nil && !strings.HasPrefix(string(value), key+":")) {
+					t.Errorf("Expected a value of %s, got %q (%v)", key, value, err)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func TestPooledReplies(t *testing.T) {
+	s, addr := startTestServer(t)
+	client := dialTestServer(t, addr)
+
+	// Replies encoded in reused buffers never carry the bytes of an earlier reply
+	for i := range 100 {
+		value := strconv.Itoa(i) + ":" + string(make([]byte, i%7))
+		client.do("SET", "key", value)
+		if bulk, _ := client.do("GET", "key").(resp.RespBulkString); string(bulk.Value) != value {
+			t.Fatalf("Expected %q, got %q", value, bulk.Value)
+		}
+	}
+	if arr, _ := client.do("MGET", "key", "missing").(resp.RespArray); len(arr.Elements) != 2 {
+		t.Errorf("Expected 2 values, got %v", arr)
+	}
+
+	values := make(map[string]metrics.Metric)
+	for _, m := range s.Metrics() {
+		values[m.Name] = m
+	}
+	for _, name := range []string{"entry", "reply_buffer", "reader", "writer"} {
+		if m := values[name+"_pool_hits"]; m.Value+values[name+"_pool_misses"].Value == 0 {
+			t.Errorf("Expected gets from the %s pool to be counted", name)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"strconv"
+	"time"
+)
+
+// Propagates the commands that changed the store to the append-only file and the replicas.
+// Must be called from the server loop.
+func (s *Server) propagate(commands ...[][]byte) {
+	if !s.propagating() {
+		return
+	}
+
+	for _, args := range commands {
+		if len(args) == 0 {
+			continue
+		}
+		if s.aof != nil {
+			s.aof.append(args)
+		}
+		s.replicate(args)
+	}
+}
+
+// Stores that report the keys and hash fields they removed by themselves, see
+// InMemoryKVStore.TrackRemovals.
+type removalTracker interface {
+	TrackRemovals() <-chan struct{}
+	TakeRemovals() []Removal
+	SetPassiveExpiry(passive bool)
+}
+
+// Propagates the keys and hash fields the store removed because they expired as DEL and HDEL, so
+// replicas, which leave expired keys to their primary, remove them at the same time.
+// Must be called from the server loop.
+func (s *Server) propagateRemovals() {
+	tracker, ok := s.store.(removalTracker)
+	if !ok || s.removalCh == nil {
+		return
+	}
+
+	for _, removal := range tracker.TakeRemovals() {
+		if removal.Fields == nil {
+			s.propagate([][]byte{[]byte(CmdDelete), removal.Key})
+		} else {
+			s.propagate(append([][]byte{[]byte(CmdHDel), removal.Key}, removal.Fields...))
+		}
+	}
+}
+
+// Reports whether changes are propagated anywhere, so commands are only rewritten when needed.
+// Must be called from the server loop.
+func (s *Server) propagating() bool {
+	return s.aof != nil || len(s.replicas) > 0
+}
+
+// Propagates a command handled by the server loop if it changed the store. Commands are rewritten
+// so replaying them gives the same result later: relative expirations become absolute, generated
+// stream IDs are made explicit and blocking commands no longer block.
+func (s *Server) propagateMessage(msg Message) {
+	if !s.propagating() {
+		return
+	}
+
+	switch cmd := msg.cmd.(type) {
+	case BlockingPopCommand:
+		// Propagated by the handler, which knows the key that was popped
+	case SetCommand:
+		s.propagate(s.withExpiration(cmd.Key, [][]byte{[]byte(CmdSet), cmd.Key, cmd.Value})...)
+	case GetOrSetCommand:
+		s.propagate(s.withExpiration(cmd.Key, [][]byte{[]byte(CmdSet), cmd.Key, cmd.Value})...)
+	case PushCommand:
+		// The push may have created the list with the default expiration
+		s.propagate(s.withExpiration(cmd.Key, msg.args)...)
+	case ExpireCommand:
+		s.propagate(s.withExpiration(cmd.Key, nil)...)
+	case HExpireCommand:
+		at := cmd.At
+		if at.IsZero() {
+			at = time.Now().Add(cmd.TTL)
+		}
+		args := [][]byte{[]byte(CmdHPExpireAt), cmd.Key, formatUnixMilli(at.UnixNano()), []byte("FIELDS"), []byte(strconv.Itoa(len(cmd.Fields)))}
+		s.propagate(append(args, cmd.Fields...))
+	case MoveCommand:
+		s.propagate(moveArgs(cmd))
+	case MigrateCommand:
+		// The keys were moved away
+		s.propagate(append([][]byte{[]byte(CmdDelete)}, cmd.Keys...))
+	case XAddCommand:
+		s.propagate(s.xaddArgs(cmd))
+	case XReadGroupCommand:
+		s.propagate(xreadGroupArgs(cmd, cmd.Keys, cmd.IDs, cmd.New))
+	default:
+		s.propagate(msg.args)
+	}
+}
+
+// Returns the command followed by a PEXPIREAT giving the key its current expiration, or a DEL if
+// the key expired.
+func (s *Server) withExpiration(key []byte, args [][]byte) [][][]byte {
+	commands := [][][]byte{args}
+	switch expiresAt := s.store.ExpiresAt(key); {
+	case expiresAt > 0:
+		commands = append(commands, [][]byte{[]byte(CmdPExpireAt), key, formatUnixMilli(expiresAt)})
+	case expiresAt == -2 && args == nil:
+		commands = append(commands, [][]byte{[]byte(CmdDelete), key})
+	}
+	return commands
+}
+
+// Returns a stream entry added by XADD with its ID made explicit.
+func (s *Server) xaddArgs(cmd XAddCommand) [][]byte {
+	id, _ := s.store.StreamLastID(cmd.Key)
+
+	args := [][]byte{[]byte(CmdXAdd), cmd.Key}
+	if cmd.Options.MaxLen >= 0 {
+		args = append(args, []byte("MAXLEN"), []byte(strconv.Itoa(cmd.Options.MaxLen)))
+	}
+	args = append(args, []byte(id.String()))
+	return append(args, cmd.Fields...)
+}
+
+// Returns LMOVE or BLMOVE as a non-blocking LMOVE.
+func moveArgs(cmd MoveCommand) [][]byte {
+	return [][]byte{[]byte(CmdLMove), cmd.Source, cmd.Destination, listDirection(cmd.popAtFront), listDirection(cmd.pushAtFront)}
+}
+
+// Returns an XREADGROUP reading the given keys without blocking.
+func xreadGroupArgs(cmd XReadGroupCommand, keys [][]byte, ids []StreamID, isNew []bool) [][]byte {
+	args := [][]byte{[]byte(CmdXReadGroup), []byte("GROUP"), cmd.Group, cmd.Consumer}
+	if cmd.Count >= 0 {
+		args = append(args, []byte("COUNT"), []byte(strconv.Itoa(cmd.Count)))
+	}
+	if cmd.NoAck {
+		args = append(args, []byte("NOACK"))
+	}
+
+	args = append(args, []byte("STREAMS"))
+	args = append(args, keys...)
+	for i := range keys {
+		if isNew[i] {
+			args = append(args, []byte(">"))
+		} else {
+			args = append(args, []byte(ids[i].String()))
+		}
+	}
+	return args
+}
+
+func listDirection(front bool) []byte {
+	if front {
+		return []byte("LEFT")
+	}
+	return []byte("RIGHT")
+}
+
+// Formats a time in unix nanoseconds as unix milliseconds.
+func formatUnixMilli(nanos int64) []byte {
+	return []byte(strconv.FormatInt(nanos/int64(time.Millisecond), 10))
+}
@@ -1,7 +1,12 @@
 package server
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/CDavidSV/GopherStore/internal/resp"
@@ -13,19 +18,147 @@ type SetCondition int
 
 const (
 	// Commands
-	CmdPing    CommandName = "PING"
-	CmdSet     CommandName = "SET"
-	CmdGet     CommandName = "GET"
-	CmdLPush   CommandName = "LPUSH"
-	CmdRPush   CommandName = "RPUSH"
-	CmdLPop    CommandName = "LPOP"
-	CmdRPop    CommandName = "RPOP"
-	CmdLLen    CommandName = "LLEN"
-	CmdLRange  CommandName = "LRANGE"
-	CmdExists  CommandName = "EXISTS"
-	CmdDelete  CommandName = "DEL"
-	CmdExpire  CommandName = "EXPIRE"
-	CmdPExpire CommandName = "PEXPIRE"
+	CmdPing      CommandName = "PING"
+	CmdSet       CommandName = "SET"
+	CmdGet       CommandName = "GET"
+	CmdLPush     CommandName = "LPUSH"
+	CmdRPush     CommandName = "RPUSH"
+	CmdLPop      CommandName = "LPOP"
+	CmdRPop      CommandName = "RPOP"
+	CmdLLen      CommandName = "LLEN"
+	CmdLRange    CommandName = "LRANGE"
+	CmdExists    CommandName = "EXISTS"
+	CmdDelete    CommandName = "DEL"
+	CmdExpire    CommandName = "EXPIRE"
+	CmdPExpire   CommandName = "PEXPIRE"
+	CmdExpireAt  CommandName = "EXPIREAT"
+	CmdPExpireAt CommandName = "PEXPIREAT"
+	CmdGetOrSet  CommandName = "GETORSET"
+	CmdDelIfEq   CommandName = "DELIFEQ"
+	CmdDebug     CommandName = "DEBUG"
+	CmdBigKeys   CommandName = "BIGKEYS"
+	CmdMemory    CommandName = "MEMORY"
+	CmdObject    CommandName = "OBJECT"
+	CmdMGet      CommandName = "MGET"
+	CmdBLPop     CommandName = "BLPOP"
+	CmdBRPop     CommandName = "BRPOP"
+	CmdLMove     CommandName = "LMOVE"
+	CmdBLMove    CommandName = "BLMOVE"
+	CmdClient    CommandName = "CLIENT"
+	CmdHello     CommandName = "HELLO"
+	CmdAuth      CommandName = "AUTH"
+	CmdConfig    CommandName = "CONFIG"
+	CmdCommand   CommandName = "COMMAND"
+	CmdLatency   CommandName = "LATENCY"
+
+	// Hash commands
+	CmdHSet         CommandName = "HSET"
+	CmdHSetNX       CommandName = "HSETNX"
+	CmdHMGet        CommandName = "HMGET"
+	CmdHLen         CommandName = "HLEN"
+	CmdHKeys        CommandName = "HKEYS"
+	CmdHVals        CommandName = "HVALS"
+	CmdHGet         CommandName = "HGET"
+	CmdHDel         CommandName = "HDEL"
+	CmdHGetAll      CommandName = "HGETALL"
+	CmdHExists      CommandName = "HEXISTS"
+	CmdHIncrBy      CommandName = "HINCRBY"
+	CmdHIncrByFloat CommandName = "HINCRBYFLOAT"
+	CmdHExpire      CommandName = "HEXPIRE"
+	CmdHPExpire     CommandName = "HPEXPIRE"
+	CmdHExpireAt    CommandName = "HEXPIREAT"
+	CmdHPExpireAt   CommandName = "HPEXPIREAT"
+	CmdHTTL         CommandName = "HTTL"
+	CmdHPTTL        CommandName = "HPTTL"
+
+	// Set commands
+	CmdSAdd       CommandName = "SADD"
+	CmdSRem       CommandName = "SREM"
+	CmdSIsMember  CommandName = "SISMEMBER"
+	CmdSMIsMember CommandName = "SMISMEMBER"
+	CmdSMembers   CommandName = "SMEMBERS"
+	CmdSCard      CommandName = "SCARD"
+	CmdSScan      CommandName = "SSCAN"
+
+	// Sorted set commands
+	CmdZAdd             CommandName = "ZADD"
+	CmdZRem             CommandName = "ZREM"
+	CmdZScore           CommandName = "ZSCORE"
+	CmdZCard            CommandName = "ZCARD"
+	CmdZRange           CommandName = "ZRANGE"
+	CmdZRevRange        CommandName = "ZREVRANGE"
+	CmdZRangeByScore    CommandName = "ZRANGEBYSCORE"
+	CmdZRevRangeByScore CommandName = "ZREVRANGEBYSCORE"
+	CmdZRangeByLex      CommandName = "ZRANGEBYLEX"
+	CmdZRevRangeByLex   CommandName = "ZREVRANGEBYLEX"
+	CmdZUnionStore      CommandName = "ZUNIONSTORE"
+	CmdZInterStore      CommandName = "ZINTERSTORE"
+	CmdZDiffStore       CommandName = "ZDIFFSTORE"
+	CmdZRemRangeByScore CommandName = "ZREMRANGEBYSCORE"
+	CmdZRemRangeByRank  CommandName = "ZREMRANGEBYRANK"
+
+	// Bitmap commands
+	CmdBitOp    CommandName = "BITOP"
+	CmdBitField CommandName = "BITFIELD"
+
+	// HyperLogLog commands
+	CmdPFAdd   CommandName = "PFADD"
+	CmdPFCount CommandName = "PFCOUNT"
+	CmdPFMerge CommandName = "PFMERGE"
+
+	// Stream commands
+	CmdXAdd       CommandName = "XADD"
+	CmdXLen       CommandName = "XLEN"
+	CmdXRange     CommandName = "XRANGE"
+	CmdXRevRange  CommandName = "XREVRANGE"
+	CmdXRead      CommandName = "XREAD"
+	CmdXGroup     CommandName = "XGROUP"
+	CmdXReadGroup CommandName = "XREADGROUP"
+	CmdXAck       CommandName = "XACK"
+	CmdXPending   CommandName = "XPENDING"
+	CmdXAutoClaim CommandName = "XAUTOCLAIM"
+
+	// Geo commands
+	CmdGeoAdd    CommandName = "GEOADD"
+	CmdGeoDist   CommandName = "GEODIST"
+	CmdGeoSearch CommandName = "GEOSEARCH"
+
+	// Pub/sub commands
+	CmdSubscribe    CommandName = "SUBSCRIBE"
+	CmdUnsubscribe  CommandName = "UNSUBSCRIBE"
+	CmdPSubscribe   CommandName = "PSUBSCRIBE"
+	CmdPUnsubscribe CommandName = "PUNSUBSCRIBE"
+	CmdPublish      CommandName = "PUBLISH"
+	CmdPubSub       CommandName = "PUBSUB"
+	CmdSSubscribe   CommandName = "SSUBSCRIBE"
+	CmdSUnsubscribe CommandName = "SUNSUBSCRIBE"
+	CmdSPublish     CommandName = "SPUBLISH"
+
+	// Function commands
+	CmdFCall    CommandName = "FCALL"
+	CmdFCallRO  CommandName = "FCALL_RO"
+	CmdFunction CommandName = "FUNCTION"
+
+	// Persistence commands
+	CmdSave     CommandName = "SAVE"
+	CmdBGSave   CommandName = "BGSAVE"
+	CmdLastSave CommandName = "LASTSAVE"
+	CmdExport   CommandName = "EXPORT"
+	CmdImport   CommandName = "IMPORT"
+	CmdSync     CommandName = "SYNC"
+
+	// Replication commands
+	CmdReplicaOf CommandName = "REPLICAOF"
+	CmdSlaveOf   CommandName = "SLAVEOF"
+	CmdPSync     CommandName = "PSYNC"
+	CmdReplConf  CommandName = "REPLCONF"
+	CmdWait      CommandName = "WAIT"
+	CmdFailover  CommandName = "FAILOVER"
+	CmdRole      CommandName = "ROLE"
+
+	// Cluster commands
+	CmdCluster CommandName = "CLUSTER"
+	CmdMigrate CommandName = "MIGRATE"
 
 	// SET command conditions
 	ConditionNone SetCondition = iota
@@ -35,16 +168,199 @@ const (
 
 type Command interface{}
 
+var errUnknownCommand = errors.New("unknown command")
+
 type SetCommand struct {
 	Key, Value []byte
 	expiration *time.Duration
 	condition  SetCondition
 }
 
-type DeleteCommand struct {
+type MGetCommand struct {
 	Keys [][]byte
 }
 
+type GetOrSetCommand struct {
+	Key, Value []byte
+	expiration *time.Duration
+}
+
+// BIGKEYS [COUNT count] [BY MEMORY|ELEMENTS] | BIGKEYS SUMMARY
+type BigKeysCommand struct {
+	Count   int
+	By      KeyMeasure
+	Summary bool // Reply with the totals of every type instead
+}
+
+// MEMORY USAGE key [SAMPLES count] | MEMORY STATS
+type MemoryCommand struct {
+	Subcommand string
+	Key        []byte
+}
+
+// OBJECT FREQ key
+type ObjectCommand struct {
+	Subcommand string
+	Key        []byte
+}
+
+// SAVE, or BGSAVE to save in the background.
+type SaveCommand struct {
+	Background bool
+}
+
+type LastSaveCommand struct{}
+
+type ExportCommand struct {
+	Pattern []byte // Empty to export every key
+}
+
+type ImportCommand struct {
+	Data []byte // Records in the JSON export format
+	NX   bool   // Fail without importing anything if one of the keys exists
+}
+
+type SyncCommand struct{}
+
+// REPLICAOF host port, or REPLICAOF NO ONE to stop replicating.
+type ReplicaOfCommand struct {
+	Addr string // host:port of the primary, empty for NO ONE
+}
+
+// Sent by a replica to start receiving the replication stream of a primary.
+type PSyncCommand struct {
+	ReplID string // ? when the replica has no history
+	Offset int64  // -1 when the replica has no history
+}
+
+// Exchanged between a primary and its replicas: REPLCONF ACK offset is sent by replicas to report the
+// replication stream they applied, REPLCONF GETACK * by primaries to request it, and
+// REPLCONF LISTENING-PORT port by replicas before PSYNC to tell the port they accept clients on.
+type ReplConfCommand struct {
+	Option string // Upper case
+	Offset int64  // Set for ACK
+	Port   string // Set for LISTENING-PORT
+}
+
+type WaitCommand struct {
+	NumReplicas int
+	Timeout     time.Duration // Zero blocks forever
+}
+
+type RoleCommand struct{}
+
+// CLUSTER subcommands. HELLO is sent between nodes to share what they know about the cluster.
+type ClusterCommand struct {
+	Subcommand string
+	Key        []byte            // Set for KEYSLOT
+	Addr       string            // host:port for MEET
+	Slots      []slotRange       // Set for ADDSLOTS and ADDSLOTSRANGE
+	Nodes      []clusterNodeInfo // Set for HELLO, starting with the sender
+}
+
+// MIGRATE host port key|"" timeout [COPY] [REPLACE] [KEYS key [key ...]]
+type MigrateCommand struct {
+	Addr    string // host:port of the target instance
+	Keys    [][]byte
+	Timeout time.Duration
+	Copy    bool // Keep the keys on this instance
+	Replace bool // Replace existing keys on the target instance
+}
+
+// FAILOVER [TO host port [FORCE]] [TIMEOUT milliseconds], or FAILOVER ABORT.
+type FailoverCommand struct {
+	To      string        // host:port of the replica to promote, empty for the first one to catch up
+	Timeout time.Duration // Zero waits forever
+	Force   bool          // Promote the replica given with TO once the timeout expires, even if it did not catch up
+	Abort   bool
+}
+
+type SubscribeCommand struct {
+	Channels [][]byte
+	Pattern  bool // PSUBSCRIBE, the channels are glob-style patterns
+	Shard    bool // SSUBSCRIBE, the channels are shard channels
+}
+
+type UnsubscribeCommand struct {
+	Channels [][]byte // Unsubscribe from all channels when empty
+	Pattern  bool     // PUNSUBSCRIBE, the channels are glob-style patterns
+	Shard    bool     // SUNSUBSCRIBE, the channels are shard channels
+}
+
+type PublishCommand struct {
+	Channel []byte
+	Message []byte
+	Shard   bool // SPUBLISH, the channel is a shard channel
+}
+
+type PubSubCommand struct {
+	Subcommand string
+	Pattern    []byte   // Only list channels matching this pattern for CHANNELS and SHARDCHANNELS, all channels if nil
+	Channels   [][]byte // Channels to count the subscribers of for NUMSUB and SHARDNUMSUB
+}
+
+type ClientCommand struct {
+	Subcommand string
+	ReplyMode  string // ON, OFF or SKIP for CLIENT REPLY
+	Name       string // Set by CLIENT SETNAME
+
+	// CLIENT TRACKING options
+	Tracking bool     // ON or OFF
+	Redirect int64    // ID of the client receiving the invalidation messages
+	BCast    bool     // Invalidate every key changed, not only the keys read by the client
+	Prefixes [][]byte // Only broadcast invalidations of keys with these prefixes
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+type HelloCommand struct {
+	Protocol resp.Protocol // 0 to keep the current protocol
+	Auth     *AuthCommand  // Credentials given with AUTH, nil if none
+	Name     *string       // Name given with SETNAME, nil if none
+}
+
+// AUTH [username] password
+type AuthCommand struct {
+	Username string
+	Password string
+}
+
+// CONFIG GET pattern [pattern ...] | CONFIG SET parameter value [parameter value ...] | CONFIG REWRITE
+type ConfigCommand struct {
+	Subcommand string
+	Patterns   []string // Parameters to get, as glob patterns
+	Pairs      []string // Alternating parameters and values to set
+}
+
+// COMMAND | COMMAND COUNT | COMMAND LIST | COMMAND INFO [name ...] | COMMAND DOCS [name ...]
+type CommandCommand struct {
+	Subcommand string   // Empty for COMMAND without arguments
+	Names      []string // Commands to describe, every command if empty
+}
+
+// LATENCY LATEST | LATENCY HISTORY event | LATENCY RESET [event ...]
+type LatencyCommand struct {
+	Subcommand string
+	Events     []string
+}
+
+type DebugCommand struct {
+	Subcommand string
+	Latency    time.Duration // Injected latency, or the duration of DEBUG SLEEP
+	Rate       float64
+	Key        []byte // DEBUG OBJECT
+	Enabled    bool   // DEBUG SET-ACTIVE-EXPIRE
+}
+
+type DeleteCommand struct {
+	Keys   [][]byte
+	IfType string // Only delete keys holding this type when set
+}
+
+type DelIfEqCommand struct {
+	Key   []byte
+	Token []byte
+}
+
 type ExistsCommand struct {
 	Keys [][]byte
 }
@@ -60,6 +376,7 @@ type PingCommand struct {
 type ExpireCommand struct {
 	Key []byte
 	TTL time.Duration
+	At  time.Time // Absolute expiration given with EXPIREAT and PEXPIREAT, zero for a TTL
 }
 
 type PushCommand struct {
@@ -73,6 +390,295 @@ type PopCommand struct {
 	popAtFront bool
 }
 
+type BlockingPopCommand struct {
+	Keys       [][]byte
+	Timeout    time.Duration // Zero blocks forever
+	popAtFront bool
+}
+
+type MoveCommand struct {
+	Source      []byte
+	Destination []byte
+	popAtFront  bool
+	pushAtFront bool
+	blocking    bool
+	Timeout     time.Duration // Zero blocks forever, only used by BLMOVE
+}
+
+type HSetCommand struct {
+	Key   []byte
+	Pairs [][]byte // Alternating fields and values
+}
+
+type HSetNXCommand struct {
+	Key   []byte
+	Field []byte
+	Value []byte
+}
+
+type HMGetCommand struct {
+	Key    []byte
+	Fields [][]byte
+}
+
+type HLenCommand struct {
+	Key []byte
+}
+
+type HKeysCommand struct {
+	Key    []byte
+	values bool // Return the values instead of the fields, used by HVALS
+}
+
+type HGetCommand struct {
+	Key   []byte
+	Field []byte
+}
+
+type HDelCommand struct {
+	Key    []byte
+	Fields [][]byte
+}
+
+type HGetAllCommand struct {
+	Key []byte
+}
+
+type HExistsCommand struct {
+	Key   []byte
+	Field []byte
+}
+
+type HIncrByCommand struct {
+	Key        []byte
+	Field      []byte
+	Delta      int64
+	FloatDelta float64
+	isFloat    bool
+}
+
+type HExpireCommand struct {
+	Key    []byte
+	Fields [][]byte
+	TTL    time.Duration
+	At     time.Time // Absolute expiration given with HEXPIREAT and HPEXPIREAT, zero for a TTL
+}
+
+type HTTLCommand struct {
+	Key          []byte
+	Fields       [][]byte
+	milliseconds bool
+}
+
+type SAddCommand struct {
+	Key     []byte
+	Members [][]byte
+}
+
+type SRemCommand struct {
+	Key     []byte
+	Members [][]byte
+}
+
+type SIsMemberCommand struct {
+	Key     []byte
+	Members [][]byte
+	multi   bool // Reply with an array, used by SMISMEMBER
+}
+
+type SMembersCommand struct {
+	Key []byte
+}
+
+type SCardCommand struct {
+	Key []byte
+}
+
+type SScanCommand struct {
+	Key     []byte
+	Cursor  uint64
+	Pattern []byte // nil matches every member
+	Count   int
+}
+
+type ZAddCommand struct {
+	Key     []byte
+	Members []ScoredMember
+	Options ZAddOptions
+}
+
+type ZRemCommand struct {
+	Key     []byte
+	Members [][]byte
+}
+
+type ZScoreCommand struct {
+	Key    []byte
+	Member []byte
+}
+
+type ZCardCommand struct {
+	Key []byte
+}
+
+type ZRangeCommand struct {
+	Key        []byte
+	Start      int
+	Stop       int
+	WithScores bool
+	rev        bool
+}
+
+type ZRangeByCommand struct {
+	Key        []byte
+	Spec       ZRangeSpec // ScoreRange or LexRange
+	Options    ZRangeOptions
+	WithScores bool
+}
+
+type ZRemRangeByRankCommand struct {
+	Key   []byte
+	Start int
+	Stop  int
+}
+
+type ZRemRangeByScoreCommand struct {
+	Key   []byte
+	Range ScoreRange
+}
+
+type ZStoreCommand struct {
+	Destination []byte
+	Keys        [][]byte
+	Options     ZStoreOptions
+}
+
+type BitOpCommand struct {
+	Operation   BitOperation
+	Destination []byte
+	Keys        [][]byte
+}
+
+type BitFieldCommand struct {
+	Key []byte
+	Ops []BitFieldOp
+}
+
+type PFAddCommand struct {
+	Key      []byte
+	Elements [][]byte
+}
+
+type PFCountCommand struct {
+	Keys [][]byte
+}
+
+type PFMergeCommand struct {
+	Destination []byte
+	Keys        [][]byte
+}
+
+type XAddCommand struct {
+	Key     []byte
+	Fields  [][]byte
+	Options XAddOptions
+}
+
+type XLenCommand struct {
+	Key []byte
+}
+
+type XRangeCommand struct {
+	Key   []byte
+	Start StreamID
+	End   StreamID
+	Count int // Negative means no limit
+	rev   bool
+}
+
+type XReadCommand struct {
+	Keys     [][]byte
+	IDs      []StreamID
+	Count    int           // Negative means no limit
+	Timeout  time.Duration // Zero blocks forever
+	lastID   []bool        // The ID of each key was given as $, the last ID of the stream when the command runs
+	blocking bool
+}
+
+type XGroupCommand struct {
+	Subcommand string
+	Key        []byte
+	Group      []byte
+	Consumer   []byte
+	ID         StreamID
+	MkStream   bool
+	lastID     bool // The ID was given as $, the last ID of the stream when the command runs
+}
+
+type XReadGroupCommand struct {
+	Group    []byte
+	Consumer []byte
+	Keys     [][]byte
+	IDs      []StreamID
+	New      []bool        // The ID of each key was given as >, entries never delivered to the group
+	Count    int           // Negative means no limit
+	Timeout  time.Duration // Zero blocks forever
+	NoAck    bool
+	blocking bool
+}
+
+type XAckCommand struct {
+	Key   []byte
+	Group []byte
+	IDs   []StreamID
+}
+
+type XPendingCommand struct {
+	Key     []byte
+	Group   []byte
+	Options XPendingOptions
+	summary bool // No range was given
+}
+
+type XAutoClaimCommand struct {
+	Key     []byte
+	Group   []byte
+	Options XAutoClaimOptions
+}
+
+type GeoAddCommand struct {
+	Key     []byte
+	Members []GeoMember
+	Options ZAddOptions
+}
+
+type GeoDistCommand struct {
+	Key     []byte
+	Member1 []byte
+	Member2 []byte
+	Unit    float64 // Meters per unit of the reply
+}
+
+type GeoSearchCommand struct {
+	Key       []byte
+	Query     GeoQuery
+	Unit      float64 // Meters per unit of the shape and the distances in the reply
+	WithCoord bool
+	WithDist  bool
+	WithHash  bool
+}
+
+type FCallCommand struct {
+	Name     string
+	Keys     [][]byte
+	Args     [][]byte
+	ReadOnly bool // Called with FCALL_RO, only read-only functions are allowed
+}
+
+type FunctionCommand struct {
+	Subcommand string
+}
+
 type LLenCommand struct {
 	Key []byte
 }
@@ -83,6 +689,23 @@ type LRangeCommand struct {
 	End   int
 }
 
+// Parses the value following an EX or PX option at position i into a duration.
+func parseExpirationOption(cmdName, option string, elements []resp.RespBulkString, i int) (time.Duration, error) {
+	if i+1 >= len(elements) {
+		return 0, fmt.Errorf("%s command %s option requires an expiration time", cmdName, option)
+	}
+
+	ttl, ok := util.ParsePositiveInt(elements[i+1].Value)
+	if !ok {
+		return 0, fmt.Errorf("invalid expiration time for %s command", cmdName)
+	}
+
+	if option == "EX" {
+		return time.Duration(ttl) * time.Second, nil
+	}
+	return time.Duration(ttl) * time.Millisecond, nil
+}
+
 func parseSetCommand(arr resp.RespArray) (Command, error) {
 	if len(arr.Elements) < 3 {
 		return nil, fmt.Errorf("SET command requires at least 2 arguments")
@@ -118,26 +741,11 @@ func parseSetCommand(arr resp.RespArray) (Command, error) {
 					return nil, fmt.Errorf("SET command can only have one condition (NX or XX)")
 				}
 				command.condition = ConditionXX
-			case "EX":
-				if i+1 >= len(elements) {
-					return nil, fmt.Errorf("SET command EX option requires an expiration time")
-				}
-				expSec, ok := util.ParsePositiveInt(elements[i+1].Value)
-				if !ok {
-					return nil, fmt.Errorf("invalid expiration time for SET command")
-				}
-				expiration := time.Duration(expSec) * time.Second
-				command.expiration = &expiration
-				i++
-			case "PX":
-				if i+1 >= len(elements) {
-					return nil, fmt.Errorf("SET command PX option requires an expiration time")
-				}
-				expMs, ok := util.ParsePositiveInt(elements[i+1].Value)
-				if !ok {
-					return nil, fmt.Errorf("invalid expiration time for SET command")
+			case "EX", "PX":
+				expiration, err := parseExpirationOption("SET", option, elements, i)
+				if err != nil {
+					return nil, err
 				}
-				expiration := time.Duration(expMs) * time.Millisecond
 				command.expiration = &expiration
 				i++
 			default:
@@ -149,6 +757,65 @@ func parseSetCommand(arr resp.RespArray) (Command, error) {
 	return command, nil
 }
 
+func parseMGetCommand(arr resp.RespArray) (Command, error) {
+	if len(arr.Elements) < 2 {
+		return nil, fmt.Errorf("MGET command requires at least 1 argument")
+	}
+
+	keys := make([][]byte, len(arr.Elements)-1)
+	for i, elem := range arr.Elements[1:] {
+		key, ok := elem.(resp.RespBulkString)
+		if !ok {
+			return nil, fmt.Errorf("expected bulk strings for keys")
+		}
+		keys[i] = key.Value
+	}
+
+	return MGetCommand{
+		Keys: keys,
+	}, nil
+}
+
+func parseGetOrSetCommand(arr resp.RespArray) (Command, error) {
+	if len(arr.Elements) < 3 {
+		return nil, fmt.Errorf("GETORSET command requires at least 2 arguments")
+	}
+
+	elements := make([]resp.RespBulkString, len(arr.Elements))
+	for i, elem := range arr.Elements {
+		elem, ok := elem.(resp.RespBulkString)
+		if !ok {
+			return nil, fmt.Errorf("invalid GETORSET command format: expected bulk strings")
+		}
+		elements[i] = elem
+	}
+
+	command := GetOrSetCommand{
+		Key:   elements[1].Value,
+		Value: elements[2].Value,
+	}
+	for i := 3; i < len(elements); i++ {
+		option := string(elements[i].Value)
+
+		switch option {
+		case "EX", "PX":
+			if command.expiration != nil {
+				return nil, fmt.Errorf("GETORSET command can only have one expiration (EX or PX)")
+			}
+			expiration, err := parseExpirationOption("GETORSET", option, elements, i)
+			if err != nil {
+				return nil, err
+			}
+			command.expiration = &expiration
+			i++
+		default:
+			return nil, fmt.Errorf("unknown option for GETORSET command (%s)", option)
+		}
+	}
+
+	return command, nil
+}
+
 func parseGetCommand(arr resp.RespArray) (Command, error) {
 	if len(arr.Elements) != 2 {
 		return nil, fmt.Errorf("GET command requires exactly 1 argument")
@@ -196,17 +863,53 @@ func parseDeleteCommand(arr resp.RespArray) (Command, error) {
 		keys[i] = key.Value
 	}
 
-	return DeleteCommand{
+	command := DeleteCommand{
 		Keys: keys,
-	}, nil
-}
+	}
 
-func parseExistsCommand(arr resp.RespArray) (Command, error) {
-	if len(arr.Elements) < 2 {
-		return nil, fmt.Errorf("EXISTS command requires at least 1 argument")
+	// Check for the trailing IFTYPE option. A key named IFTYPE second to last is always read as the option
+	if len(keys) >= 3 && strings.EqualFold(string(keys[len(keys)-2]), "IFTYPE") {
+		keyType := strings.ToLower(string(keys[len(keys)-1]))
+		switch keyType {
+		case "string", "list", "hash", "set", "zset", "stream":
+		default:
+			return nil, fmt.Errorf("invalid type for DEL command IFTYPE option (%s)", keyType)
+		}
+
+		command.Keys = keys[:len(keys)-2]
+		command.IfType = keyType
 	}
 
-	keys := make([][]byte, len(arr.Elements)-1)
+	return command, nil
+}
+
+func parseDelIfEqCommand(arr resp.RespArray) (Command, error) {
+	if len(arr.Elements) != 3 {
+		return nil, fmt.Errorf("DELIFEQ command requires exactly 2 arguments")
+	}
+
+	key, ok := arr.Elements[1].(resp.RespBulkString)
+	if !ok {
+		return nil, fmt.Errorf("invalid DELIFEQ command format: expected bulk string for key")
+	}
+
+	token, ok := arr.Elements[2].(resp.RespBulkString)
+	if !ok {
+		return nil, fmt.Errorf("invalid DELIFEQ command format: expected bulk string for token")
+	}
+
+	return DelIfEqCommand{
+		Key:   key.Value,
+		Token: token.Value,
+	}, nil
+}
+
+func parseExistsCommand(arr resp.RespArray) (Command, error) {
+	if len(arr.Elements) < 2 {
+		return nil, fmt.Errorf("EXISTS command requires at least 1 argument")
+	}
+
+	keys := make([][]byte, len(arr.Elements)-1)
 	for i, elem := range arr.Elements[1:] {
 		key, ok := elem.(resp.RespBulkString)
 		if !ok {
@@ -221,36 +924,29 @@ func parseExistsCommand(arr resp.RespArray) (Command, error) {
 }
 
 func parseExpireCommand(arr resp.RespArray) (Command, error) {
-	if len(arr.Elements) != 3 {
-		return nil, fmt.Errorf("EXPIRE/PEXPIRE command requires exactly 2 arguments")
-	}
-
-	key, ok := arr.Elements[1].(resp.RespBulkString)
-	if !ok {
-		return nil, fmt.Errorf("invalid EXPIRE/PEXPIRE command format: expected bulk string for key")
+	args, err := parseArgs(arr, 2, 2)
+	if err != nil {
+		return nil, err
 	}
 
-	ttl, ok := arr.Elements[2].(resp.RespBulkString)
+	ttlInt, ok := util.ParsePositiveInt(args[1])
 	if !ok {
-		return nil, fmt.Errorf("invalid EXPIRE/PEXPIRE command format: expected bulk string for TTL")
-	}
-
-	ttlInt, err := util.ParsePositiveInt(ttl.Value)
-	if !err {
 		return nil, fmt.Errorf("invalid TTL value")
 	}
 
-	var duration time.Duration
-	if string(arr.Elements[0].(resp.RespBulkString).Value) == "EXPIRE" {
-		duration = time.Duration(ttlInt) * time.Second
-	} else {
-		duration = time.Duration(ttlInt) * time.Millisecond
+	cmd := ExpireCommand{Key: args[0]}
+	switch CommandName(arr.Elements[0].(resp.RespBulkString).Value) {
+	case CmdExpire:
+		cmd.TTL = time.Duration(ttlInt) * time.Second
+	case CmdPExpire:
+		cmd.TTL = time.Duration(ttlInt) * time.Millisecond
+	case CmdExpireAt:
+		cmd.At = time.Unix(int64(ttlInt), 0)
+	case CmdPExpireAt:
+		cmd.At = time.UnixMilli(int64(ttlInt))
 	}
 
-	return ExpireCommand{
-		Key: key.Value,
-		TTL: duration,
-	}, nil
+	return cmd, nil
 }
 
 func parsePushCommand(arr resp.RespArray) (Command, error) {
@@ -308,6 +1004,102 @@ func parsePopCommand(arr resp.RespArray) (Command, error) {
 	return cmd, nil
 }
 
+// Parses a blocking command timeout in seconds, fractions are allowed and zero means block forever.
+func parseBlockingTimeout(s []byte) (time.Duration, bool) {
+	seconds, err := strconv.ParseFloat(string(s), 64)
+	if err != nil || seconds < 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+func parseBlockingPopCommand(arr resp.RespArray) (Command, error) {
+	if len(arr.Elements) < 3 {
+		return nil, fmt.Errorf("BLPOP/BRPOP command requires at least 2 arguments")
+	}
+
+	args := make([][]byte, len(arr.Elements)-1)
+	for i, elem := range arr.Elements[1:] {
+		arg, ok := elem.(resp.RespBulkString)
+		if !ok {
+			return nil, fmt.Errorf("invalid BLPOP/BRPOP command format: expected bulk strings for arguments")
+		}
+		args[i] = arg.Value
+	}
+
+	timeout, ok := parseBlockingTimeout(args[len(args)-1])
+	if !ok {
+		return nil, fmt.Errorf("timeout is not a valid non-negative number of seconds")
+	}
+
+	return BlockingPopCommand{
+		Keys:       args[:len(args)-1],
+		Timeout:    timeout,
+		popAtFront: string(arr.Elements[0].(resp.RespBulkString).Value) == "BLPOP",
+	}, nil
+}
+
+// Parses a LEFT or RIGHT list direction, returning true for LEFT.
+func parseListDirection(s []byte) (bool, bool) {
+	switch string(s) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+func parseMoveCommand(arr resp.RespArray) (Command, error) {
+	name := string(arr.Elements[0].(resp.RespBulkString).Value)
+	blocking := name == string(CmdBLMove)
+
+	expected := 5
+	if blocking {
+		expected = 6
+	}
+	if len(arr.Elements) != expected {
+		return nil, fmt.Errorf("%s command requires exactly %d arguments", name, expected-1)
+	}
+
+	args := make([][]byte, len(arr.Elements)-1)
+	for i, elem := range arr.Elements[1:] {
+		arg, ok := elem.(resp.RespBulkString)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s command format: expected bulk strings for arguments", name)
+		}
+		args[i] = arg.Value
+	}
+
+	popAtFront, ok := parseListDirection(args[2])
+	if !ok {
+		return nil, fmt.Errorf("invalid source direction for %s command, expected LEFT or RIGHT", name)
+	}
+
+	pushAtFront, ok := parseListDirection(args[3])
+	if !ok {
+		return nil, fmt.Errorf("invalid destination direction for %s command, expected LEFT or RIGHT", name)
+	}
+
+	cmd := MoveCommand{
+		Source:      args[0],
+		Destination: args[1],
+		popAtFront:  popAtFront,
+		pushAtFront: pushAtFront,
+		blocking:    blocking,
+	}
+
+	if blocking {
+		cmd.Timeout, ok = parseBlockingTimeout(args[4])
+		if !ok {
+			return nil, fmt.Errorf("timeout is not a valid non-negative number of seconds")
+		}
+	}
+
+	return cmd, nil
+}
+
 func parseLLenCommand(arr resp.RespArray) (Command, error) {
 	if len(arr.Elements) != 2 {
 		return nil, fmt.Errorf("LLEN command requires exactly 1 argument")
@@ -355,6 +1147,2475 @@ func parseLRangeCommand(arr resp.RespArray) (Command, error) {
 	}, nil
 }
 
+// Extracts the arguments of a command as bulk strings, checking the argument count is within [min, max].
+// A negative max allows any number of arguments.
+func parseArgs(arr resp.RespArray, min, max int) ([][]byte, error) {
+	name := string(arr.Elements[0].(resp.RespBulkString).Value)
+	count := len(arr.Elements) - 1
+
+	if min == max && count != min {
+		return nil, fmt.Errorf("%s command requires exactly %d argument%s", name, min, plural(min))
+	}
+	if count < min {
+		return nil, fmt.Errorf("%s command requires at least %d argument%s", name, min, plural(min))
+	}
+	if max >= 0 && count > max {
+		return nil, fmt.Errorf("%s command accepts at most %d argument%s", name, max, plural(max))
+	}
+
+	args := make([][]byte, count)
+	for i, elem := range arr.Elements[1:] {
+		arg, ok := elem.(resp.RespBulkString)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s command format: expected bulk strings for arguments", name)
+		}
+		args[i] = arg.Value
+	}
+
+	return args, nil
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
+
+func parseHSetCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 3, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args)%2 != 1 {
+		return nil, fmt.Errorf("HSET command requires field and value pairs")
+	}
+
+	return HSetCommand{
+		Key:   args[0],
+		Pairs: args[1:],
+	}, nil
+}
+
+func parseHSetNXCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 3, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	return HSetNXCommand{
+		Key:   args[0],
+		Field: args[1],
+		Value: args[2],
+	}, nil
+}
+
+func parseHMGetCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 2, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	return HMGetCommand{
+		Key:    args[0],
+		Fields: args[1:],
+	}, nil
+}
+
+func parseHLenCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return HLenCommand{
+		Key: args[0],
+	}, nil
+}
+
+func parseHKeysCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return HKeysCommand{
+		Key:    args[0],
+		values: string(arr.Elements[0].(resp.RespBulkString).Value) == string(CmdHVals),
+	}, nil
+}
+
+func parseHGetCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	return HGetCommand{
+		Key:   args[0],
+		Field: args[1],
+	}, nil
+}
+
+func parseHDelCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 2, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	return HDelCommand{
+		Key:    args[0],
+		Fields: args[1:],
+	}, nil
+}
+
+func parseHGetAllCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return HGetAllCommand{
+		Key: args[0],
+	}, nil
+}
+
+func parseHExistsCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	return HExistsCommand{
+		Key:   args[0],
+		Field: args[1],
+	}, nil
+}
+
+func parseHIncrByCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 3, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := HIncrByCommand{
+		Key:     args[0],
+		Field:   args[1],
+		isFloat: string(arr.Elements[0].(resp.RespBulkString).Value) == string(CmdHIncrByFloat),
+	}
+
+	if cmd.isFloat {
+		cmd.FloatDelta, err = strconv.ParseFloat(string(args[2]), 64)
+		if err != nil || math.IsNaN(cmd.FloatDelta) || math.IsInf(cmd.FloatDelta, 0) {
+			return nil, fmt.Errorf("increment is not a valid float")
+		}
+	} else {
+		cmd.Delta, err = strconv.ParseInt(string(args[2]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("increment is not an integer or out of range")
+		}
+	}
+
+	return cmd, nil
+}
+
+// Parses the trailing FIELDS numfields field [field ...] arguments of the hash field expiration commands.
+func parseFieldsArgument(name string, args [][]byte) ([][]byte, error) {
+	if len(args) < 3 || string(args[0]) != "FIELDS" {
+		return nil, fmt.Errorf("%s command requires the FIELDS numfields field [field ...] arguments", name)
+	}
+
+	numFields, ok := util.ParsePositiveInt(args[1])
+	if !ok || numFields == 0 {
+		return nil, fmt.Errorf("invalid numfields value for %s command", name)
+	}
+
+	if numFields != len(args)-2 {
+		return nil, fmt.Errorf("numfields value for %s command must match the number of fields", name)
+	}
+
+	return args[2:], nil
+}
+
+func parseHExpireCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 5, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	name := string(arr.Elements[0].(resp.RespBulkString).Value)
+	ttl, ok := util.ParsePositiveInt(args[1])
+	if !ok {
+		return nil, fmt.Errorf("invalid TTL value")
+	}
+
+	fields, err := parseFieldsArgument(name, args[2:])
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := HExpireCommand{Key: args[0], Fields: fields}
+	switch CommandName(name) {
+	case CmdHExpire:
+		cmd.TTL = time.Duration(ttl) * time.Second
+	case CmdHPExpire:
+		cmd.TTL = time.Duration(ttl) * time.Millisecond
+	case CmdHExpireAt:
+		cmd.At = time.Unix(int64(ttl), 0)
+	case CmdHPExpireAt:
+		cmd.At = time.UnixMilli(int64(ttl))
+	}
+
+	return cmd, nil
+}
+
+func parseHTTLCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 4, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	name := string(arr.Elements[0].(resp.RespBulkString).Value)
+	fields, err := parseFieldsArgument(name, args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	return HTTLCommand{
+		Key:          args[0],
+		Fields:       fields,
+		milliseconds: name == string(CmdHPTTL),
+	}, nil
+}
+
+func parseSAddCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 2, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	return SAddCommand{
+		Key:     args[0],
+		Members: args[1:],
+	}, nil
+}
+
+func parseSRemCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 2, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	return SRemCommand{
+		Key:     args[0],
+		Members: args[1:],
+	}, nil
+}
+
+func parseSIsMemberCommand(arr resp.RespArray) (Command, error) {
+	multi := string(arr.Elements[0].(resp.RespBulkString).Value) == string(CmdSMIsMember)
+
+	maxArgs := 2
+	if multi {
+		maxArgs = -1
+	}
+
+	args, err := parseArgs(arr, 2, maxArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	return SIsMemberCommand{
+		Key:     args[0],
+		Members: args[1:],
+		multi:   multi,
+	}, nil
+}
+
+func parseSMembersCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return SMembersCommand{
+		Key: args[0],
+	}, nil
+}
+
+func parseSCardCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return SCardCommand{
+		Key: args[0],
+	}, nil
+}
+
+func parseSScanCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 2, 6)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	cmd := SScanCommand{
+		Key:    args[0],
+		Cursor: cursor,
+		Count:  10,
+	}
+
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, fmt.Errorf("missing value for SSCAN command option %s", args[i])
+		}
+
+		switch string(args[i]) {
+		case "MATCH":
+			cmd.Pattern = args[i+1]
+		case "COUNT":
+			count, ok := util.ParsePositiveInt(args[i+1])
+			if !ok || count == 0 {
+				return nil, fmt.Errorf("invalid COUNT value for SSCAN command")
+			}
+			cmd.Count = count
+		default:
+			return nil, fmt.Errorf("invalid option for SSCAN command (%s)", args[i])
+		}
+	}
+
+	return cmd, nil
+}
+
+func parseZAddCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 3, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := ZAddCommand{
+		Key: args[0],
+	}
+
+	// Options come before the score/member pairs
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch string(args[i]) {
+		case "NX":
+			cmd.Options.Condition = ConditionNX
+		case "XX":
+			cmd.Options.Condition = ConditionXX
+		case "GT":
+			cmd.Options.GT = true
+		case "LT":
+			cmd.Options.LT = true
+		case "CH":
+			cmd.Options.CH = true
+		default:
+			break options
+		}
+	}
+
+	if cmd.Options.GT && cmd.Options.LT {
+		return nil, fmt.Errorf("GT and LT options for ZADD command are mutually exclusive")
+	}
+	if cmd.Options.Condition == ConditionNX && (cmd.Options.GT || cmd.Options.LT) {
+		return nil, fmt.Errorf("NX option for ZADD command cannot be combined with GT or LT")
+	}
+
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("ZADD command requires score and member pairs")
+	}
+
+	cmd.Members = make([]ScoredMember, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, ok := util.ParseFloat(pairs[j])
+		if !ok {
+			return nil, fmt.Errorf("score is not a valid float")
+		}
+		cmd.Members = append(cmd.Members, ScoredMember{Member: pairs[j+1], Score: score})
+	}
+
+	return cmd, nil
+}
+
+func parseZRemCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 2, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	return ZRemCommand{
+		Key:     args[0],
+		Members: args[1:],
+	}, nil
+}
+
+func parseZScoreCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	return ZScoreCommand{
+		Key:    args[0],
+		Member: args[1],
+	}, nil
+}
+
+func parseZCardCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return ZCardCommand{
+		Key: args[0],
+	}, nil
+}
+
+// Parses the trailing WITHSCORES option of the sorted set range commands.
+func parseWithScores(name string, args [][]byte) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	if len(args) > 1 || string(args[0]) != "WITHSCORES" {
+		return false, fmt.Errorf("invalid option for %s command (%s)", name, args[0])
+	}
+	return true, nil
+}
+
+// Handles ZRANGE and ZREVRANGE.
+func parseZRangeCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 3, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	name := string(arr.Elements[0].(resp.RespBulkString).Value)
+
+	start, ok := util.ParseInt(args[1])
+	if !ok {
+		return nil, fmt.Errorf("invalid start index for %s command", name)
+	}
+
+	stop, ok := util.ParseInt(args[2])
+	if !ok {
+		return nil, fmt.Errorf("invalid stop index for %s command", name)
+	}
+
+	withScores, err := parseWithScores(name, args[3:])
+	if err != nil {
+		return nil, err
+	}
+
+	return ZRangeCommand{
+		Key:        args[0],
+		Start:      start,
+		Stop:       stop,
+		WithScores: withScores,
+		rev:        name == string(CmdZRevRange),
+	}, nil
+}
+
+// Parses a score range bound, prefixed with ( when exclusive. Accepts -inf and +inf.
+func parseScoreBound(b []byte) (float64, bool, bool) {
+	exclusive := len(b) > 0 && b[0] == '('
+	if exclusive {
+		b = b[1:]
+	}
+
+	score, ok := util.ParseFloat(b)
+	return score, exclusive, ok
+}
+
+// Parses a lexicographic range bound: - and + for the infinities, or a value prefixed with [ or (.
+func parseLexBound(b []byte) (LexBound, bool) {
+	switch {
+	case string(b) == "-":
+		return LexBound{Infinity: -1}, true
+	case string(b) == "+":
+		return LexBound{Infinity: 1}, true
+	case len(b) > 0 && b[0] == '[':
+		return LexBound{Value: string(b[1:])}, true
+	case len(b) > 0 && b[0] == '(':
+		return LexBound{Value: string(b[1:]), Exclusive: true}, true
+	default:
+		return LexBound{}, false
+	}
+}
+
+// Parses the min and max bounds of a score range.
+func parseScoreRange(minArg, maxArg []byte) (ScoreRange, error) {
+	var r ScoreRange
+	var okMin, okMax bool
+	r.Min, r.MinExclusive, okMin = parseScoreBound(minArg)
+	r.Max, r.MaxExclusive, okMax = parseScoreBound(maxArg)
+	if !okMin || !okMax {
+		return ScoreRange{}, fmt.Errorf("min or max is not a float")
+	}
+	return r, nil
+}
+
+// Handles ZRANGEBYSCORE, ZREVRANGEBYSCORE, ZRANGEBYLEX and ZREVRANGEBYLEX.
+// The reverse variants take the max bound before the min bound.
+func parseZRangeByCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 3, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	name := CommandName(arr.Elements[0].(resp.RespBulkString).Value)
+	lex := name == CmdZRangeByLex || name == CmdZRevRangeByLex
+	cmd := ZRangeByCommand{
+		Key: args[0],
+		Options: ZRangeOptions{
+			Rev:   name == CmdZRevRangeByScore || name == CmdZRevRangeByLex,
+			Count: -1,
+		},
+	}
+
+	minArg, maxArg := args[1], args[2]
+	if cmd.Options.Rev {
+		minArg, maxArg = maxArg, minArg
+	}
+
+	if lex {
+		minBound, okMin := parseLexBound(minArg)
+		maxBound, okMax := parseLexBound(maxArg)
+		if !okMin || !okMax {
+			return nil, fmt.Errorf("min or max not valid string range item")
+		}
+		cmd.Spec = LexRange{Min: minBound, Max: maxBound}
+	} else {
+		cmd.Spec, err = parseScoreRange(minArg, maxArg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	options := args[3:]
+	for i := 0; i < len(options); i++ {
+		switch {
+		case string(options[i]) == "WITHSCORES" && !lex:
+			cmd.WithScores = true
+		case string(options[i]) == "LIMIT":
+			if i+2 >= len(options) {
+				return nil, fmt.Errorf("LIMIT option for %s command requires an offset and a count", name)
+			}
+
+			offset, okOffset := util.ParseInt(options[i+1])
+			count, okCount := util.ParseInt(options[i+2])
+			if !okOffset || !okCount {
+				return nil, fmt.Errorf("LIMIT offset and count for %s command must be integers", name)
+			}
+
+			cmd.Options.Offset = offset
+			cmd.Options.Count = count
+			i += 2
+		default:
+			return nil, fmt.Errorf("invalid option for %s command (%s)", name, options[i])
+		}
+	}
+
+	return cmd, nil
+}
+
+func parseZRemRangeByRankCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 3, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	start, ok := util.ParseInt(args[1])
+	if !ok {
+		return nil, fmt.Errorf("invalid start index for ZREMRANGEBYRANK command")
+	}
+
+	stop, ok := util.ParseInt(args[2])
+	if !ok {
+		return nil, fmt.Errorf("invalid stop index for ZREMRANGEBYRANK command")
+	}
+
+	return ZRemRangeByRankCommand{
+		Key:   args[0],
+		Start: start,
+		Stop:  stop,
+	}, nil
+}
+
+func parseZRemRangeByScoreCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 3, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := parseScoreRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	return ZRemRangeByScoreCommand{
+		Key:   args[0],
+		Range: r,
+	}, nil
+}
+
+// Handles ZUNIONSTORE, ZINTERSTORE and ZDIFFSTORE. Only the union and intersection accept WEIGHTS and AGGREGATE.
+func parseZStoreCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 3, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	name := CommandName(arr.Elements[0].(resp.RespBulkString).Value)
+	numKeys, ok := util.ParseInt(args[1])
+	if !ok || numKeys < 1 {
+		return nil, fmt.Errorf("numkeys for %s command must be a positive integer", name)
+	}
+	if numKeys > len(args)-2 {
+		return nil, fmt.Errorf("%s command requires %d key%s", name, numKeys, plural(numKeys))
+	}
+
+	cmd := ZStoreCommand{
+		Destination: args[0],
+		Keys:        args[2 : 2+numKeys],
+	}
+
+	switch name {
+	case CmdZInterStore:
+		cmd.Options.Op = ZStoreInter
+	case CmdZDiffStore:
+		cmd.Options.Op = ZStoreDiff
+	}
+
+	options := args[2+numKeys:]
+	for i := 0; i < len(options); i++ {
+		option := string(options[i])
+		if cmd.Options.Op == ZStoreDiff || (option != "WEIGHTS" && option != "AGGREGATE") {
+			return nil, fmt.Errorf("invalid option for %s command (%s)", name, option)
+		}
+
+		if option == "WEIGHTS" {
+			if len(options)-i-1 < numKeys {
+				return nil, fmt.Errorf("WEIGHTS option for %s command requires one weight per key", name)
+			}
+
+			cmd.Options.Weights = make([]float64, numKeys)
+			for j := range numKeys {
+				i++
+				if cmd.Options.Weights[j], ok = util.ParseFloat(options[i]); !ok {
+					return nil, fmt.Errorf("weight value is not a float")
+				}
+			}
+			continue
+		}
+
+		if i+1 >= len(options) {
+			return nil, fmt.Errorf("AGGREGATE option for %s command requires a value", name)
+		}
+		i++
+		switch string(options[i]) {
+		case "SUM":
+			cmd.Options.Aggregate = AggregateSum
+		case "MIN":
+			cmd.Options.Aggregate = AggregateMin
+		case "MAX":
+			cmd.Options.Aggregate = AggregateMax
+		default:
+			return nil, fmt.Errorf("AGGREGATE option for %s command must be SUM, MIN or MAX", name)
+		}
+	}
+
+	return cmd, nil
+}
+
+func parseBitOpCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 3, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := BitOpCommand{
+		Destination: args[1],
+		Keys:        args[2:],
+	}
+
+	switch string(args[0]) {
+	case "AND":
+		cmd.Operation = BitAnd
+	case "OR":
+		cmd.Operation = BitOr
+	case "XOR":
+		cmd.Operation = BitXor
+	case "NOT":
+		cmd.Operation = BitNot
+		if len(cmd.Keys) != 1 {
+			return nil, fmt.Errorf("BITOP NOT must be called with a single source key")
+		}
+	default:
+		return nil, fmt.Errorf("invalid operation for BITOP command (%s), must be AND, OR, XOR or NOT", args[0])
+	}
+
+	return cmd, nil
+}
+
+// Parses a BITFIELD type such as i8 or u16. u64 is not supported since the values are returned as int64.
+func parseBitFieldEncoding(b []byte) (BitFieldEncoding, bool) {
+	if len(b) < 2 || (b[0] != 'i' && b[0] != 'u') {
+		return BitFieldEncoding{}, false
+	}
+
+	bits, ok := util.ParseInt(b[1:])
+	signed := b[0] == 'i'
+	if !ok || bits < 1 || bits > 64 || (!signed && bits == 64) {
+		return BitFieldEncoding{}, false
+	}
+
+	return BitFieldEncoding{Signed: signed, Bits: bits}, true
+}
+
+// Parses a BITFIELD offset in bits, or in multiples of the type size when prefixed with #.
+func parseBitFieldOffset(b []byte, enc BitFieldEncoding) (uint64, bool) {
+	multiply := len(b) > 0 && b[0] == '#'
+	if multiply {
+		b = b[1:]
+	}
+
+	offset, ok := util.ParseInt(b)
+	if !ok || offset < 0 || offset > maxBitOffset {
+		return 0, false
+	}
+
+	if multiply {
+		offset *= enc.Bits
+	}
+	if offset > maxBitOffset-enc.Bits+1 {
+		return 0, false
+	}
+
+	return uint64(offset), true
+}
+
+func parseBitFieldCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := BitFieldCommand{
+		Key: args[0],
+	}
+
+	overflow := OverflowWrap
+	for i := 1; i < len(args); {
+		subcommand := string(args[i])
+
+		if subcommand == "OVERFLOW" {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("OVERFLOW option for BITFIELD command requires WRAP, SAT or FAIL")
+			}
+
+			switch string(args[i+1]) {
+			case "WRAP":
+				overflow = OverflowWrap
+			case "SAT":
+				overflow = OverflowSat
+			case "FAIL":
+				overflow = OverflowFail
+			default:
+				return nil, fmt.Errorf("invalid OVERFLOW type for BITFIELD command (%s)", args[i+1])
+			}
+			i += 2
+			continue
+		}
+
+		op := BitFieldOp{Overflow: overflow}
+		argCount := 3
+		switch subcommand {
+		case "GET":
+			op.Kind = BitFieldGet
+			argCount = 2
+		case "SET":
+			op.Kind = BitFieldSet
+		case "INCRBY":
+			op.Kind = BitFieldIncrBy
+		default:
+			return nil, fmt.Errorf("invalid subcommand for BITFIELD command (%s)", subcommand)
+		}
+
+		if i+argCount >= len(args) {
+			return nil, fmt.Errorf("BITFIELD %s requires %d arguments", subcommand, argCount)
+		}
+
+		var ok bool
+		op.Encoding, ok = parseBitFieldEncoding(args[i+1])
+		if !ok {
+			return nil, fmt.Errorf("invalid bitfield type, use i1 to i64 or u1 to u63")
+		}
+
+		op.Offset, ok = parseBitFieldOffset(args[i+2], op.Encoding)
+		if !ok {
+			return nil, fmt.Errorf("bit offset is not an integer or out of range")
+		}
+
+		if op.Kind != BitFieldGet {
+			value, err := strconv.ParseInt(string(args[i+3]), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("value for BITFIELD %s is not an integer or out of range", subcommand)
+			}
+			op.Value = value
+		}
+
+		cmd.Ops = append(cmd.Ops, op)
+		i += argCount + 1
+	}
+
+	return cmd, nil
+}
+
+func parsePFAddCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	return PFAddCommand{
+		Key:      args[0],
+		Elements: args[1:],
+	}, nil
+}
+
+func parsePFCountCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	return PFCountCommand{
+		Keys: args,
+	}, nil
+}
+
+func parsePFMergeCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	return PFMergeCommand{
+		Destination: args[0],
+		Keys:        args[1:],
+	}, nil
+}
+
+// Parses a stream ID in the form ms-seq or ms, in which case seq defaults to defaultSeq.
+func parseStreamID(b []byte, defaultSeq uint64) (StreamID, bool) {
+	msPart, seqPart, hasSeq := strings.Cut(string(b), "-")
+
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, false
+	}
+	if !hasSeq {
+		return StreamID{Ms: ms, Seq: defaultSeq}, true
+	}
+
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return StreamID{}, false
+	}
+	return StreamID{Ms: ms, Seq: seq}, true
+}
+
+func parseXAddCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 4, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := XAddCommand{
+		Key:     args[0],
+		Options: XAddOptions{MaxLen: -1},
+	}
+
+	// Options come before the ID
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch string(args[i]) {
+		case "NOMKSTREAM":
+			cmd.Options.NoMkStream = true
+		case "MAXLEN":
+			// Trimming is always exact, ~ is accepted for compatibility
+			if i+1 < len(args) && (string(args[i+1]) == "=" || string(args[i+1]) == "~") {
+				i++
+			}
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("MAXLEN option for XADD command requires a value")
+			}
+
+			maxLen, ok := util.ParseInt(args[i+1])
+			if !ok || maxLen < 0 {
+				return nil, fmt.Errorf("MAXLEN for XADD command must be a non-negative integer")
+			}
+			cmd.Options.MaxLen = maxLen
+			i++
+		default:
+			break options
+		}
+	}
+
+	if i >= len(args) {
+		return nil, fmt.Errorf("XADD command requires an ID")
+	}
+
+	id := args[i]
+	switch {
+	case string(id) == "*":
+		cmd.Options.AutoID = true
+	case len(id) > 2 && string(id[len(id)-2:]) == "-*":
+		ms, err := strconv.ParseUint(string(id[:len(id)-2]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stream ID for XADD command")
+		}
+		cmd.Options.ID = StreamID{Ms: ms}
+		cmd.Options.AutoSeq = true
+	default:
+		var ok bool
+		cmd.Options.ID, ok = parseStreamID(id, 0)
+		if !ok {
+			return nil, fmt.Errorf("invalid stream ID for XADD command")
+		}
+	}
+
+	cmd.Fields = args[i+1:]
+	if len(cmd.Fields) == 0 || len(cmd.Fields)%2 != 0 {
+		return nil, fmt.Errorf("XADD command requires field and value pairs")
+	}
+
+	return cmd, nil
+}
+
+func parseXLenCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return XLenCommand{
+		Key: args[0],
+	}, nil
+}
+
+// Parses a stream range bound: - and + for the smallest and largest IDs, or an ID optionally prefixed with ( when exclusive.
+// A bound without a sequence number covers the whole millisecond. The second result is true if an exclusive bound
+// excludes every ID, e.g. (0-0 as the end of a range.
+func parseStreamRangeBound(b []byte, isEnd bool) (StreamID, bool, bool) {
+	switch string(b) {
+	case "-":
+		return minStreamID, false, true
+	case "+":
+		return maxStreamID, false, true
+	}
+
+	exclusive := len(b) > 0 && b[0] == '('
+	if exclusive {
+		b = b[1:]
+	}
+
+	var defaultSeq uint64 = 0
+	if isEnd {
+		defaultSeq = maxStreamID.Seq
+	}
+
+	id, ok := parseStreamID(b, defaultSeq)
+	if !ok || !exclusive {
+		return id, false, ok
+	}
+
+	var inRange bool
+	if isEnd {
+		id, inRange = id.prev()
+	} else {
+		id, inRange = id.next()
+	}
+	return id, !inRange, true
+}
+
+// Parses the COUNT option of the stream read commands.
+func parseStreamCount(name string, args [][]byte, i int) (int, error) {
+	if i+1 >= len(args) {
+		return 0, fmt.Errorf("COUNT option for %s command requires a value", name)
+	}
+
+	count, ok := util.ParseInt(args[i+1])
+	if !ok || count < 0 {
+		return 0, fmt.Errorf("COUNT for %s command must be a non-negative integer", name)
+	}
+	return count, nil
+}
+
+// Handles XRANGE and XREVRANGE. XREVRANGE takes the end bound before the start bound.
+func parseXRangeCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 3, 5)
+	if err != nil {
+		return nil, err
+	}
+
+	name := string(arr.Elements[0].(resp.RespBulkString).Value)
+	cmd := XRangeCommand{
+		Key:   args[0],
+		Count: -1,
+		rev:   name == string(CmdXRevRange),
+	}
+
+	startArg, endArg := args[1], args[2]
+	if cmd.rev {
+		startArg, endArg = endArg, startArg
+	}
+
+	start, emptyStart, okStart := parseStreamRangeBound(startArg, false)
+	end, emptyEnd, okEnd := parseStreamRangeBound(endArg, true)
+	if !okStart || !okEnd {
+		return nil, fmt.Errorf("invalid stream ID for %s command", name)
+	}
+
+	cmd.Start, cmd.End = start, end
+	if emptyStart || emptyEnd {
+		// An inverted range matches no entries
+		cmd.Start, cmd.End = maxStreamID, minStreamID
+	}
+
+	if len(args) > 3 {
+		if string(args[3]) != "COUNT" {
+			return nil, fmt.Errorf("invalid option for %s command (%s)", name, args[3])
+		}
+
+		cmd.Count, err = parseStreamCount(name, args, 3)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return cmd, nil
+}
+
+func parseXReadCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 3, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := XReadCommand{
+		Count: -1,
+	}
+
+	i := 0
+	for ; i < len(args) && string(args[i]) != "STREAMS"; i += 2 {
+		switch string(args[i]) {
+		case "COUNT":
+			cmd.Count, err = parseStreamCount("XREAD", args, i)
+			if err != nil {
+				return nil, err
+			}
+
+			// COUNT 0 means no limit for XREAD
+			if cmd.Count == 0 {
+				cmd.Count = -1
+			}
+		case "BLOCK":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("BLOCK option for XREAD command requires a timeout")
+			}
+
+			ms, ok := util.ParseInt(args[i+1])
+			if !ok || ms < 0 {
+				return nil, fmt.Errorf("timeout for XREAD command must be a non-negative integer")
+			}
+			cmd.Timeout = time.Duration(ms) * time.Millisecond
+			cmd.blocking = true
+		default:
+			return nil, fmt.Errorf("invalid option for XREAD command (%s)", args[i])
+		}
+	}
+
+	// Keys are followed by one ID per key
+	streams := args[min(i+1, len(args)):]
+	if i >= len(args) || len(streams) == 0 || len(streams)%2 != 0 {
+		return nil, fmt.Errorf("XREAD command requires STREAMS followed by keys and one ID per key")
+	}
+
+	numKeys := len(streams) / 2
+	cmd.Keys = streams[:numKeys]
+	cmd.IDs = make([]StreamID, numKeys)
+	cmd.lastID = make([]bool, numKeys)
+	for j, id := range streams[numKeys:] {
+		if string(id) == "$" {
+			cmd.lastID[j] = true
+			continue
+		}
+
+		var ok bool
+		cmd.IDs[j], ok = parseStreamID(id, 0)
+		if !ok {
+			return nil, fmt.Errorf("invalid stream ID for XREAD command")
+		}
+	}
+
+	return cmd, nil
+}
+
+func parseXGroupCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := XGroupCommand{
+		Subcommand: strings.ToUpper(string(args[0])),
+	}
+	args = args[1:]
+
+	switch cmd.Subcommand {
+	case "CREATE", "SETID":
+		// XGROUP CREATE key group id|$ [MKSTREAM], XGROUP SETID key group id|$
+		maxArgs := 3
+		if cmd.Subcommand == "CREATE" {
+			maxArgs = 4
+		}
+		if len(args) < 3 || len(args) > maxArgs {
+			return nil, fmt.Errorf("wrong number of arguments for XGROUP %s", cmd.Subcommand)
+		}
+
+		if string(args[2]) == "$" {
+			cmd.lastID = true
+		} else {
+			var ok bool
+			cmd.ID, ok = parseStreamID(args[2], 0)
+			if !ok {
+				return nil, fmt.Errorf("invalid stream ID for XGROUP %s", cmd.Subcommand)
+			}
+		}
+
+		if len(args) == 4 {
+			if string(args[3]) != "MKSTREAM" {
+				return nil, fmt.Errorf("invalid option for XGROUP CREATE (%s)", args[3])
+			}
+			cmd.MkStream = true
+		}
+	case "DESTROY":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("wrong number of arguments for XGROUP DESTROY")
+		}
+	case "CREATECONSUMER", "DELCONSUMER":
+		if len(args) != 3 {
+			return nil, fmt.Errorf("wrong number of arguments for XGROUP %s", cmd.Subcommand)
+		}
+		cmd.Consumer = args[2]
+	default:
+		return nil, fmt.Errorf("unknown subcommand for XGROUP command (%s)", cmd.Subcommand)
+	}
+
+	cmd.Key, cmd.Group = args[0], args[1]
+	return cmd, nil
+}
+
+func parseXReadGroupCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 6, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(args[0]) != "GROUP" {
+		return nil, fmt.Errorf("XREADGROUP command requires GROUP followed by the group and consumer names")
+	}
+
+	cmd := XReadGroupCommand{
+		Group:    args[1],
+		Consumer: args[2],
+		Count:    -1,
+	}
+
+	i := 3
+	for ; i < len(args) && string(args[i]) != "STREAMS"; i++ {
+		switch string(args[i]) {
+		case "COUNT":
+			cmd.Count, err = parseStreamCount("XREADGROUP", args, i)
+			if err != nil {
+				return nil, err
+			}
+
+			if cmd.Count == 0 {
+				cmd.Count = -1
+			}
+			i++
+		case "BLOCK":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("BLOCK option for XREADGROUP command requires a timeout")
+			}
+
+			ms, ok := util.ParseInt(args[i+1])
+			if !ok || ms < 0 {
+				return nil, fmt.Errorf("timeout for XREADGROUP command must be a non-negative integer")
+			}
+			cmd.Timeout = time.Duration(ms) * time.Millisecond
+			cmd.blocking = true
+			i++
+		case "NOACK":
+			cmd.NoAck = true
+		default:
+			return nil, fmt.Errorf("invalid option for XREADGROUP command (%s)", args[i])
+		}
+	}
+
+	streams := args[min(i+1, len(args)):]
+	if i >= len(args) || len(streams) == 0 || len(streams)%2 != 0 {
+		return nil, fmt.Errorf("XREADGROUP command requires STREAMS followed by keys and one ID per key")
+	}
+
+	numKeys := len(streams) / 2
+	cmd.Keys = streams[:numKeys]
+	cmd.IDs = make([]StreamID, numKeys)
+	cmd.New = make([]bool, numKeys)
+	for j, id := range streams[numKeys:] {
+		if string(id) == ">" {
+			cmd.New[j] = true
+			continue
+		}
+
+		var ok bool
+		cmd.IDs[j], ok = parseStreamID(id, 0)
+		if !ok {
+			return nil, fmt.Errorf("invalid stream ID for XREADGROUP command")
+		}
+	}
+
+	return cmd, nil
+}
+
+func parseXAckCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 3, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := XAckCommand{
+		Key:   args[0],
+		Group: args[1],
+		IDs:   make([]StreamID, len(args)-2),
+	}
+
+	for i, id := range args[2:] {
+		var ok bool
+		cmd.IDs[i], ok = parseStreamID(id, 0)
+		if !ok {
+			return nil, fmt.Errorf("invalid stream ID for XACK command")
+		}
+	}
+
+	return cmd, nil
+}
+
+// Parses the minimum idle time of XPENDING and XAUTOCLAIM in milliseconds.
+func parseMinIdle(name string, b []byte) (time.Duration, error) {
+	ms, ok := util.ParseInt(b)
+	if !ok || ms < 0 {
+		return 0, fmt.Errorf("min idle time for %s command must be a non-negative integer", name)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func parseXPendingCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 2, 8)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := XPendingCommand{
+		Key:     args[0],
+		Group:   args[1],
+		summary: len(args) == 2,
+	}
+	if cmd.summary {
+		return cmd, nil
+	}
+
+	// XPENDING key group [IDLE min-idle-time] start end count [consumer]
+	rest := args[2:]
+	if string(rest[0]) == "IDLE" {
+		if len(rest) < 2 {
+			return nil, fmt.Errorf("IDLE option for XPENDING command requires a value")
+		}
+		cmd.Options.MinIdle, err = parseMinIdle("XPENDING", rest[1])
+		if err != nil {
+			return nil, err
+		}
+		rest = rest[2:]
+	}
+
+	if len(rest) != 3 && len(rest) != 4 {
+		return nil, fmt.Errorf("XPENDING command requires start, end and count")
+	}
+
+	start, emptyStart, okStart := parseStreamRangeBound(rest[0], false)
+	end, emptyEnd, okEnd := parseStreamRangeBound(rest[1], true)
+	if !okStart || !okEnd {
+		return nil, fmt.Errorf("invalid stream ID for XPENDING command")
+	}
+
+	cmd.Options.Start, cmd.Options.End = start, end
+	if emptyStart || emptyEnd {
+		cmd.Options.Start, cmd.Options.End = maxStreamID, minStreamID
+	}
+
+	count, ok := util.ParseInt(rest[2])
+	if !ok || count < 0 {
+		return nil, fmt.Errorf("count for XPENDING command must be a non-negative integer")
+	}
+	cmd.Options.Count = count
+
+	if len(rest) == 4 {
+		cmd.Options.Consumer = rest[3]
+	}
+
+	return cmd, nil
+}
+
+func parseXAutoClaimCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 5, 8)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := XAutoClaimCommand{
+		Key:   args[0],
+		Group: args[1],
+		Options: XAutoClaimOptions{
+			Consumer: args[2],
+			Count:    100,
+		},
+	}
+
+	cmd.Options.MinIdle, err = parseMinIdle("XAUTOCLAIM", args[3])
+	if err != nil {
+		return nil, err
+	}
+
+	start, empty, ok := parseStreamRangeBound(args[4], false)
+	if !ok {
+		return nil, fmt.Errorf("invalid stream ID for XAUTOCLAIM command")
+	}
+	cmd.Options.Start = start
+	if empty {
+		cmd.Options.Start = maxStreamID
+	}
+
+	for i := 5; i < len(args); i++ {
+		switch string(args[i]) {
+		case "COUNT":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("COUNT option for XAUTOCLAIM command requires a value")
+			}
+
+			count, ok := util.ParseInt(args[i+1])
+			if !ok || count < 1 {
+				return nil, fmt.Errorf("COUNT for XAUTOCLAIM command must be a positive integer")
+			}
+			cmd.Options.Count = count
+			i++
+		case "JUSTID":
+			cmd.Options.JustID = true
+		default:
+			return nil, fmt.Errorf("invalid option for XAUTOCLAIM command (%s)", args[i])
+		}
+	}
+
+	return cmd, nil
+}
+
+// Parses a geo distance unit, returning the number of meters per unit.
+func parseGeoUnit(b []byte) (float64, bool) {
+	switch string(b) {
+	case "m":
+		return 1, true
+	case "km":
+		return 1000, true
+	case "ft":
+		return 0.3048, true
+	case "mi":
+		return 1609.34, true
+	default:
+		return 0, false
+	}
+}
+
+func parseGeoPoint(lon, lat []byte) (GeoPoint, error) {
+	x, okLon := util.ParseFloat(lon)
+	y, okLat := util.ParseFloat(lat)
+	if !okLon || !okLat {
+		return GeoPoint{}, fmt.Errorf("longitude and latitude must be valid floats")
+	}
+
+	p := GeoPoint{Lon: x, Lat: y}
+	if !p.valid() {
+		return GeoPoint{}, fmt.Errorf("invalid longitude,latitude pair %f,%f", x, y)
+	}
+	return p, nil
+}
+
+func parseGeoAddCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 4, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := GeoAddCommand{
+		Key: args[0],
+	}
+
+	// Options come before the longitude/latitude/member triplets
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch string(args[i]) {
+		case "NX":
+			cmd.Options.Condition = ConditionNX
+		case "XX":
+			cmd.Options.Condition = ConditionXX
+		case "CH":
+			cmd.Options.CH = true
+		default:
+			break options
+		}
+	}
+
+	items := args[i:]
+	if len(items) == 0 || len(items)%3 != 0 {
+		return nil, fmt.Errorf("GEOADD command requires longitude, latitude and member triplets")
+	}
+
+	cmd.Members = make([]GeoMember, 0, len(items)/3)
+	for j := 0; j < len(items); j += 3 {
+		p, err := parseGeoPoint(items[j], items[j+1])
+		if err != nil {
+			return nil, err
+		}
+		cmd.Members = append(cmd.Members, GeoMember{Member: items[j+2], Point: p})
+	}
+
+	return cmd, nil
+}
+
+func parseGeoDistCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 3, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := GeoDistCommand{
+		Key:     args[0],
+		Member1: args[1],
+		Member2: args[2],
+		Unit:    1,
+	}
+
+	if len(args) == 4 {
+		var ok bool
+		cmd.Unit, ok = parseGeoUnit(args[3])
+		if !ok {
+			return nil, fmt.Errorf("unsupported unit provided, please use m, km, ft or mi")
+		}
+	}
+
+	return cmd, nil
+}
+
+func parseGeoSearchCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 6, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := GeoSearchCommand{
+		Key: args[0],
+	}
+
+	// Returns the arguments of an option, or an error if there are not enough
+	optionArgs := func(i, n int) ([][]byte, error) {
+		if i+n >= len(args) {
+			return nil, fmt.Errorf("%s option for GEOSEARCH command requires %d argument%s", args[i], n, plural(n))
+		}
+		return args[i+1 : i+1+n], nil
+	}
+
+	var hasFrom, hasBy bool
+	for i := 1; i < len(args); i++ {
+		switch string(args[i]) {
+		case "FROMMEMBER", "FROMLONLAT":
+			if hasFrom {
+				return nil, fmt.Errorf("GEOSEARCH command accepts only one of FROMMEMBER and FROMLONLAT")
+			}
+			hasFrom = true
+
+			if string(args[i]) == "FROMMEMBER" {
+				opt, err := optionArgs(i, 1)
+				if err != nil {
+					return nil, err
+				}
+				cmd.Query.Member = opt[0]
+				i++
+				continue
+			}
+
+			opt, err := optionArgs(i, 2)
+			if err != nil {
+				return nil, err
+			}
+			cmd.Query.Center, err = parseGeoPoint(opt[0], opt[1])
+			if err != nil {
+				return nil, err
+			}
+			i += 2
+		case "BYRADIUS", "BYBOX":
+			if hasBy {
+				return nil, fmt.Errorf("GEOSEARCH command accepts only one of BYRADIUS and BYBOX")
+			}
+			hasBy = true
+
+			cmd.Query.ByBox = string(args[i]) == "BYBOX"
+			n := 2
+			if cmd.Query.ByBox {
+				n = 3
+			}
+
+			opt, err := optionArgs(i, n)
+			if err != nil {
+				return nil, err
+			}
+
+			var ok bool
+			cmd.Unit, ok = parseGeoUnit(opt[n-1])
+			if !ok {
+				return nil, fmt.Errorf("unsupported unit provided, please use m, km, ft or mi")
+			}
+
+			sizes := make([]float64, n-1)
+			for j := range sizes {
+				size, ok := util.ParseFloat(opt[j])
+				if !ok || size < 0 {
+					return nil, fmt.Errorf("%s size for GEOSEARCH command must be a non-negative number", args[i])
+				}
+				sizes[j] = size * cmd.Unit
+			}
+
+			if cmd.Query.ByBox {
+				cmd.Query.Width, cmd.Query.Height = sizes[0], sizes[1]
+			} else {
+				cmd.Query.Radius = sizes[0]
+			}
+			i += n
+		case "ASC":
+			cmd.Query.Sort = GeoSortAsc
+		case "DESC":
+			cmd.Query.Sort = GeoSortDesc
+		case "COUNT":
+			opt, err := optionArgs(i, 1)
+			if err != nil {
+				return nil, err
+			}
+
+			count, ok := util.ParseInt(opt[0])
+			if !ok || count < 1 {
+				return nil, fmt.Errorf("COUNT for GEOSEARCH command must be a positive integer")
+			}
+			cmd.Query.Count = count
+			i++
+
+			if i+1 < len(args) && string(args[i+1]) == "ANY" {
+				cmd.Query.Any = true
+				i++
+			}
+		case "WITHCOORD":
+			cmd.WithCoord = true
+		case "WITHDIST":
+			cmd.WithDist = true
+		case "WITHHASH":
+			cmd.WithHash = true
+		default:
+			return nil, fmt.Errorf("invalid option for GEOSEARCH command (%s)", args[i])
+		}
+	}
+
+	if !hasFrom || !hasBy {
+		return nil, fmt.Errorf("GEOSEARCH command requires FROMMEMBER or FROMLONLAT and BYRADIUS or BYBOX")
+	}
+
+	return cmd, nil
+}
+
+func parseSaveCommand(arr resp.RespArray, background bool) (Command, error) {
+	if _, err := parseArgs(arr, 0, 0); err != nil {
+		return nil, err
+	}
+	return SaveCommand{Background: background}, nil
+}
+
+func parseLastSaveCommand(arr resp.RespArray) (Command, error) {
+	if _, err := parseArgs(arr, 0, 0); err != nil {
+		return nil, err
+	}
+	return LastSaveCommand{}, nil
+}
+
+func parseExportCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 0, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := ExportCommand{}
+	if len(args) > 0 {
+		if len(args) != 2 || string(args[0]) != "MATCH" {
+			return nil, fmt.Errorf("EXPORT command accepts only the MATCH option")
+		}
+		cmd.Pattern = args[1]
+	}
+
+	return cmd, nil
+}
+
+func parseImportCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := ImportCommand{Data: args[0]}
+	if len(args) == 2 {
+		if !strings.EqualFold(string(args[1]), "NX") {
+			return nil, fmt.Errorf("unknown option %q for IMPORT command", args[1])
+		}
+		cmd.NX = true
+	}
+	return cmd, nil
+}
+
+func parseSyncCommand(arr resp.RespArray) (Command, error) {
+	if _, err := parseArgs(arr, 0, 0); err != nil {
+		return nil, err
+	}
+	return SyncCommand{}, nil
+}
+
+func parseReplicaOfCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	host, port := string(args[0]), string(args[1])
+	if strings.EqualFold(host, "NO") && strings.EqualFold(port, "ONE") {
+		return ReplicaOfCommand{}, nil
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	return ReplicaOfCommand{Addr: net.JoinHostPort(host, port)}, nil
+}
+
+func parsePSyncCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid replication offset %q", args[1])
+	}
+	return PSyncCommand{ReplID: string(args[0]), Offset: offset}, nil
+}
+
+func parseReplConfCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 2, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := ReplConfCommand{Option: strings.ToUpper(string(args[0]))}
+	switch cmd.Option {
+	case "ACK":
+		offset, ok := util.ParseInt(args[1])
+		if !ok || offset < 0 {
+			return nil, fmt.Errorf("invalid replication offset %q", args[1])
+		}
+		cmd.Offset = int64(offset)
+	case "LISTENING-PORT":
+		if n, err := strconv.Atoi(string(args[1])); err != nil || n <= 0 || n > 65535 {
+			return nil, fmt.Errorf("invalid port %q", args[1])
+		}
+		cmd.Port = string(args[1])
+	}
+	return cmd, nil
+}
+
+func parseRoleCommand(arr resp.RespArray) (Command, error) {
+	if _, err := parseArgs(arr, 0, 0); err != nil {
+		return nil, err
+	}
+	return RoleCommand{}, nil
+}
+
+func parseClusterCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := ClusterCommand{Subcommand: strings.ToUpper(string(args[0]))}
+	args = args[1:]
+
+	switch cmd.Subcommand {
+	case "INFO", "SLOTS", "SHARDS", "MYID":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("CLUSTER %s takes no arguments", cmd.Subcommand)
+		}
+	case "KEYSLOT":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("CLUSTER KEYSLOT requires exactly 1 key")
+		}
+		cmd.Key = args[0]
+	case "MEET":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("CLUSTER MEET requires a host and port")
+		}
+		if n, err := strconv.Atoi(string(args[1])); err != nil || n <= 0 || n > 65535 {
+			return nil, fmt.Errorf("invalid port %q", args[1])
+		}
+		cmd.Addr = net.JoinHostPort(string(args[0]), string(args[1]))
+	case "ADDSLOTS":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("CLUSTER ADDSLOTS requires at least 1 slot")
+		}
+		for _, arg := range args {
+			slot, ok := parseSlot(arg)
+			if !ok {
+				return nil, fmt.Errorf("invalid or out of range slot %q", arg)
+			}
+			cmd.Slots = append(cmd.Slots, slotRange{slot, slot})
+		}
+	case "ADDSLOTSRANGE":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return nil, fmt.Errorf("CLUSTER ADDSLOTSRANGE requires pairs of start and end slots")
+		}
+		for i := 0; i < len(args); i += 2 {
+			start, ok := parseSlot(args[i])
+			end, endOk := parseSlot(args[i+1])
+			if !ok || !endOk {
+				return nil, fmt.Errorf("invalid or out of range slot range %s-%s", args[i], args[i+1])
+			}
+			if start > end {
+				return nil, fmt.Errorf("start slot %d is greater than end slot %d", start, end)
+			}
+			cmd.Slots = append(cmd.Slots, slotRange{start, end})
+		}
+	case "HELLO":
+		// CLUSTER HELLO id addr slots [id addr slots ...]
+		if len(args) == 0 || len(args)%3 != 0 {
+			return nil, fmt.Errorf("CLUSTER HELLO requires the ID, address and slots of each node")
+		}
+		cmd.Nodes, err = parseClusterNodes(args)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown subcommand for CLUSTER command (%s)", cmd.Subcommand)
+	}
+
+	return cmd, nil
+}
+
+func parseMigrateCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 4, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := strconv.Atoi(string(args[1])); err != nil || n <= 0 || n > 65535 {
+		return nil, fmt.Errorf("invalid port %q", args[1])
+	}
+	ms, ok := util.ParseInt(args[3])
+	if !ok || ms < 0 {
+		return nil, fmt.Errorf("timeout for MIGRATE command must be a non-negative integer")
+	}
+	cmd := MigrateCommand{
+		Addr:    net.JoinHostPort(string(args[0]), string(args[1])),
+		Timeout: time.Duration(ms) * time.Millisecond,
+	}
+	if cmd.Timeout == 0 {
+		cmd.Timeout = time.Second
+	}
+
+	for i := 4; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "COPY":
+			cmd.Copy = true
+		case "REPLACE":
+			cmd.Replace = true
+		case "KEYS":
+			if len(args[2]) != 0 {
+				return nil, fmt.Errorf("KEYS option for MIGRATE command requires the key argument to be empty")
+			}
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("KEYS option for MIGRATE command requires at least 1 key")
+			}
+			cmd.Keys = args[i+1:]
+			i = len(args)
+		default:
+			return nil, fmt.Errorf("unknown option %q for MIGRATE command", args[i])
+		}
+	}
+
+	if cmd.Keys == nil {
+		if len(args[2]) == 0 {
+			return nil, fmt.Errorf("MIGRATE command requires a key or the KEYS option")
+		}
+		cmd.Keys = [][]byte{args[2]}
+	}
+	return cmd, nil
+}
+
+func parseFailoverCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	var cmd FailoverCommand
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "TO":
+			if i+2 >= len(args) {
+				return nil, fmt.Errorf("TO option for FAILOVER command requires a host and port")
+			}
+			if n, err := strconv.Atoi(string(args[i+2])); err != nil || n <= 0 || n > 65535 {
+				return nil, fmt.Errorf("invalid port %q", args[i+2])
+			}
+			cmd.To = net.JoinHostPort(string(args[i+1]), string(args[i+2]))
+			i += 2
+		case "TIMEOUT":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("TIMEOUT option for FAILOVER command requires a timeout")
+			}
+			ms, ok := util.ParseInt(args[i+1])
+			if !ok || ms <= 0 {
+				return nil, fmt.Errorf("timeout for FAILOVER command must be a positive integer")
+			}
+			cmd.Timeout = time.Duration(ms) * time.Millisecond
+			i++
+		case "FORCE":
+			cmd.Force = true
+		case "ABORT":
+			cmd.Abort = true
+		default:
+			return nil, fmt.Errorf("unknown option %q for FAILOVER command", args[i])
+		}
+	}
+
+	if cmd.Abort && (cmd.To != "" || cmd.Timeout > 0 || cmd.Force) {
+		return nil, fmt.Errorf("FAILOVER ABORT accepts no other option")
+	}
+	if cmd.Force && (cmd.To == "" || cmd.Timeout == 0) {
+		return nil, fmt.Errorf("FORCE option for FAILOVER command requires both TO and TIMEOUT")
+	}
+	return cmd, nil
+}
+
+func parseWaitCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	numReplicas, ok := util.ParseInt(args[0])
+	if !ok || numReplicas < 0 {
+		return nil, fmt.Errorf("number of replicas for WAIT command must be a non-negative integer")
+	}
+	ms, ok := util.ParseInt(args[1])
+	if !ok || ms < 0 {
+		return nil, fmt.Errorf("timeout for WAIT command must be a non-negative integer")
+	}
+	return WaitCommand{NumReplicas: numReplicas, Timeout: time.Duration(ms) * time.Millisecond}, nil
+}
+
+func parseBigKeysCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 0, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	command := BigKeysCommand{
+		Count: 10,
+	}
+
+	if len(args) == 1 && strings.ToUpper(string(args[0])) == "SUMMARY" {
+		command.Summary = true
+		return command, nil
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, fmt.Errorf("BIGKEYS command accepts the COUNT and BY options, or SUMMARY alone")
+		}
+
+		switch strings.ToUpper(string(args[i])) {
+		case "COUNT":
+			count, valid := util.ParsePositiveInt(args[i+1])
+			if !valid || count == 0 {
+				return nil, fmt.Errorf("invalid count for BIGKEYS command")
+			}
+			command.Count = count
+		case "BY":
+			switch strings.ToUpper(string(args[i+1])) {
+			case "MEMORY":
+				command.By = MeasureMemory
+			case "ELEMENTS":
+				command.By = MeasureElements
+			default:
+				return nil, fmt.Errorf("BIGKEYS command orders keys BY MEMORY or ELEMENTS")
+			}
+		default:
+			return nil, fmt.Errorf("unknown option for BIGKEYS command")
+		}
+	}
+
+	return command, nil
+}
+
+func parseObjectCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := ObjectCommand{Subcommand: strings.ToUpper(string(args[0]))}
+	switch cmd.Subcommand {
+	case "FREQ":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("OBJECT FREQ requires a key")
+		}
+		cmd.Key = args[1]
+	default:
+		return nil, fmt.Errorf("unknown subcommand for OBJECT command (%s)", cmd.Subcommand)
+	}
+
+	return cmd, nil
+}
+
+func parseMemoryCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := MemoryCommand{Subcommand: strings.ToUpper(string(args[0]))}
+	args = args[1:]
+
+	switch cmd.Subcommand {
+	case "USAGE":
+		// SAMPLES is accepted for compatibility, every element is always counted
+		if len(args) != 1 && (len(args) != 3 || strings.ToUpper(string(args[1])) != "SAMPLES") {
+			return nil, fmt.Errorf("MEMORY USAGE requires a key and an optional SAMPLES count")
+		}
+		if len(args) == 3 {
+			if _, ok := util.ParsePositiveInt(args[2]); !ok {
+				return nil, fmt.Errorf("invalid SAMPLES count for MEMORY USAGE")
+			}
+		}
+		cmd.Key = args[0]
+	case "STATS":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("MEMORY STATS takes no arguments")
+		}
+	default:
+		return nil, fmt.Errorf("unknown subcommand for MEMORY command (%s)", cmd.Subcommand)
+	}
+
+	return cmd, nil
+}
+
+// Parses a probability between 0 and 1.
+func parseRate(s []byte) (float64, bool) {
+	rate, err := strconv.ParseFloat(string(s), 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return 0, false
+	}
+	return rate, true
+}
+
+func parseFCallCommand(arr resp.RespArray, readOnly bool) (Command, error) {
+	args, err := parseArgs(arr, 2, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	numKeys, ok := util.ParsePositiveInt(args[1])
+	if !ok {
+		return nil, fmt.Errorf("invalid number of keys, expected a non-negative integer")
+	}
+	if numKeys > len(args)-2 {
+		return nil, fmt.Errorf("number of keys can't be greater than number of args")
+	}
+
+	return FCallCommand{
+		Name:     string(args[0]),
+		Keys:     args[2 : 2+numKeys],
+		Args:     args[2+numKeys:],
+		ReadOnly: readOnly,
+	}, nil
+}
+
+func parseFunctionCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	command := FunctionCommand{
+		Subcommand: strings.ToUpper(string(args[0])),
+	}
+
+	switch command.Subcommand {
+	case "LIST":
+	default:
+		return nil, fmt.Errorf("unknown subcommand for FUNCTION command (%s)", args[0])
+	}
+
+	return command, nil
+}
+
+func parseSubscribeCommand(arr resp.RespArray, pattern, shard bool) (Command, error) {
+	args, err := parseArgs(arr, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	return SubscribeCommand{
+		Channels: args,
+		Pattern:  pattern,
+		Shard:    shard,
+	}, nil
+}
+
+func parseUnsubscribeCommand(arr resp.RespArray, pattern, shard bool) (Command, error) {
+	args, err := parseArgs(arr, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	return UnsubscribeCommand{
+		Channels: args,
+		Pattern:  pattern,
+		Shard:    shard,
+	}, nil
+}
+
+func parsePublishCommand(arr resp.RespArray, shard bool) (Command, error) {
+	args, err := parseArgs(arr, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	return PublishCommand{
+		Channel: args[0],
+		Message: args[1],
+		Shard:   shard,
+	}, nil
+}
+
+func parsePubSubCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	command := PubSubCommand{
+		Subcommand: strings.ToUpper(string(args[0])),
+	}
+
+	switch command.Subcommand {
+	case "CHANNELS", "SHARDCHANNELS":
+		// PUBSUB CHANNELS|SHARDCHANNELS [pattern]
+		if len(args) > 2 {
+			return nil, fmt.Errorf("PUBSUB %s accepts at most 1 pattern", command.Subcommand)
+		}
+		if len(args) == 2 {
+			command.Pattern = args[1]
+		}
+	case "NUMSUB", "SHARDNUMSUB":
+		// PUBSUB NUMSUB|SHARDNUMSUB [channel ...]
+		command.Channels = args[1:]
+	case "NUMPAT":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("PUBSUB NUMPAT takes no arguments")
+		}
+	default:
+		return nil, fmt.Errorf("unknown subcommand for PUBSUB command (%s)", args[0])
+	}
+
+	return command, nil
+}
+
+func parseClientCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	command := ClientCommand{
+		Subcommand: strings.ToUpper(string(args[0])),
+	}
+
+	switch command.Subcommand {
+	case "REPLY":
+		// CLIENT REPLY ON|OFF|SKIP
+		if len(args) != 2 {
+			return nil, fmt.Errorf("CLIENT REPLY requires a mode, expected ON, OFF or SKIP")
+		}
+		command.ReplyMode = strings.ToUpper(string(args[1]))
+		if command.ReplyMode != "ON" && command.ReplyMode != "OFF" && command.ReplyMode != "SKIP" {
+			return nil, fmt.Errorf("invalid mode for CLIENT REPLY (%s), expected ON, OFF or SKIP", args[1])
+		}
+	case "ID", "GETNAME":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("CLIENT %s takes no arguments", command.Subcommand)
+		}
+	case "SETNAME":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("CLIENT SETNAME requires a name")
+		}
+		if !validClientName(args[1]) {
+			return nil, errInvalidClientName
+		}
+		command.Name = string(args[1])
+	case "TRACKING":
+		// CLIENT TRACKING ON|OFF [REDIRECT id] [BCAST] [PREFIX prefix ...]
+		if err := parseClientTracking(&command, args[1:]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown subcommand for CLIENT command (%s)", args[0])
+	}
+
+	return command, nil
+}
+
+var errInvalidClientName = errors.New("Client names cannot contain spaces, newlines or special characters.")
+
+// Reports whether a client name only has printable characters other than spaces, like in Redis.
+func validClientName(name []byte) bool {
+	for _, c := range name {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func parseHelloCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	var command HelloCommand
+	if len(args) == 0 {
+		return command, nil
+	}
+
+	version, ok := util.ParseInt(args[0])
+	if !ok || version != int(resp.RESP2) && version != int(resp.RESP3) {
+		return nil, fmt.Errorf("NOPROTO unsupported protocol version")
+	}
+	command.Protocol = resp.Protocol(version)
+
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "AUTH":
+			if i+2 >= len(args) {
+				return nil, fmt.Errorf("HELLO AUTH requires a username and a password")
+			}
+			command.Auth = &AuthCommand{Username: string(args[i+1]), Password: string(args[i+2])}
+			i += 2
+		case "SETNAME":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("HELLO SETNAME requires a name")
+			}
+			if !validClientName(args[i+1]) {
+				return nil, errInvalidClientName
+			}
+			name := string(args[i+1])
+			command.Name = &name
+			i++
+		default:
+			return nil, fmt.Errorf("syntax error in HELLO option '%s'", args[i])
+		}
+	}
+
+	return command, nil
+}
+
+func parseAuthCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 1 {
+		return AuthCommand{Username: defaultUser, Password: string(args[0])}, nil
+	}
+	return AuthCommand{Username: string(args[0]), Password: string(args[1])}, nil
+}
+
+func parseConfigCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := ConfigCommand{Subcommand: strings.ToUpper(string(args[0]))}
+	args = args[1:]
+
+	switch cmd.Subcommand {
+	case "GET":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("CONFIG GET requires at least 1 pattern")
+		}
+		for _, arg := range args {
+			cmd.Patterns = append(cmd.Patterns, strings.ToLower(string(arg)))
+		}
+	case "SET":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return nil, fmt.Errorf("CONFIG SET requires pairs of parameters and values")
+		}
+		for i := 0; i < len(args); i += 2 {
+			cmd.Pairs = append(cmd.Pairs, strings.ToLower(string(args[i])), string(args[i+1]))
+		}
+	case "REWRITE":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("CONFIG REWRITE takes no arguments")
+		}
+	default:
+		return nil, fmt.Errorf("unknown subcommand for CONFIG command (%s)", cmd.Subcommand)
+	}
+
+	return cmd, nil
+}
+
+func parseCommandCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return CommandCommand{}, nil
+	}
+
+	cmd := CommandCommand{Subcommand: strings.ToUpper(string(args[0]))}
+	args = args[1:]
+
+	switch cmd.Subcommand {
+	case "COUNT", "LIST":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("COMMAND %s takes no arguments", cmd.Subcommand)
+		}
+	case "INFO", "DOCS":
+		for _, arg := range args {
+			cmd.Names = append(cmd.Names, string(arg))
+		}
+	default:
+		return nil, fmt.Errorf("unknown subcommand for COMMAND command (%s)", cmd.Subcommand)
+	}
+
+	return cmd, nil
+}
+
+func parseLatencyCommand(arr resp.RespArray) (Command, error) {
+	args, err := parseArgs(arr, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := LatencyCommand{Subcommand: strings.ToUpper(string(args[0]))}
+	args = args[1:]
+
+	switch cmd.Subcommand {
+	case "LATEST":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("LATENCY LATEST takes no arguments")
+		}
+	case "HISTORY":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("LATENCY HISTORY requires exactly 1 event")
+		}
+	case "RESET":
+	default:
+		return nil, fmt.Errorf("unknown subcommand for LATENCY command (%s)", cmd.Subcommand)
+	}
+
+	for _, arg := range args {
+		cmd.Events = append(cmd.Events, string(arg))
+	}
+	return cmd, nil
+}
+
+func parseClientTracking(command *ClientCommand, args [][]byte) error {
+	if len(args) == 0 {
+		return fmt.Errorf("CLIENT TRACKING requires ON or OFF")
+	}
+
+	switch strings.ToUpper(string(args[0])) {
+	case "ON":
+		command.Tracking = true
+	case "OFF":
+		command.Tracking = false
+	default:
+		return fmt.Errorf("invalid mode for CLIENT TRACKING (%s), expected ON or OFF", args[0])
+	}
+
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "REDIRECT":
+			if i+1 >= len(args) {
+				return fmt.Errorf("REDIRECT requires a client ID")
+			}
+			id, ok := util.ParseInt(args[i+1])
+			if !ok || id <= 0 {
+				return fmt.Errorf("invalid client ID for REDIRECT (%s)", args[i+1])
+			}
+			command.Redirect = int64(id)
+			i++
+		case "BCAST":
+			command.BCast = true
+		case "PREFIX":
+			if i+1 >= len(args) {
+				return fmt.Errorf("PREFIX requires a prefix")
+			}
+			command.Prefixes = append(command.Prefixes, args[i+1])
+			i++
+		default:
+			return fmt.Errorf("unknown option for CLIENT TRACKING (%s)", args[i])
+		}
+	}
+
+	if len(command.Prefixes) > 0 && !command.BCast {
+		return fmt.Errorf("PREFIX can only be used with BCAST")
+	}
+	if command.Tracking && command.Redirect == 0 {
+		return fmt.Errorf("CLIENT TRACKING requires REDIRECT, invalidation messages are delivered over pub/sub to another client")
+	}
+
+	return nil
+}
+
+func parseDebugCommand(arr resp.RespArray) (Command, error) {
+	if len(arr.Elements) < 2 {
+		return nil, fmt.Errorf("DEBUG command requires a subcommand")
+	}
+
+	args := make([][]byte, len(arr.Elements)-1)
+	for i, elem := range arr.Elements[1:] {
+		arg, ok := elem.(resp.RespBulkString)
+		if !ok {
+			return nil, fmt.Errorf("invalid DEBUG command format: expected bulk strings for arguments")
+		}
+		args[i] = arg.Value
+	}
+
+	command := DebugCommand{
+		Subcommand: strings.ToUpper(string(args[0])),
+	}
+
+	switch command.Subcommand {
+	case "LATENCY":
+		// DEBUG LATENCY milliseconds [rate]
+		if len(args) != 2 && len(args) != 3 {
+			return nil, fmt.Errorf("DEBUG LATENCY requires a latency in milliseconds and an optional rate")
+		}
+		ms, ok := util.ParsePositiveInt(args[1])
+		if !ok {
+			return nil, fmt.Errorf("invalid latency for DEBUG LATENCY")
+		}
+		command.Latency = time.Duration(ms) * time.Millisecond
+		command.Rate = 1
+		if len(args) == 3 {
+			command.Rate, ok = parseRate(args[2])
+			if !ok {
+				return nil, fmt.Errorf("invalid rate for DEBUG LATENCY, expected a value between 0 and 1")
+			}
+		}
+	case "DISCONNECT", "ERROR":
+		// DEBUG DISCONNECT|ERROR rate
+		if len(args) != 2 {
+			return nil, fmt.Errorf("DEBUG %s requires a rate", command.Subcommand)
+		}
+		rate, ok := parseRate(args[1])
+		if !ok {
+			return nil, fmt.Errorf("invalid rate for DEBUG %s, expected a value between 0 and 1", command.Subcommand)
+		}
+		command.Rate = rate
+	case "FAULTS", "RESET-FAULTS":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("DEBUG %s takes no arguments", command.Subcommand)
+		}
+	case "SLEEP":
+		// DEBUG SLEEP seconds
+		if len(args) != 2 {
+			return nil, fmt.Errorf("DEBUG SLEEP requires a number of seconds")
+		}
+		seconds, ok := util.ParseFloat(args[1])
+		if !ok || seconds < 0 || seconds > math.MaxInt64/float64(time.Second) {
+			return nil, fmt.Errorf("invalid number of seconds for DEBUG SLEEP")
+		}
+		command.Latency = time.Duration(seconds * float64(time.Second))
+	case "SET-ACTIVE-EXPIRE":
+		// DEBUG SET-ACTIVE-EXPIRE 0|1
+		if len(args) != 2 || (string(args[1]) != "0" && string(args[1]) != "1") {
+			return nil, fmt.Errorf("DEBUG SET-ACTIVE-EXPIRE requires 0 or 1")
+		}
+		command.Enabled = string(args[1]) == "1"
+	case "OBJECT":
+		// DEBUG OBJECT key
+		if len(args) != 2 {
+			return nil, fmt.Errorf("DEBUG OBJECT requires a key")
+		}
+		command.Key = args[1]
+	case "QUICKLIST-PACKED-THRESHOLD":
+		// DEBUG QUICKLIST-PACKED-THRESHOLD size
+		if len(args) != 2 {
+			return nil, fmt.Errorf("DEBUG QUICKLIST-PACKED-THRESHOLD requires a size")
+		}
+		if size, ok := util.ParseMemorySize(args[1]); !ok || size == 0 {
+			return nil, fmt.Errorf("invalid size for DEBUG QUICKLIST-PACKED-THRESHOLD")
+		}
+	default:
+		return nil, fmt.Errorf("unknown subcommand for DEBUG command (%s)", args[0])
+	}
+
+	return command, nil
+}
+
 func ParseCommand(cmdArray resp.RespArray) (Command, error) {
 	command := cmdArray.Elements[0]
 
@@ -368,23 +3629,193 @@ func ParseCommand(cmdArray resp.RespArray) (Command, error) {
 		return parseSetCommand(cmdArray)
 	case CmdGet:
 		return parseGetCommand(cmdArray)
+	case CmdGetOrSet:
+		return parseGetOrSetCommand(cmdArray)
+	case CmdMGet:
+		return parseMGetCommand(cmdArray)
 	case CmdDelete:
 		return parseDeleteCommand(cmdArray)
+	case CmdDelIfEq:
+		return parseDelIfEqCommand(cmdArray)
 	case CmdExists:
 		return parseExistsCommand(cmdArray)
 	case CmdPing:
 		return parsePingCommand(cmdArray)
-	case CmdExpire, CmdPExpire:
+	case CmdExpire, CmdPExpire, CmdExpireAt, CmdPExpireAt:
 		return parseExpireCommand(cmdArray)
 	case CmdLPush, CmdRPush:
 		return parsePushCommand(cmdArray)
 	case CmdLPop, CmdRPop:
 		return parsePopCommand(cmdArray)
+	case CmdBLPop, CmdBRPop:
+		return parseBlockingPopCommand(cmdArray)
+	case CmdLMove, CmdBLMove:
+		return parseMoveCommand(cmdArray)
 	case CmdLLen:
 		return parseLLenCommand(cmdArray)
 	case CmdLRange:
 		return parseLRangeCommand(cmdArray)
+	case CmdHSet:
+		return parseHSetCommand(cmdArray)
+	case CmdHSetNX:
+		return parseHSetNXCommand(cmdArray)
+	case CmdHMGet:
+		return parseHMGetCommand(cmdArray)
+	case CmdHLen:
+		return parseHLenCommand(cmdArray)
+	case CmdHKeys, CmdHVals:
+		return parseHKeysCommand(cmdArray)
+	case CmdHGet:
+		return parseHGetCommand(cmdArray)
+	case CmdHDel:
+		return parseHDelCommand(cmdArray)
+	case CmdHGetAll:
+		return parseHGetAllCommand(cmdArray)
+	case CmdHExists:
+		return parseHExistsCommand(cmdArray)
+	case CmdHIncrBy, CmdHIncrByFloat:
+		return parseHIncrByCommand(cmdArray)
+	case CmdHExpire, CmdHPExpire, CmdHExpireAt, CmdHPExpireAt:
+		return parseHExpireCommand(cmdArray)
+	case CmdHTTL, CmdHPTTL:
+		return parseHTTLCommand(cmdArray)
+	case CmdSAdd:
+		return parseSAddCommand(cmdArray)
+	case CmdSRem:
+		return parseSRemCommand(cmdArray)
+	case CmdSIsMember, CmdSMIsMember:
+		return parseSIsMemberCommand(cmdArray)
+	case CmdSMembers:
+		return parseSMembersCommand(cmdArray)
+	case CmdSCard:
+		return parseSCardCommand(cmdArray)
+	case CmdSScan:
+		return parseSScanCommand(cmdArray)
+	case CmdZAdd:
+		return parseZAddCommand(cmdArray)
+	case CmdZRem:
+		return parseZRemCommand(cmdArray)
+	case CmdZScore:
+		return parseZScoreCommand(cmdArray)
+	case CmdZCard:
+		return parseZCardCommand(cmdArray)
+	case CmdZRange, CmdZRevRange:
+		return parseZRangeCommand(cmdArray)
+	case CmdZRangeByScore, CmdZRevRangeByScore, CmdZRangeByLex, CmdZRevRangeByLex:
+		return parseZRangeByCommand(cmdArray)
+	case CmdZUnionStore, CmdZInterStore, CmdZDiffStore:
+		return parseZStoreCommand(cmdArray)
+	case CmdZRemRangeByRank:
+		return parseZRemRangeByRankCommand(cmdArray)
+	case CmdZRemRangeByScore:
+		return parseZRemRangeByScoreCommand(cmdArray)
+	case CmdBitOp:
+		return parseBitOpCommand(cmdArray)
+	case CmdBitField:
+		return parseBitFieldCommand(cmdArray)
+	case CmdPFAdd:
+		return parsePFAddCommand(cmdArray)
+	case CmdPFCount:
+		return parsePFCountCommand(cmdArray)
+	case CmdPFMerge:
+		return parsePFMergeCommand(cmdArray)
+	case CmdXAdd:
+		return parseXAddCommand(cmdArray)
+	case CmdXLen:
+		return parseXLenCommand(cmdArray)
+	case CmdXRange, CmdXRevRange:
+		return parseXRangeCommand(cmdArray)
+	case CmdXRead:
+		return parseXReadCommand(cmdArray)
+	case CmdXGroup:
+		return parseXGroupCommand(cmdArray)
+	case CmdXReadGroup:
+		return parseXReadGroupCommand(cmdArray)
+	case CmdXAck:
+		return parseXAckCommand(cmdArray)
+	case CmdXPending:
+		return parseXPendingCommand(cmdArray)
+	case CmdXAutoClaim:
+		return parseXAutoClaimCommand(cmdArray)
+	case CmdGeoAdd:
+		return parseGeoAddCommand(cmdArray)
+	case CmdGeoDist:
+		return parseGeoDistCommand(cmdArray)
+	case CmdGeoSearch:
+		return parseGeoSearchCommand(cmdArray)
+	case CmdSubscribe:
+		return parseSubscribeCommand(cmdArray, false, false)
+	case CmdUnsubscribe:
+		return parseUnsubscribeCommand(cmdArray, false, false)
+	case CmdPSubscribe:
+		return parseSubscribeCommand(cmdArray, true, false)
+	case CmdPUnsubscribe:
+		return parseUnsubscribeCommand(cmdArray, true, false)
+	case CmdPublish:
+		return parsePublishCommand(cmdArray, false)
+	case CmdPubSub:
+		return parsePubSubCommand(cmdArray)
+	case CmdSSubscribe:
+		return parseSubscribeCommand(cmdArray, false, true)
+	case CmdSUnsubscribe:
+		return parseUnsubscribeCommand(cmdArray, false, true)
+	case CmdSPublish:
+		return parsePublishCommand(cmdArray, true)
+	case CmdFCall:
+		return parseFCallCommand(cmdArray, false)
+	case CmdFCallRO:
+		return parseFCallCommand(cmdArray, true)
+	case CmdFunction:
+		return parseFunctionCommand(cmdArray)
+	case CmdClient:
+		return parseClientCommand(cmdArray)
+	case CmdHello:
+		return parseHelloCommand(cmdArray)
+	case CmdAuth:
+		return parseAuthCommand(cmdArray)
+	case CmdConfig:
+		return parseConfigCommand(cmdArray)
+	case CmdCommand:
+		return parseCommandCommand(cmdArray)
+	case CmdLatency:
+		return parseLatencyCommand(cmdArray)
+	case CmdDebug:
+		return parseDebugCommand(cmdArray)
+	case CmdBigKeys:
+		return parseBigKeysCommand(cmdArray)
+	case CmdMemory:
+		return parseMemoryCommand(cmdArray)
+	case CmdObject:
+		return parseObjectCommand(cmdArray)
+	case CmdSave:
+		return parseSaveCommand(cmdArray, false)
+	case CmdBGSave:
+		return parseSaveCommand(cmdArray, true)
+	case CmdLastSave:
+		return parseLastSaveCommand(cmdArray)
+	case CmdExport:
+		return parseExportCommand(cmdArray)
+	case CmdImport:
+		return parseImportCommand(cmdArray)
+	case CmdSync:
+		return parseSyncCommand(cmdArray)
+	case CmdReplicaOf, CmdSlaveOf:
+		return parseReplicaOfCommand(cmdArray)
+	case CmdPSync:
+		return parsePSyncCommand(cmdArray)
+	case CmdReplConf:
+		return parseReplConfCommand(cmdArray)
+	case CmdWait:
+		return parseWaitCommand(cmdArray)
+	case CmdFailover:
+		return parseFailoverCommand(cmdArray)
+	case CmdRole:
+		return parseRoleCommand(cmdArray)
+	case CmdCluster:
+		return parseClusterCommand(cmdArray)
+	case CmdMigrate:
+		return parseMigrateCommand(cmdArray)
 	default:
-		return nil, fmt.Errorf("unknown command: %s", cmdStr.Value)
+		return nil, fmt.Errorf("%w: %s", errUnknownCommand, cmdStr.Value)
 	}
 }
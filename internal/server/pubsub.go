@@ -0,0 +1,261 @@
+package server
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+	"github.com/CDavidSV/GopherStore/internal/util"
+)
+
+// Subscribers of a channel or pattern. Only accessed from the server loop.
+type subscribers map[*Client]struct{}
+
+// Adds a client to the subscribers of name. Returns false if it was already subscribed.
+func subscribe(registry map[string]subscribers, name string, client *Client) bool {
+	subs, exists := registry[name]
+	if !exists {
+		subs = make(subscribers)
+		registry[name] = subs
+	}
+
+	if _, subscribed := subs[client]; subscribed {
+		return false
+	}
+	subs[client] = struct{}{}
+	return true
+}
+
+// Removes a client from the subscribers of name, dropping names without subscribers.
+func unsubscribe(registry map[string]subscribers, name string, client *Client) {
+	subs := registry[name]
+	delete(subs, client)
+	if len(subs) == 0 {
+		delete(registry, name)
+	}
+}
+
+// Returns the number of channels, patterns and shard channels the client is subscribed to.
+func (c *Client) subscriptionCount() int {
+	return len(c.channels) + len(c.patterns) + len(c.shardChannels)
+}
+
+// Returns the subscription count sent in subscribe and unsubscribe replies. Like in Redis, shard
+// channels are counted apart from channels and patterns.
+func (c *Client) replyCount(shard bool) int {
+	if shard {
+		return len(c.shardChannels)
+	}
+	return len(c.channels) + len(c.patterns)
+}
+
+// Reports whether a command can run while the client is subscribed to channels or patterns.
+func allowedInSubscribeMode(cmd Command) bool {
+	switch cmd.(type) {
+	case SubscribeCommand, UnsubscribeCommand, PingCommand:
+		return true
+	default:
+		return false
+	}
+}
+
+// Encodes a subscription confirmation, e.g. ["subscribe", channel, count].
+func encodeSubscription(kind string, name []byte, count int) []byte {
+	return resp.EncodeArray([][]byte{
+		resp.EncodeBulkString([]byte(kind)),
+		resp.EncodeBulkString(name),
+		resp.EncodeInteger(int64(count)),
+	})
+}
+
+// Handles SUBSCRIBE, PSUBSCRIBE and SSUBSCRIBE commands from a client.
+func (s *Server) handleSubscribeCommand(cmd SubscribeCommand, client *Client) {
+	kind, registry, subscribed := "subscribe", s.channels, client.channels
+	if cmd.Pattern {
+		kind, registry, subscribed = "psubscribe", s.patterns, client.patterns
+	} else if cmd.Shard {
+		kind, registry, subscribed = "ssubscribe", s.shardChannels, client.shardChannels
+		if s.rejectShardChannels(cmd.Channels, client) {
+			return
+		}
+	}
+
+	for _, name := range cmd.Channels {
+		if subscribe(registry, string(name), client) {
+			subscribed[string(name)] = struct{}{}
+		}
+		client.SendMessage(encodeSubscription(kind, name, client.replyCount(cmd.Shard)))
+	}
+}
+
+// Handles UNSUBSCRIBE, PUNSUBSCRIBE and SUNSUBSCRIBE commands from a client.
+// Without channels the client is unsubscribed from all the channels, patterns or shard channels it is
+// subscribed to.
+func (s *Server) handleUnsubscribeCommand(cmd UnsubscribeCommand, client *Client) {
+	kind, registry, subscribed := "unsubscribe", s.channels, client.channels
+	if cmd.Pattern {
+		kind, registry, subscribed = "punsubscribe", s.patterns, client.patterns
+	} else if cmd.Shard {
+		kind, registry, subscribed = "sunsubscribe", s.shardChannels, client.shardChannels
+	}
+
+	names := cmd.Channels
+	if len(names) == 0 {
+		for name := range subscribed {
+			names = append(names, []byte(name))
+		}
+		slices.SortFunc(names, bytes.Compare)
+
+		if len(names) == 0 {
+			client.SendMessage(encodeSubscription(kind, nil, client.replyCount(cmd.Shard)))
+			return
+		}
+	}
+
+	for _, name := range names {
+		if _, ok := subscribed[string(name)]; ok {
+			unsubscribe(registry, string(name), client)
+			delete(subscribed, string(name))
+		}
+		client.SendMessage(encodeSubscription(kind, name, client.replyCount(cmd.Shard)))
+	}
+}
+
+// Sends an encoded message to subscribers, returning the number of clients that received it.
+func (s *Server) deliver(subs subscribers, channel, msg []byte) int {
+	receivers := 0
+	for client := range subs {
+		if err := client.SendMessage(msg); err != nil {
+			s.logger.Warn("dropped pub/sub message for slow client", "channel", string(channel), "remoteAddr", client.conn.RemoteAddr().String())
+			continue
+		}
+		receivers++
+	}
+	return receivers
+}
+
+// Sends a message to the clients subscribed to the channel or to a pattern matching it.
+// Returns the number of clients that received the message.
+func (s *Server) publish(channel, message []byte) int {
+	receivers := 0
+
+	if subs, ok := s.channels[string(channel)]; ok {
+		msg := resp.EncodeArray([][]byte{
+			resp.EncodeBulkString([]byte("message")),
+			resp.EncodeBulkString(channel),
+			resp.EncodeBulkString(message),
+		})
+		receivers += s.deliver(subs, channel, msg)
+	}
+
+	for pattern, subs := range s.patterns {
+		if !util.MatchPattern([]byte(pattern), channel) {
+			continue
+		}
+
+		msg := resp.EncodeArray([][]byte{
+			resp.EncodeBulkString([]byte("pmessage")),
+			resp.EncodeBulkString([]byte(pattern)),
+			resp.EncodeBulkString(channel),
+			resp.EncodeBulkString(message),
+		})
+		receivers += s.deliver(subs, channel, msg)
+	}
+
+	return receivers
+}
+
+// In cluster mode, shard channels belong to the slot of their name like keys, so messages only fan out
+// within the node owning it. Replies with the redirection to the owner and returns true if the
+// channels are not all served by this node.
+func (s *Server) rejectShardChannels(channels [][]byte, client *Client) bool {
+	if s.cluster == nil {
+		return false
+	}
+	if msg := s.cluster.redirect(channels); msg != "" {
+		client.SendMessage(resp.EncodeError(msg))
+		return true
+	}
+	return false
+}
+
+// Sends a message to the clients subscribed to a shard channel. Patterns never match shard channels.
+// Returns the number of clients that received the message.
+func (s *Server) publishShard(channel, message []byte) int {
+	subs, ok := s.shardChannels[string(channel)]
+	if !ok {
+		return 0
+	}
+
+	msg := resp.EncodeArray([][]byte{
+		resp.EncodeBulkString([]byte("smessage")),
+		resp.EncodeBulkString(channel),
+		resp.EncodeBulkString(message),
+	})
+	return s.deliver(subs, channel, msg)
+}
+
+// Handles PUBLISH and SPUBLISH commands from a client.
+func (s *Server) handlePublishCommand(cmd PublishCommand, client *Client) {
+	var receivers int
+	if cmd.Shard {
+		if s.rejectShardChannels([][]byte{cmd.Channel}, client) {
+			return
+		}
+		receivers = s.publishShard(cmd.Channel, cmd.Message)
+	} else {
+		receivers = s.publish(cmd.Channel, cmd.Message)
+	}
+	client.SendMessage(resp.EncodeInteger(int64(receivers)))
+}
+
+// Handles PUBSUB CHANNELS, NUMSUB, NUMPAT, SHARDCHANNELS and SHARDNUMSUB commands from a client.
+func (s *Server) handlePubSubCommand(cmd PubSubCommand, client *Client) {
+	registry := s.channels
+	if strings.HasPrefix(cmd.Subcommand, "SHARD") {
+		registry = s.shardChannels
+	}
+
+	switch cmd.Subcommand {
+	case "CHANNELS", "SHARDCHANNELS":
+		channels := [][]byte{}
+		for channel := range registry {
+			if cmd.Pattern == nil || util.MatchPattern(cmd.Pattern, []byte(channel)) {
+				channels = append(channels, []byte(channel))
+			}
+		}
+		slices.SortFunc(channels, bytes.Compare)
+		client.SendMessage(resp.EncodeBulkStringArray(channels))
+	case "NUMSUB", "SHARDNUMSUB":
+		// Channel names interleaved with their number of subscribers
+		reply := make([][]byte, 0, len(cmd.Channels)*2)
+		for _, channel := range cmd.Channels {
+			reply = append(reply, resp.EncodeBulkString(channel), resp.EncodeInteger(int64(len(registry[string(channel)]))))
+		}
+		client.SendMessage(resp.EncodeArray(reply))
+	case "NUMPAT":
+		// Counts pattern subscriptions, not unique patterns
+		count := 0
+		for _, subs := range s.patterns {
+			count += len(subs)
+		}
+		client.SendMessage(resp.EncodeInteger(int64(count)))
+	}
+}
+
+// Removes every subscription of a disconnected client.
+func (s *Server) unsubscribeAll(client *Client) {
+	for name := range client.channels {
+		unsubscribe(s.channels, name, client)
+	}
+	for name := range client.patterns {
+		unsubscribe(s.patterns, name, client)
+	}
+	for name := range client.shardChannels {
+		unsubscribe(s.shardChannels, name, client)
+	}
+	clear(client.channels)
+	clear(client.patterns)
+	clear(client.shardChannels)
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+func newTestClient() *Client {
+	return NewClient(nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+// Returns the messages sent to a client so far.
+func drainReplies(client *Client) []string {
+	var replies []string
+	for len(client.sendCh) > 0 {
+		r := <-client.sendCh
+		if r.stream != nil {
+			var buf bytes.Buffer
+			r.stream(resp.NewWriter(&buf))
+			r.data = buf.Bytes()
+		}
+		replies = append(replies, string(r.data))
+	}
+	return replies
+}
+
+func TestPubSub(t *testing.T) {
+	s := newTestServer(t)
+	subscriber := newTestClient()
+	publisher := newTestClient()
+
+	run := func(client *Client, cmd Command) []string {
+		s.processMessages([]Message{{cmd: cmd, client: client}})
+		return drainReplies(client)
+	}
+
+	replies := run(subscriber, SubscribeCommand{Channels: [][]byte{[]byte("news"), []byte("sports")}})
+	if len(replies) != 2 || replies[1] != "*3\r\n$9\r\nsubscribe\r\n$6\r\nsports\r\n:2\r\n" {
+		t.Errorf("Unexpected subscribe replies %q", replies)
+	}
+	run(subscriber, SubscribeCommand{Channels: [][]byte{[]byte("n*")}, Pattern: true})
+
+	// Only pub/sub commands and PING are allowed while subscribed
+	if replies := run(subscriber, GetCommand{Key: []byte("key")}); len(replies) != 1 || replies[0][0] != '-' {
+		t.Errorf("Expected an error for GET in subscribe mode, got %q", replies)
+	}
+
+	if replies := run(publisher, PublishCommand{Channel: []byte("news"), Message: []byte("hello")}); replies[0] != ":2\r\n" {
+		t.Errorf("Expected 2 receivers for the channel and pattern, got %q", replies)
+	}
+
+	replies = drainReplies(subscriber)
+	expected := []string{
+		"*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n",
+		"*4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$4\r\nnews\r\n$5\r\nhello\r\n",
+	}
+	if len(replies) != 2 || replies[0] != expected[0] || replies[1] != expected[1] {
+		t.Errorf("Expected %q, got %q", expected, replies)
+	}
+
+	if replies := run(publisher, PublishCommand{Channel: []byte("weather"), Message: []byte("sunny")}); replies[0] != ":0\r\n" {
+		t.Errorf("Expected no receivers, got %q", replies)
+	}
+
+	// Unsubscribing from everything leaves subscribe mode
+	run(subscriber, UnsubscribeCommand{})
+	run(subscriber, UnsubscribeCommand{Pattern: true})
+	if subscriber.subscriptionCount() != 0 || len(s.channels) != 0 || len(s.patterns) != 0 {
+		t.Error("Expected all subscriptions to be removed")
+	}
+	if replies := run(subscriber, GetCommand{Key: []byte("key")}); len(replies) != 1 || replies[0] != "$-1\r\n" {
+		t.Errorf("Expected GET to run after unsubscribing, got %q", replies)
+	}
+
+	// Disconnected clients are removed from the registries
+	run(subscriber, SubscribeCommand{Channels: [][]byte{[]byte("news")}})
+	s.unsubscribeAll(subscriber)
+	if len(s.channels) != 0 {
+		t.Error("Expected the channel to be removed with its last subscriber")
+	}
+}
+
+func TestPubSubIntrospection(t *testing.T) {
+	s := newTestServer(t)
+	first := newTestClient()
+	second := newTestClient()
+
+	s.handleSubscribeCommand(SubscribeCommand{Channels: [][]byte{[]byte("news"), []byte("sports")}}, first)
+	s.handleSubscribeCommand(SubscribeCommand{Channels: [][]byte{[]byte("news")}}, second)
+	s.handleSubscribeCommand(SubscribeCommand{Channels: [][]byte{[]byte("n*")}, Pattern: true}, first)
+	s.handleSubscribeCommand(SubscribeCommand{Channels: [][]byte{[]byte("n*")}, Pattern: true}, second)
+	drainReplies(first)
+	drainReplies(second)
+
+	tests := []struct {
+		cmd      PubSubCommand
+		expected string
+	}{
+		{PubSubCommand{Subcommand: "CHANNELS"}, "*2\r\n$4\r\nnews\r\n$6\r\nsports\r\n"},
+		{PubSubCommand{Subcommand: "CHANNELS", Pattern: []byte("s*")}, "*1\r\n$6\r\nsports\r\n"},
+		{PubSubCommand{Subcommand: "NUMSUB", Channels: [][]byte{[]byte("news"), []byte("none")}}, "*4\r\n$4\r\nnews\r\n:2\r\n$4\r\nnone\r\n:0\r\n"},
+		{PubSubCommand{Subcommand: "NUMPAT"}, ":2\r\n"},
+	}
+
+	client := newTestClient()
+	for _, tt := range tests {
+		s.handlePubSubCommand(tt.cmd, client)
+		if replies := drainReplies(client); len(replies) != 1 || replies[0] != tt.expected {
+			t.Errorf("PUBSUB %s: expected %q, got %q", tt.cmd.Subcommand, tt.expected, replies)
+		}
+	}
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	if _, err := ParseKeyspaceEvents("KEq"); err == nil {
+		t.Error("Expected an error for an unknown flag")
+	}
+
+	s := newTestServer(t)
+	flags, err := ParseKeyspaceEvents("Kl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetKeyspaceEvents(flags); err != nil {
+		t.Fatal(err)
+	}
+
+	subscriber := newTestClient()
+	s.handleSubscribeCommand(SubscribeCommand{Channels: [][]byte{[]byte("__key*@0__:*")}, Pattern: true}, subscriber)
+	drainReplies(subscriber)
+
+	// Only list events are enabled, and only on the keyspace channel
+	s.store.Set([]byte("key"), []byte("value"), -1)
+	s.store.Push([]byte("key2"), [][]byte{[]byte("a")}, false)
+	for range 2 {
+		s.publishKeyspaceEvent(<-s.eventCh)
+	}
+
+	expected := "*4\r\n$8\r\npmessage\r\n$12\r\n__key*@0__:*\r\n$19\r\n__keyspace@0__:key2\r\n$5\r\nrpush\r\n"
+	if replies := drainReplies(subscriber); len(replies) != 1 || replies[0] != expected {
+		t.Errorf("Expected %q, got %q", expected, replies)
+	}
+}
+
+func TestShardedPubSub(t *testing.T) {
+	s := newTestServer(t)
+	subscriber := newTestClient()
+	publisher := newTestClient()
+
+	s.handleSubscribeCommand(SubscribeCommand{Channels: [][]byte{[]byte("news")}}, subscriber)
+	s.handleSubscribeCommand(SubscribeCommand{Channels: [][]byte{[]byte("orders")}, Shard: true}, subscriber)
+	s.handleSubscribeCommand(SubscribeCommand{Channels: [][]byte{[]byte("*")}, Pattern: true}, subscriber)
+	replies := drainReplies(subscriber)
+	if len(replies) != 3 || replies[1] != "*3\r\n$10\r\nssubscribe\r\n$6\r\norders\r\n:1\r\n" {
+		t.Errorf("Unexpected subscribe replies %q", replies)
+	}
+
+	// Shard messages only reach shard subscribers, never patterns
+	s.handlePublishCommand(PublishCommand{Channel: []byte("orders"), Message: []byte("new"), Shard: true}, publisher)
+	if replies := drainReplies(publisher); replies[0] != ":1\r\n" {
+		t.Errorf("Expected 1 receiver, got %q", replies)
+	}
+	expected := "*3\r\n$8\r\nsmessage\r\n$6\r\norders\r\n$3\r\nnew\r\n"
+	if replies := drainReplies(subscriber); len(replies) != 1 || replies[0] != expected {
+		t.Errorf("Expected %q, got %q", expected, replies)
+	}
+
+	// PUBLISH does not reach shard channels with the same name
+	s.handlePublishCommand(PublishCommand{Channel: []byte("orders"), Message: []byte("new")}, publisher)
+	drainReplies(publisher)
+	if replies := drainReplies(subscriber); len(replies) != 1 || replies[0][:14] != "*4\r\n$8\r\npmessa" {
+		t.Errorf("Expected only the pattern message, got %q", replies)
+	}
+
+	s.handlePubSubCommand(PubSubCommand{Subcommand: "SHARDCHANNELS"}, publisher)
+	if replies := drainReplies(publisher); replies[0] != "*1\r\n$6\r\norders\r\n" {
+		t.Errorf("Unexpected shard channels %q", replies)
+	}
+
+	s.handleUnsubscribeCommand(UnsubscribeCommand{Shard: true}, subscriber)
+	if replies := drainReplies(subscriber); len(replies) != 1 || replies[0] != "*3\r\n$12\r\nsunsubscribe\r\n$6\r\norders\r\n:0\r\n" {
+		t.Errorf("Unexpected unsubscribe replies %q", replies)
+	}
+	if len(s.shardChannels) != 0 || subscriber.subscriptionCount() != 2 {
+		t.Error("Expected only the shard subscription to be removed")
+	}
+}
@@ -0,0 +1,631 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+const (
+	replicationDialTimeout = 5 * time.Second
+	replicationRetryDelay  = time.Second // Wait before reconnecting to a primary after the link failed
+	replicationAckInterval = time.Second // Time between acknowledgements sent by replicas without being asked
+)
+
+// Stores able to replace their data with the snapshot of a primary.
+type replicaStore interface {
+	snapshotter
+	Flush() error
+}
+
+// A replica connected to this server with PSYNC.
+// Must only be accessed from the server loop.
+type replica struct {
+	client  *Client
+	addr    string   // host:port the replica accepts clients on, empty if it did not send REPLCONF LISTENING-PORT
+	online  bool     // The snapshot was sent and the stream is sent as it is written
+	backlog [][]byte // Stream written while the snapshot was encoded, sent right after it
+	acked   int64    // Offset of the replication stream the replica acknowledged applying
+}
+
+// Client blocked by WAIT until enough replicas acknowledged the stream up to offset.
+type waitingClient struct {
+	bc          *blockedClient
+	offset      int64
+	numReplicas int
+}
+
+// Returns a random replication ID, identifying the history of the replication stream of a primary.
+func newReplicationID() string {
+	id := make([]byte, 20)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Sends a command to the replicas as part of the replication stream.
+// Must be called from the server loop.
+func (s *Server) replicate(args [][]byte) {
+	if len(s.replicas) == 0 {
+		return
+	}
+
+	data := resp.EncodeBulkStringArray(args)
+	s.replOffset += int64(len(data))
+	for _, r := range s.replicas {
+		s.feedReplica(r, data)
+	}
+}
+
+// Sends part of the replication stream to a replica. A replica that cannot keep up is disconnected,
+// since skipping part of the stream would make it diverge, and it resyncs once it reconnects.
+// Must be called from the server loop.
+func (s *Server) feedReplica(r *replica, data []byte) {
+	if !r.online {
+		r.backlog = append(r.backlog, data)
+		return
+	}
+
+	if err := r.client.send(data); err != nil {
+		s.logger.Warn("disconnecting replica that cannot keep up", "error", err, "remoteAddr", r.client.conn.RemoteAddr().String())
+		delete(s.replicas, r.client)
+		r.client.conn.Close()
+	}
+}
+
+// Returns the number of synced replicas that acknowledged the replication stream up to offset.
+// Must be called from the server loop.
+func (s *Server) countAcked(offset int64) int {
+	count := 0
+	for _, r := range s.replicas {
+		if r.online && r.acked >= offset {
+			count++
+		}
+	}
+	return count
+}
+
+// Handles the WAIT command from a client. Replies right away once enough replicas acknowledged every
+// write made so far, otherwise asks the replicas to acknowledge and blocks until they did or the
+// timeout expires. Replies with the number of replicas that acknowledged.
+func (s *Server) handleWaitCommand(cmd WaitCommand, client *Client) {
+	if s.link != nil {
+		client.SendMessage(resp.EncodeError("WAIT cannot be used with replica instances"))
+		return
+	}
+
+	offset := s.replOffset
+	if acked := s.countAcked(offset); acked >= cmd.NumReplicas {
+		client.SendMessage(resp.EncodeInteger(int64(acked)))
+		return
+	}
+
+	w := &waitingClient{offset: offset, numReplicas: cmd.NumReplicas}
+	w.bc = &blockedClient{
+		client: client,
+		onTimeout: func() {
+			s.removeWaiting(w)
+			client.SendMessage(resp.EncodeInteger(int64(s.countAcked(offset))))
+		},
+	}
+	s.blockClient(w.bc, cmd.Timeout)
+	s.waiting = append(s.waiting, w)
+	s.replicate([][]byte{[]byte(CmdReplConf), []byte("GETACK"), []byte("*")})
+}
+
+// Must be called from the server loop.
+func (s *Server) removeWaiting(w *waitingClient) {
+	for i, waiting := range s.waiting {
+		if waiting == w {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// Replies to the clients blocked by WAIT whose writes were acknowledged by enough replicas, and
+// forgets the ones that disconnected.
+// Must be called from the server loop.
+func (s *Server) serveWaiting() {
+	var served []*Client
+	waiting := s.waiting[:0]
+	for _, w := range s.waiting {
+		if w.bc.client.blocked != w.bc {
+			continue
+		}
+
+		acked := s.countAcked(w.offset)
+		if acked < w.numReplicas {
+			waiting = append(waiting, w)
+			continue
+		}
+		s.unblockClient(w.bc)
+		w.bc.client.SendMessage(resp.EncodeInteger(int64(acked)))
+		served = append(served, w.bc.client)
+	}
+	clear(s.waiting[len(waiting):])
+	s.waiting = waiting
+
+	for _, client := range served {
+		s.processMessages(client.takePending())
+	}
+}
+
+// Handles REPLCONF commands. ACK records the offset acknowledged by a replica, GETACK from the primary
+// makes this replica acknowledge, and other options are accepted for compatibility.
+func (s *Server) handleReplConfCommand(cmd ReplConfCommand, client *Client) {
+	switch cmd.Option {
+	case "ACK":
+		// Never replied to, the replica is not reading replies
+		if r, ok := s.replicas[client]; ok && cmd.Offset > r.acked {
+			r.acked = cmd.Offset
+			s.serveWaiting()
+			s.checkFailover()
+		}
+	case "GETACK":
+		if client.primary && s.link != nil {
+			s.link.requestAck()
+		}
+	case "LISTENING-PORT":
+		client.listeningPort = cmd.Port
+		client.SendMessage(resp.EncodeSimpleString("OK"))
+	default:
+		client.SendMessage(resp.EncodeSimpleString("OK"))
+	}
+}
+
+// Handles the PSYNC command from a replica. Replicas are always fully resynchronized: the reply is
+// +FULLRESYNC with the replication ID and offset, followed by a snapshot as with SYNC and then the
+// replication stream, starting with the commands written while the snapshot was encoded.
+func (s *Server) handlePSyncCommand(cmd PSyncCommand, client *Client) {
+	if err := s.startSync(client); err != nil {
+		s.logger.Error("failed to handle PSYNC command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeSimpleString(fmt.Sprintf("FULLRESYNC %s %d", s.replID, s.replOffset)))
+	r := &replica{client: client}
+	if host, _, err := net.SplitHostPort(client.conn.RemoteAddr().String()); err == nil && client.listeningPort != "" {
+		r.addr = net.JoinHostPort(host, client.listeningPort)
+	}
+	s.replicas[client] = r
+	s.logger.Info("replica connected, starting full sync", "remoteAddr", client.conn.RemoteAddr().String())
+}
+
+// Starts streaming to a replica once its snapshot was sent, or forgets it if the snapshot failed.
+// Must be called from the server loop.
+func (s *Server) replicaSynced(client *Client, err error) {
+	r, ok := s.replicas[client]
+	if !ok {
+		return
+	}
+	if err != nil {
+		delete(s.replicas, client)
+		return
+	}
+
+	r.online = true
+	for _, data := range r.backlog {
+		s.feedReplica(r, data)
+	}
+	r.backlog = nil
+	s.logger.Info("replica synced", "remoteAddr", client.conn.RemoteAddr().String())
+}
+
+// Connection of a replica to its primary, run by runReplicationLink until stopped.
+type replicationLink struct {
+	addr string
+	stop chan struct{}
+
+	mu   sync.Mutex
+	conn net.Conn // Current connection, closed to interrupt the link when stopped
+
+	offset atomic.Int64  // Offset of the replication stream applied by the server loop
+	synced atomic.Bool   // The snapshot of the current connection was loaded
+	ackReq chan struct{} // Requests an acknowledgement to be sent to the primary
+}
+
+// Snapshot or command received from the primary, applied by the server loop.
+type replicationMessage struct {
+	link     *replicationLink
+	snapshot []byte  // Set for the snapshot starting a full sync
+	msg      Message // Set for a command of the replication stream
+	offset   int64   // Offset of the replication stream once the snapshot or command is applied
+}
+
+// Stops the link and closes its connection.
+func (l *replicationLink) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	close(l.stop)
+	if l.conn != nil {
+		l.conn.Close()
+	}
+}
+
+// Records the current connection of the link. Returns false if the link was stopped.
+func (l *replicationLink) setConn(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-l.stop:
+		return false
+	default:
+		l.conn = conn
+		return true
+	}
+}
+
+// Sets the primary this server replicates when it starts, as host:port.
+// Must be called before ListenAndServe.
+func (s *Server) SetReplicaOf(addr string) {
+	s.replicaOf = addr
+}
+
+// Makes the link send an acknowledgement to the primary, unless one is already requested.
+func (l *replicationLink) requestAck() {
+	select {
+	case l.ackReq <- struct{}{}:
+	default:
+	}
+}
+
+// Sends the offset applied by the replica to the primary when requested and every
+// replicationAckInterval, until done is closed or a write fails.
+func (l *replicationLink) sendAcks(conn net.Conn, done chan struct{}) {
+	ticker := time.NewTicker(replicationAckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-l.ackReq:
+		case <-done:
+			return
+		}
+
+		offset := strconv.FormatInt(l.offset.Load(), 10)
+		if _, err := conn.Write(resp.EncodeBulkStringArray([][]byte{[]byte(CmdReplConf), []byte("ACK"), []byte(offset)})); err != nil {
+			return
+		}
+	}
+}
+
+// Sets whether clients are allowed to write while this server replicates a primary. Replicas are
+// read-only by default, since writes made to a replica are not sent to the primary and are lost at
+// the next sync.
+// Must be called before ListenAndServe.
+func (s *Server) SetReplicaReadOnly(readOnly bool) {
+	s.writable = !readOnly
+}
+
+// Reports whether a command must be rejected because it writes to a read-only replica. Commands of
+// the primary are always applied. Custom commands are not known to write and always run.
+// Must be called from the server loop.
+func (s *Server) rejectsWrite(msg Message) bool {
+	if s.link == nil || s.writable || msg.client.primary {
+		return false
+	}
+	return s.isWriteCommand(msg.cmd)
+}
+
+// Reports whether a command may change the data.
+func (s *Server) isWriteCommand(cmd Command) bool {
+	switch cmd := cmd.(type) {
+	case SetCommand, GetOrSetCommand, DeleteCommand, DelIfEqCommand, ExpireCommand, ImportCommand, MigrateCommand,
+		PushCommand, PopCommand, BlockingPopCommand, MoveCommand,
+		HSetCommand, HSetNXCommand, HDelCommand, HIncrByCommand, HExpireCommand,
+		SAddCommand, SRemCommand,
+		ZAddCommand, ZRemCommand, ZRemRangeByRankCommand, ZRemRangeByScoreCommand, ZStoreCommand,
+		BitOpCommand, PFAddCommand, PFMergeCommand,
+		XAddCommand, XGroupCommand, XReadGroupCommand, XAckCommand, XAutoClaimCommand,
+		GeoAddCommand:
+		return true
+	case BitFieldCommand:
+		for _, op := range cmd.Ops {
+			if op.Kind != BitFieldGet {
+				return true
+			}
+		}
+		return false
+	case FCallCommand:
+		fn, exists := s.functions.Get(cmd.Name)
+		return exists && !fn.ReadOnly
+	default:
+		return false
+	}
+}
+
+// Handles the ROLE command from a client. A primary replies ["master", offset, [[host, port, offset], ...]]
+// with the replicas that sent their port, a replica ["slave", host, port, state, offset] where state is
+// "connected" once it loaded the snapshot of its primary and "connect" otherwise.
+func (s *Server) handleRoleCommand(client *Client) {
+	if s.link != nil {
+		host, port, _ := net.SplitHostPort(s.link.addr)
+		portNum, _ := strconv.Atoi(port)
+		state := "connect"
+		if s.link.synced.Load() {
+			state = "connected"
+		}
+
+		client.SendMessage(resp.EncodeArray([][]byte{
+			resp.EncodeBulkString([]byte("slave")),
+			resp.EncodeBulkString([]byte(host)),
+			resp.EncodeInteger(int64(portNum)),
+			resp.EncodeBulkString([]byte(state)),
+			resp.EncodeInteger(s.link.offset.Load()),
+		}))
+		return
+	}
+
+	replicas := make([][]byte, 0, len(s.replicas))
+	for _, r := range s.replicas {
+		if r.addr == "" {
+			continue
+		}
+		host, port, _ := net.SplitHostPort(r.addr)
+		replicas = append(replicas, resp.EncodeBulkStringArray([][]byte{
+			[]byte(host),
+			[]byte(port),
+			[]byte(strconv.FormatInt(r.acked, 10)),
+		}))
+	}
+	client.SendMessage(resp.EncodeArray([][]byte{
+		resp.EncodeBulkString([]byte("master")),
+		resp.EncodeInteger(s.replOffset),
+		resp.EncodeArray(replicas),
+	}))
+}
+
+// Handles REPLICAOF and SLAVEOF commands from a client. REPLICAOF host port drops the current data
+// and replicates the given primary, REPLICAOF NO ONE stops replicating and keeps the data.
+func (s *Server) handleReplicaOfCommand(cmd ReplicaOfCommand, client *Client) {
+	if cmd.Addr == "" {
+		if s.link != nil {
+			s.stopReplication()
+			s.setPassiveExpiry(false)
+			s.logger.Info("stopped replicating, now a primary")
+		}
+		client.SendMessage(resp.EncodeSimpleString("OK"))
+		return
+	}
+
+	if s.link != nil && s.link.addr == cmd.Addr {
+		client.SendMessage(resp.EncodeSimpleString("OK Already connected to specified primary"))
+		return
+	}
+	if _, ok := s.store.(replicaStore); !ok {
+		client.SendMessage(resp.EncodeError("the store does not support replication"))
+		return
+	}
+
+	s.stopReplication()
+	s.startReplication(cmd.Addr)
+	client.SendMessage(resp.EncodeSimpleString("OK"))
+}
+
+// Connects to a primary and applies its replication stream until stopReplication.
+// Must be called from the server loop, or before it starts.
+func (s *Server) startReplication(addr string) {
+	s.link = &replicationLink{addr: addr, stop: make(chan struct{}), ackReq: make(chan struct{}, 1)}
+	s.setPassiveExpiry(true)
+	s.logger.Info("replicating primary", "primary", addr)
+	go s.runReplicationLink(s.link)
+}
+
+// Must be called from the server loop.
+func (s *Server) stopReplication() {
+	if s.link != nil {
+		s.link.close()
+		s.link = nil
+	}
+}
+
+// Leaves expired keys for the primary to delete while replicating, so replicas do not diverge
+// because of clock skew. Primaries propagate their expirations, see propagateRemovals.
+func (s *Server) setPassiveExpiry(passive bool) {
+	if tracker, ok := s.store.(removalTracker); ok {
+		tracker.SetPassiveExpiry(passive)
+	}
+}
+
+// Keeps a replica in sync with its primary, reconnecting and resyncing whenever the connection is lost.
+func (s *Server) runReplicationLink(link *replicationLink) {
+	for {
+		err := s.syncWithPrimary(link)
+		link.synced.Store(false)
+
+		select {
+		case <-link.stop:
+			return
+		default:
+		}
+		s.logger.Warn("lost connection to primary, reconnecting", "primary", link.addr, "error", err)
+
+		select {
+		case <-link.stop:
+			return
+		case <-time.After(replicationRetryDelay):
+		}
+	}
+}
+
+// Connects to the primary, loads its snapshot and applies its replication stream until the connection
+// fails or the link is stopped.
+func (s *Server) syncWithPrimary(link *replicationLink) error {
+	conn, err := net.DialTimeout("tcp", link.addr, replicationDialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if !link.setConn(conn) {
+		return nil
+	}
+
+	reader := bufio.NewReader(conn)
+
+	s.configMu.RLock()
+	password := s.primaryAuth
+	s.configMu.RUnlock()
+	if password != "" {
+		if _, err := conn.Write(resp.EncodeBulkStringArray([][]byte{[]byte(CmdAuth), []byte(password)})); err != nil {
+			return err
+		}
+		val, err := resp.ReadRESP(reader)
+		if err != nil {
+			return err
+		}
+		if _, ok := val.(resp.RespSimpleString); !ok {
+			return fmt.Errorf("unexpected reply to AUTH: %v", val)
+		}
+	}
+
+	// The primary needs the port to promote this replica with FAILOVER
+	if addr, ok := s.ln.Addr().(*net.TCPAddr); ok {
+		port := strconv.Itoa(addr.Port)
+		if _, err := conn.Write(resp.EncodeBulkStringArray([][]byte{[]byte(CmdReplConf), []byte("LISTENING-PORT"), []byte(port)})); err != nil {
+			return err
+		}
+		val, err := resp.ReadRESP(reader)
+		if err != nil {
+			return err
+		}
+		if _, ok := val.(resp.RespSimpleString); !ok {
+			return fmt.Errorf("unexpected reply to REPLCONF: %v", val)
+		}
+	}
+
+	if _, err := conn.Write(resp.EncodeBulkStringArray([][]byte{[]byte(CmdPSync), []byte("?"), []byte("-1")})); err != nil {
+		return err
+	}
+
+	val, err := resp.ReadRESP(reader)
+	if err != nil {
+		return err
+	}
+	reply, ok := val.(resp.RespSimpleString)
+	fields := strings.Fields(reply.Value)
+	if !ok || len(fields) != 3 || fields[0] != "FULLRESYNC" {
+		return fmt.Errorf("unexpected reply to PSYNC: %v", val)
+	}
+	offset, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid replication offset in reply to PSYNC: %v", val)
+	}
+
+	val, err = resp.ReadRESP(reader)
+	if err != nil {
+		return err
+	}
+	snapshot, ok := val.(resp.RespBulkString)
+	if !ok || snapshot.Value == nil {
+		return fmt.Errorf("expected a snapshot from the primary, got %v", val)
+	}
+	if !s.sendReplicationMessage(replicationMessage{link: link, snapshot: snapshot.Value, offset: offset}) {
+		return nil
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go link.sendAcks(conn, done)
+
+	// Replies to the commands of the primary are dropped
+	client := NewClient(conn, nil, nil, s.logger)
+	client.replyOff = true
+	client.primary = true
+	for {
+		val, err := resp.ReadRESP(reader)
+		if err != nil {
+			return err
+		}
+		arr, ok := val.(resp.RespArray)
+		if !ok || len(arr.Elements) == 0 {
+			return fmt.Errorf("expected a command from the primary, got %v", val)
+		}
+		// Primaries send commands as arrays of bulk strings, encoded the same way again
+		offset += int64(len(resp.EncodeBulkStringArray(commandArgs(arr))))
+
+		cmd, err := parseCommand(arr, s.commands)
+		if err != nil {
+			s.logger.Error("failed to parse command from primary", "error", err)
+			continue
+		}
+		if !s.sendReplicationMessage(replicationMessage{link: link, msg: Message{cmd: cmd, args: commandArgs(arr), client: client}, offset: offset}) {
+			return nil
+		}
+	}
+}
+
+// Passes a message of the primary to the server loop. Returns false if the link was stopped.
+func (s *Server) sendReplicationMessage(msg replicationMessage) bool {
+	select {
+	case s.replCh <- msg:
+		return true
+	case <-msg.link.stop:
+		return false
+	}
+}
+
+// Applies a snapshot or command received from the primary, unless the link was replaced meanwhile.
+// Must be called from the server loop.
+func (s *Server) handleReplicationMessage(msg replicationMessage) {
+	if msg.link != s.link {
+		return
+	}
+
+	if msg.snapshot == nil {
+		s.processMessages([]Message{msg.msg})
+		msg.link.offset.Store(msg.offset)
+		return
+	}
+
+	if err := s.loadPrimarySnapshot(msg.snapshot); err != nil {
+		// Retried with a new connection, the stream does not make sense without the snapshot
+		s.logger.Error("failed to load snapshot from primary", "primary", msg.link.addr, "error", err)
+		s.stopReplication()
+		s.startReplication(msg.link.addr)
+		return
+	}
+	msg.link.offset.Store(msg.offset)
+	msg.link.synced.Store(true)
+}
+
+// Replaces the data with the snapshot of the primary. The snapshot file is rewritten right away when
+// enabled, so a restart does not bring back the data of before the sync.
+// Must be called from the server loop.
+func (s *Server) loadPrimarySnapshot(data []byte) error {
+	store := s.store.(replicaStore)
+	if err := store.Flush(); err != nil {
+		return err
+	}
+	keys, err := store.LoadSnapshot(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	s.logger.Info("loaded snapshot from primary", "primary", s.link.addr, "keys", keys)
+
+	// Replicas of this server hold the data of before the sync and must resync too
+	for client := range s.replicas {
+		delete(s.replicas, client)
+		client.conn.Close()
+	}
+
+	if s.snapshotPath != "" && !s.bgsaving {
+		if err := s.saveSnapshot(); err != nil {
+			s.logger.Error("failed to save snapshot", "path", s.snapshotPath, "error", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Runs a server on a random local port until the test ends, calling configure before it starts.
+// Returns the server and its address.
+func startTestServer(t *testing.T, configure ...func(s *Server, addr string)) (*Server, string) {
+	s := newTestServer(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ln = ln
+	for _, fn := range configure {
+		fn(s, ln.Addr().String())
+	}
+
+	s.wg.Add(2)
+	go s.serverLoop()
+	go s.acceptLoop()
+	t.Cleanup(s.stop)
+
+	return s, ln.Addr().String()
+}
+
+// Connection to a test server sending one command at a time.
+type testConn struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialTestServer(t *testing.T, addr string) *testConn {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testConn{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// Sends a command and returns its reply.
+func (c *testConn) do(args ...string) resp.RespValue {
+	encoded := make([][]byte, len(args))
+	for i, arg := range args {
+		encoded[i] = []byte(arg)
+	}
+	if _, err := c.conn.Write(resp.EncodeBulkStringArray(encoded)); err != nil {
+		c.t.Fatal(err)
+	}
+
+	val, err := resp.ReadRESP(c.reader)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return val
+}
+
+// Sends a command until its reply is a bulk string equal to expected, failing after a second.
+func (c *testConn) waitFor(expected string, args ...string) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		val := c.do(args...)
+		if bulk, ok := val.(resp.RespBulkString); ok && string(bulk.Value) == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatalf("Expected %v to reply %q, got %v", args, expected, val)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	_, primaryAddr := startTestServer(t)
+	replicaServer, replicaAddr := startTestServer(t)
+	primary := dialTestServer(t, primaryAddr)
+	replica := dialTestServer(t, replicaAddr)
+
+	primary.do("SET", "before", "1")
+	replica.do("SET", "stale", "value")
+	host, port, _ := net.SplitHostPort(primaryAddr)
+	if val := replica.do("REPLICAOF", host, port); val != (resp.RespSimpleString{Value: "OK"}) {
+		t.Fatalf("Expected REPLICAOF to reply OK, got %v", val)
+	}
+
+	// The replica gets the snapshot, dropping its own data, then the stream of writes
+	replica.waitFor("1", "GET", "before")
+	if value, _ := replicaServer.store.GetValue([]byte("stale")); value != nil {
+		t.Errorf("Expected the data of the replica to be replaced, got %q", value)
+	}
+
+	primary.do("RPUSH", "queue", "a", "b")
+	primary.do("LPOP", "queue")
+	primary.do("SET", "after", "2", "EX", "100")
+	replica.waitFor("2", "GET", "after")
+	if list, _ := replicaServer.store.GetList([]byte("queue")); len(list) != 1 || string(list[0]) != "b" {
+		t.Errorf("Expected the pop to be replicated, got %q", list)
+	}
+	if expiresAt := replicaServer.store.ExpiresAt([]byte("after")); expiresAt <= 0 {
+		t.Errorf("Expected the expiration to be replicated, got %d", expiresAt)
+	}
+	if role, ok := replica.do("ROLE").(resp.RespArray); !ok || len(role.Elements) != 5 || string(role.Elements[0].(resp.RespBulkString).Value) != "slave" {
+		t.Errorf("Expected ROLE to report a replica, got %v", role)
+	}
+	if role, ok := primary.do("ROLE").(resp.RespArray); !ok || len(role.Elements) != 3 || len(role.Elements[2].(resp.RespArray).Elements) != 1 {
+		t.Errorf("Expected ROLE to report a primary with 1 replica, got %v", role)
+	}
+
+	if val := replica.do("REPLICAOF", "NO", "ONE"); val != (resp.RespSimpleString{Value: "OK"}) {
+		t.Fatalf("Expected REPLICAOF NO ONE to reply OK, got %v", val)
+	}
+	primary.do("SET", "ignored", "3")
+	time.Sleep(50 * time.Millisecond)
+	if value, _ := replicaServer.store.GetValue([]byte("ignored")); value != nil {
+		t.Errorf("Expected writes after REPLICAOF NO ONE not to be replicated, got %q", value)
+	}
+}
+
+func TestReplicationExpiry(t *testing.T) {
+	_, primaryAddr := startTestServer(t)
+	replicaServer, replicaAddr := startTestServer(t)
+	primary := dialTestServer(t, primaryAddr)
+	replica := dialTestServer(t, replicaAddr)
+
+	host, port, _ := net.SplitHostPort(primaryAddr)
+	replica.do("REPLICAOF", host, port)
+	primary.do("SET", "key", "value", "PX", "100")
+	primary.do("HSET", "hash", "f1", "v1", "f2", "v2")
+	primary.do("HPEXPIRE", "hash", "100", "FIELDS", "1", "f1")
+	replica.waitFor("value", "GET", "key")
+
+	// The replica leaves expired keys to the primary, which deletes them with DEL and HDEL
+	store := replicaServer.store.(*InMemoryKVStore)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		store.mu.Lock()
+		_, keyLeft := store.shard("key").entries["key"]
+		_, fieldLeft := store.shard("hash").entries["hash"].hash["f1"]
+		store.mu.Unlock()
+		if !keyLeft && !fieldLeft {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the expirations of the primary to be replicated")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if val, ok := replica.do("HGET", "hash", "f2").(resp.RespBulkString); !ok || string(val.Value) != "v2" {
+		t.Errorf("Expected the other fields to be kept, got %v", val)
+	}
+}
+
+func TestReplicaReadOnly(t *testing.T) {
+	_, primaryAddr := startTestServer(t)
+	_, replicaAddr := startTestServer(t)
+	primary := dialTestServer(t, primaryAddr)
+	replica := dialTestServer(t, replicaAddr)
+
+	host, port, _ := net.SplitHostPort(primaryAddr)
+	replica.do("REPLICAOF", host, port)
+	primary.do("SET", "key", "primary")
+	replica.waitFor("primary", "GET", "key")
+
+	readOnly := resp.RespErrorValue{Message: "READONLY You can't write against a read only replica."}
+	for _, args := range [][]string{
+		{"SET", "key", "replica"},
+		{"DEL", "key"},
+		{"RPUSH", "list", "a"},
+		{"BITFIELD", "bits", "SET", "u8", "0", "1"},
+	} {
+		if val := replica.do(args...); val != readOnly {
+			t.Errorf("Expected %v to be rejected, got %v", args, val)
+		}
+	}
+	if val, ok := replica.do("BITFIELD", "bits", "GET", "u8", "0").(resp.RespArray); !ok || len(val.Elements) != 1 {
+		t.Errorf("Expected BITFIELD GET to be allowed, got %v", val)
+	}
+
+	// Writes of the primary are still applied
+	primary.do("SET", "key", "updated")
+	replica.waitFor("updated", "GET", "key")
+}
+
+func TestWait(t *testing.T) {
+	_, primaryAddr := startTestServer(t)
+	_, replicaAddr := startTestServer(t)
+	primary := dialTestServer(t, primaryAddr)
+	replica := dialTestServer(t, replicaAddr)
+
+	if val := primary.do("WAIT", "1", "10"); val != (resp.RespInteger{Value: 0}) {
+		t.Errorf("Expected WAIT without replicas to time out with 0, got %v", val)
+	}
+
+	host, port, _ := net.SplitHostPort(primaryAddr)
+	replica.do("REPLICAOF", host, port)
+	primary.do("SET", "key", "1")
+	replica.waitFor("1", "GET", "key")
+
+	primary.do("SET", "key", "2")
+	if val := primary.do("WAIT", "1", "0"); val != (resp.RespInteger{Value: 1}) {
+		t.Errorf("Expected WAIT to reply once the replica acknowledged, got %v", val)
+	}
+	if value, _ := primary.do("GET", "key").(resp.RespBulkString); string(value.Value) != "2" {
+		t.Errorf("Expected the primary to keep serving after WAIT, got %q", value.Value)
+	}
+
+	start := time.Now()
+	if val := primary.do("WAIT", "2", "50"); val != (resp.RespInteger{Value: 1}) {
+		t.Errorf("Expected WAIT to reply the replicas that acknowledged once it timed out, got %v", val)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected WAIT to block until the timeout, returned after %v", elapsed)
+	}
+
+	if _, ok := replica.do("WAIT", "0", "0").(resp.RespErrorValue); !ok {
+		t.Error("Expected WAIT to be rejected on a replica")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	_, primaryAddr := startTestServer(t)
+	_, replicaAddr := startTestServer(t)
+	primary := dialTestServer(t, primaryAddr)
+	replica := dialTestServer(t, replicaAddr)
+
+	if _, ok := primary.do("FAILOVER").(resp.RespErrorValue); !ok {
+		t.Error("Expected FAILOVER without replicas to fail")
+	}
+	if _, ok := primary.do("FAILOVER", "ABORT").(resp.RespErrorValue); !ok {
+		t.Error("Expected FAILOVER ABORT without a failover to fail")
+	}
+
+	host, port, _ := net.SplitHostPort(primaryAddr)
+	replica.do("REPLICAOF", host, port)
+	primary.do("SET", "key", "1")
+	replica.waitFor("1", "GET", "key")
+
+	if val := primary.do("FAILOVER", "TIMEOUT", "1000"); val != (resp.RespSimpleString{Value: "OK"}) {
+		t.Fatalf("Expected FAILOVER to reply OK, got %v", val)
+	}
+	// Paused until the failover ends, then rejected by the new replica
+	readOnly := resp.RespErrorValue{Message: "READONLY You can't write against a read only replica."}
+	if val := primary.do("SET", "key", "2"); val != readOnly {
+		t.Errorf("Expected writes to the former primary to be rejected, got %v", val)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		val := replica.do("SET", "key", "3")
+		if val == (resp.RespSimpleString{Value: "OK"}) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the replica to be promoted, got %v", val)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	primary.waitFor("3", "GET", "key")
+
+	if val := replica.do("WAIT", "1", "1000"); val != (resp.RespInteger{Value: 1}) {
+		t.Errorf("Expected the former primary to replicate the new one, got %v", val)
+	}
+}
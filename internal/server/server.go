@@ -1,23 +1,25 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net"
 	"net/url"
-	"os"
-	"os/signal"
+	"runtime"
+	"strconv"
 	"sync"
-	"syscall"
 	"time"
 
+	"github.com/CDavidSV/GopherStore/internal/encryption"
 	"github.com/CDavidSV/GopherStore/internal/resp"
 	"github.com/CDavidSV/GopherStore/internal/util"
 )
 
 type Message struct {
 	cmd    Command
+	args   [][]byte // The command as received, including its name, written to the append-only file
 	client *Client
 }
 
@@ -32,8 +34,108 @@ type Server struct {
 	msgCh   chan Message
 	quitCh  chan struct{}
 	store   KVStore
+	faults  *faultInjector // nil unless fault injection is enabled
+	latency *latencyMonitor
+
+	stopOnce  sync.Once
+	stoppedCh chan struct{} // Closed once the server stopped
+
+	stats serverStats // Reported by Metrics
+
+	functions *FunctionLibrary              // Server-side functions callable with FCALL
+	commands  map[CommandName]customCommand // Commands registered by the embedding application
+	policy    *commandPolicy                // Renamed and restricted commands, nil if none
+
+	// Pub/sub subscribers by channel and pattern, owned by the server loop
+	channels      map[string]subscribers
+	patterns      map[string]subscribers
+	shardChannels map[string]subscribers // Shard channels, all owned by this node without cluster mode
+
+	keyspaceEvents KeyspaceEventFlags // Keyspace events published over pub/sub
+	eventCh        chan KeyspaceEvent // Changes from the store waiting to be handled, nil until watched
+
+	// Clients with CLIENT TRACKING by the keys they read, and those in broadcast mode, owned by the server loop
+	trackedKeys   map[string]subscribers
+	trackingBcast subscribers
+
+	// Clients parked by blocking commands, owned by the server loop
+	blockedByKey map[string][]*blockedClient
+	readyKeys    []string
+	timeoutCh    chan *blockedClient
+
+	// Append-only file, owned by the server loop once started
+	aofPath     string // Empty when the append-only file is disabled
+	aofPolicy   FsyncPolicy
+	aof         *appendOnlyFile
+	heldClients []*Client // Clients whose replies wait for the append-only file to be synced
+
+	persistKeys encryption.KeyProvider // Encrypts the append-only file and snapshots, nil to write them in plaintext
+
+	snapshotPath string          // Empty when snapshots are disabled
+	bgsaving     bool            // A BGSAVE is running, owned by the server loop
+	bgsaveDone   chan error      // Result of the running BGSAVE
+	syncDone     chan syncResult // Snapshots encoded for SYNC
+	syncing      int             // Snapshots being encoded for SYNC, owned by the server loop
+	bgsaveDirty  uint64          // Store changes when the running BGSAVE started
+	saveRules    []SaveRule      // Automatic BGSAVE triggers
+	lastSave     time.Time       // Last successful snapshot, or when the server started
+	lastSaveErr  error           // Result of the last BGSAVE
+	lastBGSave   time.Time       // When the last BGSAVE started
+	dirtySave    uint64          // Store changes included in the last successful snapshot
+
+	// Replication, owned by the server loop
+	replID     string                  // Identifies the replication stream sent to replicas
+	replOffset int64                   // Bytes of the replication stream sent so far
+	replicas   map[*Client]*replica    // Replicas connected with PSYNC
+	waiting    []*waitingClient        // Clients blocked by WAIT, in the order they blocked
+	replicaOf  string                  // Primary replicated from the start, set by SetReplicaOf
+	link       *replicationLink        // Connection to the primary, nil when this server is a primary
+	replCh     chan replicationMessage // Snapshots and commands received from the primary
+	writable   bool                    // Clients may write while replicating, set by SetReplicaReadOnly
+	failover   *failover               // Failover in progress started by FAILOVER, nil otherwise
+	failoverCh chan *failover          // Failovers whose timeout expired
+	removalCh  <-chan struct{}         // Signaled when the store removed expired keys, nil if it cannot report them
+
+	cluster *cluster // nil unless cluster mode is enabled
+
+	// Settings read outside the server loop, guarded by configMu since CONFIG SET changes them
+	configMu    sync.RWMutex
+	limits      resp.Limits // Limits on the commands read from clients
+	requirePass string      // Password clients authenticate with, empty if not required
+	primaryAuth string      // Password sent to the primary of this server, empty if none
+
+	tcpKeepAlive time.Duration // Period of the keepalive probes sent to idle peers, 0 disables them
+	readTimeout  time.Duration // Clients idle for longer are disconnected, 0 disables it
+	writeTimeout time.Duration // Clients that do not accept a reply for longer are disconnected, 0 disables it
+
+	ttlPolicy *ExpirationPolicy // Default TTLs for keys written without an explicit expiration
+	ttlJitter float64           // Maximum fraction of random jitter added to EX and PX expirations
+	logLevel  *slog.LevelVar    // Level of the logger changed by CONFIG SET, nil if it cannot be changed
+
+	// Configuration file reloaded by ReloadConfig and written by CONFIG REWRITE, see SetConfigFile
+	configFile       string
+	configOverridden map[string]bool // Settings given on the command line or by environment variables
+	configChanged    map[string]bool // Settings changed with CONFIG SET, owned by the server loop
+	reloadCh         chan chan error // Reloads requested by ReloadConfig, receiving their result
+
+	// Lifecycle hooks, run in registration order
+	onStart            []LifecycleHook
+	onShutdownBegin    []LifecycleHook
+	onShutdownComplete []LifecycleHook
+
+	// Read commands run outside the server loop, see SetIOThreads
+	ioThreads int           // Threads executing commands, counting the server loop
+	workers   chan struct{} // Holds a slot per read command running outside the loop, nil runs every command on the loop
+
+	// Graceful shutdown, see SetShutdownTimeout
+	shutdownTimeout time.Duration
+	draining        bool      // Set while the server drains, owned by the server loop
+	drainDeadline   time.Time // When the drain gives up on the remaining clients
 }
 
+// LifecycleHook is a callback run at a specific point of the server lifecycle.
+type LifecycleHook func() error
+
 // Creates a new server instance.
 func NewServer(logger *slog.Logger, hostName string, store KVStore) *Server {
 	urlVal := fmt.Sprintf("tcp://%s", hostName)
@@ -43,7 +145,12 @@ func NewServer(logger *slog.Logger, hostName string, store KVStore) *Server {
 		return nil
 	}
 
-	return &Server{
+	var removalCh <-chan struct{}
+	if tracker, ok := store.(removalTracker); ok {
+		removalCh = tracker.TrackRemovals()
+	}
+
+	s := &Server{
 		logger:  logger,
 		host:    parsedHost,
 		regCh:   make(chan *Client),
@@ -52,51 +159,310 @@ func NewServer(logger *slog.Logger, hostName string, store KVStore) *Server {
 		quitCh:  make(chan struct{}),
 		clients: make(map[*Client]struct{}),
 		store:   store,
+
+		functions: NewFunctionLibrary(),
+		commands:  make(map[CommandName]customCommand),
+
+		channels:      make(map[string]subscribers),
+		patterns:      make(map[string]subscribers),
+		shardChannels: make(map[string]subscribers),
+
+		trackedKeys:   make(map[string]subscribers),
+		trackingBcast: make(subscribers),
+
+		blockedByKey: make(map[string][]*blockedClient),
+		timeoutCh:    make(chan *blockedClient),
+
+		bgsaveDone: make(chan error, 1),
+		syncDone:   make(chan syncResult),
+
+		replID:     newReplicationID(),
+		replicas:   make(map[*Client]*replica),
+		replCh:     make(chan replicationMessage),
+		failoverCh: make(chan *failover),
+		removalCh:  removalCh,
+		lastSave:   time.Now(),
+
+		stoppedCh: make(chan struct{}),
+		reloadCh:  make(chan chan error),
+
+		limits:       resp.DefaultLimits,
+		tcpKeepAlive: DefaultTCPKeepAlive,
+		latency:      newLatencyMonitor(),
+
+		shutdownTimeout: DefaultShutdownTimeout,
+	}
+	s.SetIOThreads(0)
+	if reporter, ok := store.(latencyReporter); ok {
+		reporter.OnLatency(s.latency.record)
 	}
+	return s
 }
 
-// Starts the server and begins listening for incoming connections.
-func (s *Server) Start() error {
-	listener, err := net.Listen(s.host.Scheme, s.host.Host)
-	if err != nil {
+// Enables the DEBUG fault injection commands (latency, disconnects and error replies).
+// Must be called before ListenAndServe. Intended for testing clients only.
+func (s *Server) EnableFaultInjection() {
+	s.faults = newFaultInjector()
+}
+
+// Sets the limits on the commands read from clients. Commands exceeding them are rejected with a
+// protocol error before their data is read. Changes made after ListenAndServe apply to new connections.
+func (s *Server) SetRequestLimits(limits resp.Limits) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	s.limits = limits
+}
+
+// Default period of the TCP keepalive probes, like Redis' tcp-keepalive.
+const DefaultTCPKeepAlive = 300 * time.Second
+
+// Sets the period of the TCP keepalive probes sent to idle clients, so connections to dead peers are
+// detected and closed. 0 disables keepalives. Changes made after ListenAndServe apply to new connections.
+func (s *Server) SetTCPKeepAlive(period time.Duration) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	s.tcpKeepAlive = period
+}
+
+// Sets the time a client may stay idle before it is disconnected, like Redis' timeout. Blocked
+// clients, subscribers and replicas wait without sending commands and are exempt. 0 disables it.
+// Changes made after ListenAndServe apply to new connections.
+func (s *Server) SetReadTimeout(timeout time.Duration) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	s.readTimeout = timeout
+}
+
+// Sets the time writing replies to a client may take before it is disconnected, so a client that
+// stopped reading cannot wedge its connection forever. 0 disables it. Changes made after ListenAndServe
+// apply to new connections.
+func (s *Server) SetWriteTimeout(timeout time.Duration) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	s.writeTimeout = timeout
+}
+
+// Sets the default expiration policy applied to keys written without an explicit expiration.
+// Must be called before ListenAndServe.
+func (s *Server) SetExpirationPolicy(policy *ExpirationPolicy) {
+	s.ttlPolicy = policy
+}
+
+// Sets the maximum fraction of random jitter added to expirations set with EX or PX, e.g. 0.05 for ±5%.
+// Must be between 0 and 1, 0 disables jitter. Must be called before ListenAndServe.
+func (s *Server) SetTTLJitter(fraction float64) {
+	s.ttlJitter = fraction
+}
+
+// Returns the library of server-side functions callable with FCALL, to register functions directly or
+// load them from plugins. Functions must be registered before ListenAndServe.
+func (s *Server) Functions() *FunctionLibrary {
+	return s.functions
+}
+
+// Registers a hook that runs once the server is accepting connections.
+// If a hook fails or panics the server shuts down and ListenAndServe returns the error.
+// Must be called before ListenAndServe.
+func (s *Server) OnStart(hook LifecycleHook) {
+	s.onStart = append(s.onStart, hook)
+}
+
+// Default time given to the server to drain when it shuts down.
+const DefaultShutdownTimeout = 10 * time.Second
+
+// Sets the time the server drains when it shuts down: it stops accepting connections and reading
+// commands, runs the commands already read and sends the replies queued for every client. Clients
+// still connected once the timeout expires are dropped. 0 drops them right away. Must be called
+// before ListenAndServe.
+func (s *Server) SetShutdownTimeout(timeout time.Duration) {
+	s.shutdownTimeout = timeout
+}
+
+// Registers a hook that runs when shutdown begins, while clients are still connected and the store is open.
+// A hook failing or panicking is logged and does not stop the shutdown. Must be called before ListenAndServe.
+func (s *Server) OnShutdownBegin(hook LifecycleHook) {
+	s.onShutdownBegin = append(s.onShutdownBegin, hook)
+}
+
+// Registers a hook that runs after all clients are disconnected and the store is closed.
+// A hook failing or panicking is logged and the next hooks still run. Must be called before ListenAndServe.
+func (s *Server) OnShutdownComplete(hook LifecycleHook) {
+	s.onShutdownComplete = append(s.onShutdownComplete, hook)
+}
+
+// Runs hooks in order, logging failures. Stops at the first failure if stopOnError is set.
+func (s *Server) runHooks(stage string, hooks []LifecycleHook, stopOnError bool) error {
+	for i, hook := range hooks {
+		if err := runHook(hook); err != nil {
+			s.logger.Error("lifecycle hook failed", "stage", stage, "hook", i, "error", err)
+			if stopOnError {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Runs a hook, returning a panic as an error so a failing hook can't stop the server from shutting down.
+func runHook(hook LifecycleHook) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("hook panicked: %v", r)
+		}
+	}()
+
+	return hook()
+}
+
+// Stops the server loops, running the shutdown hooks around it. Calls after the first wait for the
+// same shutdown.
+func (s *Server) stop() {
+	s.stopOnce.Do(func() {
+		s.runHooks("shutdown-begin", s.onShutdownBegin, false)
+
+		close(s.quitCh)
+		s.wg.Wait()
+
+		s.runHooks("shutdown-complete", s.onShutdownComplete, false)
+		close(s.stoppedCh)
+	})
+}
+
+// Restores the store, starts listening for connections and returns once the server is started. It
+// then runs in the background until Shutdown. If a start hook fails the server shuts down and the
+// error is returned.
+func (s *Server) ListenAndServe() error {
+	// The store is restored before accepting connections, so clients never see it partially loaded
+	if err := s.restore(); err != nil {
 		return err
 	}
-	s.ln = listener
+	s.dirtySave = s.store.Dirty() // Loaded keys are already persisted
+	if s.ln == nil {
+		listener, err := net.Listen(s.host.Scheme, s.host.Host)
+		if err != nil {
+			return err
+		}
+		s.ln = listener
+	}
+
+	if s.replicaOf != "" {
+		s.startReplication(s.replicaOf)
+	}
+	if s.cluster != nil {
+		go s.runClusterGossip()
+	}
 
 	s.wg.Add(2)
 	go s.serverLoop()
 	go s.acceptLoop()
 
-	s.logger.Info("server started", "host", s.host.String())
+	if err := s.runHooks("start", s.onStart, true); err != nil {
+		s.logger.Info("Shutting down server after failed start hook...")
+		s.stop()
+		return err
+	}
+
+	s.logger.Info("server started", "addr", s.ln.Addr().String())
+	return nil
+}
+
+// Sets a listener already open, e.g. passed by systemd socket activation, that ListenAndServe accepts
+// connections from instead of listening on the host of the server. It is closed when the server
+// shuts down. Must be called before ListenAndServe.
+func (s *Server) SetListener(ln net.Listener) {
+	s.ln = ln
+}
 
-	// Wait for interrupt signal to stop the server.
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	<-c
+// Returns the address the server listens on once ListenAndServe returned, e.g. to find the port
+// picked for port 0.
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
 
-	s.logger.Info("Shutting down server...")
-	close(s.quitCh)
-	s.wg.Wait()
+// Shuts the server down: drains it, see SetShutdownTimeout, then writes the snapshot and closes the
+// append-only file and the store, running the shutdown hooks around it. Returns once the server
+// stopped, or the error of ctx if it is done first, while the server keeps stopping in the
+// background. Calls after the first wait for the same shutdown.
+func (s *Server) Shutdown(ctx context.Context) error {
+	go s.stop()
 
-	s.logger.Info("Server stopped")
-	return nil
+	select {
+	case <-s.stoppedCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // Adds a new connected client to the server's client map.
 func (s *Server) registerClient(client *Client) {
 	s.logger.Info("new client connected", "remoteAddr", client.conn.RemoteAddr().String())
 	s.clients[client] = struct{}{}
+	s.stats.connectedClients.Add(1)
+	s.stats.connectionsReceived.Add(1)
 }
 
-// Removes a client from the server's client map.
+// Removes a client from the server's client map and stops reading from it. Its writer then sends
+// the replies already queued and closes the connection, until the drain deadline while the server
+// drains and right away otherwise, since the client is gone or failed.
 func (s *Server) deregisterClient(client *Client) {
-	client.conn.Close()
+	if _, ok := s.clients[client]; !ok {
+		return
+	}
+	if client.blocked != nil {
+		s.unblockClient(client.blocked)
+	}
+	client.pending = nil
+	delete(s.replicas, client)
+	s.unsubscribeAll(client)
+	s.disableTracking(client)
+
+	deadline := time.Now()
+	if s.draining {
+		deadline = s.drainDeadline
+	}
+	client.stopReading()
+	client.conn.SetWriteDeadline(deadline)
+	close(client.closeCh)
+	s.stats.connectedClients.Add(-1)
 	s.logger.Info("client disconnected", "remoteAddr", client.conn.RemoteAddr().String())
 	delete(s.clients, client)
 }
 
+// Returns the expiration time in unix nanoseconds for a TTL, or the absolute time when it is set.
+func absoluteExpiration(ttl time.Duration, at time.Time) int64 {
+	if !at.IsZero() {
+		return at.UnixNano()
+	}
+	return time.Now().Add(ttl).UnixNano()
+}
+
+// Returns the absolute expiration time for a key being written, using the explicit expiration
+// with jitter when given and falling back to the expiration policy. Returns -1 for no expiration.
+func (s *Server) resolveExpiration(key []byte, expiration *time.Duration) int64 {
+	if expiration != nil {
+		return time.Now().Add(jitterTTL(*expiration, s.ttlJitter)).UnixNano()
+	}
+
+	if ttl, ok := s.ttlPolicy.TTLFor(key); ok {
+		return time.Now().Add(ttl).UnixNano()
+	}
+
+	return -1
+}
+
 // Responds to a PING command from a client.
 func (s *Server) handlePingCommand(cmd PingCommand, client *Client) {
+	// Subscribed clients get the reply in the same format as pub/sub messages
+	if client.subscriptionCount() > 0 {
+		client.SendMessage(resp.EncodeBulkStringArray([][]byte{[]byte("pong"), []byte(cmd.Value)}))
+		return
+	}
+
 	response := "PONG"
 	if cmd.Value != "" {
 		response = cmd.Value
@@ -108,36 +474,21 @@ func (s *Server) handlePingCommand(cmd PingCommand, client *Client) {
 
 // Handles a SET command from a client.
 func (s *Server) handleSetCommand(cmd SetCommand, client *Client) {
-	value, err := s.store.GetValue(cmd.Key)
+	expiresAt := s.resolveExpiration(cmd.Key, cmd.expiration)
+
+	set, err := s.store.SetWithOptions(cmd.Key, cmd.Value, expiresAt, cmd.condition)
 	if err != nil {
 		s.logger.Error("failed to handle SET command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
 		client.SendMessage(resp.EncodeError(err.Error()))
 		return
 	}
 
-	if cmd.condition == ConditionNX && value != nil {
-		// Key exists, do not set
-		client.SendMessage(resp.EncodeBulkString(nil))
-		return
-	}
-
-	if cmd.condition == ConditionXX && value == nil {
-		// Key does not exist, do not set
+	if !set {
+		// NX with an existing key or XX with a missing one
 		client.SendMessage(resp.EncodeBulkString(nil))
 		return
 	}
 
-	var expiresAt int64 = -1
-	if cmd.expiration != nil {
-		expTime := time.Now().Add(*cmd.expiration)
-		expiresAt = expTime.UnixNano()
-	}
-
-	if expiresAt != 0 {
-		// Set the key-value pair
-		s.store.Set(cmd.Key, cmd.Value, expiresAt)
-	}
-
 	// Reply with OK
 	if err := client.SendMessage(resp.EncodeSimpleString("OK")); err != nil {
 		s.logger.Error("failed to send SET response", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
@@ -162,17 +513,63 @@ func (s *Server) handleGetCommand(cmd GetCommand, client *Client) {
 	}
 
 	// Send value as a bulk string to the client
-	if err := client.SendMessage(resp.EncodeBulkString(value)); err != nil {
+	if err := client.SendBulkString(value); err != nil {
 		s.logger.Error("failed to send GET response", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
 	}
 }
 
+// Handles a MGET command from a client.
+func (s *Server) handleMGetCommand(cmd MGetCommand, client *Client) {
+	values := s.store.GetValues(cmd.Keys)
+
+	if err := client.SendBulkStringArray(values); err != nil {
+		s.logger.Error("failed to send MGET response", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+	}
+}
+
+// Handles a GETORSET command from a client.
+func (s *Server) handleGetOrSetCommand(cmd GetOrSetCommand, client *Client) {
+	expiresAt := s.resolveExpiration(cmd.Key, cmd.expiration)
+
+	value, err := s.store.GetOrSet(cmd.Key, cmd.Value, expiresAt)
+	if err != nil {
+		s.logger.Error("failed to handle GETORSET command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	if err := client.SendMessage(resp.EncodeBulkString(value)); err != nil {
+		s.logger.Error("failed to send GETORSET response", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+	}
+}
+
 func (s *Server) handleDeleteCommand(cmd DeleteCommand, client *Client) {
-	deleted := s.store.Delete(cmd.Keys)
+	var deleted int64
+	if cmd.IfType != "" {
+		deleted = s.store.DeleteIfType(cmd.Keys, cmd.IfType)
+	} else {
+		deleted = s.store.Delete(cmd.Keys)
+	}
 
 	client.SendMessage(resp.EncodeInteger(deleted))
 }
 
+func (s *Server) handleDelIfEqCommand(cmd DelIfEqCommand, client *Client) {
+	deleted, err := s.store.DeleteIfEquals(cmd.Key, cmd.Token)
+	if err != nil {
+		s.logger.Error("failed to handle DELIFEQ command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	// Reply with integer 1 if the key was deleted, 0 otherwise.
+	if deleted {
+		client.SendMessage(resp.EncodeInteger(1))
+	} else {
+		client.SendMessage(resp.EncodeInteger(0))
+	}
+}
+
 func (s *Server) handleExistsCommand(cmd ExistsCommand, client *Client) {
 	existing := s.store.Exists(cmd.Keys)
 
@@ -180,7 +577,7 @@ func (s *Server) handleExistsCommand(cmd ExistsCommand, client *Client) {
 }
 
 func (s *Server) handleExpireCommand(cmd ExpireCommand, client *Client) {
-	expiresAt := time.Now().Add(cmd.TTL).UnixNano()
+	expiresAt := absoluteExpiration(cmd.TTL, cmd.At)
 	success := s.store.Expire(cmd.Key, expiresAt)
 
 	// Reply with integer 1 if successful, 0 otherwise.
@@ -199,6 +596,15 @@ func (s *Server) handlePushCommand(cmd PushCommand, client *Client) {
 		return
 	}
 
+	s.signalKeyReady(cmd.Key)
+
+	// Apply the default expiration only when the push created the list
+	if newLen == len(cmd.Vals) {
+		if ttl, ok := s.ttlPolicy.TTLFor(cmd.Key); ok {
+			s.store.Expire(cmd.Key, time.Now().Add(ttl).UnixNano())
+		}
+	}
+
 	client.SendMessage(resp.EncodeInteger(int64(newLen)))
 }
 
@@ -218,23 +624,18 @@ func (s *Server) handlePopCommand(cmd PopCommand, client *Client) {
 }
 
 func (s *Server) handleLLenCommand(cmd LLenCommand, client *Client) {
-	list, err := s.store.GetList(cmd.Key)
+	length, err := s.store.ListLen(cmd.Key)
 	if err != nil {
 		s.logger.Error("failed to handle LLEN command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
 		client.SendMessage(resp.EncodeError(err.Error()))
 		return
 	}
 
-	if list == nil {
-		client.SendMessage(resp.EncodeInteger(0))
-		return
-	}
-
-	client.SendMessage(resp.EncodeInteger(int64(len(list))))
+	client.SendMessage(resp.EncodeInteger(length))
 }
 
 func (s *Server) handleLRangeCommand(cmd LRangeCommand, client *Client) {
-	list, err := s.store.GetList(cmd.Key)
+	list, err := s.store.GetRange(cmd.Key, cmd.Start, cmd.End)
 	if err != nil {
 		s.logger.Error("failed to handle LRANGE command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
 		client.SendMessage(resp.EncodeError(err.Error()))
@@ -246,83 +647,1430 @@ func (s *Server) handleLRangeCommand(cmd LRangeCommand, client *Client) {
 		return
 	}
 
-	// Slice list and send to client
-	slicedList := util.SliceList(list, cmd.Start, cmd.End)
-	client.SendMessage(resp.EncodeBulkStringArray(slicedList))
+	// Stream the copy of the range to the client
+	client.SendReply(func(w *resp.Writer) error {
+		return w.WriteBulkStringArray(list)
+	})
 }
 
-func (s *Server) handleMessage(msg Message) {
-	switch cmd := msg.cmd.(type) {
-	case PingCommand:
-		s.handlePingCommand(cmd, msg.client)
-	case SetCommand:
-		s.handleSetCommand(cmd, msg.client)
-	case GetCommand:
-		s.handleGetCommand(cmd, msg.client)
-	case DeleteCommand:
-		s.handleDeleteCommand(cmd, msg.client)
-	case ExistsCommand:
-		s.handleExistsCommand(cmd, msg.client)
-	case ExpireCommand:
-		s.handleExpireCommand(cmd, msg.client)
-	case PushCommand:
-		s.handlePushCommand(cmd, msg.client)
-	case PopCommand:
-		s.handlePopCommand(cmd, msg.client)
-	case LLenCommand:
-		s.handleLLenCommand(cmd, msg.client)
-	case LRangeCommand:
-		s.handleLRangeCommand(cmd, msg.client)
+// Handles a BIGKEYS command from a client. Replies with an array of [key, type, size, elements] entries,
+// or with the totals of every type for BIGKEYS SUMMARY.
+func (s *Server) handleBigKeysCommand(cmd BigKeysCommand, client *Client) {
+	if cmd.Summary {
+		s.handleBigKeysSummary(client)
+		return
+	}
+
+	biggest := s.store.BiggestKeys(cmd.Count, cmd.By)
+
+	reply := make([][]byte, len(biggest))
+	for i, stats := range biggest {
+		reply[i] = resp.EncodeArray([][]byte{
+			resp.EncodeBulkString(stats.Key),
+			resp.EncodeBulkString([]byte(stats.Type)),
+			resp.EncodeInteger(stats.Size),
+			resp.EncodeInteger(stats.Elements),
+		})
 	}
+
+	client.SendMessage(resp.EncodeArray(reply))
 }
 
-// Main server loop that handles clients and commands.
-func (s *Server) serverLoop() {
-	defer s.wg.Done()
+// Replies with a map per type of its number of keys, elements and approximate memory usage, and its
+// key with the most elements, like the summary of redis-cli --bigkeys.
+func (s *Server) handleBigKeysSummary(client *Client) {
+	summary := s.store.KeyspaceSummary()
 
-	for {
-		select {
-		case client := <-s.regCh:
-			s.registerClient(client)
-		case client := <-s.deregCh:
-			s.deregisterClient(client)
-		case msg := <-s.msgCh:
-			s.handleMessage(msg)
-		case <-s.quitCh:
-			// Shutdown the server
-			s.store.Close()
-			for client := range s.clients {
-				s.deregisterClient(client)
-			}
-			s.ln.Close()
-			return
+	reply := make([][]byte, len(summary))
+	for i, totals := range summary {
+		var avgElements float64
+		if totals.Keys > 0 {
+			avgElements = float64(totals.Elements) / float64(totals.Keys)
 		}
+		reply[i] = resp.EncodeMap([][]byte{
+			resp.EncodeBulkString([]byte("type")), resp.EncodeBulkString([]byte(totals.Type)),
+			resp.EncodeBulkString([]byte("keys")), resp.EncodeInteger(totals.Keys),
+			resp.EncodeBulkString([]byte("elements")), resp.EncodeInteger(totals.Elements),
+			resp.EncodeBulkString([]byte("avg-elements")), resp.EncodeBulkString([]byte(strconv.FormatFloat(avgElements, 'f', 2, 64))),
+			resp.EncodeBulkString([]byte("size")), resp.EncodeInteger(totals.Size),
+			resp.EncodeBulkString([]byte("biggest-key")), resp.EncodeBulkString(totals.Biggest.Key),
+			resp.EncodeBulkString([]byte("biggest-elements")), resp.EncodeInteger(totals.Biggest.Elements),
+		}, client.protocol)
 	}
+
+	client.SendMessage(resp.EncodeArray(reply))
 }
 
-// Accepts incomming connections and registers new clients.
-func (s *Server) acceptLoop() {
-	defer s.wg.Done()
+// Handles a MEMORY command from a client. MEMORY STATS replies with a map of the heap allocated by
+// the Go runtime, the approximate memory used by the keys and the rest as overhead.
+func (s *Server) handleMemoryCommand(cmd MemoryCommand, client *Client) {
+	switch cmd.Subcommand {
+	case "USAGE":
+		size, exists := s.store.MemoryUsage(cmd.Key)
+		if !exists {
+			client.SendMessage(resp.EncodeBulkString(nil))
+			return
+		}
+		client.SendMessage(resp.EncodeInteger(size))
+	case "STATS":
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		keys, dataset := s.store.DatasetSize()
 
-	for {
-		conn, err := s.ln.Accept()
-		if err != nil {
-			if errors.Is(err, net.ErrClosed) {
-				return // Listener closed, exit the loop
-			}
+		allocated := int64(mem.HeapAlloc)
+		var bytesPerKey, percentage int64
+		if keys > 0 {
+			bytesPerKey = dataset / keys
+		}
+		if allocated > 0 {
+			percentage = min(dataset*100/allocated, 100)
+		}
+		var aofBuffer int64
+		if s.aof != nil {
+			aofBuffer = int64(s.aof.writer.Buffered())
+		}
+		var evicted int64
+		if limiter, ok := s.store.(memoryLimiter); ok {
+			_, evicted = limiter.MemoryStats()
+		}
 
-			s.logger.Error("failed to accept connection", "error", err)
-			continue
+		stats := []struct {
+			name  string
+			value int64
+		}{
+			{"total.allocated", allocated},
+			{"total.system", int64(mem.Sys)},
+			{"overhead.total", max(allocated-dataset, 0)},
+			{"aof.buffer", aofBuffer},
+			{"clients.normal", int64(len(s.clients))},
+			{"keys.count", keys},
+			{"keys.bytes-per-key", bytesPerKey},
+			{"dataset.bytes", dataset},
+			{"dataset.percentage", percentage},
+			{"evicted.keys", evicted},
+			{"gc.cycles", int64(mem.NumGC)},
 		}
+		entries := make([][]byte, 0, len(stats)*2)
+		for _, stat := range stats {
+			entries = append(entries, resp.EncodeBulkString([]byte(stat.name)), resp.EncodeInteger(stat.value))
+		}
+		client.SendMessage(resp.EncodeMap(entries, client.protocol))
+	}
+}
 
-		// Connection accepted
-		go s.handleNewClient(conn)
+// Handles an OBJECT command from a client. Access frequencies are only tracked with an LFU eviction
+// policy, like in Redis.
+func (s *Server) handleObjectCommand(cmd ObjectCommand, client *Client) {
+	if store, ok := s.store.(tunableStore); !ok || !store.EvictionPolicy().lfu() {
+		client.SendMessage(resp.EncodeError("An LFU maxmemory policy is not selected, access frequency not tracked."))
+		return
+	}
+
+	freq, exists := s.store.AccessFrequency(cmd.Key)
+	if !exists {
+		client.SendMessage(resp.EncodeBulkString(nil))
+		return
 	}
+	client.SendMessage(resp.EncodeInteger(int64(freq)))
 }
 
-// Handles registering a new client to the server and starts its reader loop.
-func (s *Server) handleNewClient(conn net.Conn) {
-	client := NewClient(conn, s.deregCh, s.msgCh, s.logger)
+func (s *Server) handleHSetCommand(cmd HSetCommand, client *Client) {
+	created, err := s.store.HashSet(cmd.Key, cmd.Pairs)
+	if err != nil {
+		s.logger.Error("failed to handle HSET command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeInteger(created))
+}
+
+func (s *Server) handleHSetNXCommand(cmd HSetNXCommand, client *Client) {
+	set, err := s.store.HashSetNX(cmd.Key, cmd.Field, cmd.Value)
+	if err != nil {
+		s.logger.Error("failed to handle HSETNX command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	if set {
+		client.SendMessage(resp.EncodeInteger(1))
+	} else {
+		client.SendMessage(resp.EncodeInteger(0))
+	}
+}
+
+func (s *Server) handleHMGetCommand(cmd HMGetCommand, client *Client) {
+	values, err := s.store.HashGetMany(cmd.Key, cmd.Fields)
+	if err != nil {
+		s.logger.Error("failed to handle HMGET command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	// Encode each value so missing fields are sent as null bulk strings
+	reply := make([][]byte, len(values))
+	for i, value := range values {
+		reply[i] = resp.EncodeBulkString(value)
+	}
+	client.SendMessage(resp.EncodeArray(reply))
+}
+
+func (s *Server) handleHLenCommand(cmd HLenCommand, client *Client) {
+	length, err := s.store.HashLen(cmd.Key)
+	if err != nil {
+		s.logger.Error("failed to handle HLEN command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeInteger(length))
+}
+
+// Handles HKEYS and HVALS commands from a client.
+func (s *Server) handleHKeysCommand(cmd HKeysCommand, client *Client) {
+	pairs, err := s.store.HashGetAll(cmd.Key)
+	if err != nil {
+		s.logger.Error("failed to handle HKEYS command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	offset := 0
+	if cmd.values {
+		offset = 1
+	}
+
+	reply := make([][]byte, 0, len(pairs)/2)
+	for i := offset; i < len(pairs); i += 2 {
+		reply = append(reply, pairs[i])
+	}
+	client.SendMessage(resp.EncodeBulkStringArray(reply))
+}
+
+func (s *Server) handleHGetCommand(cmd HGetCommand, client *Client) {
+	value, err := s.store.HashGet(cmd.Key, cmd.Field)
+	if err != nil {
+		s.logger.Error("failed to handle HGET command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendBulkString(value)
+}
+
+func (s *Server) handleHDelCommand(cmd HDelCommand, client *Client) {
+	deleted, err := s.store.HashDelete(cmd.Key, cmd.Fields)
+	if err != nil {
+		s.logger.Error("failed to handle HDEL command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeInteger(deleted))
+}
+
+func (s *Server) handleHGetAllCommand(cmd HGetAllCommand, client *Client) {
+	pairs, err := s.store.HashGetAll(cmd.Key)
+	if err != nil {
+		s.logger.Error("failed to handle HGETALL command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	// Missing keys are returned as an empty map
+	client.SendMessage(resp.EncodeBulkStringMap(pairs, client.protocol))
+}
+
+func (s *Server) handleHExistsCommand(cmd HExistsCommand, client *Client) {
+	exists, err := s.store.HashExists(cmd.Key, cmd.Field)
+	if err != nil {
+		s.logger.Error("failed to handle HEXISTS command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	if exists {
+		client.SendMessage(resp.EncodeInteger(1))
+	} else {
+		client.SendMessage(resp.EncodeInteger(0))
+	}
+}
+
+func (s *Server) handleHIncrByCommand(cmd HIncrByCommand, client *Client) {
+	if cmd.isFloat {
+		value, err := s.store.HashIncrByFloat(cmd.Key, cmd.Field, cmd.FloatDelta)
+		if err != nil {
+			s.logger.Error("failed to handle HINCRBYFLOAT command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+			client.SendMessage(resp.EncodeError(err.Error()))
+			return
+		}
+
+		client.SendMessage(resp.EncodeBulkString(value))
+		return
+	}
+
+	value, err := s.store.HashIncrBy(cmd.Key, cmd.Field, cmd.Delta)
+	if err != nil {
+		s.logger.Error("failed to handle HINCRBY command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeInteger(value))
+}
+
+func (s *Server) handleHExpireCommand(cmd HExpireCommand, client *Client) {
+	expiresAt := absoluteExpiration(cmd.TTL, cmd.At)
+	results, err := s.store.HashExpire(cmd.Key, cmd.Fields, expiresAt)
+	if err != nil {
+		s.logger.Error("failed to handle HEXPIRE command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	reply := make([][]byte, len(results))
+	for i, result := range results {
+		reply[i] = resp.EncodeInteger(result)
+	}
+	client.SendMessage(resp.EncodeArray(reply))
+}
+
+func (s *Server) handleHTTLCommand(cmd HTTLCommand, client *Client) {
+	ttls, err := s.store.HashTTL(cmd.Key, cmd.Fields)
+	if err != nil {
+		s.logger.Error("failed to handle HTTL command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	reply := make([][]byte, len(ttls))
+	for i, ttl := range ttls {
+		// Negative values are status codes and are sent as is
+		if ttl > 0 && !cmd.milliseconds {
+			ttl = (ttl + 500) / 1000
+		}
+		reply[i] = resp.EncodeInteger(ttl)
+	}
+	client.SendMessage(resp.EncodeArray(reply))
+}
+
+func (s *Server) handleSAddCommand(cmd SAddCommand, client *Client) {
+	added, err := s.store.SetAdd(cmd.Key, cmd.Members)
+	if err != nil {
+		s.logger.Error("failed to handle SADD command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeInteger(added))
+}
+
+func (s *Server) handleSRemCommand(cmd SRemCommand, client *Client) {
+	removed, err := s.store.SetRemove(cmd.Key, cmd.Members)
+	if err != nil {
+		s.logger.Error("failed to handle SREM command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeInteger(removed))
+}
+
+// Handles SISMEMBER and SMISMEMBER commands from a client.
+func (s *Server) handleSIsMemberCommand(cmd SIsMemberCommand, client *Client) {
+	found, err := s.store.SetContains(cmd.Key, cmd.Members)
+	if err != nil {
+		s.logger.Error("failed to handle SISMEMBER command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	reply := make([][]byte, len(found))
+	for i, ok := range found {
+		if ok {
+			reply[i] = resp.EncodeInteger(1)
+		} else {
+			reply[i] = resp.EncodeInteger(0)
+		}
+	}
+
+	if cmd.multi {
+		client.SendMessage(resp.EncodeArray(reply))
+	} else {
+		client.SendMessage(reply[0])
+	}
+}
+
+func (s *Server) handleSMembersCommand(cmd SMembersCommand, client *Client) {
+	members, err := s.store.SetMembers(cmd.Key)
+	if err != nil {
+		s.logger.Error("failed to handle SMEMBERS command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	// Missing keys are returned as an empty set
+	client.SendMessage(resp.EncodeBulkStringSet(members, client.protocol))
+}
+
+func (s *Server) handleSCardCommand(cmd SCardCommand, client *Client) {
+	length, err := s.store.SetLen(cmd.Key)
+	if err != nil {
+		s.logger.Error("failed to handle SCARD command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeInteger(length))
+}
+
+// Handles a SSCAN command from a client. Replies with the next cursor and the members of the page.
+func (s *Server) handleSScanCommand(cmd SScanCommand, client *Client) {
+	members, next, err := s.store.SetScan(cmd.Key, cmd.Cursor, cmd.Count)
+	if err != nil {
+		s.logger.Error("failed to handle SSCAN command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	// MATCH is applied after selecting the page, so pages may be empty before the iteration ends
+	matched := make([][]byte, 0, len(members))
+	for _, member := range members {
+		if cmd.Pattern == nil || util.MatchPattern(cmd.Pattern, member) {
+			matched = append(matched, member)
+		}
+	}
+
+	client.SendMessage(resp.EncodeArray([][]byte{
+		resp.EncodeBulkString([]byte(strconv.FormatUint(next, 10))),
+		resp.EncodeBulkStringArray(matched),
+	}))
+}
+
+func (s *Server) handleZAddCommand(cmd ZAddCommand, client *Client) {
+	changed, err := s.store.SortedSetAdd(cmd.Key, cmd.Members, cmd.Options)
+	if err != nil {
+		s.logger.Error("failed to handle ZADD command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeInteger(changed))
+}
+
+func (s *Server) handleZRemCommand(cmd ZRemCommand, client *Client) {
+	removed, err := s.store.SortedSetRemove(cmd.Key, cmd.Members)
+	if err != nil {
+		s.logger.Error("failed to handle ZREM command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeInteger(removed))
+}
+
+func (s *Server) handleZScoreCommand(cmd ZScoreCommand, client *Client) {
+	score, exists, err := s.store.SortedSetScore(cmd.Key, cmd.Member)
+	if err != nil {
+		s.logger.Error("failed to handle ZSCORE command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	if !exists {
+		client.SendMessage(resp.EncodeBulkString(nil))
+		return
+	}
+	if client.protocol >= resp.RESP3 {
+		client.SendMessage(resp.EncodeDouble(score))
+		return
+	}
+	client.SendMessage(resp.EncodeBulkString(util.FormatFloat(score)))
+}
+
+func (s *Server) handleZCardCommand(cmd ZCardCommand, client *Client) {
+	length, err := s.store.SortedSetLen(cmd.Key)
+	if err != nil {
+		s.logger.Error("failed to handle ZCARD command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeInteger(length))
+}
+
+// Handles ZRANGE and ZREVRANGE commands from a client.
+func (s *Server) handleZRangeCommand(cmd ZRangeCommand, client *Client) {
+	members, err := s.store.SortedSetRange(cmd.Key, cmd.Start, cmd.Stop, cmd.rev)
+	if err != nil {
+		s.logger.Error("failed to handle ZRANGE command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(encodeScoredMembers(members, cmd.WithScores))
+}
+
+// Handles the score and lexicographic range commands from a client.
+func (s *Server) handleZRangeByCommand(cmd ZRangeByCommand, client *Client) {
+	members, err := s.store.SortedSetRangeBy(cmd.Key, cmd.Spec, cmd.Options)
+	if err != nil {
+		s.logger.Error("failed to handle ZRANGEBY command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(encodeScoredMembers(members, cmd.WithScores))
+}
+
+// Handles a ZREMRANGEBYRANK command from a client.
+func (s *Server) handleZRemRangeByRankCommand(cmd ZRemRangeByRankCommand, client *Client) {
+	removed, err := s.store.SortedSetRemoveRange(cmd.Key, cmd.Start, cmd.Stop)
+	if err != nil {
+		s.logger.Error("failed to handle ZREMRANGEBYRANK command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeInteger(removed))
+}
+
+// Handles a ZREMRANGEBYSCORE command from a client.
+func (s *Server) handleZRemRangeByScoreCommand(cmd ZRemRangeByScoreCommand, client *Client) {
+	removed, err := s.store.SortedSetRemoveRangeBy(cmd.Key, cmd.Range)
+	if err != nil {
+		s.logger.Error("failed to handle ZREMRANGEBYSCORE command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeInteger(removed))
+}
+
+// Handles ZUNIONSTORE, ZINTERSTORE and ZDIFFSTORE commands from a client.
+func (s *Server) handleZStoreCommand(cmd ZStoreCommand, client *Client) {
+	length, err := s.store.SortedSetStore(cmd.Destination, cmd.Keys, cmd.Options)
+	if err != nil {
+		s.logger.Error("failed to handle ZSTORE command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeInteger(length))
+}
+
+// Handles a BITOP command from a client.
+func (s *Server) handleBitOpCommand(cmd BitOpCommand, client *Client) {
+	length, err := s.store.BitOp(cmd.Operation, cmd.Destination, cmd.Keys)
+	if err != nil {
+		s.logger.Error("failed to handle BITOP command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeInteger(length))
+}
+
+// Handles a BITFIELD command from a client.
+func (s *Server) handleBitFieldCommand(cmd BitFieldCommand, client *Client) {
+	results, err := s.store.BitField(cmd.Key, cmd.Ops)
+	if err != nil {
+		s.logger.Error("failed to handle BITFIELD command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	// Writes skipped because of OVERFLOW FAIL are returned as nil
+	reply := make([][]byte, len(results))
+	for i, result := range results {
+		if result.Failed {
+			reply[i] = resp.EncodeBulkString(nil)
+		} else {
+			reply[i] = resp.EncodeInteger(result.Value)
+		}
+	}
+
+	client.SendMessage(resp.EncodeArray(reply))
+}
+
+// Handles a PFADD command from a client.
+func (s *Server) handlePFAddCommand(cmd PFAddCommand, client *Client) {
+	changed, err := s.store.HyperLogLogAdd(cmd.Key, cmd.Elements)
+	if err != nil {
+		s.logger.Error("failed to handle PFADD command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	if changed {
+		client.SendMessage(resp.EncodeInteger(1))
+	} else {
+		client.SendMessage(resp.EncodeInteger(0))
+	}
+}
+
+// Handles a PFCOUNT command from a client.
+func (s *Server) handlePFCountCommand(cmd PFCountCommand, client *Client) {
+	count, err := s.store.HyperLogLogCount(cmd.Keys)
+	if err != nil {
+		s.logger.Error("failed to handle PFCOUNT command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeInteger(count))
+}
+
+// Handles a PFMERGE command from a client.
+func (s *Server) handlePFMergeCommand(cmd PFMergeCommand, client *Client) {
+	if err := s.store.HyperLogLogMerge(cmd.Destination, cmd.Keys); err != nil {
+		s.logger.Error("failed to handle PFMERGE command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeSimpleString("OK"))
+}
+
+// Handles an XADD command from a client.
+func (s *Server) handleXAddCommand(cmd XAddCommand, client *Client) {
+	id, added, err := s.store.StreamAdd(cmd.Key, cmd.Fields, cmd.Options)
+	if err != nil {
+		s.logger.Error("failed to handle XADD command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	if !added {
+		client.SendMessage(resp.EncodeBulkString(nil))
+		return
+	}
+
+	s.signalKeyReady(cmd.Key)
+	client.SendMessage(resp.EncodeBulkString([]byte(id.String())))
+}
+
+// Handles an XLEN command from a client.
+func (s *Server) handleXLenCommand(cmd XLenCommand, client *Client) {
+	length, err := s.store.StreamLen(cmd.Key)
+	if err != nil {
+		s.logger.Error("failed to handle XLEN command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeInteger(length))
+}
+
+// Handles XRANGE and XREVRANGE commands from a client.
+func (s *Server) handleXRangeCommand(cmd XRangeCommand, client *Client) {
+	entries, err := s.store.StreamRange(cmd.Key, cmd.Start, cmd.End, cmd.Count, cmd.rev)
+	if err != nil {
+		s.logger.Error("failed to handle XRANGE command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(encodeStreamEntries(entries))
+}
+
+// Encodes stream entries as an array of [id, [field, value, ...]] arrays.
+func encodeStreamEntries(entries []StreamEntry) []byte {
+	reply := make([][]byte, len(entries))
+	for i, entry := range entries {
+		reply[i] = resp.EncodeArray([][]byte{
+			resp.EncodeBulkString([]byte(entry.ID.String())),
+			resp.EncodeBulkStringArray(entry.Fields),
+		})
+	}
+
+	return resp.EncodeArray(reply)
+}
+
+// Handles an XGROUP command from a client.
+func (s *Server) handleXGroupCommand(cmd XGroupCommand, client *Client) {
+	id := cmd.ID
+	if cmd.lastID {
+		lastID, err := s.store.StreamLastID(cmd.Key)
+		if err != nil {
+			client.SendMessage(resp.EncodeError(err.Error()))
+			return
+		}
+		id = lastID
+	}
+
+	var reply []byte
+	var err error
+	switch cmd.Subcommand {
+	case "CREATE":
+		err = s.store.StreamGroupCreate(cmd.Key, cmd.Group, id, cmd.MkStream)
+		reply = resp.EncodeSimpleString("OK")
+	case "SETID":
+		err = s.store.StreamGroupSetID(cmd.Key, cmd.Group, id)
+		reply = resp.EncodeSimpleString("OK")
+	case "DESTROY", "CREATECONSUMER":
+		var changed bool
+		if cmd.Subcommand == "DESTROY" {
+			changed, err = s.store.StreamGroupDestroy(cmd.Key, cmd.Group)
+		} else {
+			changed, err = s.store.StreamConsumerCreate(cmd.Key, cmd.Group, cmd.Consumer)
+		}
+
+		reply = resp.EncodeInteger(0)
+		if changed {
+			reply = resp.EncodeInteger(1)
+		}
+	case "DELCONSUMER":
+		var pending int64
+		pending, err = s.store.StreamConsumerDelete(cmd.Key, cmd.Group, cmd.Consumer)
+		reply = resp.EncodeInteger(pending)
+	}
+
+	if err != nil {
+		s.logger.Error("failed to handle XGROUP command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(reply)
+}
+
+// Handles an XACK command from a client.
+func (s *Server) handleXAckCommand(cmd XAckCommand, client *Client) {
+	acked, err := s.store.StreamAck(cmd.Key, cmd.Group, cmd.IDs)
+	if err != nil {
+		s.logger.Error("failed to handle XACK command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeInteger(acked))
+}
+
+// Handles an XPENDING command from a client, replying with a summary when no range is given.
+func (s *Server) handleXPendingCommand(cmd XPendingCommand, client *Client) {
+	if cmd.summary {
+		summary, err := s.store.StreamPendingSummary(cmd.Key, cmd.Group)
+		if err != nil {
+			s.logger.Error("failed to handle XPENDING command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+			client.SendMessage(resp.EncodeError(err.Error()))
+			return
+		}
+
+		if summary.Count == 0 {
+			client.SendMessage(resp.EncodeArray([][]byte{
+				resp.EncodeInteger(0), resp.EncodeBulkString(nil), resp.EncodeBulkString(nil), resp.EncodeBulkStringArray(nil),
+			}))
+			return
+		}
+
+		consumers := make([][]byte, len(summary.Consumers))
+		for i, c := range summary.Consumers {
+			consumers[i] = resp.EncodeBulkStringArray([][]byte{c.Name, []byte(strconv.FormatInt(c.Count, 10))})
+		}
+
+		client.SendMessage(resp.EncodeArray([][]byte{
+			resp.EncodeInteger(summary.Count),
+			resp.EncodeBulkString([]byte(summary.Smallest.String())),
+			resp.EncodeBulkString([]byte(summary.Largest.String())),
+			resp.EncodeArray(consumers),
+		}))
+		return
+	}
+
+	entries, err := s.store.StreamPending(cmd.Key, cmd.Group, cmd.Options)
+	if err != nil {
+		s.logger.Error("failed to handle XPENDING command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	reply := make([][]byte, len(entries))
+	for i, e := range entries {
+		reply[i] = resp.EncodeArray([][]byte{
+			resp.EncodeBulkString([]byte(e.ID.String())),
+			resp.EncodeBulkString(e.Consumer),
+			resp.EncodeInteger(e.Idle.Milliseconds()),
+			resp.EncodeInteger(e.Deliveries),
+		})
+	}
+
+	client.SendMessage(resp.EncodeArray(reply))
+}
+
+// Handles an XAUTOCLAIM command from a client.
+func (s *Server) handleXAutoClaimCommand(cmd XAutoClaimCommand, client *Client) {
+	result, err := s.store.StreamAutoClaim(cmd.Key, cmd.Group, cmd.Options)
+	if err != nil {
+		s.logger.Error("failed to handle XAUTOCLAIM command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	var claimed []byte
+	if cmd.Options.JustID {
+		ids := make([][]byte, len(result.Claimed))
+		for i, e := range result.Claimed {
+			ids[i] = []byte(e.ID.String())
+		}
+		claimed = resp.EncodeBulkStringArray(ids)
+	} else {
+		claimed = encodeStreamEntries(result.Claimed)
+	}
+
+	deleted := make([][]byte, len(result.Deleted))
+	for i, id := range result.Deleted {
+		deleted[i] = []byte(id.String())
+	}
+
+	client.SendMessage(resp.EncodeArray([][]byte{
+		resp.EncodeBulkString([]byte(result.Next.String())),
+		claimed,
+		resp.EncodeBulkStringArray(deleted),
+	}))
+}
+
+// Handles a GEOADD command from a client.
+func (s *Server) handleGeoAddCommand(cmd GeoAddCommand, client *Client) {
+	added, err := s.store.GeoAdd(cmd.Key, cmd.Members, cmd.Options)
+	if err != nil {
+		s.logger.Error("failed to handle GEOADD command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(resp.EncodeInteger(added))
+}
+
+// Handles a GEODIST command from a client.
+func (s *Server) handleGeoDistCommand(cmd GeoDistCommand, client *Client) {
+	dist, ok, err := s.store.GeoDist(cmd.Key, cmd.Member1, cmd.Member2)
+	if err != nil {
+		s.logger.Error("failed to handle GEODIST command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	if !ok {
+		client.SendMessage(resp.EncodeBulkString(nil))
+		return
+	}
+
+	client.SendMessage(resp.EncodeBulkString(strconv.AppendFloat(nil, dist/cmd.Unit, 'f', 4, 64)))
+}
+
+// Handles a GEOSEARCH command from a client. Each result is a member, or an array with the member
+// followed by the distance, hash and coordinates when requested.
+func (s *Server) handleGeoSearchCommand(cmd GeoSearchCommand, client *Client) {
+	results, err := s.store.GeoSearch(cmd.Key, cmd.Query)
+	if err != nil {
+		s.logger.Error("failed to handle GEOSEARCH command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	if !cmd.WithCoord && !cmd.WithDist && !cmd.WithHash {
+		members := make([][]byte, len(results))
+		for i, r := range results {
+			members[i] = r.Member
+		}
+		client.SendMessage(resp.EncodeBulkStringArray(members))
+		return
+	}
+
+	reply := make([][]byte, len(results))
+	for i, r := range results {
+		item := [][]byte{resp.EncodeBulkString(r.Member)}
+		if cmd.WithDist {
+			item = append(item, resp.EncodeBulkString(strconv.AppendFloat(nil, r.Dist/cmd.Unit, 'f', 4, 64)))
+		}
+		if cmd.WithHash {
+			item = append(item, resp.EncodeInteger(int64(r.Hash)))
+		}
+		if cmd.WithCoord {
+			item = append(item, resp.EncodeBulkStringArray([][]byte{util.FormatFloat(r.Point.Lon), util.FormatFloat(r.Point.Lat)}))
+		}
+		reply[i] = resp.EncodeArray(item)
+	}
+
+	client.SendMessage(resp.EncodeArray(reply))
+}
+
+func (s *Server) handleFCallCommand(cmd FCallCommand, client *Client) {
+	fn, exists := s.functions.Get(cmd.Name)
+	if !exists {
+		client.SendMessage(resp.EncodeError("function not found"))
+		return
+	}
+	if cmd.ReadOnly && !fn.ReadOnly {
+		client.SendMessage(resp.EncodeError("can not execute a function with write flag using FCALL_RO"))
+		return
+	}
+
+	ctx := newFunctionContext(s.store, cmd.Keys, fn.ReadOnly)
+	result, err := fn.call(ctx, cmd.Keys, cmd.Args)
+
+	// Clients blocked on the keys may be served by what the function wrote, even if it failed midway
+	if ctx.written {
+		for _, key := range cmd.Keys {
+			s.signalKeyReady(key)
+		}
+	}
+
+	var reply []byte
+	if err == nil {
+		reply, err = encodeFunctionReply(result)
+	}
+	if err != nil {
+		s.logger.Error("failed to handle FCALL command", "function", cmd.Name, "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+		return
+	}
+
+	client.SendMessage(reply)
+}
+
+func (s *Server) handleFunctionCommand(cmd FunctionCommand, client *Client) {
+	functions := s.functions.List()
+	reply := make([][]byte, len(functions))
+	for i, fn := range functions {
+		flags := [][]byte{}
+		if fn.ReadOnly {
+			flags = append(flags, []byte("no-writes"))
+		}
+
+		reply[i] = resp.EncodeArray([][]byte{
+			resp.EncodeBulkString([]byte("name")),
+			resp.EncodeBulkString([]byte(fn.Name)),
+			resp.EncodeBulkString([]byte("flags")),
+			resp.EncodeBulkStringArray(flags),
+		})
+	}
+
+	client.SendMessage(resp.EncodeArray(reply))
+}
+
+// Encodes sorted set members as an array, interleaving the scores if withScores is true.
+func encodeScoredMembers(members []ScoredMember, withScores bool) []byte {
+	reply := make([][]byte, 0, len(members)*2)
+	for _, m := range members {
+		reply = append(reply, m.Member)
+		if withScores {
+			reply = append(reply, util.FormatFloat(m.Score))
+		}
+	}
+
+	return resp.EncodeBulkStringArray(reply)
+}
+
+// Handles CLIENT REPLY, ID and TRACKING commands from a client.
+func (s *Server) handleClientCommand(cmd ClientCommand, client *Client) {
+	switch cmd.Subcommand {
+	case "REPLY":
+		switch cmd.ReplyMode {
+		case "ON":
+			client.replyOff = false
+			client.replySkip = false
+			client.muted.Store(false)
+			client.SendMessage(resp.EncodeSimpleString("OK"))
+		case "OFF":
+			// Nothing is replied, not even to this command
+			client.replyOff = true
+			client.muted.Store(true)
+		case "SKIP":
+			// The next command is skipped, unless replies are already off
+			client.replySkip = true
+			client.muted.Store(true)
+		}
+	case "ID":
+		client.SendMessage(resp.EncodeInteger(client.id))
+	case "SETNAME":
+		client.name = cmd.Name
+		client.SendMessage(resp.EncodeSimpleString("OK"))
+	case "GETNAME":
+		if client.name == "" {
+			client.SendMessage(resp.EncodeBulkString(nil))
+			return
+		}
+		client.SendMessage(resp.EncodeBulkString([]byte(client.name)))
+	case "TRACKING":
+		if err := s.handleClientTracking(cmd, client); err != nil {
+			s.logger.Error("failed to handle CLIENT TRACKING command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+			client.SendMessage(resp.EncodeError(err.Error()))
+			return
+		}
+		client.SendMessage(resp.EncodeSimpleString("OK"))
+	}
+}
+
+// Handles a DEBUG command from a client.
+func (s *Server) handleDebugCommand(cmd DebugCommand, client *Client) {
+	if s.faults == nil {
+		client.SendMessage(resp.EncodeError("DEBUG commands are disabled, start the server with -chaos to enable them"))
+		return
+	}
+
+	switch cmd.Subcommand {
+	case "SLEEP":
+		// Blocks the server loop on purpose, like a slow command would
+		time.Sleep(cmd.Latency)
+		client.SendMessage(resp.EncodeSimpleString("OK"))
+		return
+	case "SET-ACTIVE-EXPIRE":
+		if store, ok := s.store.(tunableStore); ok {
+			store.SetActiveExpire(cmd.Enabled)
+		}
+		client.SendMessage(resp.EncodeSimpleString("OK"))
+		return
+	case "OBJECT":
+		info, exists := s.store.DebugObject(cmd.Key)
+		if !exists {
+			client.SendMessage(resp.EncodeError("no such key"))
+			return
+		}
+		ttl := int64(-1)
+		if info.ExpiresAt > 0 {
+			ttl = max(time.Until(time.Unix(0, info.ExpiresAt)).Milliseconds(), 0)
+		}
+		client.SendMessage(resp.EncodeSimpleString(fmt.Sprintf("type:%s encoding:%s version:%d size:%d elements:%d ttl:%d",
+			info.Type, info.Encoding, info.Version, info.Size, info.Elements, ttl)))
+		return
+	case "QUICKLIST-PACKED-THRESHOLD":
+		// Lists are not packed into quicklist nodes, the size is only validated
+		client.SendMessage(resp.EncodeSimpleString("OK"))
+		return
+	case "LATENCY":
+		s.faults.setLatency(cmd.Latency, cmd.Rate)
+	case "DISCONNECT":
+		s.faults.setDisconnectRate(cmd.Rate)
+	case "ERROR":
+		s.faults.setErrorRate(cmd.Rate)
+	case "RESET-FAULTS":
+		s.faults.reset()
+	case "FAULTS":
+		client.SendMessage(resp.EncodeBulkStringArray(s.faults.settings()))
+		return
+	}
+
+	s.logger.Warn("fault injection settings changed", "subcommand", cmd.Subcommand, "remoteAddr", client.conn.RemoteAddr().String())
+	client.SendMessage(resp.EncodeSimpleString("OK"))
+}
+
+func (s *Server) handleMessage(msg Message) {
+	switch cmd := msg.cmd.(type) {
+	case PingCommand:
+		s.handlePingCommand(cmd, msg.client)
+	case SetCommand:
+		s.handleSetCommand(cmd, msg.client)
+	case GetCommand:
+		s.handleGetCommand(cmd, msg.client)
+	case GetOrSetCommand:
+		s.handleGetOrSetCommand(cmd, msg.client)
+	case MGetCommand:
+		s.handleMGetCommand(cmd, msg.client)
+	case DeleteCommand:
+		s.handleDeleteCommand(cmd, msg.client)
+	case DelIfEqCommand:
+		s.handleDelIfEqCommand(cmd, msg.client)
+	case ExistsCommand:
+		s.handleExistsCommand(cmd, msg.client)
+	case ExpireCommand:
+		s.handleExpireCommand(cmd, msg.client)
+	case PushCommand:
+		s.handlePushCommand(cmd, msg.client)
+	case PopCommand:
+		s.handlePopCommand(cmd, msg.client)
+	case BlockingPopCommand:
+		s.handleBlockingPopCommand(cmd, msg.client)
+	case MoveCommand:
+		s.handleMoveCommand(cmd, msg.client)
+	case LLenCommand:
+		s.handleLLenCommand(cmd, msg.client)
+	case LRangeCommand:
+		s.handleLRangeCommand(cmd, msg.client)
+	case HSetCommand:
+		s.handleHSetCommand(cmd, msg.client)
+	case HSetNXCommand:
+		s.handleHSetNXCommand(cmd, msg.client)
+	case HMGetCommand:
+		s.handleHMGetCommand(cmd, msg.client)
+	case HLenCommand:
+		s.handleHLenCommand(cmd, msg.client)
+	case HKeysCommand:
+		s.handleHKeysCommand(cmd, msg.client)
+	case HGetCommand:
+		s.handleHGetCommand(cmd, msg.client)
+	case HDelCommand:
+		s.handleHDelCommand(cmd, msg.client)
+	case HGetAllCommand:
+		s.handleHGetAllCommand(cmd, msg.client)
+	case HExistsCommand:
+		s.handleHExistsCommand(cmd, msg.client)
+	case HIncrByCommand:
+		s.handleHIncrByCommand(cmd, msg.client)
+	case HExpireCommand:
+		s.handleHExpireCommand(cmd, msg.client)
+	case HTTLCommand:
+		s.handleHTTLCommand(cmd, msg.client)
+	case SAddCommand:
+		s.handleSAddCommand(cmd, msg.client)
+	case SRemCommand:
+		s.handleSRemCommand(cmd, msg.client)
+	case SIsMemberCommand:
+		s.handleSIsMemberCommand(cmd, msg.client)
+	case SMembersCommand:
+		s.handleSMembersCommand(cmd, msg.client)
+	case SCardCommand:
+		s.handleSCardCommand(cmd, msg.client)
+	case SScanCommand:
+		s.handleSScanCommand(cmd, msg.client)
+	case ZAddCommand:
+		s.handleZAddCommand(cmd, msg.client)
+	case ZRemCommand:
+		s.handleZRemCommand(cmd, msg.client)
+	case ZScoreCommand:
+		s.handleZScoreCommand(cmd, msg.client)
+	case ZCardCommand:
+		s.handleZCardCommand(cmd, msg.client)
+	case ZRangeCommand:
+		s.handleZRangeCommand(cmd, msg.client)
+	case ZRangeByCommand:
+		s.handleZRangeByCommand(cmd, msg.client)
+	case ZStoreCommand:
+		s.handleZStoreCommand(cmd, msg.client)
+	case ZRemRangeByRankCommand:
+		s.handleZRemRangeByRankCommand(cmd, msg.client)
+	case ZRemRangeByScoreCommand:
+		s.handleZRemRangeByScoreCommand(cmd, msg.client)
+	case BitOpCommand:
+		s.handleBitOpCommand(cmd, msg.client)
+	case BitFieldCommand:
+		s.handleBitFieldCommand(cmd, msg.client)
+	case PFAddCommand:
+		s.handlePFAddCommand(cmd, msg.client)
+	case PFCountCommand:
+		s.handlePFCountCommand(cmd, msg.client)
+	case PFMergeCommand:
+		s.handlePFMergeCommand(cmd, msg.client)
+	case XAddCommand:
+		s.handleXAddCommand(cmd, msg.client)
+	case XLenCommand:
+		s.handleXLenCommand(cmd, msg.client)
+	case XRangeCommand:
+		s.handleXRangeCommand(cmd, msg.client)
+	case XReadCommand:
+		s.handleXReadCommand(cmd, msg.client)
+	case XGroupCommand:
+		s.handleXGroupCommand(cmd, msg.client)
+	case XReadGroupCommand:
+		s.handleXReadGroupCommand(cmd, msg.client)
+	case XAckCommand:
+		s.handleXAckCommand(cmd, msg.client)
+	case XPendingCommand:
+		s.handleXPendingCommand(cmd, msg.client)
+	case XAutoClaimCommand:
+		s.handleXAutoClaimCommand(cmd, msg.client)
+	case GeoAddCommand:
+		s.handleGeoAddCommand(cmd, msg.client)
+	case GeoDistCommand:
+		s.handleGeoDistCommand(cmd, msg.client)
+	case GeoSearchCommand:
+		s.handleGeoSearchCommand(cmd, msg.client)
+	case FCallCommand:
+		s.handleFCallCommand(cmd, msg.client)
+	case FunctionCommand:
+		s.handleFunctionCommand(cmd, msg.client)
+	case customCommandCall:
+		s.handleCustomCommand(cmd, msg.client)
+	case SubscribeCommand:
+		s.handleSubscribeCommand(cmd, msg.client)
+	case UnsubscribeCommand:
+		s.handleUnsubscribeCommand(cmd, msg.client)
+	case PublishCommand:
+		s.handlePublishCommand(cmd, msg.client)
+	case PubSubCommand:
+		s.handlePubSubCommand(cmd, msg.client)
+	case ClientCommand:
+		s.handleClientCommand(cmd, msg.client)
+	case HelloCommand:
+		s.handleHelloCommand(cmd, msg.client)
+	case AuthCommand:
+		s.handleAuthCommand(cmd, msg.client)
+	case ConfigCommand:
+		s.handleConfigCommand(cmd, msg.client)
+	case CommandCommand:
+		s.handleCommandCommand(cmd, msg.client)
+	case LatencyCommand:
+		s.handleLatencyCommand(cmd, msg.client)
+	case DebugCommand:
+		s.handleDebugCommand(cmd, msg.client)
+	case BigKeysCommand:
+		s.handleBigKeysCommand(cmd, msg.client)
+	case MemoryCommand:
+		s.handleMemoryCommand(cmd, msg.client)
+	case ObjectCommand:
+		s.handleObjectCommand(cmd, msg.client)
+	case SaveCommand:
+		s.handleSaveCommand(cmd, msg.client)
+	case LastSaveCommand:
+		s.handleLastSaveCommand(msg.client)
+	case ExportCommand:
+		s.handleExportCommand(cmd, msg.client)
+	case ImportCommand:
+		s.handleImportCommand(cmd, msg.client)
+	case SyncCommand:
+		s.handleSyncCommand(msg.client)
+	case ReplicaOfCommand:
+		s.handleReplicaOfCommand(cmd, msg.client)
+	case PSyncCommand:
+		s.handlePSyncCommand(cmd, msg.client)
+	case ReplConfCommand:
+		s.handleReplConfCommand(cmd, msg.client)
+	case WaitCommand:
+		s.handleWaitCommand(cmd, msg.client)
+	case FailoverCommand:
+		s.handleFailoverCommand(cmd, msg.client)
+	case RoleCommand:
+		s.handleRoleCommand(msg.client)
+	case ClusterCommand:
+		s.handleClusterCommand(cmd, msg.client)
+	case MigrateCommand:
+		s.handleMigrateCommand(cmd, msg.client)
+	}
+}
+
+// Executes messages in order. Messages from blocked clients are queued until the client is unblocked,
+// and clients unblocked by a command get their queued messages executed right after it.
+func (s *Server) processMessages(queue []Message) {
+	for len(queue) > 0 {
+		msg := queue[0]
+		queue = queue[1:]
+
+		if msg.client.blocked != nil {
+			msg.client.pending = append(msg.client.pending, msg)
+			continue
+		}
+		if reply, ok := msg.cmd.(readerError); ok {
+			s.applyReplyMode(msg)
+			msg.client.SendMessage(resp.EncodeError(reply.message))
+			continue
+		}
+
+		if !msg.client.authenticated && !allowedUnauthenticated(msg.cmd) {
+			msg.client.SendMessage(resp.EncodeError("NOAUTH Authentication required."))
+			continue
+		}
+		s.applyReplyMode(msg)
+		if msg.client.subscriptionCount() > 0 && !allowedInSubscribeMode(msg.cmd) {
+			msg.client.SendMessage(resp.EncodeError("only SUBSCRIBE, UNSUBSCRIBE, PSUBSCRIBE, PUNSUBSCRIBE, SSUBSCRIBE, SUNSUBSCRIBE and PING are allowed while subscribed"))
+			continue
+		}
+		if s.rejectsWrite(msg) {
+			msg.client.SendMessage(resp.EncodeError("READONLY You can't write against a read only replica."))
+			continue
+		}
+		if s.rejectsForMemory(msg) {
+			msg.client.SendMessage(resp.EncodeError("OOM command not allowed when used memory > 'maxmemory'."))
+			continue
+		}
+		if s.failover != nil && s.isWriteCommand(msg.cmd) {
+			s.pauseForFailover(msg)
+			continue
+		}
+		s.holdReplies(msg.client)
+		s.propagateRemovals() // Keys expired in the background before the command
+		dirty := s.store.Dirty()
+		s.latency.measure(latencyCommand, func() { s.handleMessage(msg) })
+		s.stats.commandsProcessed.Add(1)
+		s.propagateRemovals() // Keys found expired by the command, before it changed them
+		if s.store.Dirty() != dirty {
+			s.propagateMessage(msg)
+		}
+		s.trackReadKeys(msg)
+		s.updateIdleExempt(msg.client)
+		if msg.client.blocked == nil {
+			// Replies outside commands, like pub/sub messages, only follow CLIENT REPLY ON and OFF
+			msg.client.muted.Store(msg.client.replyOff)
+		}
+
+		for _, client := range s.serveReadyKeys() {
+			queue = append(queue, client.takePending()...)
+		}
+	}
+
+	s.flushAppendOnly()
+}
+
+// Exempts a client from the read timeout while it waits without sending commands, like Redis does
+// for blocked clients, subscribers and replicas.
+func (s *Server) updateIdleExempt(client *Client) {
+	_, isReplica := s.replicas[client]
+	client.setIdleExempt(client.blocked != nil || client.subscriptionCount() > 0 || isReplica)
+}
+
+// Suppresses the replies of a command if the client turned replies off or is skipping this one.
+// CLIENT commands update the reply mode themselves.
+func (s *Server) applyReplyMode(msg Message) {
+	if _, ok := msg.cmd.(ClientCommand); ok {
+		return
+	}
+
+	client := msg.client
+	client.muted.Store(client.replyOff || client.replySkip)
+	client.replySkip = false
+}
+
+// Main server loop that handles clients and commands.
+func (s *Server) serverLoop() {
+	defer s.wg.Done()
+
+	var autosave <-chan time.Time
+	if s.snapshotPath != "" && len(s.saveRules) > 0 {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		autosave = ticker.C
+	}
+
+	for {
+		select {
+		case client := <-s.regCh:
+			s.registerClient(client)
+		case client := <-s.deregCh:
+			s.deregisterClient(client)
+		case msg := <-s.msgCh:
+			s.processMessages([]Message{msg})
+			msg.client.loopHandled()
+		case bc := <-s.timeoutCh:
+			s.handleBlockTimeout(bc)
+		case event := <-s.eventCh:
+			s.handleKeyspaceEvent(event)
+		case err := <-s.bgsaveDone:
+			s.handleBackgroundSaveDone(err)
+		case result := <-s.syncDone:
+			s.handleSyncDone(result)
+		case msg := <-s.replCh:
+			s.handleReplicationMessage(msg)
+		case f := <-s.failoverCh:
+			s.handleFailoverTimeout(f)
+		case <-s.removalCh:
+			s.propagateRemovals()
+			s.flushAppendOnly()
+		case <-autosave:
+			s.checkSaveRules()
+		case done := <-s.reloadCh:
+			done <- s.reloadConfig()
+		case <-s.quitCh:
+			// Shutdown the server
+			s.stopReplication()
+			s.drain()
+			if s.bgsaving {
+				s.handleBackgroundSaveDone(<-s.bgsaveDone)
+			}
+			for s.syncing > 0 {
+				s.handleSyncDone(<-s.syncDone)
+			}
+			if s.snapshotPath != "" {
+				if err := s.saveSnapshot(); err != nil {
+					s.logger.Error("failed to save snapshot", "path", s.snapshotPath, "error", err)
+				}
+			}
+			if s.aof != nil {
+				if err := s.aof.close(); err != nil {
+					s.logger.Error("failed to close append-only file", "path", s.aofPath, "error", err)
+				}
+			}
+			s.store.Close()
+			for client := range s.clients {
+				s.deregisterClient(client)
+			}
+			return
+		}
+	}
+}
+
+// Drains the server before it shuts down, see SetShutdownTimeout. Stops accepting connections and
+// reading commands, then keeps running the commands already read until every client is
+// deregistered, which happens once its reader stopped.
+func (s *Server) drain() {
+	s.ln.Close()
+	s.draining = true
+	s.drainDeadline = time.Now().Add(s.shutdownTimeout)
+	for client := range s.clients {
+		client.stopReading()
+	}
+
+	timeout := time.NewTimer(s.shutdownTimeout)
+	defer timeout.Stop()
+	for len(s.clients) > 0 {
+		select {
+		case client := <-s.regCh:
+			// Accepted before the listener was closed
+			s.registerClient(client)
+			client.stopReading()
+		case client := <-s.deregCh:
+			s.deregisterClient(client)
+		case msg := <-s.msgCh:
+			s.processMessages([]Message{msg})
+			msg.client.loopHandled()
+		case bc := <-s.timeoutCh:
+			s.handleBlockTimeout(bc)
+		case event := <-s.eventCh:
+			s.handleKeyspaceEvent(event)
+		case <-s.removalCh:
+			s.propagateRemovals()
+			s.flushAppendOnly()
+		case <-timeout.C:
+			s.logger.Warn("shutdown timeout expired, dropping clients", "clients", len(s.clients))
+			s.draining = false
+			return
+		}
+	}
+	s.draining = false
+}
+
+// Accepts incomming connections and registers new clients.
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return // Listener closed, exit the loop
+			}
+
+			s.logger.Error("failed to accept connection", "error", err)
+			continue
+		}
+
+		// Connection accepted
+		go s.handleNewClient(conn)
+	}
+}
+
+// Handles registering a new client to the server and starts its reader loop.
+func (s *Server) handleNewClient(conn net.Conn) {
+	client := NewClient(conn, s.deregCh, s.msgCh, s.logger)
+	client.faults = s.faults
+	client.commands = s.commands
+	client.policy = s.policy
+	s.configMu.RLock()
+	client.limits = s.limits
+	client.authenticated = s.requirePass == ""
+	client.readTimeout = s.readTimeout
+	client.writeTimeout = s.writeTimeout
+	keepAlive := s.tcpKeepAlive
+	s.configMu.RUnlock()
+	client.runner = s.runConcurrently
+	client.concurrent.Store(client.canRunConcurrently())
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetKeepAlive(keepAlive > 0)
+		if keepAlive > 0 {
+			tcpConn.SetKeepAlivePeriod(keepAlive)
+		}
+	}
 	s.regCh <- client
 
 	go client.write()
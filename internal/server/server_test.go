@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+func TestListenAndServe(t *testing.T) {
+	store := NewInMemoryKVStore()
+	s := NewServer(slog.New(slog.NewTextHandler(io.Discard, nil)), "127.0.0.1:0", store)
+
+	var started, stopped bool
+	s.OnStart(func() error { started = true; return nil })
+	s.OnShutdownComplete(func() error { stopped = true; return nil })
+	if err := s.ListenAndServe(); err != nil {
+		t.Fatal(err)
+	}
+	if !started {
+		t.Error("Expected the start hooks to run before ListenAndServe returns")
+	}
+
+	client := dialTestServer(t, s.Addr().String())
+	if val := client.do("PING"); val != (resp.RespSimpleString{Value: "PONG"}) {
+		t.Errorf("Expected PONG, got %v", val)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected the server to shut down, got %v", err)
+	}
+	if !stopped {
+		t.Error("Expected the shutdown hooks to run before Shutdown returns")
+	}
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Expected a second Shutdown to return once stopped, got %v", err)
+	}
+	if _, err := net.Dial("tcp", s.Addr().String()); err == nil {
+		t.Error("Expected new connections to be refused")
+	}
+}
+
+func TestListenAndServeFailedHook(t *testing.T) {
+	store := NewInMemoryKVStore()
+	s := NewServer(slog.New(slog.NewTextHandler(io.Discard, nil)), "127.0.0.1:0", store)
+
+	failed := errors.New("failed")
+	s.OnStart(func() error { return failed })
+	if err := s.ListenAndServe(); err != failed {
+		t.Fatalf("Expected the error of the start hook, got %v", err)
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected Shutdown to return once stopped, got %v", err)
+	}
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	store := NewInMemoryKVStore()
+	s := NewServer(slog.New(slog.NewTextHandler(io.Discard, nil)), "127.0.0.1:0", store)
+
+	var order []string
+	hook := func(name string) LifecycleHook {
+		return func() error { order = append(order, name); return nil }
+	}
+	s.OnShutdownComplete(hook("complete-1"))
+	s.OnShutdownBegin(hook("begin-1"))
+	s.OnStart(hook("start-1"))
+	s.OnStart(hook("start-2"))
+	s.OnShutdownBegin(func() error {
+		order = append(order, "begin-2")
+		return errors.New("failed")
+	})
+	s.OnShutdownBegin(func() error {
+		order = append(order, "begin-3")
+		panic("broken hook")
+	})
+	s.OnShutdownBegin(hook("begin-4"))
+	s.OnShutdownComplete(func() error {
+		order = append(order, "complete-2")
+		panic("broken hook")
+	})
+	s.OnShutdownComplete(hook("complete-3"))
+
+	if err := s.ListenAndServe(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Failing and panicking shutdown hooks don't stop the next hooks or the shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected the server to shut down, got %v", err)
+	}
+
+	expected := []string{"start-1", "start-2", "begin-1", "begin-2", "begin-3", "begin-4", "complete-1", "complete-2", "complete-3"}
+	if !slices.Equal(order, expected) {
+		t.Errorf("Expected the hooks to run in order %v, got %v", expected, order)
+	}
+}
+
+func TestListenAndServePanickingHook(t *testing.T) {
+	store := NewInMemoryKVStore()
+	s := NewServer(slog.New(slog.NewTextHandler(io.Discard, nil)), "127.0.0.1:0", store)
+
+	var ranAfter, stopped bool
+	s.OnStart(func() error { panic("broken hook") })
+	s.OnStart(func() error { ranAfter = true; return nil })
+	s.OnShutdownComplete(func() error { stopped = true; return nil })
+	if err := s.ListenAndServe(); err == nil {
+		t.Fatal("Expected the panic of the start hook to be returned")
+	}
+	if ranAfter {
+		t.Error("Expected the start hooks after a failed one to not run")
+	}
+	if !stopped {
+		t.Error("Expected the server to shut down after a failed start hook")
+	}
+}
+
+func TestSetListener(t *testing.T) {
+	store := NewInMemoryKVStore()
+	s := NewServer(slog.New(slog.NewTextHandler(io.Discard, nil)), "127.0.0.1:1", store)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetListener(ln)
+	if err := s.ListenAndServe(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+
+	if s.Addr().String() != ln.Addr().String() {
+		t.Errorf("Expected the server to use the listener set, got %s", s.Addr())
+	}
+	client := dialTestServer(t, ln.Addr().String())
+	if val := client.do("PING"); val != (resp.RespSimpleString{Value: "PONG"}) {
+		t.Errorf("Expected PONG, got %v", val)
+	}
+}
+
+func TestShutdownDrainsClients(t *testing.T) {
+	s, addr := startTestServer(t, func(s *Server, _ string) {
+		s.EnableFaultInjection()
+	})
+	client := dialTestServer(t, addr)
+
+	// The commands after the sleep are read while the server loop is busy and must still run
+	var pipeline []byte
+	for _, cmd := range [][]string{{"DEBUG", "SLEEP", "0.2"}, {"SET", "key", "value"}, {"GET", "key"}} {
+		args := make([][]byte, len(cmd))
+		for i, arg := range cmd {
+			args[i] = []byte(arg)
+		}
+		pipeline = append(pipeline, resp.EncodeBulkStringArray(args)...)
+	}
+	if _, err := client.conn.Write(pipeline); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	stopped := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stopped <- s.Shutdown(ctx)
+	}()
+
+	client.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for range 2 {
+		if val, err := resp.ReadRESP(client.reader); err != nil || val != (resp.RespSimpleString{Value: "OK"}) {
+			t.Fatalf("Expected OK, got %v (%v)", val, err)
+		}
+	}
+	if val, err := resp.ReadRESP(client.reader); err != nil {
+		t.Fatalf("Expected the reply of GET, got %v", err)
+	} else if bulk, _ := val.(resp.RespBulkString); string(bulk.Value) != "value" {
+		t.Errorf("Expected the value written before shutting down, got %v", val)
+	}
+	if _, err := client.reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the connection to be closed once drained, got %v", err)
+	}
+
+	if err := <-stopped; err != nil {
+		t.Errorf("Expected the server to stop once drained, got %v", err)
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Error("Expected new connections to be refused")
+	}
+}
@@ -0,0 +1,357 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Wait before retrying an automatic BGSAVE that failed.
+const saveRetryDelay = 5 * time.Second
+
+// Snapshot metadata holding the size of the append-only file when the snapshot was taken. Only the
+// commands after it are replayed on top of the snapshot.
+const snapshotAOFOffset = "aof-offset"
+
+// Stores able to write their keys to a snapshot and load them back.
+type snapshotter interface {
+	BeginSnapshot() (*StoreSnapshot, error)
+	LoadSnapshot(r io.Reader) (int, error)
+}
+
+// Enables snapshots at path. The snapshot is loaded by ListenAndServe and written when the server
+// stops. With the append-only file also enabled, each snapshot records the position it reached in
+// the file, so only the commands written after it are replayed at startup. Fails if the store
+// cannot be snapshotted.
+// Must be called before ListenAndServe.
+func (s *Server) SetSnapshotFile(path string) error {
+	if _, ok := s.store.(snapshotter); !ok {
+		return fmt.Errorf("the store does not support snapshots")
+	}
+
+	s.snapshotPath = path
+	return nil
+}
+
+// Writes a snapshot of the store to the snapshot file.
+// Must be called from the server loop.
+func (s *Server) saveSnapshot() error {
+	snapshot, metadata, err := s.beginSnapshot()
+	if err != nil {
+		return err
+	}
+	return s.writeSnapshot(snapshot, metadata)
+}
+
+// Starts a snapshot of the store as it is now, along with its metadata. The append-only file is
+// synced first so the position recorded in the snapshot is on disk before the snapshot is.
+// Must be called from the server loop.
+func (s *Server) beginSnapshot() (*StoreSnapshot, map[string]string, error) {
+	var metadata map[string]string
+	if s.aof != nil {
+		if err := s.aof.sync(); err != nil {
+			return nil, nil, fmt.Errorf("failed to sync append-only file: %w", err)
+		}
+		metadata = map[string]string{snapshotAOFOffset: strconv.FormatInt(s.aof.size, 10)}
+	}
+
+	snapshot, err := s.store.(snapshotter).BeginSnapshot()
+	if err != nil {
+		return nil, nil, err
+	}
+	return snapshot, metadata, nil
+}
+
+// Writes a snapshot started by beginSnapshot to the snapshot file and releases it, encrypted with the
+// current key when encryption is enabled. Safe to call from any goroutine.
+func (s *Server) writeSnapshot(snapshot *StoreSnapshot, metadata map[string]string) error {
+	defer snapshot.Release()
+
+	return replaceFile(s.snapshotPath, func(file *os.File) error {
+		var w io.Writer = file
+		if s.persistKeys != nil {
+			w = &encryptingWriter{w: file, keys: s.persistKeys}
+		}
+		return snapshot.Encode(w, metadata)
+	})
+}
+
+// Replaces the file at path with the content written by write. The content is written to a
+// temporary file in the same directory and renamed over the previous file once synced, so a crash
+// while writing never leaves a partial file behind.
+func replaceFile(path string, write func(file *os.File) error) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, name+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Sync the directory so the rename survives a crash, not supported on every platform
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// Restores the store from the snapshot and the append-only file, whichever are enabled. With both,
+// the snapshot is loaded and only the part of the append-only file written after it is replayed, so
+// restarts take time proportional to the recent writes rather than the whole history.
+func (s *Server) restore() error {
+	if s.snapshotPath == "" || s.aofPath == "" {
+		if s.snapshotPath != "" {
+			return s.loadSnapshot()
+		}
+		return s.loadAppendOnlyFile(0)
+	}
+
+	metadata, exists, err := s.readSnapshotMetadata()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return s.loadAppendOnlyFile(0)
+	}
+
+	// An encrypted file is larger than the commands it holds, its replay checks the offset again
+	var aofSize int64
+	if info, err := os.Stat(s.aofPath); err == nil {
+		aofSize = info.Size()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	value, ok := metadata[snapshotAOFOffset]
+	if !ok {
+		if aofSize > 0 {
+			// The snapshot was taken without the append-only file, which may be older or newer
+			s.logger.Warn("ignoring snapshot taken without the append-only file", "path", s.snapshotPath, "aofPath", s.aofPath)
+			return s.loadAppendOnlyFile(0)
+		}
+
+		// The append-only file was just enabled, the snapshot is saved again to record its position
+		if err := s.loadSnapshot(); err != nil {
+			return err
+		}
+		if err := s.loadAppendOnlyFile(0); err != nil {
+			return err
+		}
+		return s.saveSnapshot()
+	}
+
+	offset, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || offset < 0 {
+		return fmt.Errorf("invalid append-only file offset %q in snapshot %s", value, s.snapshotPath)
+	}
+	if offset > aofSize {
+		return fmt.Errorf("append-only file %s has %d bytes but snapshot %s was taken at offset %d, the files do not belong together", s.aofPath, aofSize, s.snapshotPath, offset)
+	}
+
+	if err := s.loadSnapshot(); err != nil {
+		return err
+	}
+	return s.loadAppendOnlyFile(offset)
+}
+
+// Reads the metadata of the snapshot file, returning false if it does not exist.
+func (s *Server) readSnapshotMetadata() (map[string]string, bool, error) {
+	file, err := os.Open(s.snapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	reader, _, err := s.persistenceReader(file)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load snapshot %s: %w", s.snapshotPath, err)
+	}
+	metadata, err := ReadSnapshotMetadata(reader)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load snapshot %s: %w", s.snapshotPath, err)
+	}
+	return metadata, true, nil
+}
+
+// Loads the snapshot file into the store if it exists.
+func (s *Server) loadSnapshot() error {
+	file, err := os.Open(s.snapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, _, err := s.persistenceReader(file)
+	if err != nil {
+		return fmt.Errorf("failed to load snapshot %s: %w", s.snapshotPath, err)
+	}
+	keys, err := s.store.(snapshotter).LoadSnapshot(reader)
+	if err != nil {
+		return fmt.Errorf("failed to load snapshot %s: %w", s.snapshotPath, err)
+	}
+
+	s.logger.Info("loaded snapshot", "path", s.snapshotPath, "keys", keys)
+	return nil
+}
+
+// Handles SAVE and BGSAVE commands from a client. SAVE blocks every client until the snapshot is
+// written, BGSAVE writes it on a background goroutine while commands keep running.
+func (s *Server) handleSaveCommand(cmd SaveCommand, client *Client) {
+	if s.snapshotPath == "" {
+		client.SendMessage(resp.EncodeError("snapshots are disabled, no snapshot file is configured"))
+		return
+	}
+	if s.bgsaving {
+		client.SendMessage(resp.EncodeError("background save already in progress"))
+		return
+	}
+
+	if !cmd.Background {
+		dirty := s.store.Dirty()
+		if err := s.saveSnapshot(); err != nil {
+			s.logger.Error("failed to handle SAVE command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+			client.SendMessage(resp.EncodeError(err.Error()))
+			return
+		}
+
+		s.lastSave = time.Now()
+		s.dirtySave = dirty
+		s.logger.Info("snapshot saved", "path", s.snapshotPath)
+		client.SendMessage(resp.EncodeSimpleString("OK"))
+		return
+	}
+
+	s.startBackgroundSave()
+	client.SendMessage(resp.EncodeSimpleString("Background saving started"))
+}
+
+// Handles the LASTSAVE command from a client.
+func (s *Server) handleLastSaveCommand(client *Client) {
+	client.SendMessage(resp.EncodeInteger(s.lastSave.Unix()))
+}
+
+// Starts a snapshot and writes it on a background goroutine. Its result is sent to bgsaveDone.
+// Must be called from the server loop.
+func (s *Server) startBackgroundSave() {
+	s.bgsaving = true
+	s.bgsaveDirty = s.store.Dirty()
+	s.lastBGSave = time.Now()
+
+	snapshot, metadata, err := s.beginSnapshot()
+	if err != nil {
+		s.bgsaveDone <- err
+		return
+	}
+	go func() {
+		s.bgsaveDone <- s.writeSnapshot(snapshot, metadata)
+	}()
+}
+
+// Records the end of a BGSAVE.
+// Must be called from the server loop.
+func (s *Server) handleBackgroundSaveDone(err error) {
+	s.bgsaving = false
+	s.lastSaveErr = err
+	if err != nil {
+		s.logger.Error("background save failed", "path", s.snapshotPath, "error", err)
+		return
+	}
+
+	s.lastSave = time.Now()
+	s.dirtySave = s.bgsaveDirty
+	s.logger.Info("background save completed", "path", s.snapshotPath)
+}
+
+// Automatic snapshot trigger, a BGSAVE is started once After has elapsed since the last snapshot
+// and the store changed at least Changes times.
+type SaveRule struct {
+	After   time.Duration
+	Changes uint64
+}
+
+// Parses automatic snapshot rules given as pairs of seconds and changes, like Redis' save
+// directive, e.g. "3600 1 300 100 60 10000". An empty spec disables automatic snapshots.
+func ParseSaveRules(spec string) ([]SaveRule, error) {
+	fields := strings.Fields(spec)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("save rules must be a list of seconds and changes pairs")
+	}
+
+	var rules []SaveRule
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.Atoi(fields[i])
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid seconds %q in save rule, must be a positive integer", fields[i])
+		}
+		changes, err := strconv.ParseUint(fields[i+1], 10, 64)
+		if err != nil || changes == 0 {
+			return nil, fmt.Errorf("invalid changes %q in save rule, must be a positive integer", fields[i+1])
+		}
+
+		rules = append(rules, SaveRule{After: time.Duration(seconds) * time.Second, Changes: changes})
+	}
+	return rules, nil
+}
+
+// Sets the rules starting a BGSAVE automatically. Rules only apply when snapshots are enabled.
+// Must be called before ListenAndServe.
+func (s *Server) SetSaveRules(rules []SaveRule) {
+	s.saveRules = rules
+}
+
+// Starts a BGSAVE if any save rule is met. After a failed BGSAVE, the next one waits
+// saveRetryDelay so a full disk is not hammered every second.
+// Must be called from the server loop.
+func (s *Server) checkSaveRules() {
+	if s.bgsaving {
+		return
+	}
+	if s.lastSaveErr != nil && time.Since(s.lastBGSave) < saveRetryDelay {
+		return
+	}
+
+	changes := s.store.Dirty() - s.dirtySave
+	elapsed := time.Since(s.lastSave)
+	for _, rule := range s.saveRules {
+		if changes >= rule.Changes && elapsed >= rule.After {
+			s.logger.Info("starting automatic background save", "changes", changes, "seconds", int(rule.After.Seconds()))
+			s.startBackgroundSave()
+			return
+		}
+	}
+}
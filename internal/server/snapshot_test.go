@@ -0,0 +1,272 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+func TestSaveAndLoadSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dump.gsdb")
+
+	s := newTestServer(t)
+	if err := s.SetSnapshotFile(path); err != nil {
+		t.Fatal(err)
+	}
+	s.store.Set([]byte("key"), []byte("value"), -1)
+	if err := s.saveSnapshot(); err != nil {
+		t.Fatalf("Failed to save snapshot: %v", err)
+	}
+
+	// Only the snapshot is left once the temporary file is renamed
+	if files, _ := os.ReadDir(dir); len(files) != 1 || files[0].Name() != "dump.gsdb" {
+		t.Errorf("Expected only the snapshot in the directory, got %v", files)
+	}
+
+	restored := newTestServer(t)
+	restored.SetSnapshotFile(path)
+	if err := restored.loadSnapshot(); err != nil {
+		t.Fatalf("Failed to load snapshot: %v", err)
+	}
+	if value, _ := restored.store.GetValue([]byte("key")); string(value) != "value" {
+		t.Errorf("Expected the key to be restored, got %q", value)
+	}
+
+	missing := newTestServer(t)
+	missing.SetSnapshotFile(filepath.Join(dir, "missing.gsdb"))
+	if err := missing.loadSnapshot(); err != nil {
+		t.Errorf("Expected a missing snapshot to start empty, got %v", err)
+	}
+}
+
+func TestSaveCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.gsdb")
+
+	s := newTestServer(t)
+	client := newTestClient()
+	run := func(args ...string) string {
+		cmd, err := parseCommand(commandArray(args...), nil)
+		if err != nil {
+			t.Fatalf("Failed to parse %v: %v", args, err)
+		}
+		s.processMessages([]Message{{cmd: cmd, args: commandArgs(commandArray(args...)), client: client}})
+		return strings.Join(drainReplies(client), "")
+	}
+
+	if reply := run("SAVE"); !strings.HasPrefix(reply, "-") {
+		t.Errorf("Expected SAVE to fail without a snapshot file, got %q", reply)
+	}
+
+	s.SetSnapshotFile(path)
+	run("SET", "key", "before")
+	if reply := run("SAVE"); reply != "+OK\r\n" {
+		t.Fatalf("Expected SAVE to reply OK, got %q", reply)
+	}
+
+	run("SET", "key", "after")
+	if reply := run("BGSAVE"); reply != "+Background saving started\r\n" {
+		t.Fatalf("Expected BGSAVE to start, got %q", reply)
+	}
+	if reply := run("BGSAVE"); !strings.HasPrefix(reply, "-") {
+		t.Errorf("Expected a second BGSAVE to fail while one is running, got %q", reply)
+	}
+	if err := <-s.bgsaveDone; err != nil {
+		t.Fatalf("Background save failed: %v", err)
+	}
+	s.handleBackgroundSaveDone(nil)
+
+	restored := newTestServer(t)
+	restored.SetSnapshotFile(path)
+	if err := restored.loadSnapshot(); err != nil {
+		t.Fatalf("Failed to load snapshot: %v", err)
+	}
+	if value, _ := restored.store.GetValue([]byte("key")); string(value) != "after" {
+		t.Errorf("Expected the background save to have the latest value, got %q", value)
+	}
+}
+
+func TestParseSaveRules(t *testing.T) {
+	rules, err := ParseSaveRules("3600 1 300 100")
+	if err != nil || len(rules) != 2 || rules[1] != (SaveRule{After: 300 * time.Second, Changes: 100}) {
+		t.Errorf("Expected 2 rules, got %v (%v)", rules, err)
+	}
+	if rules, err := ParseSaveRules(""); err != nil || len(rules) != 0 {
+		t.Errorf("Expected no rules for an empty spec, got %v (%v)", rules, err)
+	}
+
+	for _, spec := range []string{"60", "0 1", "60 0", "-1 5", "60 many"} {
+		if _, err := ParseSaveRules(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestSaveRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.gsdb")
+
+	s := newTestServer(t)
+	s.SetSnapshotFile(path)
+	s.SetSaveRules([]SaveRule{{After: time.Minute, Changes: 2}})
+	started := s.lastSave
+
+	s.store.Set([]byte("a"), []byte("1"), -1)
+	s.store.Set([]byte("b"), []byte("2"), -1)
+	s.checkSaveRules()
+	if s.bgsaving {
+		t.Fatal("Expected no save before the rule's time elapsed")
+	}
+
+	s.lastSave = s.lastSave.Add(-time.Minute)
+	s.checkSaveRules()
+	if !s.bgsaving {
+		t.Fatal("Expected a save once the rule is met")
+	}
+	s.handleBackgroundSaveDone(<-s.bgsaveDone)
+	if !s.lastSave.After(started) {
+		t.Errorf("Expected the last save time to advance, got %v", s.lastSave)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the snapshot to be written: %v", err)
+	}
+
+	// Changes are counted from the last snapshot
+	s.lastSave = s.lastSave.Add(-time.Minute)
+	s.store.Set([]byte("c"), []byte("3"), -1)
+	s.checkSaveRules()
+	if s.bgsaving {
+		t.Error("Expected no save with fewer changes than the rule since the last snapshot")
+	}
+
+	client := newTestClient()
+	s.processMessages([]Message{{cmd: LastSaveCommand{}, client: client}})
+	if reply := strings.Join(drainReplies(client), ""); reply != fmt.Sprintf(":%d\r\n", s.lastSave.Unix()) {
+		t.Errorf("Expected LASTSAVE to return the last save time, got %q", reply)
+	}
+}
+
+func TestHybridRestore(t *testing.T) {
+	dir := t.TempDir()
+	snapshotPath, aofPath := filepath.Join(dir, "dump.gsdb"), filepath.Join(dir, "appendonly.aof")
+
+	newHybridServer := func() *Server {
+		s := newTestServer(t)
+		s.SetSnapshotFile(snapshotPath)
+		s.SetAppendOnly(aofPath, FsyncAlways)
+		return s
+	}
+
+	s := newHybridServer()
+	if err := s.restore(); err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient()
+	run := func(args ...string) {
+		cmd, err := parseCommand(commandArray(args...), nil)
+		if err != nil {
+			t.Fatalf("Failed to parse %v: %v", args, err)
+		}
+		s.processMessages([]Message{{cmd: cmd, args: commandArgs(commandArray(args...)), client: client}})
+		drainReplies(client)
+	}
+
+	run("SET", "before", "1")
+	run("RPUSH", "list", "a")
+	if err := s.saveSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	offset := s.aof.size
+	run("SET", "after", "2")
+	run("RPUSH", "list", "b")
+	if err := s.aof.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the commands after the snapshot are replayed, so damaging the ones before it is harmless
+	file, err := os.OpenFile(aofPath, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteAt(bytes.Repeat([]byte("x"), int(offset)), 0)
+	file.Close()
+
+	restored := newHybridServer()
+	if err := restored.restore(); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	defer restored.aof.close()
+
+	for key, expected := range map[string]string{"before": "1", "after": "2"} {
+		if value, _ := restored.store.GetValue([]byte(key)); string(value) != expected {
+			t.Errorf("Expected %s to be %q, got %q", key, expected, value)
+		}
+	}
+	if list, _ := restored.store.GetList([]byte("list")); len(list) != 2 {
+		t.Errorf("Expected the list pushes before and after the snapshot, got %q", list)
+	}
+
+	// A snapshot taken further than the end of the append-only file does not belong with it
+	if err := os.Truncate(aofPath, offset-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := newHybridServer().restore(); err == nil {
+		t.Error("Expected an error for an append-only file shorter than the snapshot offset")
+	}
+}
+
+func TestSyncCommand(t *testing.T) {
+	s := newTestServer(t)
+	client := newTestClient()
+	run := func(args ...string) string {
+		cmd, err := parseCommand(commandArray(args...), nil)
+		if err != nil {
+			t.Fatalf("Failed to parse %v: %v", args, err)
+		}
+		s.processMessages([]Message{{cmd: cmd, args: commandArgs(commandArray(args...)), client: client}})
+		return strings.Join(drainReplies(client), "")
+	}
+
+	run("SET", "key", "before")
+	run("RPUSH", "list", "a", "b")
+	if reply := run("SYNC"); reply != "" {
+		t.Fatalf("Expected SYNC to block until the snapshot is encoded, got %q", reply)
+	}
+
+	// Commands sent meanwhile run after the snapshot, which does not include them
+	if reply := run("SET", "key", "after"); reply != "" {
+		t.Errorf("Expected the command to wait for the snapshot, got %q", reply)
+	}
+	s.handleSyncDone(<-s.syncDone)
+
+	replies := drainReplies(client)
+	if len(replies) != 2 || replies[1] != "+OK\r\n" {
+		t.Fatalf("Expected the snapshot then the queued reply, got %d replies", len(replies))
+	}
+	val, err := resp.ReadRESP(bufio.NewReader(strings.NewReader(replies[0])))
+	if err != nil {
+		t.Fatalf("Failed to read the snapshot reply: %v", err)
+	}
+	data, ok := val.(resp.RespBulkString)
+	if !ok {
+		t.Fatalf("Expected a bulk string, got %v", val)
+	}
+
+	loaded := NewInMemoryKVStore()
+	defer loaded.Close()
+	if keys, err := loaded.LoadSnapshot(bytes.NewReader(data.Value)); err != nil || keys != 2 {
+		t.Fatalf("Expected 2 keys to be loaded, got %d (%v)", keys, err)
+	}
+	if value, _ := loaded.GetValue([]byte("key")); string(value) != "before" {
+		t.Errorf("Expected the snapshot to hold the value from when SYNC ran, got %q", value)
+	}
+	if value, _ := s.store.GetValue([]byte("key")); string(value) != "after" {
+		t.Errorf("Expected the queued command to run, got %q", value)
+	}
+}
@@ -0,0 +1,435 @@
+package server
+
+import "math/rand/v2"
+
+const (
+	skipListMaxLevel = 32
+	// Probability of a node being promoted to the next level.
+	skipListP = 0.25
+)
+
+type skipListLevel struct {
+	forward *skipListNode
+	span    int // Number of nodes skipped by the forward pointer, used to compute ranks
+}
+
+type skipListNode struct {
+	member   string
+	score    float64
+	backward *skipListNode
+	level    []skipListLevel
+}
+
+// A member of a sorted set together with its score.
+type ScoredMember struct {
+	Member []byte
+	Score  float64
+}
+
+// Sorted set implemented as a skip list ordered by score and member, plus a map of member scores.
+// Ranks are 0-based. Not safe for concurrent use.
+type sortedSet struct {
+	scores map[string]float64
+	header *skipListNode
+	tail   *skipListNode
+	length int
+	level  int
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{
+		scores: make(map[string]float64),
+		header: &skipListNode{level: make([]skipListLevel, skipListMaxLevel)},
+		level:  1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Float64() < skipListP {
+		level++
+	}
+	return level
+}
+
+// Reports whether the node sorts before the given score and member.
+func (n *skipListNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+func (z *sortedSet) len() int {
+	return z.length
+}
+
+func (z *sortedSet) score(member string) (float64, bool) {
+	score, ok := z.scores[member]
+	return score, ok
+}
+
+// Adds a member or updates its score. Returns true if the member is new.
+func (z *sortedSet) add(member string, score float64) bool {
+	old, exists := z.scores[member]
+	if exists {
+		if old != score {
+			z.delete(member, old)
+			z.insert(member, score)
+			z.scores[member] = score
+		}
+		return false
+	}
+
+	z.insert(member, score)
+	z.scores[member] = score
+	return true
+}
+
+// Removes a member. Returns true if the member existed.
+func (z *sortedSet) remove(member string) bool {
+	score, exists := z.scores[member]
+	if !exists {
+		return false
+	}
+
+	z.delete(member, score)
+	delete(z.scores, member)
+	return true
+}
+
+func (z *sortedSet) insert(member string, score float64) {
+	var update [skipListMaxLevel]*skipListNode
+	var rank [skipListMaxLevel]int
+
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		if i < z.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > z.level {
+		for i := z.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = z.header
+			update[i].level[i].span = z.length
+		}
+		z.level = level
+	}
+
+	x = &skipListNode{
+		member: member,
+		score:  score,
+		level:  make([]skipListLevel, level),
+	}
+
+	for i := range level {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = (rank[0] - rank[i]) + 1
+	}
+
+	// Levels above the new node now skip one more node
+	for i := level; i < z.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != z.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		z.tail = x
+	}
+
+	z.length++
+}
+
+func (z *sortedSet) delete(member string, score float64) {
+	var update [skipListMaxLevel]*skipListNode
+
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x != nil && x.score == score && x.member == member {
+		z.deleteNode(x, update[:z.level])
+	}
+}
+
+// Unlinks a node given the last node before it at each level.
+func (z *sortedSet) deleteNode(x *skipListNode, update []*skipListNode) {
+	for i := range z.level {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		z.tail = x.backward
+	}
+
+	for z.level > 1 && z.header.level[z.level-1].forward == nil {
+		z.level--
+	}
+
+	z.length--
+}
+
+// Returns the node at the given 0-based rank, or nil if out of range.
+func (z *sortedSet) nodeByRank(rank int) *skipListNode {
+	if rank < 0 || rank >= z.length {
+		return nil
+	}
+
+	// Spans count from the header, so the first node is at traversed position 1
+	target := rank + 1
+	traversed := 0
+
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= target {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == target {
+			return x
+		}
+	}
+
+	return nil
+}
+
+// Returns the members between the 0-based ranks start and stop inclusive, both already within range.
+// When rev is true ranks are counted from the highest score.
+func (z *sortedSet) rangeByRank(start, stop int, rev bool) []ScoredMember {
+	if start > stop || start >= z.length {
+		return nil
+	}
+
+	var x *skipListNode
+	if rev {
+		x = z.nodeByRank(z.length - 1 - start)
+	} else {
+		x = z.nodeByRank(start)
+	}
+
+	members := make([]ScoredMember, 0, stop-start+1)
+	for i := start; i <= stop && x != nil; i++ {
+		members = append(members, ScoredMember{Member: []byte(x.member), Score: x.score})
+		if rev {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+
+	return members
+}
+
+// Range of sorted set members used by the range queries. Implemented by ScoreRange and LexRange.
+type ZRangeSpec interface {
+	gteMin(n *skipListNode) bool // Reports whether the node is not below the start of the range
+	lteMax(n *skipListNode) bool // Reports whether the node is not above the end of the range
+}
+
+// Range of scores, each bound can be exclusive and infinite.
+type ScoreRange struct {
+	Min, Max                   float64
+	MinExclusive, MaxExclusive bool
+}
+
+func (r ScoreRange) gteMin(n *skipListNode) bool {
+	if r.MinExclusive {
+		return n.score > r.Min
+	}
+	return n.score >= r.Min
+}
+
+func (r ScoreRange) lteMax(n *skipListNode) bool {
+	if r.MaxExclusive {
+		return n.score < r.Max
+	}
+	return n.score <= r.Max
+}
+
+// Bound of a lexicographic range.
+type LexBound struct {
+	Value     string
+	Exclusive bool
+	Infinity  int // -1 for the "-" bound below every member, 1 for the "+" bound above every member
+}
+
+// Lexicographic range of members, only meaningful when all members share the same score.
+type LexRange struct {
+	Min, Max LexBound
+}
+
+func (r LexRange) gteMin(n *skipListNode) bool {
+	switch r.Min.Infinity {
+	case -1:
+		return true
+	case 1:
+		return false
+	}
+
+	if r.Min.Exclusive {
+		return n.member > r.Min.Value
+	}
+	return n.member >= r.Min.Value
+}
+
+func (r LexRange) lteMax(n *skipListNode) bool {
+	switch r.Max.Infinity {
+	case 1:
+		return true
+	case -1:
+		return false
+	}
+
+	if r.Max.Exclusive {
+		return n.member < r.Max.Value
+	}
+	return n.member <= r.Max.Value
+}
+
+// Returns the first node within the range, or nil if the range is empty.
+func (z *sortedSet) firstInRange(spec ZRangeSpec) *skipListNode {
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !spec.gteMin(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+
+	x = x.level[0].forward
+	if x == nil || !spec.lteMax(x) {
+		return nil
+	}
+	return x
+}
+
+// Returns the last node within the range, or nil if the range is empty.
+func (z *sortedSet) lastInRange(spec ZRangeSpec) *skipListNode {
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && spec.lteMax(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+
+	if x == z.header || !spec.gteMin(x) {
+		return nil
+	}
+	return x
+}
+
+// Returns the members within the range, from the highest to the lowest if rev is true.
+// Skips the first offset members and returns at most count members, a negative count means no limit.
+func (z *sortedSet) rangeBy(spec ZRangeSpec, rev bool, offset, count int) []ScoredMember {
+	if offset < 0 {
+		return nil
+	}
+
+	var x *skipListNode
+	if rev {
+		x = z.lastInRange(spec)
+	} else {
+		x = z.firstInRange(spec)
+	}
+
+	next := func(n *skipListNode) *skipListNode {
+		if rev {
+			return n.backward
+		}
+		return n.level[0].forward
+	}
+
+	for ; x != nil && offset > 0; offset-- {
+		x = next(x)
+	}
+
+	var members []ScoredMember
+	for x != nil && count != 0 {
+		if rev && !spec.gteMin(x) || !rev && !spec.lteMax(x) {
+			break
+		}
+
+		members = append(members, ScoredMember{Member: []byte(x.member), Score: x.score})
+		x = next(x)
+		count--
+	}
+
+	return members
+}
+
+// Removes the members within the range. Returns the number of members removed.
+func (z *sortedSet) deleteRangeBy(spec ZRangeSpec) int {
+	var update [skipListMaxLevel]*skipListNode
+
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !spec.gteMin(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	removed := 0
+	x = x.level[0].forward
+	for x != nil && spec.lteMax(x) {
+		next := x.level[0].forward
+		z.deleteNode(x, update[:z.level])
+		delete(z.scores, x.member)
+		removed++
+		x = next
+	}
+
+	return removed
+}
+
+// Removes the members between the 0-based ranks start and stop inclusive, both already within range.
+// Returns the number of members removed.
+func (z *sortedSet) deleteRangeByRank(start, stop int) int {
+	var update [skipListMaxLevel]*skipListNode
+
+	// Find the last node before rank start at each level, spans count from the header
+	traversed := 0
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= start {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	removed := 0
+	x = x.level[0].forward
+	for rank := start; x != nil && rank <= stop; rank++ {
+		next := x.level[0].forward
+		z.deleteNode(x, update[:z.level])
+		delete(z.scores, x.member)
+		removed++
+		x = next
+	}
+
+	return removed
+}
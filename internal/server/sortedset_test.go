@@ -0,0 +1,238 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// Returns the members of a reference map sorted by score and member.
+func sortedReference(ref map[string]float64) []ScoredMember {
+	members := make([]ScoredMember, 0, len(ref))
+	for member, score := range ref {
+		members = append(members, ScoredMember{Member: []byte(member), Score: score})
+	}
+
+	slices.SortFunc(members, func(a, b ScoredMember) int {
+		if a.Score != b.Score {
+			if a.Score < b.Score {
+				return -1
+			}
+			return 1
+		}
+		return slices.Compare(a.Member, b.Member)
+	})
+	return members
+}
+
+func TestSortedSetRandomOperations(t *testing.T) {
+	z := newSortedSet()
+	ref := make(map[string]float64)
+
+	for range 5000 {
+		member := fmt.Sprintf("member%d", rand.IntN(500))
+		if rand.IntN(4) == 0 {
+			z.remove(member)
+			delete(ref, member)
+		} else {
+			// Few distinct scores so members with equal scores are common
+			score := float64(rand.IntN(50))
+			z.add(member, score)
+			ref[member] = score
+		}
+	}
+
+	expected := sortedReference(ref)
+	if z.len() != len(expected) {
+		t.Fatalf("Expected length %d, got %d", len(expected), z.len())
+	}
+
+	got := z.rangeByRank(0, z.len()-1, false)
+	for i := range expected {
+		if string(got[i].Member) != string(expected[i].Member) || got[i].Score != expected[i].Score {
+			t.Fatalf("Mismatch at rank %d: expected %s (%v), got %s (%v)",
+				i, expected[i].Member, expected[i].Score, got[i].Member, got[i].Score)
+		}
+	}
+
+	// Every rank can be reached directly through the spans
+	for i := range expected {
+		node := z.nodeByRank(i)
+		if node == nil || node.member != string(expected[i].Member) {
+			t.Fatalf("nodeByRank(%d) returned the wrong node", i)
+		}
+	}
+}
+
+func TestSortedSetRangeByRank(t *testing.T) {
+	z := newSortedSet()
+	for i, member := range []string{"a", "b", "c", "d", "e"} {
+		z.add(member, float64(i))
+	}
+
+	tests := []struct {
+		start, stop int
+		rev         bool
+		want        []string
+	}{
+		{0, 4, false, []string{"a", "b", "c", "d", "e"}},
+		{1, 2, false, []string{"b", "c"}},
+		{0, 1, true, []string{"e", "d"}},
+		{3, 4, true, []string{"b", "a"}},
+		{3, 1, false, nil},
+		{5, 6, false, nil},
+	}
+
+	for _, tt := range tests {
+		got := z.rangeByRank(tt.start, tt.stop, tt.rev)
+		if len(got) != len(tt.want) {
+			t.Errorf("rangeByRank(%d, %d, %v) returned %d members, want %d", tt.start, tt.stop, tt.rev, len(got), len(tt.want))
+			continue
+		}
+		for i := range got {
+			if string(got[i].Member) != tt.want[i] {
+				t.Errorf("rangeByRank(%d, %d, %v)[%d] = %s, want %s", tt.start, tt.stop, tt.rev, i, got[i].Member, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestSortedSetRangeByScore(t *testing.T) {
+	z := newSortedSet()
+	for i, member := range []string{"a", "b", "c", "d", "e"} {
+		z.add(member, float64(i+1))
+	}
+
+	inf := math.Inf(1)
+	tests := []struct {
+		name string
+		r    ScoreRange
+		rev  bool
+		want []string
+	}{
+		{"inclusive", ScoreRange{Min: 2, Max: 4}, false, []string{"b", "c", "d"}},
+		{"exclusive", ScoreRange{Min: 2, Max: 4, MinExclusive: true, MaxExclusive: true}, false, []string{"c"}},
+		{"infinite", ScoreRange{Min: -inf, Max: inf}, false, []string{"a", "b", "c", "d", "e"}},
+		{"reverse", ScoreRange{Min: 2, Max: 4}, true, []string{"d", "c", "b"}},
+		{"reverse exclusive", ScoreRange{Min: 1, Max: 5, MinExclusive: true, MaxExclusive: true}, true, []string{"d", "c", "b"}},
+		{"empty", ScoreRange{Min: 3, Max: 3, MinExclusive: true}, false, nil},
+		{"inverted", ScoreRange{Min: 4, Max: 2}, false, nil},
+		{"above all", ScoreRange{Min: 10, Max: inf}, true, nil},
+	}
+
+	for _, tt := range tests {
+		assertMembers(t, tt.name, z.rangeBy(tt.r, tt.rev, 0, -1), tt.want)
+	}
+}
+
+func TestSortedSetRangeByLex(t *testing.T) {
+	z := newSortedSet()
+	for _, member := range []string{"apple", "banana", "cherry", "date"} {
+		z.add(member, 0)
+	}
+
+	tests := []struct {
+		name string
+		r    LexRange
+		rev  bool
+		want []string
+	}{
+		{"all", LexRange{Min: LexBound{Infinity: -1}, Max: LexBound{Infinity: 1}}, false, []string{"apple", "banana", "cherry", "date"}},
+		{"inclusive", LexRange{Min: LexBound{Value: "banana"}, Max: LexBound{Value: "cherry"}}, false, []string{"banana", "cherry"}},
+		{"exclusive", LexRange{Min: LexBound{Value: "banana", Exclusive: true}, Max: LexBound{Infinity: 1}}, false, []string{"cherry", "date"}},
+		{"prefix", LexRange{Min: LexBound{Value: "b"}, Max: LexBound{Value: "c", Exclusive: true}}, false, []string{"banana"}},
+		{"reverse", LexRange{Min: LexBound{Infinity: -1}, Max: LexBound{Value: "banana"}}, true, []string{"banana", "apple"}},
+		{"plus as min", LexRange{Min: LexBound{Infinity: 1}, Max: LexBound{Infinity: 1}}, false, nil},
+	}
+
+	for _, tt := range tests {
+		assertMembers(t, tt.name, z.rangeBy(tt.r, tt.rev, 0, -1), tt.want)
+	}
+}
+
+func TestSortedSetRangeByLimit(t *testing.T) {
+	z := newSortedSet()
+	for i, member := range []string{"a", "b", "c", "d", "e"} {
+		z.add(member, float64(i+1))
+	}
+
+	all := ScoreRange{Min: math.Inf(-1), Max: math.Inf(1)}
+	tests := []struct {
+		name          string
+		rev           bool
+		offset, count int
+		want          []string
+	}{
+		{"first page", false, 0, 2, []string{"a", "b"}},
+		{"second page", false, 2, 2, []string{"c", "d"}},
+		{"past the end", false, 10, 2, nil},
+		{"no limit", false, 3, -1, []string{"d", "e"}},
+		{"zero count", false, 0, 0, nil},
+		{"negative offset", false, -1, 2, nil},
+		{"reverse", true, 1, 2, []string{"d", "c"}},
+	}
+
+	for _, tt := range tests {
+		assertMembers(t, tt.name, z.rangeBy(all, tt.rev, tt.offset, tt.count), tt.want)
+	}
+}
+
+func TestSortedSetDeleteRange(t *testing.T) {
+	z := newSortedSet()
+	ref := make(map[string]float64)
+	for i := range 200 {
+		member := fmt.Sprintf("member%03d", i)
+		z.add(member, float64(i))
+		ref[member] = float64(i)
+	}
+
+	if removed := z.deleteRangeByRank(10, 49); removed != 40 {
+		t.Errorf("Expected 40 members removed by rank, got %d", removed)
+	}
+	for i := 10; i < 50; i++ {
+		delete(ref, fmt.Sprintf("member%03d", i))
+	}
+
+	if removed := z.deleteRangeBy(ScoreRange{Min: 100, Max: 150, MaxExclusive: true}); removed != 50 {
+		t.Errorf("Expected 50 members removed by score, got %d", removed)
+	}
+	for i := 100; i < 150; i++ {
+		delete(ref, fmt.Sprintf("member%03d", i))
+	}
+
+	if removed := z.deleteRangeBy(ScoreRange{Min: 300, Max: 400}); removed != 0 {
+		t.Errorf("Expected no members removed outside the set, got %d", removed)
+	}
+
+	expected := sortedReference(ref)
+	if z.len() != len(expected) || len(z.scores) != len(expected) {
+		t.Fatalf("Expected length %d, got %d (%d scores)", len(expected), z.len(), len(z.scores))
+	}
+
+	// Spans must still be consistent after removing runs of nodes
+	for i := range expected {
+		node := z.nodeByRank(i)
+		if node == nil || node.member != string(expected[i].Member) {
+			t.Fatalf("nodeByRank(%d) returned the wrong node", i)
+		}
+	}
+	if z.tail == nil || z.tail.member != string(expected[len(expected)-1].Member) {
+		t.Error("Expected tail to point to the last member")
+	}
+}
+
+func assertMembers(t *testing.T, name string, got []ScoredMember, want []string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Errorf("%s: got %d members, want %d", name, len(got), len(want))
+		return
+	}
+	for i := range got {
+		if string(got[i].Member) != want[i] {
+			t.Errorf("%s: member %d = %s, want %s", name, i, got[i].Member, want[i])
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// ID of a stream entry, ordered by the millisecond timestamp and then the sequence number.
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	minStreamID = StreamID{0, 0}
+	maxStreamID = StreamID{math.MaxUint64, math.MaxUint64}
+)
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+func (id StreamID) compare(other StreamID) int {
+	if c := cmp.Compare(id.Ms, other.Ms); c != 0 {
+		return c
+	}
+	return cmp.Compare(id.Seq, other.Seq)
+}
+
+// Returns the smallest ID greater than id. Returns false if id is the maximum ID.
+func (id StreamID) next() (StreamID, bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return StreamID{id.Ms, id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return StreamID{id.Ms + 1, 0}, true
+	default:
+		return id, false
+	}
+}
+
+// Returns the largest ID smaller than id. Returns false if id is the minimum ID.
+func (id StreamID) prev() (StreamID, bool) {
+	switch {
+	case id.Seq > 0:
+		return StreamID{id.Ms, id.Seq - 1}, true
+	case id.Ms > 0:
+		return StreamID{id.Ms - 1, math.MaxUint64}, true
+	default:
+		return id, false
+	}
+}
+
+// An entry of a stream with its field/value pairs.
+type StreamEntry struct {
+	ID     StreamID
+	Fields [][]byte // Flat list of field/value pairs
+}
+
+// Append-only log of entries ordered by ID. Not safe for concurrent use.
+type stream struct {
+	entries []StreamEntry
+	lastID  StreamID                  // ID of the last entry ever added, kept when entries are trimmed
+	groups  map[string]*consumerGroup // nil until the first group is created
+}
+
+func newStream() *stream {
+	return &stream{}
+}
+
+func (s *stream) len() int {
+	return len(s.entries)
+}
+
+// Returns the index of the first entry with an ID not smaller than id.
+func (s *stream) search(id StreamID) int {
+	return sort.Search(len(s.entries), func(i int) bool {
+		return s.entries[i].ID.compare(id) >= 0
+	})
+}
+
+// Appends an entry. The ID must be greater than the last ID.
+func (s *stream) append(id StreamID, fields [][]byte) {
+	s.entries = append(s.entries, StreamEntry{ID: id, Fields: fields})
+	s.lastID = id
+}
+
+// Removes the oldest entries so at most maxLen remain. Returns the number of entries removed.
+func (s *stream) trim(maxLen int) int {
+	removed := len(s.entries) - maxLen
+	if removed <= 0 {
+		return 0
+	}
+
+	// Clear the trimmed entries so their fields can be released before the array is reallocated
+	clear(s.entries[:removed])
+	s.entries = s.entries[removed:]
+	return removed
+}
+
+// Returns the entries with IDs between start and end inclusive, from the newest to the oldest if rev is true.
+// A negative count means no limit.
+func (s *stream) rangeByID(start, end StreamID, count int, rev bool) []StreamEntry {
+	if start.compare(end) > 0 || count == 0 {
+		return nil
+	}
+
+	// Entries [lo, hi) are within the range
+	lo := s.search(start)
+	hi := len(s.entries)
+	if next, ok := end.next(); ok {
+		hi = s.search(next)
+	}
+
+	if count > 0 && hi-lo > count {
+		if rev {
+			lo = hi - count
+		} else {
+			hi = lo + count
+		}
+	}
+
+	entries := make([]StreamEntry, 0, hi-lo)
+	if rev {
+		for i := hi - 1; i >= lo; i-- {
+			entries = append(entries, s.entries[i])
+		}
+	} else {
+		entries = append(entries, s.entries[lo:hi]...)
+	}
+
+	return entries
+}
+
+// Returns the entry with the given ID.
+func (s *stream) entry(id StreamID) (StreamEntry, bool) {
+	i := s.search(id)
+	if i < len(s.entries) && s.entries[i].ID == id {
+		return s.entries[i], true
+	}
+	return StreamEntry{}, false
+}
+
+// An entry delivered to a consumer of a group and not yet acknowledged.
+type pendingEntry struct {
+	id          StreamID
+	consumer    *streamConsumer
+	deliveredAt int64 // Unix milliseconds of the last delivery
+	deliveries  int64
+}
+
+type streamConsumer struct {
+	name    string
+	pending map[StreamID]*pendingEntry
+}
+
+// Consumer group reading a stream, tracking the last delivered ID and the pending entries list (PEL).
+type consumerGroup struct {
+	lastID    StreamID
+	pel       []*pendingEntry // Ordered by ID
+	consumers map[string]*streamConsumer
+}
+
+func newConsumerGroup(lastID StreamID) *consumerGroup {
+	return &consumerGroup{
+		lastID:    lastID,
+		consumers: make(map[string]*streamConsumer),
+	}
+}
+
+// Returns the consumer with the given name, creating it if create is true.
+// Returns nil if the consumer does not exist and create is false.
+func (g *consumerGroup) consumer(name string, create bool) *streamConsumer {
+	c, exists := g.consumers[name]
+	if !exists && create {
+		c = &streamConsumer{name: name, pending: make(map[StreamID]*pendingEntry)}
+		g.consumers[name] = c
+	}
+	return c
+}
+
+// Returns the index of the first pending entry with an ID not smaller than id.
+func (g *consumerGroup) searchPending(id StreamID) int {
+	return sort.Search(len(g.pel), func(i int) bool {
+		return g.pel[i].id.compare(id) >= 0
+	})
+}
+
+// Records the delivery of an entry to a consumer. Entries already pending are transferred to the consumer.
+func (g *consumerGroup) deliver(id StreamID, c *streamConsumer, now int64) {
+	i := g.searchPending(id)
+	if i < len(g.pel) && g.pel[i].id == id {
+		pe := g.pel[i]
+		delete(pe.consumer.pending, id)
+		pe.consumer = c
+		pe.deliveredAt = now
+		pe.deliveries++
+		c.pending[id] = pe
+		return
+	}
+
+	pe := &pendingEntry{id: id, consumer: c, deliveredAt: now, deliveries: 1}
+	g.pel = slices.Insert(g.pel, i, pe)
+	c.pending[id] = pe
+}
+
+// Removes an entry from the pending entries list. Returns true if it was pending.
+func (g *consumerGroup) ack(id StreamID) bool {
+	i := g.searchPending(id)
+	if i >= len(g.pel) || g.pel[i].id != id {
+		return false
+	}
+
+	delete(g.pel[i].consumer.pending, id)
+	g.pel = slices.Delete(g.pel, i, i+1)
+	return true
+}
+
+// Deletes a consumer and its pending entries. Returns the number of entries that were pending.
+func (g *consumerGroup) deleteConsumer(name string) int {
+	c, exists := g.consumers[name]
+	if !exists {
+		return 0
+	}
+
+	pending := len(c.pending)
+	g.pel = slices.DeleteFunc(g.pel, func(pe *pendingEntry) bool {
+		return pe.consumer == c
+	})
+	delete(g.consumers, name)
+
+	return pending
+}
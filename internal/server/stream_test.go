@@ -0,0 +1,88 @@
+package server
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStreamIDNextAndPrev(t *testing.T) {
+	tests := []struct {
+		id     StreamID
+		next   StreamID
+		nextOK bool
+		prev   StreamID
+		prevOK bool
+	}{
+		{StreamID{5, 3}, StreamID{5, 4}, true, StreamID{5, 2}, true},
+		{StreamID{5, 0}, StreamID{5, 1}, true, StreamID{4, math.MaxUint64}, true},
+		{StreamID{5, math.MaxUint64}, StreamID{6, 0}, true, StreamID{5, math.MaxUint64 - 1}, true},
+		{minStreamID, StreamID{0, 1}, true, minStreamID, false},
+		{maxStreamID, maxStreamID, false, StreamID{math.MaxUint64, math.MaxUint64 - 1}, true},
+	}
+
+	for _, tt := range tests {
+		if next, ok := tt.id.next(); next != tt.next || ok != tt.nextOK {
+			t.Errorf("%s.next() = (%s, %v), want (%s, %v)", tt.id, next, ok, tt.next, tt.nextOK)
+		}
+		if prev, ok := tt.id.prev(); prev != tt.prev || ok != tt.prevOK {
+			t.Errorf("%s.prev() = (%s, %v), want (%s, %v)", tt.id, prev, ok, tt.prev, tt.prevOK)
+		}
+	}
+}
+
+func TestStreamRangeByID(t *testing.T) {
+	s := newStream()
+	for _, id := range []StreamID{{1, 0}, {1, 1}, {2, 0}, {3, 5}, {4, 0}} {
+		s.append(id, [][]byte{[]byte("field"), []byte("value")})
+	}
+
+	tests := []struct {
+		name       string
+		start, end StreamID
+		count      int
+		rev        bool
+		want       []StreamID
+	}{
+		{"all", minStreamID, maxStreamID, -1, false, []StreamID{{1, 0}, {1, 1}, {2, 0}, {3, 5}, {4, 0}}},
+		{"inclusive bounds", StreamID{1, 1}, StreamID{3, 5}, -1, false, []StreamID{{1, 1}, {2, 0}, {3, 5}}},
+		{"between entries", StreamID{1, 2}, StreamID{3, 0}, -1, false, []StreamID{{2, 0}}},
+		{"count", minStreamID, maxStreamID, 2, false, []StreamID{{1, 0}, {1, 1}}},
+		{"reverse count", minStreamID, maxStreamID, 2, true, []StreamID{{4, 0}, {3, 5}}},
+		{"zero count", minStreamID, maxStreamID, 0, false, nil},
+		{"inverted", maxStreamID, minStreamID, -1, false, nil},
+		{"after last", StreamID{5, 0}, maxStreamID, -1, false, nil},
+	}
+
+	for _, tt := range tests {
+		got := s.rangeByID(tt.start, tt.end, tt.count, tt.rev)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %d entries, want %d", tt.name, len(got), len(tt.want))
+			continue
+		}
+		for i := range got {
+			if got[i].ID != tt.want[i] {
+				t.Errorf("%s: entry %d is %s, want %s", tt.name, i, got[i].ID, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestStreamTrim(t *testing.T) {
+	s := newStream()
+	for i := range 10 {
+		s.append(StreamID{uint64(i + 1), 0}, nil)
+	}
+
+	if removed := s.trim(3); removed != 7 {
+		t.Errorf("Expected 7 entries removed, got %d", removed)
+	}
+	if s.len() != 3 || s.entries[0].ID != (StreamID{8, 0}) {
+		t.Errorf("Expected the 3 newest entries to remain, got %v", s.entries)
+	}
+
+	// The last ID is kept so new IDs keep increasing
+	s.trim(0)
+	if s.len() != 0 || s.lastID != (StreamID{10, 0}) {
+		t.Errorf("Expected empty stream with last ID 10-0, got %d entries and %s", s.len(), s.lastID)
+	}
+}
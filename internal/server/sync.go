@@ -0,0 +1,74 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Snapshot encoded for a client that sent SYNC.
+type syncResult struct {
+	bc   *blockedClient
+	data []byte
+	err  error
+}
+
+// Handles the SYNC command from a client, replying with a snapshot of every key as a bulk string in the
+// binary snapshot format. The snapshot is copy-on-write like BGSAVE, so it is consistent as of when the
+// command ran, and it is encoded on a background goroutine while the client is blocked, so other
+// clients keep running. Commands sent by the client in the meantime run once the snapshot was sent.
+func (s *Server) handleSyncCommand(client *Client) {
+	if err := s.startSync(client); err != nil {
+		s.logger.Error("failed to handle SYNC command", "error", err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(err.Error()))
+	}
+}
+
+// Starts a snapshot and encodes it on a background goroutine for a client, blocking the client until
+// handleSyncDone sends it.
+// Must be called from the server loop.
+func (s *Server) startSync(client *Client) error {
+	store, ok := s.store.(snapshotter)
+	if !ok {
+		return fmt.Errorf("the store does not support snapshots")
+	}
+
+	snapshot, err := store.BeginSnapshot()
+	if err != nil {
+		return err
+	}
+
+	bc := &blockedClient{client: client}
+	s.blockClient(bc, 0)
+	s.syncing++
+	go func() {
+		defer snapshot.Release()
+
+		var buf bytes.Buffer
+		err := snapshot.Encode(&buf, nil)
+		s.syncDone <- syncResult{bc: bc, data: buf.Bytes(), err: err}
+	}()
+	return nil
+}
+
+// Sends an encoded snapshot to the client that requested it and runs the commands it sent meanwhile.
+// Must be called from the server loop.
+func (s *Server) handleSyncDone(result syncResult) {
+	s.syncing--
+	client := result.bc.client
+	if client.blocked != result.bc {
+		// Disconnected while the snapshot was encoded
+		return
+	}
+	s.unblockClient(result.bc)
+
+	if result.err != nil {
+		s.logger.Error("failed to handle SYNC command", "error", result.err, "remoteAddr", client.conn.RemoteAddr().String())
+		client.SendMessage(resp.EncodeError(result.err.Error()))
+	} else {
+		client.SendMessage(resp.EncodeBulkString(result.data))
+	}
+	s.replicaSynced(client, result.err)
+	s.processMessages(client.takePending())
+}
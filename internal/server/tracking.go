@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/CDavidSV/GopherStore/internal/resp"
+)
+
+// Channel the invalidation messages of CLIENT TRACKING are published on.
+const invalidateChannel = "__redis__:invalidate"
+
+// Client-side caching state of a client with CLIENT TRACKING ON.
+type clientTracking struct {
+	redirect *Client             // Client receiving the invalidation messages
+	bcast    bool                // Invalidate every key changed, not only the keys read
+	prefixes [][]byte            // Only broadcast invalidations of keys with these prefixes, every key if empty
+	keys     map[string]struct{} // Keys read since they were last invalidated, unused in broadcast mode
+}
+
+// Handles CLIENT TRACKING ON and OFF, starting to watch the store the first time tracking is enabled.
+func (s *Server) handleClientTracking(cmd ClientCommand, client *Client) error {
+	if !cmd.Tracking {
+		s.disableTracking(client)
+		return nil
+	}
+
+	var redirect *Client
+	for c := range s.clients {
+		if c.id == cmd.Redirect {
+			redirect = c
+			break
+		}
+	}
+	if redirect == nil {
+		return fmt.Errorf("the client ID to redirect to does not exist")
+	}
+
+	if err := s.watchKeyspace(); err != nil {
+		return err
+	}
+
+	// Turning tracking on again replaces the previous options
+	s.disableTracking(client)
+	client.tracking = &clientTracking{
+		redirect: redirect,
+		bcast:    cmd.BCast,
+		prefixes: cmd.Prefixes,
+		keys:     make(map[string]struct{}),
+	}
+	if cmd.BCast {
+		s.trackingBcast[client] = struct{}{}
+	}
+
+	return nil
+}
+
+// Stops tracking the keys read by a client.
+func (s *Server) disableTracking(client *Client) {
+	if client.tracking == nil {
+		return
+	}
+
+	for key := range client.tracking.keys {
+		unsubscribe(s.trackedKeys, key, client)
+	}
+	delete(s.trackingBcast, client)
+	client.tracking = nil
+}
+
+// Remembers the keys read by a command of a client with tracking enabled, so it is told when they change.
+func (s *Server) trackReadKeys(msg Message) {
+	tracking := msg.client.tracking
+	if tracking == nil || tracking.bcast {
+		return
+	}
+
+	for _, key := range trackedReadKeys(msg.cmd) {
+		if subscribe(s.trackedKeys, string(key), msg.client) {
+			tracking.keys[string(key)] = struct{}{}
+		}
+	}
+}
+
+// Sends an invalidation message for a changed key to the clients that read it since it was last
+// invalidated and to the broadcasting clients with a matching prefix. Clients reading the key are
+// only told once, until they read it again.
+func (s *Server) invalidateKey(key []byte) {
+	if subs, ok := s.trackedKeys[string(key)]; ok {
+		delete(s.trackedKeys, string(key))
+		for client := range subs {
+			delete(client.tracking.keys, string(key))
+			s.sendInvalidation(client.tracking.redirect, key)
+		}
+	}
+
+	for client := range s.trackingBcast {
+		tracking := client.tracking
+		if len(tracking.prefixes) == 0 || hasAnyPrefix(key, tracking.prefixes) {
+			s.sendInvalidation(tracking.redirect, key)
+		}
+	}
+}
+
+// Sends a ["message", "__redis__:invalidate", [key]] message to the client receiving the
+// invalidations, if it is still connected and subscribed to the invalidation channel.
+func (s *Server) sendInvalidation(target *Client, key []byte) {
+	if _, connected := s.clients[target]; !connected {
+		return
+	}
+	if _, subscribed := target.channels[invalidateChannel]; !subscribed {
+		return
+	}
+
+	msg := resp.EncodeArray([][]byte{
+		resp.EncodeBulkString([]byte("message")),
+		resp.EncodeBulkString([]byte(invalidateChannel)),
+		resp.EncodeBulkStringArray([][]byte{key}),
+	})
+	if err := target.SendMessage(msg); err != nil {
+		s.logger.Warn("dropped invalidation message for slow client", "key", string(key), "remoteAddr", target.conn.RemoteAddr().String())
+	}
+}
+
+func hasAnyPrefix(key []byte, prefixes [][]byte) bool {
+	for _, prefix := range prefixes {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the keys whose values are returned by a read command, which a client may cache.
+func trackedReadKeys(cmd Command) [][]byte {
+	switch c := cmd.(type) {
+	case GetCommand:
+		return [][]byte{c.Key}
+	case MGetCommand:
+		return c.Keys
+	case GetOrSetCommand:
+		return [][]byte{c.Key}
+	case ExistsCommand:
+		return c.Keys
+	case LLenCommand:
+		return [][]byte{c.Key}
+	case LRangeCommand:
+		return [][]byte{c.Key}
+	case HGetCommand:
+		return [][]byte{c.Key}
+	case HMGetCommand:
+		return [][]byte{c.Key}
+	case HGetAllCommand:
+		return [][]byte{c.Key}
+	case HKeysCommand:
+		return [][]byte{c.Key}
+	case HLenCommand:
+		return [][]byte{c.Key}
+	case HExistsCommand:
+		return [][]byte{c.Key}
+	case SIsMemberCommand:
+		return [][]byte{c.Key}
+	case SMembersCommand:
+		return [][]byte{c.Key}
+	case SCardCommand:
+		return [][]byte{c.Key}
+	case ZScoreCommand:
+		return [][]byte{c.Key}
+	case ZCardCommand:
+		return [][]byte{c.Key}
+	case ZRangeCommand:
+		return [][]byte{c.Key}
+	case ZRangeByCommand:
+		return [][]byte{c.Key}
+	case GeoDistCommand:
+		return [][]byte{c.Key}
+	case GeoSearchCommand:
+		return [][]byte{c.Key}
+	case XLenCommand:
+		return [][]byte{c.Key}
+	case XRangeCommand:
+		return [][]byte{c.Key}
+	case PFCountCommand:
+		return c.Keys
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/CDavidSV/GopherStore/internal/util"
+)
+
+type ttlRule struct {
+	pattern string
+	ttl     time.Duration
+}
+
+// ExpirationPolicy assigns default TTLs to keys written without an explicit expiration,
+// based on glob-style key patterns. Rules are checked in order and the first match wins.
+type ExpirationPolicy struct {
+	rules []ttlRule
+}
+
+// Parses a policy from pattern/duration pairs separated by spaces, e.g. "session:* 30m feed:* 2h".
+func ParseExpirationPolicy(spec string) (*ExpirationPolicy, error) {
+	fields := strings.Fields(spec)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("expiration policy must be a list of pattern and duration pairs")
+	}
+
+	policy := &ExpirationPolicy{}
+	for i := 0; i < len(fields); i += 2 {
+		ttl, err := time.ParseDuration(fields[i+1])
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid duration %q for pattern %q", fields[i+1], fields[i])
+		}
+
+		policy.rules = append(policy.rules, ttlRule{
+			pattern: fields[i],
+			ttl:     ttl,
+		})
+	}
+
+	return policy, nil
+}
+
+// Returns the default TTL for the key, or false if no rule matches.
+func (p *ExpirationPolicy) TTLFor(key []byte) (time.Duration, bool) {
+	if p == nil {
+		return 0, false
+	}
+
+	for _, rule := range p.rules {
+		if util.MatchPattern([]byte(rule.pattern), key) {
+			return rule.ttl, true
+		}
+	}
+
+	return 0, false
+}
+
+// Formats the policy using the same syntax accepted by ParseExpirationPolicy.
+func (p *ExpirationPolicy) String() string {
+	if p == nil {
+		return ""
+	}
+
+	parts := make([]string, 0, len(p.rules)*2)
+	for _, rule := range p.rules {
+		parts = append(parts, rule.pattern, rule.ttl.String())
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Returns the TTL randomly shifted by up to the given fraction in either direction, e.g. 0.05 for ±5%,
+// so keys written together with the same TTL do not all expire at once.
+func jitterTTL(ttl time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return ttl
+	}
+
+	shift := (rand.Float64()*2 - 1) * fraction
+	return ttl + time.Duration(float64(ttl)*shift)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseExpirationPolicy(t *testing.T) {
+	policy, err := ParseExpirationPolicy("session:* 30m feed:* 2h *:tmp 10s")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		key     string
+		wantTTL time.Duration
+		wantOk  bool
+	}{
+		{key: "session:42", wantTTL: 30 * time.Minute, wantOk: true},
+		{key: "feed:home", wantTTL: 2 * time.Hour, wantOk: true},
+		{key: "upload:tmp", wantTTL: 10 * time.Second, wantOk: true},
+		{key: "user:42", wantOk: false},
+	}
+
+	for _, tt := range tests {
+		ttl, ok := policy.TTLFor([]byte(tt.key))
+		if ok != tt.wantOk || ttl != tt.wantTTL {
+			t.Errorf("TTLFor(%q) = %v, %v, want %v, %v", tt.key, ttl, ok, tt.wantTTL, tt.wantOk)
+		}
+	}
+
+	if policy.String() != "session:* 30m0s feed:* 2h0m0s *:tmp 10s" {
+		t.Errorf("Unexpected policy string %q", policy.String())
+	}
+}
+
+func TestParseExpirationPolicyInvalid(t *testing.T) {
+	invalid := []string{
+		"session:*",
+		"session:* forever",
+		"session:* -5m",
+	}
+
+	for _, spec := range invalid {
+		if _, err := ParseExpirationPolicy(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+
+	// An empty policy matches nothing
+	policy, err := ParseExpirationPolicy("")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := policy.TTLFor([]byte("key")); ok {
+		t.Error("Expected empty policy not to match")
+	}
+}
+
+func TestJitterTTL(t *testing.T) {
+	ttl := 100 * time.Second
+	if got := jitterTTL(ttl, 0); got != ttl {
+		t.Errorf("Expected no jitter, got %v", got)
+	}
+
+	seen := make(map[time.Duration]bool)
+	for range 1000 {
+		got := jitterTTL(ttl, 0.05)
+		if got < 95*time.Second || got > 105*time.Second {
+			t.Fatalf("Expected a TTL within 5%% of %v, got %v", ttl, got)
+		}
+		seen[got] = true
+	}
+
+	if len(seen) < 2 {
+		t.Error("Expected jittered TTLs to vary")
+	}
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"bytes"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("keys")
+
+// BoltBackend stores values in a BoltDB file, a single-file B+tree with ACID transactions.
+type BoltBackend struct {
+	db *bolt.DB
+}
+
+// Opens the BoltDB file at path, creating it if needed.
+func OpenBolt(path string) (*BoltBackend, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltBackend{db: db}, nil
+}
+
+func (b *BoltBackend) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		// Values are only valid during the transaction
+		if v := tx.Bucket(boltBucket).Get(key); v != nil {
+			value = bytes.Clone(v)
+		}
+		return nil
+	})
+	return value, err
+}
+
+func (b *BoltBackend) Write(batch []Write) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		for _, w := range batch {
+			var err error
+			if w.Value == nil {
+				err = bucket.Delete(w.Key)
+			} else {
+				err = bucket.Put(w.Key, w.Value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltBackend) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	backend, err := Open("bolt", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = backend.Write([]Write{
+		{Key: []byte("a"), Value: []byte("1")},
+		{Key: []byte("b"), Value: []byte("2")},
+		{Key: []byte("a"), Value: nil},
+		{Key: []byte("empty"), Value: []byte{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Values survive reopening the file
+	backend, err = Open("bolt", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	if value, err := backend.Get([]byte("a")); err != nil || value != nil {
+		t.Errorf("Expected the deleted key to be missing, got %q (%v)", value, err)
+	}
+	if value, _ := backend.Get([]byte("b")); string(value) != "2" {
+		t.Errorf("Expected b to be 2, got %q", value)
+	}
+	if value, _ := backend.Get([]byte("empty")); value == nil || len(value) != 0 {
+		t.Errorf("Expected an empty value to be stored, got %v", value)
+	}
+
+	if _, err := Open("badger", path); err == nil {
+		t.Error("Expected an error for an unknown backend")
+	}
+}
@@ -0,0 +1,34 @@
+package storage
+
+import "fmt"
+
+// Write sets or deletes a key in a backend.
+type Write struct {
+	Key   []byte
+	Value []byte // nil deletes the key
+}
+
+// Backend stores the encoded values of a key-value store on disk, letting the store only keep its hot
+// keys in memory. Values are opaque to the backend.
+// Implementations must be safe for concurrent use.
+type Backend interface {
+	Get(key []byte) ([]byte, error) // Returns the value stored at key, or nil if the key does not exist.
+	Write(batch []Write) error      // Applies the writes atomically, later writes to a key win.
+	Close() error                   // Flushes pending writes and releases the backend.
+}
+
+// Sink receives a copy of the writes made to a key-value store, like a database the store caches.
+// Every Backend is a Sink. Implementations are only called from one goroutine at a time.
+type Sink interface {
+	Write(batch []Write) error // Applies the writes, returning an error to have the whole batch retried.
+}
+
+// Opens the backend of the given kind with its data at path. Supported kinds: bolt.
+func Open(kind, path string) (Backend, error) {
+	switch kind {
+	case "bolt":
+		return OpenBolt(path)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q, expected bolt", kind)
+	}
+}
@@ -0,0 +1,92 @@
+// Package systemd integrates a service with systemd: readiness notifications for units with
+// Type=notify and listeners passed by socket activation. Both do nothing when the process was not
+// started by systemd, so programs can use them unconditionally.
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// States sent with Notify.
+const (
+	Ready    = "READY=1"    // The service finished starting up
+	Stopping = "STOPPING=1" // The service is shutting down
+)
+
+// First file descriptor passed by socket activation, after stdin, stdout and stderr.
+const listenFDsStart = 3
+
+// Sends a state to the service manager over the socket named by NOTIFY_SOCKET, like sd_notify.
+// Several states can be sent at once separated by newlines, e.g. "READY=1\nSTATUS=Serving".
+// Returns false if the process was not started by systemd with notifications enabled.
+func Notify(state string) (bool, error) {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return false, nil
+	}
+	// Names starting with @ are abstract sockets
+	if name[0] == '@' {
+		name = "\x00" + name[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Returns the listeners passed by socket activation, in the order of the ListenStream settings of
+// the socket unit, or none if the process was not socket activated. The environment variables
+// describing them are unset, so child processes do not use them too.
+func Listeners() ([]net.Listener, error) {
+	return listeners(listenFDsStart)
+}
+
+// Returns the listeners passed by socket activation, starting at file descriptor first.
+func listeners(first int) ([]net.Listener, error) {
+	// The variables are meant for this process only, not for a parent that exec'd it
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	lns := make([]net.Listener, 0, count)
+	for i := range count {
+		fd := first + i
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		// FileListener duplicates the descriptor, the original is closed either way
+		file := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("file descriptor %d (%s) is not a listening socket: %w", fd, name, err)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
@@ -0,0 +1,78 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify(Ready); sent || err != nil {
+		t.Errorf("Expected nothing to be sent without NOTIFY_SOCKET, got %v (%v)", sent, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	if sent, err := Notify(Ready); !sent || err != nil {
+		t.Fatalf("Expected the state to be sent, got %v (%v)", sent, err)
+	}
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != Ready {
+		t.Errorf("Expected %q, got %q (%v)", Ready, buf[:n], err)
+	}
+}
+
+func TestListeners(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	if lns, err := Listeners(); lns != nil || err != nil {
+		t.Errorf("Expected the sockets of another process to be ignored, got %v (%v)", lns, err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	file, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDNAMES", "gopherstore")
+	lns, err := listeners(int(file.Fd()))
+	if err != nil || len(lns) != 1 {
+		t.Fatalf("Expected the passed listener, got %v (%v)", lns, err)
+	}
+	defer lns[0].Close()
+	if lns[0].Addr().String() != ln.Addr().String() {
+		t.Errorf("Expected the listener on %s, got %s", ln.Addr(), lns[0].Addr())
+	}
+	if _, set := os.LookupEnv("LISTEN_FDS"); set {
+		t.Error("Expected the environment variables to be unset")
+	}
+
+	// Both listeners share the socket, the passed one accepts connections too
+	ln.Close()
+	conn, err := net.Dial("tcp", lns[0].Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if accepted, err := lns[0].Accept(); err != nil {
+		t.Errorf("Expected the passed listener to accept connections, got %v", err)
+	} else {
+		accepted.Close()
+	}
+}
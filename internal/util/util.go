@@ -1,6 +1,10 @@
 package util
 
-import "strconv"
+import (
+	"math"
+	"strconv"
+	"strings"
+)
 
 func ParsePositiveInt(s []byte) (int, bool) {
 	n, err := strconv.Atoi(string(s))
@@ -18,6 +22,58 @@ func ParseInt(s []byte) (int, bool) {
 	return n, true
 }
 
+// Parses a float, accepting inf, +inf and -inf but rejecting NaN.
+func ParseFloat(s []byte) (float64, bool) {
+	f, err := strconv.ParseFloat(string(s), 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+// Formats a float using the shortest representation, with infinities as inf and -inf. Integers that
+// a float holds exactly, below 2^53, are formatted without an exponent like Redis does, so scores
+// such as millisecond timestamps read back as they were written.
+func FormatFloat(f float64) []byte {
+	switch {
+	case math.IsInf(f, 1):
+		return []byte("inf")
+	case math.IsInf(f, -1):
+		return []byte("-inf")
+	case f == math.Trunc(f) && math.Abs(f) < 1<<53 && !(f == 0 && math.Signbit(f)):
+		return strconv.AppendInt(nil, int64(f), 10)
+	default:
+		return strconv.AppendFloat(nil, f, 'g', -1, 64)
+	}
+}
+
+// Parses a memory size in bytes with an optional unit like in Redis configs: k, m and g are powers
+// of 1000, kb, mb and gb powers of 1024. Units are case insensitive.
+func ParseMemorySize(s []byte) (int64, bool) {
+	str := strings.ToLower(string(s))
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10},
+		{"g", 1e9}, {"m", 1e6}, {"k", 1e3}, {"b", 1},
+	}
+
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(str, unit.suffix) {
+			str, multiplier = strings.TrimSuffix(str, unit.suffix), unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/multiplier {
+		return 0, false
+	}
+	return n * multiplier, true
+}
+
 func ReverseSlice[T any](s [][]T) {
 	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
 		s[i], s[j] = s[j], s[i]
@@ -47,3 +103,91 @@ func SliceList[T any](list []T, start, end int) []T {
 		return list[startIndex : endIndex+1]
 	}
 }
+
+// Reports whether s matches the glob-style pattern, using the same syntax as Redis:
+// * matches any sequence, ? matches a single byte, [abc], [^abc] and [a-z] match
+// character classes and \ escapes the next character.
+func MatchPattern(pattern, s []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// Collapse consecutive stars
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if MatchPattern(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+
+			pattern = pattern[1:]
+			negate := len(pattern) > 0 && pattern[0] == '^'
+			if negate {
+				pattern = pattern[1:]
+			}
+
+			matched := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				if pattern[0] == '\\' && len(pattern) > 1 {
+					pattern = pattern[1:]
+					if pattern[0] == s[0] {
+						matched = true
+					}
+					pattern = pattern[1:]
+				} else if len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']' {
+					lo, hi := pattern[0], pattern[2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					if s[0] >= lo && s[0] <= hi {
+						matched = true
+					}
+					pattern = pattern[3:]
+				} else {
+					if pattern[0] == s[0] {
+						matched = true
+					}
+					pattern = pattern[1:]
+				}
+			}
+
+			// Skip the closing bracket
+			if len(pattern) > 0 {
+				pattern = pattern[1:]
+			}
+
+			if matched == negate {
+				return false
+			}
+			s = s[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+
+	return len(s) == 0
+}
@@ -0,0 +1,107 @@
+package util
+
+import "testing"
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		input   string
+		want    bool
+	}{
+		{name: "exact match", pattern: "key", input: "key", want: true},
+		{name: "exact mismatch", pattern: "key", input: "kez", want: false},
+		{name: "star matches everything", pattern: "*", input: "anything", want: true},
+		{name: "star matches empty", pattern: "*", input: "", want: true},
+		{name: "prefix star", pattern: "session:*", input: "session:42", want: true},
+		{name: "prefix star mismatch", pattern: "session:*", input: "feed:42", want: false},
+		{name: "star in the middle", pattern: "user:*:name", input: "user:42:name", want: true},
+		{name: "star in the middle mismatch", pattern: "user:*:name", input: "user:42:age", want: false},
+		{name: "consecutive stars", pattern: "a**b", input: "axxb", want: true},
+		{name: "question mark", pattern: "h?llo", input: "hello", want: true},
+		{name: "question mark needs a byte", pattern: "h?llo", input: "hllo", want: false},
+		{name: "character class", pattern: "h[ae]llo", input: "hallo", want: true},
+		{name: "character class mismatch", pattern: "h[ae]llo", input: "hillo", want: false},
+		{name: "negated class", pattern: "h[^e]llo", input: "hallo", want: true},
+		{name: "negated class mismatch", pattern: "h[^e]llo", input: "hello", want: false},
+		{name: "range", pattern: "h[a-c]llo", input: "hbllo", want: true},
+		{name: "range mismatch", pattern: "h[a-c]llo", input: "hdllo", want: false},
+		{name: "escaped star", pattern: "a\\*b", input: "a*b", want: true},
+		{name: "escaped star is literal", pattern: "a\\*b", input: "axb", want: false},
+		{name: "trailing input", pattern: "abc", input: "abcd", want: false},
+		{name: "empty pattern", pattern: "", input: "", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MatchPattern([]byte(tt.pattern), []byte(tt.input))
+			if got != tt.want {
+				t.Errorf("MatchPattern(%q, %q) = %v, want %v", tt.pattern, tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAndFormatFloat(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		ok    bool
+	}{
+		{"1.5", "1.5", true},
+		{"100", "100", true},
+		{"-0.25", "-0.25", true},
+		{"1e21", "1e+21", true},
+		{"1700000000000", "1700000000000", true},
+		{"-1.7e12", "-1700000000000", true},
+		{"9007199254740991", "9007199254740991", true},
+		{"9007199254740992", "9.007199254740992e+15", true},
+		{"3479099956230698", "3479099956230698", true},
+		{"-0", "-0", true},
+		{"inf", "inf", true},
+		{"+inf", "inf", true},
+		{"-inf", "-inf", true},
+		{"nan", "", false},
+		{"abc", "", false},
+	}
+
+	for _, tt := range tests {
+		f, ok := ParseFloat([]byte(tt.input))
+		if ok != tt.ok {
+			t.Errorf("ParseFloat(%q) ok = %v, want %v", tt.input, ok, tt.ok)
+			continue
+		}
+		if ok && string(FormatFloat(f)) != tt.want {
+			t.Errorf("FormatFloat(ParseFloat(%q)) = %s, want %s", tt.input, FormatFloat(f), tt.want)
+		}
+	}
+}
+
+func TestParseMemorySize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+		ok    bool
+	}{
+		{"0", 0, true},
+		{"100", 100, true},
+		{"100b", 100, true},
+		{"1k", 1000, true},
+		{"1KB", 1024, true},
+		{"2mb", 2 << 20, true},
+		{"3m", 3000000, true},
+		{"1gb", 1 << 30, true},
+		{"mb", 0, false},
+		{"-1kb", 0, false},
+		{"1.5mb", 0, false},
+		{"1tb", 0, false},
+		{"9223372036854775807gb", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ParseMemorySize([]byte(tt.input))
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("ParseMemorySize(%q) = %d, %v, want %d, %v", tt.input, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -87,6 +87,17 @@ document.getElementById("getForm").addEventListener("submit", async (e) => {
     );
 });
 
+// MGET Command
+document.getElementById("mgetForm").addEventListener("submit", async (e) => {
+    e.preventDefault();
+    const keys = document
+        .getElementById("mgetKeys")
+        .value.split(",")
+        .map((k) => k.trim())
+        .filter((k) => k);
+    await sendRequest("/mget", "POST", "mgetResponse", { keys });
+});
+
 // DELETE Command
 document.getElementById("deleteForm").addEventListener("submit", async (e) => {
     e.preventDefault();
@@ -95,7 +106,12 @@ document.getElementById("deleteForm").addEventListener("submit", async (e) => {
         .value.split(",")
         .map((k) => k.trim())
         .filter((k) => k);
-    await sendRequest("/delete", "POST", "deleteResponse", { keys });
+    const type = document.getElementById("deleteType").value;
+
+    const body = { keys };
+    if (type) body.type = type;
+
+    await sendRequest("/delete", "POST", "deleteResponse", body);
 });
 
 // LPUSH Command